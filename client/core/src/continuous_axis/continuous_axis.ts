@@ -30,6 +30,7 @@ export enum AxisType {
   TIMESTAMP = 'timestamp',
   DURATION = 'duration',
   DOUBLE = 'double',
+  INTEGER = 'integer',
 }
 
 /** The set of properties used to define an axis. */
@@ -104,10 +105,10 @@ export class Axis<T> {
 /** Returns the AxisType of the axis defined in the provided properties. */
 function axisType(properties: ValueMap): AxisType {
   const t = properties.expectString(Key.AXIS_TYPE);
-  if (t !== AxisType.DOUBLE && t !== AxisType.DURATION &&
-      t !== AxisType.TIMESTAMP) {
+  if (t !== AxisType.DOUBLE && t !== AxisType.INTEGER &&
+      t !== AxisType.DURATION && t !== AxisType.TIMESTAMP) {
     throw new ConfigurationError(
-        `continuous axes must be of double, duration, or timestamp type`)
+        `continuous axes must be of double, integer, duration, or timestamp type`)
         .from(SOURCE)
         .at(Severity.ERROR);
   }
@@ -122,6 +123,14 @@ function getDoubleAxis(properties: ValueMap): Axis<number> {
       }, (a: number, b: number) => b - a);
 }
 
+/** Returns an integer axis defined in the provided properties. */
+function getIntegerAxis(properties: ValueMap): Axis<number> {
+  return Axis.fromProperties<number>(
+      properties, (itemProperties: ValueMap, key: string) => {
+        return itemProperties.expectNumber(key);
+      }, (a: number, b: number) => b - a);
+}
+
 /** Returns a Duration axis defined in the provided properties. */
 function getDurationAxis(properties: ValueMap): Axis<Duration> {
   return Axis.fromProperties<Duration>(
@@ -139,19 +148,22 @@ function getTimestampAxis(properties: ValueMap): Axis<Timestamp> {
 }
 
 /**
- * Returns a double, Duration, or Timestamp axis from the provided properties.
+ * Returns a double, integer, Duration, or Timestamp axis from the provided
+ * properties.
  */
 export function getAxis(properties: ValueMap): Axis<unknown> {
   switch (axisType(properties)) {
     case AxisType.DOUBLE:
       return getDoubleAxis(properties) as Axis<unknown>;
+    case AxisType.INTEGER:
+      return getIntegerAxis(properties) as Axis<unknown>;
     case AxisType.DURATION:
       return getDurationAxis(properties) as Axis<unknown>;
     case AxisType.TIMESTAMP:
       return getTimestampAxis(properties) as Axis<unknown>;
     default:
       throw new ConfigurationError(
-          `trace continuous axis must be of double, duration, or timestamp type`)
+          `trace continuous axis must be of double, integer, duration, or timestamp type`)
           .from(SOURCE)
           .at(Severity.ERROR);
   }
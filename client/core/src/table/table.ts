@@ -22,7 +22,7 @@ import {ConfigurationError, Severity} from '../errors/errors.js';
 import {MatchFn} from '../interactions/interactions.js';
 import {children} from '../payload/payload.js';
 import {ResponseNode} from '../protocol/response_interface.js';
-import {EmptyValue, StringValue, Value} from '../value/value.js';
+import {DoubleListValue, DoubleValue, EmptyValue, IntegerListValue, IntegerValue, StringListValue, StringValue, Value} from '../value/value.js';
 import {ValueMap} from '../value/value_map.js';
 
 const SOURCE = 'table';
@@ -30,6 +30,9 @@ const SOURCE = 'table';
 enum Keys {
   CELL = 'table_cell',
   FORMATTED_CELL = 'table_formatted_cell',
+  NODE_TYPE = 'table_node_type',
+  COLUMN = 'table_column',
+  CATEGORY_IDS = 'category_ids',
 
   ROW_HEIGHT_PX = 'table_row_height_px',
   FONT_SIZE_PX = 'table_font_size_px',
@@ -172,6 +175,72 @@ export class Row implements Highlightable {
   }
 }
 
+/** The table_node_type of a columnar table's columnar rows node. */
+const COLUMNAR_ROWS_NODE_TYPE = 1;
+
+/**
+ * Returns the cells of a columnar table column, encoded as a list Value, as
+ * individual Values.
+ */
+function columnCells(v: Value): Value[] {
+  if (v instanceof StringListValue) {
+    return v.val.map(s => new StringValue(s));
+  }
+  if (v instanceof IntegerListValue) {
+    return v.val.map(i => new IntegerValue(i));
+  }
+  if (v instanceof DoubleListValue) {
+    return v.val.map(d => new DoubleValue(d));
+  }
+  throw new ConfigurationError(`columnar table column has unsupported type`)
+      .from(SOURCE)
+      .at(Severity.ERROR);
+}
+
+/**
+ * The rows of a columnar table, in which the cells of each column are encoded
+ * as a single list, with the i'th entry belonging to the i'th row.  Row
+ * ResponseNodes are synthesized on demand.
+ */
+class ColumnarRows {
+  readonly rowCount: number = 0;
+  private readonly cellsByColumnID = new Map<string, Value[]>();
+
+  constructor(node: ResponseNode, columns: Header[]) {
+    for (const column of columns) {
+      const key = `${Keys.COLUMN}:${column.category.id}`;
+      if (!node.properties.has(key)) {
+        continue;
+      }
+      const cells = columnCells(node.properties.get(key));
+      this.rowCount = Math.max(this.rowCount, cells.length);
+      this.cellsByColumnID.set(column.category.id, cells);
+    }
+  }
+
+  slice(startIdx = 0, endIdx = this.rowCount): ResponseNode[] {
+    const ret: ResponseNode[] = [];
+    for (let idx = Math.max(startIdx, 0); idx < Math.min(endIdx, this.rowCount);
+         idx++) {
+      const cells: ResponseNode[] = [];
+      for (const [columnID, columnCells] of this.cellsByColumnID) {
+        if (idx >= columnCells.length) {
+          continue;
+        }
+        cells.push({
+          properties: new ValueMap(new Map<string, Value>([
+            [Keys.CATEGORY_IDS, new StringListValue([columnID])],
+            [Keys.CELL, columnCells[idx]],
+          ])),
+          children: [],
+        });
+      }
+      ret.push({properties: new ValueMap(), children: cells});
+    }
+    return ret;
+  }
+}
+
 /** A Table Column Header */
 export class Header {
   readonly properties: ValueMap;
@@ -204,6 +273,10 @@ export class Header {
  * specifying the display value of the cell; the latter is formatted before
  * displaying.  Each Row has zero or more Cell children, representing the cells
  * in that row.
+ *
+ * Alternatively, a columnar table has, in place of its Rows, a single node
+ * whose Keys.NODE_TYPE is COLUMNAR_ROWS_NODE_TYPE, and which holds the cells
+ * of each column as a list under `${Keys.COLUMN}:${column ID}`.
  */
 export class CanonicalTable {
   readonly renderProperties: TableRenderProperties;
@@ -211,6 +284,8 @@ export class CanonicalTable {
   private readonly initialColumnOrder = new Array<string>();
   private readonly columnsByID: ReadonlyMap<string, Header>;
   private readonly rowNodes: ResponseNode[];
+  // Defined if the table is columnar.
+  private readonly columnarRows: ColumnarRows|undefined;
   private readonly columnsList = new Array<Header>();
 
   constructor(
@@ -231,9 +306,19 @@ export class CanonicalTable {
     }
     this.columnsByID = columnsByID;
     this.rowNodes = this.node.children.slice(1);
+    if (this.rowNodes.length === 1 &&
+        this.rowNodes[0].properties.has(Keys.NODE_TYPE) &&
+        this.rowNodes[0].properties.expectNumber(Keys.NODE_TYPE) ===
+            COLUMNAR_ROWS_NODE_TYPE) {
+      this.columnarRows = new ColumnarRows(this.rowNodes[0], this.columnsList);
+      this.rowNodes = [];
+    }
   }
 
   get rowCount(): number {
+    if (this.columnarRows !== undefined) {
+      return this.columnarRows.rowCount;
+    }
     return this.rowNodes.length;
   }
 
@@ -266,7 +351,10 @@ export class CanonicalTable {
           .from(SOURCE)
           .at(Severity.ERROR);
     }
-    return this.rowNodes.slice(startIdx, endIdx)
+    const rowNodes = (this.columnarRows !== undefined) ?
+        this.columnarRows.slice(startIdx, endIdx) :
+        this.rowNodes.slice(startIdx, endIdx);
+    return rowNodes
         .map(
             (row: ResponseNode) => new Row(
                 row, this.columnsList, this.rowMatchFn, this.cellMatchFn,
@@ -12,7 +12,7 @@
 */
 
 import 'jasmine';
-import {int, str, strs, valueMap} from '../value/test_value.js';
+import {dbl, dbls, int, str, strs, valueMap} from '../value/test_value.js';
 import {node} from '../protocol/test_response.js';
 import {CanonicalTable, Cell, Header, Row} from './table.js';
 import {ResponseNode} from '../protocol/response_interface.js';
@@ -198,4 +198,36 @@ describe('columns test', () => {
           ''
         ]);
   });
+
+  it('gets columnar rows', () => {
+    const scoreColumn = node(valueMap(
+        {key: 'category_defined_id', val: str('score')},
+        {key: 'category_display_name', val: str('Score')},
+        {key: 'category_description', val: str('How good is it?')}));
+    const table = new CanonicalTable(
+        node(
+            valueMap(),
+            node(
+                // column definitions
+                valueMap(),
+                nameColumn,
+                scoreColumn,
+                ),
+            node(valueMap(
+                {key: 'table_node_type', val: int(1)},
+                {key: 'table_column:name', val: strs('vanilla', 'chocolate')},
+                {key: 'table_column:score', val: dbls(4.5, 5)},
+                ))),
+        undefined, undefined, () => {});
+    expect(table.rowCount).toEqual(2);
+    expect(table.rowSlice().map(
+               row => row.cells(table.columns()).map(cell => cell.value)))
+        .toEqual([
+          [str('vanilla'), dbl(4.5)],
+          [str('chocolate'), dbl(5)],
+        ]);
+    expect(table.rowSlice(1).map(
+               row => row.cells(table.columns('name')).map(cell => cell.value)))
+        .toEqual([[str('chocolate')]]);
+  });
 });
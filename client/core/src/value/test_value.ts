@@ -18,7 +18,7 @@
 import {Duration} from '../duration/duration.js';
 import {Timestamp} from '../timestamp/timestamp.js';
 
-import {DoubleValue, DurationValue, IntegerListValue, IntegerSetValue, IntegerValue, StringListValue, StringSetValue, StringTableBuilder, StringValue, TimestampValue, Value} from './value.js';
+import {DoubleListValue, DoubleValue, DurationValue, IntegerListValue, IntegerSetValue, IntegerValue, StringListValue, StringSetValue, StringTableBuilder, StringValue, TimestampValue, Value} from './value.js';
 import {ValueMap} from './value_map.js';
 
 /** Builds a StringValue. */
@@ -56,6 +56,11 @@ export function dbl(dbl: number): DoubleValue {
   return new DoubleValue(dbl);
 }
 
+/** Builds a DoubleListValue. */
+export function dbls(...dbls: number[]): DoubleListValue {
+  return new DoubleListValue(dbls);
+}
+
 /** Builds a DurationValue. */
 export function dur(dur: Duration): DurationValue {
   return new DurationValue(dur);
@@ -46,7 +46,9 @@ export enum ValueType {
   INTEGERS = 6,
   DOUBLE = 7,
   DURATION = 8,
-  TIMESTAMP = 9
+  TIMESTAMP = 9,
+  REFERENCE = 10,
+  DOUBLES = 11
 }
 
 /**
@@ -86,11 +88,19 @@ export function fromV(v: V, stringTable: string[]): Value|undefined {
       return new IntegerListValue(v[1] as number[]);
     case ValueType.DOUBLE:
       return new DoubleValue(v[1] as number);
+    case ValueType.DOUBLES:
+      return new DoubleListValue(v[1] as number[]);
     case ValueType.DURATION:
       return new DurationValue(new Duration(v[1] as number));
     case ValueType.TIMESTAMP:
       const parts = v[1] as number[];
       return new TimestampValue(new Timestamp(parts[0], parts[1]));
+    case ValueType.REFERENCE:
+      // A reference to another datum is decoded as the string list
+      // [series name, datum ID], where an empty series name refers to the
+      // referring datum's own series.
+      return new StringListValue(
+          (v[1] as number[]).map((idx) => stringTable[idx]));
     default:
       return undefined;
   }
@@ -1081,6 +1091,144 @@ export class DoubleValue extends ReplaySubject<Value> implements Value {
   }
 }
 
+/** A Value containing an ordered list of doubles. */
+export class DoubleListValue extends ReplaySubject<Value> implements Value {
+  constructor(private wrappedDbls: number[]) {
+    super(1);
+    this.next(this);
+  }
+
+  importFrom(sv: ExportedValue): boolean {
+    if (Array.isArray(sv)) {
+      const v: number[] = [];
+      for (const n of sv) {
+        if (typeof n !== 'number') {
+          return false;
+        }
+        v.push(n);
+      }
+      this.val = v;
+      return true;
+    }
+    return false;
+  }
+
+  exportTo(): ExportedValue {
+    return this.val;
+  }
+
+  get val(): number[] {
+    return Array.from(this.wrappedDbls);
+  }
+
+  set val(wrappedDbls: number[]) {
+    let update = false;
+    if ((wrappedDbls.length !== this.wrappedDbls.length)) {
+      update = true;
+    } else {
+      for (let idx = 0; idx < wrappedDbls.length; idx++) {
+        if (wrappedDbls[idx] !== this.wrappedDbls[idx]) {
+          update = true;
+          break;
+        }
+      }
+    }
+    if (update) {
+      this.wrappedDbls = wrappedDbls;
+      this.next(this);
+    }
+  }
+
+  override toString(): string {
+    return `[${
+        this.wrappedDbls.map(wrappedDbl => wrappedDbl.toString()).join(', ')}]`;
+  }
+
+  toV(): V|undefined {
+    return [
+      ValueType.DOUBLES,
+      Array.from(this.val),
+    ];
+  }
+
+  fold(other: Value, toggle: boolean, replace = true): boolean {
+    let otherVal: number[];
+    if (other instanceof EmptyValue) {
+      this.val = [];
+      return true;
+    } else if (other instanceof DoubleValue) {
+      otherVal = [other.val];
+    } else if (other instanceof DoubleListValue) {
+      otherVal = other.val;
+    } else {
+      return false;
+    }
+    this.val = foldList<number>(this.val, otherVal, replace, toggle);
+    return true;
+  }
+
+  comparable(other: Value): number[] {
+    if (other instanceof DoubleValue) {
+      return [other.val];
+    } else if (other instanceof DoubleListValue) {
+      return other.val;
+    } else {
+      return [];
+    }
+  }
+
+  includes(other: Value): boolean {
+    const otherVal = this.comparable(other);
+    if (this.val.length !== otherVal.length) {
+      return false;
+    }
+    for (let idx = 0; idx < this.val.length; idx++) {
+      if (otherVal[idx] !== this.val[idx]) {
+        return false;
+      }
+    }
+    return true;
+  }
+
+  prefixOf(other: Value): boolean {
+    if (other instanceof DoubleListValue) {
+      return this.val.every((element, index) => {
+        return element === other.val[index];
+      });
+    }
+    return false;
+  }
+
+  // Double lists A and B compare:
+  //   <0 if A has fewer entries than B, or
+  //   >0 if B has fewer entries than A, or
+  //   <0 if for the leftmost different position P, A[P] compares less than
+  //      B[P], or
+  //   >0 if for the leftmost different position P, A[P] compares greater than
+  //      B[P], or
+  //   0 if there is no different position.
+  compare(other: Value): number {
+    if (other instanceof EmptyValue) {
+      return this.val.length - 0;
+    }
+    const otherVal = this.comparable(other);
+    if (this.val.length !== otherVal.length) {
+      return this.val.length - otherVal.length;
+    }
+    for (let idx = 0; idx < this.val.length; idx++) {
+      const cmp = this.val[idx] - otherVal[idx];
+      if (cmp !== 0) {
+        return cmp;
+      }
+    }
+    return 0;
+  }
+
+  typeName(): string {
+    return 'double list';
+  }
+}
+
 /** A Value containing a duration. */
 export class DurationValue extends ReplaySubject<Value> implements Value {
   constructor(private wrappedDur: Duration) {
@@ -13,7 +13,7 @@
 
 import 'jasmine';
 
-import {strs, str, strSet, dbl, dur, ts, int, ints, intSet} from './test_value.js';
+import {strs, str, strSet, dbl, dbls, dur, ts, int, ints, intSet} from './test_value.js';
 import {Value, V, ValueType, fromV} from './value.js';
 import {Duration} from '../duration/duration.js';
 import {Timestamp} from '../timestamp/timestamp.js';
@@ -51,6 +51,11 @@ describe('value test', () => {
         v: [ValueType.DOUBLE, 3.14159],
         value: dbl(3.14159),
       },
+      {
+        json: '[ 11, [ 0.5, 1.25 ] ]',
+        v: [ValueType.DOUBLES, [0.5, 1.25]],
+        value: dbls(0.5, 1.25),
+      },
       {
         json: '[ 8, 150000000 ]',
         v: [ValueType.DURATION, 150000000],
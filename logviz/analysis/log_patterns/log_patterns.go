@@ -0,0 +1,206 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package logpatterns clusters log messages into templates, using a variant of
+// the Drain algorithm (He et al., 'Drain: An Online Log Parsing Approach with
+// Fixed Depth Tree', ICWS 2017).  Messages are split into whitespace-separated
+// tokens, and messages with the same number of tokens and sufficiently many
+// equal tokens share a template, in which the tokens that vary are replaced by
+// wildcards.  For example, the messages
+//
+//	connected to host-1 in 20ms
+//	connected to host-2 in 35ms
+//
+// share the template 'connected to <*> in <*>'.
+package logpatterns
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Wildcard is the template token standing in for tokens that vary among a
+// template's messages.
+const Wildcard = "<*>"
+
+const (
+	defaultDepth               = 4
+	defaultSimilarityThreshold = 0.4
+	defaultMaxChildren         = 100
+)
+
+// Options configures a Clusterer.  Zero-valued fields take default values.
+type Options struct {
+	// The depth of the parse tree, including its root and leaf levels; a
+	// message's first Depth-2 tokens route it through the tree.  Defaults to 4.
+	Depth int
+	// The minimum fraction of a template's tokens a message must equal to
+	// join that template.  Defaults to 0.4.
+	SimilarityThreshold float64
+	// The maximum number of children of each internal tree node.  Once a node
+	// is full, further tokens route through its wildcard child.  Defaults to
+	// 100.
+	MaxChildren int
+}
+
+// Template is a cluster of similar messages.
+type Template struct {
+	// A unique identifier for the template, assigned in order of creation.
+	ID int
+	// The template's tokens, with Wildcard in place of varying tokens.
+	Tokens []string
+	// The number of messages in the template.
+	Count int
+}
+
+// String returns the receiver's tokens, joined by spaces.
+func (t *Template) String() string {
+	return strings.Join(t.Tokens, " ")
+}
+
+// similarity returns the fraction of the receiver's tokens that equal the
+// corresponding provided tokens, and the number of the receiver's wildcards.
+func (t *Template) similarity(tokens []string) (float64, int) {
+	var equal, wildcards int
+	for idx, token := range t.Tokens {
+		if token == Wildcard {
+			wildcards++
+		} else if token == tokens[idx] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(t.Tokens)), wildcards
+}
+
+// merge updates the receiver's tokens to wildcard any that differ from the
+// provided tokens.
+func (t *Template) merge(tokens []string) {
+	for idx, token := range t.Tokens {
+		if token != tokens[idx] {
+			t.Tokens[idx] = Wildcard
+		}
+	}
+}
+
+// node is a node in a Clusterer's parse tree.  Internal nodes have children;
+// leaf nodes have templates.
+type node struct {
+	children  map[string]*node
+	templates []*Template
+}
+
+func newNode() *node {
+	return &node{
+		children: map[string]*node{},
+	}
+}
+
+// Clusterer clusters messages into Templates.  It is not safe for concurrent
+// use.
+type Clusterer struct {
+	opts Options
+	// The parse tree's root's children, keyed by message token count.
+	byLength  map[int]*node
+	templates []*Template
+}
+
+// New returns a new Clusterer configured by the provided Options.
+func New(opts Options) *Clusterer {
+	if opts.Depth < 3 {
+		opts.Depth = defaultDepth
+	}
+	if opts.SimilarityThreshold <= 0 {
+		opts.SimilarityThreshold = defaultSimilarityThreshold
+	}
+	if opts.MaxChildren <= 0 {
+		opts.MaxChildren = defaultMaxChildren
+	}
+	return &Clusterer{
+		opts:     opts,
+		byLength: map[int]*node{},
+	}
+}
+
+// Tokenize splits the provided message into tokens.
+func Tokenize(message string) []string {
+	return strings.Fields(message)
+}
+
+// hasDigit returns true if the provided token contains a digit.  Such tokens
+// are likely variable, so don't route messages through the parse tree.
+func hasDigit(token string) bool {
+	return strings.IndexFunc(token, unicode.IsDigit) >= 0
+}
+
+// leaf returns the leaf node to which the provided tokens route, creating it
+// and its ancestors as needed.
+func (c *Clusterer) leaf(tokens []string) *node {
+	n, ok := c.byLength[len(tokens)]
+	if !ok {
+		n = newNode()
+		c.byLength[len(tokens)] = n
+	}
+	for depth := 0; depth < c.opts.Depth-2 && depth < len(tokens); depth++ {
+		token := tokens[depth]
+		if hasDigit(token) {
+			token = Wildcard
+		}
+		child, ok := n.children[token]
+		if !ok {
+			if len(n.children) >= c.opts.MaxChildren {
+				token = Wildcard
+				child = n.children[token]
+			}
+			if child == nil {
+				child = newNode()
+				n.children[token] = child
+			}
+		}
+		n = child
+	}
+	return n
+}
+
+// Add adds the provided message to the best-matching Template, creating one
+// if none matches well enough, and returns that Template.
+func (c *Clusterer) Add(message string) *Template {
+	tokens := Tokenize(message)
+	leaf := c.leaf(tokens)
+	var best *Template
+	bestSimilarity, bestWildcards := -1.0, -1
+	for _, t := range leaf.templates {
+		similarity, wildcards := t.similarity(tokens)
+		if similarity > bestSimilarity || (similarity == bestSimilarity && wildcards > bestWildcards) {
+			best, bestSimilarity, bestWildcards = t, similarity, wildcards
+		}
+	}
+	// Empty messages all share a template.
+	if best != nil && (len(tokens) == 0 || bestSimilarity >= c.opts.SimilarityThreshold) {
+		best.merge(tokens)
+		best.Count++
+		return best
+	}
+	t := &Template{
+		ID:     len(c.templates),
+		Tokens: append([]string{}, tokens...),
+		Count:  1,
+	}
+	leaf.templates = append(leaf.templates, t)
+	c.templates = append(c.templates, t)
+	return t
+}
+
+// Templates returns the receiver's Templates, in order of creation.
+func (c *Clusterer) Templates() []*Template {
+	return c.templates
+}
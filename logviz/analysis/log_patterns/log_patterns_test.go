@@ -0,0 +1,113 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logpatterns
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClusterer(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		opts        Options
+		messages    []string
+		// The template ID of each message.
+		wantIDs       []int
+		wantTemplates []*Template
+	}{{
+		description: "varying tokens become wildcards",
+		messages: []string{
+			"connected to host-1 in 20ms",
+			"connected to host-2 in 35ms",
+			"request failed: timeout",
+			"connected to host-3 in 20ms",
+		},
+		wantIDs: []int{0, 0, 1, 0},
+		wantTemplates: []*Template{
+			{ID: 0, Tokens: []string{"connected", "to", "<*>", "in", "<*>"}, Count: 3},
+			{ID: 1, Tokens: []string{"request", "failed:", "timeout"}, Count: 1},
+		},
+	}, {
+		description: "different lengths don't share templates",
+		messages: []string{
+			"cache lookup miss",
+			"cache lookup miss for key",
+			"cache lookup hit",
+		},
+		wantIDs: []int{0, 1, 0},
+		wantTemplates: []*Template{
+			{ID: 0, Tokens: []string{"cache", "lookup", "<*>"}, Count: 2},
+			{ID: 1, Tokens: []string{"cache", "lookup", "miss", "for", "key"}, Count: 1},
+		},
+	}, {
+		description: "dissimilar messages don't share templates",
+		opts: Options{
+			SimilarityThreshold: 0.9,
+		},
+		messages: []string{
+			"user alice logged in",
+			"user bob logged out",
+		},
+		wantIDs: []int{0, 1},
+		wantTemplates: []*Template{
+			{ID: 0, Tokens: []string{"user", "alice", "logged", "in"}, Count: 1},
+			{ID: 1, Tokens: []string{"user", "bob", "logged", "out"}, Count: 1},
+		},
+	}, {
+		description: "tokens with digits route through wildcards",
+		opts: Options{
+			Depth: 3,
+		},
+		messages: []string{
+			"42 retries left",
+			"41 retries left",
+		},
+		wantIDs: []int{0, 0},
+		wantTemplates: []*Template{
+			{ID: 0, Tokens: []string{"<*>", "retries", "left"}, Count: 2},
+		},
+	}, {
+		description: "full nodes route through wildcards",
+		opts: Options{
+			Depth:       3,
+			MaxChildren: 1,
+		},
+		messages: []string{
+			"alpha started",
+			"beta started",
+			"gamma started",
+		},
+		wantIDs: []int{0, 1, 1},
+		wantTemplates: []*Template{
+			{ID: 0, Tokens: []string{"alpha", "started"}, Count: 1},
+			{ID: 1, Tokens: []string{"<*>", "started"}, Count: 2},
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			c := New(test.opts)
+			var gotIDs []int
+			for _, msg := range test.messages {
+				gotIDs = append(gotIDs, c.Add(msg).ID)
+			}
+			if diff := cmp.Diff(test.wantIDs, gotIDs); diff != "" {
+				t.Errorf("Got template IDs %v, diff (-want +got): %s", gotIDs, diff)
+			}
+			if diff := cmp.Diff(test.wantTemplates, c.Templates()); diff != "" {
+				t.Errorf("Templates() = %v, diff (-want +got): %s", c.Templates(), diff)
+			}
+		})
+	}
+}
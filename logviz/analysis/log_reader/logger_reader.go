@@ -22,6 +22,8 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -52,12 +54,23 @@ type LogParser interface {
 	ReadLogEntry() (logtrace.Entry, error)
 }
 
+// CockroachDBLogParser is a LogParser for CockroachDB "v2" logs.  Each
+// CockroachDB log file is written by a single process, whose PID is the last
+// component of the file's name, as in
+// 'cockroach.host.user.2023-01-02T03_04_05Z.001234.log'; if the log's name
+// follows this convention, its entries are attributed to that Process.
 type CockroachDBLogParser struct {
 	decoder     crdbV2Decoder
 	ac          *logtrace.AssetCache
 	logFilename string
+	// The Process writing the log, if known.
+	process *logtrace.Process
 }
 
+// crdbLogFilenameRE matches the names of CockroachDB log files, capturing the
+// PID of the process that wrote them.
+var crdbLogFilenameRE = regexp.MustCompile(`\.\d{4}-\d{2}-\d{2}T\d{2}_\d{2}_\d{2}Z\.0*(\d+)\.log$`)
+
 var _ LogParser = &CockroachDBLogParser{}
 
 // Init is part of the LogParser interface.
@@ -67,6 +80,10 @@ func (c *CockroachDBLogParser) Init(reader *bufio.Reader, logFilename string, ac
 	c.decoder = crdbV2Decoder{
 		reader: reader,
 	}
+	c.process = nil
+	if m := crdbLogFilenameRE.FindStringSubmatch(filepath.Base(logFilename)); m != nil {
+		c.process = ac.Process(m[1])
+	}
 }
 
 // ReadLogLine is part of the LogParser interface.
@@ -81,6 +98,7 @@ func (c *CockroachDBLogParser) ReadLogEntry() (logtrace.Entry, error) {
 		Log:            c.ac.Log(c.logFilename),
 		Level:          c.ac.Level(crdbSeverityWeight[crdbEntry.Severity], crdbSeverityName[crdbEntry.Severity]),
 		SourceLocation: c.ac.SourceLocation(crdbEntry.File, int(crdbEntry.Line)),
+		Process:        c.process,
 		Message:        strings.Split(crdbEntry.Message, "\n"),
 	}, nil
 }
@@ -117,6 +135,7 @@ func (tlr *TextLogReader) Entries(ac *logtrace.AssetCache) (<-chan *logtrace.Ite
 				}
 				return
 			}
+			entry.Message = ac.InternMessage(entry.Message)
 			entries <- &logtrace.Item{
 				Entry: &entry,
 			}
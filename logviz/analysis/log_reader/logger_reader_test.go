@@ -73,6 +73,30 @@ I'm glad you're here!`,
 				}).
 				WithMessage("Hello there", "I'm glad you're here!"),
 		},
+	}, {
+		description: "log with PID",
+		log:         "2023/01/02 03:04:05.000006 1234 hello.cc:7: [W] Hello there",
+		wantEntries: []*logtrace.Entry{
+			logtrace.NewEntry().
+				In(&logtrace.Log{
+					Filename: "test",
+				}).
+				At(time.Date(2023, 01, 02, 03, 04, 05, 6000, time.UTC)).
+				WithLevel(&logtrace.Level{
+					Label:  "Warning",
+					Weight: 2,
+				}).
+				From(&logtrace.SourceLocation{
+					SourceFile: &logtrace.SourceFile{
+						Filename: "hello.cc",
+					},
+					Line: 7,
+				}).
+				ByProcess(&logtrace.Process{
+					PID: "1234",
+				}).
+				WithMessage("Hello there"),
+		},
 	}} {
 		t.Run(test.description, func(t *testing.T) {
 			// Ignore empty lines; they're useful for writing the test cases
@@ -97,3 +121,46 @@ I'm glad you're here!`,
 		})
 	}
 }
+
+func TestCockroachDBLogParser(t *testing.T) {
+	log := `I230102 03:04:05.000006 14 server/server.go:123 ⋮ [n1] 1  Hello there
+W230102 03:04:06.000000 15 server/server.go:130 ⋮ [n1] 2  Slow down`
+	for _, test := range []struct {
+		description string
+		logFilename string
+		wantPID     string
+	}{{
+		description: "PID in log name",
+		logFilename: "logs/cockroach.host.user.2023-01-02T03_04_05Z.001234.log",
+		wantPID:     "1234",
+	}, {
+		description: "unconventional log name",
+		logFilename: "cockroach.log",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			reader := New(test.logFilename, ReaderCloser{Reader: bufio.NewReader(strings.NewReader(log))}, &CockroachDBLogParser{})
+			entryCh, err := reader.Entries(logtrace.NewAssetCache())
+			if err != nil {
+				t.Fatalf("Failed to fetch entries: %s", err)
+			}
+			var gotPIDs, gotMessages []string
+			for item := range entryCh {
+				if item.Err != nil {
+					t.Fatalf("Unexpected parsing error %s", item.Err)
+				}
+				pid := ""
+				if item.Entry.Process != nil {
+					pid = item.Entry.Process.PID
+				}
+				gotPIDs = append(gotPIDs, pid)
+				gotMessages = append(gotMessages, item.Entry.Message...)
+			}
+			if diff := cmp.Diff([]string{"Hello there", "Slow down"}, gotMessages); diff != "" {
+				t.Errorf("Entries() yielded messages %v, diff (-want +got) %s", gotMessages, diff)
+			}
+			if diff := cmp.Diff([]string{test.wantPID, test.wantPID}, gotPIDs); diff != "" {
+				t.Errorf("Entries() yielded PIDs %v, diff (-want +got) %s", gotPIDs, diff)
+			}
+		})
+	}
+}
@@ -31,9 +31,9 @@ type simpleLogParser struct {
 var _ LogParser = &simpleLogParser{}
 
 // NewSimpleLogParser creates a LogParser implementation that expects log line
-// formats like `Lmmdd hh:mm:ss.uuuuuu PID file:line] msg`, with times in
-// America/Los_Angeles and dates within the twelve months prior to the
-// provided 'now' timestamp.
+// formats like `yyyy/mm/dd hh:mm:ss.uuuuuu [PID ]file:line: [L] msg`, with
+// times in UTC.  If a PID is present, the entry is attributed to that
+// Process.
 func NewSimpleLogParser() *simpleLogParser {
 	// Groups:
 	//   1: Year
@@ -43,13 +43,14 @@ func NewSimpleLogParser() *simpleLogParser {
 	//   5: Minute
 	//   6: Second
 	//   7: Microsecond
-	//   8: Filename
-	//   9: Source line
-	//  10: Severity
-	//  11: Message
-	// Lmmdd hh:mm:ss.uuuuuu PID file:line] msg
+	//   8: PID, if any
+	//   9: Filename
+	//  10: Source line
+	//  11: Severity
+	//  12: Message
+	// yyyy/mm/dd hh:mm:ss.uuuuuu [PID ]file:line: [L] msg
 	return &simpleLogParser{
-		re: regexp.MustCompile(`^(\d{4})/(\d{2})/(\d{2}) (\d{2}):(\d{2}):(\d{2})\.(\d{6}) ([^:]*):(\d+): \[([IWEFP])\] (.*)$`),
+		re: regexp.MustCompile(`^(\d{4})/(\d{2})/(\d{2}) (\d{2}):(\d{2}):(\d{2})\.(\d{6}) (?:(\d+) )?([^:]*):(\d+): \[([IWEFP])\] (.*)$`),
 		tz: time.UTC,
 	}
 }
@@ -86,7 +87,7 @@ func (slp *simpleLogParser) ReadLogEntry() (logtrace.Entry, error) {
 		// remember it as the header.
 		if firstLine == nil {
 			firstLine = curMatches
-			if len(firstLine) != 12 {
+			if len(firstLine) != 13 {
 				return logtrace.Entry{}, fmt.Errorf("can't parse log line '%s'", line)
 			}
 		} else {
@@ -106,7 +107,7 @@ func (slp *simpleLogParser) ReadLogEntry() (logtrace.Entry, error) {
 	}
 
 	e := logtrace.Entry{}
-	e.WithMessage(firstLine[11])
+	e.WithMessage(firstLine[12])
 	for _, l := range continuationLines {
 		e.Message = append(e.Message, l)
 	}
@@ -150,12 +151,15 @@ func (slp *simpleLogParser) ReadLogEntry() (logtrace.Entry, error) {
 	// assume it's from last year.
 	t := time.Date(year, time.Month(month), day, hour, minute, second, usec*1000, slp.tz)
 	e.At(t)
-	lineNumber, err := strconv.Atoi(firstLine[9])
+	lineNumber, err := strconv.Atoi(firstLine[10])
 	if err != nil {
-		return logtrace.Entry{}, fmt.Errorf("failed to parse line number `%s` as int", firstLine[9])
+		return logtrace.Entry{}, fmt.Errorf("failed to parse line number `%s` as int", firstLine[10])
 	}
-	e.From(slp.ac.SourceLocation(firstLine[8], lineNumber))
-	lev, ok := defaultLevels[firstLine[10]]
+	e.From(slp.ac.SourceLocation(firstLine[9], lineNumber))
+	if firstLine[8] != "" {
+		e.ByProcess(slp.ac.Process(firstLine[8]))
+	}
+	lev, ok := defaultLevels[firstLine[11]]
 
 	if !ok {
 		return logtrace.Entry{}, fmt.Errorf("unrecognized level '%s'", firstLine[1])
@@ -0,0 +1,241 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logtrace
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+)
+
+// EncodingVersion is the version of the LogTrace serialization format written
+// by Encode.  It changes whenever the format does, so callers keying caches
+// of encoded LogTraces should include it in their keys.
+const EncodingVersion = 4
+
+// encodedSourceLocation is the serialized form of a SourceLocation; its
+// SourceFile is encoded by filename.
+type encodedSourceLocation struct {
+	Filename string
+	Line     int
+}
+
+// encodedEntry is the serialized form of an Entry.  Its assets are encoded as
+// indices into the enclosing encodedLogTrace's asset slices.
+type encodedEntry struct {
+	Time      time.Time
+	Log       int
+	Level     int
+	SourceLoc int
+	// The index of the Entry's Process, plus one; zero means no Process.
+	Process       int
+	Message       []string
+	CorrelationID string
+	Fields        map[string]string
+}
+
+// encodedLogTrace is the serialized form of a LogTrace.  Since gob does not
+// preserve pointer identity, each distinct asset is encoded exactly once and
+// referred to by index.
+type encodedLogTrace struct {
+	Version int
+	// If true, the Entries were already prepared when the LogTrace was
+	// constructed: adjusted, sorted, deduplicated, redacted, and with their
+	// correlation IDs and derived fields extracted.  Prepared Entries are not
+	// prepared again when decoded.
+	Prepared   bool
+	Logs       []string
+	Levels     []Level
+	SourceLocs []encodedSourceLocation
+	Processes  []string
+	Entries    []encodedEntry
+}
+
+// Encode writes a serialization of the receiver, including all its assets, to
+// the provided Writer.  The serialization may be read with DecodeLogTrace.
+func (lt *LogTrace) Encode(w io.Writer) error {
+	enc := &encodedLogTrace{
+		Version:  EncodingVersion,
+		Prepared: true,
+		Entries: make([]encodedEntry, 0, lt.EntryCount()),
+	}
+	logs, levels, sourceLocs, processes := newDictionary[*Log](), newDictionary[*Level](), newDictionary[*SourceLocation](), newDictionary[*Process]()
+	for pos := 0; pos < lt.EntryCount(); pos++ {
+		entry := lt.EntryAt(pos)
+		ee := encodedEntry{
+			Time:          entry.Time,
+			Log:           int(logs.index(entry.Log)),
+			Level:         int(levels.index(entry.Level)),
+			SourceLoc:     int(sourceLocs.index(entry.SourceLocation)),
+			Message:       entry.Message,
+			CorrelationID: entry.CorrelationID,
+			Fields:        entry.Fields,
+		}
+		if entry.Process != nil {
+			ee.Process = int(processes.index(entry.Process)) + 1
+		}
+		enc.Entries = append(enc.Entries, ee)
+	}
+	for _, log := range logs.values {
+		enc.Logs = append(enc.Logs, log.Filename)
+	}
+	for _, level := range levels.values {
+		enc.Levels = append(enc.Levels, *level)
+	}
+	for _, sourceLoc := range sourceLocs.values {
+		enc.SourceLocs = append(enc.SourceLocs, encodedSourceLocation{
+			Filename: sourceLoc.SourceFile.Filename,
+			Line:     sourceLoc.Line,
+		})
+	}
+	for _, process := range processes.values {
+		enc.Processes = append(enc.Processes, process.PID)
+	}
+	if err := gob.NewEncoder(w).Encode(enc); err != nil {
+		return fmt.Errorf("failed to encode log trace: %s", err)
+	}
+	return nil
+}
+
+// encodedLogReader is a LogReader producing the Entries of an
+// encodedLogTrace.
+type encodedLogReader struct {
+	enc *encodedLogTrace
+}
+
+func (elr *encodedLogReader) Entries(ac *AssetCache) (<-chan *Item, error) {
+	logs := make([]*Log, len(elr.enc.Logs))
+	for idx, filename := range elr.enc.Logs {
+		logs[idx] = ac.Log(filename)
+	}
+	levels := make([]*Level, len(elr.enc.Levels))
+	for idx, level := range elr.enc.Levels {
+		levels[idx] = ac.Level(level.Weight, level.Label)
+	}
+	sourceLocs := make([]*SourceLocation, len(elr.enc.SourceLocs))
+	for idx, sourceLoc := range elr.enc.SourceLocs {
+		sourceLocs[idx] = ac.SourceLocation(sourceLoc.Filename, sourceLoc.Line)
+	}
+	processes := make([]*Process, len(elr.enc.Processes))
+	for idx, pid := range elr.enc.Processes {
+		processes[idx] = ac.Process(pid)
+	}
+	asset := func(kind string, idx, count int) error {
+		if idx < 0 || idx >= count {
+			return fmt.Errorf("encoded log trace has out-of-range %s index %d", kind, idx)
+		}
+		return nil
+	}
+	itemCh := make(chan *Item)
+	go func() {
+		defer close(itemCh)
+		for _, ee := range elr.enc.Entries {
+			for _, err := range []error{
+				asset("log", ee.Log, len(logs)),
+				asset("level", ee.Level, len(levels)),
+				asset("source location", ee.SourceLoc, len(sourceLocs)),
+				asset("process", ee.Process, len(processes)+1),
+			} {
+				if err != nil {
+					itemCh <- &Item{Err: err}
+					return
+				}
+			}
+			entry := NewEntry().
+				In(logs[ee.Log]).
+				At(ee.Time).
+				WithLevel(levels[ee.Level]).
+				From(sourceLocs[ee.SourceLoc]).
+				WithMessage(ac.InternMessage(ee.Message)...).
+				WithCorrelationID(ee.CorrelationID)
+			for name, value := range ee.Fields {
+				entry.WithField(name, value)
+			}
+			if ee.Process > 0 {
+				entry.ByProcess(processes[ee.Process-1])
+			}
+			itemCh <- &Item{Entry: entry}
+		}
+	}()
+	return itemCh, nil
+}
+
+// DecodeLogTrace reads a LogTrace serialized by LogTrace.Encode from the
+// provided Reader, and returns a new LogTrace configured by the provided
+// Options.  Since encoded Entries are already prepared, only the Options
+// governing how Entries are stored and indexed apply.
+func DecodeLogTrace(r io.Reader, opts Options) (*LogTrace, error) {
+	enc := &encodedLogTrace{}
+	if err := gob.NewDecoder(r).Decode(enc); err != nil {
+		return nil, fmt.Errorf("failed to decode log trace: %s", err)
+	}
+	if enc.Version != EncodingVersion {
+		return nil, fmt.Errorf("can't decode log trace encoding version %d (want %d)", enc.Version, EncodingVersion)
+	}
+	if enc.Prepared {
+		opts = Options{
+			IndexBucketWidth: opts.IndexBucketWidth,
+			OnDiskThreshold:  opts.OnDiskThreshold,
+			OnDiskDir:        opts.OnDiskDir,
+			// Prepared Entries were encoded in order.
+			Presorted: true,
+		}
+	}
+	return NewLogTraceWithOptions(opts, &encodedLogReader{enc: enc})
+}
+
+// describedOptions is the form of Options described by Options.String.
+type describedOptions struct {
+	IndexBucketWidth     time.Duration
+	OnDiskThreshold      int
+	OnDiskDir            string
+	TimeZone             string
+	LogOffsets           map[string]time.Duration
+	CorrelationIDPattern string
+	CorrelationIDField   string
+	FieldPatterns        []string
+	Presorted            bool
+	Deduplicate          bool
+	Redactor             string
+}
+
+// String describes the receiver.  Options constructing different LogTraces
+// from the same LogReaders have different descriptions, so descriptions,
+// along with EncodingVersion, may key caches of encoded LogTraces.
+func (opts Options) String() string {
+	desc := describedOptions{
+		IndexBucketWidth:   opts.IndexBucketWidth,
+		OnDiskThreshold:    opts.OnDiskThreshold,
+		OnDiskDir:          opts.OnDiskDir,
+		LogOffsets:         opts.LogOffsets,
+		CorrelationIDField: opts.CorrelationIDField,
+		Presorted:          opts.Presorted,
+		Deduplicate:        opts.Deduplicate,
+	}
+	if opts.TimeZone != nil {
+		desc.TimeZone = opts.TimeZone.String()
+	}
+	if opts.CorrelationIDPattern != nil {
+		desc.CorrelationIDPattern = opts.CorrelationIDPattern.String()
+	}
+	for _, pattern := range opts.FieldPatterns {
+		desc.FieldPatterns = append(desc.FieldPatterns, pattern.String())
+	}
+	if opts.Redactor != nil {
+		desc.Redactor = opts.Redactor.String()
+	}
+	// Maps are printed in key order, so equal Options print identically.
+	return fmt.Sprintf("%#v", desc)
+}
@@ -0,0 +1,435 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logtrace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// entryStore provides access to a LogTrace's time-ordered Entries by
+// position.
+type entryStore interface {
+	// Returns the number of Entries in the store.
+	len() int
+	// Returns the Entry at the specified position.
+	at(pos int) *Entry
+	// Returns the time of the Entry at the specified position.  This may be
+	// much cheaper than at(pos).Time.
+	timeAt(pos int) time.Time
+	// Releases any resources held by the store.
+	close() error
+}
+
+// memoryStore is an entryStore holding its Entries in memory.
+type memoryStore []*Entry
+
+func (ms memoryStore) len() int {
+	return len(ms)
+}
+
+func (ms memoryStore) at(pos int) *Entry {
+	return ms[pos]
+}
+
+func (ms memoryStore) timeAt(pos int) time.Time {
+	return ms[pos].Time
+}
+
+func (ms memoryStore) close() error {
+	return nil
+}
+
+// The on-disk columnar format begins with a header comprising a magic string,
+// the entry count N, and the length L of the message data.  Following the
+// header are, in order:
+//   - L bytes of message data.  Each Entry's message is a uvarint line count
+//     followed by each line as a uvarint length and its bytes, then a uvarint
+//     count of its derived fields followed by each field, in increasing name
+//     order, as a uvarint field name index and its value as a uvarint length
+//     and its bytes;
+//   - N int64 Unix-nanosecond timestamps;
+//   - N uint32 Log indices;
+//   - N uint32 Level indices;
+//   - N uint32 SourceLocation indices;
+//   - N uint32 Process indices, offset by one so that 0 means no Process;
+//   - N uint32 correlation ID indices, offset by one so that 0 means no
+//     correlation ID;
+//   - N uint64 offsets into the message data, one per Entry.
+//
+// Messages are written as Entries are read, so the message data is in read
+// order, while all other columns are in timestamp order.  All integers are
+// little-endian.  The Logs, Levels, SourceLocations, and Processes themselves
+// are few, and are kept in memory, as are the distinct correlation IDs and
+// derived field names.
+const columnarMagic = "LTCOL004"
+
+const columnarHeaderSize = len(columnarMagic) + 8 + 8
+
+// columnarStore is an entryStore backed by a memory-mapped file in the
+// on-disk columnar format.  Entries are materialized on each access, so
+// repeated accesses to the same position yield distinct, but equal, Entries.
+type columnarStore struct {
+	data           []byte
+	n              int
+	loc            *time.Location
+	logs           []*Log
+	levels         []*Level
+	sourceLocs     []*SourceLocation
+	processes      []*Process
+	correlationIDs []string
+	fieldNames     []string
+	// Offsets of the columns within data.
+	msgsOff, timesOff, logsOff, levelsOff, sourceLocsOff, processesOff, correlationIDsOff, msgOffsetsOff int
+}
+
+// dictionary assigns dense indices to distinct values.
+type dictionary[T comparable] struct {
+	indices map[T]uint32
+	values  []T
+}
+
+func newDictionary[T comparable]() *dictionary[T] {
+	return &dictionary[T]{
+		indices: map[T]uint32{},
+	}
+}
+
+func (d *dictionary[T]) index(val T) uint32 {
+	idx, ok := d.indices[val]
+	if !ok {
+		idx = uint32(len(d.values))
+		d.indices[val] = idx
+		d.values = append(d.values, val)
+	}
+	return idx
+}
+
+// columnarRecord is the fixed-size part of an Entry added to a
+// columnarWriter, whose message has already been written.
+type columnarRecord struct {
+	time                                          int64
+	log, level, sourceLoc, process, correlationID uint32
+	msgOff, msgLen                                uint64
+}
+
+// columnarWriter writes Entries, in any order, to a new file in the on-disk
+// columnar format.  Each Entry's message is written as the Entry is added, so
+// that only a small fixed-size record per Entry is held in memory until the
+// file is finished.
+type columnarWriter struct {
+	file           *os.File
+	w              *bufio.Writer
+	logs           *dictionary[*Log]
+	levels         *dictionary[*Level]
+	sourceLocs     *dictionary[*SourceLocation]
+	processes      *dictionary[*Process]
+	correlationIDs *dictionary[string]
+	fieldNames     *dictionary[string]
+	records        []columnarRecord
+	// The length of the message data written so far.
+	msgLen uint64
+	// The earliest timestamp added so far, and its location.
+	minTime int64
+	loc     *time.Location
+	// Scratch space for encoding messages.
+	buf []byte
+}
+
+// newColumnarWriter returns a new columnarWriter writing to a new file in the
+// specified directory.
+func newColumnarWriter(dir string) (*columnarWriter, error) {
+	file, err := os.CreateTemp(dir, "logtrace-*.col")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create on-disk store: %s", err)
+	}
+	cw := &columnarWriter{
+		file:           file,
+		w:              bufio.NewWriter(file),
+		logs:           newDictionary[*Log](),
+		levels:         newDictionary[*Level](),
+		sourceLocs:     newDictionary[*SourceLocation](),
+		processes:      newDictionary[*Process](),
+		correlationIDs: newDictionary[string](),
+		fieldNames:     newDictionary[string](),
+	}
+	// Reserve space for the header, which is written once the entry count and
+	// message length are known.
+	if _, err := cw.w.Write(make([]byte, columnarHeaderSize)); err != nil {
+		cw.abort()
+		return nil, fmt.Errorf("failed to write on-disk store: %s", err)
+	}
+	return cw, nil
+}
+
+// add writes the provided Entry's message to the receiver's file, and records
+// the rest of the Entry.
+func (cw *columnarWriter) add(entry *Entry) error {
+	buf := binary.AppendUvarint(cw.buf[:0], uint64(len(entry.Message)))
+	for _, line := range entry.Message {
+		buf = binary.AppendUvarint(buf, uint64(len(line)))
+		buf = append(buf, line...)
+	}
+	names := make([]string, 0, len(entry.Fields))
+	for name := range entry.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf = binary.AppendUvarint(buf, uint64(len(names)))
+	for _, name := range names {
+		buf = binary.AppendUvarint(buf, uint64(cw.fieldNames.index(name)))
+		buf = binary.AppendUvarint(buf, uint64(len(entry.Fields[name])))
+		buf = append(buf, entry.Fields[name]...)
+	}
+	cw.buf = buf
+	if _, err := cw.w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write on-disk store: %s", err)
+	}
+	rec := columnarRecord{
+		time:      entry.Time.UnixNano(),
+		log:       cw.logs.index(entry.Log),
+		level:     cw.levels.index(entry.Level),
+		sourceLoc: cw.sourceLocs.index(entry.SourceLocation),
+		msgOff:    cw.msgLen,
+		msgLen:    uint64(len(buf)),
+	}
+	if entry.Process != nil {
+		rec.process = cw.processes.index(entry.Process) + 1
+	}
+	if entry.CorrelationID != "" {
+		rec.correlationID = cw.correlationIDs.index(entry.CorrelationID) + 1
+	}
+	if cw.loc == nil || rec.time < cw.minTime {
+		cw.minTime, cw.loc = rec.time, entry.Time.Location()
+	}
+	cw.records = append(cw.records, rec)
+	cw.msgLen += rec.msgLen
+	return nil
+}
+
+// abort closes and removes the receiver's file.  The receiver must not be used
+// after it is aborted.
+func (cw *columnarWriter) abort() {
+	cw.file.Close()
+	os.Remove(cw.file.Name())
+}
+
+// duplicates returns true if the Entries of the provided records are
+// identical except perhaps in their Log.  Since fields are written in name
+// order, identical messages and fields are written identically, so messages
+// are compared by their written bytes.  The receiver must be flushed.
+func (cw *columnarWriter) duplicates(a, b *columnarRecord) (bool, error) {
+	if a.time != b.time || a.level != b.level || a.sourceLoc != b.sourceLoc ||
+		a.process != b.process || a.correlationID != b.correlationID || a.msgLen != b.msgLen {
+		return false, nil
+	}
+	aMsg, bMsg := make([]byte, a.msgLen), make([]byte, b.msgLen)
+	if _, err := cw.file.ReadAt(aMsg, int64(columnarHeaderSize)+int64(a.msgOff)); err != nil {
+		return false, err
+	}
+	if _, err := cw.file.ReadAt(bMsg, int64(columnarHeaderSize)+int64(b.msgOff)); err != nil {
+		return false, err
+	}
+	return bytes.Equal(aMsg, bMsg), nil
+}
+
+// deduplicate returns the provided records, which must be in timestamp order,
+// without any record whose Entry duplicates an earlier one's.  The provided
+// slice is reused.  The receiver must be flushed.
+func (cw *columnarWriter) deduplicate(records []columnarRecord) ([]columnarRecord, error) {
+	ret := records[:0]
+	// The index in ret of the first record sharing the current timestamp.
+	runStart := 0
+	for _, rec := range records {
+		if runStart < len(ret) && ret[runStart].time != rec.time {
+			runStart = len(ret)
+		}
+		duplicate := false
+		for idx := runStart; idx < len(ret) && !duplicate; idx++ {
+			var err error
+			if duplicate, err = cw.duplicates(&ret[idx], &rec); err != nil {
+				return nil, err
+			}
+		}
+		if !duplicate {
+			ret = append(ret, rec)
+		}
+	}
+	return ret, nil
+}
+
+// finish orders the added Entries by timestamp, unless they were added in
+// timestamp order, drops duplicate Entries if requested, and completes the
+// receiver's file.  It then maps that file for reading and returns a
+// columnarStore backed by it.  The file is removed once mapped, or on
+// failure.  The receiver must not be used after it is finished.
+func (cw *columnarWriter) finish(sorted, deduplicate bool) (cs *columnarStore, err error) {
+	defer func() {
+		cw.file.Close()
+		if rmErr := os.Remove(cw.file.Name()); rmErr != nil && err == nil {
+			cs.close()
+			cs, err = nil, fmt.Errorf("failed to remove on-disk store file: %s", rmErr)
+		}
+	}()
+	if len(cw.records) == 0 {
+		return nil, fmt.Errorf("can't create an on-disk store with no Entries")
+	}
+	if err := cw.w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write on-disk store: %s", err)
+	}
+	records := cw.records
+	if !sorted {
+		sort.SliceStable(records, func(x, y int) bool {
+			return records[x].time < records[y].time
+		})
+	}
+	if deduplicate {
+		if records, err = cw.deduplicate(records); err != nil {
+			return nil, fmt.Errorf("failed to read on-disk store: %s", err)
+		}
+	}
+	n := len(records)
+	write := func(data any) {
+		if err == nil {
+			err = binary.Write(cw.w, binary.LittleEndian, data)
+		}
+	}
+	col := make([]int64, n)
+	for idx := range records {
+		col[idx] = records[idx].time
+	}
+	write(col)
+	idxCol := make([]uint32, n)
+	for _, indexOf := range []func(rec *columnarRecord) uint32{
+		func(rec *columnarRecord) uint32 { return rec.log },
+		func(rec *columnarRecord) uint32 { return rec.level },
+		func(rec *columnarRecord) uint32 { return rec.sourceLoc },
+		func(rec *columnarRecord) uint32 { return rec.process },
+		func(rec *columnarRecord) uint32 { return rec.correlationID },
+	} {
+		for idx := range records {
+			idxCol[idx] = indexOf(&records[idx])
+		}
+		write(idxCol)
+	}
+	msgOffsets := make([]uint64, n)
+	for idx := range records {
+		msgOffsets[idx] = records[idx].msgOff
+	}
+	write(msgOffsets)
+	if err == nil {
+		err = cw.w.Flush()
+	}
+	if err == nil {
+		header := append([]byte(columnarMagic), make([]byte, 16)...)
+		binary.LittleEndian.PutUint64(header[len(columnarMagic):], uint64(n))
+		binary.LittleEndian.PutUint64(header[len(columnarMagic)+8:], cw.msgLen)
+		_, err = cw.file.WriteAt(header, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write on-disk store: %s", err)
+	}
+	data, err := mapFile(cw.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map on-disk store: %s", err)
+	}
+	cs = &columnarStore{
+		data:           data,
+		n:              n,
+		loc:            cw.loc,
+		logs:           cw.logs.values,
+		levels:         cw.levels.values,
+		sourceLocs:     cw.sourceLocs.values,
+		processes:      cw.processes.values,
+		correlationIDs: cw.correlationIDs.values,
+		fieldNames:     cw.fieldNames.values,
+	}
+	cs.msgsOff = columnarHeaderSize
+	cs.timesOff = cs.msgsOff + int(cw.msgLen)
+	cs.logsOff = cs.timesOff + 8*n
+	cs.levelsOff = cs.logsOff + 4*n
+	cs.sourceLocsOff = cs.levelsOff + 4*n
+	cs.processesOff = cs.sourceLocsOff + 4*n
+	cs.correlationIDsOff = cs.processesOff + 4*n
+	cs.msgOffsetsOff = cs.correlationIDsOff + 4*n
+	// Unmap the store once it is no longer reachable.
+	runtime.SetFinalizer(cs, func(cs *columnarStore) {
+		cs.close()
+	})
+	return cs, nil
+}
+
+func (cs *columnarStore) len() int {
+	return cs.n
+}
+
+func (cs *columnarStore) uint32At(colOff, pos int) uint32 {
+	return binary.LittleEndian.Uint32(cs.data[colOff+4*pos:])
+}
+
+func (cs *columnarStore) timeAt(pos int) time.Time {
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(cs.data[cs.timesOff+8*pos:]))).In(cs.loc)
+}
+
+func (cs *columnarStore) at(pos int) *Entry {
+	entry := &Entry{
+		Time:           cs.timeAt(pos),
+		Log:            cs.logs[cs.uint32At(cs.logsOff, pos)],
+		Level:          cs.levels[cs.uint32At(cs.levelsOff, pos)],
+		SourceLocation: cs.sourceLocs[cs.uint32At(cs.sourceLocsOff, pos)],
+	}
+	if procIdx := cs.uint32At(cs.processesOff, pos); procIdx > 0 {
+		entry.Process = cs.processes[procIdx-1]
+	}
+	if idIdx := cs.uint32At(cs.correlationIDsOff, pos); idIdx > 0 {
+		entry.CorrelationID = cs.correlationIDs[idIdx-1]
+	}
+	msgOff := cs.msgsOff + int(binary.LittleEndian.Uint64(cs.data[cs.msgOffsetsOff+8*pos:]))
+	lines, n := binary.Uvarint(cs.data[msgOff:])
+	msgOff += n
+	entry.Message = make([]string, lines)
+	for idx := range entry.Message {
+		lineLen, n := binary.Uvarint(cs.data[msgOff:])
+		msgOff += n
+		entry.Message[idx] = string(cs.data[msgOff : msgOff+int(lineLen)])
+		msgOff += int(lineLen)
+	}
+	fields, n := binary.Uvarint(cs.data[msgOff:])
+	msgOff += n
+	for ; fields > 0; fields-- {
+		nameIdx, n := binary.Uvarint(cs.data[msgOff:])
+		msgOff += n
+		valueLen, n := binary.Uvarint(cs.data[msgOff:])
+		msgOff += n
+		entry.WithField(cs.fieldNames[nameIdx], string(cs.data[msgOff:msgOff+int(valueLen)]))
+		msgOff += int(valueLen)
+	}
+	return entry
+}
+
+func (cs *columnarStore) close() error {
+	if cs == nil || cs.data == nil {
+		return nil
+	}
+	data := cs.data
+	cs.data = nil
+	runtime.SetFinalizer(cs, nil)
+	return unmapFile(data)
+}
@@ -26,8 +26,15 @@ type filter struct {
 	levels      map[*Level]struct{}
 	sourceLocs  map[*SourceLocation]struct{}
 	sourceFiles map[*SourceFile]struct{}
-	startTime   time.Time
-	endTime     time.Time
+	processes   map[*Process]struct{}
+	// Correlation IDs are filtered by value.
+	correlationIDs map[string]struct{}
+	// Entries must have each of these derived fields.
+	fields    map[string]struct{}
+	startTime time.Time
+	endTime   time.Time
+	// If greater than 1, only every stride'th candidate position is visited.
+	stride int
 }
 
 // WithLogs returns a Filter filtering in the specified Logs.
@@ -72,6 +79,62 @@ func WithSourceFiles(sfs ...*SourceFile) Filter {
 	}
 }
 
+// WithProcesses returns a Filter filtering in the specified Processes.
+// Entries with no known Process are filtered out by a non-empty process
+// filter.
+func WithProcesses(processes ...*Process) Filter {
+	return func(f *filter) error {
+		for _, process := range processes {
+			f.processes[process] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithCorrelationIDs returns a Filter filtering in Entries with the specified
+// correlation IDs.
+func WithCorrelationIDs(ids ...string) Filter {
+	return func(f *filter) error {
+		for _, id := range ids {
+			f.correlationIDs[id] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithFields returns a Filter filtering in Entries having all the specified
+// derived fields, with any values.
+func WithFields(names ...string) Filter {
+	return func(f *filter) error {
+		for _, name := range names {
+			f.fields[name] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithSampleStride returns a Filter sampling Entries by position: of the
+// Entries in the filtered-in time range (or, where the LogTrace's index can
+// narrow the search, of the index's candidates), only every stride'th is
+// considered, and then only if it is otherwise filtered in.  Sampled
+// traversals thus cost about 1/stride of full ones, and each visited Entry
+// stands for about stride Entries.  Strides below 2 sample every Entry.
+func WithSampleStride(stride int) Filter {
+	return func(f *filter) error {
+		f.stride = stride
+		return nil
+	}
+}
+
+// step returns the increment between the candidate positions the receiver
+// considers.
+func (f *filter) step() int {
+	if f.stride > 1 {
+		return f.stride
+	}
+	return 1
+}
+
 // WithStartTime returns a Filter filtering in from the specified start time.
 func WithStartTime(time time.Time) Filter {
 	return func(f *filter) error {
@@ -103,12 +166,15 @@ func ConcatenateFilters(filters ...Filter) Filter {
 func (lt *LogTrace) filter(filters ...Filter) (*filter, error) {
 	start, end := lt.TimeRange()
 	ret := &filter{
-		logs:        map[*Log]struct{}{},
-		levels:      map[*Level]struct{}{},
-		sourceLocs:  map[*SourceLocation]struct{}{},
-		sourceFiles: map[*SourceFile]struct{}{},
-		startTime:   start,
-		endTime:     end,
+		logs:           map[*Log]struct{}{},
+		levels:         map[*Level]struct{}{},
+		sourceLocs:     map[*SourceLocation]struct{}{},
+		sourceFiles:    map[*SourceFile]struct{}{},
+		processes:      map[*Process]struct{}{},
+		correlationIDs: map[string]struct{}{},
+		fields:         map[string]struct{}{},
+		startTime:      start,
+		endTime:        end,
 	}
 	for _, filter := range filters {
 		if err := filter(ret); err != nil {
@@ -124,28 +190,35 @@ func (lt *LogTrace) filter(filters ...Filter) (*filter, error) {
 	return ret, nil
 }
 
-// filterRangeTemporal returns the interval within the provided Entry slice,
-// which must be time-ordered increasing, filtered in by the receiving filter.
-func (f *filter) filterRangeTemporal(entries []*Entry) []*Entry {
-	if len(entries) == 0 {
-		return entries
-	}
-	startTime := entries[0].Time
-	endTime := entries[len(entries)-1].Time
-	startIdx := 0
-	if f.startTime.After(startTime) {
-		startIdx = sort.Search(len(entries), func(pos int) bool {
-			return !entries[pos].Time.Before(f.startTime)
-		})
-	}
-	endIdx := len(entries)
-	if f.endTime.Before(endTime) {
-		endIdx = sort.Search(len(entries), func(pos int) bool {
-			return entries[pos].Time.After(f.endTime)
-		})
+// filterRangeTemporal returns the [start, end) interval of positions within
+// the provided entryStore filtered in by the receiving filter.
+func (f *filter) filterRangeTemporal(store entryStore) (int, int) {
+	startIdx := sort.Search(store.len(), func(pos int) bool {
+		return !store.timeAt(pos).Before(f.startTime)
+	})
+	endIdx := sort.Search(store.len(), func(pos int) bool {
+		return store.timeAt(pos).After(f.endTime)
+	})
+	if endIdx < startIdx {
+		return startIdx, startIdx
 	}
+	return startIdx, endIdx
+}
 
-	return entries[startIdx:endIdx]
+// filterPositionsTemporal returns the interval within the provided positions,
+// which must be increasing positions within the provided entryStore, filtered
+// in by the receiving filter.
+func (f *filter) filterPositionsTemporal(store entryStore, positions []int) []int {
+	startIdx := sort.Search(len(positions), func(idx int) bool {
+		return !store.timeAt(positions[idx]).Before(f.startTime)
+	})
+	endIdx := sort.Search(len(positions), func(idx int) bool {
+		return store.timeAt(positions[idx]).After(f.endTime)
+	})
+	if endIdx < startIdx {
+		return nil
+	}
+	return positions[startIdx:endIdx]
 }
 
 // entryFilteredIn determines if the provided Entry is filtered in per the
@@ -176,5 +249,20 @@ func (f *filter) entryFilteredIn(e *Entry) bool {
 			return false
 		}
 	}
+	if len(f.processes) > 0 {
+		if _, ok := f.processes[e.Process]; !ok {
+			return false
+		}
+	}
+	if len(f.correlationIDs) > 0 {
+		if _, ok := f.correlationIDs[e.CorrelationID]; !ok {
+			return false
+		}
+	}
+	for name := range f.fields {
+		if _, ok := e.Fields[name]; !ok {
+			return false
+		}
+	}
 	return true
 }
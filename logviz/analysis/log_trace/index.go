@@ -0,0 +1,199 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logtrace
+
+import (
+	"sort"
+	"time"
+)
+
+// TimeBuckets holds per-Level entry counts over consecutive, fixed-width time
+// buckets spanning a LogTrace.
+type TimeBuckets struct {
+	// The start of the first bucket.  This is the LogTrace's start time,
+	// truncated to the bucket width.
+	Start time.Time
+	// The width of each bucket.
+	Width time.Duration
+	// For each Level, the number of entries at that Level in each bucket.
+	CountsByLevel map[*Level][]int
+}
+
+// The standard widths, finest first, at which indexed LogTraces count entries
+// in addition to their own bucket width, so that queries over wide time ranges
+// may aggregate a few coarse buckets rather than very many fine ones.
+var summaryBucketWidths = []time.Duration{time.Second, time.Minute, time.Hour}
+
+// Bucket widths yielding more than this many buckets per Level over a LogTrace
+// are omitted, bounding the memory used by its index.
+const maxTimeBuckets = 1 << 20
+
+// timeBucketWidths returns the widths, finest first, at which a LogTrace
+// indexed with the specified bucket width counts entries: that width, and
+// every coarser standard summary width, omitting any yielding too many
+// buckets.
+func timeBucketWidths(lt *LogTrace, bucketWidth time.Duration) []time.Duration {
+	startTs, endTs := lt.TimeRange()
+	widths := []time.Duration{bucketWidth}
+	for _, width := range summaryBucketWidths {
+		if width > bucketWidth {
+			widths = append(widths, width)
+		}
+	}
+	var ret []time.Duration
+	for _, width := range widths {
+		if endTs.Sub(startTs)/width < maxTimeBuckets {
+			ret = append(ret, width)
+		}
+	}
+	return ret
+}
+
+// newTimeBuckets returns a new TimeBuckets of each of the specified widths
+// over the provided LogTrace, in the same order, computed in a single pass
+// over its Entries.
+func newTimeBuckets(lt *LogTrace, widths ...time.Duration) []*TimeBuckets {
+	startTs, endTs := lt.TimeRange()
+	ret := make([]*TimeBuckets, len(widths))
+	buckets := make([]int, len(widths))
+	for idx, width := range widths {
+		ret[idx] = &TimeBuckets{
+			Start:         startTs.Truncate(width),
+			Width:         width,
+			CountsByLevel: map[*Level][]int{},
+		}
+		buckets[idx] = int(endTs.Sub(ret[idx].Start)/width) + 1
+	}
+	for pos := 0; pos < lt.EntryCount(); pos++ {
+		entry := lt.EntryAt(pos)
+		for idx, tb := range ret {
+			counts, ok := tb.CountsByLevel[entry.Level]
+			if !ok {
+				counts = make([]int, buckets[idx])
+				tb.CountsByLevel[entry.Level] = counts
+			}
+			counts[int(entry.Time.Sub(tb.Start)/tb.Width)]++
+		}
+	}
+	return ret
+}
+
+// ForEachBucket invokes the provided callback for each Level and bucket with
+// a nonzero count whose start lies within the specified time range.
+func (tb *TimeBuckets) ForEachBucket(startTs, endTs time.Time, fn func(level *Level, bucketStart time.Time, count int)) {
+	// The first and last bucket indices whose starts are in range.
+	first, last := 0, -1
+	if startTs.After(tb.Start) {
+		first = int((startTs.Sub(tb.Start) + tb.Width - 1) / tb.Width)
+	}
+	if !endTs.Before(tb.Start) {
+		last = int(endTs.Sub(tb.Start) / tb.Width)
+	}
+	for level, counts := range tb.CountsByLevel {
+		for idx := first; idx <= last && idx < len(counts); idx++ {
+			if counts[idx] == 0 {
+				continue
+			}
+			fn(level, tb.Start.Add(time.Duration(idx)*tb.Width), counts[idx])
+		}
+	}
+}
+
+// index holds, for each distinct Level, SourceFile, Process, and correlation
+// ID in a LogTrace, the increasing positions within the LogTrace of the
+// entries with that granularity.
+type index struct {
+	byLevel         map[*Level][]int
+	bySourceFile    map[*SourceFile][]int
+	byProcess       map[*Process][]int
+	byCorrelationID map[string][]int
+	// Per-Level entry counts in time buckets of several widths, finest first.
+	timeBuckets []*TimeBuckets
+}
+
+func newIndex(lt *LogTrace, bucketWidth time.Duration) *index {
+	idx := &index{
+		byLevel:         map[*Level][]int{},
+		bySourceFile:    map[*SourceFile][]int{},
+		byProcess:       map[*Process][]int{},
+		byCorrelationID: map[string][]int{},
+		timeBuckets:     newTimeBuckets(lt, timeBucketWidths(lt, bucketWidth)...),
+	}
+	for pos := 0; pos < lt.EntryCount(); pos++ {
+		entry := lt.EntryAt(pos)
+		idx.byLevel[entry.Level] = append(idx.byLevel[entry.Level], pos)
+		sf := entry.SourceLocation.SourceFile
+		idx.bySourceFile[sf] = append(idx.bySourceFile[sf], pos)
+		if entry.Process != nil {
+			idx.byProcess[entry.Process] = append(idx.byProcess[entry.Process], pos)
+		}
+		if entry.CorrelationID != "" {
+			idx.byCorrelationID[entry.CorrelationID] = append(idx.byCorrelationID[entry.CorrelationID], pos)
+		}
+	}
+	return idx
+}
+
+// positions returns the increasing positions of all indexed entries matching
+// any of the provided keys.
+func positions[T comparable](positionsByKey map[T][]int, keys map[T]struct{}) []int {
+	var ret []int
+	for key := range keys {
+		ret = append(ret, positionsByKey[key]...)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// candidates returns the positions of a superset of the entries satisfying
+// the provided filter, using whichever indexed filter dimension is most
+// selective.  If no indexed dimension is filtered, returns false.
+func (idx *index) candidates(f *filter) ([]int, bool) {
+	var ret []int
+	found := false
+	consider := func(count int, get func() []int) {
+		if !found || count < len(ret) {
+			ret, found = get(), true
+		}
+	}
+	if len(f.levels) > 0 {
+		consider(countPositions(idx.byLevel, f.levels), func() []int {
+			return positions(idx.byLevel, f.levels)
+		})
+	}
+	if len(f.sourceFiles) > 0 {
+		consider(countPositions(idx.bySourceFile, f.sourceFiles), func() []int {
+			return positions(idx.bySourceFile, f.sourceFiles)
+		})
+	}
+	if len(f.processes) > 0 {
+		consider(countPositions(idx.byProcess, f.processes), func() []int {
+			return positions(idx.byProcess, f.processes)
+		})
+	}
+	if len(f.correlationIDs) > 0 {
+		consider(countPositions(idx.byCorrelationID, f.correlationIDs), func() []int {
+			return positions(idx.byCorrelationID, f.correlationIDs)
+		})
+	}
+	return ret, found
+}
+
+func countPositions[T comparable](positionsByKey map[T][]int, keys map[T]struct{}) int {
+	var ret int
+	for key := range keys {
+		ret += len(positionsByKey[key])
+	}
+	return ret
+}
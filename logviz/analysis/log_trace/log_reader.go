@@ -17,7 +17,10 @@ package logtrace
 
 import (
 	"fmt"
+	"hash/maphash"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -89,6 +92,27 @@ func (sf *SourceFile) String() string {
 	return sf.Identifier()
 }
 
+// Process describes the process that emitted an Entry.
+type Process struct {
+	// The process's identifier, such as its PID.  Must be unique among
+	// Processes.
+	PID string
+}
+
+// Identifier returns a unique name of the receiving Process.
+func (p *Process) Identifier() string {
+	return p.PID
+}
+
+// DisplayName returns a display name for the receiving Process.
+func (p *Process) DisplayName() string {
+	return p.PID
+}
+
+func (p *Process) String() string {
+	return p.Identifier()
+}
+
 // SourceLocation describes the source location for an Entry.
 type SourceLocation struct {
 	SourceFile *SourceFile
@@ -121,7 +145,16 @@ type Entry struct {
 	Level *Level
 	// an Entry's SourceFile is referenced in its SourceLocation.
 	SourceLocation *SourceLocation
-	Message        []string
+	// The process that emitted this Entry.  May be nil if the log does not
+	// record it.
+	Process *Process
+	Message []string
+	// The correlation ID, such as a session or trace ID, relating this Entry
+	// to others across logs, files, and processes.  Empty if none is known.
+	CorrelationID string
+	// Derived fields, such as latencies or RPC method names, extracted from
+	// the message, by field name.  Nil if the Entry has none.
+	Fields map[string]string
 }
 
 // NewEntry returns a new, empty Entry.
@@ -153,6 +186,28 @@ func (e *Entry) From(sourceLoc *SourceLocation) *Entry {
 	return e
 }
 
+// ByProcess amends the receiver's Process field with the specified Process.
+func (e *Entry) ByProcess(p *Process) *Entry {
+	e.Process = p
+	return e
+}
+
+// WithCorrelationID amends the receiver's CorrelationID field with the
+// specified ID.
+func (e *Entry) WithCorrelationID(id string) *Entry {
+	e.CorrelationID = id
+	return e
+}
+
+// WithField amends the receiver's Fields with the specified field.
+func (e *Entry) WithField(name, value string) *Entry {
+	if e.Fields == nil {
+		e.Fields = map[string]string{}
+	}
+	e.Fields[name] = value
+	return e
+}
+
 // WithMessage amends the receiver's Message field with the specified strings.
 func (e *Entry) WithMessage(msgs ...string) *Entry {
 	e.Message = msgs
@@ -161,12 +216,20 @@ func (e *Entry) WithMessage(msgs ...string) *Entry {
 
 // AssetCache is a cache of all Entry assets (Log, SourceLocation, Process, and
 // Level) encountered while handling all logs in a trace, which permits an
-// identity between identical assets from different logs.
+// identity between identical assets from different logs.  It also interns
+// Entry messages, so that repeated messages share storage.  It is safe for
+// concurrent use by several LogReaders.
 type AssetCache struct {
+	mu          sync.Mutex
 	logs        map[string]*Log
 	sourceFiles map[string]*SourceFile
 	sourceLocs  map[*SourceFile]map[int]*SourceLocation
 	levels      map[int]*Level
+	processes   map[string]*Process
+	// Interned messages, by hash.  A message whose hash collides with that of
+	// a different interned message is not itself interned.
+	messages    map[uint64][]string
+	messageSeed maphash.Seed
 }
 
 // NewAssetCache returns a new, empty AssetCache.
@@ -176,12 +239,44 @@ func NewAssetCache() *AssetCache {
 		sourceFiles: map[string]*SourceFile{},
 		sourceLocs:  map[*SourceFile]map[int]*SourceLocation{},
 		levels:      map[int]*Level{},
+		processes:   map[string]*Process{},
+		messages:    map[uint64][]string{},
+		messageSeed: maphash.MakeSeed(),
+	}
+}
+
+// InternMessage returns a message equal to the provided one, sharing storage
+// with any equal message previously interned by the receiving AssetCache.
+// Interned messages are shared between Entries, and must not be modified.
+func (ac *AssetCache) InternMessage(message []string) []string {
+	if len(message) == 0 {
+		return message
+	}
+	var h maphash.Hash
+	h.SetSeed(ac.messageSeed)
+	for _, line := range message {
+		h.WriteString(line)
+		h.WriteByte('\n')
+	}
+	sum := h.Sum64()
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	interned, ok := ac.messages[sum]
+	if !ok {
+		ac.messages[sum] = message
+		return message
+	}
+	if slices.Equal(interned, message) {
+		return interned
 	}
+	return message
 }
 
 // Log fetches the Log with the specified filename from the receiving
 // AssetCache, creating it if necessary.
 func (ac *AssetCache) Log(filename string) *Log {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
 	log, ok := ac.logs[filename]
 	if !ok {
 		log = &Log{
@@ -196,7 +291,9 @@ func (ac *AssetCache) Log(filename string) *Log {
 // specified source file and line from the receiving AssetCache, creating it
 // if necessary.
 func (ac *AssetCache) SourceLocation(filename string, line int) *SourceLocation {
-	sourceFile := ac.SourceFile(filename)
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	sourceFile := ac.sourceFile(filename)
 	sourceLines, ok := ac.sourceLocs[sourceFile]
 	if !ok {
 		sourceLines = map[int]*SourceLocation{}
@@ -216,6 +313,13 @@ func (ac *AssetCache) SourceLocation(filename string, line int) *SourceLocation
 // SourceFile fetches the SourceFile with the specified filename from the
 // receiving AssetCache, creating it if necessary.
 func (ac *AssetCache) SourceFile(filename string) *SourceFile {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	return ac.sourceFile(filename)
+}
+
+// sourceFile is SourceFile, for callers holding the receiver's lock.
+func (ac *AssetCache) sourceFile(filename string) *SourceFile {
 	sourceFile, ok := ac.sourceFiles[filename]
 	if !ok {
 		sourceFile = &SourceFile{
@@ -229,6 +333,8 @@ func (ac *AssetCache) SourceFile(filename string) *SourceFile {
 // Level fetches the Level with the specified weight and label from the
 // receiving AssetCache, creating it if necessary.
 func (ac *AssetCache) Level(weight int, label string) *Level {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
 	level, ok := ac.levels[weight]
 	if !ok {
 		level = &Level{
@@ -240,6 +346,21 @@ func (ac *AssetCache) Level(weight int, label string) *Level {
 	return level
 }
 
+// Process fetches the Process with the specified PID from the receiving
+// AssetCache, creating it if necessary.
+func (ac *AssetCache) Process(pid string) *Process {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+	process, ok := ac.processes[pid]
+	if !ok {
+		process = &Process{
+			PID: pid,
+		}
+		ac.processes[pid] = process
+	}
+	return process
+}
+
 // Item is the type sent on the channel returned by a LogReader's Entries()
 // method.  It is a union of a logentry.Entry and an error.
 type Item struct {
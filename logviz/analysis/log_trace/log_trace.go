@@ -15,7 +15,9 @@ package logtrace
 
 import (
 	"fmt"
+	"regexp"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -35,64 +37,378 @@ type LogTrace struct {
 	Levels      map[*Level]string
 	SourceLocs  map[*SourceLocation]string
 	SourceFiles map[*SourceFile]string
+	Processes   map[*Process]string
 
 	// We also maintain maps to look up granularity by identifier string.
 	LogsByID        map[string]*Log
 	LevelsByID      map[string]*Level
 	SourceLocsByID  map[string]*SourceLocation
 	SourceFilesByID map[string]*SourceFile
+	ProcessesByID   map[string]*Process
 
+	// The LogTrace's Entries, in increasing temporal order.  Populated only if
+	// the LogTrace is held in memory; prefer EntryAt and ForEachEntry, which
+	// support all backing stores.
 	Entries []*Entry
+
+	// The store holding the LogTrace's Entries.
+	store entryStore
+	// If non-nil, indices over Entries used to accelerate filtered queries.
+	index *index
+	// The estimated memory held by Entries.
+	memoryUsage MemoryUsage
+}
+
+// Options configures the construction of a LogTrace.
+type Options struct {
+	// If positive, the LogTrace is indexed, with per-Level entry counts in time
+	// buckets of this width and of each coarser standard width among one
+	// second, one minute, and one hour.  Indices speed up filtered queries on
+	// large logs, at the cost of additional memory and construction time.
+	IndexBucketWidth time.Duration
+	// If positive, once more than this many Entries have been read, they, and
+	// all subsequently read Entries, are written to a compact columnar file as
+	// they are read, and that file is memory-mapped, rather than held in
+	// memory.
+	OnDiskThreshold int
+	// The directory in which on-disk backing files are created.  If empty, the
+	// default directory for temporary files is used.
+	OnDiskDir string
+	// If non-nil, the location in which Entry timestamps' wall-clock readings
+	// are reinterpreted, for logs recording local times without a time zone.
+	TimeZone *time.Location
+	// Fixed offsets added to the timestamps of Entries in the Logs with the
+	// specified identifiers, after any TimeZone is applied.  These correct
+	// clock skew between machines, so that merged multi-host logs line up.
+	LogOffsets map[string]time.Duration
+	// If non-nil, Entries lacking a CorrelationID take it from the first match
+	// of this pattern in their message: its first capture group if it has one,
+	// and otherwise the whole match.
+	CorrelationIDPattern *regexp.Regexp
+	// If non-empty, and CorrelationIDPattern is nil, Entries lacking a
+	// CorrelationID take it from the value of this structured field in their
+	// message, written as 'field=value', 'field: value', or '"field": "value"'.
+	CorrelationIDField string
+	// Patterns extracting derived fields from Entries' messages.  Each named
+	// capture group participating in a pattern's first match in a message sets
+	// the field of that name, unless the Entry already has that field.
+	FieldPatterns []*regexp.Regexp
+	// If true, each LogReader is assumed to yield Entries in nondecreasing
+	// timestamp order, even after any TimeZone and LogOffsets are applied, and
+	// the LogReaders' Entries are merged rather than sorted, which is much
+	// faster for many large logs.  Construction fails if any LogReader yields
+	// an Entry earlier than its predecessor.
+	Presorted bool
+	// If true, Entries that exactly duplicate an earlier Entry, differing at
+	// most in their Log, are dropped.  Such duplicates arise when overlapping
+	// copies of the same log, such as rotated log files, are loaded together.
+	Deduplicate bool
+	// If non-nil, masks sensitive content in Entries' messages and derived
+	// fields, after correlation IDs and derived fields are extracted, so that
+	// it is never held in the LogTrace.  Correlation IDs are not redacted.
+	Redactor Redactor
+}
+
+// Redactor masks sensitive content, such as email addresses or credentials,
+// in Entries' messages and derived fields.  Redactors must support concurrent
+// use.
+type Redactor interface {
+	// Redact returns the provided message and derived fields with any
+	// sensitive content masked.  The provided message and fields must not be
+	// modified; if nothing is masked, they may be returned as-is.
+	Redact(message []string, fields map[string]string) ([]string, map[string]string)
+	// String describes the Redactor's configuration.  Redactors masking
+	// differently must have different descriptions.
+	String() string
+}
+
+// Redact returns the provided Entry with its message and derived fields
+// masked by the provided Redactor.  Entries belong to their LogReaders or
+// LogTraces, so a copy is returned.
+func Redact(entry *Entry, r Redactor) *Entry {
+	redacted := *entry
+	redacted.Message, redacted.Fields = r.Redact(entry.Message, entry.Fields)
+	return &redacted
+}
+
+// correlationIDPattern returns the pattern from which the receiver extracts
+// correlation IDs, or nil if it doesn't extract them.
+func (opts Options) correlationIDPattern() *regexp.Regexp {
+	if opts.CorrelationIDPattern != nil {
+		return opts.CorrelationIDPattern
+	}
+	if opts.CorrelationIDField != "" {
+		return regexp.MustCompile(`(?:^|[\s,{(\[])"?` + regexp.QuoteMeta(opts.CorrelationIDField) + `"?\s*[=:]\s*"?([^\s",})\]]+)`)
+	}
+	return nil
+}
+
+// extractCorrelationID returns the correlation ID matched by the provided
+// pattern in the provided message, or the empty string if there's none.
+func extractCorrelationID(pattern *regexp.Regexp, message []string) string {
+	m := pattern.FindStringSubmatch(strings.Join(message, "\n"))
+	switch {
+	case m == nil:
+		return ""
+	case len(m) > 1:
+		return m[1]
+	default:
+		return m[0]
+	}
+}
+
+// extractFields returns the provided fields, amended with those extracted from
+// the provided message by the provided patterns.  The provided fields are not
+// modified.
+func extractFields(patterns []*regexp.Regexp, fields map[string]string, message []string) map[string]string {
+	joined := strings.Join(message, "\n")
+	ret, copied := fields, false
+	for _, pattern := range patterns {
+		m := pattern.FindStringSubmatchIndex(joined)
+		if m == nil {
+			continue
+		}
+		for groupIdx, name := range pattern.SubexpNames() {
+			if name == "" || m[2*groupIdx] < 0 {
+				continue
+			}
+			if _, ok := ret[name]; ok {
+				continue
+			}
+			if !copied {
+				ret = make(map[string]string, len(fields)+1)
+				for k, v := range fields {
+					ret[k] = v
+				}
+				copied = true
+			}
+			ret[name] = joined[m[2*groupIdx]:m[2*groupIdx+1]]
+		}
+	}
+	return ret
+}
+
+// adjustTime returns the provided Entry's timestamp as adjusted by the
+// receiver's TimeZone and LogOffsets.
+func (opts Options) adjustTime(entry *Entry) time.Time {
+	t := entry.Time
+	if opts.TimeZone != nil {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), opts.TimeZone)
+	}
+	return t.Add(opts.LogOffsets[entry.Log.Identifier()])
+}
+
+// prepare returns the provided Entry with its timestamp adjusted, its
+// correlation ID and derived fields extracted, and its sensitive content
+// redacted, as specified by the receiver.
+// The provided correlation ID pattern is the receiver's
+// correlationIDPattern().  Entries belong to their LogReaders, so if any
+// change is necessary, a copy is returned.
+func (opts Options) prepare(entry *Entry, correlationIDPattern *regexp.Regexp) *Entry {
+	adjustTime := opts.TimeZone != nil || len(opts.LogOffsets) > 0
+	extractID := correlationIDPattern != nil && entry.CorrelationID == ""
+	deriveFields := len(opts.FieldPatterns) > 0
+	redact := opts.Redactor != nil
+	if !adjustTime && !extractID && !deriveFields && !redact {
+		return entry
+	}
+	adjusted := *entry
+	if adjustTime {
+		adjusted.Time = opts.adjustTime(entry)
+	}
+	if extractID {
+		adjusted.CorrelationID = extractCorrelationID(correlationIDPattern, entry.Message)
+	}
+	if deriveFields {
+		adjusted.Fields = extractFields(opts.FieldPatterns, entry.Fields, entry.Message)
+	}
+	if redact {
+		adjusted.Message, adjusted.Fields = opts.Redactor.Redact(adjusted.Message, adjusted.Fields)
+	}
+	return &adjusted
+}
+
+// register adds the provided Entry's Log, Level, SourceLocation, SourceFile,
+// and Process to the receiver.
+func (lt *LogTrace) register(entry *Entry) {
+	lt.Logs[entry.Log] = entry.Log.Identifier()
+	lt.LogsByID[entry.Log.Identifier()] = entry.Log
+	lt.Levels[entry.Level] = entry.Level.Identifier()
+	lt.LevelsByID[entry.Level.Identifier()] = entry.Level
+	lt.SourceLocs[entry.SourceLocation] = entry.SourceLocation.Identifier()
+	lt.SourceLocsByID[entry.SourceLocation.Identifier()] = entry.SourceLocation
+	lt.SourceFiles[entry.SourceLocation.SourceFile] = entry.SourceLocation.SourceFile.Identifier()
+	lt.SourceFilesByID[entry.SourceLocation.SourceFile.Identifier()] = entry.SourceLocation.SourceFile
+	if entry.Process != nil {
+		lt.Processes[entry.Process] = entry.Process.Identifier()
+		lt.ProcessesByID[entry.Process.Identifier()] = entry.Process
+	}
 }
 
 // NewLogTrace returns a new LogTrace populated from the provided LogReader.
 func NewLogTrace(lrs ...LogReader) (*LogTrace, error) {
+	return NewLogTraceWithOptions(Options{}, lrs...)
+}
+
+// NewIndexedLogTrace returns a new LogTrace populated from the provided
+// LogReaders, with indices supporting fast filtering by Level, SourceFile,
+// and Process, and with per-Level entry counts in time buckets of the
+// specified width and of coarser standard widths.
+func NewIndexedLogTrace(bucketWidth time.Duration, lrs ...LogReader) (*LogTrace, error) {
+	if bucketWidth <= 0 {
+		return nil, fmt.Errorf("log trace time bucket width must be positive")
+	}
+	return NewLogTraceWithOptions(Options{IndexBucketWidth: bucketWidth}, lrs...)
+}
+
+// NewLogTraceWithOptions returns a new LogTrace populated from the provided
+// LogReaders and configured by the provided Options.
+func NewLogTraceWithOptions(opts Options, lrs ...LogReader) (*LogTrace, error) {
 	lt := &LogTrace{
 		Logs:        map[*Log]string{},
 		Levels:      map[*Level]string{},
 		SourceLocs:  map[*SourceLocation]string{},
 		SourceFiles: map[*SourceFile]string{},
+		Processes:   map[*Process]string{},
 
 		LogsByID:        map[string]*Log{},
 		LevelsByID:      map[string]*Level{},
 		SourceLocsByID:  map[string]*SourceLocation{},
 		SourceFilesByID: map[string]*SourceFile{},
+		ProcessesByID:   map[string]*Process{},
+	}
+	correlationIDPattern := opts.correlationIDPattern()
+	prepare := func(entry *Entry) *Entry {
+		lt.register(entry)
+		return opts.prepare(entry, correlationIDPattern)
+	}
+	// Entries are held in memory until there are more than OnDiskThreshold of
+	// them, and are thereafter written to disk as they are read.
+	var cw *columnarWriter
+	emit := func(entry *Entry) error {
+		if cw != nil {
+			return cw.add(entry)
+		}
+		lt.Entries = append(lt.Entries, entry)
+		if opts.OnDiskThreshold <= 0 || len(lt.Entries) <= opts.OnDiskThreshold {
+			return nil
+		}
+		var err error
+		if cw, err = newColumnarWriter(opts.OnDiskDir); err != nil {
+			return err
+		}
+		for _, entry := range lt.Entries {
+			if err := cw.add(entry); err != nil {
+				return err
+			}
+		}
+		lt.Entries = nil
+		return nil
+	}
+	if err := readEntries(opts, lrs, prepare, emit); err != nil {
+		if cw != nil {
+			cw.abort()
+		}
+		return nil, err
+	}
+	if cw != nil {
+		cs, err := cw.finish(opts.Presorted, opts.Deduplicate)
+		if err != nil {
+			return nil, err
+		}
+		lt.store = cs
+	} else {
+		if !opts.Presorted {
+			// Order Entries by timestamp ascending.
+			sort.SliceStable(lt.Entries, func(x, y int) bool {
+				return lt.Entries[x].Time.Before(lt.Entries[y].Time)
+			})
+		}
+		if opts.Deduplicate {
+			lt.Entries = deduplicate(lt.Entries)
+		}
+		if len(lt.Entries) == 0 {
+			return nil, fmt.Errorf("log trace has no Entries")
+		}
+		lt.store = memoryStore(lt.Entries)
+	}
+	lt.memoryUsage = measureMemoryUsage(lt.Entries)
+	if opts.IndexBucketWidth > 0 {
+		lt.index = newIndex(lt, opts.IndexBucketWidth)
 	}
+	return lt, nil
+}
+
+// readEntries passes the Entries of the provided LogReaders, once passed
+// through the provided prepare function, to the provided emit function.  If
+// the provided Options specify that the LogReaders are presorted, Entries are
+// emitted in timestamp order; otherwise, they are emitted in the order read.
+func readEntries(opts Options, lrs []LogReader, prepare func(*Entry) *Entry, emit func(*Entry) error) error {
 	ac := NewAssetCache()
+	if opts.Presorted {
+		return mergeSorted(ac, lrs, prepare, emit)
+	}
 	for _, lr := range lrs {
 		entryCh, err := lr.Entries(ac)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create logtracer data source: %s", err)
+			return fmt.Errorf("failed to create logtracer data source: %s", err)
 		}
 		for item := range entryCh {
 			if item.Err != nil {
-				return nil, fmt.Errorf("failure fetching log Entries: %s", item.Err)
+				return fmt.Errorf("failure fetching log Entries: %s", item.Err)
+			}
+			if err := emit(prepare(item.Entry)); err != nil {
+				return err
 			}
-			lt.Logs[item.Entry.Log] = item.Entry.Log.Identifier()
-			lt.LogsByID[item.Entry.Log.Identifier()] = item.Entry.Log
-			lt.Levels[item.Entry.Level] = item.Entry.Level.Identifier()
-			lt.LevelsByID[item.Entry.Level.Identifier()] = item.Entry.Level
-			lt.SourceLocs[item.Entry.SourceLocation] = item.Entry.SourceLocation.Identifier()
-			lt.SourceLocsByID[item.Entry.SourceLocation.Identifier()] = item.Entry.SourceLocation
-			lt.SourceFiles[item.Entry.SourceLocation.SourceFile] = item.Entry.SourceLocation.SourceFile.Identifier()
-			lt.SourceFilesByID[item.Entry.SourceLocation.SourceFile.Identifier()] = item.Entry.SourceLocation.SourceFile
-			lt.Entries = append(lt.Entries, item.Entry)
 		}
 	}
-	if len(lt.Entries) == 0 {
-		return nil, fmt.Errorf("log trace has no Entries")
+	return nil
+}
+
+// Close releases any resources held by the receiver's backing store.  The
+// receiver must not be used after it is closed.  LogTraces that are not
+// closed release their resources when garbage-collected.
+func (lt *LogTrace) Close() error {
+	return lt.store.close()
+}
+
+// EntryCount returns the number of Entries in the receiver.  It is safe for
+// concurrent access.
+func (lt *LogTrace) EntryCount() int {
+	return lt.store.len()
+}
+
+// EntryAt returns the Entry at the specified position in the receiver's
+// time-ordered Entries.  It is safe for concurrent access.
+func (lt *LogTrace) EntryAt(pos int) *Entry {
+	return lt.store.at(pos)
+}
+
+// EntryCountInRange returns the number of Entries in the receiver within the
+// specified time range, inclusive.  It is safe for concurrent access.
+func (lt *LogTrace) EntryCountInRange(startTs, endTs time.Time) int {
+	f := &filter{
+		startTime: startTs,
+		endTime:   endTs,
+	}
+	startIdx, endIdx := f.filterRangeTemporal(lt.store)
+	return endIdx - startIdx
+}
+
+// TimeBuckets returns the receiver's per-Level time-bucketed entry counts at
+// each of its indexed bucket widths, finest first, or nil if the receiver is
+// not indexed.
+func (lt *LogTrace) TimeBuckets() []*TimeBuckets {
+	if lt.index == nil {
+		return nil
 	}
-	// Order Entries by timestamp ascending.
-	sort.Slice(lt.Entries, func(x, y int) bool {
-		return lt.Entries[x].Time.Before(lt.Entries[y].Time)
-	})
-	return lt, nil
+	return lt.index.timeBuckets
 }
 
 // TimeRange returns the start and end times of the receiver LogTrace.  It is
 // safe for concurrent access.
 func (lt *LogTrace) TimeRange() (time.Time, time.Time) {
-	return lt.Entries[0].Time, lt.Entries[len(lt.Entries)-1].Time
+	return lt.store.timeAt(0), lt.store.timeAt(lt.store.len() - 1)
 }
 
 // ForEachEntry executes the provided callback function for each Entry
@@ -103,8 +419,22 @@ func (lt *LogTrace) ForEachEntry(fn func(entry *Entry) error, fs ...Filter) erro
 	if err != nil {
 		return err
 	}
-	for _, e := range f.filterRangeTemporal(lt.Entries) {
-		if f.entryFilteredIn(e) {
+	if lt.index != nil {
+		if positions, ok := lt.index.candidates(f); ok {
+			positions = f.filterPositionsTemporal(lt.store, positions)
+			for idx := 0; idx < len(positions); idx += f.step() {
+				if e := lt.store.at(positions[idx]); f.entryFilteredIn(e) {
+					if err := fn(e); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+	}
+	startIdx, endIdx := f.filterRangeTemporal(lt.store)
+	for pos := startIdx; pos < endIdx; pos += f.step() {
+		if e := lt.store.at(pos); f.entryFilteredIn(e) {
 			if err := fn(e); err != nil {
 				return err
 			}
@@ -14,10 +14,17 @@
 package logtrace
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/util"
 )
 
 type testLogReader struct {
@@ -91,6 +98,28 @@ var entrySets = map[string][]*Entry{
 			WithLevel(ac.Level(0, "Fatal")).
 			WithMessage("Crashing..."),
 	},
+	"proclog": []*Entry{
+		NewEntry().
+			In(ac.Log("proclog")).
+			At(testTime(0)).
+			From(ac.SourceLocation("a.cc", 10)).
+			WithLevel(ac.Level(3, "Info")).
+			ByProcess(ac.Process("100")).
+			WithMessage("starting"),
+		NewEntry().
+			In(ac.Log("proclog")).
+			At(testTime(10)).
+			From(ac.SourceLocation("a.cc", 10)).
+			WithLevel(ac.Level(3, "Info")).
+			ByProcess(ac.Process("200")).
+			WithMessage("starting"),
+		NewEntry().
+			In(ac.Log("proclog")).
+			At(testTime(20)).
+			From(ac.SourceLocation("a.cc", 20)).
+			WithLevel(ac.Level(3, "Info")).
+			WithMessage("who am I?"),
+	},
 }
 
 func lt(t *testing.T, lrs ...LogReader) *LogTrace {
@@ -101,6 +130,27 @@ func lt(t *testing.T, lrs ...LogReader) *LogTrace {
 	return lt
 }
 
+func indexedLT(t *testing.T, lrs ...LogReader) *LogTrace {
+	lt, err := NewIndexedLogTrace(10*time.Second, lrs...)
+	if err != nil {
+		t.Fatalf("Failed to create indexed LogTrace: %s", err)
+	}
+	return lt
+}
+
+func onDiskLT(t *testing.T, opts Options, lrs ...LogReader) *LogTrace {
+	opts.OnDiskThreshold = 1
+	opts.OnDiskDir = t.TempDir()
+	lt, err := NewLogTraceWithOptions(opts, lrs...)
+	if err != nil {
+		t.Fatalf("Failed to create on-disk LogTrace: %s", err)
+	}
+	if lt.Entries != nil {
+		t.Fatalf("On-disk LogTrace unexpectedly has in-memory Entries")
+	}
+	return lt
+}
+
 func TestForEachEntryAndFiltering(t *testing.T) {
 	for _, test := range []struct {
 		description string
@@ -159,6 +209,98 @@ func TestForEachEntryAndFiltering(t *testing.T) {
 			entrySets["mylog"][0],
 			entrySets["mylog"][3],
 		},
+	}, {
+		description: "filter to process 100",
+		logTrace: lt(t,
+			newTestLogReader("proclog", entrySets["proclog"]...),
+		),
+		filters: []Filter{
+			WithProcesses(ac.Process("100")),
+		},
+		wantEntries: []*Entry{
+			entrySets["proclog"][0],
+		},
+	}, {
+		description: "indexed, filter to source file a.cc",
+		logTrace: indexedLT(t,
+			newTestLogReader("log", entrySets["mylog"]...),
+		),
+		filters: []Filter{
+			WithSourceFiles(ac.SourceFile("a.cc")),
+		},
+		wantEntries: []*Entry{
+			entrySets["mylog"][0],
+			entrySets["mylog"][2],
+			entrySets["mylog"][3],
+			entrySets["mylog"][4],
+		},
+	}, {
+		description: "indexed, filter to log level 'Info' and source file a.cc after 5 sec",
+		logTrace: indexedLT(t,
+			newTestLogReader("log", entrySets["mylog"]...),
+		),
+		filters: []Filter{
+			WithLevels(ac.Level(3, "Info")),
+			WithSourceFiles(ac.SourceFile("a.cc")),
+			WithStartTime(testTime(5)),
+		},
+		wantEntries: []*Entry{
+			entrySets["mylog"][3],
+		},
+	}, {
+		description: "indexed, filter to processes 100 and 200",
+		logTrace: indexedLT(t,
+			newTestLogReader("proclog", entrySets["proclog"]...),
+		),
+		filters: []Filter{
+			WithProcesses(ac.Process("100"), ac.Process("200")),
+		},
+		wantEntries: []*Entry{
+			entrySets["proclog"][0],
+			entrySets["proclog"][1],
+		},
+	}, {
+		description: "indexed, empty time range",
+		logTrace: indexedLT(t,
+			newTestLogReader("log", entrySets["mylog"]...),
+		),
+		filters: []Filter{
+			WithSourceFiles(ac.SourceFile("a.cc")),
+			WithStartTime(testTime(25)),
+			WithEndTime(testTime(5)),
+		},
+		wantEntries: []*Entry{},
+	}, {
+		description: "on disk, no filters",
+		logTrace: onDiskLT(t, Options{},
+			newTestLogReader("log", entrySets["mylog"]...),
+		),
+		wantEntries: entrySets["mylog"],
+	}, {
+		description: "on disk, filter to mylog after 15 sec",
+		logTrace: onDiskLT(t, Options{},
+			newTestLogReader("log", entrySets["mylog"]...),
+		),
+		filters: []Filter{
+			WithLogs(ac.Log("mylog")),
+			WithStartTime(testTime(15)),
+		},
+		wantEntries: []*Entry{
+			entrySets["mylog"][2],
+			entrySets["mylog"][3],
+			entrySets["mylog"][4],
+		},
+	}, {
+		description: "on disk, indexed, filter to process 200",
+		logTrace: onDiskLT(t, Options{IndexBucketWidth: 10 * time.Second},
+			newTestLogReader("proclog", entrySets["proclog"]...),
+		),
+		filters: []Filter{
+			WithProcesses(ac.Process("200")),
+		},
+		wantEntries: []*Entry{
+			entrySets["proclog"][1],
+		},
 	}} {
 		t.Run(test.description, func(t *testing.T) {
 			gotEntries := []*Entry{}
@@ -174,3 +316,750 @@ func TestForEachEntryAndFiltering(t *testing.T) {
 		})
 	}
 }
+
+func TestTimeBuckets(t *testing.T) {
+	if got := lt(t, newTestLogReader("log", entrySets["mylog"]...)).TimeBuckets(); got != nil {
+		t.Errorf("TimeBuckets() on unindexed LogTrace = %v, want nil", got)
+	}
+	indexed := indexedLT(t, newTestLogReader("log", entrySets["mylog"]...)).TimeBuckets()
+	var gotWidths []time.Duration
+	for _, tb := range indexed {
+		gotWidths = append(gotWidths, tb.Width)
+	}
+	if diff := cmp.Diff([]time.Duration{10 * time.Second, time.Minute, time.Hour}, gotWidths); diff != "" {
+		t.Errorf("TimeBuckets() widths diff (-want +got): %s", diff)
+	}
+	got := indexed[0]
+	want := &TimeBuckets{
+		Start: testTime(0),
+		Width: 10 * time.Second,
+		CountsByLevel: map[*Level][]int{
+			ac.Level(0, "Fatal"):   {0, 0, 0, 0, 1},
+			ac.Level(1, "Error"):   {0, 1, 0, 0, 0},
+			ac.Level(2, "Warning"): {0, 0, 1, 0, 0},
+			ac.Level(3, "Info"):    {1, 0, 0, 1, 0},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("TimeBuckets() = %v, diff (-want +got): %s", got, diff)
+	}
+	multi := newTimeBuckets(lt(t, newTestLogReader("log", entrySets["mylog"]...)), 10*time.Second, 20*time.Second)
+	wantMulti := []*TimeBuckets{want, {
+		Start: testTime(0),
+		Width: 20 * time.Second,
+		CountsByLevel: map[*Level][]int{
+			ac.Level(0, "Fatal"):   {0, 0, 1},
+			ac.Level(1, "Error"):   {1, 0, 0},
+			ac.Level(2, "Warning"): {0, 1, 0},
+			ac.Level(3, "Info"):    {1, 1, 0},
+		},
+	}}
+	if diff := cmp.Diff(wantMulti, multi); diff != "" {
+		t.Errorf("newTimeBuckets() = %v, diff (-want +got): %s", multi, diff)
+	}
+	gotInRange := map[string]int{}
+	got.ForEachBucket(testTime(5), testTime(30), func(level *Level, bucketStart time.Time, count int) {
+		gotInRange[fmt.Sprintf("%s@%s", level.Identifier(), bucketStart.Sub(startTime))] += count
+	})
+	wantInRange := map[string]int{
+		ac.Level(1, "Error").Identifier() + "@10s":   1,
+		ac.Level(2, "Warning").Identifier() + "@20s": 1,
+		ac.Level(3, "Info").Identifier() + "@30s":    1,
+	}
+	if diff := cmp.Diff(wantInRange, gotInRange); diff != "" {
+		t.Errorf("ForEachBucket() diff (-want +got): %s", diff)
+	}
+}
+
+func TestOptionsString(t *testing.T) {
+	// Every option must be described, so that caches keyed by descriptions
+	// distinguish all Options.
+	optsType, descType := reflect.TypeOf(Options{}), reflect.TypeOf(describedOptions{})
+	for idx := 0; idx < optsType.NumField(); idx++ {
+		if _, ok := descType.FieldByName(optsType.Field(idx).Name); !ok {
+			t.Errorf("Options field %s is not described by Options.String()", optsType.Field(idx).Name)
+		}
+	}
+	base := Options{
+		IndexBucketWidth: time.Second,
+		LogOffsets:       map[string]time.Duration{"a": time.Second, "b": time.Minute},
+		FieldPatterns:    []*regexp.Regexp{regexp.MustCompile(`took (?P<ms>\d+)ms`)},
+	}
+	same := base
+	same.LogOffsets = map[string]time.Duration{"b": time.Minute, "a": time.Second}
+	same.FieldPatterns = []*regexp.Regexp{regexp.MustCompile(`took (?P<ms>\d+)ms`)}
+	if base.String() != same.String() {
+		t.Errorf("Equal Options described differently: %s and %s", base, same)
+	}
+	for _, modify := range []func(opts *Options){
+		func(opts *Options) { opts.Presorted = true },
+		func(opts *Options) { opts.Deduplicate = true },
+		func(opts *Options) { opts.TimeZone = time.UTC },
+		func(opts *Options) { opts.LogOffsets = map[string]time.Duration{"a": time.Second} },
+		func(opts *Options) { opts.CorrelationIDField = "req" },
+		func(opts *Options) { opts.FieldPatterns = nil },
+	} {
+		different := base
+		modify(&different)
+		if base.String() == different.String() {
+			t.Errorf("Different Options described identically: %s", base)
+		}
+	}
+}
+
+func TestEncodeAndDecode(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		logTrace    *LogTrace
+	}{{
+		description: "in memory",
+		logTrace: lt(t,
+			newTestLogReader("log", entrySets["mylog"]...),
+			newTestLogReader("proclog", entrySets["proclog"]...),
+		),
+	}, {
+		description: "on disk",
+		logTrace: onDiskLT(t, Options{},
+			newTestLogReader("log", entrySets["mylog"]...),
+			newTestLogReader("proclog", entrySets["proclog"]...),
+		),
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := test.logTrace.Encode(&buf); err != nil {
+				t.Fatalf("Encode() yielded unexpected error %s", err)
+			}
+			// Encoded entries are already prepared, so preparing options, such
+			// as log offsets, are not applied again.
+			got, err := DecodeLogTrace(&buf, Options{
+				LogOffsets: map[string]time.Duration{"log": time.Hour, "proclog": time.Hour},
+			})
+			if err != nil {
+				t.Fatalf("DecodeLogTrace() yielded unexpected error %s", err)
+			}
+			entries := func(lt *LogTrace) []*Entry {
+				ret := []*Entry{}
+				for pos := 0; pos < lt.EntryCount(); pos++ {
+					ret = append(ret, lt.EntryAt(pos))
+				}
+				return ret
+			}
+			if diff := cmp.Diff(entries(test.logTrace), entries(got)); diff != "" {
+				t.Errorf("DecodeLogTrace() yielded different entries, diff (-want +got): %s", diff)
+			}
+			if len(got.ProcessesByID) != 2 || len(got.SourceFilesByID) != 2 {
+				t.Errorf("DecodeLogTrace() yielded %d processes and %d source files, want 2 and 2", len(got.ProcessesByID), len(got.SourceFilesByID))
+			}
+		})
+	}
+}
+
+func TestTimestampAdjustments(t *testing.T) {
+	utcTime := func(hour, minute int) time.Time {
+		return time.Date(2023, time.January, 1, hour, minute, 0, 0, time.UTC)
+	}
+	entry := func(log string, at time.Time, msg string) *Entry {
+		return NewEntry().
+			In(ac.Log(log)).
+			At(at).
+			From(ac.SourceLocation("a.cc", 10)).
+			WithLevel(ac.Level(3, "Info")).
+			WithMessage(msg)
+	}
+	hostA := []*Entry{
+		entry("host_a", utcTime(12, 0), "a1"),
+		entry("host_a", utcTime(12, 2), "a2"),
+	}
+	hostB := []*Entry{
+		entry("host_b", utcTime(12, 1), "b1"),
+	}
+	est := time.FixedZone("EST", -5*60*60)
+	for _, test := range []struct {
+		description string
+		opts        Options
+		want        []time.Time
+		wantMsgs    []string
+	}{{
+		description: "no adjustment",
+		want:        []time.Time{utcTime(12, 0), utcTime(12, 1), utcTime(12, 2)},
+		wantMsgs:    []string{"a1", "b1", "a2"},
+	}, {
+		description: "clock skew",
+		opts: Options{
+			LogOffsets: map[string]time.Duration{"host_b": 2 * time.Minute},
+		},
+		want:     []time.Time{utcTime(12, 0), utcTime(12, 2), utcTime(12, 3)},
+		wantMsgs: []string{"a1", "a2", "b1"},
+	}, {
+		description: "time zone and clock skew",
+		opts: Options{
+			TimeZone:   est,
+			LogOffsets: map[string]time.Duration{"host_a": -time.Hour},
+		},
+		want:     []time.Time{utcTime(16, 0), utcTime(16, 2), utcTime(17, 1)},
+		wantMsgs: []string{"a1", "a2", "b1"},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			lt, err := NewLogTraceWithOptions(test.opts,
+				newTestLogReader("host_a", hostA...),
+				newTestLogReader("host_b", hostB...),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create LogTrace: %s", err)
+			}
+			var got []time.Time
+			var gotMsgs []string
+			if err := lt.ForEachEntry(func(entry *Entry) error {
+				got = append(got, entry.Time)
+				gotMsgs = append(gotMsgs, entry.Message...)
+				return nil
+			}); err != nil {
+				t.Fatalf("ForEachEntry() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, got, cmp.Comparer(time.Time.Equal)); diff != "" {
+				t.Errorf("Got entry times %v, diff (-want +got): %s", got, diff)
+			}
+			if diff := cmp.Diff(test.wantMsgs, gotMsgs); diff != "" {
+				t.Errorf("Got entry messages %v, diff (-want +got): %s", gotMsgs, diff)
+			}
+		})
+	}
+	// The LogReaders' own entries are unchanged.
+	if !hostB[0].Time.Equal(utcTime(12, 1)) {
+		t.Errorf("LogReader entry time was modified to %v", hostB[0].Time)
+	}
+}
+
+func TestCorrelationIDs(t *testing.T) {
+	entry := func(log string, sec int, msg string) *Entry {
+		return NewEntry().
+			In(ac.Log(log)).
+			At(testTime(sec)).
+			From(ac.SourceLocation("a.cc", 10)).
+			WithLevel(ac.Level(3, "Info")).
+			WithMessage(msg)
+	}
+	frontend := []*Entry{
+		entry("frontend", 0, "request req=abc123 received"),
+		entry("frontend", 2, "request req=def456 received"),
+		entry("frontend", 4, "request req=abc123 done"),
+	}
+	backend := []*Entry{
+		entry("backend", 1, `{"req": "abc123", "op": "lookup"}`),
+		entry("backend", 3, "idle"),
+		// Reader-supplied correlation IDs are kept.
+		entry("backend", 5, "flushing").WithCorrelationID("abc123"),
+	}
+	for _, test := range []struct {
+		description string
+		opts        Options
+		id          string
+		wantSecs    []int
+	}{{
+		description: "field",
+		opts: Options{
+			CorrelationIDField: "req",
+		},
+		id:       "abc123",
+		wantSecs: []int{0, 1, 4, 5},
+	}, {
+		description: "field, indexed",
+		opts: Options{
+			CorrelationIDField: "req",
+			IndexBucketWidth:   time.Second,
+		},
+		id:       "def456",
+		wantSecs: []int{2},
+	}, {
+		description: "pattern, on disk",
+		opts: Options{
+			CorrelationIDPattern: regexp.MustCompile(`req=(\w+)`),
+			OnDiskThreshold:      1,
+		},
+		id:       "abc123",
+		wantSecs: []int{0, 4, 5},
+	}, {
+		description: "no extraction",
+		id:          "abc123",
+		wantSecs:    []int{5},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if test.opts.OnDiskThreshold > 0 {
+				test.opts.OnDiskDir = t.TempDir()
+			}
+			lt, err := NewLogTraceWithOptions(test.opts,
+				newTestLogReader("frontend", frontend...),
+				newTestLogReader("backend", backend...),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create LogTrace: %s", err)
+			}
+			var gotSecs []int
+			if err := lt.ForEachEntry(func(entry *Entry) error {
+				if entry.CorrelationID != test.id {
+					t.Errorf("Filtered-in entry has correlation ID '%s', want '%s'", entry.CorrelationID, test.id)
+				}
+				gotSecs = append(gotSecs, int(entry.Time.Sub(startTime)/time.Second))
+				return nil
+			}, WithCorrelationIDs(test.id)); err != nil {
+				t.Fatalf("ForEachEntry() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.wantSecs, gotSecs); diff != "" {
+				t.Errorf("Got entries at %v, diff (-want +got): %s", gotSecs, diff)
+			}
+		})
+	}
+}
+
+func TestDerivedFields(t *testing.T) {
+	entry := func(sec int, msg string) *Entry {
+		return NewEntry().
+			In(ac.Log("server")).
+			At(testTime(sec)).
+			From(ac.SourceLocation("a.cc", 10)).
+			WithLevel(ac.Level(3, "Info")).
+			WithMessage(msg)
+	}
+	entries := []*Entry{
+		entry(0, "rpc Lookup took 20ms"),
+		entry(1, "rpc Store took 350ms"),
+		entry(2, "idle"),
+		// Reader-supplied fields are kept.
+		entry(3, "rpc Lookup took 5ms").WithField("rpc_method", "Cached"),
+	}
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`rpc (?P<rpc_method>\w+)`),
+		regexp.MustCompile(`took (?P<latency_ms>\d+)ms`),
+	}
+	want := []map[string]string{
+		{"rpc_method": "Lookup", "latency_ms": "20"},
+		{"rpc_method": "Store", "latency_ms": "350"},
+		nil,
+		{"rpc_method": "Cached", "latency_ms": "5"},
+	}
+	for _, test := range []struct {
+		description string
+		opts        Options
+	}{{
+		description: "in memory",
+		opts: Options{
+			FieldPatterns: patterns,
+		},
+	}, {
+		description: "on disk",
+		opts: Options{
+			FieldPatterns:   patterns,
+			OnDiskThreshold: 1,
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if test.opts.OnDiskThreshold > 0 {
+				test.opts.OnDiskDir = t.TempDir()
+			}
+			lt, err := NewLogTraceWithOptions(test.opts, newTestLogReader("server", entries...))
+			if err != nil {
+				t.Fatalf("Failed to create LogTrace: %s", err)
+			}
+			var buf bytes.Buffer
+			if err := lt.Encode(&buf); err != nil {
+				t.Fatalf("Encode() yielded unexpected error %s", err)
+			}
+			decoded, err := DecodeLogTrace(&buf, Options{})
+			if err != nil {
+				t.Fatalf("DecodeLogTrace() yielded unexpected error %s", err)
+			}
+			for _, lt := range []*LogTrace{lt, decoded} {
+				var got []map[string]string
+				for pos := 0; pos < lt.EntryCount(); pos++ {
+					got = append(got, lt.EntryAt(pos).Fields)
+				}
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("Got entry fields %v, diff (-want +got): %s", got, diff)
+				}
+				var withLatency int
+				if err := lt.ForEachEntry(func(entry *Entry) error {
+					withLatency++
+					return nil
+				}, WithFields("latency_ms")); err != nil {
+					t.Fatalf("ForEachEntry() yielded unexpected error %s", err)
+				}
+				if withLatency != 3 {
+					t.Errorf("Got %d entries with field 'latency_ms', want 3", withLatency)
+				}
+			}
+		})
+	}
+	// The LogReader's own entries are unchanged.
+	if diff := cmp.Diff(map[string]string{"rpc_method": "Cached"}, entries[3].Fields); diff != "" {
+		t.Errorf("LogReader entry fields were modified, diff (-want +got): %s", diff)
+	}
+}
+
+// digitRedactor is a Redactor masking all digits.
+type digitRedactor struct{}
+
+var digitsRE = regexp.MustCompile(`\d+`)
+
+func (digitRedactor) Redact(message []string, fields map[string]string) ([]string, map[string]string) {
+	retMessage := make([]string, len(message))
+	for idx, line := range message {
+		retMessage[idx] = digitsRE.ReplaceAllString(line, "#")
+	}
+	var retFields map[string]string
+	if fields != nil {
+		retFields = map[string]string{}
+		for name, value := range fields {
+			retFields[name] = digitsRE.ReplaceAllString(value, "#")
+		}
+	}
+	return retMessage, retFields
+}
+
+func (digitRedactor) String() string {
+	return "digits"
+}
+
+func TestRedaction(t *testing.T) {
+	entry := func(sec int, msg string) *Entry {
+		return NewEntry().
+			In(ac.Log("server")).
+			At(testTime(sec)).
+			From(ac.SourceLocation("a.cc", 10)).
+			WithLevel(ac.Level(3, "Info")).
+			WithMessage(msg)
+	}
+	entries := []*Entry{
+		entry(0, "user 1234 took 20ms"),
+		entry(1, "idle"),
+	}
+	opts := Options{
+		CorrelationIDPattern: regexp.MustCompile(`user (\d+)`),
+		FieldPatterns:        []*regexp.Regexp{regexp.MustCompile(`took (?P<latency>\d+ms)`)},
+		Redactor:             digitRedactor{},
+	}
+	lt, err := NewLogTraceWithOptions(opts, newTestLogReader("server", entries...))
+	if err != nil {
+		t.Fatalf("Failed to create LogTrace: %s", err)
+	}
+	type redacted struct {
+		CorrelationID string
+		Message       []string
+		Fields        map[string]string
+	}
+	var got []redacted
+	for pos := 0; pos < lt.EntryCount(); pos++ {
+		e := lt.EntryAt(pos)
+		got = append(got, redacted{e.CorrelationID, e.Message, e.Fields})
+	}
+	// Correlation IDs and derived fields are extracted before redaction, but
+	// derived fields are redacted too.
+	want := []redacted{{
+		CorrelationID: "1234",
+		Message:       []string{"user # took #ms"},
+		Fields:        map[string]string{"latency": "#ms"},
+	}, {
+		Message: []string{"idle"},
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Got redacted entries %v, diff (-want +got): %s", got, diff)
+	}
+	// The LogReader's own entries are unchanged.
+	if diff := cmp.Diff([]string{"user 1234 took 20ms"}, entries[0].Message); diff != "" {
+		t.Errorf("LogReader entry message was modified, diff (-want +got): %s", diff)
+	}
+	redactedEntry := Redact(entries[0], digitRedactor{})
+	if diff := cmp.Diff([]string{"user # took #ms"}, redactedEntry.Message); diff != "" {
+		t.Errorf("Redact() yielded message %v, diff (-want +got): %s", redactedEntry.Message, diff)
+	}
+	if redactedEntry == entries[0] {
+		t.Errorf("Redact() returned its input Entry, want a copy")
+	}
+}
+
+func TestPresortedAndDeduplicated(t *testing.T) {
+	entry := func(log string, sec int, msg string) *Entry {
+		return NewEntry().
+			In(ac.Log(log)).
+			At(testTime(sec)).
+			From(ac.SourceLocation("a.cc", 10)).
+			WithLevel(ac.Level(3, "Info")).
+			WithMessage(msg)
+	}
+	// app.log.1 and app.log are overlapping copies of the same log.
+	readers := func() []LogReader {
+		return []LogReader{
+			newTestLogReader("app.log.1",
+				entry("app.log.1", 0, "a"),
+				entry("app.log.1", 1, "b"),
+				entry("app.log.1", 1, "c"),
+			),
+			newTestLogReader("app.log",
+				entry("app.log", 1, "b"),
+				entry("app.log", 1, "c"),
+				entry("app.log", 2, "d"),
+			),
+			newTestLogReader("other.log",
+				entry("other.log", 1, "b"),
+				entry("other.log", 3, "e"),
+			),
+		}
+	}
+	for _, test := range []struct {
+		description string
+		opts        Options
+		lrs         []LogReader
+		wantMsgs    []string
+		wantErr     bool
+	}{{
+		description: "sorted",
+		lrs:         readers(),
+		wantMsgs:    []string{"a", "b", "c", "b", "c", "b", "d", "e"},
+	}, {
+		description: "presorted",
+		opts: Options{
+			Presorted: true,
+		},
+		lrs:      readers(),
+		wantMsgs: []string{"a", "b", "c", "b", "c", "b", "d", "e"},
+	}, {
+		description: "sorted, deduplicated",
+		opts: Options{
+			Deduplicate: true,
+		},
+		lrs:      readers(),
+		wantMsgs: []string{"a", "b", "c", "d", "e"},
+	}, {
+		description: "presorted, deduplicated",
+		opts: Options{
+			Presorted:   true,
+			Deduplicate: true,
+		},
+		lrs:      readers(),
+		wantMsgs: []string{"a", "b", "c", "d", "e"},
+	}, {
+		description: "presorted with skew",
+		opts: Options{
+			Presorted:  true,
+			LogOffsets: map[string]time.Duration{"other.log": -3 * time.Second},
+		},
+		lrs:      readers(),
+		wantMsgs: []string{"b", "a", "e", "b", "c", "b", "c", "d"},
+	}, {
+		description: "presorted, but unsorted",
+		opts: Options{
+			Presorted: true,
+		},
+		lrs: []LogReader{
+			newTestLogReader("unsorted.log",
+				entry("unsorted.log", 0, "a"),
+				entry("unsorted.log", 1, "b"),
+				entry("unsorted.log", 0, "c"),
+			),
+		},
+		wantErr: true,
+	}, {
+		description: "presorted, empty",
+		opts: Options{
+			Presorted: true,
+		},
+		lrs:     []LogReader{newTestLogReader("empty.log")},
+		wantErr: true,
+	}} {
+		for _, onDisk := range []bool{false, true} {
+			description := test.description
+			opts := test.opts
+			if onDisk {
+				description += ", on disk"
+				// Entries are streamed to disk once the second is read.
+				opts.OnDiskThreshold = 1
+			}
+			t.Run(description, func(t *testing.T) {
+				opts.OnDiskDir = t.TempDir()
+				lt, err := NewLogTraceWithOptions(opts, test.lrs...)
+				if (err != nil) != test.wantErr {
+					t.Fatalf("NewLogTraceWithOptions() yielded error %v, wanted error: %t", err, test.wantErr)
+				}
+				if files, err := os.ReadDir(opts.OnDiskDir); err != nil || len(files) != 0 {
+					t.Errorf("On-disk directory holds %d files (error %v), want none", len(files), err)
+				}
+				if err != nil {
+					return
+				}
+				if onDisk && lt.Entries != nil {
+					t.Errorf("On-disk LogTrace unexpectedly has in-memory Entries")
+				}
+				var gotMsgs []string
+				if err := lt.ForEachEntry(func(entry *Entry) error {
+					gotMsgs = append(gotMsgs, entry.Message...)
+					return nil
+				}); err != nil {
+					t.Fatalf("ForEachEntry() yielded unexpected error %s", err)
+				}
+				if diff := cmp.Diff(test.wantMsgs, gotMsgs); diff != "" {
+					t.Errorf("Got entry messages %v, diff (-want +got): %s", gotMsgs, diff)
+				}
+			})
+		}
+	}
+}
+
+func TestForEachEntryParallel(t *testing.T) {
+	levels := []*Level{ac.Level(1, "Error"), ac.Level(3, "Info")}
+	var entries []*Entry
+	for idx := 0; idx < 4*minShardEntries+3; idx++ {
+		entries = append(entries, NewEntry().
+			In(ac.Log("biglog")).
+			At(startTime.Add(time.Duration(idx)*time.Millisecond)).
+			From(ac.SourceLocation("a.cc", idx%7)).
+			WithLevel(levels[idx%3%2]).
+			WithMessage("hello"))
+	}
+	for _, test := range []struct {
+		description string
+		lt          *LogTrace
+		filters     []Filter
+		// Sampled traversals visit too few entries to need several shards.
+		sampled bool
+	}{{
+		description: "unindexed, unfiltered",
+		lt:          lt(t, newTestLogReader("biglog", entries...)),
+	}, {
+		description: "unindexed, filtered",
+		lt:          lt(t, newTestLogReader("biglog", entries...)),
+		filters:     []Filter{WithLevels(levels[0]), WithStartTime(startTime.Add(time.Second))},
+	}, {
+		description: "indexed, filtered",
+		lt:          indexedLT(t, newTestLogReader("biglog", entries...)),
+		filters:     []Filter{WithLevels(levels[0])},
+	}, {
+		description: "unindexed, lightly sampled",
+		lt:          lt(t, newTestLogReader("biglog", entries...)),
+		filters:     []Filter{WithSampleStride(2)},
+	}, {
+		description: "unindexed, sampled",
+		lt:          lt(t, newTestLogReader("biglog", entries...)),
+		filters:     []Filter{WithSampleStride(3), WithStartTime(startTime.Add(time.Second))},
+		sampled:     true,
+	}, {
+		description: "indexed, filtered, sampled",
+		lt:          indexedLT(t, newTestLogReader("biglog", entries...)),
+		filters:     []Filter{WithLevels(levels[0]), WithSampleStride(3)},
+		sampled:     true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			var want []*Entry
+			wantCounts := map[*SourceLocation]int{}
+			if err := test.lt.ForEachEntry(func(entry *Entry) error {
+				want = append(want, entry)
+				wantCounts[entry.SourceLocation]++
+				return nil
+			}, test.filters...); err != nil {
+				t.Fatalf("ForEachEntry() yielded unexpected error %s", err)
+			}
+			var shards [][]*Entry
+			if err := test.lt.ForEachEntryParallel(4, func() func(entry *Entry) error {
+				shards = append(shards, nil)
+				shard := len(shards) - 1
+				return func(entry *Entry) error {
+					shards[shard] = append(shards[shard], entry)
+					return nil
+				}
+			}, test.filters...); err != nil {
+				t.Fatalf("ForEachEntryParallel() yielded unexpected error %s", err)
+			}
+			if len(shards) < 2 && !test.sampled {
+				t.Errorf("ForEachEntryParallel() used %d shards, wanted several", len(shards))
+			}
+			got := Reduce(shards, func(into, from []*Entry) []*Entry {
+				return append(into, from...)
+			})
+			if len(got) != len(want) {
+				t.Fatalf("ForEachEntryParallel() visited %d entries, want %d", len(got), len(want))
+			}
+			for idx := range want {
+				if got[idx] != want[idx] {
+					t.Fatalf("ForEachEntryParallel() visited entry %d out of order", idx)
+				}
+			}
+			gotCounts, err := Aggregate(test.lt, 0, func() map[*SourceLocation]int {
+				return map[*SourceLocation]int{}
+			}, func(counts map[*SourceLocation]int, entry *Entry) error {
+				counts[entry.SourceLocation]++
+				return nil
+			}, MergeCounts[*SourceLocation], test.filters...)
+			if err != nil {
+				t.Fatalf("Aggregate() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(wantCounts, gotCounts); diff != "" {
+				t.Errorf("Aggregate() diff (-want +got): %s", diff)
+			}
+		})
+	}
+	wantErr := fmt.Errorf("oops")
+	if err := lt(t, newTestLogReader("biglog", entries...)).ForEachEntryParallel(4, func() func(entry *Entry) error {
+		return func(entry *Entry) error {
+			return wantErr
+		}
+	}); err != wantErr {
+		t.Errorf("ForEachEntryParallel() yielded error %v, want %v", err, wantErr)
+	}
+	// Sampled traversals visit every stride'th Entry.
+	var sampled int
+	if err := lt(t, newTestLogReader("biglog", entries...)).ForEachEntry(func(entry *Entry) error {
+		if want := startTime.Add(time.Duration(sampled*3) * time.Millisecond); !entry.Time.Equal(want) {
+			t.Fatalf("Sampled ForEachEntry() visited entry at %s, want %s", entry.Time, want)
+		}
+		sampled++
+		return nil
+	}, WithSampleStride(3)); err != nil {
+		t.Fatalf("ForEachEntry() yielded unexpected error %s", err)
+	}
+	if want := (len(entries) + 2) / 3; sampled != want {
+		t.Errorf("Sampled ForEachEntry() visited %d entries, want %d", sampled, want)
+	}
+	// Panicking shards fail with a PanicError, rather than crashing.
+	var pe *util.PanicError
+	if err := lt(t, newTestLogReader("biglog", entries...)).ForEachEntryParallel(4, func() func(entry *Entry) error {
+		return func(entry *Entry) error {
+			var bins []int
+			bins[len(entry.Message)]++
+			return nil
+		}
+	}); !errors.As(err, &pe) {
+		t.Errorf("ForEachEntryParallel() with a panicking callback yielded error %v, want a PanicError", err)
+	}
+}
+
+func TestInternMessageAndMemoryUsage(t *testing.T) {
+	ac := NewAssetCache()
+	first := ac.InternMessage([]string{"hello", "world"})
+	second := ac.InternMessage([]string{"hello", "world"})
+	other := ac.InternMessage([]string{"hello"})
+	if &first[0] != &second[0] {
+		t.Errorf("InternMessage() didn't share equal messages")
+	}
+	if &first[0] == &other[0] {
+		t.Errorf("InternMessage() shared unequal messages")
+	}
+	var entries []*Entry
+	for idx, message := range [][]string{first, second, other} {
+		entries = append(entries, NewEntry().
+			In(ac.Log("log")).
+			At(testTime(idx)).
+			From(ac.SourceLocation("a.cc", 10)).
+			WithLevel(ac.Level(3, "Info")).
+			WithMessage(message...))
+	}
+	want := MemoryUsage{
+		Entries:            3,
+		EntryBytes:         3 * entrySize,
+		MessageBytes:       3*stringSize + int64(len("hello")+len("world")+len("hello")),
+		SharedMessageBytes: 2*stringSize + int64(len("hello")+len("world")),
+	}
+	if diff := cmp.Diff(want, lt(t, newTestLogReader("log", entries...)).MemoryUsage()); diff != "" {
+		t.Errorf("MemoryUsage() diff (-want +got): %s", diff)
+	}
+	if diff := cmp.Diff(MemoryUsage{}, onDiskLT(t, Options{}, newTestLogReader("log", entries...)).MemoryUsage()); diff != "" {
+		t.Errorf("on-disk MemoryUsage() diff (-want +got): %s", diff)
+	}
+}
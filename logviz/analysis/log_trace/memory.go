@@ -0,0 +1,80 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logtrace
+
+import (
+	"reflect"
+)
+
+var (
+	entrySize  = int64(reflect.TypeOf(Entry{}).Size())
+	stringSize = int64(reflect.TypeOf("").Size())
+)
+
+// MemoryUsage estimates the resident memory held by a LogTrace's in-memory
+// Entries.  Logs, Levels, SourceLocations, and Processes, which are few and
+// shared, and derived fields are not counted.
+type MemoryUsage struct {
+	// The number of in-memory Entries.
+	Entries int
+	// The bytes held by the Entries themselves.
+	EntryBytes int64
+	// The bytes held by distinct Entry messages, including their lines'
+	// string headers.  Messages shared by several Entries, as by
+	// AssetCache.InternMessage, are counted once.
+	MessageBytes int64
+	// The bytes that messages shared by several Entries would have held had
+	// they not been shared.
+	SharedMessageBytes int64
+}
+
+// TotalBytes returns the total estimated resident bytes of the receiver's
+// Entries.
+func (mu MemoryUsage) TotalBytes() int64 {
+	return mu.EntryBytes + mu.MessageBytes
+}
+
+// measureMemoryUsage returns the MemoryUsage of the provided Entries.
+func measureMemoryUsage(entries []*Entry) MemoryUsage {
+	ret := MemoryUsage{
+		Entries:    len(entries),
+		EntryBytes: int64(len(entries)) * entrySize,
+	}
+	// Shared messages share their backing arrays, and hence the address of
+	// their first line.
+	seen := map[*string]struct{}{}
+	for _, entry := range entries {
+		if len(entry.Message) == 0 {
+			continue
+		}
+		size := int64(len(entry.Message)) * stringSize
+		for _, line := range entry.Message {
+			size += int64(len(line))
+		}
+		if _, ok := seen[&entry.Message[0]]; ok {
+			ret.SharedMessageBytes += size
+			continue
+		}
+		seen[&entry.Message[0]] = struct{}{}
+		ret.MessageBytes += size
+	}
+	return ret
+}
+
+// MemoryUsage returns the estimated resident memory held by the receiver's
+// Entries, as measured when it was constructed.  LogTraces backed by on-disk
+// stores hold no Entries in memory, and report no usage.
+func (lt *LogTrace) MemoryUsage() MemoryUsage {
+	return lt.memoryUsage
+}
@@ -0,0 +1,154 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logtrace
+
+import (
+	"container/heap"
+	"fmt"
+	"slices"
+)
+
+// mergeCursor is the next Entry of one LogReader during a k-way merge.
+type mergeCursor struct {
+	// The index of the LogReader, breaking timestamp ties.
+	idx     int
+	entryCh <-chan *Item
+	entry   *Entry
+}
+
+// advance sets the receiver's entry to its LogReader's next Entry, as
+// returned by the provided function, or to nil if there are no more Entries.
+// Returns an error if the next Entry is earlier than the current one.
+func (mc *mergeCursor) advance(prepare func(*Entry) *Entry) error {
+	item, ok := <-mc.entryCh
+	if !ok {
+		mc.entry = nil
+		return nil
+	}
+	if item.Err != nil {
+		return fmt.Errorf("failure fetching log Entries: %s", item.Err)
+	}
+	next := prepare(item.Entry)
+	if mc.entry != nil && next.Time.Before(mc.entry.Time) {
+		return fmt.Errorf("presorted log '%s' has an Entry at %v after one at %v", next.Log.Identifier(), next.Time, mc.entry.Time)
+	}
+	mc.entry = next
+	return nil
+}
+
+// mergeHeap is a min-heap of mergeCursors, ordered by their Entries'
+// timestamps.
+type mergeHeap []*mergeCursor
+
+func (mh mergeHeap) Len() int { return len(mh) }
+
+func (mh mergeHeap) Less(a, b int) bool {
+	if !mh[a].entry.Time.Equal(mh[b].entry.Time) {
+		return mh[a].entry.Time.Before(mh[b].entry.Time)
+	}
+	return mh[a].idx < mh[b].idx
+}
+
+func (mh mergeHeap) Swap(a, b int) { mh[a], mh[b] = mh[b], mh[a] }
+
+func (mh *mergeHeap) Push(x any) { *mh = append(*mh, x.(*mergeCursor)) }
+
+func (mh *mergeHeap) Pop() any {
+	old := *mh
+	ret := old[len(old)-1]
+	*mh = old[:len(old)-1]
+	return ret
+}
+
+// mergeSorted passes the Entries of the provided LogReaders, each of which
+// must yield Entries in nondecreasing timestamp order once passed through the
+// provided prepare function, to the provided emit function in timestamp
+// order.  Ties are broken by LogReader order,
+// as by a stable sort of the LogReaders' concatenated Entries.
+func mergeSorted(ac *AssetCache, lrs []LogReader, prepare func(*Entry) *Entry, emit func(*Entry) error) error {
+	mh := make(mergeHeap, 0, len(lrs))
+	for idx, lr := range lrs {
+		entryCh, err := lr.Entries(ac)
+		if err != nil {
+			return fmt.Errorf("failed to create logtracer data source: %s", err)
+		}
+		mc := &mergeCursor{
+			idx:     idx,
+			entryCh: entryCh,
+		}
+		if err := mc.advance(prepare); err != nil {
+			return err
+		}
+		if mc.entry != nil {
+			mh = append(mh, mc)
+		}
+	}
+	heap.Init(&mh)
+	for len(mh) > 0 {
+		mc := mh[0]
+		if err := emit(mc.entry); err != nil {
+			return err
+		}
+		if err := mc.advance(prepare); err != nil {
+			return err
+		}
+		if mc.entry == nil {
+			heap.Pop(&mh)
+		} else {
+			heap.Fix(&mh, 0)
+		}
+	}
+	return nil
+}
+
+// duplicates returns true if the receiver and the provided Entry are
+// identical except perhaps in their Log.
+func (e *Entry) duplicates(other *Entry) bool {
+	if !e.Time.Equal(other.Time) || e.Level != other.Level || e.SourceLocation != other.SourceLocation ||
+		e.Process != other.Process || e.CorrelationID != other.CorrelationID ||
+		!slices.Equal(e.Message, other.Message) || len(e.Fields) != len(other.Fields) {
+		return false
+	}
+	for k, v := range e.Fields {
+		if ov, ok := other.Fields[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+// deduplicate returns the provided Entries, which must be in timestamp order,
+// without any Entry duplicating an earlier one.  The provided slice is
+// reused.
+func deduplicate(entries []*Entry) []*Entry {
+	ret := entries[:0]
+	// The index in ret of the first Entry sharing the current timestamp.
+	runStart := 0
+	for _, entry := range entries {
+		if runStart < len(ret) && !ret[runStart].Time.Equal(entry.Time) {
+			runStart = len(ret)
+		}
+		duplicate := false
+		for _, kept := range ret[runStart:] {
+			if kept.duplicates(entry) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			ret = append(ret, entry)
+		}
+	}
+	return ret
+}
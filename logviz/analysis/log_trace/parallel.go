@@ -0,0 +1,169 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logtrace
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Filtered-in Entries are only split into additional shards for concurrent
+// handling when each shard would hold at least this many Entries, so that
+// small traversals don't pay for goroutines they don't need.
+const minShardEntries = 1 << 14
+
+// ForEachEntryParallel partitions the Entries satisfying the provided Filters
+// into up to the specified number of contiguous shards, and handles the
+// shards concurrently.  If workers is not positive, runtime.GOMAXPROCS(0) is
+// used.
+//
+// Before any Entry is handled, newShard is invoked once per shard, in
+// increasing shard (and hence temporal) order, and returns the callback to
+// execute for each Entry in that shard.  Each shard's callback is invoked
+// from a single goroutine on its Entries in increasing temporal order, so
+// the partial aggregates it builds need no synchronization; merging these in
+// shard order, as with Reduce, yields what ForEachEntry would have.
+//
+// If any callback returns an error, the remaining shards stop early and the
+// error of the earliest failing shard is returned.  A callback panicking fails
+// its shard with a util.PanicError, since panics in shard goroutines could
+// not otherwise be recovered by the QueryDispatcher.  It is
+// safe for concurrent access.
+func (lt *LogTrace) ForEachEntryParallel(workers int, newShard func() func(entry *Entry) error, fs ...Filter) error {
+	f, err := lt.filter(fs...)
+	if err != nil {
+		return err
+	}
+	// The Entries to consider are either the index candidates or the whole
+	// store, restricted to the filtered-in time range.
+	var positions []int
+	startIdx, endIdx := 0, 0
+	if lt.index != nil {
+		if candidates, ok := lt.index.candidates(f); ok {
+			positions = f.filterPositionsTemporal(lt.store, candidates)
+			endIdx = len(positions)
+		}
+	}
+	if positions == nil {
+		startIdx, endIdx = f.filterRangeTemporal(lt.store)
+	}
+	positionAt := func(idx int) int {
+		if positions != nil {
+			return positions[idx]
+		}
+		return idx
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	shardCount := (endIdx - startIdx) / f.step() / minShardEntries
+	if shardCount > workers {
+		shardCount = workers
+	}
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	fns := make([]func(entry *Entry) error, shardCount)
+	for shard := range fns {
+		fns[shard] = newShard()
+	}
+	errs := make([]error, shardCount)
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for shard := range fns {
+		shard := shard
+		// Shard boundaries are spread evenly over [startIdx, endIdx).
+		shardStart := startIdx + (endIdx-startIdx)*shard/shardCount
+		shardEnd := startIdx + (endIdx-startIdx)*(shard+1)/shardCount
+		// When sampling, each shard begins at the first sampled position
+		// within it, so that the traversal samples as ForEachEntry would.
+		step := f.step()
+		shardStart += (step - (shardStart-startIdx)%step) % step
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[shard] = util.Recovered(r)
+					failed.Store(true)
+				}
+			}()
+			for idx := shardStart; idx < shardEnd && !failed.Load(); idx += step {
+				if e := lt.store.at(positionAt(idx)); f.entryFilteredIn(e) {
+					if err := fns[shard](e); err != nil {
+						errs[shard] = err
+						failed.Store(true)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reduce merges each of the provided partial aggregates, in order, into the
+// first, and returns the result.  Partials are typically those built by the
+// shards of ForEachEntryParallel.  If there are no partials, Reduce returns
+// the zero value.
+func Reduce[P any](partials []P, merge func(into, from P) P) P {
+	var ret P
+	for idx, partial := range partials {
+		if idx == 0 {
+			ret = partial
+			continue
+		}
+		ret = merge(ret, partial)
+	}
+	return ret
+}
+
+// Aggregate builds a partial aggregate, with newPartial, for each shard of a
+// ForEachEntryParallel traversal of the Entries satisfying the provided
+// Filters, accumulates each Entry into its shard's partial with fn, and
+// returns the partials merged in temporal order with merge.  Since fn
+// mutates its partial in place, P should be a reference type such as a map
+// or pointer.
+func Aggregate[P any](lt *LogTrace, workers int, newPartial func() P, fn func(partial P, entry *Entry) error, merge func(into, from P) P, fs ...Filter) (P, error) {
+	var partials []P
+	if err := lt.ForEachEntryParallel(workers, func() func(entry *Entry) error {
+		partial := newPartial()
+		partials = append(partials, partial)
+		return func(entry *Entry) error {
+			return fn(partial, entry)
+		}
+	}, fs...); err != nil {
+		var zero P
+		return zero, err
+	}
+	return Reduce(partials, merge), nil
+}
+
+// MergeCounts adds each count in from to the corresponding count in into, and
+// returns into.  It is suitable as an Aggregate merge function.
+func MergeCounts[K comparable](into, from map[K]int) map[K]int {
+	for key, count := range from {
+		into[key] += count
+	}
+	return into
+}
@@ -0,0 +1,115 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package processlifetimes infers the lifetimes of logging processes from
+// their log entries.  By default, a process lives from its first entry to its
+// last; optionally, messages matching configured start and stop patterns,
+// such as 'starting' or 'shutting down', explicitly begin and end lifetimes,
+// so that a process logging under the same identifier across restarts has
+// several lifetimes.
+package processlifetimes
+
+import (
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Options configures a Tracker.
+type Options struct {
+	// If non-nil, messages matching StartPattern begin a new lifetime of their
+	// process, ending any lifetime already in progress.
+	StartPattern *regexp.Regexp
+	// If non-nil, messages matching StopPattern end their process's current
+	// lifetime.  Any later entry from that process begins a new lifetime.
+	StopPattern *regexp.Regexp
+}
+
+// Lifetime is a single lifetime of a process.
+type Lifetime struct {
+	// The times of the lifetime's first and last entries.
+	Start, End time.Time
+	// True if the lifetime's first entry matched the start pattern.
+	ExplicitStart bool
+	// True if the lifetime's last entry matched the stop pattern.
+	ExplicitStop bool
+	// The number of entries in the lifetime.
+	Entries int
+}
+
+// processState tracks the lifetimes of a single process.
+type processState struct {
+	lifetimes []*Lifetime
+	// True if the last lifetime has not been explicitly stopped.
+	open bool
+}
+
+// Tracker infers process lifetimes from a time-ordered sequence of log
+// entries.  It is not safe for concurrent use.
+type Tracker struct {
+	opts      Options
+	processes map[string]*processState
+}
+
+// New returns a new Tracker configured by the provided Options.
+func New(opts Options) *Tracker {
+	return &Tracker{
+		opts:      opts,
+		processes: map[string]*processState{},
+	}
+}
+
+// Add adds an entry logged by the specified process at the specified time with
+// the specified message.  Entries must be added in increasing temporal order.
+func (t *Tracker) Add(processID string, ts time.Time, message string) {
+	ps, ok := t.processes[processID]
+	if !ok {
+		ps = &processState{}
+		t.processes[processID] = ps
+	}
+	isStart := t.opts.StartPattern != nil && t.opts.StartPattern.MatchString(message)
+	if isStart || !ps.open {
+		ps.lifetimes = append(ps.lifetimes, &Lifetime{
+			Start:         ts,
+			ExplicitStart: isStart,
+		})
+		ps.open = true
+	}
+	lt := ps.lifetimes[len(ps.lifetimes)-1]
+	lt.End = ts
+	lt.Entries++
+	if t.opts.StopPattern != nil && t.opts.StopPattern.MatchString(message) {
+		lt.ExplicitStop = true
+		ps.open = false
+	}
+}
+
+// ProcessIDs returns the identifiers of all processes with lifetimes, in
+// increasing order.
+func (t *Tracker) ProcessIDs() []string {
+	ret := make([]string, 0, len(t.processes))
+	for processID := range t.processes {
+		ret = append(ret, processID)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Lifetimes returns the lifetimes of the specified process, in temporal order.
+func (t *Tracker) Lifetimes(processID string) []*Lifetime {
+	ps, ok := t.processes[processID]
+	if !ok {
+		return nil
+	}
+	return ps.lifetimes
+}
@@ -0,0 +1,89 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package processlifetimes
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTracker(t *testing.T) {
+	ts := func(secs int) time.Time {
+		return time.Unix(int64(secs), 0)
+	}
+	type entry struct {
+		process string
+		secs    int
+		message string
+	}
+	for _, test := range []struct {
+		description string
+		opts        Options
+		entries     []entry
+		want        map[string][]*Lifetime
+	}{{
+		description: "first to last entry",
+		entries: []entry{
+			{"p1", 0, "hello"},
+			{"p2", 1, "starting"},
+			{"p1", 2, "shutting down"},
+			{"p2", 3, "goodbye"},
+		},
+		want: map[string][]*Lifetime{
+			"p1": {{Start: ts(0), End: ts(2), Entries: 2}},
+			"p2": {{Start: ts(1), End: ts(3), Entries: 2}},
+		},
+	}, {
+		description: "explicit starts and stops",
+		opts: Options{
+			StartPattern: regexp.MustCompile(`^starting`),
+			StopPattern:  regexp.MustCompile(`^shutting down`),
+		},
+		entries: []entry{
+			{"p1", 0, "hello"},
+			{"p1", 1, "starting"},
+			{"p1", 2, "working"},
+			{"p1", 3, "shutting down"},
+			{"p1", 4, "late message"},
+			{"p1", 5, "starting again"},
+			{"p2", 6, "shutting down"},
+		},
+		want: map[string][]*Lifetime{
+			"p1": {
+				{Start: ts(0), End: ts(0), Entries: 1},
+				{Start: ts(1), End: ts(3), ExplicitStart: true, ExplicitStop: true, Entries: 3},
+				{Start: ts(4), End: ts(4), Entries: 1},
+				{Start: ts(5), End: ts(5), ExplicitStart: true, Entries: 1},
+			},
+			"p2": {{Start: ts(6), End: ts(6), ExplicitStop: true, Entries: 1}},
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			tracker := New(test.opts)
+			for _, e := range test.entries {
+				tracker.Add(e.process, ts(e.secs), e.message)
+			}
+			got := map[string][]*Lifetime{}
+			for _, processID := range tracker.ProcessIDs() {
+				got[processID] = tracker.Lifetimes(processID)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("lifetimes diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package annotations provides annotations for LogViz: user notes attached to
+// a time range of a collection, so that investigations can record findings in
+// place.  Annotations are persisted as JSON files in a directory, and are
+// created, listed, and deleted through the data queries served by DataSource.
+package annotations
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	createQuery = "annotations.create"
+	listQuery   = "annotations.list"
+	deleteQuery = "annotations.delete"
+
+	annotationIDKey   = "annotation_id"
+	noteKey           = "annotation_note"
+	authorKey         = "annotation_author"
+	createdKey        = "annotation_created"
+	collectionNameKey = "collection_name"
+	startTimestampKey = "start_timestamp"
+	endTimestampKey   = "end_timestamp"
+)
+
+// ErrNotFound is returned by Store.Delete for unknown annotation IDs.
+var ErrNotFound = errors.New("annotation not found")
+
+// Annotation is a note attached to a time range of a collection.
+type Annotation struct {
+	// The annotation's unique ID, assigned by Store.Create.
+	ID string `json:"id"`
+	// The annotated collection.
+	Collection string `json:"collection"`
+	// The annotated time range, inclusive.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// The note.
+	Note string `json:"note"`
+	// The name of the Principal that created the annotation, if known.
+	Author string `json:"author,omitempty"`
+	// When the annotation was created, assigned by Store.Create.
+	Created time.Time `json:"created"`
+}
+
+// Overlaps returns true if the receiver's time range overlaps the provided
+// inclusive time range.
+func (a *Annotation) Overlaps(start, end time.Time) bool {
+	return !a.Start.After(end) && !a.End.Before(start)
+}
+
+// properties returns the receiver's fields as properties.
+func (a *Annotation) properties() []util.PropertyUpdate {
+	return []util.PropertyUpdate{
+		util.StringProperty(annotationIDKey, a.ID),
+		util.StringProperty(collectionNameKey, a.Collection),
+		util.TimestampProperty(startTimestampKey, a.Start),
+		util.TimestampProperty(endTimestampKey, a.End),
+		util.StringProperty(noteKey, a.Note),
+		util.If(a.Author != "", util.StringProperty(authorKey, a.Author)),
+		util.TimestampProperty(createdKey, a.Created),
+	}
+}
+
+// Store persists Annotations as JSON files in a directory.  All Annotations
+// are also held in memory, so that those overlapping a time range can be
+// found without reading the directory; Annotations written to the directory
+// by other processes are not seen until the Store is recreated.  Stores
+// support concurrent use.
+type Store struct {
+	dir  string
+	mu   sync.RWMutex
+	byID map[string]*Annotation
+}
+
+const annotationSuffix = ".json"
+
+// validIDRE matches annotation IDs that are safe to use as filenames.
+var validIDRE = regexp.MustCompile(`^[0-9a-f]+$`)
+
+// NewStore returns a new Store in the specified directory, creating it if
+// necessary, and loading any Annotations already there.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create annotation directory: %s", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:  dir,
+		byID: map[string]*Annotation{},
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), annotationSuffix)
+		if !ok || entry.IsDir() || !validIDRE.MatchString(id) {
+			continue
+		}
+		contents, err := os.ReadFile(s.path(id))
+		if err != nil {
+			return nil, err
+		}
+		a := &Annotation{}
+		if err := json.Unmarshal(contents, a); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal annotation '%s': %s", id, err)
+		}
+		s.byID[a.ID] = a
+	}
+	return s, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+annotationSuffix)
+}
+
+// idBytes is the number of random bytes in an annotation ID.
+const idBytes = 8
+
+// Create stores the provided Annotation, assigning its ID and creation time.
+// Annotations are written to a temporary file and renamed into place, so that
+// a partially-written Annotation is never loaded.
+func (s *Store) Create(a *Annotation) error {
+	if a.Collection == "" {
+		return fmt.Errorf("annotation has no collection")
+	}
+	if a.Note == "" {
+		return fmt.Errorf("annotation has no note")
+	}
+	if a.End.Before(a.Start) {
+		return fmt.Errorf("annotation ends before it starts")
+	}
+	id := make([]byte, idBytes)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	a.ID = hex.EncodeToString(id)
+	a.Created = time.Now().UTC()
+	contents, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("failed to marshal annotation: %s", err)
+	}
+	file, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(file.Name(), s.path(a.ID)); err != nil {
+		return err
+	}
+	s.byID[a.ID] = a
+	return nil
+}
+
+// Delete removes the Annotation with the specified ID, or returns
+// ErrNotFound.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[id]; !ok {
+		return ErrNotFound
+	}
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	delete(s.byID, id)
+	return nil
+}
+
+// Overlapping returns the Annotations on the specified collection overlapping
+// the provided inclusive time range, ordered by start time and then by ID.  If
+// both start and end are zero, all of the collection's Annotations are
+// returned.
+func (s *Store) Overlapping(collection string, start, end time.Time) []*Annotation {
+	all := start.IsZero() && end.IsZero()
+	s.mu.RLock()
+	var ret []*Annotation
+	for _, a := range s.byID {
+		if a.Collection == collection && (all || a.Overlaps(start, end)) {
+			ret = append(ret, a)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(ret, func(a, b int) bool {
+		if !ret[a].Start.Equal(ret[b].Start) {
+			return ret[a].Start.Before(ret[b].Start)
+		}
+		return ret[a].ID < ret[b].ID
+	})
+	return ret
+}
+
+var (
+	createSchema = []*util.OptionSpec{{
+		Key:      noteKey,
+		Source:   util.FromSeries,
+		Required: true,
+		Type:     util.StringValueType,
+	}, {
+		Key:      collectionNameKey,
+		Required: true,
+		Type:     util.StringValueType,
+	}, {
+		Key:      startTimestampKey,
+		Required: true,
+		Type:     util.TimestampValueType,
+	}, {
+		Key:      endTimestampKey,
+		Required: true,
+		Type:     util.TimestampValueType,
+	}}
+	listSchema = []*util.OptionSpec{{
+		Key:      collectionNameKey,
+		Required: true,
+		Type:     util.StringValueType,
+	}, {
+		Key:  startTimestampKey,
+		Type: util.TimestampValueType,
+	}, {
+		Key:  endTimestampKey,
+		Type: util.TimestampValueType,
+	}}
+	deleteSchema = []*util.OptionSpec{{
+		Key:      annotationIDKey,
+		Source:   util.FromSeries,
+		Required: true,
+		Type:     util.StringValueType,
+	}}
+)
+
+// DataSource is a QueryDispatcher data source serving the Annotations in a
+// Store.  It supports the queries:
+//
+//   - 'annotations.create', which creates an Annotation with the note in the
+//     'annotation_note' option, on the collection and time range specified by
+//     the 'collection_name', 'start_timestamp', and 'end_timestamp' options or
+//     global filters.  Its author is the requesting Principal, if any.  It
+//     yields the new Annotation's properties.
+//   - 'annotations.list', which yields a child datum for each Annotation on
+//     the collection specified by the 'collection_name' option or global
+//     filter, ordered by start time, with the Annotation's properties.  If
+//     'start_timestamp' and 'end_timestamp' are specified, only Annotations
+//     overlapping that time range are listed.
+//   - 'annotations.delete', which deletes the Annotation whose ID is given by
+//     the 'annotation_id' option.
+//
+// Annotation properties are 'annotation_id', 'collection_name',
+// 'start_timestamp', 'end_timestamp', 'annotation_note', 'annotation_author'
+// if known, and 'annotation_created'.
+type DataSource struct {
+	store *Store
+}
+
+// NewDataSource returns a new DataSource serving the Annotations in the
+// provided Store.
+func NewDataSource(store *Store) *DataSource {
+	return &DataSource{
+		store: store,
+	}
+}
+
+// SupportedDataSeriesQueries returns the queries supported by DataSource.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	return []string{createQuery, listQuery, deleteQuery}
+}
+
+// HandleDataSeriesRequests handles the provided DataSeriesRequests.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		var err error
+		switch req.QueryName {
+		case createQuery:
+			err = ds.handleCreateQuery(ctx, globalState, drb.DataSeries(req), req.Options)
+		case listQuery:
+			err = ds.handleListQuery(globalState, drb.DataSeries(req), req.Options)
+		case deleteQuery:
+			err = ds.handleDeleteQuery(drb.DataSeries(req), req.Options)
+		default:
+			err = fmt.Errorf("unsupported data query `%s`", req.QueryName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *DataSource) handleCreateQuery(ctx context.Context, globalState map[string]*util.V, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	opts, err := util.ResolveOptions(globalState, reqOpts, createSchema)
+	if err != nil {
+		return err
+	}
+	a := &Annotation{}
+	if a.Note, err = opts.String(noteKey); err != nil {
+		return err
+	}
+	if a.Collection, err = opts.String(collectionNameKey); err != nil {
+		return err
+	}
+	if a.Start, err = opts.Timestamp(startTimestampKey); err != nil {
+		return err
+	}
+	if a.End, err = opts.Timestamp(endTimestampKey); err != nil {
+		return err
+	}
+	if p := util.PrincipalFrom(ctx); p != nil {
+		a.Author = p.Name
+	}
+	if err := ds.store.Create(a); err != nil {
+		return err
+	}
+	series.With(a.properties()...)
+	return nil
+}
+
+func (ds *DataSource) handleListQuery(globalState map[string]*util.V, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	opts, err := util.ResolveOptions(globalState, reqOpts, listSchema)
+	if err != nil {
+		return err
+	}
+	collection, err := opts.String(collectionNameKey)
+	if err != nil {
+		return err
+	}
+	var start, end time.Time
+	if opts.Has(startTimestampKey) && opts.Has(endTimestampKey) {
+		if start, err = opts.Timestamp(startTimestampKey); err != nil {
+			return err
+		}
+		if end, err = opts.Timestamp(endTimestampKey); err != nil {
+			return err
+		}
+	}
+	for _, a := range ds.store.Overlapping(collection, start, end) {
+		series.Child().With(a.properties()...)
+	}
+	return nil
+}
+
+func (ds *DataSource) handleDeleteQuery(series util.DataBuilder, reqOpts map[string]*util.V) error {
+	opts, err := util.ResolveOptions(nil, reqOpts, deleteSchema)
+	if err != nil {
+		return err
+	}
+	id, err := opts.String(annotationIDKey)
+	if err != nil {
+		return err
+	}
+	if err := ds.store.Delete(id); err != nil {
+		return fmt.Errorf("failed to delete annotation '%s': %s", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package annotations
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	testutil "github.com/google/traceviz/server/go/test_util"
+	"github.com/google/traceviz/server/go/util"
+)
+
+var startTime = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func ts(dur time.Duration) time.Time {
+	return startTime.Add(dur)
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore() yielded unexpected error %s", err)
+	}
+	early := &Annotation{
+		Collection: "log1",
+		Start:      ts(0),
+		End:        ts(10 * time.Minute),
+		Note:       "startup",
+	}
+	late := &Annotation{
+		Collection: "log1",
+		Start:      ts(20 * time.Minute),
+		End:        ts(30 * time.Minute),
+		Note:       "shutdown",
+		Author:     "alice",
+	}
+	other := &Annotation{
+		Collection: "log2",
+		Start:      ts(0),
+		End:        ts(30 * time.Minute),
+		Note:       "elsewhere",
+	}
+	for _, a := range []*Annotation{late, early, other} {
+		if err := store.Create(a); err != nil {
+			t.Fatalf("Create(%s) yielded unexpected error %s", a.Note, err)
+		}
+	}
+	for _, a := range []*Annotation{{
+		Start: ts(0),
+		End:   ts(time.Minute),
+		Note:  "no collection",
+	}, {
+		Collection: "log1",
+		Start:      ts(0),
+		End:        ts(time.Minute),
+	}, {
+		Collection: "log1",
+		Start:      ts(time.Minute),
+		End:        ts(0),
+		Note:       "backwards",
+	}} {
+		if err := store.Create(a); err == nil {
+			t.Errorf("Create(%s) yielded no error, but expected one", a.Note)
+		}
+	}
+	for _, test := range []struct {
+		description string
+		start, end  time.Time
+		want        []*Annotation
+	}{{
+		description: "all",
+		want:        []*Annotation{early, late},
+	}, {
+		description: "overlapping start",
+		start:       ts(10 * time.Minute),
+		end:         ts(15 * time.Minute),
+		want:        []*Annotation{early},
+	}, {
+		description: "between",
+		start:       ts(11 * time.Minute),
+		end:         ts(19 * time.Minute),
+	}, {
+		description: "spanning",
+		start:       ts(-time.Hour),
+		end:         ts(time.Hour),
+		want:        []*Annotation{early, late},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			got := store.Overlapping("log1", test.start, test.end)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Overlapping() = %v, diff (-want +got):\n%s", got, diff)
+			}
+		})
+	}
+	if err := store.Delete(early.ID); err != nil {
+		t.Fatalf("Delete() yielded unexpected error %s", err)
+	}
+	if err := store.Delete(early.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a deleted annotation yielded error %v, want ErrNotFound", err)
+	}
+	// Annotations persist across Stores.
+	reloaded, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore() yielded unexpected error %s", err)
+	}
+	got := reloaded.Overlapping("log1", time.Time{}, time.Time{})
+	if diff := cmp.Diff([]*Annotation{late}, got, cmp.Comparer(func(a, b time.Time) bool {
+		return a.Equal(b)
+	})); diff != "" {
+		t.Errorf("Reloaded annotations = %v, diff (-want +got):\n%s", got, diff)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore() yielded unexpected error %s", err)
+	}
+	qd, err := querydispatcher.New(NewDataSource(store))
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	ctx := util.WithPrincipal(context.Background(), &util.Principal{Name: "alice"})
+	handle := func(req *util.DataRequest) (*util.Data, error) {
+		return qd.HandleDataRequest(ctx, req)
+	}
+	// Create an annotation on the filtered-in time range.
+	if _, err := handle(&util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+			startTimestampKey: util.TimestampValue(ts(0)),
+			endTimestampKey:   util.TimestampValue(ts(10 * time.Minute)),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName: createQuery,
+			Options: map[string]*util.V{
+				noteKey: util.StringValue("startup"),
+			},
+		}},
+	}); err != nil {
+		t.Fatalf("Unexpected error creating annotation: %s", err)
+	}
+	created := store.Overlapping("log1", time.Time{}, time.Time{})
+	if len(created) != 1 {
+		t.Fatalf("Got %d annotations, want 1", len(created))
+	}
+	a := created[0]
+	listReq := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName: listQuery,
+		}},
+	}
+	gotData, err := handle(listReq)
+	if err != nil {
+		t.Fatalf("Unexpected error listing annotations: %s", err)
+	}
+	drb := util.NewDataResponseBuilder()
+	drb.DataSeries(listReq.SeriesRequests[0]).Child().With(
+		util.StringProperty(annotationIDKey, a.ID),
+		util.StringProperty(collectionNameKey, "log1"),
+		util.TimestampProperty(startTimestampKey, ts(0)),
+		util.TimestampProperty(endTimestampKey, ts(10*time.Minute)),
+		util.StringProperty(noteKey, "startup"),
+		util.StringProperty(authorKey, "alice"),
+		util.TimestampProperty(createdKey, a.Created),
+	)
+	if err := testutil.CompareDataResponses(t, gotData, drb); err != nil {
+		t.Fatalf("Failed to compare data responses: %s", err)
+	}
+	// Creating an annotation requires a note and a time range.
+	if _, err := handle(&util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName: createQuery,
+			Options: map[string]*util.V{
+				noteKey: util.StringValue("unplaced"),
+			},
+		}},
+	}); err == nil {
+		t.Errorf("Creating an annotation without a time range yielded no error, but expected one")
+	}
+	// Delete the annotation.
+	deleteReq := &util.DataRequest{
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName: deleteQuery,
+			Options: map[string]*util.V{
+				annotationIDKey: util.StringValue(a.ID),
+			},
+		}},
+	}
+	if _, err := handle(deleteReq); err != nil {
+		t.Fatalf("Unexpected error deleting annotation: %s", err)
+	}
+	if _, err := handle(deleteReq); err == nil {
+		t.Errorf("Deleting a deleted annotation yielded no error, but expected one")
+	}
+	gotData, err = handle(listReq)
+	if err != nil {
+		t.Fatalf("Unexpected error listing annotations: %s", err)
+	}
+	drb = util.NewDataResponseBuilder()
+	drb.DataSeries(listReq.SeriesRequests[0])
+	if err := testutil.CompareDataResponses(t, gotData, drb); err != nil {
+		t.Fatalf("Failed to compare data responses: %s", err)
+	}
+}
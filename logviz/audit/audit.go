@@ -0,0 +1,214 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package audit provides an access log of LogViz data queries, recording who
+// queried which collection, and when, for deployments whose logs hold
+// sensitive data and whose access must be accounted for.  An Observer builds
+// a Record for each data query and writes it to a pluggable Sink; Sinks
+// writing JSON lines to files or standard output, and posting JSON to an HTTP
+// endpoint, are provided.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/traceviz/logviz/logger"
+	"github.com/google/traceviz/server/go/handlers"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Record describes a single data query.
+type Record struct {
+	// When the query was received.
+	Time time.Time `json:"time"`
+	// The authenticated principal making the query, if any.
+	Principal string `json:"principal,omitempty"`
+	// The network address of the client making the query.
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// The queried collections.
+	Collections []string `json:"collections,omitempty"`
+	// The names of the queries made, in request order.
+	Queries []string `json:"queries"`
+	// The HTTP status code of the response, and any error.
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Sink persists Records.  Sinks must support concurrent use.
+type Sink interface {
+	// Write persists the provided Record.
+	Write(ctx context.Context, rec *Record) error
+}
+
+// WriterSink is a Sink writing each Record as a line of JSON to an
+// io.Writer.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a new WriterSink writing to the provided io.Writer,
+// such as os.Stdout.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{
+		w: w,
+	}
+}
+
+// NewFileSink returns a new WriterSink appending to the file at the specified
+// path, creating it, readable only by its owner, if necessary.
+func NewFileSink(path string) (*WriterSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %s", err)
+	}
+	return NewWriterSink(file), nil
+}
+
+// Write writes the provided Record as a line of JSON.
+func (ws *WriterSink) Write(ctx context.Context, rec *Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	_, err = ws.w.Write(line)
+	return err
+}
+
+// Close closes the receiver's io.Writer, if it is an io.Closer.
+func (ws *WriterSink) Close() error {
+	if closer, ok := ws.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// defaultHTTPTimeout bounds each post made by an HTTPSink using the default
+// HTTP client.
+const defaultHTTPTimeout = 10 * time.Second
+
+// HTTPSink is a Sink posting each Record, as JSON, to an HTTP endpoint.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink returns a new HTTPSink posting to the specified URL with the
+// provided HTTP client.  If the client is nil, a client with a 10-second
+// timeout is used.
+func NewHTTPSink(url string, client *http.Client) *HTTPSink {
+	if client == nil {
+		client = &http.Client{
+			Timeout: defaultHTTPTimeout,
+		}
+	}
+	return &HTTPSink{
+		url:    url,
+		client: client,
+	}
+}
+
+// Write posts the provided Record.  Responses with non-2xx statuses are
+// errors.
+func (hs *HTTPSink) Write(ctx context.Context, rec *Record) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hs.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := hs.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("audit endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Observer is a handlers.Observer writing a Record of each handled
+// DataRequest to a Sink.  Failures to write Records are logged.
+type Observer struct {
+	sink          Sink
+	collectionKey string
+}
+
+var _ handlers.Observer = &Observer{}
+
+// NewObserver returns a new Observer writing to the provided Sink.  The
+// global filter with the provided key names the collection, or collections,
+// each DataRequest queries.
+func NewObserver(sink Sink, collectionKey string) *Observer {
+	return &Observer{
+		sink:          sink,
+		collectionKey: collectionKey,
+	}
+}
+
+// RequestStarted returns the provided Context.
+func (o *Observer) RequestStarted(ctx context.Context, req *util.DataRequest) context.Context {
+	return ctx
+}
+
+// RequestFinished writes a Record of the described DataRequest.  Records are
+// written even for DataRequests that were rejected, e.g. for failing
+// authentication, and for those whose clients have gone away, so the
+// provided Context's cancellation is not passed on to the Sink.
+func (o *Observer) RequestFinished(ctx context.Context, info *handlers.RequestInfo) {
+	if err := o.sink.Write(context.Background(), o.record(info)); err != nil {
+		logger.FromContext(ctx).Error("failed to write audit record", "error", err)
+	}
+}
+
+// record returns a Record of the described DataRequest.
+func (o *Observer) record(info *handlers.RequestInfo) *Record {
+	rec := &Record{
+		Time:    info.Start.UTC(),
+		Queries: info.QueryNames(),
+		Status:  info.StatusCode,
+	}
+	if info.Principal != nil {
+		rec.Principal = info.Principal.Name
+	}
+	if info.HTTPRequest != nil {
+		rec.RemoteAddr = info.HTTPRequest.RemoteAddr
+	}
+	if val, ok := info.DataRequest.GlobalFilters[o.collectionKey]; ok {
+		if names, err := util.ExpectStringsValue(val); err == nil {
+			rec.Collections = names
+		} else if name, err := util.ExpectStringValue(val); err == nil {
+			rec.Collections = []string{name}
+		}
+	}
+	if info.Err != nil {
+		rec.Error = info.Err.Error()
+	}
+	return rec
+}
@@ -0,0 +1,173 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/handlers"
+	"github.com/google/traceviz/server/go/util"
+)
+
+var startTime = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// readRecords returns the Records in the provided JSON lines.
+func readRecords(t *testing.T, lines []byte) []*Record {
+	t.Helper()
+	var ret []*Record
+	scanner := bufio.NewScanner(bytes.NewReader(lines))
+	for scanner.Scan() {
+		rec := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			t.Fatalf("Failed to unmarshal audit record: %s", err)
+		}
+		ret = append(ret, rec)
+	}
+	return ret
+}
+
+func TestObserver(t *testing.T) {
+	buf := &bytes.Buffer{}
+	o := NewObserver(NewWriterSink(buf), "collection_name")
+	httpReq := httptest.NewRequest(http.MethodGet, "/GetData", nil)
+	httpReq.RemoteAddr = "10.0.0.1:1234"
+	for _, info := range []*handlers.RequestInfo{{
+		DataRequest: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				"collection_name": util.StringValue("log1"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName: "logs.raw_entries",
+			}, {
+				QueryName: "logs.timeseries",
+			}},
+		},
+		HTTPRequest: httpReq,
+		Principal:   &util.Principal{Name: "alice"},
+		Start:       startTime,
+		StatusCode:  http.StatusOK,
+	}, {
+		DataRequest: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				"collection_name": util.StringsValue("log1", "log2"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName: "logs.raw_entries",
+			}},
+		},
+		Start:      startTime.Add(time.Second),
+		StatusCode: http.StatusUnauthorized,
+		Err:        errors.New("unknown user"),
+	}} {
+		ctx := o.RequestStarted(context.Background(), info.DataRequest)
+		o.RequestFinished(ctx, info)
+	}
+	want := []*Record{{
+		Time:        startTime,
+		Principal:   "alice",
+		RemoteAddr:  "10.0.0.1:1234",
+		Collections: []string{"log1"},
+		Queries:     []string{"logs.raw_entries", "logs.timeseries"},
+		Status:      http.StatusOK,
+	}, {
+		Time:        startTime.Add(time.Second),
+		Collections: []string{"log1", "log2"},
+		Queries:     []string{"logs.raw_entries"},
+		Status:      http.StatusUnauthorized,
+		Error:       "unknown user",
+	}}
+	if diff := cmp.Diff(want, readRecords(t, buf.Bytes())); diff != "" {
+		t.Errorf("Audit records diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// Each sink appends to the file.
+	for idx := 0; idx < 2; idx++ {
+		sink, err := NewFileSink(path)
+		if err != nil {
+			t.Fatalf("NewFileSink() yielded unexpected error %s", err)
+		}
+		if err := sink.Write(context.Background(), &Record{
+			Time:    startTime.Add(time.Duration(idx) * time.Second),
+			Queries: []string{"logs.raw_entries"},
+		}); err != nil {
+			t.Fatalf("Write() yielded unexpected error %s", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Close() yielded unexpected error %s", err)
+		}
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %s", err)
+	}
+	want := []*Record{{
+		Time:    startTime,
+		Queries: []string{"logs.raw_entries"},
+	}, {
+		Time:    startTime.Add(time.Second),
+		Queries: []string{"logs.raw_entries"},
+	}}
+	if diff := cmp.Diff(want, readRecords(t, contents)); diff != "" {
+		t.Errorf("Audit records diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	var posted []byte
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		buf := &bytes.Buffer{}
+		buf.ReadFrom(req.Body)
+		posted = append(posted, buf.Bytes()...)
+		posted = append(posted, '\n')
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	sink := NewHTTPSink(server.URL, nil)
+	rec := &Record{
+		Time:        startTime,
+		Principal:   "alice",
+		Collections: []string{"log1"},
+		Queries:     []string{"logs.raw_entries"},
+		Status:      http.StatusOK,
+	}
+	if err := sink.Write(context.Background(), rec); err != nil {
+		t.Fatalf("Write() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff([]*Record{rec}, readRecords(t, posted)); diff != "" {
+		t.Errorf("Posted audit records diff (-want +got):\n%s", diff)
+	}
+	status = http.StatusServiceUnavailable
+	if err := sink.Write(context.Background(), rec); err == nil {
+		t.Errorf("Write() to a failing endpoint yielded no error, but expected one")
+	}
+}
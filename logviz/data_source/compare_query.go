@@ -0,0 +1,288 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/color"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+// expectCollectionNames returns the collection names specified by the
+// provided collection_name global filter value, which may be a single string
+// or a nonempty list of strings.
+func expectCollectionNames(val *util.V) ([]string, error) {
+	if val.T == util.StringsValueType {
+		names, err := util.ExpectStringsValue(val)
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("required filter option '%s' must not be empty", collectionNameKey)
+		}
+		return names, nil
+	}
+	name, err := util.ExpectStringValue(val)
+	if err != nil {
+		return nil, fmt.Errorf("required filter option '%s' must be a string or strings", collectionNameKey)
+	}
+	return []string{name}, nil
+}
+
+// comparedCollection is one of several collections being compared, with the
+// query filters resolved against it.
+type comparedCollection struct {
+	name string
+	coll *Collection
+	qf   *queryFilters
+}
+
+// handleComparisonRequests handles the provided DataSeriesRequests across
+// multiple collections, such as a good run and a bad run.  Global filters are
+// resolved independently against each collection.  Only the aggregate source
+// file table and timeseries queries support comparison.
+func (ds *DataSource) handleComparisonRequests(ctx context.Context, collectionNames []string, globalFilters map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	ccs := make([]*comparedCollection, 0, len(collectionNames))
+	for _, collectionName := range collectionNames {
+		coll, err := ds.fetchCollection(ctx, collectionName)
+		if err != nil {
+			return err
+		}
+		defer coll.release()
+		qf, err := filterFromGlobalFilters(ctx, coll.lt, globalFilters)
+		if err != nil {
+			return fmt.Errorf("collection '%s': %s", collectionName, err)
+		}
+		qf.redactor = ds.redactor
+		ccs = append(ccs, &comparedCollection{
+			name: collectionName,
+			coll: coll,
+			qf:   qf,
+		})
+	}
+	for _, req := range reqs {
+		series := drb.DataSeries(req)
+		var err error
+		switch req.QueryName {
+		case aggregateSourceFilesTableQuery:
+			err = handleSourceFileTableComparisonQuery(ccs, series, req.Options)
+		case timeseriesQuery:
+			err = handleTimeseriesComparisonQuery(ccs, series, req.Options)
+		default:
+			err = fmt.Errorf("query does not support comparing multiple collections")
+		}
+		if err != nil {
+			return fmt.Errorf("error handling data query %s: %s", req.QueryName, err)
+		}
+	}
+	return nil
+}
+
+// collectionEntriesCol returns the column holding entry counts from the named
+// collection in a source file comparison table.
+func collectionEntriesCol(collectionName string) *table.ColumnUpdate {
+	return table.Column(category.New(
+		entriesKey+"_"+collectionName,
+		"Entries\n"+collectionName,
+		fmt.Sprintf("The number of distinct log entries associated with this source file in '%s'", collectionName),
+	))
+}
+
+// collectionDeltaCol returns the column holding the difference between entry
+// counts in the named collection and in the first collection in a source file
+// comparison table.
+func collectionDeltaCol(collectionName, baseCollectionName string) *table.ColumnUpdate {
+	return table.Column(category.New(
+		deltaKey+"_"+collectionName,
+		"Delta\n"+collectionName,
+		fmt.Sprintf("The number of log entries associated with this source file in '%s', less the number in '%s'", collectionName, baseCollectionName),
+	))
+}
+
+// handleSourceFileTableComparisonQuery emits a table with one row per source
+// file appearing in any compared collection, with a column of entry counts for
+// each collection, and, for each collection after the first, a column of the
+// difference between its entry counts and the first's.
+func handleSourceFileTableComparisonQuery(ccs []*comparedCollection, tableDb util.DataBuilder, reqOpts map[string]*util.V) error {
+	var searchRegex *regexp.Regexp
+	for key, val := range reqOpts {
+		switch key {
+		case searchRegexKey:
+			searchRegexStr, err := util.ExpectStringValue(val)
+			if err != nil {
+				return err
+			}
+			if searchRegexStr != "" {
+				if searchRegex, err = regexp.Compile(searchRegexStr); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+	}
+	cols := []*table.ColumnUpdate{sourceFileCol}
+	entriesCols := make([]*table.ColumnUpdate, len(ccs))
+	deltaCols := make([]*table.ColumnUpdate, len(ccs))
+	for idx, cc := range ccs {
+		entriesCols[idx] = collectionEntriesCol(cc.name)
+		cols = append(cols, entriesCols[idx])
+	}
+	for idx, cc := range ccs[1:] {
+		deltaCols[idx+1] = collectionDeltaCol(cc.name, ccs[0].name)
+		cols = append(cols, deltaCols[idx+1])
+	}
+	// Count each collection's filtered-in entries by source file name.
+	entriesBySourceFile := map[string][]int64{}
+	for idx, cc := range ccs {
+		for _, sf := range cc.qf.sourceFiles {
+			if _, ok := entriesBySourceFile[sf.Filename]; !ok {
+				entriesBySourceFile[sf.Filename] = make([]int64, len(ccs))
+			}
+		}
+		if err := cc.qf.forEachEntry(cc.coll.lt, func(entry *logtrace.Entry) error {
+			sf := entry.SourceLocation.SourceFile
+			if searchRegex != nil && !searchRegex.MatchString(sf.DisplayName()) {
+				return nil
+			}
+			entries, ok := entriesBySourceFile[sf.Filename]
+			if !ok {
+				entries = make([]int64, len(ccs))
+				entriesBySourceFile[sf.Filename] = entries
+			}
+			entries[idx]++
+			return nil
+		}, cc.qf.filters(timeFilters)); err != nil {
+			return err
+		}
+	}
+	sourceFiles := make([]string, 0, len(entriesBySourceFile))
+	for sourceFile := range entriesBySourceFile {
+		sourceFiles = append(sourceFiles, sourceFile)
+	}
+	sort.Strings(sourceFiles)
+	// Emit the data series as a table.
+	t := table.New(tableDb, renderSettings, cols...)
+	for _, sourceFile := range sourceFiles {
+		entries := entriesBySourceFile[sourceFile]
+		cells := []table.CellUpdate{
+			table.Cell(sourceFileCol, util.String(sourceFile)),
+		}
+		for idx := range ccs {
+			cells = append(cells, table.Cell(entriesCols[idx], util.Integer(entries[idx])))
+		}
+		for idx := 1; idx < len(ccs); idx++ {
+			cells = append(cells, table.Cell(deltaCols[idx], util.Integer(entries[idx]-entries[0])))
+		}
+		t.Row(cells...).With(
+			util.StringProperty(sourceFileKey, sourceFile),
+			color.Secondary(highlightColor),
+		)
+	}
+	return nil
+}
+
+// handleTimeseriesComparisonQuery emits an xy chart with one series per
+// compared collection, showing its rate of filtered-in entries over time.
+// Since compared collections generally cover different time ranges, the x
+// axis is the time since the start of each collection's filtered range.
+func handleTimeseriesComparisonQuery(ccs []*comparedCollection, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	// Handle query parameters.
+	var binCount, viewportWidthPx int64
+	var err error
+	for key, val := range reqOpts {
+		switch key {
+		case binCountKey:
+			binCount, err = util.ExpectIntegerValue(val)
+		case viewportWidthPxKey:
+			viewportWidthPx, err = util.ExpectIntegerValue(val)
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	binCount, err = timeseriesBinCount(binCount, viewportWidthPx)
+	if err != nil {
+		return err
+	}
+	// All collections share the bin width suiting the longest filtered range.
+	var totalWidth time.Duration
+	for _, cc := range ccs {
+		if cc.qf.duration() > totalWidth {
+			totalWidth = cc.qf.duration()
+		}
+	}
+	// As in the single-collection timeseries, the last bin only contains
+	// entries at the end of the longest range.
+	binWidth := totalWidth / time.Duration(binCount-1)
+	if binWidth == 0 {
+		binWidth = 1
+	}
+	binNormalization, binNormalizationLabel := timeseriesBinNormalization(binWidth)
+	pointsByCollection := make([][]float64, len(ccs))
+	var yAxisMax float64
+	seriesColorSpaces := make([]util.PropertyUpdate, len(ccs))
+	for idx, cc := range ccs {
+		seriesColorSpaces[idx] = idToColorSpace(cc.name).Define()
+		points := make([]float64, binCount)
+		if err := cc.qf.forEachEntry(cc.coll.lt, func(entry *logtrace.Entry) error {
+			points[binIndex(entry.Time, cc.qf.startTimestamp, binWidth, binCount)]++
+			return nil
+		}, cc.qf.filters(timeFilters, sourceFileFilter)); err != nil {
+			return err
+		}
+		for bin := range points {
+			points[bin] /= binNormalization
+			if points[bin] > yAxisMax {
+				yAxisMax = points[bin]
+			}
+		}
+		pointsByCollection[idx] = points
+	}
+	// Emit the series data.
+	chart := xychart.New(series,
+		continuousaxis.NewDurationAxis(
+			category.New("x_axis", "Time from start", "Time from the start of each log"),
+			0, totalWidth),
+		continuousaxis.NewDoubleAxis(
+			category.New("y_axis", "Messages per "+binNormalizationLabel, "Log messages per "+binNormalizationLabel),
+			0, yAxisMax), seriesColorSpaces...).With(
+		xAxisRenderSettings.Apply(),
+		yAxisRenderSettings.Apply(),
+	)
+	for idx, cc := range ccs {
+		timeseries := chart.AddSeries(
+			category.New(cc.name, cc.name, cc.name),
+			idToColorSpace(cc.name).PrimaryColor(1.0),
+		)
+		binLow := time.Duration(0)
+		for _, point := range pointsByCollection[idx] {
+			timeseries.WithPoint(binLow, point)
+			binLow += binWidth
+		}
+	}
+	return nil
+}
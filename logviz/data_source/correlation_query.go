@@ -0,0 +1,107 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datasource
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// handleByCorrelationIDQuery emits all entries with the requested correlation
+// ID, across all files and processes and irrespective of global filters, as a
+// trace.  Each process has a category holding a single span from its first to
+// its last such entry, within which each entry is an instantaneous subspan.
+func handleByCorrelationIDQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	// Handle query parameters.
+	var correlationID string
+	var err error
+	for key, val := range reqOpts {
+		switch key {
+		case correlationIDKey:
+			correlationID, err = util.ExpectStringValue(val)
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if correlationID == "" {
+		return fmt.Errorf("correlation query requires option '%s'", correlationIDKey)
+	}
+	entriesByProcessID := map[string][]*logtrace.Entry{}
+	var startTimestamp, endTimestamp time.Time
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
+		if startTimestamp.IsZero() {
+			startTimestamp = entry.Time
+		}
+		endTimestamp = entry.Time
+		id := processID(entry)
+		entriesByProcessID[id] = append(entriesByProcessID[id], entry)
+		return nil
+	}, logtrace.WithCorrelationIDs(correlationID)); err != nil {
+		return err
+	}
+	if len(entriesByProcessID) == 0 {
+		return fmt.Errorf("no log entries have correlation ID '%s'", correlationID)
+	}
+	processIDs := make([]string, 0, len(entriesByProcessID))
+	for id := range entriesByProcessID {
+		processIDs = append(processIDs, id)
+	}
+	sort.Strings(processIDs)
+	t := trace.New[time.Time](
+		series,
+		continuousaxis.NewTimestampAxis(
+			category.New("x_axis", "Time", "Time of correlated log entries"),
+			startTimestamp, endTimestamp),
+		traceRenderSettings).With(
+		xAxisRenderSettings.Apply(),
+		util.StringProperty(correlationIDKey, correlationID),
+		colorSpacesByLevelWeight[0].Define(),
+		colorSpacesByLevelWeight[1].Define(),
+		colorSpacesByLevelWeight[2].Define(),
+		colorSpacesByLevelWeight[3].Define(),
+	)
+	for _, id := range processIDs {
+		entries := entriesByProcessID[id]
+		span := t.Category(
+			category.New(id, id, fmt.Sprintf("Log entries with correlation ID %s in process %s", correlationID, id)),
+			util.StringProperty(processKey, id),
+		).Span(entries[0].Time, entries[len(entries)-1].Time,
+			util.IntegerProperty(entriesKey, int64(len(entries))),
+		)
+		for _, entry := range entries {
+			var primaryColor util.PropertyUpdate
+			if coloring := colorSpacesByLevelWeight[entry.Level.Weight]; coloring != nil {
+				primaryColor = coloring.PrimaryColor(1)
+			}
+			span.Subspan(entry.Time, entry.Time,
+				util.StringProperty(levelNameKey, entry.Level.DisplayName()),
+				util.StringProperty(sourceLocNameKey, entry.SourceLocation.DisplayName()),
+				util.StringProperty(sourceFileKey, entry.SourceLocation.SourceFile.Identifier()),
+				util.StringsProperty(messageKey, entry.Message...),
+				primaryColor,
+			)
+		}
+	}
+	return nil
+}
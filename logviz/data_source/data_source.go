@@ -21,9 +21,12 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/logviz/annotations"
+	"github.com/google/traceviz/logviz/logger"
 	"github.com/google/traceviz/server/go/category"
 	"github.com/google/traceviz/server/go/color"
 	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
@@ -38,6 +41,15 @@ const (
 	timeseriesQuery                = "logs.timeseries"
 	traceQuery                     = "logs.trace"
 	panAndZoomQuery                = "logs.pan_and_zoom"
+	sourceTreeQuery                = "logs.source_tree"
+	processTraceQuery              = "logs.process_trace"
+	gapHistogramQuery              = "logs.gap_histogram"
+	topSourcesQuery                = "logs.top_sources"
+	entryDetailsQuery              = "logs.entry_details"
+	patternsQuery                  = "logs.patterns"
+	byCorrelationIDQuery           = "logs.by_correlation_id"
+	sourceLocTimeseriesQuery       = "logs.source_location_timeseries"
+	processLifetimesQuery          = "logs.process_lifetimes"
 
 	collectionNameKey      = "collection_name"
 	endTimestampKey        = "end_timestamp"
@@ -54,9 +66,45 @@ const (
 	timestampKey           = "timestamp"
 	panKey                 = "pan"
 	zoomKey                = "zoom"
+	sourcePathKey          = "source_path"
+	processKey             = "process"
+	labelKey               = "label"
+	fractionKey            = "fraction"
+	logKey                 = "log"
+	selectedKey            = "selected"
+	deltaKey               = "delta"
+	repetitionsKey         = "repetitions"
+	firstTimestampKey      = "first_timestamp"
+	lastTimestampKey       = "last_timestamp"
+	templateKey            = "template"
+	trendKey               = "trend"
+	examplesKey            = "examples"
+	anomalyKey             = "anomaly"
+	baselineKey            = "baseline"
+	sigmasKey              = "sigmas"
+	correlationIDKey       = "correlation_id"
+	explicitStartKey       = "explicit_start"
+	explicitStopKey        = "explicit_stop"
+	annotationIDsKey       = "annotation_ids"
+	annotationNotesKey     = "annotation_notes"
 
-	aggregateByKey = "aggregate_by"
-	binCountKey    = "bin_count"
+	aggregateByKey     = "aggregate_by"
+	anomalySigmaKey    = "anomaly_sigma"
+	anomalyWindowKey   = "anomaly_window"
+	binCountKey        = "bin_count"
+	contextEntriesKey  = "context_entries"
+	expandSourceLocKey = "expand_source_locations"
+	foldRepetitionsKey = "fold_repetitions"
+	gapThresholdKey    = "gap_threshold"
+	maxExamplesKey     = "max_examples"
+	maxNodesKey        = "max_nodes"
+	skipEmptyBinsKey   = "skip_empty_bins"
+	startRegexKey      = "start_regex"
+	stopRegexKey       = "stop_regex"
+	topKKey            = "top_k"
+	valueAggregateKey  = "value_aggregate"
+	valueFieldKey      = "value_field"
+	viewportWidthPxKey = "viewport_width_px"
 )
 
 const (
@@ -71,6 +119,28 @@ const (
 	maxZoom    = 50
 )
 
+// fieldAggregationPrefix prefixes aggregation types that group entries by the
+// value of a derived field, e.g. 'field:rpc_method'.
+const fieldAggregationPrefix = "field:"
+
+// derivedFieldOf returns, if the provided aggregation type names a derived
+// field, that field's name and a function returning an Entry's value of it,
+// and true.  Entries lacking the field should be filtered out with
+// logtrace.WithFields.
+func derivedFieldOf(aggregateBy string) (string, func(entry *logtrace.Entry) string, bool) {
+	name, ok := strings.CutPrefix(aggregateBy, fieldAggregationPrefix)
+	if !ok || name == "" {
+		return "", nil, false
+	}
+	return name, func(entry *logtrace.Entry) string {
+		return entry.Fields[name]
+	}, true
+}
+
+// CollectionNameKey is the global filter naming the collection, or
+// collections, to query.
+const CollectionNameKey = collectionNameKey
+
 // queryFilters is a collection of filters assembled by filterFromGlobalFilters
 // once per DataRequest, prior to handling any individual DataSeriesRequest.
 type queryFilters struct {
@@ -80,6 +150,13 @@ type queryFilters struct {
 	// The filtered-in set of source files; empty means no filter.  Defaults to
 	// empty.
 	sourceFiles []*logtrace.SourceFile
+	// The Context of the DataRequest; iteration over entries stops once it is
+	// done.
+	ctx context.Context
+	// The annotations overlapping the filtered-in time range.
+	annotations []*annotations.Annotation
+	// If non-nil, masks sensitive content in entries before they are visited.
+	redactor logtrace.Redactor
 }
 
 func (qf *queryFilters) duration() time.Duration {
@@ -108,6 +185,54 @@ func (qf *queryFilters) filters(filterBys ...filterBy) logtrace.Filter {
 	return logtrace.ConcatenateFilters(ret...)
 }
 
+// forEachEntry invokes the provided callback on each entry in the provided
+// LogTrace satisfying the provided Filters, as LogTrace.ForEachEntry does,
+// but stops, returning its error, once the receiver's Context is done.
+func (qf *queryFilters) forEachEntry(lt *logtrace.LogTrace, fn func(entry *logtrace.Entry) error, fs ...logtrace.Filter) error {
+	return lt.ForEachEntry(util.WithCtxChecks(qf.ctx, qf.redacting(fn)), fs...)
+}
+
+// forEachEntryParallel invokes the callbacks returned by newShard on the
+// entries in the provided LogTrace satisfying the provided Filters, as
+// LogTrace.ForEachEntryParallel does across all available cores, but stops,
+// returning its error, once the receiver's Context is done.
+func (qf *queryFilters) forEachEntryParallel(lt *logtrace.LogTrace, newShard func() func(entry *logtrace.Entry) error, fs ...logtrace.Filter) error {
+	return lt.ForEachEntryParallel(0, func() func(entry *logtrace.Entry) error {
+		return util.WithCtxChecks(qf.ctx, qf.redacting(newShard()))
+	}, fs...)
+}
+
+// redacting returns the provided entry callback, invoked instead with
+// redacted copies of entries if the receiver has a redactor.
+func (qf *queryFilters) redacting(fn func(entry *logtrace.Entry) error) func(entry *logtrace.Entry) error {
+	if qf.redactor == nil {
+		return fn
+	}
+	return func(entry *logtrace.Entry) error {
+		return fn(logtrace.Redact(entry, qf.redactor))
+	}
+}
+
+// annotationMarkers returns a PropertyUpdate marking the IDs and notes of the
+// receiver's annotations overlapping the provided inclusive time range, or
+// util.EmptyUpdate if there are none.
+func (qf *queryFilters) annotationMarkers(start, end time.Time) util.PropertyUpdate {
+	var ids, notes []string
+	for _, a := range qf.annotations {
+		if a.Overlaps(start, end) {
+			ids = append(ids, a.ID)
+			notes = append(notes, a.Note)
+		}
+	}
+	if len(ids) == 0 {
+		return util.EmptyUpdate
+	}
+	return util.Chain(
+		util.StringsProperty(annotationIDsKey, ids...),
+		util.StringsProperty(annotationNotesKey, notes...),
+	)
+}
+
 func (qf *queryFilters) clampTimerange(lt *logtrace.LogTrace) {
 	startTs, endTs := lt.TimeRange()
 	if qf.startTimestamp.Before(startTs) {
@@ -118,10 +243,26 @@ func (qf *queryFilters) clampTimerange(lt *logtrace.LogTrace) {
 	}
 }
 
+// echoGlobalFilters reports, in the provided DataResponseBuilder, the time
+// range filters actually applied, if they differ from those requested because
+// of defaulting, clamping, panning, or zooming.  Any requested pan or zoom is
+// reset, since it has already been applied to the reported time range.
+func (qf *queryFilters) echoGlobalFilters(requested map[string]*util.V, drb *util.DataResponseBuilder) {
+	drb.SetGlobalFilterIfChanged(requested, startTimestampKey, util.TimestampValue(qf.startTimestamp))
+	drb.SetGlobalFilterIfChanged(requested, endTimestampKey, util.TimestampValue(qf.endTimestamp))
+	for _, key := range []string{panKey, zoomKey} {
+		if _, ok := requested[key]; ok {
+			drb.SetGlobalFilterIfChanged(requested, key, util.StringValue(none))
+		}
+	}
+}
+
 // filterFromGlobalFilters returns a queryFilters constructed from the provided
-// TraceViz DataRequest global filters key-value map.
-func filterFromGlobalFilters(lt *logtrace.LogTrace, options map[string]*util.V) (*queryFilters, error) {
-	qf := &queryFilters{}
+// TraceViz DataRequest Context and global filters key-value map.
+func filterFromGlobalFilters(ctx context.Context, lt *logtrace.LogTrace, options map[string]*util.V) (*queryFilters, error) {
+	qf := &queryFilters{
+		ctx: ctx,
+	}
 	var err error
 	// Populate the filtered timestamps.
 	startTs, endTs := lt.TimeRange()
@@ -207,42 +348,237 @@ func filterFromGlobalFilters(lt *logtrace.LogTrace, options map[string]*util.V)
 // name.
 type LogTraceFetcher interface {
 	// FetchLog fetches the log specified by collectionName, returning a
-	// LogTrace or an error if a failure is encountered.
+	// LogTrace or an error if a failure is encountered.  Fetchers caching
+	// Collections should close them as they evict them.
 	Fetch(ctx context.Context, collectionName string) (*Collection, error)
 }
 
+// WatchingLogTraceFetcher describes LogTraceFetchers that can also observe
+// changes to the collections they fetch, e.g. logs still being written.
+type WatchingLogTraceFetcher interface {
+	LogTraceFetcher
+	// Changed returns a channel that is closed when the specified collection
+	// next changes, or nil if changes to it cannot be observed.  Once the
+	// channel is closed, Fetch should return the changed collection.  Any
+	// state used to watch for changes should be released once the provided
+	// Context is done.
+	Changed(ctx context.Context, collectionName string) (<-chan struct{}, error)
+}
+
+// CollectionObserver is notified as collections are loaded into, and evicted
+// from, a collection cache.  *querydispatcher.QueryDispatcher is a
+// CollectionObserver, forwarding these notifications to its data sources.
+type CollectionObserver interface {
+	CollectionLoaded(collectionName string, collection any)
+	CollectionEvicted(collectionName string)
+}
+
 // collection represents a single fetched log trace, along with any metadata it
 // requires.
 type Collection struct {
 	lt *logtrace.LogTrace
+	// The maximum number of entries a timeseries query will visit individually.
+	// Queries over more entries than this are downsampled.
+	maxTimeseriesEntries int
+	// The number of entries above which timeseries queries are answered from
+	// lt's time buckets, where possible, rather than by visiting each entry.
+	minAggregatedEntries int
+
+	mu sync.Mutex
+	// The number of in-flight queries using lt.
+	refs int
+	// If true, the Collection has been closed, and lt is closed once refs
+	// reaches zero.
+	closed bool
 }
 
+// NewCollection returns a new Collection over the provided LogTrace.  If the
+// LogTrace is indexed, timeseries queries over very many entries are answered
+// from its time buckets rather than by visiting each entry.
 func NewCollection(lt *logtrace.LogTrace) *Collection {
 	return &Collection{
-		lt: lt,
+		lt:                   lt,
+		maxTimeseriesEntries: defaultMaxTimeseriesEntries,
+		minAggregatedEntries: defaultMinAggregatedEntries,
+	}
+}
+
+// timeBucketsFor returns the coarsest of the receiver's time bucket widths
+// that may be aggregated into bins of the specified width with little error,
+// or nil if there is none.
+func (c *Collection) timeBucketsFor(binWidth time.Duration) *logtrace.TimeBuckets {
+	var ret *logtrace.TimeBuckets
+	for _, tb := range c.lt.TimeBuckets() {
+		if binWidth >= minAggregatedBinBuckets*tb.Width {
+			ret = tb
+		}
+	}
+	return ret
+}
+
+// acquire marks the receiver as in use by a query, so that it is not closed
+// until the query calls release.  Returns false if the receiver has been
+// closed, in which case release must not be called.
+func (c *Collection) acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.refs++
+	return true
+}
+
+// release marks a query that acquired the receiver as no longer using it.
+func (c *Collection) release() {
+	c.mu.Lock()
+	c.refs--
+	closeLT := c.closed && c.refs == 0
+	c.mu.Unlock()
+	if closeLT {
+		c.lt.Close()
+	}
+}
+
+// Close releases the receiver's LogTrace, and any on-disk store backing it,
+// once no query is using it.  The receiver must not be used once it is
+// closed; caches should close Collections as they evict them.
+func (c *Collection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
 	}
+	c.closed = true
+	if c.refs > 0 {
+		return nil
+	}
+	return c.lt.Close()
+}
+
+// MemoryUsage returns the estimated resident memory held by the receiver's
+// LogTrace.
+func (c *Collection) MemoryUsage() logtrace.MemoryUsage {
+	return c.lt.MemoryUsage()
 }
 
 // DataSource implements querydispatcher.dataSource for logs data.  It caches
 // the most recently used logs.
 type DataSource struct {
-	// An LRU cache holding the most recently-accessed logs.
+	// Guards lru and evictions.
+	mu sync.Mutex
+	// An LRU cache holding the most recently-accessed logs.  If nil, logs are
+	// not cached, and are fetched for every request.
 	lru *simplelru.LRU
+	// The collections evicted from lru while mu is held.  These are closed,
+	// and reported to observer, once mu is released.
+	evictions []eviction
 	// A log fetcher used to fetch uncached logs.
 	fetcher LogTraceFetcher
+	// If non-nil, notified as logs are added to, and evicted from, lru.
+	observer CollectionObserver
+	// If non-nil, the annotations marked in timeseries and raw entries
+	// responses.
+	annotations *annotations.Store
+	// If non-nil, masks sensitive content in entries before they are used to
+	// answer queries.
+	redactor logtrace.Redactor
 }
 
 // New returns a new DataSource with the specified cache capacity, and using
-// the provided log fetcher.
+// the provided log fetcher.  If cap is zero, the DataSource does not cache
+// logs; this is useful when the fetcher does its own caching.  Otherwise, the
+// DataSource closes the Collections it evicts.
 func New(cap int, fetcher LogTraceFetcher) (*DataSource, error) {
-	lru, err := simplelru.NewLRU(cap /*no onEvict policy*/, nil)
-	if err != nil {
-		return nil, err
-	}
-	return &DataSource{
-		lru:     lru,
+	ds := &DataSource{
 		fetcher: fetcher,
-	}, nil
+	}
+	if cap != 0 {
+		lru, err := simplelru.NewLRU(cap, ds.evicted)
+		if err != nil {
+			return nil, err
+		}
+		ds.lru = lru
+	}
+	return ds, nil
+}
+
+// ObserveCollections configures the receiver to notify the provided
+// CollectionObserver as logs are added to, and evicted from, its cache.  If
+// the receiver does not cache logs, its fetcher is responsible for such
+// notifications.
+func (ds *DataSource) ObserveCollections(observer CollectionObserver) *DataSource {
+	ds.observer = observer
+	return ds
+}
+
+// WithAnnotations configures the receiver to mark, in its timeseries and raw
+// entries responses, the annotations in the provided Store overlapping each
+// point, gap, and entry, and returns the receiver.  Marked items carry the
+// overlapping annotations' IDs, as 'annotation_ids', and notes, as
+// 'annotation_notes'.
+func (ds *DataSource) WithAnnotations(store *annotations.Store) *DataSource {
+	ds.annotations = store
+	return ds
+}
+
+// WithRedactor configures the receiver to mask sensitive content in log
+// entries' messages and derived fields, using the provided Redactor, as it
+// answers queries, and returns the receiver.  Searches, patterns, and field
+// values all see only the redacted content.  Logs may instead be redacted once
+// as they are parsed, via logtrace.Options.Redactor.
+func (ds *DataSource) WithRedactor(r logtrace.Redactor) *DataSource {
+	ds.redactor = r
+	return ds
+}
+
+// eviction is a Collection evicted from a DataSource's cache.
+type eviction struct {
+	collectionName string
+	coll           *Collection
+}
+
+// evicted is the receiver's cache eviction callback, invoked with mu held.
+func (ds *DataSource) evicted(key, value any) {
+	coll, _ := value.(*Collection)
+	ds.evictions = append(ds.evictions, eviction{
+		collectionName: key.(string),
+		coll:           coll,
+	})
+}
+
+// unlock releases mu, then closes the collections evicted while it was held,
+// each once no query is using it, and reports their eviction.
+func (ds *DataSource) unlock() {
+	evictions := ds.evictions
+	ds.evictions = nil
+	ds.mu.Unlock()
+	for _, ev := range evictions {
+		if ev.coll != nil {
+			ev.coll.Close()
+		}
+		if ds.observer != nil {
+			ds.observer.CollectionEvicted(ev.collectionName)
+		}
+	}
+}
+
+// uncache removes the named collection from the receiver's cache, if it is
+// there.
+func (ds *DataSource) uncache(collectionName string) {
+	if ds.lru == nil {
+		return
+	}
+	ds.mu.Lock()
+	defer ds.unlock()
+	ds.lru.Remove(collectionName)
+}
+
+// OnCollectionEvicted removes the named collection from the receiver's cache,
+// if it is there, so that a collection evicted from its fetcher's cache, for
+// instance because it changed, is not served stale.
+func (ds *DataSource) OnCollectionEvicted(collectionName string) {
+	ds.uncache(collectionName)
 }
 
 // SupportedDataSeriesQueries returns the DataSeriesRequest query names
@@ -254,62 +590,200 @@ func (ds *DataSource) SupportedDataSeriesQueries() []string {
 		timeseriesQuery,
 		traceQuery,
 		panAndZoomQuery,
+		sourceTreeQuery,
+		processTraceQuery,
+		gapHistogramQuery,
+		topSourcesQuery,
+		entryDetailsQuery,
+		patternsQuery,
+		byCorrelationIDQuery,
+		sourceLocTimeseriesQuery,
+		processLifetimesQuery,
 	}
 }
 
-// fetchCollection returns the specified collection from the LRU if it's
-// present there.  If it isn't already in the LRU, it is fetched and added to
-// the LRU before being returned.
+// The number of times a collection is fetched before giving up if each
+// fetched Collection is closed, by eviction, before it can be acquired.
+const maxFetchAttempts = 3
+
+// fetchCollection returns the specified collection, acquired for use by a
+// query; the caller must release it when done.  Collections closed before
+// they can be acquired are fetched again.
 func (ds *DataSource) fetchCollection(ctx context.Context, collectionName string) (*Collection, error) {
-	collIf, ok := ds.lru.Get(collectionName)
-	if ok {
-		coll, ok := collIf.(*Collection)
-		if !ok {
-			return nil, fmt.Errorf("fetched collection didn't contain a LogTrace")
+	for attempt := 0; attempt < maxFetchAttempts; attempt++ {
+		coll, err := ds.fetchCachedCollection(ctx, collectionName)
+		if err != nil {
+			return nil, err
 		}
-		return coll, nil
+		if coll.acquire() {
+			return coll, nil
+		}
+	}
+	return nil, fmt.Errorf("collection '%s' was repeatedly evicted while being fetched", collectionName)
+}
+
+// cached returns the specified collection from the LRU, if it's present
+// there.
+func (ds *DataSource) cached(collectionName string) (*Collection, bool, error) {
+	ds.mu.Lock()
+	defer ds.unlock()
+	collIf, ok := ds.lru.Get(collectionName)
+	if !ok {
+		return nil, false, nil
+	}
+	coll, ok := collIf.(*Collection)
+	if !ok {
+		return nil, false, fmt.Errorf("fetched collection didn't contain a LogTrace")
+	}
+	return coll, true, nil
+}
+
+// fetchCachedCollection returns the specified collection from the LRU if it's
+// present there.  If it isn't already in the LRU, it is fetched and added to
+// the LRU before being returned.  The LRU is not locked while fetching, so
+// concurrent queries may fetch the same collection; the first fetched is
+// cached and returned to all of them, and the others are closed.
+func (ds *DataSource) fetchCachedCollection(ctx context.Context, collectionName string) (*Collection, error) {
+	if ds.lru == nil {
+		return ds.fetcher.Fetch(ctx, collectionName)
+	}
+	if coll, ok, err := ds.cached(collectionName); err != nil || ok {
+		return coll, err
 	}
 	coll, err := ds.fetcher.Fetch(ctx, collectionName)
 	if err != nil {
 		return nil, err
 	}
+	ds.mu.Lock()
+	if existingIf, ok := ds.lru.Get(collectionName); ok {
+		ds.unlock()
+		existing, ok := existingIf.(*Collection)
+		if !ok {
+			return nil, fmt.Errorf("fetched collection didn't contain a LogTrace")
+		}
+		// Another query fetched and cached the collection first.
+		if existing != coll {
+			coll.Close()
+		}
+		return existing, nil
+	}
 	ds.lru.Add(collectionName, coll)
+	ds.unlock()
+	if ds.observer != nil {
+		ds.observer.CollectionLoaded(collectionName, coll)
+	}
 	return coll, nil
 }
 
+// Warmup fetches the collections named by the provided hints'
+// 'collection_name', so that they are parsed, indexed, summarized, and cached
+// before they are first queried.
+func (ds *DataSource) Warmup(ctx context.Context, hints map[string]*util.V) error {
+	collectionNameVal, ok := hints[collectionNameKey]
+	if !ok {
+		return fmt.Errorf("missing required filter option '%s'", collectionNameKey)
+	}
+	collectionNames, err := expectCollectionNames(collectionNameVal)
+	if err != nil {
+		return err
+	}
+	for idx, collectionName := range collectionNames {
+		coll, err := ds.fetchCollection(ctx, collectionName)
+		if err != nil {
+			return fmt.Errorf("collection '%s': %s", collectionName, err)
+		}
+		coll.release()
+		util.ReportProgress(ctx, float64(idx+1)/float64(len(collectionNames)))
+	}
+	return nil
+}
+
+// Changed returns a channel that is closed when any collection named by the
+// provided global filters next changes, or nil if the DataSource's fetcher
+// cannot observe changes to them.  Changed collections are evicted from the
+// DataSource's cache.
+func (ds *DataSource) Changed(ctx context.Context, globalFilters map[string]*util.V) (<-chan struct{}, error) {
+	wf, ok := ds.fetcher.(WatchingLogTraceFetcher)
+	if !ok {
+		return nil, nil
+	}
+	collectionNameVal, ok := globalFilters[collectionNameKey]
+	if !ok {
+		return nil, fmt.Errorf("missing required filter option '%s'", collectionNameKey)
+	}
+	collectionNames, err := expectCollectionNames(collectionNameVal)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(chan struct{})
+	var once sync.Once
+	watched := false
+	for _, collectionName := range collectionNames {
+		ch, err := wf.Changed(ctx, collectionName)
+		if err != nil {
+			return nil, err
+		}
+		if ch == nil {
+			continue
+		}
+		watched = true
+		go func(collectionName string, ch <-chan struct{}) {
+			select {
+			case <-ch:
+				ds.uncache(collectionName)
+				once.Do(func() { close(ret) })
+			case <-ctx.Done():
+			}
+		}(collectionName, ch)
+	}
+	if !watched {
+		return nil, nil
+	}
+	return ret, nil
+}
+
 // HandleDataSeriesRequests handles the provided set of DataSeriesRequests, with
 // the provided global filters.  It assembles its responses in the provided
 // DataResponseBuilder.
 func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalFilters map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
 	// Log how long it takes to handle each DataRequest.
 	start := time.Now()
-	queryNames := make([]string, 0, len(reqs))
-	for _, req := range reqs {
-		queryNames = append(queryNames, req.QueryName)
-	}
 	defer func() {
-		fmt.Printf("Handled [%s] queries in %s\n", strings.Join(queryNames, ", "), time.Since(start))
+		logger.FromContext(ctx).Debug("handled data series requests", "series_count", len(reqs), "duration", time.Since(start))
 	}()
 	// Pull the collection name from the global filters.
 	collectionNameVal, ok := globalFilters[collectionNameKey]
 	if !ok {
 		return fmt.Errorf("missing required filter option '%s'", collectionNameKey)
 	}
-	collectionName, err := util.ExpectStringValue(collectionNameVal)
+	collectionNames, err := expectCollectionNames(collectionNameVal)
 	if err != nil {
-		return fmt.Errorf("required filter option '%s' must be a string", collectionNameKey)
+		return err
+	}
+	if len(collectionNames) > 1 {
+		return ds.handleComparisonRequests(ctx, collectionNames, globalFilters, drb, reqs)
 	}
 	// Fetch the collection, from the cache if it's there.
-	coll, err := ds.fetchCollection(ctx, collectionName)
+	util.BeginPhase(ctx, "fetch")
+	coll, err := ds.fetchCollection(ctx, collectionNames[0])
 	if err != nil {
 		return err
 	}
+	defer coll.release()
 	// Build the queryFilters, just once, for all DataSeriesRequests.
-	qf, err := filterFromGlobalFilters(coll.lt, globalFilters)
+	util.BeginPhase(ctx, "filter")
+	qf, err := filterFromGlobalFilters(ctx, coll.lt, globalFilters)
 	if err != nil {
 		return err
 	}
-	// Handle each DataSeriesRequest.  Can be parallelized.
+	qf.echoGlobalFilters(globalFilters, drb)
+	qf.redactor = ds.redactor
+	if ds.annotations != nil {
+		qf.annotations = ds.annotations.Overlapping(collectionNames[0], qf.startTimestamp, qf.endTimestamp)
+	}
+	// Handle each DataSeriesRequest.  Can be parallelized.  Handlers may
+	// separately report building their series' data after aggregating.
+	util.BeginPhase(ctx, "aggregate")
 	for _, req := range reqs {
 		series := drb.DataSeries(req)
 		var err error
@@ -324,6 +798,24 @@ func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalFilter
 			err = handleTraceQuery(coll, qf, series, req.Options)
 		case panAndZoomQuery:
 			err = handlePanAndZoomQuery(coll, qf, series, req.Options)
+		case sourceTreeQuery:
+			err = handleSourceTreeQuery(coll, qf, series, req.Options)
+		case processTraceQuery:
+			err = handleProcessTraceQuery(coll, qf, series, req.Options)
+		case gapHistogramQuery:
+			err = handleGapHistogramQuery(coll, qf, series, req.Options)
+		case topSourcesQuery:
+			err = handleTopSourcesQuery(coll, qf, series, req.Options)
+		case entryDetailsQuery:
+			err = handleEntryDetailsQuery(coll, qf, series, req.Options)
+		case patternsQuery:
+			err = handlePatternsQuery(coll, qf, series, req.Options)
+		case byCorrelationIDQuery:
+			err = handleByCorrelationIDQuery(coll, qf, series, req.Options)
+		case sourceLocTimeseriesQuery:
+			err = handleSourceLocTimeseriesQuery(coll, qf, series, req.Options)
+		case processLifetimesQuery:
+			err = handleProcessLifetimesQuery(coll, qf, series, req.Options)
 		default:
 			err = fmt.Errorf("unsupported data query")
 		}
@@ -338,10 +830,10 @@ func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalFilter
 type sourceFileData struct {
 	// The source file.
 	sourceFile *logtrace.SourceFile
-	// A set of unique source line granularities.  Since a single sourceFileData
-	// concerns only one source file, the size of this set is also the number
-	// of distinct source lines.
-	lines map[int]struct{}
+	// A mapping from unique source line granularities to the data for that
+	// source location.  Since a single sourceFileData concerns only one source
+	// file, the size of this map is also the number of distinct source lines.
+	lines map[int]*sourceLocData
 	// The number of entries associated with this source file.
 	entries int
 	// A mapping from log Level to the number of entries for this source file at
@@ -351,6 +843,17 @@ type sourceFileData struct {
 	levelColumns map[*logtrace.Level]*table.ColumnUpdate
 }
 
+// sourceLocData helps aggregate log data at source-location granularity.
+type sourceLocData struct {
+	// The source location.
+	sourceLoc *logtrace.SourceLocation
+	// The number of entries associated with this source location.
+	entries int
+	// A mapping from log Level to the number of entries for this source
+	// location at that level.
+	entriesAtLevel map[*logtrace.Level]int
+}
+
 var (
 	sourceFileCol     = table.Column(category.New(sourceFileKey, "Source\nFile", "The logging source file"))
 	sourceLocCountCol = table.Column(category.New(sourceLocCountKey, "Source\nLocations", "The number of distinct source locations (logging lines) in this source file"))
@@ -385,6 +888,34 @@ func (sfd *sourceFileData) row(levels []*levelInfo) []table.CellUpdate {
 	return cells
 }
 
+// row returns a set of cells comprising the receiver's table row, nested
+// beneath its source file's row.
+func (sld *sourceLocData) row(levels []*levelInfo) []table.CellUpdate {
+	cells := []table.CellUpdate{
+		table.Cell(sourceFileCol, util.String(sld.sourceLoc.DisplayName())),
+		table.Cell(entriesCol, util.Integer(int64(sld.entries))),
+	}
+	for _, levelInfo := range levels {
+		if entriesAtLevel, ok := sld.entriesAtLevel[levelInfo.level]; ok {
+			cells = append(cells, table.Cell(levelInfo.column, util.Integer(int64(entriesAtLevel))))
+		}
+	}
+	return cells
+}
+
+// sortedLines returns the receiver's per-source-location data in increasing
+// line order.
+func (sfd *sourceFileData) sortedLines() []*sourceLocData {
+	ret := make([]*sourceLocData, 0, len(sfd.lines))
+	for _, sld := range sfd.lines {
+		ret = append(ret, sld)
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].sourceLoc.Line < ret[b].sourceLoc.Line
+	})
+	return ret
+}
+
 var (
 	highlightColor = "rgb(127, 127, 255)"
 
@@ -410,6 +941,13 @@ func handleSourceFileTableQuery(coll *Collection, qf *queryFilters, tableDb util
 			return err
 		}
 	}
+	var expandSourceLocs int64
+	if expandSourceLocsVal, ok := reqOpts[expandSourceLocKey]; ok {
+		expandSourceLocs, err = util.ExpectIntegerValue(expandSourceLocsVal)
+		if err != nil {
+			return err
+		}
+	}
 	cols := []*table.ColumnUpdate{
 		sourceFileCol, sourceLocCountCol, entriesCol,
 	}
@@ -442,7 +980,7 @@ func handleSourceFileTableQuery(coll *Collection, qf *queryFilters, tableDb util
 		if !ok {
 			data = &sourceFileData{
 				sourceFile:     sf,
-				lines:          map[int]struct{}{},
+				lines:          map[int]*sourceLocData{},
 				entriesAtLevel: map[*logtrace.Level]int{},
 			}
 			sourceFileDatas = append(sourceFileDatas, data)
@@ -456,32 +994,66 @@ func handleSourceFileTableQuery(coll *Collection, qf *queryFilters, tableDb util
 	for _, filteredInSourceFile := range qf.sourceFiles {
 		getSourceFileData(filteredInSourceFile)
 	}
-	// For each entry, update its corresponding *sourceFileData.
-	if err := coll.lt.ForEachEntry(func(entry *logtrace.Entry) error {
-		if searchRegex != nil {
-			if !searchRegex.MatchString(entry.SourceLocation.SourceFile.DisplayName()) {
-				return nil
+	// Count the entries at each source location and level, in parallel, then
+	// update each source location's corresponding *sourceFileData.
+	type locLevel struct {
+		sourceLoc *logtrace.SourceLocation
+		level     *logtrace.Level
+	}
+	var partials []map[locLevel]int
+	if err := qf.forEachEntryParallel(coll.lt, func() func(entry *logtrace.Entry) error {
+		counts := map[locLevel]int{}
+		partials = append(partials, counts)
+		return func(entry *logtrace.Entry) error {
+			if searchRegex != nil {
+				if !searchRegex.MatchString(entry.SourceLocation.SourceFile.DisplayName()) {
+					return nil
+				}
 			}
+			counts[locLevel{entry.SourceLocation, entry.Level}]++
+			return nil
 		}
-		data := getSourceFileData(entry.SourceLocation.SourceFile)
-		data.lines[entry.SourceLocation.Line] = struct{}{}
-		data.entries = data.entries + 1
-		data.entriesAtLevel[entry.Level] = data.entriesAtLevel[entry.Level] + 1
-		return nil
 	}, qf.filters(timeFilters)); err != nil {
 		return err
 	}
+	for key, count := range logtrace.Reduce(partials, logtrace.MergeCounts[locLevel]) {
+		data := getSourceFileData(key.sourceLoc.SourceFile)
+		sld, ok := data.lines[key.sourceLoc.Line]
+		if !ok {
+			sld = &sourceLocData{
+				sourceLoc:      key.sourceLoc,
+				entriesAtLevel: map[*logtrace.Level]int{},
+			}
+			data.lines[key.sourceLoc.Line] = sld
+		}
+		sld.entries = sld.entries + count
+		sld.entriesAtLevel[key.level] = sld.entriesAtLevel[key.level] + count
+		data.entries = data.entries + count
+		data.entriesAtLevel[key.level] = data.entriesAtLevel[key.level] + count
+	}
 	// Sort sourceFileDatas by source file name
 	sort.Slice(sourceFileDatas, func(a, b int) bool {
 		return sourceFileDatas[a].sourceFile.Filename < sourceFileDatas[b].sourceFile.Filename
 	})
 	// Emit the data series as a table.
+	util.BeginPhase(qf.ctx, "build")
 	table := table.New(tableDb, renderSettings, cols...)
 	for _, sfd := range sourceFileDatas {
-		table.Row(sfd.row(levels)...).With(
+		row := table.Row(sfd.row(levels)...).With(
 			util.StringProperty(sourceFileKey, sfd.sourceFile.Filename),
 			color.Secondary(highlightColor),
 		)
+		// If requested, nest a collapsed row for each source location beneath
+		// its source file's row.
+		if expandSourceLocs != 0 && len(sfd.lines) > 0 {
+			row.Collapsed()
+			for _, sld := range sfd.sortedLines() {
+				row.Row(sld.row(levels)...).With(
+					util.StringProperty(sourceFileKey, sfd.sourceFile.Filename),
+					util.StringProperty(sourceLocNameKey, sld.sourceLoc.DisplayName()),
+				)
+			}
+		}
 	}
 	return nil
 }
@@ -527,41 +1099,115 @@ func handleRawEntriesQuery(coll *Collection, qf *queryFilters, tableDb util.Data
 			return err
 		}
 	}
+	var foldRepetitions int64
+	if foldRepetitionsVal, ok := reqOpts[foldRepetitionsKey]; ok {
+		foldRepetitions, err = util.ExpectIntegerValue(foldRepetitionsVal)
+		if err != nil {
+			return err
+		}
+	}
 	t := table.New(tableDb, renderSettings, eventCol)
 	for _, colorSpace := range colorSpacesByLevelWeight {
 		t.With(colorSpace.Define())
 	}
+	// If repetitions are folded, the current run of identical entries is held
+	// until a different entry ends it.
+	var runFirst, runLast *logtrace.Entry
+	var runLength int64
+	flushRun := func() {
+		if runFirst == nil {
+			return
+		}
+		row := entryRow(t, runFirst).With(
+			messageMatchRanges(searchRegex, runFirst),
+			qf.annotationMarkers(runFirst.Time, runLast.Time),
+		)
+		if runLength > 1 {
+			row.With(
+				util.IntegerProperty(repetitionsKey, runLength),
+				util.TimestampProperty(firstTimestampKey, runFirst.Time),
+				util.TimestampProperty(lastTimestampKey, runLast.Time),
+			)
+		}
+		runFirst, runLast, runLength = nil, nil, 0
+	}
 	// Aggregate across all filtered-in log entries.
-	if err := coll.lt.ForEachEntry(func(entry *logtrace.Entry) error {
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
 		if searchRegex != nil {
 			if !searchRegex.MatchString(strings.Join(entry.Message, "\n")) {
 				return nil
 			}
 		}
-		coloring := colorSpacesByLevelWeight[entry.Level.Weight]
-		var primaryColor util.PropertyUpdate
-		if coloring != nil {
-			primaryColor = coloring.PrimaryColor(1)
-		}
-		t.Row(
-			table.FormattedCell(eventCol, eventFormatStr,
-				util.TimestampProperty(timestampKey, entry.Time),
-				util.StringProperty(levelNameKey, entry.Level.DisplayName()),
-				util.StringProperty(sourceLocNameKey, entry.SourceLocation.DisplayName()),
-				util.StringsProperty(messageKey, entry.Message...),
-			)).With(
-			util.StringProperty(sourceFileKey, entry.SourceLocation.SourceFile.Identifier()),
-			util.TimestampProperty(timestampKey, entry.Time),
-			primaryColor,
-			color.Secondary(highlightColor),
-		)
+		if foldRepetitions == 0 {
+			entryRow(t, entry).With(
+				messageMatchRanges(searchRegex, entry),
+				qf.annotationMarkers(entry.Time, entry.Time),
+			)
+			return nil
+		}
+		if runFirst == nil || !repeats(runFirst, entry) {
+			flushRun()
+			runFirst = entry
+		}
+		runLast = entry
+		runLength++
 		return nil
 	}, qf.filters(timeFilters, sourceFileFilter)); err != nil {
 		return err
 	}
+	flushRun()
 	return nil
 }
 
+// messageMatchRanges returns a PropertyUpdate marking the ranges of the
+// provided Entry's message, with its lines joined by newlines, matched by the
+// provided search regex.  If the search regex is nil, it does nothing.
+func messageMatchRanges(searchRegex *regexp.Regexp, entry *logtrace.Entry) util.PropertyUpdate {
+	if searchRegex == nil {
+		return util.EmptyUpdate
+	}
+	var ranges []table.MatchRange
+	for _, loc := range searchRegex.FindAllStringIndex(strings.Join(entry.Message, "\n"), -1) {
+		ranges = append(ranges, table.MatchRange{Start: loc[0], End: loc[1]})
+	}
+	return table.PropertyMatchRanges(eventCol, messageKey, ranges...)
+}
+
+// repeats returns true if the provided Entries have the same source location
+// and message.
+func repeats(a, b *logtrace.Entry) bool {
+	if a.SourceLocation != b.SourceLocation || len(a.Message) != len(b.Message) {
+		return false
+	}
+	for idx := range a.Message {
+		if a.Message[idx] != b.Message[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// entryRow emits the provided Entry as a raw event row in the provided table.
+func entryRow(t *table.Node, entry *logtrace.Entry) *table.RowNode {
+	coloring := colorSpacesByLevelWeight[entry.Level.Weight]
+	var primaryColor util.PropertyUpdate
+	if coloring != nil {
+		primaryColor = coloring.PrimaryColor(1)
+	}
+	return t.Row(
+		table.FormattedCell(eventCol, eventFormatStr,
+			util.TimestampProperty(timestampKey, entry.Time),
+			util.StringProperty(levelNameKey, entry.Level.DisplayName()),
+			util.StringProperty(sourceLocNameKey, entry.SourceLocation.DisplayName()),
+			util.StringsProperty(messageKey, entry.Message...),
+		)).With(
+		util.StringProperty(sourceFileKey, entry.SourceLocation.SourceFile.Identifier()),
+		util.TimestampProperty(timestampKey, entry.Time),
+		primaryColor,
+		color.Secondary(highlightColor),
+	)
+}
+
 // idToColorSpace is a helper defining color spaces based on ID hashes.
 func idToColorSpace(id string) *color.Space {
 	hasher := fnv.New32()
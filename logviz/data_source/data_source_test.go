@@ -17,19 +17,28 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"math"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	logreader "github.com/google/traceviz/logviz/analysis/log_reader"
 	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/logviz/annotations"
+	"github.com/google/traceviz/logviz/redact"
 	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/color"
 	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
 	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
 	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/trace"
 	testutil "github.com/google/traceviz/server/go/test_util"
 	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
 	xychart "github.com/google/traceviz/server/go/xy_chart"
 )
 
@@ -48,6 +57,27 @@ const (
 2023/01/01 00:15:00.000000 c.cc:20: [E] Alert!
 2023/01/01 00:25:00.000000 a.cc:40: [E] ALERT!
 2023/01/01 00:35:00.000000 c.cc:30: [F] Failure`
+	repeatingLog = `2023/01/01 00:00:00.000000 a.cc:10: [I] Retrying
+2023/01/01 00:01:00.000000 a.cc:10: [I] Retrying
+2023/01/01 00:02:00.000000 a.cc:10: [I] Retrying
+2023/01/01 00:03:00.000000 b.cc:10: [E] Gave up
+2023/01/01 00:04:00.000000 a.cc:10: [I] Retrying`
+	frontendLog = `2023/01/01 00:00:00.000000 fe.cc:10: [I] Handling req=r1
+2023/01/01 00:01:00.000000 fe.cc:10: [I] Handling req=r2
+2023/01/01 00:04:00.000000 fe.cc:20: [W] Slow response req=r1`
+	backendLog = `2023/01/01 00:02:00.000000 be.cc:10: [I] Lookup req=r1
+2023/01/01 00:03:00.000000 be.cc:30: [E] Lookup failed req=r2`
+	latencyLog = `2023/01/01 00:00:00.000000 srv.cc:10: [I] Request took 10ms
+2023/01/01 00:01:00.000000 srv.cc:10: [I] Request took 30ms
+2023/01/01 00:02:00.000000 srv.cc:20: [W] Request took 20ms
+2023/01/01 00:03:00.000000 srv.cc:30: [E] Request failed
+2023/01/01 00:04:00.000000 srv.cc:10: [I] Request took 40ms`
+	// A single log written by a process that crashed, and by its replacement.
+	restartedLog = `2023/01/01 00:00:00.000000 100 main.cc:10: [I] Starting
+2023/01/01 00:01:00.000000 100 main.cc:20: [I] Serving
+2023/01/01 00:02:00.000000 100 main.cc:30: [F] Crashed
+2023/01/01 00:05:00.000000 200 main.cc:10: [I] Starting
+2023/01/01 00:07:00.000000 200 main.cc:20: [I] Serving`
 )
 
 func testLogReader(collectionName, log string) *logreader.TextLogReader {
@@ -63,18 +93,46 @@ func (tlf *testLogTraceFetcher) Fetch(ctx context.Context, collectionName string
 		logReaders = []logtrace.LogReader{testLogReader("log1", log1)}
 	case "log2":
 		logReaders = []logtrace.LogReader{testLogReader("log2", log2)}
-	case "both":
+	case "repeating":
+		logReaders = []logtrace.LogReader{testLogReader("repeating", repeatingLog)}
+	case "correlated":
+		logReaders = []logtrace.LogReader{testLogReader("frontend", frontendLog), testLogReader("backend", backendLog)}
+	case "latency":
+		logReaders = []logtrace.LogReader{testLogReader("latency", latencyLog)}
+	case "restarted":
+		logReaders = []logtrace.LogReader{testLogReader("restarted", restartedLog)}
+	case "both", "both_downsampled":
 		logReaders = []logtrace.LogReader{testLogReader("log1", log1), testLogReader("log2", log2)}
 	default:
 		return nil, fmt.Errorf("can't find collection '%s'", collectionName)
 	}
-	lt, err := logtrace.NewLogTrace(logReaders...)
+	lt, err := logtrace.NewLogTraceWithOptions(logtrace.Options{
+		IndexBucketWidth:   time.Second,
+		CorrelationIDField: "req",
+		FieldPatterns: []*regexp.Regexp{
+			regexp.MustCompile(`Handling req=(?P<handled>\w+)`),
+			regexp.MustCompile(`took (?P<latency_ms>\d+)ms`),
+		},
+	}, logReaders...)
 	if err != nil {
 		return nil, err
 	}
-	return &Collection{
-		lt: lt,
-	}, nil
+	coll := NewCollection(lt)
+	if collectionName == "both_downsampled" {
+		coll.maxTimeseriesEntries = 2
+	}
+	return coll, nil
+}
+
+// sourceTreeStats returns the standard weighted tree annotations of a source
+// tree node with the specified entries, in a tree of the specified total
+// entries.
+func sourceTreeStats(entries, totalEntries int64) util.PropertyUpdate {
+	return util.Chain(
+		weightedtree.TotalMagnitude(float64(entries)),
+		weightedtree.PercentOfRoot(float64(entries)*100/float64(totalEntries)),
+		weightedtree.SampleCount(entries),
+	)
 }
 
 func TestQueries(t *testing.T) {
@@ -83,6 +141,186 @@ func TestQueries(t *testing.T) {
 	warningCol := table.Column(category.New("level_2", "Warning", "The number of distinct log entries associated with this source file at log level `Warning`"))
 	infoCol := table.Column(category.New("level_3", "Info", "The number of distinct log entries associated with this source file at log level `Info`"))
 
+	// The expected per-level timeseries over both logs with four bins.
+	wantPerLevelTimeseries := func(series util.DataBuilder) {
+		binWidth := 35 * time.Minute / 3.0
+		// First bin is [ts(0), ts(11 minutes 40 seconds))
+		firstBinStart := time.Second * 0
+		// Second bin is [ts(11 minutes 40 seconds), ts(23 minutes 20 seconds))
+		secondBinStart := firstBinStart + binWidth
+		// Third bin is [ts(23 minutes 20 seconds), ts(35 minutes)]
+		thirdBinStart := secondBinStart + binWidth
+		// Fourth bin is [ts(35 minutes), ...]
+		fourthBinStart := thirdBinStart + binWidth
+		chart := xychart.New(series,
+			continuousaxis.NewTimestampAxis(
+				category.New("x_axis", "Message timestamp", "Log message timestamp"),
+				ts(0), ts(time.Minute*35)),
+			continuousaxis.NewDoubleAxis(
+				category.New("y_axis", "Messages per minute", "Log messages per minute"),
+				0, 2.0/(float64(binWidth)/float64(time.Minute))),
+			colorSpacesByLevelWeight[0].Define(),
+			colorSpacesByLevelWeight[1].Define(),
+			colorSpacesByLevelWeight[2].Define(),
+			colorSpacesByLevelWeight[3].Define(),
+			xAxisRenderSettings.Apply(),
+			yAxisRenderSettings.Apply(),
+		)
+		// Fatal datapoints
+		s := chart.AddSeries(
+			category.New("0", "0", "0"),
+			colorSpacesByLevelWeight[0].PrimaryColor(1),
+		)
+		s.WithPoint(
+			ts(firstBinStart),
+			0,
+		).WithPoint(
+			ts(secondBinStart),
+			0,
+		).WithPoint(
+			ts(thirdBinStart),
+			0,
+		).WithPoint(
+			ts(fourthBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		)
+		// Error datapoints
+		s = chart.AddSeries(
+			category.New("1", "1", "1"),
+			colorSpacesByLevelWeight[1].PrimaryColor(1),
+		)
+		s.WithPoint(
+			ts(firstBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(secondBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(thirdBinStart),
+			2.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(fourthBinStart),
+			0,
+		)
+		// Warning datapoints
+		s = chart.AddSeries(
+			category.New("2", "2", "2"),
+			colorSpacesByLevelWeight[2].PrimaryColor(1),
+		)
+		s.WithPoint(
+			ts(firstBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(secondBinStart),
+			0,
+		).WithPoint(
+			ts(thirdBinStart),
+			0,
+		).WithPoint(
+			ts(fourthBinStart),
+			0,
+		)
+		// Info datapoints
+		s = chart.AddSeries(
+			category.New("3", "3", "3"),
+			colorSpacesByLevelWeight[3].PrimaryColor(1),
+		)
+		s.WithPoint(
+			ts(firstBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(secondBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(thirdBinStart),
+			0,
+		).WithPoint(
+			ts(fourthBinStart),
+			0,
+		)
+	}
+
+	// The expected per-level timeseries over both logs with four bins, with
+	// runs of empty bins emitted as gaps.
+	wantSkippedPerLevelTimeseries := func(series util.DataBuilder) {
+		binWidth := 35 * time.Minute / 3.0
+		firstBinStart := time.Second * 0
+		secondBinStart := firstBinStart + binWidth
+		thirdBinStart := secondBinStart + binWidth
+		fourthBinStart := thirdBinStart + binWidth
+		chart := xychart.New(series,
+			continuousaxis.NewTimestampAxis(
+				category.New("x_axis", "Message timestamp", "Log message timestamp"),
+				ts(0), ts(time.Minute*35)),
+			continuousaxis.NewDoubleAxis(
+				category.New("y_axis", "Messages per minute", "Log messages per minute"),
+				0, 2.0/(float64(binWidth)/float64(time.Minute))),
+			colorSpacesByLevelWeight[0].Define(),
+			colorSpacesByLevelWeight[1].Define(),
+			colorSpacesByLevelWeight[2].Define(),
+			colorSpacesByLevelWeight[3].Define(),
+			xAxisRenderSettings.Apply(),
+			yAxisRenderSettings.Apply(),
+		)
+		// Fatal datapoints
+		s := chart.AddSeries(
+			category.New("0", "0", "0"),
+			colorSpacesByLevelWeight[0].PrimaryColor(1),
+		)
+		s.WithGap(
+			ts(firstBinStart),
+			ts(thirdBinStart),
+		).WithPoint(
+			ts(fourthBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		)
+		// Error datapoints; a single empty bin is not a gap.
+		s = chart.AddSeries(
+			category.New("1", "1", "1"),
+			colorSpacesByLevelWeight[1].PrimaryColor(1),
+		)
+		s.WithPoint(
+			ts(firstBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(secondBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(thirdBinStart),
+			2.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(fourthBinStart),
+			0,
+		)
+		// Warning datapoints
+		s = chart.AddSeries(
+			category.New("2", "2", "2"),
+			colorSpacesByLevelWeight[2].PrimaryColor(1),
+		)
+		s.WithPoint(
+			ts(firstBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithGap(
+			ts(secondBinStart),
+			ts(fourthBinStart),
+		)
+		// Info datapoints
+		s = chart.AddSeries(
+			category.New("3", "3", "3"),
+			colorSpacesByLevelWeight[3].PrimaryColor(1),
+		)
+		s.WithPoint(
+			ts(firstBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithPoint(
+			ts(secondBinStart),
+			1.0/(float64(binWidth)/float64(time.Minute)),
+		).WithGap(
+			ts(thirdBinStart),
+			ts(fourthBinStart),
+		)
+	}
+
 	for _, test := range []struct {
 		description string
 		req         *util.DataRequest
@@ -124,6 +362,65 @@ func TestQueries(t *testing.T) {
 				color.Secondary(highlightColor),
 			)
 		},
+	}, {
+		description: "aggregate table by source file, one log, expanding source locations",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("log1"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: aggregateSourceFilesTableQuery,
+					Options: map[string]*util.V{
+						expandSourceLocKey: util.IntValue(1),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			t := table.New(db, renderSettings,
+				sourceFileCol, sourceLocCountCol, entriesCol, errorCol, warningCol, infoCol,
+			)
+			aRow := t.Row(
+				table.Cell(sourceFileCol, util.String("a.cc")),
+				table.Cell(sourceLocCountCol, util.Integer(3)),
+				table.Cell(entriesCol, util.Integer(3)),
+				table.Cell(warningCol, util.Integer(1)),
+				table.Cell(infoCol, util.Integer(2)),
+			).With(
+				util.StringProperty(sourceFileKey, "a.cc"),
+				color.Secondary(highlightColor),
+			).Collapsed()
+			for _, loc := range []struct {
+				line string
+				col  *table.ColumnUpdate
+			}{{"a.cc:10", infoCol}, {"a.cc:20", warningCol}, {"a.cc:30", infoCol}} {
+				aRow.Row(
+					table.Cell(sourceFileCol, util.String(loc.line)),
+					table.Cell(entriesCol, util.Integer(1)),
+					table.Cell(loc.col, util.Integer(1)),
+				).With(
+					util.StringProperty(sourceFileKey, "a.cc"),
+					util.StringProperty(sourceLocNameKey, loc.line),
+				)
+			}
+			t.Row(
+				table.Cell(sourceFileCol, util.String("b.cc")),
+				table.Cell(sourceLocCountCol, util.Integer(1)),
+				table.Cell(entriesCol, util.Integer(1)),
+				table.Cell(errorCol, util.Integer(1)),
+			).With(
+				util.StringProperty(sourceFileKey, "b.cc"),
+				color.Secondary(highlightColor),
+			).Collapsed().Row(
+				table.Cell(sourceFileCol, util.String("b.cc:10")),
+				table.Cell(entriesCol, util.Integer(1)),
+				table.Cell(errorCol, util.Integer(1)),
+			).With(
+				util.StringProperty(sourceFileKey, "b.cc"),
+				util.StringProperty(sourceLocNameKey, "b.cc:10"),
+			)
+		},
 	}, {
 		description: "aggregate table by source file, two logs",
 		req: &util.DataRequest{
@@ -289,6 +586,55 @@ func TestQueries(t *testing.T) {
 				util.TimestampProperty(timestampKey, ts(30*time.Minute)),
 			)
 		},
+	}, {
+		description: "entries, one log, searched",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("log1"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: rawEntriesQuery,
+					Options: map[string]*util.V{
+						searchRegexKey: util.StringValue("e[lr]"),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			t := table.New(db, renderSettings, eventCol).With(
+				colorSpacesByLevelWeight[0].Define(),
+				colorSpacesByLevelWeight[1].Define(),
+				colorSpacesByLevelWeight[2].Define(),
+				colorSpacesByLevelWeight[3].Define(),
+			)
+			t.Row(
+				table.FormattedCell(eventCol, eventFormatStr,
+					util.TimestampProperty(timestampKey, ts(0)),
+					util.StringProperty(levelNameKey, "Info"),
+					util.StringProperty(sourceLocNameKey, "a.cc:10"),
+					util.StringsProperty(messageKey, "Hello"),
+				)).With(
+				colorSpacesByLevelWeight[3].PrimaryColor(1),
+				color.Secondary(highlightColor),
+				util.StringProperty(sourceFileKey, "a.cc"),
+				util.TimestampProperty(timestampKey, ts(0)),
+				table.PropertyMatchRanges(eventCol, messageKey, table.MatchRange{Start: 1, End: 3}),
+			)
+			t.Row(
+				table.FormattedCell(eventCol, eventFormatStr,
+					util.TimestampProperty(timestampKey, ts(20*time.Minute)),
+					util.StringProperty(levelNameKey, "Info"),
+					util.StringProperty(sourceLocNameKey, "a.cc:30"),
+					util.StringsProperty(messageKey, "Still here"),
+				)).With(
+				colorSpacesByLevelWeight[3].PrimaryColor(1),
+				color.Secondary(highlightColor),
+				util.StringProperty(sourceFileKey, "a.cc"),
+				util.TimestampProperty(timestampKey, ts(20*time.Minute)),
+				table.PropertyMatchRanges(eventCol, messageKey, table.MatchRange{Start: 7, End: 9}),
+			)
+		},
 	}, {
 		description: "per-level timeseries, both logs",
 		req: &util.DataRequest{
@@ -305,196 +651,1041 @@ func TestQueries(t *testing.T) {
 				},
 			},
 		},
+		wantSeries: wantPerLevelTimeseries,
+	}, {
+		description: "per-level timeseries, both logs, skipping empty bins",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: timeseriesQuery,
+					Options: map[string]*util.V{
+						aggregateByKey:   util.StringValue(levelNameKey),
+						binCountKey:      util.IntValue(4),
+						skipEmptyBinsKey: util.IntValue(1),
+					},
+				},
+			},
+		},
+		wantSeries: wantSkippedPerLevelTimeseries,
+	}, {
+		description: "source location timeseries, both logs, not downsampled",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both_downsampled"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: sourceLocTimeseriesQuery,
+					Options: map[string]*util.V{
+						sourceLocNameKey: util.StringValue("c.cc:10"),
+						binCountKey:      util.IntValue(4),
+					},
+				},
+			},
+		},
 		wantSeries: func(series util.DataBuilder) {
 			binWidth := 35 * time.Minute / 3.0
-			// First bin is [ts(0), ts(11 minutes 40 seconds))
-			firstBinStart := time.Second * 0
-			// Second bin is [ts(11 minutes 40 seconds), ts(23 minutes 20 seconds))
-			secondBinStart := firstBinStart + binWidth
-			// Third bin is [ts(23 minutes 20 seconds), ts(35 minutes)]
-			thirdBinStart := secondBinStart + binWidth
-			// Fourth bin is [ts(35 minutes), ...]
-			fourthBinStart := thirdBinStart + binWidth
+			perMinute := float64(binWidth) / float64(time.Minute)
 			chart := xychart.New(series,
 				continuousaxis.NewTimestampAxis(
 					category.New("x_axis", "Message timestamp", "Log message timestamp"),
 					ts(0), ts(time.Minute*35)),
 				continuousaxis.NewDoubleAxis(
 					category.New("y_axis", "Messages per minute", "Log messages per minute"),
-					0, 2.0/(float64(binWidth)/float64(time.Minute))),
-				colorSpacesByLevelWeight[0].Define(),
+					0, 1.0/perMinute),
 				colorSpacesByLevelWeight[1].Define(),
-				colorSpacesByLevelWeight[2].Define(),
-				colorSpacesByLevelWeight[3].Define(),
 				xAxisRenderSettings.Apply(),
 				yAxisRenderSettings.Apply(),
 			)
-			// Fatal datapoints
-			s := chart.AddSeries(
-				category.New("0", "0", "0"),
-				colorSpacesByLevelWeight[0].PrimaryColor(1),
-			)
-			s.WithPoint(
-				ts(firstBinStart),
-				0,
-			).WithPoint(
-				ts(secondBinStart),
-				0,
-			).WithPoint(
-				ts(thirdBinStart),
-				0,
-			).WithPoint(
-				ts(fourthBinStart),
-				1.0/(float64(binWidth)/float64(time.Minute)),
-			)
-			// Error datapoints
-			s = chart.AddSeries(
+			chart.AddSeries(
 				category.New("1", "1", "1"),
 				colorSpacesByLevelWeight[1].PrimaryColor(1),
+			).
+				WithPoint(ts(0), 1/perMinute).
+				WithPoint(ts(binWidth), 0).
+				WithPoint(ts(2*binWidth), 0).
+				WithPoint(ts(3*binWidth), 0)
+		},
+	}, {
+		description: "per-level max value timeseries, latency log",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("latency"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: timeseriesQuery,
+					Options: map[string]*util.V{
+						aggregateByKey:    util.StringValue(levelNameKey),
+						binCountKey:       util.IntValue(3),
+						valueFieldKey:     util.StringValue("latency_ms"),
+						valueAggregateKey: util.StringValue("max"),
+					},
+				},
+			},
+		},
+		wantSeries: func(series util.DataBuilder) {
+			binWidth := 2 * time.Minute
+			chart := xychart.New(series,
+				continuousaxis.NewTimestampAxis(
+					category.New("x_axis", "Message timestamp", "Log message timestamp"),
+					ts(0), ts(time.Minute*4)),
+				continuousaxis.NewDoubleAxis(
+					category.New("y_axis", "max of latency_ms", "max of latency_ms"),
+					0, 40),
+				colorSpacesByLevelWeight[2].Define(),
+				colorSpacesByLevelWeight[3].Define(),
+				xAxisRenderSettings.Apply(),
+				yAxisRenderSettings.Apply(),
 			)
-			s.WithPoint(
-				ts(firstBinStart),
-				1.0/(float64(binWidth)/float64(time.Minute)),
-			).WithPoint(
-				ts(secondBinStart),
-				1.0/(float64(binWidth)/float64(time.Minute)),
-			).WithPoint(
-				ts(thirdBinStart),
-				2.0/(float64(binWidth)/float64(time.Minute)),
-			).WithPoint(
-				ts(fourthBinStart),
-				0,
-			)
-			// Warning datapoints
-			s = chart.AddSeries(
+			chart.AddSeries(
 				category.New("2", "2", "2"),
 				colorSpacesByLevelWeight[2].PrimaryColor(1),
-			)
-			s.WithPoint(
-				ts(firstBinStart),
-				1.0/(float64(binWidth)/float64(time.Minute)),
-			).WithPoint(
-				ts(secondBinStart),
-				0,
-			).WithPoint(
-				ts(thirdBinStart),
-				0,
-			).WithPoint(
-				ts(fourthBinStart),
-				0,
-			)
-			// Info datapoints
-			s = chart.AddSeries(
+			).
+				WithPoint(ts(0), 0).
+				WithPoint(ts(binWidth), 20).
+				WithPoint(ts(2*binWidth), 0)
+			// The failed request has no latency, and is ignored.
+			chart.AddSeries(
 				category.New("3", "3", "3"),
 				colorSpacesByLevelWeight[3].PrimaryColor(1),
-			)
-			s.WithPoint(
-				ts(firstBinStart),
-				1.0/(float64(binWidth)/float64(time.Minute)),
-			).WithPoint(
-				ts(secondBinStart),
-				1.0/(float64(binWidth)/float64(time.Minute)),
-			).WithPoint(
-				ts(thirdBinStart),
-				0,
-			).WithPoint(
-				ts(fourthBinStart),
-				0,
-			)
+			).
+				WithPoint(ts(0), 30).
+				WithPoint(ts(binWidth), 0).
+				WithPoint(ts(2*binWidth), 40)
 		},
-		// }, {
-		// 	description: "trace, cockroachdb logs",
-		// 	req: &util.DataRequest{
-		// 		GlobalFilters: map[string]*util.V{
-		// 			collectionNameKey: util.StringValue("both"),
-		// 		},
-		// 		SeriesRequests: []*util.DataSeriesRequest{
-		// 			{
-		// 				QueryName: traceQuery,
-		// 				Options: map[string]*util.V{
-		// 				},
-		// 			},
-		// 		},
-		// 	},
-		// 	wantSeries: func(series util.DataBuilder) {
-		// 	},
-		}, {
-			description: "zoom in",
-			req: &util.DataRequest{
-				GlobalFilters: map[string]*util.V{
-					collectionNameKey: util.StringValue("log1"),
-					startTimestampKey: util.TimestampValue(ts(time.Minute * 0)),
-					endTimestampKey:   util.TimestampValue(ts(time.Minute * 30)),
-					zoomKey:           util.StringValue("in"),
-				},
-				SeriesRequests: []*util.DataSeriesRequest{
-					&util.DataSeriesRequest{
-						QueryName: panAndZoomQuery,
+	}, {
+		description: "value timeseries, unsupported aggregate",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("latency"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: timeseriesQuery,
+					Options: map[string]*util.V{
+						aggregateByKey:    util.StringValue(levelNameKey),
+						binCountKey:       util.IntValue(3),
+						valueFieldKey:     util.StringValue("latency_ms"),
+						valueAggregateKey: util.StringValue("median"),
 					},
 				},
 			},
-			wantSeries: func(db util.DataBuilder) {
-				// Zooming into 30-minute range centered at 15m, with a zoom factor of 2,
-				// yields a 15-minute range centered at 15m.
-				db.With(
-					util.TimestampProperty(startTimestampKey, ts(time.Second*(7.5*60))),
-					util.TimestampProperty(endTimestampKey, ts(time.Second*(22.5*60))),
-				)
+		},
+		wantErr: true,
+	}, {
+		description: "source location timeseries, unknown source location",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
 			},
-		}, {
-			description: "zoom out",
-			req: &util.DataRequest{
-				GlobalFilters: map[string]*util.V{
-					collectionNameKey: util.StringValue("log1"),
-					startTimestampKey: util.TimestampValue(ts(time.Minute * 12)),
-					endTimestampKey:   util.TimestampValue(ts(time.Minute * 18)),
-					zoomKey:           util.StringValue("out"),
-				},
-				SeriesRequests: []*util.DataSeriesRequest{
-					&util.DataSeriesRequest{
-						QueryName: panAndZoomQuery,
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: sourceLocTimeseriesQuery,
+					Options: map[string]*util.V{
+						sourceLocNameKey: util.StringValue("z.cc:10"),
+						binCountKey:      util.IntValue(4),
 					},
 				},
 			},
-			wantSeries: func(db util.DataBuilder) {
-				// Zooming out from a 6-minute range centered at 15m, with a zoom factor
-				// of 2, yields a 12-minute range centered at 15m.
-				db.With(
-					util.TimestampProperty(startTimestampKey, ts(time.Minute*9)),
-					util.TimestampProperty(endTimestampKey, ts(time.Minute*21)),
-				)
+		},
+		wantErr: true,
+	}, {
+		description: "per-level timeseries, both logs, bin count from viewport width",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
 			},
-		}, {
-			description: "pan left",
-			req: &util.DataRequest{
-				GlobalFilters: map[string]*util.V{
-					collectionNameKey: util.StringValue("log1"),
-					startTimestampKey: util.TimestampValue(ts(time.Minute * 12)),
-					endTimestampKey:   util.TimestampValue(ts(time.Minute * 18)),
-					panKey:            util.StringValue("left"),
-				},
-				SeriesRequests: []*util.DataSeriesRequest{
-					&util.DataSeriesRequest{
-						QueryName: panAndZoomQuery,
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: timeseriesQuery,
+					Options: map[string]*util.V{
+						aggregateByKey:     util.StringValue(levelNameKey),
+						viewportWidthPxKey: util.IntValue(4 * timeseriesBinWidthPx),
 					},
 				},
 			},
-			wantSeries: func(db util.DataBuilder) {
-				// Panning left in a 6-minute range centered at 15m yields a 6-minute
-				// range centered at 12m
-				db.With(
-					util.TimestampProperty(startTimestampKey, ts(time.Minute*9)),
-					util.TimestampProperty(endTimestampKey, ts(time.Minute*15)),
-				)
+		},
+		wantSeries: wantPerLevelTimeseries,
+	}, {
+		description: "per-level timeseries, both logs, downsampled from minute aggregates",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both_downsampled"),
 			},
-		}, {
-			description: "pan right",
-			req: &util.DataRequest{
-				GlobalFilters: map[string]*util.V{
-					collectionNameKey: util.StringValue("log1"),
-					startTimestampKey: util.TimestampValue(ts(time.Minute * 12)),
-					endTimestampKey:   util.TimestampValue(ts(time.Minute * 18)),
-					panKey:            util.StringValue("right"),
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: timeseriesQuery,
+					Options: map[string]*util.V{
+						aggregateByKey: util.StringValue(levelNameKey),
+						binCountKey:    util.IntValue(4),
+					},
+				},
+			},
+		},
+		// All entries lie on minute boundaries, so aggregation is exact.
+		wantSeries: wantPerLevelTimeseries,
+	}, {
+		description: "per-level timeseries, filtered, downsampled by stride",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey:      util.StringValue("both_downsampled"),
+				filteredSourceFilesKey: util.StringsValue("a.cc"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: timeseriesQuery,
+					Options: map[string]*util.V{
+						aggregateByKey: util.StringValue(levelNameKey),
+						binCountKey:    util.IntValue(2),
+					},
+				},
+			},
+		},
+		wantSeries: func(series util.DataBuilder) {
+			// With 8 entries in range and at most 2 visited, only every fourth
+			// candidate entry is visited and counted four times.  The index
+			// narrows the candidates to the four a.cc entries, of which only
+			// a.cc:10 at 0m is visited.
+			binWidth := 35 * time.Minute
+			chart := xychart.New(series,
+				continuousaxis.NewTimestampAxis(
+					category.New("x_axis", "Message timestamp", "Log message timestamp"),
+					ts(0), ts(time.Minute*35)),
+				continuousaxis.NewDoubleAxis(
+					category.New("y_axis", "Messages per minute", "Log messages per minute"),
+					0, 4.0/35),
+				colorSpacesByLevelWeight[3].Define(),
+				xAxisRenderSettings.Apply(),
+				yAxisRenderSettings.Apply(),
+			)
+			chart.AddSeries(
+				category.New("3", "3", "3"),
+				colorSpacesByLevelWeight[3].PrimaryColor(1),
+			).WithPoint(ts(0), 4.0/35).WithPoint(ts(binWidth), 0)
+		},
+	}, {
+		description: "source tree, both logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: sourceTreeQuery,
+					Options: map[string]*util.V{
+						viewportWidthPxKey: util.IntValue(100),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			tree := weightedtree.New(db, sourceTreeRenderSettings).TopDown()
+			line := func(parent *weightedtree.Node, line string) {
+				parent.Node(1,
+					sourceTreeStats(1, 8),
+					util.StringProperty(sourcePathKey, line),
+					util.IntegerProperty(entriesKey, 1),
+				)
+			}
+			file := func(filename string, entries int64, lines ...string) {
+				fileNode := tree.Node(0,
+					sourceTreeStats(entries, 8),
+					util.StringProperty(sourcePathKey, filename),
+					util.IntegerProperty(entriesKey, entries),
+					util.StringProperty(sourceFileKey, filename),
+				)
+				for _, l := range lines {
+					line(fileNode, l)
+				}
+			}
+			file("a.cc", 4, ":10", ":20", ":30", ":40")
+			file("c.cc", 3, ":10", ":20", ":30")
+			file("b.cc", 1, ":10")
+		},
+	}, {
+		description: "source tree, both logs, max nodes",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: sourceTreeQuery,
+					Options: map[string]*util.V{
+						viewportWidthPxKey: util.IntValue(100),
+						maxNodesKey:        util.IntValue(2),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			tree := weightedtree.New(db, sourceTreeRenderSettings).TopDown()
+			tree.Node(4,
+				sourceTreeStats(4, 8),
+				util.StringProperty(sourcePathKey, "a.cc"),
+				util.IntegerProperty(entriesKey, 4),
+				util.StringProperty(sourceFileKey, "a.cc"),
+			)
+			tree.Node(3,
+				sourceTreeStats(3, 8),
+				util.StringProperty(sourcePathKey, "c.cc"),
+				util.IntegerProperty(entriesKey, 3),
+				util.StringProperty(sourceFileKey, "c.cc"),
+			)
+		},
+	}, {
+		description: "process trace, both logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: processTraceQuery,
+					Options: map[string]*util.V{
+						gapThresholdKey: util.DurationValue(10 * time.Minute),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			tr := trace.New[time.Time](db,
+				continuousaxis.NewTimestampAxis(
+					category.New("x_axis", "Time", "Time from start of log"),
+					ts(0), ts(35*time.Minute)),
+				traceRenderSettings).With(
+				xAxisRenderSettings.Apply(),
+				colorSpacesByLevelWeight[0].Define(),
+				colorSpacesByLevelWeight[1].Define(),
+				colorSpacesByLevelWeight[2].Define(),
+				colorSpacesByLevelWeight[3].Define(),
+			)
+			entries := func(n int64) util.PropertyUpdate {
+				return util.IntegerProperty(entriesKey, n)
+			}
+			entry := func(span *trace.Span[time.Time], at time.Duration, level int, levelName, sourceLoc string) {
+				span.Subspan(ts(at), ts(at),
+					util.StringProperty(levelNameKey, levelName),
+					util.StringProperty(sourceLocNameKey, sourceLoc),
+					colorSpacesByLevelWeight[level].PrimaryColor(1),
+				)
+			}
+			fileCat := func(parent *trace.Category[time.Time], proc, file string) *trace.Category[time.Time] {
+				return parent.Category(
+					category.New(proc+"/"+file, file, "Log activity from "+file+" in process "+proc),
+					util.StringProperty(sourceFileKey, file),
+				)
+			}
+			// log1: a single burst.
+			log1 := tr.Category(
+				category.New("log1", "log1", "Log activity in process log1"),
+				util.StringProperty(processKey, "log1"),
+			)
+			log1.Span(ts(0), ts(30*time.Minute), entries(4))
+			span := fileCat(log1, "log1", "a.cc").Span(ts(0), ts(20*time.Minute), entries(3))
+			entry(span, 0, 3, "Info", "a.cc:10")
+			entry(span, 10*time.Minute, 2, "Warning", "a.cc:20")
+			entry(span, 20*time.Minute, 3, "Info", "a.cc:30")
+			span = fileCat(log1, "log1", "b.cc").Span(ts(30*time.Minute), ts(30*time.Minute), entries(1))
+			entry(span, 30*time.Minute, 1, "Error", "b.cc:10")
+			// log2: c.cc has two bursts.
+			log2 := tr.Category(
+				category.New("log2", "log2", "Log activity in process log2"),
+				util.StringProperty(processKey, "log2"),
+			)
+			log2.Span(ts(5*time.Minute), ts(35*time.Minute), entries(4))
+			span = fileCat(log2, "log2", "a.cc").Span(ts(25*time.Minute), ts(25*time.Minute), entries(1))
+			entry(span, 25*time.Minute, 1, "Error", "a.cc:40")
+			cCat := fileCat(log2, "log2", "c.cc")
+			span = cCat.Span(ts(5*time.Minute), ts(15*time.Minute), entries(2))
+			entry(span, 5*time.Minute, 1, "Error", "c.cc:10")
+			entry(span, 15*time.Minute, 1, "Error", "c.cc:20")
+			span = cCat.Span(ts(35*time.Minute), ts(35*time.Minute), entries(1))
+			entry(span, 35*time.Minute, 0, "Fatal", "c.cc:30")
+		},
+	}, {
+		description: "process lifetimes, both logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: processLifetimesQuery,
+					Options: map[string]*util.V{
+						startRegexKey: util.StringValue("^Still"),
+						stopRegexKey:  util.StringValue("^Failure"),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			tr := trace.New[time.Time](db,
+				continuousaxis.NewTimestampAxis(
+					category.New("x_axis", "Time", "Time from start of log"),
+					ts(0), ts(35*time.Minute)),
+				traceRenderSettings).With(
+				xAxisRenderSettings.Apply(),
+			)
+			log1 := tr.Category(
+				category.New("log1", "log1", "Lifetimes of process log1"),
+				util.StringProperty(processKey, "log1"),
+			)
+			log1.Span(ts(0), ts(10*time.Minute),
+				util.IntegerProperty(entriesKey, 2),
+			)
+			log1.Span(ts(20*time.Minute), ts(30*time.Minute),
+				util.IntegerProperty(entriesKey, 2),
+				util.IntegerProperty(explicitStartKey, 1),
+			)
+			tr.Category(
+				category.New("log2", "log2", "Lifetimes of process log2"),
+				util.StringProperty(processKey, "log2"),
+			).Span(ts(5*time.Minute), ts(35*time.Minute),
+				util.IntegerProperty(entriesKey, 4),
+				util.IntegerProperty(explicitStopKey, 1),
+			)
+		},
+	}, {
+		description: "entries by correlation ID",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("correlated"),
+				// Global filters are ignored.
+				startTimestampKey: util.TimestampValue(ts(3 * time.Minute)),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: byCorrelationIDQuery,
+					Options: map[string]*util.V{
+						correlationIDKey: util.StringValue("r1"),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			tr := trace.New[time.Time](db,
+				continuousaxis.NewTimestampAxis(
+					category.New("x_axis", "Time", "Time of correlated log entries"),
+					ts(0), ts(4*time.Minute)),
+				traceRenderSettings).With(
+				xAxisRenderSettings.Apply(),
+				util.StringProperty(correlationIDKey, "r1"),
+				colorSpacesByLevelWeight[0].Define(),
+				colorSpacesByLevelWeight[1].Define(),
+				colorSpacesByLevelWeight[2].Define(),
+				colorSpacesByLevelWeight[3].Define(),
+			)
+			entry := func(span *trace.Span[time.Time], at time.Duration, level int, levelName, sourceLoc, sourceFile, message string) {
+				span.Subspan(ts(at), ts(at),
+					util.StringProperty(levelNameKey, levelName),
+					util.StringProperty(sourceLocNameKey, sourceLoc),
+					util.StringProperty(sourceFileKey, sourceFile),
+					util.StringsProperty(messageKey, message),
+					colorSpacesByLevelWeight[level].PrimaryColor(1),
+				)
+			}
+			span := tr.Category(
+				category.New("backend", "backend", "Log entries with correlation ID r1 in process backend"),
+				util.StringProperty(processKey, "backend"),
+			).Span(ts(2*time.Minute), ts(2*time.Minute), util.IntegerProperty(entriesKey, 1))
+			entry(span, 2*time.Minute, 3, "Info", "be.cc:10", "be.cc", "Lookup req=r1")
+			span = tr.Category(
+				category.New("frontend", "frontend", "Log entries with correlation ID r1 in process frontend"),
+				util.StringProperty(processKey, "frontend"),
+			).Span(ts(0), ts(4*time.Minute), util.IntegerProperty(entriesKey, 2))
+			entry(span, 0, 3, "Info", "fe.cc:10", "fe.cc", "Handling req=r1")
+			entry(span, 4*time.Minute, 2, "Warning", "fe.cc:20", "fe.cc", "Slow response req=r1")
+		},
+	}, {
+		description: "entries by unknown correlation ID",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("correlated"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: byCorrelationIDQuery,
+					Options: map[string]*util.V{
+						correlationIDKey: util.StringValue("r3"),
+					},
+				},
+			},
+		},
+		wantErr: true,
+	}, {
+		description: "gap histogram by process, both logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: gapHistogramQuery,
+					Options: map[string]*util.V{
+						aggregateByKey: util.StringValue(processKey),
+						binCountKey:    util.IntValue(2),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			chart := xychart.New(db,
+				continuousaxis.NewDurationAxis(
+					category.New("x_axis", "Gap", "Time between consecutive log messages"),
+					0, 10*time.Minute),
+				continuousaxis.NewDoubleAxis(
+					category.New("y_axis", "Gaps", "Number of gaps between consecutive log messages"),
+					0, 3),
+				idToColorSpace("log1").Define(),
+				idToColorSpace("log2").Define(),
+				xAxisRenderSettings.Apply(),
+				yAxisRenderSettings.Apply(),
+			)
+			// Each process logs every ten minutes.
+			for _, proc := range []string{"log1", "log2"} {
+				chart.AddSeries(
+					category.New(proc, proc, proc),
+					idToColorSpace(proc).PrimaryColor(1),
+				).WithPoint(
+					time.Duration(0), 0,
+				).WithPoint(
+					5*time.Minute, 3,
+				)
+			}
+		},
+	}, {
+		description: "gap histogram by process, restarted process",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("restarted"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: gapHistogramQuery,
+					Options: map[string]*util.V{
+						aggregateByKey: util.StringValue(processKey),
+						binCountKey:    util.IntValue(2),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			chart := xychart.New(db,
+				continuousaxis.NewDurationAxis(
+					category.New("x_axis", "Gap", "Time between consecutive log messages"),
+					0, 2*time.Minute),
+				continuousaxis.NewDoubleAxis(
+					category.New("y_axis", "Gaps", "Number of gaps between consecutive log messages"),
+					0, 2),
+				idToColorSpace("100").Define(),
+				idToColorSpace("200").Define(),
+				xAxisRenderSettings.Apply(),
+				yAxisRenderSettings.Apply(),
+			)
+			// The log's parsed PIDs, not the log itself, identify its processes,
+			// so the three-minute gap across the restart isn't counted.
+			chart.AddSeries(
+				category.New("100", "100", "100"),
+				idToColorSpace("100").PrimaryColor(1),
+			).WithPoint(time.Duration(0), 0).WithPoint(time.Minute, 2)
+			chart.AddSeries(
+				category.New("200", "200", "200"),
+				idToColorSpace("200").PrimaryColor(1),
+			).WithPoint(time.Duration(0), 0).WithPoint(time.Minute, 1)
+		},
+	}, {
+		description: "gap histogram, both logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: gapHistogramQuery,
+					Options: map[string]*util.V{
+						binCountKey: util.IntValue(1),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			chart := xychart.New(db,
+				continuousaxis.NewDurationAxis(
+					category.New("x_axis", "Gap", "Time between consecutive log messages"),
+					0, 5*time.Minute),
+				continuousaxis.NewDoubleAxis(
+					category.New("y_axis", "Gaps", "Number of gaps between consecutive log messages"),
+					0, 7),
+				idToColorSpace("all").Define(),
+				xAxisRenderSettings.Apply(),
+				yAxisRenderSettings.Apply(),
+			)
+			// Interleaved, the logs have an entry every five minutes.
+			chart.AddSeries(
+				category.New("all", "all", "all"),
+				idToColorSpace("all").PrimaryColor(1),
+			).WithPoint(time.Duration(0), 7)
+		},
+	}, {
+		description: "top source files, both logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: topSourcesQuery,
+					Options: map[string]*util.V{
+						aggregateByKey: util.StringValue(sourceFileKey),
+						topKKey:        util.IntValue(2),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			tab := table.New(db, nil, topSourceCol, topEntriesCol, topFractionCol).With(
+				idToColorSpace("a.cc").Define(),
+				idToColorSpace("c.cc").Define(),
+				idToColorSpace("other").Define(),
+				util.StringsProperty(labelKey, "a.cc", "c.cc", "other"),
+				util.IntegersProperty(entriesKey, 4, 3, 1),
+			)
+			row := func(label string, entries int64) {
+				tab.Row(
+					table.Cell(topSourceCol, util.String(label)),
+					table.Cell(topEntriesCol, util.Integer(entries)),
+					table.Cell(topFractionCol, util.Double(float64(entries)/8)),
+				).With(
+					util.StringProperty(labelKey, label),
+					util.IntegerProperty(entriesKey, entries),
+					idToColorSpace(label).PrimaryColor(1),
+				)
+			}
+			row("a.cc", 4)
+			row("c.cc", 3)
+			// b.cc is aggregated into 'other'.
+			row("other", 1)
+		},
+	}, {
+		description: "top derived field values, correlated logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("correlated"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: topSourcesQuery,
+					Options: map[string]*util.V{
+						aggregateByKey: util.StringValue("field:handled"),
+						topKKey:        util.IntValue(5),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			tab := table.New(db, nil, topSourceCol, topEntriesCol, topFractionCol).With(
+				idToColorSpace("r1").Define(),
+				idToColorSpace("r2").Define(),
+				util.StringsProperty(labelKey, "r1", "r2"),
+				util.IntegersProperty(entriesKey, 1, 1),
+			)
+			// Only the two 'Handling' entries have the derived field.
+			for _, label := range []string{"r1", "r2"} {
+				tab.Row(
+					table.Cell(topSourceCol, util.String(label)),
+					table.Cell(topEntriesCol, util.Integer(1)),
+					table.Cell(topFractionCol, util.Double(.5)),
+				).With(
+					util.StringProperty(labelKey, label),
+					util.IntegerProperty(entriesKey, 1),
+					idToColorSpace(label).PrimaryColor(1),
+				)
+			}
+		},
+	}, {
+		description: "entry details, both logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: entryDetailsQuery,
+					Options: map[string]*util.V{
+						timestampKey:      util.TimestampValue(ts(15 * time.Minute)),
+						sourceLocNameKey:  util.StringValue("c.cc:20"),
+						contextEntriesKey: util.IntValue(1),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			db.With(
+				util.TimestampProperty(timestampKey, ts(15*time.Minute)),
+				util.StringProperty(levelNameKey, "Error"),
+				util.StringProperty(sourceLocNameKey, "c.cc:20"),
+				util.StringProperty(sourceFileKey, "c.cc"),
+				util.StringProperty(logKey, "log2"),
+				util.StringProperty(processKey, "log2"),
+				util.StringsProperty(messageKey, "Alert!"),
+				util.StringsProperty(filteredSourceFilesKey, "c.cc"),
+				util.TimestampProperty(startTimestampKey, ts(5*time.Minute)),
+				util.TimestampProperty(endTimestampKey, ts(25*time.Minute)),
+			)
+			t := table.New(db, renderSettings, eventCol).With(
+				colorSpacesByLevelWeight[0].Define(),
+				colorSpacesByLevelWeight[1].Define(),
+				colorSpacesByLevelWeight[2].Define(),
+				colorSpacesByLevelWeight[3].Define(),
+			)
+			// Only entries from the same process (here, log) are included.
+			row := func(at time.Duration, sourceLoc, sourceFile, message string) *table.RowNode {
+				return t.Row(
+					table.FormattedCell(eventCol, eventFormatStr,
+						util.TimestampProperty(timestampKey, ts(at)),
+						util.StringProperty(levelNameKey, "Error"),
+						util.StringProperty(sourceLocNameKey, sourceLoc),
+						util.StringsProperty(messageKey, message),
+					)).With(
+					colorSpacesByLevelWeight[1].PrimaryColor(1),
+					color.Secondary(highlightColor),
+					util.StringProperty(sourceFileKey, sourceFile),
+					util.TimestampProperty(timestampKey, ts(at)),
+				)
+			}
+			row(5*time.Minute, "c.cc:10", "c.cc", "Alert!")
+			row(15*time.Minute, "c.cc:20", "c.cc", "Alert!").With(
+				util.IntegerProperty(selectedKey, 1),
+			)
+			row(25*time.Minute, "a.cc:40", "a.cc", "ALERT!")
+		},
+	}, {
+		description: "entry details, no such entry",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: entryDetailsQuery,
+					Options: map[string]*util.V{
+						timestampKey:     util.TimestampValue(ts(15 * time.Minute)),
+						sourceLocNameKey: util.StringValue("c.cc:10"),
+					},
+				},
+			},
+		},
+		wantErr: true,
+	}, {
+		description: "aggregate table by source file, comparing two logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringsValue("log1", "log2"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: aggregateSourceFilesTableQuery,
+					Options:   map[string]*util.V{},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			log1Col, log2Col := collectionEntriesCol("log1"), collectionEntriesCol("log2")
+			deltaCol := collectionDeltaCol("log2", "log1")
+			t := table.New(db, renderSettings, sourceFileCol, log1Col, log2Col, deltaCol)
+			row := func(sourceFile string, log1Entries, log2Entries int64) {
+				t.Row(
+					table.Cell(sourceFileCol, util.String(sourceFile)),
+					table.Cell(log1Col, util.Integer(log1Entries)),
+					table.Cell(log2Col, util.Integer(log2Entries)),
+					table.Cell(deltaCol, util.Integer(log2Entries-log1Entries)),
+				).With(
+					util.StringProperty(sourceFileKey, sourceFile),
+					color.Secondary(highlightColor),
+				)
+			}
+			row("a.cc", 3, 1)
+			row("b.cc", 1, 0)
+			row("c.cc", 0, 3)
+		},
+	}, {
+		description: "timeseries, comparing two logs",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringsValue("log1", "log2"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: timeseriesQuery,
+					Options: map[string]*util.V{
+						binCountKey: util.IntValue(2),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			// Both logs span 30 minutes, so there's one 30-minute bin holding their
+			// first three entries, and a final bin holding their last entry.
+			chart := xychart.New(db,
+				continuousaxis.NewDurationAxis(
+					category.New("x_axis", "Time from start", "Time from the start of each log"),
+					0, 30*time.Minute),
+				continuousaxis.NewDoubleAxis(
+					category.New("y_axis", "Messages per minute", "Log messages per minute"),
+					0, 3.0/30),
+				idToColorSpace("log1").Define(),
+				idToColorSpace("log2").Define(),
+				xAxisRenderSettings.Apply(),
+				yAxisRenderSettings.Apply(),
+			)
+			for _, log := range []string{"log1", "log2"} {
+				chart.AddSeries(
+					category.New(log, log, log),
+					idToColorSpace(log).PrimaryColor(1),
+				).WithPoint(
+					time.Duration(0), 3.0/30,
+				).WithPoint(
+					30*time.Minute, 1.0/30,
+				)
+			}
+		},
+	}, {
+		description: "unsupported comparison",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringsValue("log1", "log2"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: rawEntriesQuery,
+					Options:   map[string]*util.V{},
+				},
+			},
+		},
+		wantErr: true,
+	}, {
+		description: "entries with repetitions folded",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("repeating"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: rawEntriesQuery,
+					Options: map[string]*util.V{
+						foldRepetitionsKey: util.IntValue(1),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			t := table.New(db, renderSettings, eventCol).With(
+				colorSpacesByLevelWeight[0].Define(),
+				colorSpacesByLevelWeight[1].Define(),
+				colorSpacesByLevelWeight[2].Define(),
+				colorSpacesByLevelWeight[3].Define(),
+			)
+			row := func(at time.Duration, level int, levelName, sourceLoc, sourceFile, message string) *table.RowNode {
+				return t.Row(
+					table.FormattedCell(eventCol, eventFormatStr,
+						util.TimestampProperty(timestampKey, ts(at)),
+						util.StringProperty(levelNameKey, levelName),
+						util.StringProperty(sourceLocNameKey, sourceLoc),
+						util.StringsProperty(messageKey, message),
+					)).With(
+					colorSpacesByLevelWeight[level].PrimaryColor(1),
+					color.Secondary(highlightColor),
+					util.StringProperty(sourceFileKey, sourceFile),
+					util.TimestampProperty(timestampKey, ts(at)),
+				)
+			}
+			row(0, 3, "Info", "a.cc:10", "a.cc", "Retrying").With(
+				util.IntegerProperty(repetitionsKey, 3),
+				util.TimestampProperty(firstTimestampKey, ts(0)),
+				util.TimestampProperty(lastTimestampKey, ts(2*time.Minute)),
+			)
+			row(3*time.Minute, 1, "Error", "b.cc:10", "b.cc", "Gave up")
+			// Only consecutive repetitions are folded.
+			row(4*time.Minute, 3, "Info", "a.cc:10", "a.cc", "Retrying")
+		},
+	}, {
+		description: "patterns",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("repeating"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: patternsQuery,
+					Options: map[string]*util.V{
+						binCountKey:    util.IntValue(2),
+						maxExamplesKey: util.IntValue(1),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			t := table.New(db, renderSettings, templateCol, templateEntriesCol)
+			row := func(template string, entries int64, trend []int64, first, last time.Duration) {
+				t.Row(
+					table.Cell(templateCol, util.String(template)),
+					table.Cell(templateEntriesCol, util.Integer(entries)),
+				).With(
+					util.StringProperty(templateKey, template),
+					util.IntegersProperty(trendKey, trend...),
+					util.StringsProperty(examplesKey, template),
+					util.TimestampProperty(firstTimestampKey, ts(first)),
+					util.TimestampProperty(lastTimestampKey, ts(last)),
+				)
+			}
+			row("Retrying", 4, []int64{3, 1}, 0, 4*time.Minute)
+			row("Gave up", 1, []int64{1, 0}, 3*time.Minute, 3*time.Minute)
+		},
+	}, {
+		description: "patterns, range not divisible into bins",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("repeating"),
+				startTimestampKey: util.TimestampValue(ts(4*time.Minute - 10)),
+				endTimestampKey:   util.TimestampValue(ts(4 * time.Minute)),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: patternsQuery,
+					Options: map[string]*util.V{
+						binCountKey: util.IntValue(5),
+					},
+				},
+			},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			// The 10ns range is covered by four 2ns-wide bins, so the entry at its
+			// end lies past the last bin, and is clamped into it.
+			table.New(db, renderSettings, templateCol, templateEntriesCol).Row(
+				table.Cell(templateCol, util.String("Retrying")),
+				table.Cell(templateEntriesCol, util.Integer(1)),
+			).With(
+				util.StringProperty(templateKey, "Retrying"),
+				util.IntegersProperty(trendKey, 0, 0, 0, 0, 1),
+				util.StringsProperty(examplesKey, "Retrying"),
+				util.TimestampProperty(firstTimestampKey, ts(4*time.Minute)),
+				util.TimestampProperty(lastTimestampKey, ts(4*time.Minute)),
+			)
+		},
+	}, {
+		description: "gap histogram, bad bin count",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{
+					QueryName: gapHistogramQuery,
+					Options: map[string]*util.V{
+						binCountKey: util.IntValue(0),
+					},
+				},
+			},
+		},
+		wantErr: true,
+		// }, {
+		// 	description: "trace, cockroachdb logs",
+		// 	req: &util.DataRequest{
+		// 		GlobalFilters: map[string]*util.V{
+		// 			collectionNameKey: util.StringValue("both"),
+		// 		},
+		// 		SeriesRequests: []*util.DataSeriesRequest{
+		// 			{
+		// 				QueryName: traceQuery,
+		// 				Options: map[string]*util.V{
+		// 				},
+		// 			},
+		// 		},
+		// 	},
+		// 	wantSeries: func(series util.DataBuilder) {
+		// 	},
+		}, {
+			description: "zoom in",
+			req: &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					collectionNameKey: util.StringValue("log1"),
+					startTimestampKey: util.TimestampValue(ts(time.Minute * 0)),
+					endTimestampKey:   util.TimestampValue(ts(time.Minute * 30)),
+					zoomKey:           util.StringValue("in"),
+				},
+				SeriesRequests: []*util.DataSeriesRequest{
+					&util.DataSeriesRequest{
+						QueryName: panAndZoomQuery,
+					},
+				},
+			},
+			wantSeries: func(db util.DataBuilder) {
+				// Zooming into 30-minute range centered at 15m, with a zoom factor of 2,
+				// yields a 15-minute range centered at 15m.
+				db.With(
+					util.TimestampProperty(startTimestampKey, ts(time.Second*(7.5*60))),
+					util.TimestampProperty(endTimestampKey, ts(time.Second*(22.5*60))),
+				)
+			},
+		}, {
+			description: "zoom out",
+			req: &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					collectionNameKey: util.StringValue("log1"),
+					startTimestampKey: util.TimestampValue(ts(time.Minute * 12)),
+					endTimestampKey:   util.TimestampValue(ts(time.Minute * 18)),
+					zoomKey:           util.StringValue("out"),
+				},
+				SeriesRequests: []*util.DataSeriesRequest{
+					&util.DataSeriesRequest{
+						QueryName: panAndZoomQuery,
+					},
+				},
+			},
+			wantSeries: func(db util.DataBuilder) {
+				// Zooming out from a 6-minute range centered at 15m, with a zoom factor
+				// of 2, yields a 12-minute range centered at 15m.
+				db.With(
+					util.TimestampProperty(startTimestampKey, ts(time.Minute*9)),
+					util.TimestampProperty(endTimestampKey, ts(time.Minute*21)),
+				)
+			},
+		}, {
+			description: "pan left",
+			req: &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					collectionNameKey: util.StringValue("log1"),
+					startTimestampKey: util.TimestampValue(ts(time.Minute * 12)),
+					endTimestampKey:   util.TimestampValue(ts(time.Minute * 18)),
+					panKey:            util.StringValue("left"),
+				},
+				SeriesRequests: []*util.DataSeriesRequest{
+					&util.DataSeriesRequest{
+						QueryName: panAndZoomQuery,
+					},
+				},
+			},
+			wantSeries: func(db util.DataBuilder) {
+				// Panning left in a 6-minute range centered at 15m yields a 6-minute
+				// range centered at 12m
+				db.With(
+					util.TimestampProperty(startTimestampKey, ts(time.Minute*9)),
+					util.TimestampProperty(endTimestampKey, ts(time.Minute*15)),
+				)
+			},
+		}, {
+			description: "pan right",
+			req: &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					collectionNameKey: util.StringValue("log1"),
+					startTimestampKey: util.TimestampValue(ts(time.Minute * 12)),
+					endTimestampKey:   util.TimestampValue(ts(time.Minute * 18)),
+					panKey:            util.StringValue("right"),
 				},
 				SeriesRequests: []*util.DataSeriesRequest{
 					&util.DataSeriesRequest{
@@ -502,35 +1693,777 @@ func TestQueries(t *testing.T) {
 					},
 				},
 			},
-			wantSeries: func(db util.DataBuilder) {
-				// Panning left in a 6-minute range centered at 15m yields a 6-minute
-				// range centered at 18m
-				db.With(
-					util.TimestampProperty(startTimestampKey, ts(time.Minute*15)),
-					util.TimestampProperty(endTimestampKey, ts(time.Minute*21)),
-				)
+			wantSeries: func(db util.DataBuilder) {
+				// Panning left in a 6-minute range centered at 15m yields a 6-minute
+				// range centered at 18m
+				db.With(
+					util.TimestampProperty(startTimestampKey, ts(time.Minute*15)),
+					util.TimestampProperty(endTimestampKey, ts(time.Minute*21)),
+				)
+			},
+		}} {
+		t.Run(test.description, func(t *testing.T) {
+			ds, err := New(10, &testLogTraceFetcher{})
+			if err != nil {
+				t.Fatalf("Unexpected failure creating data source: %s", err)
+			}
+			qd, err := querydispatcher.New(ds)
+			if err != nil {
+				t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+			}
+			gotData, err := qd.HandleDataRequest(context.Background(), test.req)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error status: got %s", err)
+			}
+			if err != nil {
+				return
+			}
+			drb := util.NewDataResponseBuilder()
+			test.wantSeries(drb.DataSeries(test.req.SeriesRequests[0]))
+			if err := testutil.CompareDataResponses(t, gotData, drb); err != nil {
+				t.Fatalf("Failed to compare data responses: %s", err)
+			}
+		})
+	}
+}
+
+func TestGlobalFilterEcho(t *testing.T) {
+	ds, err := New(10, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	qd, err := querydispatcher.New(ds)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	for _, test := range []struct {
+		description string
+		filters     map[string]*util.V
+		want        map[string]*util.V
+	}{{
+		description: "unchanged",
+		filters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+			startTimestampKey: util.TimestampValue(ts(0)),
+			endTimestampKey:   util.TimestampValue(ts(30 * time.Minute)),
+		},
+		want: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+			startTimestampKey: util.TimestampValue(ts(0)),
+			endTimestampKey:   util.TimestampValue(ts(30 * time.Minute)),
+		},
+	}, {
+		description: "defaulted",
+		filters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+		},
+		want: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+			startTimestampKey: util.TimestampValue(ts(0)),
+			endTimestampKey:   util.TimestampValue(ts(30 * time.Minute)),
+		},
+	}, {
+		description: "clamped and zoomed",
+		filters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+			startTimestampKey: util.TimestampValue(ts(-time.Hour)),
+			endTimestampKey:   util.TimestampValue(ts(time.Hour)),
+			zoomKey:           util.StringValue(zoomIn),
+		},
+		want: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+			startTimestampKey: util.TimestampValue(ts(7*time.Minute + 30*time.Second)),
+			endTimestampKey:   util.TimestampValue(ts(22*time.Minute + 30*time.Second)),
+			zoomKey:           util.StringValue(none),
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			data, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+				GlobalFilters: test.filters,
+				SeriesRequests: []*util.DataSeriesRequest{{
+					QueryName:  panAndZoomQuery,
+					SeriesName: "1",
+				}},
+			})
+			if err != nil {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, data.GlobalFilters); diff != "" {
+				t.Errorf("GlobalFilters diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAnnotationMarkers(t *testing.T) {
+	store, err := annotations.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Unexpected failure creating annotation store: %s", err)
+	}
+	spike := &annotations.Annotation{
+		Collection: "log1",
+		Start:      ts(5 * time.Minute),
+		End:        ts(15 * time.Minute),
+		Note:       "warning spike",
+	}
+	for _, a := range []*annotations.Annotation{spike, {
+		Collection: "log2",
+		Start:      ts(0),
+		End:        ts(time.Hour),
+		Note:       "another log",
+	}} {
+		if err := store.Create(a); err != nil {
+			t.Fatalf("Unexpected failure creating annotation: %s", err)
+		}
+	}
+	ds, err := New(10, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	qd, err := querydispatcher.New(ds.WithAnnotations(store))
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log1"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  rawEntriesQuery,
+			SeriesName: "entries",
+			Options: map[string]*util.V{
+				searchRegexKey: util.StringValue("problem|here"),
+			},
+		}, {
+			QueryName:  timeseriesQuery,
+			SeriesName: "timeseries",
+			Options: map[string]*util.V{
+				aggregateByKey: util.StringValue(levelNameKey),
+				binCountKey:    util.IntValue(4),
 			},
-		}} {
+		}},
+	}
+	gotData, err := qd.HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	// Only the entry at 10m lies within the annotation.
+	drb := util.NewDataResponseBuilder()
+	tbl := table.New(drb.DataSeries(req.SeriesRequests[0]), renderSettings, eventCol).With(
+		colorSpacesByLevelWeight[0].Define(),
+		colorSpacesByLevelWeight[1].Define(),
+		colorSpacesByLevelWeight[2].Define(),
+		colorSpacesByLevelWeight[3].Define(),
+	)
+	tbl.Row(
+		table.FormattedCell(eventCol, eventFormatStr,
+			util.TimestampProperty(timestampKey, ts(10*time.Minute)),
+			util.StringProperty(levelNameKey, "Warning"),
+			util.StringProperty(sourceLocNameKey, "a.cc:20"),
+			util.StringsProperty(messageKey, "We have a problem..."),
+		)).With(
+		color.Secondary(highlightColor),
+		colorSpacesByLevelWeight[2].PrimaryColor(1),
+		util.StringProperty(sourceFileKey, "a.cc"),
+		util.TimestampProperty(timestampKey, ts(10*time.Minute)),
+		table.PropertyMatchRanges(eventCol, messageKey, table.MatchRange{Start: 10, End: 17}),
+		util.StringsProperty(annotationIDsKey, spike.ID),
+		util.StringsProperty(annotationNotesKey, "warning spike"),
+	)
+	tbl.Row(
+		table.FormattedCell(eventCol, eventFormatStr,
+			util.TimestampProperty(timestampKey, ts(20*time.Minute)),
+			util.StringProperty(levelNameKey, "Info"),
+			util.StringProperty(sourceLocNameKey, "a.cc:30"),
+			util.StringsProperty(messageKey, "Still here"),
+		)).With(
+		colorSpacesByLevelWeight[3].PrimaryColor(1),
+		color.Secondary(highlightColor),
+		util.StringProperty(sourceFileKey, "a.cc"),
+		util.TimestampProperty(timestampKey, ts(20*time.Minute)),
+		table.PropertyMatchRanges(eventCol, messageKey, table.MatchRange{Start: 6, End: 10}),
+	)
+	wantData, err := drb.Data()
+	if err != nil {
+		t.Fatalf("Unexpected failure building wanted data: %s", err)
+	}
+	gotEntries := &util.Data{
+		StringTable: gotData.StringTable,
+		DataSeries:  gotData.DataSeries[:1],
+	}
+	if err := testutil.CompareDataResponses(t, gotEntries, wantData); err != nil {
+		t.Fatalf("Failed to compare data responses: %s", err)
+	}
+	// In the timeseries, with 10-minute bins, the annotation overlaps the
+	// first two bins of each of the three level series.
+	var markedPoints int
+	for _, series := range gotData.DataSeries[1].Root.Children[1:] {
+		for _, point := range series.Children {
+			if ids, ok := point.PropertyStrings(gotData.StringTable, annotationIDsKey); ok {
+				if diff := cmp.Diff([]string{spike.ID}, ids); diff != "" {
+					t.Errorf("Marked point has annotation IDs diff (-want +got):\n%s", diff)
+				}
+				markedPoints++
+			}
+		}
+	}
+	if markedPoints != 6 {
+		t.Errorf("Timeseries has %d marked points, want 6", markedPoints)
+	}
+}
+
+func TestQueryTimeRedaction(t *testing.T) {
+	config, err := redact.ParseConfig([]byte(`{"stage": "query", "patterns": [{"name": "issue", "regex": "problem"}]}`))
+	if err != nil {
+		t.Fatalf("Unexpected failure parsing redaction config: %s", err)
+	}
+	r, err := config.Redactor()
+	if err != nil {
+		t.Fatalf("Unexpected failure creating redactor: %s", err)
+	}
+	ds, err := New(10, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	qd, err := querydispatcher.New(ds.WithRedactor(r))
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	for _, test := range []struct {
+		description string
+		searchRegex string
+		buildData   func(db util.DataBuilder)
+	}{{
+		description: "redacted content is masked",
+		searchRegex: "REDACTED|here",
+		buildData: func(db util.DataBuilder) {
+			tbl := table.New(db, renderSettings, eventCol).With(
+				colorSpacesByLevelWeight[0].Define(),
+				colorSpacesByLevelWeight[1].Define(),
+				colorSpacesByLevelWeight[2].Define(),
+				colorSpacesByLevelWeight[3].Define(),
+			)
+			tbl.Row(
+				table.FormattedCell(eventCol, eventFormatStr,
+					util.TimestampProperty(timestampKey, ts(10*time.Minute)),
+					util.StringProperty(levelNameKey, "Warning"),
+					util.StringProperty(sourceLocNameKey, "a.cc:20"),
+					util.StringsProperty(messageKey, "We have a [REDACTED:issue]..."),
+				)).With(
+				color.Secondary(highlightColor),
+				colorSpacesByLevelWeight[2].PrimaryColor(1),
+				util.StringProperty(sourceFileKey, "a.cc"),
+				util.TimestampProperty(timestampKey, ts(10*time.Minute)),
+				table.PropertyMatchRanges(eventCol, messageKey, table.MatchRange{Start: 11, End: 19}),
+			)
+			tbl.Row(
+				table.FormattedCell(eventCol, eventFormatStr,
+					util.TimestampProperty(timestampKey, ts(20*time.Minute)),
+					util.StringProperty(levelNameKey, "Info"),
+					util.StringProperty(sourceLocNameKey, "a.cc:30"),
+					util.StringsProperty(messageKey, "Still here"),
+				)).With(
+				colorSpacesByLevelWeight[3].PrimaryColor(1),
+				color.Secondary(highlightColor),
+				util.StringProperty(sourceFileKey, "a.cc"),
+				util.TimestampProperty(timestampKey, ts(20*time.Minute)),
+				table.PropertyMatchRanges(eventCol, messageKey, table.MatchRange{Start: 6, End: 10}),
+			)
+		},
+	}, {
+		description: "redacted content is not searchable",
+		searchRegex: "problem",
+		buildData: func(db util.DataBuilder) {
+			table.New(db, renderSettings, eventCol).With(
+				colorSpacesByLevelWeight[0].Define(),
+				colorSpacesByLevelWeight[1].Define(),
+				colorSpacesByLevelWeight[2].Define(),
+				colorSpacesByLevelWeight[3].Define(),
+			)
+		},
+	}} {
 		t.Run(test.description, func(t *testing.T) {
-			ds, err := New(10, &testLogTraceFetcher{})
+			req := &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					collectionNameKey: util.StringValue("log1"),
+				},
+				SeriesRequests: []*util.DataSeriesRequest{{
+					QueryName:  rawEntriesQuery,
+					SeriesName: "entries",
+					Options: map[string]*util.V{
+						searchRegexKey: util.StringValue(test.searchRegex),
+					},
+				}},
+			}
+			gotData, err := qd.HandleDataRequest(context.Background(), req)
 			if err != nil {
-				t.Fatalf("Unexpected failure creating data source: %s", err)
+				t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
 			}
-			qd, err := querydispatcher.New(ds)
+			drb := util.NewDataResponseBuilder()
+			test.buildData(drb.DataSeries(req.SeriesRequests[0]))
+			wantData, err := drb.Data()
 			if err != nil {
-				t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+				t.Fatalf("Unexpected failure building wanted data: %s", err)
 			}
-			gotData, err := qd.HandleDataRequest(context.Background(), test.req)
-			if (err != nil) != test.wantErr {
-				t.Fatalf("Unexpected error status: got %s", err)
+			if err := testutil.CompareDataResponses(t, gotData, wantData); err != nil {
+				t.Fatalf("Failed to compare data responses: %s", err)
+			}
+		})
+	}
+}
+
+// watchingTestLogTraceFetcher is a testLogTraceFetcher whose collections may
+// be changed by closing their channels.
+type watchingTestLogTraceFetcher struct {
+	testLogTraceFetcher
+	changed map[string]chan struct{}
+}
+
+func (wtlf *watchingTestLogTraceFetcher) Changed(ctx context.Context, collectionName string) (<-chan struct{}, error) {
+	if ch, ok := wtlf.changed[collectionName]; ok {
+		return ch, nil
+	}
+	return nil, nil
+}
+
+func TestChanged(t *testing.T) {
+	fetcher := &watchingTestLogTraceFetcher{
+		changed: map[string]chan struct{}{
+			"log2": make(chan struct{}),
+		},
+	}
+	ds, err := New(10, fetcher)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if ch, err := ds.Changed(ctx, map[string]*util.V{
+		collectionNameKey: util.StringValue("log1"),
+	}); err != nil || ch != nil {
+		t.Errorf("Changed() of an unwatched collection = %v, %v; want nil, nil", ch, err)
+	}
+	if _, err := ds.fetchCollection(ctx, "log2"); err != nil {
+		t.Fatalf("Unexpected failure fetching collection: %s", err)
+	}
+	ch, err := ds.Changed(ctx, map[string]*util.V{
+		collectionNameKey: util.StringsValue("log1", "log2"),
+	})
+	if err != nil || ch == nil {
+		t.Fatalf("Changed() of a watched collection = %v, %v; want a channel", ch, err)
+	}
+	close(fetcher.changed["log2"])
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Changed() channel was not closed after a change")
+	}
+	if ds.lru.Contains("log2") {
+		t.Errorf("Changed collection was not evicted from the cache")
+	}
+}
+
+// testCollectionObserver records collection lifecycle events.
+type testCollectionObserver struct {
+	events []string
+}
+
+func (tco *testCollectionObserver) CollectionLoaded(collectionName string, collection any) {
+	if _, ok := collection.(*Collection); !ok {
+		tco.events = append(tco.events, "loaded non-Collection "+collectionName)
+		return
+	}
+	tco.events = append(tco.events, "loaded "+collectionName)
+}
+
+func (tco *testCollectionObserver) CollectionEvicted(collectionName string) {
+	tco.events = append(tco.events, "evicted "+collectionName)
+}
+
+func TestObserveCollections(t *testing.T) {
+	ds, err := New(2, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	observer := &testCollectionObserver{}
+	ds.ObserveCollections(observer)
+	ctx := context.Background()
+	for _, collectionName := range []string{"log1", "log2", "log1", "repeating"} {
+		if _, err := ds.fetchCollection(ctx, collectionName); err != nil {
+			t.Fatalf("Unexpected failure fetching collection: %s", err)
+		}
+	}
+	ds.OnCollectionEvicted("log1")
+	ds.OnCollectionEvicted("log2")
+	want := []string{
+		"loaded log1",
+		"loaded log2",
+		"evicted log2",
+		"loaded repeating",
+		"evicted log1",
+	}
+	if diff := cmp.Diff(want, observer.events); diff != "" {
+		t.Errorf("Got collection events diff (-want +got):\n%s", diff)
+	}
+	if ds.lru.Contains("log1") {
+		t.Errorf("Evicted collection remained in the cache")
+	}
+}
+
+func TestCollectionClosedOnEviction(t *testing.T) {
+	ds, err := New(1, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	ctx := context.Background()
+	log1, err := ds.fetchCollection(ctx, "log1")
+	if err != nil {
+		t.Fatalf("Unexpected failure fetching collection: %s", err)
+	}
+	// Evicts log1, which is still in use.
+	log2, err := ds.fetchCollection(ctx, "log2")
+	if err != nil {
+		t.Fatalf("Unexpected failure fetching collection: %s", err)
+	}
+	log2.release()
+	if !log1.closed || log1.refs != 1 {
+		t.Errorf("Evicted in-use collection has closed %t and %d references, want closed with 1 reference", log1.closed, log1.refs)
+	}
+	if log1.acquire() {
+		t.Errorf("Evicted collection was acquired")
+	}
+	log1.release()
+	if log1.refs != 0 {
+		t.Errorf("Released collection has %d references, want 0", log1.refs)
+	}
+	refetched, err := ds.fetchCollection(ctx, "log1")
+	if err != nil {
+		t.Fatalf("Unexpected failure fetching collection: %s", err)
+	}
+	defer refetched.release()
+	if refetched == log1 {
+		t.Errorf("Evicted collection was reused")
+	}
+}
+
+func TestConcurrentCacheAccess(t *testing.T) {
+	ds, err := New(1, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		collectionName := []string{"log1", "log2"}[idx%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for iter := 0; iter < 10; iter++ {
+				coll, err := ds.fetchCollection(ctx, collectionName)
+				if err != nil {
+					t.Errorf("Unexpected failure fetching collection: %s", err)
+					return
+				}
+				coll.release()
+				ds.OnCollectionEvicted(collectionName)
 			}
+		}()
+	}
+	wg.Wait()
+}
+
+// racingFetcher is a LogTraceFetcher whose fetches each return a new
+// Collection, and wait until the expected number of fetches are under way.
+type racingFetcher struct {
+	tlf     testLogTraceFetcher
+	mu      sync.Mutex
+	fetched []*Collection
+	waiting sync.WaitGroup
+}
+
+func (rf *racingFetcher) Fetch(ctx context.Context, collectionName string) (*Collection, error) {
+	coll, err := rf.tlf.Fetch(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	rf.mu.Lock()
+	rf.fetched = append(rf.fetched, coll)
+	rf.mu.Unlock()
+	rf.waiting.Done()
+	rf.waiting.Wait()
+	return coll, nil
+}
+
+func TestConcurrentFetchClosesUncached(t *testing.T) {
+	const fetches = 4
+	rf := &racingFetcher{}
+	rf.waiting.Add(fetches)
+	ds, err := New(10, rf)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	got := make([]*Collection, fetches)
+	var wg sync.WaitGroup
+	for idx := range got {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			coll, err := ds.fetchCollection(context.Background(), "log1")
 			if err != nil {
+				t.Errorf("Unexpected failure fetching collection: %s", err)
 				return
 			}
-			drb := util.NewDataResponseBuilder()
-			test.wantSeries(drb.DataSeries(test.req.SeriesRequests[0]))
-			if err := testutil.CompareDataResponses(t, gotData, drb); err != nil {
-				t.Fatalf("Failed to compare data responses: %s", err)
+			got[idx] = coll
+		}(idx)
+	}
+	wg.Wait()
+	if len(rf.fetched) != fetches {
+		t.Fatalf("Fetcher was called %d times, want %d", len(rf.fetched), fetches)
+	}
+	for idx, coll := range got {
+		if coll != got[0] {
+			t.Errorf("Fetch %d yielded a different collection than fetch 0", idx)
+		}
+		coll.release()
+	}
+	for _, coll := range rf.fetched {
+		if wantClosed := coll != got[0]; coll.closed != wantClosed {
+			t.Errorf("Fetched collection closed %t, want %t", coll.closed, wantClosed)
+		}
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	ds, err := New(10, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	qd, err := querydispatcher.New(ds)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, seriesReq := range []*util.DataSeriesRequest{{
+		QueryName:  rawEntriesQuery,
+		SeriesName: "1",
+	}, {
+		QueryName:  sourceTreeQuery,
+		SeriesName: "1",
+		Options: map[string]*util.V{
+			viewportWidthPxKey: util.IntValue(100),
+		},
+	}} {
+		_, err := qd.HandleDataRequest(ctx, &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("both"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{seriesReq},
+		})
+		if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+			t.Errorf("HandleDataRequest(%s) with a canceled Context yielded %v, want %v", seriesReq.QueryName, err, context.Canceled)
+		}
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	ds, err := New(10, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	qd, err := querydispatcher.New(ds)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	if err := qd.Warmup(context.Background(), map[string]*util.V{
+		collectionNameKey: util.StringsValue("both", "repeating"),
+	}); err != nil {
+		t.Fatalf("Warmup() yielded unexpected error %s", err)
+	}
+	for _, collectionName := range []string{"both", "repeating"} {
+		if !ds.lru.Contains(collectionName) {
+			t.Errorf("Warmup() didn't cache collection '%s'", collectionName)
+		}
+	}
+	if err := qd.Warmup(context.Background(), map[string]*util.V{
+		collectionNameKey: util.StringValue("missing"),
+	}); err == nil {
+		t.Errorf("Warmup() of a missing collection yielded no error")
+	}
+}
+
+func TestBinBounds(t *testing.T) {
+	ds, err := New(10, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	qd, err := querydispatcher.New(ds)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	// The 35-minute range of 'both' isn't evenly divisible into 1999999
+	// nanosecond-granular bins, so the entry at its end would lie past the
+	// last bin were it not clamped; and so many bins are reduced to
+	// maxBinCount.
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("both"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  timeseriesQuery,
+			SeriesName: "timeseries",
+			Options: map[string]*util.V{
+				aggregateByKey: util.StringValue(levelNameKey),
+				binCountKey:    util.IntValue(2000000),
+			},
+		}},
+	}
+	gotData, err := qd.HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if len(gotData.DataSeries) != 1 || gotData.DataSeries[0].Root == nil || len(gotData.DataSeries[0].Root.Children) < 2 {
+		t.Fatalf("HandleDataRequest() yielded unexpected response %v", gotData)
+	}
+	for _, series := range gotData.DataSeries[0].Root.Children[1:] {
+		if len(series.Children) != maxBinCount {
+			t.Errorf("Timeseries series has %d points, want %d", len(series.Children), maxBinCount)
+		}
+	}
+	for _, test := range []struct {
+		t       time.Time
+		wantBin int
+	}{
+		{ts(0), 0},
+		{ts(time.Minute - 1), 0},
+		{ts(time.Minute), 1},
+		{ts(10 * time.Minute), 3},
+	} {
+		if got := binIndex(test.t, ts(0), time.Minute, 4); got != test.wantBin {
+			t.Errorf("binIndex(%s) = %d, want %d", test.t, got, test.wantBin)
+		}
+	}
+}
+
+func TestProcessTraceCategoryIDs(t *testing.T) {
+	ds, err := New(10, &testLogTraceFetcher{})
+	if err != nil {
+		t.Fatalf("Unexpected failure creating data source: %s", err)
+	}
+	qd, err := querydispatcher.New(ds)
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	// Both processes in 'both' log from a.cc.
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("both"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  processTraceQuery,
+			SeriesName: "trace",
+		}},
+	}
+	gotData, err := qd.HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if len(gotData.DataSeries) != 1 || gotData.DataSeries[0].Root == nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected response %v", gotData)
+	}
+	var aCCIDs []string
+	seen := map[string]bool{}
+	var visit func(datum *util.Datum)
+	visit = func(datum *util.Datum) {
+		if id, ok := datum.PropertyString(gotData.StringTable, keys.CategoryDefinedID); ok {
+			if seen[id] {
+				t.Errorf("Category ID '%s' is defined more than once", id)
+			}
+			seen[id] = true
+			if file, ok := datum.PropertyString(gotData.StringTable, sourceFileKey); ok && file == "a.cc" {
+				aCCIDs = append(aCCIDs, id)
+			}
+		}
+		for _, child := range datum.Children {
+			visit(child)
+		}
+	}
+	visit(gotData.DataSeries[0].Root)
+	if diff := cmp.Diff([]string{"log1/a.cc", "log2/a.cc"}, aCCIDs); diff != "" {
+		t.Errorf("a.cc category IDs diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestFindAnomalies(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		points      []float64
+		window      int
+		sigma       float64
+		want        []*anomaly
+	}{{
+		description: "spike after flat baseline",
+		points:      []float64{1, 1, 1, 1, 5, 1},
+		window:      3,
+		sigma:       2,
+		want:        []*anomaly{nil, nil, nil, nil, {baseline: 1, sigmas: math.Inf(1)}, nil},
+	}, {
+		description: "spike after noisy baseline",
+		points:      []float64{2, 4, 2, 4, 10},
+		window:      4,
+		sigma:       3,
+		want:        []*anomaly{nil, nil, nil, nil, {baseline: 3, sigmas: 7}},
+	}, {
+		description: "deviation within sigma",
+		points:      []float64{2, 4, 2, 4, 5},
+		window:      4,
+		sigma:       3,
+		want:        []*anomaly{nil, nil, nil, nil, nil},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			got := findAnomalies(test.points, test.window, test.sigma)
+			if diff := cmp.Diff(test.want, got, cmp.AllowUnexported(anomaly{})); diff != "" {
+				t.Errorf("findAnomalies() = %v, diff (-want +got): %s", got, diff)
+			}
+		})
+	}
+}
+
+func TestCollectionSummaries(t *testing.T) {
+	coll, err := (&testLogTraceFetcher{}).Fetch(context.Background(), "both")
+	if err != nil {
+		t.Fatalf("failed to fetch collection: %s", err)
+	}
+	var widths []time.Duration
+	for _, tb := range coll.lt.TimeBuckets() {
+		widths = append(widths, tb.Width)
+	}
+	if diff := cmp.Diff([]time.Duration{time.Second, time.Minute, time.Hour}, widths); diff != "" {
+		t.Errorf("Collection summary widths: diff (-want +got) %s", diff)
+	}
+	for _, test := range []struct {
+		binWidth  time.Duration
+		wantWidth time.Duration
+	}{{
+		binWidth:  5 * time.Second,
+		wantWidth: 0,
+	}, {
+		binWidth:  time.Minute,
+		wantWidth: time.Second,
+	}, {
+		binWidth:  15 * time.Minute,
+		wantWidth: time.Minute,
+	}, {
+		binWidth:  24 * time.Hour,
+		wantWidth: time.Hour,
+	}} {
+		t.Run(test.binWidth.String(), func(t *testing.T) {
+			var gotWidth time.Duration
+			if tb := coll.timeBucketsFor(test.binWidth); tb != nil {
+				gotWidth = tb.Width
+			}
+			if gotWidth != test.wantWidth {
+				t.Errorf("timeBucketsFor(%v) had width %v, want %v", test.binWidth, gotWidth, test.wantWidth)
 			}
 		})
 	}
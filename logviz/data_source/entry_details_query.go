@@ -0,0 +1,145 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datasource
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// The default number of entries before and after an inspected entry included
+// in its details.
+const defaultContextEntries = 5
+
+// errContextGathered stops iteration once enough context entries are found.
+var errContextGathered = errors.New("context entries gathered")
+
+// handleEntryDetailsQuery emits the details of the single entry logged at the
+// requested timestamp from the requested source location, as identified in
+// raw entry rows.  The entry's fields, including its complete multi-line
+// message, are emitted as series properties, alongside the source file, process,
+// and time range filters that would focus the view on it.  The entry itself,
+// marked as selected, and the context_entries entries before and after it from
+// the same process are emitted as a table of raw events.  The entry is sought
+// irrespective of any global filters.
+func handleEntryDetailsQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	// Handle query parameters.
+	var timestamp time.Time
+	var sourceLocName string
+	contextEntries := int64(defaultContextEntries)
+	var err error
+	for key, val := range reqOpts {
+		switch key {
+		case timestampKey:
+			timestamp, err = util.ExpectTimestampValue(val)
+		case sourceLocNameKey:
+			sourceLocName, err = util.ExpectStringValue(val)
+		case contextEntriesKey:
+			contextEntries, err = util.ExpectIntegerValue(val)
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if timestamp.IsZero() || sourceLocName == "" {
+		return fmt.Errorf("entry details require options '%s' and '%s'", timestampKey, sourceLocNameKey)
+	}
+	if contextEntries < 0 {
+		return fmt.Errorf("entry details option '%s' must not be negative", contextEntriesKey)
+	}
+	isTarget := func(entry *logtrace.Entry) bool {
+		return entry.Time.Equal(timestamp) && entry.SourceLocation.Identifier() == sourceLocName
+	}
+	// Find the requested entry.
+	var target *logtrace.Entry
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
+		if target == nil && isTarget(entry) {
+			target = entry
+		}
+		return nil
+	}, logtrace.WithStartTime(timestamp), logtrace.WithEndTime(timestamp)); err != nil {
+		return err
+	}
+	if target == nil {
+		return fmt.Errorf("no log entry from %s at %s", sourceLocName, timestamp)
+	}
+	// Gather the surrounding entries from the same process, or from the same
+	// log if the process is unknown.
+	sameProcess := logtrace.WithLogs(target.Log)
+	if target.Process != nil {
+		sameProcess = logtrace.WithProcesses(target.Process)
+	}
+	var before, after []*logtrace.Entry
+	found := false
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
+		switch {
+		case !found && isTarget(entry):
+			found = true
+		case !found:
+			before = append(before, entry)
+			if int64(len(before)) > contextEntries {
+				before = before[1:]
+			}
+		case int64(len(after)) < contextEntries:
+			after = append(after, entry)
+		default:
+			return errContextGathered
+		}
+		return nil
+	}, sameProcess); err != nil && err != errContextGathered {
+		return err
+	}
+	// Emit the entry's fields, and the properties needed to filter to its
+	// source file, its process, and its surrounding entries.
+	contextStart, contextEnd := target.Time, target.Time
+	if len(before) > 0 {
+		contextStart = before[0].Time
+	}
+	if len(after) > 0 {
+		contextEnd = after[len(after)-1].Time
+	}
+	sourceFile := target.SourceLocation.SourceFile.Identifier()
+	series.With(
+		util.TimestampProperty(timestampKey, target.Time),
+		util.StringProperty(levelNameKey, target.Level.DisplayName()),
+		util.StringProperty(sourceLocNameKey, target.SourceLocation.DisplayName()),
+		util.StringProperty(sourceFileKey, sourceFile),
+		util.StringProperty(logKey, target.Log.DisplayName()),
+		util.StringProperty(processKey, processID(target)),
+		util.StringsProperty(messageKey, target.Message...),
+		util.StringsProperty(filteredSourceFilesKey, sourceFile),
+		util.TimestampProperty(startTimestampKey, contextStart),
+		util.TimestampProperty(endTimestampKey, contextEnd),
+	)
+	// Emit the entry in context as a table of raw events.
+	t := table.New(series, renderSettings, eventCol)
+	for _, colorSpace := range colorSpacesByLevelWeight {
+		t.With(colorSpace.Define())
+	}
+	for _, entry := range before {
+		entryRow(t, entry)
+	}
+	entryRow(t, target).With(util.IntegerProperty(selectedKey, 1))
+	for _, entry := range after {
+		entryRow(t, entry)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datasource
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/util"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+// allEntriesSeriesID is the ID of the single gap histogram series produced
+// when no aggregation is requested.
+const allEntriesSeriesID = "all"
+
+// handleGapHistogramQuery emits the distribution of time gaps between
+// consecutive filtered-in entries as an xy chart, with one series per
+// aggregation group.  Gaps are only measured between entries in the same
+// group, so aggregating by process, source file, or the value of a derived
+// field ('field:<name>') reveals stalls and bursts in each individually.  Each of the bin_count bins includes its lower bound
+// and excludes its upper bound, except the last, which also includes the
+// largest gap.
+func handleGapHistogramQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	// Handle query parameters.
+	var binCount int64
+	var aggregateBy string
+	var err error
+	for key, val := range reqOpts {
+		switch key {
+		case binCountKey:
+			binCount, err = util.ExpectIntegerValue(val)
+		case aggregateByKey:
+			aggregateBy, err = util.ExpectStringValue(val)
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if binCount < 1 {
+		return fmt.Errorf("gap histogram bin count must be >0")
+	}
+	if binCount > maxBinCount {
+		binCount = maxBinCount
+	}
+	var groupOf func(entry *logtrace.Entry) string
+	filters := []logtrace.Filter{qf.filters(timeFilters, sourceFileFilter)}
+	switch aggregateBy {
+	case "":
+		groupOf = func(entry *logtrace.Entry) string {
+			return allEntriesSeriesID
+		}
+	case sourceFileKey:
+		groupOf = func(entry *logtrace.Entry) string {
+			return entry.SourceLocation.SourceFile.Identifier()
+		}
+	case processKey:
+		groupOf = processID
+	default:
+		field, fieldOf, ok := derivedFieldOf(aggregateBy)
+		if !ok {
+			return fmt.Errorf("unsupported aggregation type '%s'", aggregateBy)
+		}
+		groupOf = fieldOf
+		filters = append(filters, logtrace.WithFields(field))
+	}
+	// Gather the gaps between consecutive entries in each group.
+	lastTimeByGroup := map[string]time.Time{}
+	gapsByGroup := map[string][]time.Duration{}
+	var maxGap time.Duration
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
+		group := groupOf(entry)
+		if lastTime, ok := lastTimeByGroup[group]; ok {
+			gap := entry.Time.Sub(lastTime)
+			gapsByGroup[group] = append(gapsByGroup[group], gap)
+			if gap > maxGap {
+				maxGap = gap
+			}
+		}
+		lastTimeByGroup[group] = entry.Time
+		return nil
+	}, filters...); err != nil {
+		return err
+	}
+	// Bin the gaps.  Bins are at least a nanosecond wide, even if all gaps are
+	// zero.
+	binWidth := (maxGap + time.Duration(binCount) - 1) / time.Duration(binCount)
+	if binWidth == 0 {
+		binWidth = 1
+	}
+	// Sort series output for test stability
+	groups := make([]string, 0, len(gapsByGroup))
+	for group := range gapsByGroup {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	countsByGroup := make(map[string][]float64, len(groups))
+	seriesColorSpaces := make([]util.PropertyUpdate, len(groups))
+	var yAxisMax float64
+	for idx, group := range groups {
+		seriesColorSpaces[idx] = idToColorSpace(group).Define()
+		counts := make([]float64, binCount)
+		for _, gap := range gapsByGroup[group] {
+			bin := int64(gap / binWidth)
+			if bin >= binCount {
+				bin = binCount - 1
+			}
+			counts[bin]++
+			if counts[bin] > yAxisMax {
+				yAxisMax = counts[bin]
+			}
+		}
+		countsByGroup[group] = counts
+	}
+	// Emit the series data.
+	chart := xychart.New(series,
+		continuousaxis.NewDurationAxis(
+			category.New("x_axis", "Gap", "Time between consecutive log messages"),
+			0, maxGap),
+		continuousaxis.NewDoubleAxis(
+			category.New("y_axis", "Gaps", "Number of gaps between consecutive log messages"),
+			0, yAxisMax), seriesColorSpaces...).With(
+		xAxisRenderSettings.Apply(),
+		yAxisRenderSettings.Apply(),
+	)
+	for _, group := range groups {
+		histogram := chart.AddSeries(
+			category.New(group, group, group),
+			idToColorSpace(group).PrimaryColor(1.0),
+		)
+		binLow := time.Duration(0)
+		for _, count := range countsByGroup[group] {
+			histogram.WithPoint(binLow, count)
+			binLow += binWidth
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datasource
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	logpatterns "github.com/google/traceviz/logviz/analysis/log_patterns"
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	// The default number of bins in each template's trend.
+	defaultTrendBinCount = 20
+	// The default number of example messages reported for each template.
+	defaultMaxExamples = 3
+	// The maximum number of bins in each template's trend.  Larger requested
+	// bin counts are reduced to this, bounding the memory a single request,
+	// over many templates, may demand.
+	maxTrendBinCount = 1000
+)
+
+var (
+	templateCol        = table.Column(category.New(templateKey, "Template", "A log message template; <*> stands in for varying tokens"))
+	templateEntriesCol = table.Column(category.New(entriesKey, "Entries", "The number of filtered-in log entries matching this template"))
+)
+
+// templateInfo accumulates the filtered-in entries matching a single
+// template.
+type templateInfo struct {
+	template                      *logpatterns.Template
+	trend                         []int64
+	examples                      []string
+	firstTimestamp, lastTimestamp time.Time
+}
+
+// handlePatternsQuery clusters the first lines of filtered-in entries' messages
+// into templates, and emits a table with one row per template, in decreasing
+// order of entry count.  Each row carries, as properties, the template's trend
+// -- its entry counts in bin_count (at most maxTrendBinCount) equal time bins
+// spanning the filtered range, suitable for a sparkline -- up to max_examples example messages, and the
+// timestamps of its first and last entries.
+func handlePatternsQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	// Handle query parameters.
+	binCount := int64(defaultTrendBinCount)
+	maxExamples := int64(defaultMaxExamples)
+	var err error
+	for key, val := range reqOpts {
+		switch key {
+		case binCountKey:
+			binCount, err = util.ExpectIntegerValue(val)
+		case maxExamplesKey:
+			maxExamples, err = util.ExpectIntegerValue(val)
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if binCount <= 1 {
+		return fmt.Errorf("patterns bin count must be >1")
+	}
+	if binCount > maxTrendBinCount {
+		binCount = maxTrendBinCount
+	}
+	if maxExamples < 0 {
+		return fmt.Errorf("patterns option '%s' must not be negative", maxExamplesKey)
+	}
+	// As in timeseries, the last bin only holds entries at the end of the
+	// filtered range.
+	binWidth := qf.duration() / time.Duration(binCount-1)
+	if binWidth == 0 {
+		binWidth = 1
+	}
+	clusterer := logpatterns.New(logpatterns.Options{})
+	infosByID := map[int]*templateInfo{}
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
+		var firstLine string
+		if len(entry.Message) > 0 {
+			firstLine = entry.Message[0]
+		}
+		template := clusterer.Add(firstLine)
+		ti, ok := infosByID[template.ID]
+		if !ok {
+			ti = &templateInfo{
+				template:       template,
+				trend:          make([]int64, binCount),
+				firstTimestamp: entry.Time,
+			}
+			infosByID[template.ID] = ti
+		}
+		ti.trend[binIndex(entry.Time, qf.startTimestamp, binWidth, binCount)]++
+		if int64(len(ti.examples)) < maxExamples {
+			ti.examples = append(ti.examples, firstLine)
+		}
+		ti.lastTimestamp = entry.Time
+		return nil
+	}, qf.filters(timeFilters, sourceFileFilter)); err != nil {
+		return err
+	}
+	infos := make([]*templateInfo, 0, len(infosByID))
+	for _, ti := range infosByID {
+		infos = append(infos, ti)
+	}
+	sort.Slice(infos, func(a, b int) bool {
+		if infos[a].template.Count != infos[b].template.Count {
+			return infos[a].template.Count > infos[b].template.Count
+		}
+		return infos[a].template.ID < infos[b].template.ID
+	})
+	t := table.New(series, renderSettings, templateCol, templateEntriesCol)
+	for _, ti := range infos {
+		template := ti.template.String()
+		t.Row(
+			table.Cell(templateCol, util.String(template)),
+			table.Cell(templateEntriesCol, util.Integer(int64(ti.template.Count))),
+		).With(
+			util.StringProperty(templateKey, template),
+			util.IntegersProperty(trendKey, ti.trend...),
+			util.StringsProperty(examplesKey, ti.examples...),
+			util.TimestampProperty(firstTimestampKey, ti.firstTimestamp),
+			util.TimestampProperty(lastTimestampKey, ti.lastTimestamp),
+		)
+	}
+	return nil
+}
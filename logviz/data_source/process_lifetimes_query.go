@@ -0,0 +1,87 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datasource
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	processlifetimes "github.com/google/traceviz/logviz/analysis/process_lifetimes"
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// handleProcessLifetimesQuery emits a trace with one category per process,
+// holding a span for each of that process's inferred lifetimes.  A lifetime
+// runs from a process's first entry to its last, unless the 'start_regex' or
+// 'stop_regex' options are provided, in which case messages matching them
+// explicitly begin and end lifetimes.
+func handleProcessLifetimesQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	// Handle query parameters.
+	var opts processlifetimes.Options
+	for key, val := range reqOpts {
+		pattern, err := util.ExpectStringValue(val)
+		if err != nil {
+			return err
+		}
+		var re *regexp.Regexp
+		if pattern != "" {
+			if re, err = regexp.Compile(pattern); err != nil {
+				return err
+			}
+		}
+		switch key {
+		case startRegexKey:
+			opts.StartPattern = re
+		case stopRegexKey:
+			opts.StopPattern = re
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+	}
+	tracker := processlifetimes.New(opts)
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
+		tracker.Add(processID(entry), entry.Time, strings.Join(entry.Message, "\n"))
+		return nil
+	}, qf.filters(timeFilters, sourceFileFilter)); err != nil {
+		return err
+	}
+	t := trace.New[time.Time](
+		series,
+		continuousaxis.NewTimestampAxis(
+			category.New("x_axis", "Time", "Time from start of log"),
+			qf.startTimestamp, qf.endTimestamp),
+		traceRenderSettings).With(
+		xAxisRenderSettings.Apply(),
+	)
+	for _, id := range tracker.ProcessIDs() {
+		processCat := t.Category(
+			category.New(id, id, fmt.Sprintf("Lifetimes of process %s", id)),
+			util.StringProperty(processKey, id),
+		)
+		for _, lifetime := range tracker.Lifetimes(id) {
+			processCat.Span(lifetime.Start, lifetime.End,
+				util.IntegerProperty(entriesKey, int64(lifetime.Entries)),
+				util.If(lifetime.ExplicitStart, util.IntegerProperty(explicitStartKey, 1)),
+				util.If(lifetime.ExplicitStop, util.IntegerProperty(explicitStopKey, 1)),
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datasource
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// By default, the maximum gap between consecutive entries in a single burst is
+// the filtered time range divided by defaultGapThresholdDivisor.
+const defaultGapThresholdDivisor = 100
+
+// processID returns the identifier of the process that logged the provided
+// Entry.  If the Entry has no known Process, its Log stands in for it.
+func processID(entry *logtrace.Entry) string {
+	if entry.Process != nil {
+		return entry.Process.Identifier()
+	}
+	return entry.Log.Identifier()
+}
+
+// bursts splits the provided time-ordered entries into maximal runs in which
+// no two consecutive entries are more than gapThreshold apart.
+func bursts(entries []*logtrace.Entry, gapThreshold time.Duration) [][]*logtrace.Entry {
+	var ret [][]*logtrace.Entry
+	start := 0
+	for idx := 1; idx <= len(entries); idx++ {
+		if idx == len(entries) || entries[idx].Time.Sub(entries[idx-1].Time) > gapThreshold {
+			ret = append(ret, entries[start:idx])
+			start = idx
+		}
+	}
+	return ret
+}
+
+// processActivity gathers the entries logged by a single process.
+type processActivity struct {
+	id                    string
+	entries               []*logtrace.Entry
+	entriesBySourceFileID map[string][]*logtrace.Entry
+}
+
+func handleProcessTraceQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	// Handle query parameters.
+	gapThreshold := qf.duration() / defaultGapThresholdDivisor
+	var err error
+	for key, val := range reqOpts {
+		switch key {
+		case gapThresholdKey:
+			gapThreshold, err = util.ExpectDurationValue(val)
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if gapThreshold < 0 {
+		return fmt.Errorf("process trace option '%s' must not be negative", gapThresholdKey)
+	}
+	activityByProcessID := map[string]*processActivity{}
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
+		id := processID(entry)
+		pa, ok := activityByProcessID[id]
+		if !ok {
+			pa = &processActivity{
+				id:                    id,
+				entriesBySourceFileID: map[string][]*logtrace.Entry{},
+			}
+			activityByProcessID[id] = pa
+		}
+		pa.entries = append(pa.entries, entry)
+		sfID := entry.SourceLocation.SourceFile.Identifier()
+		pa.entriesBySourceFileID[sfID] = append(pa.entriesBySourceFileID[sfID], entry)
+		return nil
+	}, qf.filters(timeFilters, sourceFileFilter)); err != nil {
+		return err
+	}
+	activities := make([]*processActivity, 0, len(activityByProcessID))
+	for _, pa := range activityByProcessID {
+		activities = append(activities, pa)
+	}
+	sort.Slice(activities, func(a, b int) bool {
+		return activities[a].id < activities[b].id
+	})
+	t := trace.New[time.Time](
+		series,
+		continuousaxis.NewTimestampAxis(
+			category.New("x_axis", "Time", "Time from start of log"),
+			qf.startTimestamp, qf.endTimestamp),
+		traceRenderSettings).With(
+		xAxisRenderSettings.Apply(),
+		colorSpacesByLevelWeight[0].Define(),
+		colorSpacesByLevelWeight[1].Define(),
+		colorSpacesByLevelWeight[2].Define(),
+		colorSpacesByLevelWeight[3].Define(),
+	)
+	for _, pa := range activities {
+		processCat := t.Category(
+			category.New(pa.id, pa.id, fmt.Sprintf("Log activity in process %s", pa.id)),
+			util.StringProperty(processKey, pa.id),
+		)
+		// The process category shows only bursts of activity; individual entries
+		// are shown in its per-source-file subcategories.
+		for _, burst := range bursts(pa.entries, gapThreshold) {
+			processCat.Span(burst[0].Time, burst[len(burst)-1].Time,
+				util.IntegerProperty(entriesKey, int64(len(burst))),
+			)
+		}
+		sfIDs := make([]string, 0, len(pa.entriesBySourceFileID))
+		for sfID := range pa.entriesBySourceFileID {
+			sfIDs = append(sfIDs, sfID)
+		}
+		sort.Strings(sfIDs)
+		for _, sfID := range sfIDs {
+			sfCat := processCat.Category(
+				category.New(pa.id+"/"+sfID, sfID, fmt.Sprintf("Log activity from %s in process %s", sfID, pa.id)),
+				util.StringProperty(sourceFileKey, sfID),
+			)
+			for _, burst := range bursts(pa.entriesBySourceFileID[sfID], gapThreshold) {
+				span := sfCat.Span(burst[0].Time, burst[len(burst)-1].Time,
+					util.IntegerProperty(entriesKey, int64(len(burst))),
+				)
+				for _, entry := range burst {
+					var primaryColor util.PropertyUpdate
+					if coloring := colorSpacesByLevelWeight[entry.Level.Weight]; coloring != nil {
+						primaryColor = coloring.PrimaryColor(1)
+					}
+					span.Subspan(entry.Time, entry.Time,
+						util.StringProperty(levelNameKey, entry.Level.DisplayName()),
+						util.StringProperty(sourceLocNameKey, entry.SourceLocation.DisplayName()),
+						primaryColor,
+					)
+				}
+			}
+		}
+	}
+	return nil
+}
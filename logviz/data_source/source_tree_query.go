@@ -0,0 +1,213 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datasource
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+)
+
+// sourceTreeNode is a weightedtree.TreeNode aggregating log entry counts by
+// source path component.  The path components of a source location are the
+// directories and filename of its source file, followed by its line.
+type sourceTreeNode struct {
+	path []weightedtree.ScopeID
+	// The path component this node represents.  Empty for the root.
+	name string
+	// The source file this node represents, if it is a file node.
+	sourceFile *logtrace.SourceFile
+	// The number of entries logged at this node and all its descendants.
+	totalEntries int
+	children     map[weightedtree.ScopeID]*sourceTreeNode
+}
+
+func newSourceTreeNode(name string, path ...weightedtree.ScopeID) *sourceTreeNode {
+	return &sourceTreeNode{
+		path:     path,
+		name:     name,
+		children: map[weightedtree.ScopeID]*sourceTreeNode{},
+	}
+}
+
+func (stn *sourceTreeNode) Path() []weightedtree.ScopeID {
+	return stn.path
+}
+
+func (stn *sourceTreeNode) Children(scopeIDs ...weightedtree.ScopeID) ([]weightedtree.TreeNode, error) {
+	var ret []weightedtree.TreeNode
+	if len(scopeIDs) == 0 {
+		ret = make([]weightedtree.TreeNode, 0, len(stn.children))
+		for _, child := range stn.children {
+			ret = append(ret, child)
+		}
+		return ret, nil
+	}
+	ret = make([]weightedtree.TreeNode, 0, len(scopeIDs))
+	for _, scopeID := range scopeIDs {
+		if child, ok := stn.children[scopeID]; ok {
+			ret = append(ret, child)
+		}
+	}
+	return ret, nil
+}
+
+// sourceTree assembles a tree of sourceTreeNodes, assigning a unique ScopeID
+// to each distinct path component.
+type sourceTree struct {
+	root           *sourceTreeNode
+	scopeIDsByName map[string]weightedtree.ScopeID
+}
+
+func newSourceTree() *sourceTree {
+	return &sourceTree{
+		root:           newSourceTreeNode(""),
+		scopeIDsByName: map[string]weightedtree.ScopeID{},
+	}
+}
+
+func (st *sourceTree) scopeID(name string) weightedtree.ScopeID {
+	scopeID, ok := st.scopeIDsByName[name]
+	if !ok {
+		scopeID = weightedtree.ScopeID(len(st.scopeIDsByName))
+		st.scopeIDsByName[name] = scopeID
+	}
+	return scopeID
+}
+
+// add adds the provided Entry to the receiver.
+func (st *sourceTree) add(entry *logtrace.Entry) {
+	sourceFile := entry.SourceLocation.SourceFile
+	var components []string
+	for _, component := range strings.Split(sourceFile.Filename, "/") {
+		if component != "" {
+			components = append(components, component)
+		}
+	}
+	fileDepth := len(components)
+	components = append(components, ":"+strconv.Itoa(entry.SourceLocation.Line))
+	node := st.root
+	node.totalEntries++
+	for idx, component := range components {
+		scopeID := st.scopeID(component)
+		child, ok := node.children[scopeID]
+		if !ok {
+			child = newSourceTreeNode(component, append(append([]weightedtree.ScopeID{}, node.path...), scopeID)...)
+			if idx == fileDepth-1 {
+				child.sourceFile = sourceFile
+			}
+			node.children[scopeID] = child
+		}
+		child.totalEntries++
+		node = child
+	}
+}
+
+// totalEntries returns the total entries of all the provided TreeNodes, which
+// must be *sourceTreeNodes.
+func totalEntries(tns []weightedtree.TreeNode) int {
+	var ret int
+	for _, tn := range tns {
+		ret += tn.(*sourceTreeNode).totalEntries
+	}
+	return ret
+}
+
+// compareSourceTreeNodes orders by total entries, breaking ties by path
+// component name so that responses are deterministic.
+func compareSourceTreeNodes(a, b weightedtree.Comparable) (int, error) {
+	if diff := totalEntries(a.TreeNodes) - totalEntries(b.TreeNodes); diff != 0 {
+		return diff, nil
+	}
+	if len(a.TreeNodes) == 0 || len(b.TreeNodes) == 0 {
+		return len(a.TreeNodes) - len(b.TreeNodes), nil
+	}
+	// Lower names are 'heavier', so are visited first.
+	return strings.Compare(b.TreeNodes[0].(*sourceTreeNode).name, a.TreeNodes[0].(*sourceTreeNode).name), nil
+}
+
+var sourceTreeRenderSettings = &weightedtree.RenderSettings{
+	FrameHeightPx: 20,
+}
+
+func handleSourceTreeQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	// Handle query parameters.
+	var viewportWidthPx, maxNodes int64
+	var err error
+	for key, val := range reqOpts {
+		switch key {
+		case viewportWidthPxKey:
+			viewportWidthPx, err = util.ExpectIntegerValue(val)
+		case maxNodesKey:
+			maxNodes, err = util.ExpectIntegerValue(val)
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if viewportWidthPx <= 0 {
+		return fmt.Errorf("source tree option '%s' must be positive", viewportWidthPxKey)
+	}
+	// Absent an explicit node limit, show no more nodes than could fit across
+	// the viewport.
+	if maxNodes <= 0 {
+		maxNodes = viewportWidthPx
+	}
+	st := newSourceTree()
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
+		st.add(entry)
+		return nil
+	}, qf.filters(timeFilters, sourceFileFilter)); err != nil {
+		return err
+	}
+	tree := weightedtree.New(series, sourceTreeRenderSettings).TopDown()
+	if st.root.totalEntries == 0 {
+		return nil
+	}
+	// The root isn't emitted, so doesn't count against maxNodes.  Don't visit
+	// nodes too narrow to be rendered at the viewport's width.
+	rootEntries := float64(st.root.totalEntries)
+	subtree, err := weightedtree.Walk(st.root, compareSourceTreeNodes,
+		weightedtree.MaxNodes(uint(maxNodes+1)),
+		weightedtree.DeterministicOrder(),
+		weightedtree.WithContext(qf.ctx),
+		weightedtree.WithWeight(func(c weightedtree.Comparable) (float64, error) {
+			return float64(totalEntries(c.TreeNodes)), nil
+		}),
+		weightedtree.FilterTreeNodes(func(tn weightedtree.TreeNode) bool {
+			return float64(tn.(*sourceTreeNode).totalEntries)*float64(viewportWidthPx)/rootEntries >= 1
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	return subtree.BuildResponse(tree, func(stn *weightedtree.SubtreeNode) ([]util.PropertyUpdate, error) {
+		node := stn.TreeNodes[0].(*sourceTreeNode)
+		props := []util.PropertyUpdate{
+			util.StringProperty(sourcePathKey, node.name),
+			util.IntegerProperty(entriesKey, int64(node.totalEntries)),
+			weightedtree.SampleCount(int64(node.totalEntries)),
+		}
+		if node.sourceFile != nil {
+			props = append(props, util.StringProperty(sourceFileKey, node.sourceFile.Identifier()))
+		}
+		return props, nil
+	})
+}
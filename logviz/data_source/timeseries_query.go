@@ -15,7 +15,9 @@ package datasource
 
 import (
 	"fmt"
+	"math"
 	"sort"
+	"strconv"
 	"time"
 
 	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
@@ -26,10 +28,238 @@ import (
 	xychart "github.com/google/traceviz/server/go/xy_chart"
 )
 
+const (
+	// The default maximum number of entries a timeseries query will visit
+	// individually before downsampling.
+	defaultMaxTimeseriesEntries = 1 << 20
+	// The default number of entries in range above which timeseries queries
+	// use time-bucketed aggregates, where possible, rather than visiting each
+	// entry.
+	defaultMinAggregatedEntries = 1 << 14
+	// When a timeseries bin count isn't explicitly requested, bins are chosen
+	// to be this many pixels wide across the viewport.
+	timeseriesBinWidthPx = 5
+	// Time-bucketed aggregates are only used when bins are at
+	// least this many times wider than the buckets, so that misattributing
+	// entries to the bin holding the start of their bucket introduces little
+	// error.
+	minAggregatedBinBuckets = 10
+	// The default number of preceding bins forming the rolling baseline
+	// against which anomalies are detected.
+	defaultAnomalyWindow = 10
+	// The maximum number of bins in timeseries, and in other binned queries,
+	// bounding the memory a single request may demand.  Larger requested bin
+	// counts are reduced to this.
+	maxBinCount = 10000
+)
+
+// binIndex returns the index of the bin, among binCount bins of the specified
+// width beginning at start, holding the specified time.  Bin widths are
+// rounded down, so times at the very end of a range may lie past the last
+// bin; these are placed in the last bin.
+func binIndex(t, start time.Time, binWidth time.Duration, binCount int64) int {
+	bin := int64(t.Sub(start) / binWidth)
+	if bin >= binCount {
+		bin = binCount - 1
+	}
+	return int(bin)
+}
+
+// valueAggregates maps the supported values of the 'value_aggregate' option to
+// functions reducing a nonempty set of numeric field values to a single point.
+var valueAggregates = map[string]func(values []float64) float64{
+	"sum": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"avg": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"max": func(values []float64) float64 {
+		ret := values[0]
+		for _, v := range values[1:] {
+			if v > ret {
+				ret = v
+			}
+		}
+		return ret
+	},
+	"p95": func(values []float64) float64 {
+		return percentile(values, 95)
+	},
+}
+
+// percentile returns the nearest-rank pth percentile of the provided nonempty
+// values, which are sorted in place.
+func percentile(values []float64, p float64) float64 {
+	sort.Float64s(values)
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+	return values[rank-1]
+}
+
+// anomaly describes a timeseries point deviating from its rolling baseline.
+type anomaly struct {
+	// The mean of the preceding window of points.
+	baseline float64
+	// The point's deviation from baseline, in standard deviations of the
+	// preceding window.  Infinite if the window has no variance, in which case
+	// any deviation is anomalous.
+	sigmas float64
+}
+
+// findAnomalies returns, for each of the provided points, an anomaly if that
+// point deviates from the mean of the up to window points preceding it by
+// more than sigma standard deviations, and otherwise nil.  Points with fewer
+// than two preceding points are never anomalous.
+func findAnomalies(points []float64, window int, sigma float64) []*anomaly {
+	ret := make([]*anomaly, len(points))
+	for idx, point := range points {
+		start := idx - window
+		if start < 0 {
+			start = 0
+		}
+		preceding := points[start:idx]
+		if len(preceding) < 2 {
+			continue
+		}
+		var sum float64
+		for _, p := range preceding {
+			sum += p
+		}
+		mean := sum / float64(len(preceding))
+		var sumSq float64
+		for _, p := range preceding {
+			sumSq += (p - mean) * (p - mean)
+		}
+		stddev := math.Sqrt(sumSq / float64(len(preceding)))
+		deviation := point - mean
+		if deviation == 0 {
+			continue
+		}
+		sigmas := math.Inf(1)
+		if stddev > 0 {
+			sigmas = deviation / stddev
+		} else if deviation < 0 {
+			sigmas = math.Inf(-1)
+		}
+		if math.Abs(sigmas) > sigma {
+			ret[idx] = &anomaly{
+				baseline: mean,
+				sigmas:   sigmas,
+			}
+		}
+	}
+	return ret
+}
+
+// timeseriesBinCount returns the number of timeseries bins to use given the
+// requested bin count and viewport width, either of which may be zero if not
+// requested.  If a viewport width is provided, a bin count is chosen to suit
+// it if none was requested, and no more bins than there are pixels are used.
+// No more than maxBinCount bins are used.
+func timeseriesBinCount(binCount, viewportWidthPx int64) (int64, error) {
+	if viewportWidthPx < 0 {
+		return 0, fmt.Errorf("timeseries option '%s' must not be negative", viewportWidthPxKey)
+	}
+	if viewportWidthPx > 0 {
+		if binCount == 0 {
+			binCount = viewportWidthPx / timeseriesBinWidthPx
+			if binCount < 2 {
+				binCount = 2
+			}
+		} else if binCount > viewportWidthPx {
+			binCount = viewportWidthPx
+		}
+	}
+	if binCount <= 1 {
+		return 0, fmt.Errorf("timeseries bin count must be >1")
+	}
+	if binCount > maxBinCount {
+		binCount = maxBinCount
+	}
+	return binCount, nil
+}
+
+// timeseriesBinNormalization returns the factor by which the entry count in a
+// bin of the provided width is divided to yield a rate per the nearest larger
+// time unit, and that unit's name.
+func timeseriesBinNormalization(binWidth time.Duration) (float64, string) {
+	switch {
+	case binWidth >= time.Hour:
+		return float64(binWidth) / float64(time.Hour), "hour"
+	case binWidth >= time.Minute:
+		return float64(binWidth) / float64(time.Minute), "minute"
+	case binWidth >= time.Second:
+		return float64(binWidth) / float64(time.Second), "second"
+	case binWidth >= time.Millisecond:
+		return float64(binWidth) / float64(time.Millisecond), "millisecond"
+	case binWidth >= time.Microsecond:
+		return float64(binWidth) / float64(time.Microsecond), "microsecond"
+	default:
+		return float64(binWidth) / float64(time.Nanosecond), "nanosecond"
+	}
+}
+
 func handleTimeseriesQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	return emitTimeseries(coll, qf, series, reqOpts, nil)
+}
+
+// handleSourceLocTimeseriesQuery handles a timeseries query restricted to the
+// single source location identified by the 'source_loc_name' option.  It
+// accepts the options of the timeseries query, but aggregates by level by
+// default.
+func handleSourceLocTimeseriesQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	sourceLocVal, ok := reqOpts[sourceLocNameKey]
+	if !ok {
+		return fmt.Errorf("source location timeseries requires option '%s'", sourceLocNameKey)
+	}
+	sourceLocName, err := util.ExpectStringValue(sourceLocVal)
+	if err != nil {
+		return err
+	}
+	sourceLoc, ok := coll.lt.SourceLocsByID[sourceLocName]
+	if !ok {
+		return fmt.Errorf("unknown source location '%s'", sourceLocName)
+	}
+	opts := map[string]*util.V{
+		aggregateByKey: util.StringValue(levelNameKey),
+	}
+	for key, val := range reqOpts {
+		if key != sourceLocNameKey {
+			opts[key] = val
+		}
+	}
+	return emitTimeseries(coll, qf, series, opts, sourceLoc)
+}
+
+// emitTimeseries emits a timeseries of the filtered-in entries, as configured
+// by the provided options, into the provided DataBuilder.  If sourceLoc is
+// non-nil, only entries at that source location are counted.
+//
+// By default, each point is the rate of entries in its bin.  If the
+// 'value_field' option names a derived field, each point is instead the
+// 'value_aggregate' (one of 'sum', the default, 'avg', 'max', or 'p95') of
+// that field's numeric values in its bin; entries lacking the field, or whose
+// value isn't numeric, are ignored.
+func emitTimeseries(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V, sourceLoc *logtrace.SourceLocation) error {
 	// Handle query parameters.
-	var binCount int64
+	var binCount, viewportWidthPx int64
 	var aggregateBy string
+	var anomalySigma float64
+	anomalyWindow := int64(defaultAnomalyWindow)
+	var skipEmptyBins int64
+	var valueField string
+	valueAggregate := "sum"
 	var err error
 	for key, val := range reqOpts {
 		switch key {
@@ -37,6 +267,18 @@ func handleTimeseriesQuery(coll *Collection, qf *queryFilters, series util.DataB
 			binCount, err = util.ExpectIntegerValue(val)
 		case aggregateByKey:
 			aggregateBy, err = util.ExpectStringValue(val)
+		case viewportWidthPxKey:
+			viewportWidthPx, err = util.ExpectIntegerValue(val)
+		case anomalySigmaKey:
+			anomalySigma, err = util.ExpectDoubleValue(val)
+		case anomalyWindowKey:
+			anomalyWindow, err = util.ExpectIntegerValue(val)
+		case skipEmptyBinsKey:
+			skipEmptyBins, err = util.ExpectIntegerValue(val)
+		case valueFieldKey:
+			valueField, err = util.ExpectStringValue(val)
+		case valueAggregateKey:
+			valueAggregate, err = util.ExpectStringValue(val)
 		default:
 			return fmt.Errorf("unsupported option '%s'", key)
 		}
@@ -44,8 +286,19 @@ func handleTimeseriesQuery(coll *Collection, qf *queryFilters, series util.DataB
 			return err
 		}
 	}
-	if binCount <= 1 {
-		return fmt.Errorf("timeseries bin count must be >1")
+	aggregateValues, ok := valueAggregates[valueAggregate]
+	if !ok {
+		return fmt.Errorf("unsupported value aggregate '%s'", valueAggregate)
+	}
+	binCount, err = timeseriesBinCount(binCount, viewportWidthPx)
+	if err != nil {
+		return err
+	}
+	if anomalySigma < 0 {
+		return fmt.Errorf("timeseries option '%s' must not be negative", anomalySigmaKey)
+	}
+	if anomalyWindow < 2 {
+		return fmt.Errorf("timeseries option '%s' must be at least 2", anomalyWindowKey)
 	}
 	// Information about a single series.
 	type seriesInfo struct {
@@ -54,29 +307,64 @@ func handleTimeseriesQuery(coll *Collection, qf *queryFilters, series util.DataB
 		// if nil, will be generated by hashing the name.
 		colorSpace *color.Space
 		points     []float64
+		// If a value field is specified, the values of that field in each bin.
+		values [][]float64
 	}
 	// Based on aggregateBy, set up a helper, getSeriesInfo, to fetch the right
 	// seriesInfo for a given log Entry.
 	seriesInfoByName := map[string]*seriesInfo{}
-	// getSeriesInfo must be defined by each supported aggregation type.
+	// If aggregating by a derived field, its name.
+	var derivedField string
+	// getSeriesInfo and seriesKey, which returns the key in seriesInfoByName
+	// of a given log Entry's series, must be defined by each supported
+	// aggregation type.
 	var getSeriesInfo func(entry *logtrace.Entry) *seriesInfo
+	var seriesKey func(entry *logtrace.Entry) string
+	// getLevelSeriesInfo is defined only for aggregation types able to use
+	// the Collection's time-bucketed aggregates.
+	var getLevelSeriesInfo func(level *logtrace.Level) *seriesInfo
 	switch aggregateBy {
 	case levelNameKey:
-		getSeriesInfo = func(entry *logtrace.Entry) *seriesInfo {
-			if si, ok := seriesInfoByName[entry.Level.Identifier()]; ok {
+		getLevelSeriesInfo = func(level *logtrace.Level) *seriesInfo {
+			if si, ok := seriesInfoByName[level.Identifier()]; ok {
 				return si
 			}
 			si := &seriesInfo{
-				id:         entry.Level.Identifier(),
-				name:       entry.Level.String(),
-				colorSpace: colorSpacesByLevelWeight[entry.Level.Weight],
+				id:         level.Identifier(),
+				name:       level.String(),
+				colorSpace: colorSpacesByLevelWeight[level.Weight],
 				points:     make([]float64, binCount),
 			}
-			seriesInfoByName[entry.Level.Identifier()] = si
+			seriesInfoByName[level.Identifier()] = si
 			return si
 		}
+		getSeriesInfo = func(entry *logtrace.Entry) *seriesInfo {
+			return getLevelSeriesInfo(entry.Level)
+		}
+		seriesKey = func(entry *logtrace.Entry) string {
+			return entry.Level.Identifier()
+		}
 	default:
-		return fmt.Errorf("unsupported aggregation type '%s'", aggregateBy)
+		field, fieldOf, ok := derivedFieldOf(aggregateBy)
+		if !ok {
+			return fmt.Errorf("unsupported aggregation type '%s'", aggregateBy)
+		}
+		getSeriesInfo = func(entry *logtrace.Entry) *seriesInfo {
+			value := fieldOf(entry)
+			if si, ok := seriesInfoByName[value]; ok {
+				return si
+			}
+			si := &seriesInfo{
+				id:         value,
+				name:       field + "=" + value,
+				colorSpace: idToColorSpace(value),
+				points:     make([]float64, binCount),
+			}
+			seriesInfoByName[value] = si
+			return si
+		}
+		seriesKey = fieldOf
+		derivedField = field
 	}
 	// Figure out how wide each bin should be given the requested bin count.
 	totalWidth := qf.duration()
@@ -84,51 +372,130 @@ func handleTimeseriesQuery(coll *Collection, qf *queryFilters, series util.DataB
 	// so we allocate the rest of the total width over (binCount-1) bins.
 	// Each bin includes its lower bound and does not include its upper bound.
 	binWidth := totalWidth / time.Duration(binCount-1)
-	// Set the bin normalization factor, and the y-axis label, to the nearest
-	// larger time unit.
-	var binNormalization float64
-	var binNormalizationLabel string
-	switch {
-	case binWidth >= time.Hour:
-		binNormalization = float64(binWidth) / float64(time.Hour)
-		binNormalizationLabel = "hour"
-	case binWidth >= time.Minute:
-		binNormalization = float64(binWidth) / float64(time.Minute)
-		binNormalizationLabel = "minute"
-	case binWidth >= time.Second:
-		binNormalization = float64(binWidth) / float64(time.Second)
-		binNormalizationLabel = "second"
-	case binWidth >= time.Millisecond:
-		binNormalization = float64(binWidth) / float64(time.Millisecond)
-		binNormalizationLabel = "millisecond"
-	case binWidth >= time.Microsecond:
-		binNormalization = float64(binWidth) / float64(time.Microsecond)
-		binNormalizationLabel = "microsecond"
-	case binWidth >= time.Nanosecond:
-		binNormalization = float64(binWidth) / float64(time.Nanosecond)
-		binNormalizationLabel = "nanosecond"
+	if binWidth == 0 {
+		binWidth = 1
+	}
+	binNormalization, binNormalizationLabel := timeseriesBinNormalization(binWidth)
+	yAxisCat := category.New("y_axis", "Messages per "+binNormalizationLabel, "Log messages per "+binNormalizationLabel)
+	if valueField != "" {
+		// Aggregated values are not rates, and are not normalized.
+		binNormalization = 1
+		yAxisName := valueAggregate + " of " + valueField
+		yAxisCat = category.New("y_axis", yAxisName, yAxisName)
 	}
 	// whichBin returns the bin index for a given Entry.
 	whichBin := func(entry *logtrace.Entry) (int, error) {
 		if entry.Time.Before(qf.startTimestamp) || entry.Time.After(qf.endTimestamp) {
 			return 0, fmt.Errorf("entry is unexpectedly out of range")
 		}
-		startOffset := entry.Time.Sub(qf.startTimestamp)
-		return int(startOffset / binWidth), nil
+		return binIndex(entry.Time, qf.startTimestamp, binWidth, binCount), nil
 	}
-	// For each filtered-in Entry, add that entry to the proper bin in its proper
-	// seriesInfo, creating that seriesInfo if it doesn't exist.
-	if err := coll.lt.ForEachEntry(func(entry *logtrace.Entry) error {
-		si := getSeriesInfo(entry)
-		bin, err := whichBin(entry)
-		if err != nil {
+	// If there are many entries in range, the Collection's time-bucketed
+	// aggregates are used where possible, at the coarsest resolution fine enough
+	// for the requested bins.  Otherwise, if there are too many entries in range
+	// to visit each individually, only every stride'th entry is considered, as
+	// by logtrace.WithSampleStride, and each visited entry is weighted by the
+	// stride.  Single source locations are never downsampled, since they may
+	// hold few of the entries in range, nor are field values, whose aggregates
+	// can't be recovered from a sample.
+	stride := 1
+	usedAggregates := false
+	filters := []logtrace.Filter{qf.filters(timeFilters, sourceFileFilter)}
+	if derivedField != "" {
+		filters = append(filters, logtrace.WithFields(derivedField))
+	}
+	if valueField != "" {
+		filters = append(filters, logtrace.WithFields(valueField))
+	}
+	if sourceLoc != nil {
+		filters = append(filters, logtrace.WithSourceLocations(sourceLoc))
+	} else if entryCount := coll.lt.EntryCountInRange(qf.startTimestamp, qf.endTimestamp); valueField == "" && (entryCount > coll.minAggregatedEntries || coll.maxTimeseriesEntries > 0 && entryCount > coll.maxTimeseriesEntries) {
+		tb := coll.timeBucketsFor(binWidth)
+		if getLevelSeriesInfo != nil && tb != nil && len(qf.sourceFiles) == 0 {
+			tb.ForEachBucket(qf.startTimestamp, qf.endTimestamp, func(level *logtrace.Level, bucketStart time.Time, count int) {
+				bin := binIndex(bucketStart, qf.startTimestamp, binWidth, binCount)
+				getLevelSeriesInfo(level).points[bin] += float64(count)
+			})
+			usedAggregates = true
+		} else if coll.maxTimeseriesEntries > 0 && entryCount > coll.maxTimeseriesEntries {
+			stride = (entryCount + coll.maxTimeseriesEntries - 1) / coll.maxTimeseriesEntries
+			filters = append(filters, logtrace.WithSampleStride(stride))
+		}
+	}
+	// For each filtered-in Entry, add that entry to the proper bin in its
+	// series' partial within its shard.  Then, merge the partials, in temporal
+	// order, into their proper seriesInfos, creating those seriesInfos if they
+	// don't exist.
+	if !usedAggregates {
+		type partialSeries struct {
+			// An entry in the series, from which its seriesInfo is created.
+			exemplar *logtrace.Entry
+			points   []float64
+			values   [][]float64
+		}
+		var partials []map[string]*partialSeries
+		if err := qf.forEachEntryParallel(coll.lt, func() func(entry *logtrace.Entry) error {
+			partial := map[string]*partialSeries{}
+			partials = append(partials, partial)
+			return func(entry *logtrace.Entry) error {
+				var value float64
+				if valueField != "" {
+					var err error
+					if value, err = strconv.ParseFloat(entry.Fields[valueField], 64); err != nil {
+						return nil
+					}
+				}
+				bin, err := whichBin(entry)
+				if err != nil {
+					return err
+				}
+				key := seriesKey(entry)
+				ps, ok := partial[key]
+				if !ok {
+					ps = &partialSeries{
+						exemplar: entry,
+						points:   make([]float64, binCount),
+					}
+					partial[key] = ps
+				}
+				if valueField != "" {
+					if ps.values == nil {
+						ps.values = make([][]float64, binCount)
+					}
+					ps.values[bin] = append(ps.values[bin], value)
+					return nil
+				}
+				ps.points[bin] += float64(stride)
+				return nil
+			}
+		}, filters...); err != nil {
 			return err
 		}
-		si.points[bin]++
-		return nil
-	}, qf.filters(timeFilters, sourceFileFilter)); err != nil {
-		return err
+		for _, partial := range partials {
+			for _, ps := range partial {
+				si := getSeriesInfo(ps.exemplar)
+				for bin, point := range ps.points {
+					si.points[bin] += point
+				}
+				if ps.values != nil {
+					if si.values == nil {
+						si.values = make([][]float64, binCount)
+					}
+					for bin, values := range ps.values {
+						si.values[bin] = append(si.values[bin], values...)
+					}
+				}
+			}
+		}
+	}
+	for _, si := range seriesInfoByName {
+		for bin, values := range si.values {
+			if len(values) > 0 {
+				si.points[bin] = aggregateValues(values)
+			}
+		}
 	}
+	util.BeginPhase(qf.ctx, "build")
 	// Sort series output for test stability
 	seriesNames := make([]string, 0, len(seriesInfoByName))
 	for seriesName := range seriesInfoByName {
@@ -156,9 +523,7 @@ func handleTimeseriesQuery(coll *Collection, qf *queryFilters, series util.DataB
 		continuousaxis.NewTimestampAxis(
 			category.New("x_axis", "Message timestamp", "Log message timestamp"),
 			qf.startTimestamp, qf.endTimestamp),
-		continuousaxis.NewDoubleAxis(
-			category.New("y_axis", "Messages per "+binNormalizationLabel, "Log messages per "+binNormalizationLabel),
-			0, yAxisMax), seriesColorSpaces...).With(
+		continuousaxis.NewDoubleAxis(yAxisCat, 0, yAxisMax), seriesColorSpaces...).With(
 		xAxisRenderSettings.Apply(),
 		yAxisRenderSettings.Apply(),
 	)
@@ -168,16 +533,50 @@ func handleTimeseriesQuery(coll *Collection, qf *queryFilters, series util.DataB
 			category.New(si.id, si.name, si.name),
 			si.colorSpace.PrimaryColor(1.0),
 		)
-		// For each point in the series, emit that point.
+		// If requested, flag points deviating from their rolling baseline.
+		var anomalies []*anomaly
+		if anomalySigma > 0 {
+			anomalies = findAnomalies(si.points, int(anomalyWindow), anomalySigma)
+		}
+		// For each point in the series, emit that point.  If requested, runs
+		// of several empty bins are instead emitted as a single gap.  Points
+		// and gaps are marked with any annotations overlapping their bins.
+		var emptyRun []time.Time
+		flushEmptyRun := func() {
+			if len(emptyRun) > 1 {
+				last := emptyRun[len(emptyRun)-1]
+				timeseries.WithGap(emptyRun[0], last, qf.annotationMarkers(emptyRun[0], last.Add(binWidth-1)))
+			} else if len(emptyRun) == 1 {
+				timeseries.WithPoint(emptyRun[0], 0, qf.annotationMarkers(emptyRun[0], emptyRun[0].Add(binWidth-1)))
+			}
+			emptyRun = emptyRun[:0]
+		}
 		binLow := qf.startTimestamp
-		for _, dataPoint := range si.points {
+		for idx, dataPoint := range si.points {
 			weight := dataPoint / binNormalization
+			isAnomaly := anomalies != nil && anomalies[idx] != nil
+			if skipEmptyBins != 0 && dataPoint == 0 && !isAnomaly {
+				emptyRun = append(emptyRun, binLow)
+				binLow = binLow.Add(binWidth)
+				continue
+			}
+			flushEmptyRun()
+			var anomalyProperties []util.PropertyUpdate
+			if isAnomaly {
+				anomalyProperties = []util.PropertyUpdate{
+					util.IntegerProperty(anomalyKey, 1),
+					util.DoubleProperty(baselineKey, anomalies[idx].baseline/binNormalization),
+					util.If(!math.IsInf(anomalies[idx].sigmas, 0), util.DoubleProperty(sigmasKey, anomalies[idx].sigmas)),
+				}
+			}
 			timeseries.WithPoint(
 				binLow,
 				weight,
+				append(anomalyProperties, qf.annotationMarkers(binLow, binLow.Add(binWidth-1)))...,
 			)
 			binLow = binLow.Add(binWidth)
 		}
+		flushEmptyRun()
 	}
 	return nil
 }
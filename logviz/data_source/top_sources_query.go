@@ -0,0 +1,162 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package datasource
+
+import (
+	"fmt"
+	"sort"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	// The default number of top sources reported by a top sources query.
+	defaultTopK = 10
+	// The label of the row aggregating all sources outside the top K.
+	otherSourcesLabel = "other"
+)
+
+var (
+	topSourceCol   = table.Column(category.New(labelKey, "Source", "The logging source"))
+	topEntriesCol  = table.Column(category.New(entriesKey, "Entries", "The number of filtered-in log entries from this source"))
+	topFractionCol = table.Column(category.New(fractionKey, "Fraction", "The fraction of filtered-in log entries from this source"))
+)
+
+// sourceCount is the number of entries attributed to a single source.
+type sourceCount struct {
+	label   string
+	entries int64
+}
+
+// handleTopSourcesQuery emits the K sources with the most filtered-in entries
+// as a table, with one row per source in decreasing order of entry count, and
+// a final 'other' row aggregating all remaining sources.  Sources are source
+// locations by default, or source files, processes, levels, or the values of a
+// derived field ('field:<name>'), as specified by the aggregate_by option;
+// entries lacking that derived field are omitted.  So that the response may also drive a pie or bar
+// chart, each row is colored and carries its label and entry count as
+// properties, and the table itself carries the parallel lists of all row
+// labels and entry counts.
+func handleTopSourcesQuery(coll *Collection, qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	// Handle query parameters.
+	k := int64(defaultTopK)
+	aggregateBy := sourceLocNameKey
+	var err error
+	for key, val := range reqOpts {
+		switch key {
+		case topKKey:
+			k, err = util.ExpectIntegerValue(val)
+		case aggregateByKey:
+			aggregateBy, err = util.ExpectStringValue(val)
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if k < 1 {
+		return fmt.Errorf("top sources option '%s' must be positive", topKKey)
+	}
+	var sourceOf func(entry *logtrace.Entry) string
+	filters := []logtrace.Filter{qf.filters(timeFilters, sourceFileFilter)}
+	switch aggregateBy {
+	case sourceLocNameKey:
+		sourceOf = func(entry *logtrace.Entry) string {
+			return entry.SourceLocation.DisplayName()
+		}
+	case sourceFileKey:
+		sourceOf = func(entry *logtrace.Entry) string {
+			return entry.SourceLocation.SourceFile.Identifier()
+		}
+	case processKey:
+		sourceOf = processID
+	case levelNameKey:
+		sourceOf = func(entry *logtrace.Entry) string {
+			return entry.Level.DisplayName()
+		}
+	default:
+		field, fieldOf, ok := derivedFieldOf(aggregateBy)
+		if !ok {
+			return fmt.Errorf("unsupported aggregation type '%s'", aggregateBy)
+		}
+		sourceOf = fieldOf
+		filters = append(filters, logtrace.WithFields(field))
+	}
+	countsBySource := map[string]*sourceCount{}
+	var total int64
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
+		source := sourceOf(entry)
+		sc, ok := countsBySource[source]
+		if !ok {
+			sc = &sourceCount{label: source}
+			countsBySource[source] = sc
+		}
+		sc.entries++
+		total++
+		return nil
+	}, filters...); err != nil {
+		return err
+	}
+	counts := make([]*sourceCount, 0, len(countsBySource))
+	for _, sc := range countsBySource {
+		counts = append(counts, sc)
+	}
+	// Sort by decreasing entry count, breaking ties by label for stability.
+	sort.Slice(counts, func(a, b int) bool {
+		if counts[a].entries != counts[b].entries {
+			return counts[a].entries > counts[b].entries
+		}
+		return counts[a].label < counts[b].label
+	})
+	if int64(len(counts)) > k {
+		other := &sourceCount{label: otherSourcesLabel}
+		for _, sc := range counts[k:] {
+			other.entries += sc.entries
+		}
+		counts = append(counts[:k], other)
+	}
+	labels := make([]string, len(counts))
+	entries := make([]int64, len(counts))
+	tableProperties := make([]util.PropertyUpdate, 0, len(counts)+2)
+	for idx, sc := range counts {
+		labels[idx] = sc.label
+		entries[idx] = sc.entries
+		tableProperties = append(tableProperties, idToColorSpace(sc.label).Define())
+	}
+	tableProperties = append(tableProperties,
+		util.StringsProperty(labelKey, labels...),
+		util.IntegersProperty(entriesKey, entries...),
+	)
+	t := table.New(series, nil, topSourceCol, topEntriesCol, topFractionCol).With(tableProperties...)
+	for _, sc := range counts {
+		var fraction float64
+		if total > 0 {
+			fraction = float64(sc.entries) / float64(total)
+		}
+		t.Row(
+			table.Cell(topSourceCol, util.String(sc.label)),
+			table.Cell(topEntriesCol, util.Integer(sc.entries)),
+			table.Cell(topFractionCol, util.Double(fraction)),
+		).With(
+			util.StringProperty(labelKey, sc.label),
+			util.IntegerProperty(entriesKey, sc.entries),
+			idToColorSpace(sc.label).PrimaryColor(1),
+		)
+	}
+	return nil
+}
@@ -87,7 +87,7 @@ func handleTraceQuery(coll *Collection, qf *queryFilters, series util.DataBuilde
 	root := newTimeSeriesTreeNode("")
 	// For each filtered-in Entry, add that entry to the proper bin in its proper
 	// seriesInfo, creating that seriesInfo if it doesn't exist.
-	if err := coll.lt.ForEachEntry(func(entry *logtrace.Entry) error {
+	if err := qf.forEachEntry(coll.lt, func(entry *logtrace.Entry) error {
 		path := strings.Split(entry.SourceLocation.SourceFile.Filename, "/")
 		root.add(entry, path...)
 		return nil
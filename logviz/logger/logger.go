@@ -0,0 +1,140 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package logger provides structured logging for LogViz servers, built on
+// log/slog.  An Observer attaches to each data query's Context a Logger
+// carrying the query's request ID, query names, and collection name, so that
+// every log emitted while handling the query, via FromContext, is annotated
+// with them.  Logs emitted by a LogViz server in slog's JSON format may
+// themselves be examined with LogViz.
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"time"
+
+	"github.com/google/traceviz/server/go/handlers"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Attribute keys attached to data query logs.
+const (
+	RequestIDKey  = "request_id"
+	QueriesKey    = "queries"
+	CollectionKey = "collection"
+)
+
+type contextKey struct{}
+
+// WithLogger returns a copy of the provided Context carrying the provided
+// Logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the Logger carried by the provided Context, or, if
+// there is none, the default Logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// RequestID returns a new random request ID.
+func RequestID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// Observer is a handlers.Observer annotating each DataRequest's Context with
+// a Logger, and logging each handled DataRequest.
+type Observer struct {
+	logger        *slog.Logger
+	collectionKey string
+}
+
+var _ handlers.Observer = &Observer{}
+
+// NewObserver returns a new Observer deriving per-request Loggers from the
+// provided Logger.  The global filter with the provided key names the
+// collection each DataRequest queries.
+func NewObserver(logger *slog.Logger, collectionKey string) *Observer {
+	return &Observer{
+		logger:        logger,
+		collectionKey: collectionKey,
+	}
+}
+
+// collection returns the collection name, or names, specified by the
+// provided DataRequest, or nil if it specifies none.
+func (o *Observer) collection(req *util.DataRequest) any {
+	val, ok := req.GlobalFilters[o.collectionKey]
+	if !ok {
+		return nil
+	}
+	if names, err := util.ExpectStringsValue(val); err == nil {
+		return names
+	}
+	if name, err := util.ExpectStringValue(val); err == nil {
+		return name
+	}
+	return nil
+}
+
+// RequestStarted attaches to the returned Context a Logger carrying a new
+// request ID, and the query names and collection name of the provided
+// DataRequest.
+func (o *Observer) RequestStarted(ctx context.Context, req *util.DataRequest) context.Context {
+	queryNames := make([]string, 0, len(req.SeriesRequests))
+	for _, seriesReq := range req.SeriesRequests {
+		if seriesReq != nil {
+			queryNames = append(queryNames, seriesReq.QueryName)
+		}
+	}
+	attrs := []any{
+		slog.String(RequestIDKey, RequestID()),
+		slog.Any(QueriesKey, queryNames),
+	}
+	if collection := o.collection(req); collection != nil {
+		attrs = append(attrs, slog.Any(CollectionKey, collection))
+	}
+	return WithLogger(ctx, o.logger.With(attrs...))
+}
+
+// RequestFinished logs the handled DataRequest, at level Error if it failed,
+// at level Warn if any of its data series failed, and otherwise at level
+// Info.
+func (o *Observer) RequestFinished(ctx context.Context, info *handlers.RequestInfo) {
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.Int("status", info.StatusCode),
+		slog.Duration("duration", info.Duration.Round(time.Microsecond)),
+	}
+	if info.HTTPRequest != nil {
+		attrs = append(attrs, slog.String("remote_addr", info.HTTPRequest.RemoteAddr))
+	}
+	if len(info.SeriesErrs) > 0 {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Any("series_errors", info.SeriesErrs))
+	}
+	if info.Err != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("error", info.Err.Error()))
+	}
+	FromContext(ctx).LogAttrs(ctx, level, "handled data request", attrs...)
+}
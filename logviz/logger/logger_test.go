@@ -0,0 +1,141 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/traceviz/server/go/handlers"
+	"github.com/google/traceviz/server/go/util"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestObserver(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		req         *util.DataRequest
+		err         error
+		// The expected logs, omitting request IDs and durations.
+		wantLogs []map[string]any
+	}{{
+		description: "single collection",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				"collection_name": util.StringValue("a.log"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{QueryName: "logs.timeseries"},
+				{QueryName: "logs.table"},
+			},
+		},
+		wantLogs: []map[string]any{{
+			"level":      "INFO",
+			"msg":        "handling",
+			"queries":    []any{"logs.timeseries", "logs.table"},
+			"collection": "a.log",
+		}, {
+			"level":       "INFO",
+			"msg":         "handled data request",
+			"queries":     []any{"logs.timeseries", "logs.table"},
+			"collection":  "a.log",
+			"status":      float64(http.StatusOK),
+			"remote_addr": "1.2.3.4:5",
+		}},
+	}, {
+		description: "compared collections, failed",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				"collection_name": util.StringsValue("a.log", "b.log"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{
+				{QueryName: "logs.compare"},
+			},
+		},
+		err: errors.New("oops"),
+		wantLogs: []map[string]any{{
+			"level":      "INFO",
+			"msg":        "handling",
+			"queries":    []any{"logs.compare"},
+			"collection": []any{"a.log", "b.log"},
+		}, {
+			"level":       "ERROR",
+			"msg":         "handled data request",
+			"queries":     []any{"logs.compare"},
+			"collection":  []any{"a.log", "b.log"},
+			"status":      float64(http.StatusOK),
+			"remote_addr": "1.2.3.4:5",
+			"error":       "oops",
+		}},
+	}, {
+		description: "no collection",
+		req:         &util.DataRequest{},
+		wantLogs: []map[string]any{{
+			"level":   "INFO",
+			"msg":     "handling",
+			"queries": []any{},
+		}, {
+			"level":       "INFO",
+			"msg":         "handled data request",
+			"queries":     []any{},
+			"status":      float64(http.StatusOK),
+			"remote_addr": "1.2.3.4:5",
+		}},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			o := NewObserver(slog.New(slog.NewJSONHandler(buf, nil)), "collection_name")
+			ctx := o.RequestStarted(context.Background(), test.req)
+			FromContext(ctx).Info("handling")
+			o.RequestFinished(ctx, &handlers.RequestInfo{
+				DataRequest: test.req,
+				HTTPRequest: &http.Request{RemoteAddr: "1.2.3.4:5"},
+				StatusCode:  http.StatusOK,
+				Err:         test.err,
+			})
+			var gotLogs []map[string]any
+			requestIDs := map[any]struct{}{}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				gotLog := map[string]any{}
+				if err := json.Unmarshal([]byte(line), &gotLog); err != nil {
+					t.Fatalf("Failed to parse log line '%s': %s", line, err)
+				}
+				requestIDs[gotLog[RequestIDKey]] = struct{}{}
+				for _, key := range []string{"time", "duration", RequestIDKey} {
+					delete(gotLog, key)
+				}
+				gotLogs = append(gotLogs, gotLog)
+			}
+			if diff := cmp.Diff(test.wantLogs, gotLogs); diff != "" {
+				t.Errorf("Got logs diff (-want +got):\n%s", diff)
+			}
+			if _, ok := requestIDs[nil]; ok || len(requestIDs) != 1 {
+				t.Errorf("Got request IDs %v, wanted a single request ID", requestIDs)
+			}
+		})
+	}
+}
+
+func TestFromContextDefault(t *testing.T) {
+	if got := FromContext(context.Background()); got != slog.Default() {
+		t.Errorf("FromContext() = %v, wanted the default Logger", got)
+	}
+}
@@ -0,0 +1,387 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package presets provides named filter presets for LogViz: saved views of a
+// collection, time range, set of source files, set of levels, and search, so
+// that teams can share canonical starting points for recurring
+// investigations.  Presets are persisted as JSON files in a directory, and
+// are saved, listed, and loaded through the data queries served by
+// DataSource.
+package presets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	saveQuery = "presets.save"
+	listQuery = "presets.list"
+	loadQuery = "presets.load"
+
+	presetNameKey          = "preset_name"
+	collectionNameKey      = "collection_name"
+	startTimestampKey      = "start_timestamp"
+	endTimestampKey        = "end_timestamp"
+	filteredSourceFilesKey = "filtered_source_files"
+	filteredLevelsKey      = "filtered_levels"
+	searchRegexKey         = "search_regex"
+)
+
+// ErrNotFound is returned by Store.Load for unknown preset names.
+var ErrNotFound = errors.New("preset not found")
+
+// Preset is a named set of LogViz filters.  Unset fields leave the
+// corresponding filter unset.
+type Preset struct {
+	// The preset's name, which must be nonempty and contain only letters,
+	// digits, underscores, and hyphens.
+	Name string `json:"name"`
+	// The collection to view.
+	Collection string `json:"collection,omitempty"`
+	// The filtered-in time range.  Zero times are unset.
+	StartTimestamp time.Time `json:"start_timestamp"`
+	EndTimestamp   time.Time `json:"end_timestamp"`
+	// The filtered-in source files, and level names.
+	SourceFiles []string `json:"source_files,omitempty"`
+	Levels      []string `json:"levels,omitempty"`
+	// The search regular expression.
+	Search string `json:"search,omitempty"`
+}
+
+// globalFilters returns the global filters applying the receiver, mapping
+// unset filters to nil.
+func (p *Preset) globalFilters() map[string]*util.V {
+	ret := map[string]*util.V{
+		collectionNameKey:      nil,
+		startTimestampKey:      nil,
+		endTimestampKey:        nil,
+		filteredSourceFilesKey: nil,
+		filteredLevelsKey:      nil,
+		searchRegexKey:         nil,
+	}
+	if p.Collection != "" {
+		ret[collectionNameKey] = util.StringValue(p.Collection)
+	}
+	if !p.StartTimestamp.IsZero() {
+		ret[startTimestampKey] = util.TimestampValue(p.StartTimestamp)
+	}
+	if !p.EndTimestamp.IsZero() {
+		ret[endTimestampKey] = util.TimestampValue(p.EndTimestamp)
+	}
+	if len(p.SourceFiles) > 0 {
+		ret[filteredSourceFilesKey] = util.StringsValue(p.SourceFiles...)
+	}
+	if len(p.Levels) > 0 {
+		ret[filteredLevelsKey] = util.StringsValue(p.Levels...)
+	}
+	if p.Search != "" {
+		ret[searchRegexKey] = util.StringValue(p.Search)
+	}
+	return ret
+}
+
+// properties returns the receiver's name and set filters as properties.
+func (p *Preset) properties() []util.PropertyUpdate {
+	return []util.PropertyUpdate{
+		util.StringProperty(presetNameKey, p.Name),
+		util.If(p.Collection != "", util.StringProperty(collectionNameKey, p.Collection)),
+		util.If(!p.StartTimestamp.IsZero(), util.TimestampProperty(startTimestampKey, p.StartTimestamp)),
+		util.If(!p.EndTimestamp.IsZero(), util.TimestampProperty(endTimestampKey, p.EndTimestamp)),
+		util.If(len(p.SourceFiles) > 0, util.StringsProperty(filteredSourceFilesKey, p.SourceFiles...)),
+		util.If(len(p.Levels) > 0, util.StringsProperty(filteredLevelsKey, p.Levels...)),
+		util.If(p.Search != "", util.StringProperty(searchRegexKey, p.Search)),
+	}
+}
+
+// validate returns an error if the receiver is malformed.
+func (p *Preset) validate() error {
+	if !validNameRE.MatchString(p.Name) {
+		return fmt.Errorf("invalid preset name '%s'", p.Name)
+	}
+	if !p.StartTimestamp.IsZero() && !p.EndTimestamp.IsZero() && p.EndTimestamp.Before(p.StartTimestamp) {
+		return fmt.Errorf("preset '%s' ends before it starts", p.Name)
+	}
+	if _, err := regexp.Compile(p.Search); err != nil {
+		return fmt.Errorf("preset '%s' has invalid search: %s", p.Name, err)
+	}
+	return nil
+}
+
+// Store persists Presets as JSON files in a directory.  Stores support
+// concurrent use, including by multiple processes sharing the directory.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a new Store in the specified directory, creating it if
+// necessary.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create preset directory: %s", err)
+	}
+	return &Store{
+		dir: dir,
+	}, nil
+}
+
+// validNameRE matches preset names that are safe to use as filenames.
+var validNameRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+const presetSuffix = ".json"
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+presetSuffix)
+}
+
+// Save stores the provided Preset, replacing any existing Preset of the same
+// name.  Presets are written to a temporary file and renamed into place, so
+// that concurrent readers never see partial contents.
+func (s *Store) Save(p *Preset) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	contents, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal preset: %s", err)
+	}
+	file, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path(p.Name))
+}
+
+// Load returns the Preset with the specified name, or ErrNotFound.
+func (s *Store) Load(name string) (*Preset, error) {
+	if !validNameRE.MatchString(name) {
+		return nil, ErrNotFound
+	}
+	contents, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := &Preset{}
+	if err := json.Unmarshal(contents, p); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal preset '%s': %s", name, err)
+	}
+	return p, nil
+}
+
+// List returns all stored Presets, ordered by name.
+func (s *Store) List() ([]*Preset, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Preset
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), presetSuffix)
+		if !ok || entry.IsDir() || !validNameRE.MatchString(name) {
+			continue
+		}
+		p, err := s.Load(name)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted since the directory was read.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].Name < ret[b].Name
+	})
+	return ret, nil
+}
+
+var (
+	nameSchema = []*util.OptionSpec{{
+		Key:      presetNameKey,
+		Source:   util.FromSeries,
+		Required: true,
+		Type:     util.StringValueType,
+	}}
+	saveSchema = append([]*util.OptionSpec{
+		{Key: collectionNameKey, Type: util.StringValueType},
+		{Key: startTimestampKey, Type: util.TimestampValueType},
+		{Key: endTimestampKey, Type: util.TimestampValueType},
+		{Key: filteredSourceFilesKey, Type: util.StringsValueType},
+		{Key: filteredLevelsKey, Type: util.StringsValueType},
+		{Key: searchRegexKey, Type: util.StringValueType},
+	}, nameSchema...)
+)
+
+// DataSource is a QueryDispatcher data source serving the Presets in a
+// Store.  It supports the queries:
+//
+//   - 'presets.save', which saves the filters in effect as the Preset named
+//     by the 'preset_name' option, and responds as 'presets.load' does.
+//     Each filter may be specified as a data series option or as a global
+//     filter; filters specified as neither are left unset.
+//   - 'presets.list', which yields a child datum for each saved Preset,
+//     ordered by name, with its name and set filters as properties.
+//   - 'presets.load', which yields the Preset named by the 'preset_name'
+//     option, with its name and set filters as properties, and applies it by
+//     setting the response's global filters to its filters, removing those
+//     it leaves unset.
+//
+// Preset filters use the same keys as the LogViz global filters:
+// 'collection_name', 'start_timestamp', 'end_timestamp',
+// 'filtered_source_files', 'filtered_levels', and 'search_regex'.
+type DataSource struct {
+	store *Store
+}
+
+// NewDataSource returns a new DataSource serving the Presets in the provided
+// Store.
+func NewDataSource(store *Store) *DataSource {
+	return &DataSource{
+		store: store,
+	}
+}
+
+// SupportedDataSeriesQueries returns the queries supported by DataSource.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	return []string{saveQuery, listQuery, loadQuery}
+}
+
+// HandleDataSeriesRequests handles the provided DataSeriesRequests.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		var err error
+		switch req.QueryName {
+		case saveQuery:
+			err = ds.handleSaveQuery(globalState, drb, req)
+		case listQuery:
+			err = ds.handleListQuery(drb, req)
+		case loadQuery:
+			err = ds.handleLoadQuery(drb, req)
+		default:
+			err = fmt.Errorf("unsupported data query `%s`", req.QueryName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ds *DataSource) handleSaveQuery(globalState map[string]*util.V, drb *util.DataResponseBuilder, req *util.DataSeriesRequest) error {
+	opts, err := util.ResolveOptions(globalState, req.Options, saveSchema)
+	if err != nil {
+		return err
+	}
+	p := &Preset{}
+	if p.Name, err = opts.String(presetNameKey); err != nil {
+		return err
+	}
+	if opts.Has(collectionNameKey) {
+		if p.Collection, err = opts.String(collectionNameKey); err != nil {
+			return err
+		}
+	}
+	if opts.Has(startTimestampKey) {
+		if p.StartTimestamp, err = opts.Timestamp(startTimestampKey); err != nil {
+			return err
+		}
+	}
+	if opts.Has(endTimestampKey) {
+		if p.EndTimestamp, err = opts.Timestamp(endTimestampKey); err != nil {
+			return err
+		}
+	}
+	if opts.Has(filteredSourceFilesKey) {
+		if p.SourceFiles, err = opts.Strings(filteredSourceFilesKey); err != nil {
+			return err
+		}
+	}
+	if opts.Has(filteredLevelsKey) {
+		if p.Levels, err = opts.Strings(filteredLevelsKey); err != nil {
+			return err
+		}
+	}
+	if opts.Has(searchRegexKey) {
+		if p.Search, err = opts.String(searchRegexKey); err != nil {
+			return err
+		}
+	}
+	if err := ds.store.Save(p); err != nil {
+		return err
+	}
+	apply(p, drb, req)
+	return nil
+}
+
+func (ds *DataSource) handleListQuery(drb *util.DataResponseBuilder, req *util.DataSeriesRequest) error {
+	if _, err := util.ResolveOptions(nil, req.Options, nil); err != nil {
+		return err
+	}
+	ps, err := ds.store.List()
+	if err != nil {
+		return err
+	}
+	series := drb.DataSeries(req)
+	for _, p := range ps {
+		series.Child().With(p.properties()...)
+	}
+	return nil
+}
+
+func (ds *DataSource) handleLoadQuery(drb *util.DataResponseBuilder, req *util.DataSeriesRequest) error {
+	opts, err := util.ResolveOptions(nil, req.Options, nameSchema)
+	if err != nil {
+		return err
+	}
+	name, err := opts.String(presetNameKey)
+	if err != nil {
+		return err
+	}
+	p, err := ds.store.Load(name)
+	if err != nil {
+		return fmt.Errorf("failed to load preset '%s': %s", name, err)
+	}
+	apply(p, drb, req)
+	return nil
+}
+
+// apply emits the provided Preset into the provided request's data series,
+// and sets the response's global filters to its filters.
+func apply(p *Preset, drb *util.DataResponseBuilder, req *util.DataSeriesRequest) {
+	drb.DataSeries(req).With(p.properties()...)
+	for key, val := range p.globalFilters() {
+		drb.SetGlobalFilter(key, val)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package presets
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	testutil "github.com/google/traceviz/server/go/test_util"
+	"github.com/google/traceviz/server/go/util"
+)
+
+var startTime = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func ts(dur time.Duration) time.Time {
+	return startTime.Add(dur)
+}
+
+func TestStore(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore() yielded unexpected error %s", err)
+	}
+	outage := &Preset{
+		Name:           "outage",
+		Collection:     "log1",
+		StartTimestamp: ts(0),
+		EndTimestamp:   ts(time.Hour),
+		SourceFiles:    []string{"a.cc"},
+		Levels:         []string{"Error", "Fatal"},
+		Search:         "Alert",
+	}
+	retries := &Preset{
+		Name:   "retries",
+		Search: "Retrying",
+	}
+	for _, p := range []*Preset{retries, outage} {
+		if err := store.Save(p); err != nil {
+			t.Fatalf("Save(%s) yielded unexpected error %s", p.Name, err)
+		}
+	}
+	got, err := store.Load("outage")
+	if err != nil {
+		t.Fatalf("Load() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(outage, got); diff != "" {
+		t.Errorf("Load() = %v, diff (-want +got):\n%s", got, diff)
+	}
+	gotList, err := store.List()
+	if err != nil {
+		t.Fatalf("List() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff([]*Preset{outage, retries}, gotList); diff != "" {
+		t.Errorf("List() = %v, diff (-want +got):\n%s", gotList, diff)
+	}
+	if _, err := store.Load("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load() of a missing preset yielded error %v, want ErrNotFound", err)
+	}
+	if _, err := store.Load("../outage"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load() of an invalid name yielded error %v, want ErrNotFound", err)
+	}
+	for _, p := range []*Preset{{
+		Name: "../escape",
+	}, {
+		Name:           "backwards",
+		StartTimestamp: ts(time.Hour),
+		EndTimestamp:   ts(0),
+	}, {
+		Name:   "bad_search",
+		Search: "(",
+	}} {
+		if err := store.Save(p); err == nil {
+			t.Errorf("Save(%s) yielded no error, but expected one", p.Name)
+		}
+	}
+}
+
+func TestQueries(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore() yielded unexpected error %s", err)
+	}
+	qd, err := querydispatcher.New(NewDataSource(store))
+	if err != nil {
+		t.Fatalf("Unexpected failure creating query dispatcher: %s", err)
+	}
+	// Each test depends on the presets saved by its predecessors.
+	for _, test := range []struct {
+		description string
+		req         *util.DataRequest
+		wantSeries  func(db util.DataBuilder)
+		wantFilters map[string]*util.V
+		wantErr     bool
+	}{{
+		description: "save from global filters",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey:      util.StringValue("log1"),
+				startTimestampKey:      util.TimestampValue(ts(0)),
+				endTimestampKey:        util.TimestampValue(ts(time.Hour)),
+				filteredSourceFilesKey: util.StringsValue("a.cc"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName: saveQuery,
+				Options: map[string]*util.V{
+					presetNameKey:     util.StringValue("outage"),
+					filteredLevelsKey: util.StringsValue("Error"),
+					searchRegexKey:    util.StringValue("Alert"),
+				},
+			}},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			db.With(
+				util.StringProperty(presetNameKey, "outage"),
+				util.StringProperty(collectionNameKey, "log1"),
+				util.TimestampProperty(startTimestampKey, ts(0)),
+				util.TimestampProperty(endTimestampKey, ts(time.Hour)),
+				util.StringsProperty(filteredSourceFilesKey, "a.cc"),
+				util.StringsProperty(filteredLevelsKey, "Error"),
+				util.StringProperty(searchRegexKey, "Alert"),
+			)
+		},
+		wantFilters: map[string]*util.V{
+			collectionNameKey:      util.StringValue("log1"),
+			startTimestampKey:      util.TimestampValue(ts(0)),
+			endTimestampKey:        util.TimestampValue(ts(time.Hour)),
+			filteredSourceFilesKey: util.StringsValue("a.cc"),
+			filteredLevelsKey:      util.StringsValue("Error"),
+			searchRegexKey:         util.StringValue("Alert"),
+		},
+	}, {
+		description: "save from options",
+		req: &util.DataRequest{
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName: saveQuery,
+				Options: map[string]*util.V{
+					presetNameKey:     util.StringValue("log2_errors"),
+					collectionNameKey: util.StringValue("log2"),
+					filteredLevelsKey: util.StringsValue("Error", "Fatal"),
+				},
+			}},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			db.With(
+				util.StringProperty(presetNameKey, "log2_errors"),
+				util.StringProperty(collectionNameKey, "log2"),
+				util.StringsProperty(filteredLevelsKey, "Error", "Fatal"),
+			)
+		},
+		wantFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log2"),
+			filteredLevelsKey: util.StringsValue("Error", "Fatal"),
+		},
+	}, {
+		description: "save without name",
+		req: &util.DataRequest{
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName: saveQuery,
+			}},
+		},
+		wantErr: true,
+	}, {
+		description: "list",
+		req: &util.DataRequest{
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName: listQuery,
+			}},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			db.Child().With(
+				util.StringProperty(presetNameKey, "log2_errors"),
+				util.StringProperty(collectionNameKey, "log2"),
+				util.StringsProperty(filteredLevelsKey, "Error", "Fatal"),
+			)
+			db.Child().With(
+				util.StringProperty(presetNameKey, "outage"),
+				util.StringProperty(collectionNameKey, "log1"),
+				util.TimestampProperty(startTimestampKey, ts(0)),
+				util.TimestampProperty(endTimestampKey, ts(time.Hour)),
+				util.StringsProperty(filteredSourceFilesKey, "a.cc"),
+				util.StringsProperty(filteredLevelsKey, "Error"),
+				util.StringProperty(searchRegexKey, "Alert"),
+			)
+		},
+	}, {
+		description: "load replaces filters",
+		req: &util.DataRequest{
+			GlobalFilters: map[string]*util.V{
+				collectionNameKey: util.StringValue("log1"),
+				startTimestampKey: util.TimestampValue(ts(0)),
+				searchRegexKey:    util.StringValue("Hello"),
+			},
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName: loadQuery,
+				Options: map[string]*util.V{
+					presetNameKey: util.StringValue("log2_errors"),
+				},
+			}},
+		},
+		wantSeries: func(db util.DataBuilder) {
+			db.With(
+				util.StringProperty(presetNameKey, "log2_errors"),
+				util.StringProperty(collectionNameKey, "log2"),
+				util.StringsProperty(filteredLevelsKey, "Error", "Fatal"),
+			)
+		},
+		wantFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("log2"),
+			filteredLevelsKey: util.StringsValue("Error", "Fatal"),
+		},
+	}, {
+		description: "load missing",
+		req: &util.DataRequest{
+			SeriesRequests: []*util.DataSeriesRequest{{
+				QueryName: loadQuery,
+				Options: map[string]*util.V{
+					presetNameKey: util.StringValue("missing"),
+				},
+			}},
+		},
+		wantErr: true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			gotData, err := qd.HandleDataRequest(context.Background(), test.req)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error status: got %s", err)
+			}
+			if err != nil {
+				return
+			}
+			drb := util.NewDataResponseBuilder()
+			test.wantSeries(drb.DataSeries(test.req.SeriesRequests[0]))
+			if err := testutil.CompareDataResponses(t, gotData, drb); err != nil {
+				t.Fatalf("Failed to compare data responses: %s", err)
+			}
+			wantFilters := test.wantFilters
+			if wantFilters == nil {
+				wantFilters = test.req.GlobalFilters
+			}
+			if diff := cmp.Diff(wantFilters, gotData.GlobalFilters); diff != "" {
+				t.Errorf("GlobalFilters diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
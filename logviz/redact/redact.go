@@ -0,0 +1,330 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package redact provides Redactor, a logtrace.Redactor masking sensitive
+// content, such as email addresses, IP addresses, and credentials, in log
+// messages and derived fields, so that LogViz may be used on logs subject to
+// PII constraints.  Redactors are configured per deployment by a JSON Config,
+// which also specifies whether logs are redacted as they are parsed, so that
+// sensitive content is never held by LogViz, or as queries are answered.
+//
+// For example, the Config
+//
+//	{
+//	  "stage": "parse",
+//	  "builtins": ["email", "ipv4"],
+//	  "patterns": [{"name": "ssn", "regex": "\\b\\d{3}-\\d{2}-\\d{4}\\b"}],
+//	  "fields": ["user"]
+//	}
+//
+// masks, as logs are parsed, email addresses, IPv4 addresses, and US social
+// security numbers, as well as the value of the derived field 'user' wherever
+// it appears.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+)
+
+// The name of the capture group which, if present in a Rule's pattern,
+// delimits the part of each match that is masked.
+const secretGroup = "secret"
+
+// Rule masks the matches of a regular expression.
+type Rule struct {
+	// The rule's name, which appears in its masks.
+	Name string
+	// The pattern matching sensitive content.  If it has a capture group
+	// named 'secret', only that group is masked in each match; otherwise, the
+	// whole match is.
+	Pattern *regexp.Regexp
+}
+
+// builtins maps the names of the built-in Rules to their patterns.
+var builtins = map[string]string{
+	"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"ipv4":  `\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`,
+	"ipv6":  `(?i)\b(?:(?:[0-9a-f]{1,4}:){7}[0-9a-f]{1,4}|(?:[0-9a-f]{1,4}:){1,6}(?::[0-9a-f]{1,4}){1,6})\b`,
+	// Bearer tokens, and values assigned to keys naming credentials.
+	"token": `(?i)(?:\bbearer\s+|\b(?:access[_-]?token|token|api[_-]?key|secret|password|passwd)\s*[=:]\s*"?)(?P<secret>[^\s",;&]+)`,
+}
+
+// Builtin returns the built-in Rule with the specified name: 'email', 'ipv4',
+// 'ipv6', or 'token'.
+func Builtin(name string) (*Rule, error) {
+	pattern, ok := builtins[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown built-in redaction rule '%s'", name)
+	}
+	return &Rule{
+		Name:    name,
+		Pattern: regexp.MustCompile(pattern),
+	}, nil
+}
+
+// mask returns the mask replacing content redacted by the rule or field with
+// the specified name.
+func mask(name string) string {
+	return "[REDACTED:" + name + "]"
+}
+
+// Redactor is a logtrace.Redactor masking the matches of a set of Rules, and
+// the values of a set of derived fields, in log messages and derived fields.
+// Redactors support concurrent use.
+type Redactor struct {
+	rules  []*Rule
+	fields []string
+}
+
+var _ logtrace.Redactor = &Redactor{}
+
+// New returns a new Redactor masking the matches of the provided Rules in
+// each message and derived field value, and masking the values of the
+// specified derived fields, both in those fields and wherever they appear in
+// the message.
+func New(rules []*Rule, fields ...string) *Redactor {
+	sortedFields := append([]string{}, fields...)
+	sort.Strings(sortedFields)
+	return &Redactor{
+		rules:  rules,
+		fields: sortedFields,
+	}
+}
+
+// Redact returns the provided message and derived fields with their
+// sensitive content masked.  If nothing is masked, they are returned as-is.
+func (r *Redactor) Redact(message []string, fields map[string]string) ([]string, map[string]string) {
+	var fieldValues []fieldValue
+	for _, name := range r.fields {
+		if value := fields[name]; value != "" {
+			fieldValues = append(fieldValues, fieldValue{name, value})
+		}
+	}
+	var retMessage []string
+	for idx, line := range message {
+		if redacted := r.redact(line, fieldValues); redacted != line {
+			if retMessage == nil {
+				retMessage = append([]string{}, message...)
+			}
+			retMessage[idx] = redacted
+		}
+	}
+	if retMessage == nil {
+		retMessage = message
+	}
+	var retFields map[string]string
+	for name, value := range fields {
+		redacted := r.redact(value, fieldValues)
+		if r.redactsField(name) {
+			redacted = mask(name)
+		}
+		if redacted != value {
+			if retFields == nil {
+				retFields = make(map[string]string, len(fields))
+				for n, v := range fields {
+					retFields[n] = v
+				}
+			}
+			retFields[name] = redacted
+		}
+	}
+	if retFields == nil {
+		retFields = fields
+	}
+	return retMessage, retFields
+}
+
+// fieldValue is the value of a redacted field.
+type fieldValue struct {
+	name, value string
+}
+
+// span is a range of text to be masked.
+type span struct {
+	start, end int
+	name       string
+}
+
+// redact returns the provided text with the matches of the receiver's Rules,
+// and of the provided redacted field values, masked.  Overlapping matches are
+// masked together, by the mask of the earliest, or of the longest among those
+// starting together.
+func (r *Redactor) redact(text string, fieldValues []fieldValue) string {
+	var spans []span
+	for _, fv := range fieldValues {
+		for offset := 0; ; {
+			idx := strings.Index(text[offset:], fv.value)
+			if idx < 0 {
+				break
+			}
+			offset += idx + len(fv.value)
+			spans = append(spans, span{offset - len(fv.value), offset, fv.name})
+		}
+	}
+	for _, rule := range r.rules {
+		spans = rule.appendSpans(spans, text)
+	}
+	if len(spans) == 0 {
+		return text
+	}
+	sort.SliceStable(spans, func(a, b int) bool {
+		if spans[a].start != spans[b].start {
+			return spans[a].start < spans[b].start
+		}
+		return spans[a].end > spans[b].end
+	})
+	var sb strings.Builder
+	last := 0
+	for idx := 0; idx < len(spans); {
+		cur := spans[idx]
+		for idx++; idx < len(spans) && spans[idx].start < cur.end; idx++ {
+			if spans[idx].end > cur.end {
+				cur.end = spans[idx].end
+			}
+		}
+		sb.WriteString(text[last:cur.start])
+		sb.WriteString(mask(cur.name))
+		last = cur.end
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+func (r *Redactor) redactsField(name string) bool {
+	idx := sort.SearchStrings(r.fields, name)
+	return idx < len(r.fields) && r.fields[idx] == name
+}
+
+// String describes the receiver's Rules and redacted fields.
+func (r *Redactor) String() string {
+	rules := make([]string, len(r.rules))
+	for idx, rule := range r.rules {
+		rules[idx] = rule.Name + "=" + rule.Pattern.String()
+	}
+	return fmt.Sprintf("rules:%q fields:%q", rules, r.fields)
+}
+
+// appendSpans appends the spans of the receiver's matches in the provided
+// text to the provided spans.
+func (rule *Rule) appendSpans(spans []span, text string) []span {
+	group := rule.Pattern.SubexpIndex(secretGroup)
+	for _, match := range rule.Pattern.FindAllStringSubmatchIndex(text, -1) {
+		start, end := match[0], match[1]
+		if group >= 0 {
+			start, end = match[2*group], match[2*group+1]
+		}
+		if start < end {
+			spans = append(spans, span{start, end, rule.Name})
+		}
+	}
+	return spans
+}
+
+// Stage specifies when logs are redacted.
+type Stage string
+
+const (
+	// ParseStage redacts logs as they are parsed, so that their sensitive
+	// content is never held, or cached, by LogViz.  Changing the redaction
+	// configuration requires logs to be reparsed.
+	ParseStage Stage = "parse"
+	// QueryStage redacts logs as queries are answered.  Logs are held
+	// unredacted, but their sensitive content never appears in responses.
+	QueryStage Stage = "query"
+)
+
+// Config is a JSON-encodable redaction configuration.
+type Config struct {
+	// When logs are redacted.  Defaults to ParseStage.
+	Stage Stage `json:"stage,omitempty"`
+	// The names of the built-in Rules to apply.
+	Builtins []string `json:"builtins,omitempty"`
+	// Additional Rules to apply.
+	Patterns []PatternConfig `json:"patterns,omitempty"`
+	// The names of the derived fields whose values are masked.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// PatternConfig is a JSON-encodable Rule.
+type PatternConfig struct {
+	// The Rule's name, which appears in its masks.
+	Name string `json:"name"`
+	// The Rule's regular expression.
+	Regex string `json:"regex"`
+}
+
+// ParseConfig parses and validates the provided JSON Config.
+func ParseConfig(data []byte) (*Config, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	config := &Config{}
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("malformed redaction config: %s", err)
+	}
+	switch config.Stage {
+	case "":
+		config.Stage = ParseStage
+	case ParseStage, QueryStage:
+	default:
+		return nil, fmt.Errorf("unsupported redaction stage '%s'; want '%s' or '%s'", config.Stage, ParseStage, QueryStage)
+	}
+	if _, err := config.Redactor(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadConfig reads, parses, and validates the JSON Config at the specified
+// path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// Redactor returns a new Redactor applying the receiver's Rules and masking
+// its fields.
+func (c *Config) Redactor() (*Redactor, error) {
+	var rules []*Rule
+	for _, name := range c.Builtins {
+		rule, err := Builtin(name)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	for _, pc := range c.Patterns {
+		if pc.Name == "" {
+			return nil, fmt.Errorf("redaction pattern `%s` has no name", pc.Regex)
+		}
+		pattern, err := regexp.Compile(pc.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern '%s': %s", pc.Name, err)
+		}
+		rules = append(rules, &Rule{
+			Name:    pc.Name,
+			Pattern: pattern,
+		})
+	}
+	return New(rules, c.Fields...), nil
+}
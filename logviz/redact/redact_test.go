@@ -0,0 +1,155 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package redact
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRedact(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		config      string
+		message     []string
+		fields      map[string]string
+		wantMessage []string
+		wantFields  map[string]string
+	}{{
+		description: "nothing to redact",
+		config:      `{"builtins": ["email", "ipv4", "ipv6", "token"]}`,
+		message:     []string{"Started at 12:34:56 with 3 workers"},
+		fields:      map[string]string{"workers": "3"},
+		wantMessage: []string{"Started at 12:34:56 with 3 workers"},
+		wantFields:  map[string]string{"workers": "3"},
+	}, {
+		description: "built-in rules",
+		config:      `{"builtins": ["email", "ipv4", "ipv6", "token"]}`,
+		message: []string{
+			"Login by jane.doe@example.com from 10.0.0.12",
+			"Peer fe80::1ff:fe23:4567:890a connected",
+			`Authorization: Bearer abc.def; api_key="s3cr3t" password=hunter2`,
+		},
+		wantMessage: []string{
+			"Login by [REDACTED:email] from [REDACTED:ipv4]",
+			"Peer [REDACTED:ipv6] connected",
+			`Authorization: Bearer [REDACTED:token]; api_key="[REDACTED:token]" password=[REDACTED:token]`,
+		},
+	}, {
+		description: "custom patterns, with and without secret groups",
+		config: `{"patterns": [
+			{"name": "ssn", "regex": "\\b\\d{3}-\\d{2}-\\d{4}\\b"},
+			{"name": "account", "regex": "account (?P<secret>\\d+)"}
+		]}`,
+		message:     []string{"SSN 123-45-6789 on account 42"},
+		wantMessage: []string{"SSN [REDACTED:ssn] on account [REDACTED:account]"},
+	}, {
+		description: "fields",
+		config:      `{"builtins": ["email"], "fields": ["user"]}`,
+		message:     []string{"User alice (alice@example.com) logged in"},
+		fields: map[string]string{
+			"user":    "alice",
+			"contact": "alice@example.com",
+			"count":   "1",
+		},
+		wantMessage: []string{"User [REDACTED:user] ([REDACTED:email]) logged in"},
+		wantFields: map[string]string{
+			"user":    "[REDACTED:user]",
+			"contact": "[REDACTED:email]",
+			"count":   "1",
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			config, err := ParseConfig([]byte(test.config))
+			if err != nil {
+				t.Fatalf("ParseConfig() yielded unexpected error %s", err)
+			}
+			r, err := config.Redactor()
+			if err != nil {
+				t.Fatalf("Redactor() yielded unexpected error %s", err)
+			}
+			origMessage := append([]string{}, test.message...)
+			gotMessage, gotFields := r.Redact(test.message, test.fields)
+			if diff := cmp.Diff(test.wantMessage, gotMessage); diff != "" {
+				t.Errorf("Redact() yielded message %v, diff (-want +got):\n%s", gotMessage, diff)
+			}
+			if diff := cmp.Diff(test.wantFields, gotFields); diff != "" {
+				t.Errorf("Redact() yielded fields %v, diff (-want +got):\n%s", gotFields, diff)
+			}
+			if diff := cmp.Diff(origMessage, test.message); diff != "" {
+				t.Errorf("Redact() modified its input message, diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		config      string
+		wantStage   Stage
+		wantErr     bool
+	}{{
+		description: "default stage",
+		config:      `{"builtins": ["email"]}`,
+		wantStage:   ParseStage,
+	}, {
+		description: "query stage",
+		config:      `{"stage": "query", "fields": ["user"]}`,
+		wantStage:   QueryStage,
+	}, {
+		description: "unsupported stage",
+		config:      `{"stage": "render"}`,
+		wantErr:     true,
+	}, {
+		description: "unknown builtin",
+		config:      `{"builtins": ["phone"]}`,
+		wantErr:     true,
+	}, {
+		description: "malformed pattern",
+		config:      `{"patterns": [{"name": "bad", "regex": "("}]}`,
+		wantErr:     true,
+	}, {
+		description: "unnamed pattern",
+		config:      `{"patterns": [{"regex": "x"}]}`,
+		wantErr:     true,
+	}, {
+		description: "unknown key",
+		config:      `{"builtin": ["email"]}`,
+		wantErr:     true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			config, err := ParseConfig([]byte(test.config))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseConfig() yielded error %v, wanted error: %t", err, test.wantErr)
+			}
+			if err == nil && config.Stage != test.wantStage {
+				t.Errorf("ParseConfig() yielded stage '%s', want '%s'", config.Stage, test.wantStage)
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	a := New(nil, "user", "host")
+	b := New(nil, "host", "user")
+	c := New(nil, "host")
+	if a.String() != b.String() {
+		t.Errorf("Redactors differing only in field order have descriptions %s and %s, want equal", a, b)
+	}
+	if a.String() == c.String() {
+		t.Errorf("Redactors masking different fields have equal descriptions %s", a)
+	}
+}
@@ -1,42 +1,244 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/google/traceviz/logviz/audit"
+	"github.com/google/traceviz/logviz/redact"
 	"github.com/google/traceviz/logviz/service"
+	"github.com/google/traceviz/server/go/handlers"
 )
 
 var (
 	port         = flag.Int("port", 7410, "Port to serve LogViz clients on")
+	address      = flag.String("address", "", "The host address to serve LogViz clients on; defaults to all addresses")
 	resourceRoot = flag.String("resource_root", "", "The path to the LogViz tool client resources")
 	logRoot      = flag.String("log_root", ".", "The root path for visualizable logs")
+
+	onDiskThreshold = flag.Int("on_disk_threshold", 0, "If positive, logs with more than this many entries are held in memory-mapped files rather than in memory")
+	onDiskDir       = flag.String("on_disk_dir", "", "The directory in which to create memory-mapped log files; defaults to the system temporary directory")
+	cacheMaxBytes   = flag.Int64("cache_max_bytes", 0, "If positive, the approximate maximum total memory of cached logs")
+	cacheTTL        = flag.Duration("cache_ttl", 0, "If positive, the duration after which cached logs expire")
+	parsedCacheDir  = flag.String("parsed_cache_dir", "", "If set, the directory in which to cache parsed logs across restarts")
+	presetsDir      = flag.String("presets_dir", "", "If set, the directory in which named filter presets are saved, so that they can be shared across users and restarts")
+	annotationsDir  = flag.String("annotations_dir", "", "If set, the directory in which annotations on logs' time ranges are saved, so that they can be shared across users and restarts")
+
+	timeZone   = flag.String("time_zone", "", "If set, the IANA time zone name in which log timestamps' wall-clock readings are reinterpreted")
+	logOffsets = flag.String("log_offsets", "", "A comma-separated list of <log name>=<duration> offsets added to logs' timestamps, to correct clock skew")
+
+	correlationIDRegex = flag.String("correlation_id_regex", "", "If set, a regular expression extracting each log message's correlation ID, such as a session or trace ID, as its first capture group")
+	correlationIDField = flag.String("correlation_id_field", "", "If set, and --correlation_id_regex is not, the structured field holding each log message's correlation ID")
+	fieldRegex         = flag.String("field_regex", "", "If set, a regular expression whose named capture groups extract derived fields, such as latencies or RPC methods, from each log message")
+	redactionConfig    = flag.String("redaction_config", "", "If set, the path to a JSON redaction config specifying sensitive content, such as emails, IP addresses, or tokens, to mask in logs as they are parsed or as they are queried")
+
+	authHeader   = flag.String("auth_header", "", "If set, the request header, set by a trusted authenticating proxy, naming the requesting user; only --allowed_users may then query")
+	allowedUsers = flag.String("allowed_users", "", "A comma-separated list of users allowed to query when --auth_header is set")
+	auditLog     = flag.String("audit_log", "", "If set, where to record who made each data query, of which logs, and when: 'stdout', an http:// or https:// URL to post each record to, or a file path to append to")
+
+	qpsPerUser    = flag.Float64("qps_per_user", 0, "If positive, the sustained data queries per second allowed to each user or IP address")
+	burstPerUser  = flag.Int("burst_per_user", 10, "The data queries each user or IP address may make in a burst above --qps_per_user")
+	maxConcurrent = flag.Int("max_concurrent_queries", 0, "If positive, the maximum number of data queries handled concurrently")
+
+	maxRequestBytes   = flag.Int64("max_request_bytes", 1<<20, "If positive, the maximum size, in bytes, of a data query request")
+	maxSeriesRequests = flag.Int("max_series_requests", 100, "If positive, the maximum number of data series requests in a data query")
+	maxOptions        = flag.Int("max_options", 100, "If positive, the maximum number of global filters in a data query, and of options in each of its data series requests")
+
+	corsOrigins = flag.String("cors_allowed_origins", "", "A comma-separated list of origins allowed to make cross-origin data queries")
+	csrf        = flag.Bool("csrf", false, "If true, data queries require a CSRF token")
+
+	validateResponses = flag.Bool("validate_responses", false, "If true, data query responses are checked against the well-known data models before they are sent; for debugging")
+
+	resourceAccounting = flag.Bool("resource_accounting", false, "If true, the resources used by each data query are recorded, and served at /admin/resource_stats")
+	selfTraceRequests  = flag.Int("self_trace_requests", 0, "If positive, the handling of this many of the most recent data queries is traced, and served as the query 'traceviz.self_trace'")
+	watchInterval      = flag.Duration("watch_interval", 0, "If positive, clients may watch logs for changes, and watched logs are checked for changes at this interval")
+
+	tlsCert           = flag.String("tls_cert", "", "If set, with --tls_key, the path to the TLS certificate with which to serve LogViz over HTTPS")
+	tlsKey            = flag.String("tls_key", "", "If set, with --tls_cert, the path to the TLS private key with which to serve LogViz over HTTPS")
+	readTimeout       = flag.Duration("read_timeout", 0, "If positive, the maximum duration for reading an entire request")
+	readHeaderTimeout = flag.Duration("read_header_timeout", 10*time.Second, "If positive, the maximum duration for reading request headers")
+	writeTimeout      = flag.Duration("write_timeout", 0, "If positive, the maximum duration for writing a response; also bounds change notification streams")
+	idleTimeout       = flag.Duration("idle_timeout", 2*time.Minute, "If positive, the maximum duration a keep-alive connection may be idle")
+	shutdownTimeout   = flag.Duration("shutdown_timeout", 30*time.Second, "If positive, the maximum duration to wait for in-flight requests to finish on SIGTERM")
+
+	logFormat = flag.String("log_format", "text", "The format of server logs: 'text' or 'json'")
+	logLevel  = flag.String("log_level", "info", "The minimum level of server logs: 'debug', 'info', 'warn', or 'error'")
 )
 
 func main() {
 	flag.Parse()
 
-	service, err := service.New(*resourceRoot, *logRoot, 10)
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalf("Malformed log level: %s", err)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	switch *logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, handlerOpts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, handlerOpts)))
+	default:
+		log.Fatalf("Unsupported log format '%s'", *logFormat)
+	}
+
+	opts := []service.Option{
+		service.WithOnDiskThreshold(*onDiskDir, *onDiskThreshold),
+		service.WithParsedCacheDir(*parsedCacheDir),
+		service.WithPresetsDir(*presetsDir),
+		service.WithAnnotationsDir(*annotationsDir),
+		service.WithCacheMaxBytes(*cacheMaxBytes),
+		service.WithCacheTTL(*cacheTTL),
+		service.WithChangePolling(*watchInterval),
+		service.WithLimits(handlers.Limits{
+			RequestsPerSecond:     *qpsPerUser,
+			Burst:                 *burstPerUser,
+			MaxConcurrentRequests: *maxConcurrent,
+		}),
+		service.WithRequestLimits(handlers.RequestLimits{
+			MaxRequestBytes:   *maxRequestBytes,
+			MaxSeriesRequests: *maxSeriesRequests,
+			MaxOptions:        *maxOptions,
+		}),
+	}
+	if *timeZone != "" || *logOffsets != "" {
+		var tz *time.Location
+		if *timeZone != "" {
+			var err error
+			if tz, err = time.LoadLocation(*timeZone); err != nil {
+				log.Fatalf("Failed to load time zone: %s", err)
+			}
+		}
+		offsets := map[string]time.Duration{}
+		if *logOffsets != "" {
+			for _, logOffset := range strings.Split(*logOffsets, ",") {
+				name, offsetStr, ok := strings.Cut(logOffset, "=")
+				if !ok {
+					log.Fatalf("Malformed log offset '%s'; want <log name>=<duration>", logOffset)
+				}
+				offset, err := time.ParseDuration(offsetStr)
+				if err != nil {
+					log.Fatalf("Malformed log offset '%s': %s", logOffset, err)
+				}
+				offsets[name] = offset
+			}
+		}
+		opts = append(opts, service.WithTimestampAdjustments(tz, offsets))
+	}
+	if *correlationIDRegex != "" || *correlationIDField != "" {
+		var pattern *regexp.Regexp
+		if *correlationIDRegex != "" {
+			var err error
+			if pattern, err = regexp.Compile(*correlationIDRegex); err != nil {
+				log.Fatalf("Failed to compile --correlation_id_regex: %s", err)
+			}
+		}
+		opts = append(opts, service.WithCorrelationIDExtraction(pattern, *correlationIDField))
+	}
+	if *fieldRegex != "" {
+		pattern, err := regexp.Compile(*fieldRegex)
+		if err != nil {
+			log.Fatalf("Failed to compile --field_regex: %s", err)
+		}
+		opts = append(opts, service.WithFieldExtraction(pattern))
+	}
+	if *redactionConfig != "" {
+		config, err := redact.LoadConfig(*redactionConfig)
+		if err != nil {
+			log.Fatalf("Failed to load --redaction_config: %s", err)
+		}
+		r, err := config.Redactor()
+		if err != nil {
+			log.Fatalf("Failed to load --redaction_config: %s", err)
+		}
+		if config.Stage == redact.QueryStage {
+			opts = append(opts, service.WithQueryTimeRedaction(r))
+		} else {
+			opts = append(opts, service.WithParseTimeRedaction(r))
+		}
+	}
+	if *corsOrigins != "" {
+		opts = append(opts, service.WithCORS(handlers.CORSConfig{
+			AllowedOrigins:   strings.Split(*corsOrigins, ","),
+			AllowCredentials: true,
+		}))
+	}
+	if *csrf {
+		opts = append(opts, service.WithCSRF(handlers.CSRFConfig{}))
+	}
+	if *resourceAccounting {
+		opts = append(opts, service.WithResourceAccounting())
+	}
+	if *selfTraceRequests > 0 {
+		opts = append(opts, service.WithSelfTrace(*selfTraceRequests))
+	}
+	if *validateResponses {
+		opts = append(opts, service.WithResponseValidation())
+	}
+	if *auditLog != "" {
+		var sink audit.Sink
+		switch {
+		case *auditLog == "stdout":
+			sink = audit.NewWriterSink(os.Stdout)
+		case strings.HasPrefix(*auditLog, "http://") || strings.HasPrefix(*auditLog, "https://"):
+			sink = audit.NewHTTPSink(*auditLog, nil)
+		default:
+			fileSink, err := audit.NewFileSink(*auditLog)
+			if err != nil {
+				log.Fatalf("Failed to create audit log: %s", err)
+			}
+			sink = fileSink
+		}
+		opts = append(opts, service.WithAudit(sink))
+	}
+	if *authHeader != "" {
+		opts = append(opts, service.WithHeaderAuth(*authHeader, strings.Split(*allowedUsers, ",")...))
+	}
+	svc, err := service.New(*resourceRoot, *logRoot, 10, opts...)
 	if err != nil {
 		log.Fatalf("Failed to create LogViz service: %s", err)
 	}
 
 	mux := http.DefaultServeMux
-	service.RegisterHandlers(mux)
-	mux.Handle("/", http.FileServer(http.Dir(*resourceRoot)))
+	svc.RegisterHandlers(mux)
+	// Unknown paths are client-side routes, and load the client.
+	assets := handlers.NewAssetHandler().
+		WithDir("/", *resourceRoot).
+		WithSPAFallback(handlers.NewFileAsset(filepath.Join(*resourceRoot, "index.html"), "text/html"))
+	for path, handler := range assets.HandlersByPath() {
+		mux.HandleFunc(path, handler)
+	}
 	hostname, err := os.Hostname()
 	if err != nil {
 		log.Fatalf("Failed to get hostname: %s", err)
 	}
 
+	scheme := "http"
+	if *tlsCert != "" {
+		scheme = "https"
+	}
 	// Provide OSC 8 (https://en.wikipedia.org/wiki/ANSI_escape_code#OSC) link for
 	// compatible terminals.
-	fmt.Printf("Serving LogViz at \x1B]8;;http://%[1]s:%[2]d\x07http://%[1]s:%[2]d\x1B]8;;\x07", hostname, *port)
-	http.ListenAndServe(
-		fmt.Sprintf(":%d", *port),
-		mux,
-	)
+	fmt.Printf("Serving LogViz at \x1B]8;;%[3]s://%[1]s:%[2]d\x07%[3]s://%[1]s:%[2]d\x1B]8;;\x07\n", hostname, *port, scheme)
+	if err := svc.Serve(context.Background(), mux, service.ServeConfig{
+		Addr:              fmt.Sprintf("%s:%d", *address, *port),
+		CertFile:          *tlsCert,
+		KeyFile:           *tlsKey,
+		ReadTimeout:       *readTimeout,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		ShutdownTimeout:   *shutdownTimeout,
+	}); err != nil {
+		log.Fatalf("Failed to serve LogViz: %s", err)
+	}
 }
@@ -0,0 +1,219 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package service
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	datasource "github.com/google/traceviz/logviz/data_source"
+)
+
+// CachedCollectionStats describes a single cached collection.
+type CachedCollectionStats struct {
+	Name             string    `json:"name"`
+	ApproximateBytes int64     `json:"approximate_bytes"`
+	LoadedAt         time.Time `json:"loaded_at"`
+}
+
+// CacheStats describes the state and history of a collectionCache.
+type CacheStats struct {
+	Collections int    `json:"collections"`
+	TotalBytes  int64  `json:"total_bytes"`
+	MaxEntries  int    `json:"max_entries"`
+	MaxBytes    int64  `json:"max_bytes"`
+	TTL         string `json:"ttl"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	// Cached collections, most recently used first.
+	Contents []*CachedCollectionStats `json:"contents"`
+}
+
+type cacheItem struct {
+	name     string
+	coll     *datasource.Collection
+	bytes    int64
+	loadedAt time.Time
+}
+
+// collectionCache is a least-recently-used cache of Collections bounded by
+// entry count and by the approximate total memory of its Collections.
+// Collections may also expire a fixed time after being loaded.  It is safe
+// for concurrent use.
+type collectionCache struct {
+	// The maximum number of cached collections.
+	maxEntries int
+	// If positive, the maximum approximate total size of cached collections.
+	// The most recently added collection is retained even if it alone exceeds
+	// this budget.
+	maxBytes int64
+	// If positive, the duration after which a cached collection expires.
+	ttl time.Duration
+	now func() time.Time
+	// If non-nil, notified as collections are added and removed.
+	observer datasource.CollectionObserver
+
+	mu sync.Mutex
+	// The collections removed while mu was held, whose removal is reported to
+	// observer, and which are closed, once it is released.
+	removed []*cacheItem
+	// Most recently used at the front.
+	lru                                  *list.List
+	itemsByName                          map[string]*list.Element
+	totalBytes                           int64
+	hits, misses, evictions, expirations uint64
+}
+
+func newCollectionCache(maxEntries int, maxBytes int64, ttl time.Duration) *collectionCache {
+	return &collectionCache{
+		maxEntries:  maxEntries,
+		maxBytes:    maxBytes,
+		ttl:         ttl,
+		now:         time.Now,
+		lru:         list.New(),
+		itemsByName: map[string]*list.Element{},
+	}
+}
+
+func (cc *collectionCache) expired(item *cacheItem) bool {
+	return cc.ttl > 0 && cc.now().Sub(item.loadedAt) >= cc.ttl
+}
+
+// remove removes the provided element; cc.mu must be held.
+func (cc *collectionCache) remove(elem *list.Element) {
+	item := cc.lru.Remove(elem).(*cacheItem)
+	delete(cc.itemsByName, item.name)
+	cc.totalBytes -= item.bytes
+	cc.removed = append(cc.removed, item)
+}
+
+// unlock releases cc.mu, then reports any collections removed while it was
+// held to the receiver's observer, so that the observer may use the cache,
+// and closes them.  Collections still in use by queries are closed once those
+// queries finish.
+func (cc *collectionCache) unlock() {
+	removed := cc.removed
+	cc.removed = nil
+	cc.mu.Unlock()
+	for _, item := range removed {
+		if cc.observer != nil {
+			cc.observer.CollectionEvicted(item.name)
+		}
+		item.coll.Close()
+	}
+}
+
+// get returns the named collection, if it's cached and unexpired.
+func (cc *collectionCache) get(name string) (*datasource.Collection, bool) {
+	cc.mu.Lock()
+	defer cc.unlock()
+	elem, ok := cc.itemsByName[name]
+	if ok && cc.expired(elem.Value.(*cacheItem)) {
+		cc.remove(elem)
+		cc.expirations++
+		ok = false
+	}
+	if !ok {
+		cc.misses++
+		return nil, false
+	}
+	cc.hits++
+	cc.lru.MoveToFront(elem)
+	return elem.Value.(*cacheItem).coll, true
+}
+
+// invalidate removes the named collection, if it's cached, so that it is
+// reloaded when next fetched.
+func (cc *collectionCache) invalidate(name string) {
+	cc.mu.Lock()
+	defer cc.unlock()
+	if elem, ok := cc.itemsByName[name]; ok {
+		cc.remove(elem)
+	}
+}
+
+// add caches the provided collection under the specified name, then evicts
+// expired collections, and least-recently-used collections until the cache is
+// within its bounds.
+func (cc *collectionCache) add(name string, coll *datasource.Collection) {
+	if cc.observer != nil {
+		// Deferred first, so invoked after evictions are reported.
+		defer cc.observer.CollectionLoaded(name, coll)
+	}
+	cc.mu.Lock()
+	defer cc.unlock()
+	if elem, ok := cc.itemsByName[name]; ok {
+		cc.remove(elem)
+	}
+	item := &cacheItem{
+		name:     name,
+		coll:     coll,
+		bytes:    coll.MemoryUsage().TotalBytes(),
+		loadedAt: cc.now(),
+	}
+	cc.itemsByName[name] = cc.lru.PushFront(item)
+	cc.totalBytes += item.bytes
+	for elem := cc.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if cc.expired(elem.Value.(*cacheItem)) {
+			cc.remove(elem)
+			cc.expirations++
+		}
+		elem = prev
+	}
+	for cc.lru.Len() > 1 && (cc.lru.Len() > cc.maxEntries || (cc.maxBytes > 0 && cc.totalBytes > cc.maxBytes)) {
+		cc.remove(cc.lru.Back())
+		cc.evictions++
+	}
+}
+
+// stats returns a snapshot of the receiver's statistics.
+func (cc *collectionCache) stats() *CacheStats {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	ret := &CacheStats{
+		Collections: cc.lru.Len(),
+		TotalBytes:  cc.totalBytes,
+		MaxEntries:  cc.maxEntries,
+		MaxBytes:    cc.maxBytes,
+		TTL:         cc.ttl.String(),
+		Hits:        cc.hits,
+		Misses:      cc.misses,
+		Evictions:   cc.evictions,
+		Expirations: cc.expirations,
+		Contents:    []*CachedCollectionStats{},
+	}
+	for elem := cc.lru.Front(); elem != nil; elem = elem.Next() {
+		item := elem.Value.(*cacheItem)
+		ret.Contents = append(ret.Contents, &CachedCollectionStats{
+			Name:             item.name,
+			ApproximateBytes: item.bytes,
+			LoadedAt:         item.loadedAt,
+		})
+	}
+	return ret
+}
+
+// handleStats serves the receiver's statistics as JSON.
+func (cc *collectionCache) handleStats(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(cc.stats()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	logreader "github.com/google/traceviz/logviz/analysis/log_reader"
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	datasource "github.com/google/traceviz/logviz/data_source"
+)
+
+var cacheStartTime = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// testCollection returns a new Collection with the provided number of
+// entries, all of the same size.
+func testCollection(t *testing.T, entries int) *datasource.Collection {
+	t.Helper()
+	lines := make([]string, entries)
+	for idx := range lines {
+		lines[idx] = fmt.Sprintf("2023/01/01 00:%02d:00.000000 a.cc:10: [I] Entry %04d", idx%60, idx)
+	}
+	lt, err := logtrace.NewLogTrace(logreader.New("log", logreader.ReaderCloser{
+		Reader: bufio.NewReader(strings.NewReader(strings.Join(lines, "\n"))),
+	}, logreader.NewSimpleLogParser()))
+	if err != nil {
+		t.Fatalf("NewLogTrace() yielded unexpected error %s", err)
+	}
+	return datasource.NewCollection(lt)
+}
+
+// testCache returns a new collectionCache with the provided bounds, whose
+// clock is advanced by the returned function.
+func testCache(maxEntries int, maxBytes int64, ttl time.Duration) (*collectionCache, func(time.Duration)) {
+	cc := newCollectionCache(maxEntries, maxBytes, ttl)
+	now := cacheStartTime
+	cc.now = func() time.Time {
+		return now
+	}
+	return cc, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+// testCacheObserver records the collections loaded into, and evicted from, a
+// collectionCache.
+type testCacheObserver struct {
+	events []string
+}
+
+func (tco *testCacheObserver) CollectionLoaded(collectionName string, collection any) {
+	tco.events = append(tco.events, "loaded "+collectionName)
+}
+
+func (tco *testCacheObserver) CollectionEvicted(collectionName string) {
+	tco.events = append(tco.events, "evicted "+collectionName)
+}
+
+// contents returns the names of the receiver's cached collections, most
+// recently used first.
+func contents(cc *collectionCache) []string {
+	ret := []string{}
+	for _, ccs := range cc.stats().Contents {
+		ret = append(ret, ccs.Name)
+	}
+	return ret
+}
+
+func TestCollectionCacheEvictionByCount(t *testing.T) {
+	cc, _ := testCache(2, 0, 0)
+	tco := &testCacheObserver{}
+	cc.observer = tco
+	for _, name := range []string{"a", "b"} {
+		cc.add(name, testCollection(t, 1))
+	}
+	// Using 'a' makes 'b' least recently used.
+	if _, ok := cc.get("a"); !ok {
+		t.Fatalf("get('a') found no collection")
+	}
+	cc.add("c", testCollection(t, 1))
+	if diff := cmp.Diff([]string{"c", "a"}, contents(cc)); diff != "" {
+		t.Errorf("Cache contents diff (-want +got):\n%s", diff)
+	}
+	if _, ok := cc.get("b"); ok {
+		t.Errorf("get('b') found an evicted collection")
+	}
+	wantEvents := []string{"loaded a", "loaded b", "evicted b", "loaded c"}
+	if diff := cmp.Diff(wantEvents, tco.events); diff != "" {
+		t.Errorf("Observed events diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCollectionCacheEvictionByBytes(t *testing.T) {
+	// Measure the size of a collection as the cache does.
+	measure, _ := testCache(1, 0, 0)
+	measure.add("x", testCollection(t, 10))
+	size := measure.stats().TotalBytes
+	if size <= 0 {
+		t.Fatalf("Got collection size %d, wanted a positive size", size)
+	}
+	for _, test := range []struct {
+		description  string
+		maxBytes     int64
+		adds         []string
+		gets         []string
+		wantContents []string
+		wantBytes    int64
+		wantEvicted  uint64
+	}{{
+		description:  "within budget",
+		maxBytes:     3 * size,
+		adds:         []string{"a", "b", "c"},
+		wantContents: []string{"c", "b", "a"},
+		wantBytes:    3 * size,
+	}, {
+		description:  "over budget",
+		maxBytes:     2 * size,
+		adds:         []string{"a", "b", "c"},
+		wantContents: []string{"c", "b"},
+		wantBytes:    2 * size,
+		wantEvicted:  1,
+	}, {
+		description:  "over budget, least recently used evicted",
+		maxBytes:     2*size + size/2,
+		adds:         []string{"a", "b"},
+		gets:         []string{"a"},
+		wantContents: []string{"c", "a"},
+		wantBytes:    2 * size,
+		wantEvicted:  1,
+	}, {
+		description:  "newest retained even if it alone exceeds budget",
+		maxBytes:     size / 2,
+		adds:         []string{"a", "b", "c"},
+		wantContents: []string{"c"},
+		wantBytes:    size,
+		wantEvicted:  2,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			cc, _ := testCache(100, test.maxBytes, 0)
+			for _, name := range test.adds {
+				cc.add(name, testCollection(t, 10))
+			}
+			for _, name := range test.gets {
+				cc.get(name)
+			}
+			if len(test.gets) > 0 {
+				cc.add("c", testCollection(t, 10))
+			}
+			stats := cc.stats()
+			if diff := cmp.Diff(test.wantContents, contents(cc)); diff != "" {
+				t.Errorf("Cache contents diff (-want +got):\n%s", diff)
+			}
+			if stats.TotalBytes != test.wantBytes {
+				t.Errorf("Got total bytes %d, wanted %d", stats.TotalBytes, test.wantBytes)
+			}
+			if stats.Evictions != test.wantEvicted {
+				t.Errorf("Got %d evictions, wanted %d", stats.Evictions, test.wantEvicted)
+			}
+		})
+	}
+}
+
+func TestCollectionCacheTTL(t *testing.T) {
+	cc, advance := testCache(10, 0, time.Minute)
+	tco := &testCacheObserver{}
+	cc.observer = tco
+	cc.add("a", testCollection(t, 1))
+	advance(30 * time.Second)
+	cc.add("b", testCollection(t, 1))
+	if _, ok := cc.get("a"); !ok {
+		t.Errorf("get('a') found no collection before it expired")
+	}
+	// 'a' expires on access.
+	advance(30 * time.Second)
+	if _, ok := cc.get("a"); ok {
+		t.Errorf("get('a') found an expired collection")
+	}
+	// 'b' expires when another collection is added.
+	advance(30 * time.Second)
+	cc.add("c", testCollection(t, 1))
+	if diff := cmp.Diff([]string{"c"}, contents(cc)); diff != "" {
+		t.Errorf("Cache contents diff (-want +got):\n%s", diff)
+	}
+	stats := cc.stats()
+	if stats.Expirations != 2 || stats.Evictions != 0 {
+		t.Errorf("Got %d expirations and %d evictions, wanted 2 and 0", stats.Expirations, stats.Evictions)
+	}
+	wantEvents := []string{"loaded a", "loaded b", "evicted a", "evicted b", "loaded c"}
+	if diff := cmp.Diff(wantEvents, tco.events); diff != "" {
+		t.Errorf("Observed events diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCollectionCacheStats(t *testing.T) {
+	cc, advance := testCache(2, 0, time.Hour)
+	a, b, c := testCollection(t, 1), testCollection(t, 2), testCollection(t, 3)
+	cc.add("a", a)
+	advance(time.Minute)
+	cc.add("b", b)
+	cc.get("a")
+	cc.get("a")
+	cc.get("missing")
+	advance(time.Minute)
+	cc.add("c", c)
+	cc.invalidate("c")
+	cc.get("c")
+	stats := cc.stats()
+	aBytes := stats.Contents[0].ApproximateBytes
+	want := &CacheStats{
+		Collections: 1,
+		TotalBytes:  aBytes,
+		MaxEntries:  2,
+		TTL:         "1h0m0s",
+		Hits:        2,
+		Misses:      2,
+		Evictions:   1,
+		Contents: []*CachedCollectionStats{{
+			Name:             "a",
+			ApproximateBytes: aBytes,
+			LoadedAt:         cacheStartTime,
+		}},
+	}
+	if diff := cmp.Diff(want, stats); diff != "" {
+		t.Errorf("stats() diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+)
+
+// parsedCache persists parsed LogTraces as files in a cache directory, so that
+// logs need not be reparsed when the server restarts.  Cached LogTraces are
+// keyed by their source log's name, size, and modification time, and by the
+// Options with which it is parsed, so a cached LogTrace is not used once its
+// source log or its parsing changes.
+type parsedCache struct {
+	dir string
+}
+
+// key returns the cache key for the log with the specified collection name
+// and file info, parsed with the provided options.
+func (pc *parsedCache) key(collectionName string, info fs.FileInfo, opts logtrace.Options) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s\x00%d\x00%d\x00%s",
+		logtrace.EncodingVersion, collectionName, info.Size(), info.ModTime().UnixNano(), opts)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (pc *parsedCache) path(key string) string {
+	return filepath.Join(pc.dir, key+".ltcache")
+}
+
+// load returns the LogTrace cached under the specified key, or nil if there
+// is none.
+func (pc *parsedCache) load(key string, opts logtrace.Options) (*logtrace.LogTrace, error) {
+	file, err := os.Open(pc.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return logtrace.DecodeLogTrace(bufio.NewReader(file), opts)
+}
+
+// store caches the provided LogTrace under the specified key.  The cache file
+// is written under a temporary name and then renamed, so that concurrent or
+// interrupted stores never leave a partial cache file.
+func (pc *parsedCache) store(key string, lt *logtrace.LogTrace) (err error) {
+	if err := os.MkdirAll(pc.dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(pc.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	w := bufio.NewWriter(file)
+	if err := lt.Encode(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), pc.path(key))
+}
@@ -0,0 +1,103 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ServeConfig configures how Serve serves a Service.
+type ServeConfig struct {
+	// The TCP address to listen on, such as ':7410'.
+	Addr string
+	// If set, the listener to serve on, instead of listening on Addr.  It is
+	// closed when Serve returns.
+	Listener net.Listener
+	// If set, the paths of the TLS certificate and private key files, and the
+	// Service is served over HTTPS.
+	CertFile, KeyFile string
+	// As for the corresponding http.Server fields; zero values impose no
+	// timeout.  WriteTimeout also bounds the lifetime of change notification
+	// streams.
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// If positive, the maximum time to wait for in-flight requests to finish
+	// during shutdown, after which remaining connections, including change
+	// notification streams, are closed.  Otherwise, shutdown waits for all
+	// in-flight requests to finish.
+	ShutdownTimeout time.Duration
+}
+
+// Serve serves the provided handler, typically a ServeMux with the receiver's
+// handlers registered, as specified by the provided ServeConfig, until the
+// provided Context is canceled or the process receives SIGINT or SIGTERM.  It
+// then shuts down gracefully: it stops accepting connections, reports the
+// receiver as not ready, and drains in-flight requests.  Returns nil if
+// shutdown completed gracefully.
+func (s *Service) Serve(ctx context.Context, handler http.Handler, config ServeConfig) error {
+	srv := &http.Server{
+		Addr:              config.Addr,
+		Handler:           handler,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errs := make(chan error, 1)
+	go func() {
+		useTLS := config.CertFile != "" || config.KeyFile != ""
+		switch {
+		case config.Listener != nil && useTLS:
+			errs <- srv.ServeTLS(config.Listener, config.CertFile, config.KeyFile)
+		case config.Listener != nil:
+			errs <- srv.Serve(config.Listener)
+		case useTLS:
+			errs <- srv.ListenAndServeTLS(config.CertFile, config.KeyFile)
+		default:
+			errs <- srv.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	s.draining.Store(true)
+	shutdownCtx := context.Background()
+	if config.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, config.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		srv.Close()
+		return fmt.Errorf("failed to drain in-flight requests: %s", err)
+	}
+	if err := <-errs; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestService returns a new Service serving an empty collection root.
+func newTestService(t *testing.T, opts ...Option) *Service {
+	t.Helper()
+	s, err := New(t.TempDir(), t.TempDir(), 1, opts...)
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	return s
+}
+
+// listen returns a new listener on a free local port.
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	return l
+}
+
+// serve serves the provided handler on the provided Service as specified by
+// the provided ServeConfig until the returned CancelFunc is invoked, sending
+// Serve's result on the returned channel.
+func serve(s *Service, handler http.Handler, config ServeConfig) (context.CancelFunc, <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve(ctx, handler, config)
+	}()
+	return cancel, errs
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1, and
+// its key, into the provided directory, returning their paths and the
+// certificate.
+func writeTestCertificate(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %s", err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %s", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %s", err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestServeTLS(t *testing.T) {
+	s := newTestService(t)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	certFile, keyFile, cert := writeTestCertificate(t, t.TempDir())
+	l := listen(t)
+	cancel, errs := serve(s, mux, ServeConfig{
+		Listener: l,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		},
+	}
+	resp, err := client.Get("https://" + l.Addr().String() + healthzPath)
+	if err != nil {
+		t.Fatalf("HTTPS request yielded unexpected error %s", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Failed to read response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil || strings.TrimSpace(string(body)) != "ok" {
+		t.Errorf("Got status %d, TLS %t, body %q; wanted status 200 over TLS with body 'ok'", resp.StatusCode, resp.TLS != nil, body)
+	}
+	// Plaintext requests to the TLS listener are refused.
+	if resp, err := http.Get("http://" + l.Addr().String() + healthzPath); err == nil {
+		if resp.StatusCode == http.StatusOK {
+			t.Errorf("Plaintext request succeeded, wanted it refused")
+		}
+		resp.Body.Close()
+	}
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("Serve() yielded unexpected error %s", err)
+	}
+}
+
+func TestServeTLSMissingCertificate(t *testing.T) {
+	s := newTestService(t)
+	dir := t.TempDir()
+	cancel, errs := serve(s, http.NewServeMux(), ServeConfig{
+		Listener: listen(t),
+		CertFile: filepath.Join(dir, "missing.pem"),
+		KeyFile:  filepath.Join(dir, "missing.key"),
+	})
+	defer cancel()
+	if err := <-errs; err == nil {
+		t.Errorf("Serve() with a missing certificate yielded no error")
+	}
+}
+
+// blockingHandler is an http.Handler whose requests block until released.
+type blockingHandler struct {
+	started  chan struct{}
+	released chan struct{}
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{
+		started:  make(chan struct{}, 1),
+		released: make(chan struct{}),
+	}
+}
+
+func (bh *blockingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	bh.started <- struct{}{}
+	<-bh.released
+	io.WriteString(w, "done")
+}
+
+func TestServeGracefulShutdown(t *testing.T) {
+	for _, test := range []struct {
+		description     string
+		shutdownTimeout time.Duration
+		// If true, the in-flight request is released only after Serve
+		// returns.
+		stuck        bool
+		wantBody     string
+		wantServeErr bool
+	}{{
+		description: "drains in-flight requests",
+		wantBody:    "done",
+	}, {
+		description:     "drains in-flight requests within timeout",
+		shutdownTimeout: time.Minute,
+		wantBody:        "done",
+	}, {
+		description:     "abandons stuck requests after timeout",
+		shutdownTimeout: 50 * time.Millisecond,
+		stuck:           true,
+		wantServeErr:    true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			s := newTestService(t)
+			bh := newBlockingHandler()
+			mux := http.NewServeMux()
+			s.RegisterHandlers(mux)
+			mux.Handle("/slow", bh)
+			l := listen(t)
+			cancel, errs := serve(s, mux, ServeConfig{
+				Listener:        l,
+				ShutdownTimeout: test.shutdownTimeout,
+			})
+			type result struct {
+				body string
+				err  error
+			}
+			results := make(chan result, 1)
+			go func() {
+				resp, err := http.Get("http://" + l.Addr().String() + "/slow")
+				if err != nil {
+					results <- result{err: err}
+					return
+				}
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				results <- result{body: string(body), err: err}
+			}()
+			<-bh.started
+			if s.draining.Load() {
+				t.Fatalf("Service is draining before shutdown")
+			}
+			cancel()
+			// Shutdown begins, but waits for the in-flight request.
+			for !s.draining.Load() {
+				time.Sleep(time.Millisecond)
+			}
+			var serveErr error
+			if test.stuck {
+				serveErr = <-errs
+				close(bh.released)
+			} else {
+				select {
+				case err := <-errs:
+					t.Fatalf("Serve() returned (error %v) with a request in flight", err)
+				case <-time.After(50 * time.Millisecond):
+				}
+				close(bh.released)
+				serveErr = <-errs
+			}
+			if gotErr := serveErr != nil; gotErr != test.wantServeErr {
+				t.Errorf("Serve() yielded error %v, wanted error: %t", serveErr, test.wantServeErr)
+			}
+			res := <-results
+			if test.stuck {
+				if res.err == nil && res.body == "done" {
+					t.Errorf("Stuck request completed, wanted it abandoned")
+				}
+				return
+			}
+			if res.err != nil {
+				t.Fatalf("In-flight request yielded unexpected error %s", res.err)
+			}
+			if res.body != test.wantBody {
+				t.Errorf("In-flight request got body %q, wanted %q", res.body, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestReadyzWhileDraining(t *testing.T) {
+	s := newTestService(t)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	for _, test := range []struct {
+		draining   bool
+		wantStatus int
+	}{{
+		wantStatus: http.StatusOK,
+	}, {
+		draining:   true,
+		wantStatus: http.StatusServiceUnavailable,
+	}} {
+		s.draining.Store(test.draining)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, readyzPath, nil))
+		if rec.Code != test.wantStatus {
+			t.Errorf("While draining: %t, got /readyz status %d, wanted %d", test.draining, rec.Code, test.wantStatus)
+		}
+	}
+}
@@ -17,47 +17,362 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
 	"path"
+	"regexp"
+	"sync/atomic"
+	"time"
 
 	logreader "github.com/google/traceviz/logviz/analysis/log_reader"
 	logtrace "github.com/google/traceviz/logviz/analysis/log_trace"
+	"github.com/google/traceviz/logviz/annotations"
+	"github.com/google/traceviz/logviz/audit"
 	datasource "github.com/google/traceviz/logviz/data_source"
+	"github.com/google/traceviz/logviz/logger"
+	"github.com/google/traceviz/logviz/presets"
 	"github.com/google/traceviz/server/go/handlers"
 	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
-	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// Option configures a Service.
+type Option func(opts *options)
+
+type options struct {
+	logTraceOpts   logtrace.Options
+	parsedCacheDir string
+	cacheMaxBytes  int64
+	cacheTTL       time.Duration
+	// If authHeader is non-empty, requests are authenticated by it, and only
+	// allowedPrincipals may query.
+	authHeader        string
+	allowedPrincipals []string
+	limits            handlers.Limits
+	requestLimits     handlers.RequestLimits
+	// If non-nil, the CORS and CSRF configurations for data queries.
+	cors *handlers.CORSConfig
+	csrf *handlers.CSRFConfig
+	// If true, responses are validated before they are sent.
+	validateResponses bool
+	// If positive, the interval at which watched collections' files are
+	// checked for changes.
+	changePollInterval time.Duration
+	// If true, the resources used by each data query are recorded.
+	resourceAccounting bool
+	// If positive, the number of most recent data queries whose handling is
+	// traced.
+	selfTraceRequests int
+	// If non-empty, the directory in which named filter presets are stored.
+	presetsDir string
+	// If non-empty, the directory in which annotations are stored.
+	annotationsDir string
+	// If non-nil, the Sink to which a record of each data query is written.
+	auditSink audit.Sink
+	// If non-nil, masks sensitive content in entries as queries are answered.
+	queryRedactor logtrace.Redactor
+	// If non-nil, the filesystem holding client assets compiled into the
+	// binary.
+	embeddedAssets fs.FS
+	// The Logger from which each data query's Logger is derived.
+	logger *slog.Logger
+}
+
+func defaultOptions() *options {
+	return &options{
+		logTraceOpts: logtrace.Options{
+			IndexBucketWidth: time.Second,
+		},
+		logger: slog.Default(),
+	}
+}
+
+// WithOnDiskThreshold specifies that collections with more than the specified
+// number of entries should be held in memory-mapped files created in the
+// specified directory, rather than in memory.  If dir is empty, the default
+// directory for temporary files is used.
+func WithOnDiskThreshold(dir string, threshold int) Option {
+	return func(opts *options) {
+		opts.logTraceOpts.OnDiskDir = dir
+		opts.logTraceOpts.OnDiskThreshold = threshold
+	}
+}
+
+// WithTimestampAdjustments specifies that, as collections are loaded, their
+// entries' wall-clock timestamps should be reinterpreted in the provided
+// location, if it is non-nil, and then shifted by the offset, if any, given
+// for their collection's name, to correct clock skew between machines.
+func WithTimestampAdjustments(tz *time.Location, logOffsets map[string]time.Duration) Option {
+	return func(opts *options) {
+		opts.logTraceOpts.TimeZone = tz
+		opts.logTraceOpts.LogOffsets = logOffsets
+	}
+}
+
+// WithCorrelationIDExtraction specifies that, as collections are loaded,
+// each entry's correlation ID should be extracted from its message by the
+// provided pattern, if it is non-nil, or else from the value of the specified
+// structured field, if it is non-empty.  The pattern's first capture group, if
+// it has one, is the ID.
+func WithCorrelationIDExtraction(pattern *regexp.Regexp, field string) Option {
+	return func(opts *options) {
+		opts.logTraceOpts.CorrelationIDPattern = pattern
+		opts.logTraceOpts.CorrelationIDField = field
+	}
+}
+
+// WithFieldExtraction specifies that, as collections are loaded, derived
+// fields should be extracted from each entry's message by the provided
+// patterns' named capture groups.  Derived fields may then be targeted by
+// aggregating queries, with the aggregation 'field:<field name>'.
+func WithFieldExtraction(patterns ...*regexp.Regexp) Option {
+	return func(opts *options) {
+		opts.logTraceOpts.FieldPatterns = append(opts.logTraceOpts.FieldPatterns, patterns...)
+	}
+}
+
+// WithParseTimeRedaction specifies that, as collections are loaded, sensitive
+// content in each entry's message and derived fields should be masked by the
+// provided Redactor, so that it is never held or cached.
+func WithParseTimeRedaction(r logtrace.Redactor) Option {
+	return func(opts *options) {
+		opts.logTraceOpts.Redactor = r
+	}
+}
+
+// WithQueryTimeRedaction specifies that sensitive content in each entry's
+// message and derived fields should be masked by the provided Redactor as
+// queries are answered.  Collections are held, and cached, unredacted.
+func WithQueryTimeRedaction(r logtrace.Redactor) Option {
+	return func(opts *options) {
+		opts.queryRedactor = r
+	}
+}
+
+// WithParsedCacheDir specifies that parsed collections should be cached in,
+// and loaded from, the specified directory.
+func WithParsedCacheDir(dir string) Option {
+	return func(opts *options) {
+		opts.parsedCacheDir = dir
+	}
+}
+
+// WithCacheMaxBytes bounds the approximate total memory of cached
+// collections, as estimated by their LogTraces' MemoryUsage.  Collections
+// held on disk report no usage, and so are bounded only by entry count.  If
+// maxBytes is not positive, the cache is bounded only by entry count.
+func WithCacheMaxBytes(maxBytes int64) Option {
+	return func(opts *options) {
+		opts.cacheMaxBytes = maxBytes
+	}
+}
+
+// WithCacheTTL specifies that cached collections should expire the specified
+// duration after being loaded.  If ttl is not positive, collections do not
+// expire.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.cacheTTL = ttl
+	}
+}
+
+// WithHeaderAuth specifies that requests should be authenticated by the
+// specified header, as set by a trusted authenticating proxy, and that only
+// the specified principals may query, or fetch the status, metrics, and admin
+// endpoints.  By default, all requests are allowed.
+func WithHeaderAuth(header string, allowedPrincipals ...string) Option {
+	return func(opts *options) {
+		opts.authHeader = header
+		opts.allowedPrincipals = allowedPrincipals
+	}
+}
+
+// WithLimits specifies rate and concurrency limits for data queries.  By
+// default, queries are not limited.
+func WithLimits(limits handlers.Limits) Option {
+	return func(opts *options) {
+		opts.limits = limits
+	}
+}
+
+// WithRequestLimits specifies size and shape limits for data query requests.
+// By default, requests are not limited.
+func WithRequestLimits(limits handlers.RequestLimits) Option {
+	return func(opts *options) {
+		opts.requestLimits = limits
+	}
+}
+
+// WithEmbeddedAssets specifies that the client assets should be served from
+// the provided filesystem, such as an embed.FS, rather than from the asset
+// root, so that the Service may be shipped as a single self-contained binary.
+// Each asset is also served under a fingerprinted, indefinitely cacheable
+// path, as by handlers.EmbeddedAssets.
+func WithEmbeddedAssets(fsys fs.FS) Option {
+	return func(opts *options) {
+		opts.embeddedAssets = fsys
+	}
+}
+
+// WithLogger specifies the Logger to which data queries, and any logs emitted
+// while handling them, are logged.  Each data query's logs carry its request
+// ID, query names, and collection name, as by logger.Observer.  By default,
+// the default slog Logger is used.
+func WithLogger(l *slog.Logger) Option {
+	return func(opts *options) {
+		opts.logger = l
+	}
+}
+
+// WithCORS specifies that cross-origin data queries should be permitted per
+// the provided configuration, so that the frontend may be served from a
+// different origin.
+func WithCORS(config handlers.CORSConfig) Option {
+	return func(opts *options) {
+		opts.cors = &config
+	}
+}
+
+// WithCSRF specifies that data queries should be protected against
+// Cross-Site Request Forgery per the provided configuration.
+func WithCSRF(config handlers.CSRFConfig) Option {
+	return func(opts *options) {
+		opts.csrf = &config
+	}
+}
+
+// WithResponseValidation specifies that each data query response should be
+// checked against the well-known data models before it is sent, and replaced
+// with an error if it is malformed.  This is intended for debugging.
+func WithResponseValidation() Option {
+	return func(opts *options) {
+		opts.validateResponses = true
+	}
+}
+
+// WithChangePolling specifies that clients may watch collections for
+// changes, and that each watched collection's file should be checked for
+// changes at the specified interval.  Changed collections are reloaded when
+// next queried.  By default, changes are not observed.
+func WithChangePolling(interval time.Duration) Option {
+	return func(opts *options) {
+		opts.changePollInterval = interval
+	}
+}
+
+// WithResourceAccounting specifies that the resources used to produce each
+// data series should be recorded, by query and by collection, and served at
+// /admin/resource_stats.
+func WithResourceAccounting() Option {
+	return func(opts *options) {
+		opts.resourceAccounting = true
+	}
+}
+
+// WithSelfTrace specifies that the handling of the specified number of most
+// recent data queries should be traced, and served as the query
+// 'traceviz.self_trace'.
+func WithSelfTrace(requests int) Option {
+	return func(opts *options) {
+		opts.selfTraceRequests = requests
+	}
+}
+
+// WithPresetsDir specifies that named filter presets should be stored in the
+// specified directory, and saved, listed, and loaded through the queries
+// 'presets.save', 'presets.list', and 'presets.load'.
+func WithPresetsDir(dir string) Option {
+	return func(opts *options) {
+		opts.presetsDir = dir
+	}
+}
+
+// WithAnnotationsDir specifies that annotations should be stored in the
+// specified directory, created, listed, and deleted through the queries
+// 'annotations.create', 'annotations.list', and 'annotations.delete', and
+// marked in timeseries and raw entries responses.
+func WithAnnotationsDir(dir string) Option {
+	return func(opts *options) {
+		opts.annotationsDir = dir
+	}
+}
+
+// WithAudit specifies that a record of each data query, naming the principal
+// making it, the collections it queried, and when, should be written to the
+// provided Sink.
+func WithAudit(sink audit.Sink) Option {
+	return func(opts *options) {
+		opts.auditSink = sink
+	}
+}
+
+const (
+	// The path on which collection cache statistics are served.
+	cacheStatsPath = "/admin/cache_stats"
+	// The path on which resource accounting statistics are served.
+	resourceStatsPath = "/admin/resource_stats"
 )
 
 type collectionFetcher struct {
 	collectionRoot string
-	lru            *simplelru.LRU
+	cache          *collectionCache
+	logTraceOpts   logtrace.Options
+	// If non-nil, a persistent cache of parsed collections.
+	parsedCache *parsedCache
+	// If positive, the interval at which watched collections are polled for
+	// changes.
+	changePollInterval time.Duration
 }
 
-func newCollectionFetcher(collectionRoot string, cap int) (*collectionFetcher, error) {
-	lru, err := simplelru.NewLRU(cap, nil /* no onEvict policy */)
-	if err != nil {
-		return nil, err
+func newCollectionFetcher(collectionRoot string, cap int, opts *options) (*collectionFetcher, error) {
+	if cap <= 0 {
+		return nil, fmt.Errorf("collection cache capacity must be positive")
 	}
-	return &collectionFetcher{
-		collectionRoot: collectionRoot,
-		lru:            lru,
-	}, nil
+	cf := &collectionFetcher{
+		collectionRoot:     collectionRoot,
+		cache:              newCollectionCache(cap, opts.cacheMaxBytes, opts.cacheTTL),
+		logTraceOpts:       opts.logTraceOpts,
+		changePollInterval: opts.changePollInterval,
+	}
+	if opts.parsedCacheDir != "" {
+		cf.parsedCache = &parsedCache{
+			dir: opts.parsedCacheDir,
+		}
+	}
+	return cf, nil
 }
 
 func (cf *collectionFetcher) Fetch(ctx context.Context, collectionName string) (*datasource.Collection, error) {
-	collIf, ok := cf.lru.Get(collectionName)
-	if ok {
-		coll, ok := collIf.(*datasource.Collection)
-		if !ok {
-			return nil, fmt.Errorf("fetched collection wasn't a LogTrace")
-		}
+	if coll, ok := cf.cache.get(collectionName); ok {
 		return coll, nil
 	}
 	file, err := os.Open(path.Join(cf.collectionRoot, collectionName))
 	if err != nil {
 		return nil, err
 	}
+	// If the parsed collection is cached, use that.  Failures to load from or
+	// store to the cache are not fatal; the log is simply parsed.
+	var cacheKey string
+	if cf.parsedCache != nil {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		cacheKey = cf.parsedCache.key(collectionName, info, cf.logTraceOpts)
+		lt, err := cf.parsedCache.load(cacheKey, cf.logTraceOpts)
+		if err != nil {
+			logger.FromContext(ctx).Warn("failed to load cached collection", "collection", collectionName, "error", err)
+		}
+		if lt != nil {
+			file.Close()
+			logLoaded(ctx, collectionName, lt)
+			coll := datasource.NewCollection(lt)
+			cf.cache.add(collectionName, coll)
+			return coll, nil
+		}
+	}
 	// The TextLogReader takes ownership of the file.
 	lr := logreader.New(
 		collectionName,
@@ -67,33 +382,140 @@ func (cf *collectionFetcher) Fetch(ctx context.Context, collectionName string) (
 		},
 		&logreader.CockroachDBLogParser{},
 	)
-	lt, err := logtrace.NewLogTrace(lr)
+	lt, err := logtrace.NewLogTraceWithOptions(cf.logTraceOpts, lr)
 	if err != nil {
 		return nil, err
 	}
+	if cf.parsedCache != nil {
+		if err := cf.parsedCache.store(cacheKey, lt); err != nil {
+			logger.FromContext(ctx).Warn("failed to cache collection", "collection", collectionName, "error", err)
+		}
+	}
+	logLoaded(ctx, collectionName, lt)
 	coll := datasource.NewCollection(lt)
-	cf.lru.Add(collectionName, coll)
+	cf.cache.add(collectionName, coll)
 	return coll, nil
 }
 
+// logLoaded logs the size and memory footprint of a newly-loaded collection.
+func logLoaded(ctx context.Context, collectionName string, lt *logtrace.LogTrace) {
+	usage := lt.MemoryUsage()
+	logger.FromContext(ctx).Info("loaded collection",
+		"collection", collectionName,
+		"entries", lt.EntryCount(),
+		"memory_bytes", usage.TotalBytes(),
+		"shared_message_bytes", usage.SharedMessageBytes,
+	)
+}
+
+// Changed returns a channel that is closed when the named collection's file
+// is next modified, per polling at the receiver's change poll interval, or nil
+// if the receiver does not poll for changes.
+func (cf *collectionFetcher) Changed(ctx context.Context, collectionName string) (<-chan struct{}, error) {
+	if cf.changePollInterval <= 0 {
+		return nil, nil
+	}
+	filename := path.Join(cf.collectionRoot, collectionName)
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cf.changePollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			cur, err := os.Stat(filename)
+			if err == nil && cur.ModTime().Equal(info.ModTime()) && cur.Size() == info.Size() {
+				continue
+			}
+			cf.cache.invalidate(collectionName)
+			close(ret)
+			return
+		}
+	}()
+	return ret, nil
+}
+
 type Service struct {
-	queryHandler handlers.QueryHandler
-	assetHandler *handlers.AssetHandler
+	queryHandler   handlers.QueryHandler
+	assetHandler   *handlers.AssetHandler
+	embeddedAssets *handlers.EmbeddedAssets
+	cache          *collectionCache
+	queryStats     *queryStats
+	metrics        *handlers.MetricsObserver
+	csrf           *handlers.CSRFProtection
+	cors           handlers.WrapFunc
+	resourceStats  handlers.HandlerFunc
+	// If non-nil, applies the query handler's authentication and
+	// authorization to the status, metrics, and admin endpoints.
+	requireAuth    handlers.WrapFunc
+	collectionRoot string
+	buildInfo      *BuildInfo
+	startTime      time.Time
+	// Set when Serve begins shutting down.
+	draining atomic.Bool
 }
 
-func New(assetRoot, collectionRoot string, cap int) (*Service, error) {
-	cf, err := newCollectionFetcher(collectionRoot, cap)
+func New(assetRoot, collectionRoot string, cap int, opts ...Option) (*Service, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	cf, err := newCollectionFetcher(collectionRoot, cap, o)
 	if err != nil {
 		return nil, err
 	}
-	ds, err := datasource.New(10, cf)
+	// The collection fetcher does its own caching, so the DataSource needn't.
+	ds, err := datasource.New(0, cf)
 	if err != nil {
 		return nil, err
 	}
+	if o.queryRedactor != nil {
+		ds.WithRedactor(o.queryRedactor)
+	}
+	var annotationStore *annotations.Store
+	if o.annotationsDir != "" {
+		if annotationStore, err = annotations.NewStore(o.annotationsDir); err != nil {
+			return nil, err
+		}
+		ds.WithAnnotations(annotationStore)
+	}
 	qd, err := querydispatcher.New(ds)
 	if err != nil {
 		return nil, err
 	}
+	if annotationStore != nil {
+		if _, err := qd.WithDataSource(annotations.NewDataSource(annotationStore)); err != nil {
+			return nil, err
+		}
+	}
+	if o.presetsDir != "" {
+		store, err := presets.NewStore(o.presetsDir)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := qd.WithDataSource(presets.NewDataSource(store)); err != nil {
+			return nil, err
+		}
+	}
+	// Data sources track collections as they enter and leave the cache.
+	cf.cache.observer = qd
+	var resourceStats handlers.HandlerFunc
+	if o.resourceAccounting {
+		qd.WithResourceAccounting(datasource.CollectionNameKey)
+		resourceStats = handlers.ResourceStatsHandler(qd)
+	}
+	if o.selfTraceRequests > 0 {
+		if _, err := qd.WithSelfTrace(o.selfTraceRequests); err != nil {
+			return nil, err
+		}
+	}
 	assetHandler := handlers.NewAssetHandler()
 	addFileAsset := func(resourceName, resourceType, filename string) {
 		assetHandler.With(
@@ -110,9 +532,62 @@ func New(assetRoot, collectionRoot string, cap int) (*Service, error) {
 	addFileAsset("polyfills.js", "application/javascript", "polyfills.js")
 	addFileAsset("runtime.js", "application/javascript", "runtime.js")
 	addFileAsset("/favicon.ico", "image/x-icon", "favicon.ico")
+	var embeddedAssets *handlers.EmbeddedAssets
+	if o.embeddedAssets != nil {
+		if embeddedAssets, err = handlers.NewEmbeddedAssets(o.embeddedAssets, "/"); err != nil {
+			return nil, err
+		}
+	}
+	qs := newQueryStats()
+	queryHandler := handlers.NewQueryHandler(qd)
+	queryHandler.Wrap(handlers.RecoverPanics, qs.wrap)
+	metrics := handlers.NewMetricsObserver(qd)
+	queryHandler.Observe(metrics, logger.NewObserver(o.logger, datasource.CollectionNameKey))
+	if o.auditSink != nil {
+		queryHandler.Observe(audit.NewObserver(o.auditSink, datasource.CollectionNameKey))
+	}
+	var requireAuth handlers.WrapFunc
+	if o.authHeader != "" {
+		headerAuth := handlers.NewHeaderAuth(o.authHeader, o.allowedPrincipals...)
+		queryHandler.Auth(headerAuth, headerAuth)
+		requireAuth = handlers.RequireAuth(headerAuth, headerAuth)
+	} else {
+		queryHandler.Auth(nil, handlers.AllowAll())
+	}
+	queryHandler.Limit(o.limits)
+	queryHandler.LimitRequests(o.requestLimits)
+	if o.validateResponses {
+		queryHandler.Validate()
+	}
+	var csrf *handlers.CSRFProtection
+	if o.csrf != nil {
+		csrf = handlers.NewCSRFProtection(*o.csrf)
+		queryHandler.Wrap(csrf.Wrap)
+	}
+	var cors handlers.WrapFunc
+	if o.cors != nil {
+		corsConfig := *o.cors
+		if csrf != nil {
+			corsConfig.AllowedHeaders = append(corsConfig.AllowedHeaders, csrf.HeaderName())
+		}
+		cors = handlers.CORS(corsConfig)
+		// CORS must be the last wrapper, to answer preflight requests.
+		queryHandler.Wrap(cors)
+	}
 	return &Service{
-		queryHandler: handlers.NewQueryHandler(qd),
-		assetHandler: assetHandler,
+		queryHandler:   queryHandler,
+		assetHandler:   assetHandler,
+		embeddedAssets: embeddedAssets,
+		cache:          cf.cache,
+		queryStats:     qs,
+		metrics:        metrics,
+		csrf:           csrf,
+		cors:           cors,
+		resourceStats:  resourceStats,
+		requireAuth:    requireAuth,
+		collectionRoot: collectionRoot,
+		buildInfo:      buildInfo(),
+		startTime:      time.Now(),
 	}, nil
 }
 
@@ -120,4 +595,37 @@ func (s *Service) RegisterHandlers(mux *http.ServeMux) {
 	for path, handler := range s.queryHandler.HandlersByPath() {
 		mux.HandleFunc(path, handler)
 	}
+	if s.embeddedAssets != nil {
+		for path, handler := range s.embeddedAssets.HandlersByPath() {
+			mux.HandleFunc(path, handler)
+		}
+	}
+	// Health and readiness checks reveal nothing, and are needed by load
+	// balancers and orchestrators, so are unauthenticated; the rest describe
+	// the queries and collections being served.
+	mux.HandleFunc(healthzPath, s.handleHealthz)
+	mux.HandleFunc(readyzPath, s.handleReadyz)
+	mux.HandleFunc(cacheStatsPath, s.withAuth(s.cache.handleStats))
+	mux.HandleFunc(statuszPath, s.withAuth(s.handleStatusz))
+	mux.HandleFunc(metricsPath, s.withAuth(s.metrics.ServeHTTP))
+	if s.resourceStats != nil {
+		mux.HandleFunc(resourceStatsPath, s.withAuth(s.resourceStats))
+	}
+	if s.csrf != nil {
+		for path, handler := range s.csrf.HandlersByPath() {
+			if s.cors != nil {
+				handler = s.cors(handler)
+			}
+			mux.HandleFunc(path, handler)
+		}
+	}
+}
+
+// withAuth returns the provided HandlerFunc, requiring the receiver's
+// authentication and authorization if any is configured.
+func (s *Service) withAuth(hf handlers.HandlerFunc) handlers.HandlerFunc {
+	if s.requireAuth == nil {
+		return hf
+	}
+	return s.requireAuth(hf)
 }
@@ -0,0 +1,196 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/google/traceviz/server/go/handlers"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+	statuszPath = "/statusz"
+	metricsPath = "/metrics"
+
+	// The number of most recent queries whose latencies are reported.
+	recentQueryCount = 100
+)
+
+// QueryRecord describes a single handled data query.
+type QueryRecord struct {
+	Start      time.Time `json:"start"`
+	DurationMs float64   `json:"duration_ms"`
+	StatusCode int       `json:"status_code"`
+}
+
+// queryStats tracks in-flight and recently-handled data queries.  It is safe
+// for concurrent use.
+type queryStats struct {
+	mu       sync.Mutex
+	inFlight int
+	handled  uint64
+	// A ring buffer of the most recent queries; next is the position of the
+	// next record.
+	recent []*QueryRecord
+	next   int
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{
+		recent: make([]*QueryRecord, 0, recentQueryCount),
+	}
+}
+
+// statusRecorder is an http.ResponseWriter recording the status code written
+// to it.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	sr.statusCode = statusCode
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, so that an
+// http.ResponseController may reach it, e.g. to flush streamed responses.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// wrap is a handlers.WrapFunc tracking the queries handled by the wrapped
+// HandlerFunc.
+func (qs *queryStats) wrap(hf handlers.HandlerFunc) handlers.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		qs.mu.Lock()
+		qs.inFlight++
+		qs.mu.Unlock()
+		sr := &statusRecorder{
+			ResponseWriter: w,
+			statusCode:     http.StatusOK,
+		}
+		defer func() {
+			qs.record(&QueryRecord{
+				Start:      start,
+				DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+				StatusCode: sr.statusCode,
+			})
+		}()
+		hf(sr, req)
+	}
+}
+
+func (qs *queryStats) record(qr *QueryRecord) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	qs.inFlight--
+	qs.handled++
+	if len(qs.recent) < cap(qs.recent) {
+		qs.recent = append(qs.recent, qr)
+	} else {
+		qs.recent[qs.next] = qr
+	}
+	qs.next = (qs.next + 1) % cap(qs.recent)
+}
+
+// snapshot returns the in-flight and handled query counts, and the recent
+// queries, most recent first.
+func (qs *queryStats) snapshot() (inFlight int, handled uint64, recent []*QueryRecord) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	recent = make([]*QueryRecord, 0, len(qs.recent))
+	for idx := 1; idx <= len(qs.recent); idx++ {
+		recent = append(recent, qs.recent[(qs.next-idx+len(qs.recent))%len(qs.recent)])
+	}
+	return qs.inFlight, qs.handled, recent
+}
+
+// BuildInfo describes the build of the running server.
+type BuildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings"`
+}
+
+func buildInfo() *BuildInfo {
+	ret := &BuildInfo{
+		Settings: map[string]string{},
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ret
+	}
+	ret.GoVersion = bi.GoVersion
+	ret.Path = bi.Main.Path
+	ret.Version = bi.Main.Version
+	for _, setting := range bi.Settings {
+		ret.Settings[setting.Key] = setting.Value
+	}
+	return ret
+}
+
+// Status describes the state of a running Service.
+type Status struct {
+	Build          *BuildInfo     `json:"build"`
+	StartTime      time.Time      `json:"start_time"`
+	Uptime         string         `json:"uptime"`
+	Cache          *CacheStats    `json:"cache"`
+	InFlight       int            `json:"in_flight_queries"`
+	QueriesHandled uint64         `json:"queries_handled"`
+	RecentQueries  []*QueryRecord `json:"recent_queries"`
+}
+
+func (s *Service) handleHealthz(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether the Service can serve collections: it is
+// ready once its collection root is accessible, until it begins shutting
+// down.
+func (s *Service) handleReadyz(w http.ResponseWriter, req *http.Request) {
+	if s.draining.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if _, err := os.Stat(s.collectionRoot); err != nil {
+		http.Error(w, "collection root is inaccessible: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Service) handleStatusz(w http.ResponseWriter, req *http.Request) {
+	status := &Status{
+		Build:     s.buildInfo,
+		StartTime: s.startTime,
+		Uptime:    time.Since(s.startTime).String(),
+		Cache:     s.cache.stats(),
+	}
+	status.InFlight, status.QueriesHandled, status.RecentQueries = s.queryStats.snapshot()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStatusEndpointAuth(t *testing.T) {
+	const header = "X-Test-User"
+	open := newTestService(t)
+	authed := newTestService(t, WithHeaderAuth(header, "alice"), WithResourceAccounting())
+	for _, test := range []struct {
+		description string
+		s           *Service
+		path        string
+		user        string
+		wantStatus  int
+	}{{
+		description: "healthz without auth",
+		s:           open,
+		path:        healthzPath,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "statusz without auth",
+		s:           open,
+		path:        statuszPath,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "metrics without auth",
+		s:           open,
+		path:        metricsPath,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "cache stats without auth",
+		s:           open,
+		path:        cacheStatsPath,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "healthz is unauthenticated",
+		s:           authed,
+		path:        healthzPath,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "readyz is unauthenticated",
+		s:           authed,
+		path:        readyzPath,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "statusz unauthenticated",
+		s:           authed,
+		path:        statuszPath,
+		wantStatus:  http.StatusUnauthorized,
+	}, {
+		description: "statusz unauthorized",
+		s:           authed,
+		path:        statuszPath,
+		user:        "mallory",
+		wantStatus:  http.StatusForbidden,
+	}, {
+		description: "statusz authorized",
+		s:           authed,
+		path:        statuszPath,
+		user:        "alice",
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "metrics unauthenticated",
+		s:           authed,
+		path:        metricsPath,
+		wantStatus:  http.StatusUnauthorized,
+	}, {
+		description: "metrics authorized",
+		s:           authed,
+		path:        metricsPath,
+		user:        "alice",
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "cache stats unauthorized",
+		s:           authed,
+		path:        cacheStatsPath,
+		user:        "mallory",
+		wantStatus:  http.StatusForbidden,
+	}, {
+		description: "cache stats authorized",
+		s:           authed,
+		path:        cacheStatsPath,
+		user:        "alice",
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "resource stats unauthenticated",
+		s:           authed,
+		path:        resourceStatsPath,
+		wantStatus:  http.StatusUnauthorized,
+	}, {
+		description: "resource stats authorized",
+		s:           authed,
+		path:        resourceStatsPath,
+		user:        "alice",
+		wantStatus:  http.StatusOK,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			mux := http.NewServeMux()
+			test.s.RegisterHandlers(mux)
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.user != "" {
+				req.Header.Set(header, test.user)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Errorf("Got status %d, wanted %d (body %q)", rec.Code, test.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadyzInaccessibleCollectionRoot(t *testing.T) {
+	root := t.TempDir()
+	s, err := New(t.TempDir(), filepath.Join(root, "collections"), 1)
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	for _, test := range []struct {
+		create     bool
+		wantStatus int
+	}{{
+		wantStatus: http.StatusServiceUnavailable,
+	}, {
+		create:     true,
+		wantStatus: http.StatusOK,
+	}} {
+		if test.create {
+			if err := os.Mkdir(filepath.Join(root, "collections"), 0o755); err != nil {
+				t.Fatalf("Failed to create collection root: %s", err)
+			}
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, readyzPath, nil))
+		if rec.Code != test.wantStatus {
+			t.Errorf("With collection root created: %t, got /readyz status %d, wanted %d", test.create, rec.Code, test.wantStatus)
+		}
+	}
+}
+
+func TestStatusz(t *testing.T) {
+	s := newTestService(t)
+	s.cache.add("a", testCollection(t, 1))
+	// Handle a query, and observe the status while another is in flight.
+	handled := s.queryStats.wrap(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handled(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/GetData", nil))
+	var status *Status
+	inFlight := s.queryStats.wrap(func(w http.ResponseWriter, req *http.Request) {
+		rec := httptest.NewRecorder()
+		s.handleStatusz(rec, httptest.NewRequest(http.MethodGet, statuszPath, nil))
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Got Content-Type '%s', wanted 'application/json'", got)
+		}
+		status = &Status{}
+		if err := json.Unmarshal(rec.Body.Bytes(), status); err != nil {
+			t.Fatalf("Failed to unmarshal Status: %s", err)
+		}
+	})
+	inFlight(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/GetData", nil))
+	if status.InFlight != 1 || status.QueriesHandled != 1 {
+		t.Errorf("Got %d in-flight and %d handled queries, wanted 1 and 1", status.InFlight, status.QueriesHandled)
+	}
+	if len(status.RecentQueries) != 1 || status.RecentQueries[0].StatusCode != http.StatusTeapot {
+		t.Errorf("Got recent queries %v, wanted one with status %d", status.RecentQueries, http.StatusTeapot)
+	}
+	if status.Cache == nil || status.Cache.Collections != 1 {
+		t.Errorf("Got cache stats %v, wanted one cached collection", status.Cache)
+	}
+	if status.StartTime.IsZero() || status.Uptime == "" {
+		t.Errorf("Got start time %v and uptime '%s', wanted both set", status.StartTime, status.Uptime)
+	}
+}
+
+func TestQueryStatsRecent(t *testing.T) {
+	qs := newQueryStats()
+	var want []*QueryRecord
+	for idx := 0; idx < recentQueryCount+recentQueryCount/2; idx++ {
+		qr := &QueryRecord{
+			Start:      cacheStartTime.Add(time.Duration(idx) * time.Second),
+			StatusCode: http.StatusOK,
+		}
+		qs.mu.Lock()
+		qs.inFlight++
+		qs.mu.Unlock()
+		qs.record(qr)
+		want = append([]*QueryRecord{qr}, want...)
+	}
+	inFlight, handled, recent := qs.snapshot()
+	if inFlight != 0 || handled != uint64(recentQueryCount+recentQueryCount/2) {
+		t.Errorf("Got %d in-flight and %d handled queries, wanted 0 and %d", inFlight, handled, recentQueryCount+recentQueryCount/2)
+	}
+	if diff := cmp.Diff(want[:recentQueryCount], recent); diff != "" {
+		t.Errorf("Recent queries diff (-want +got):\n%s", diff)
+	}
+}
@@ -54,31 +54,32 @@ import (
 	"github.com/google/traceviz/server/go/category"
 	categoryaxis "github.com/google/traceviz/server/go/category_axis"
 	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/util"
 )
 
 const (
 	// Data types
-	dataTypeKey    = "bar_chart_data_type"
-	stackedBarsKey = "bar_chart_stacked_bars"
-	barKey         = "bar_chart_bar"
-	boxPlotKey     = "bar_chart_box_plot"
+	dataTypeKey    = keys.BarChartDataType
+	stackedBarsKey = keys.BarChartStackedBars
+	barKey         = keys.BarChartBar
+	boxPlotKey     = keys.BarChartBoxPlot
 
 	// Bar datum keys
-	barLowerExtentKey = "bar_chart_bar_lower_extent"
-	barUpperExtentKey = "bar_chart_bar_upper_extent"
-	boxPlotMinKey     = "bar_chart_box_plot_min"
-	boxPlotQ1Key      = "bar_chart_box_plot_q1"
-	boxPlotQ2Key      = "bar_chart_box_plot_q2"
-	boxPlotQ3Key      = "bar_chart_box_plot_q3"
-	boxPlotMaxKey     = "bar_chart_box_plot_max"
+	barLowerExtentKey = keys.BarChartBarLowerExtent
+	barUpperExtentKey = keys.BarChartBarUpperExtent
+	boxPlotMinKey     = keys.BarChartBoxPlotMin
+	boxPlotQ1Key      = keys.BarChartBoxPlotQ1
+	boxPlotQ2Key      = keys.BarChartBoxPlotQ2
+	boxPlotQ3Key      = keys.BarChartBoxPlotQ3
+	boxPlotMaxKey     = keys.BarChartBoxPlotMax
 
 	// Rendering property keys
-	barWidthCatPxKey         = "bar_chart_bar_width_cat_px"
-	barPaddingCatPxKey       = "bar_chart_bar_padding_cat_px"
-	categoryMinWidthCatPxKey = "bar_chart_category_min_width_cat_px"
-	categoryPaddingCatPxKey  = "bar_chart_category_padding_cat_px"
-	categoryWidthValPxKey    = "bar_chart_category_width_val_px"
+	barWidthCatPxKey         = keys.BarChartBarWidthCatPx
+	barPaddingCatPxKey       = keys.BarChartBarPaddingCatPx
+	categoryMinWidthCatPxKey = keys.BarChartCategoryMinWidthCatPx
+	categoryPaddingCatPxKey  = keys.BarChartCategoryPaddingCatPx
+	categoryWidthValPxKey    = keys.BarChartCategoryWidthValPx
 )
 
 // RenderSettings is a collection of rendering settings for bar chart.  A bar
@@ -112,14 +113,14 @@ func (rs *RenderSettings) define() util.PropertyUpdate {
 
 // BarChart represents a bar chart with one continuous value axis and one
 // discrete category axis.
-type BarChart[T float64 | time.Duration | time.Time] struct {
+type BarChart[T int64 | float64 | time.Duration | time.Time] struct {
 	db        util.DataBuilder
 	valueAxis *continuousaxis.Axis[T]
 }
 
 // New returns a new BarChart populating the provided DataBuilder, and using
 // the provided value axis and render settings.
-func New[T float64 | time.Duration | time.Time](db util.DataBuilder, valueAxis *continuousaxis.Axis[T], renderSettings *RenderSettings, properties ...util.PropertyUpdate) *BarChart[T] {
+func New[T int64 | float64 | time.Duration | time.Time](db util.DataBuilder, valueAxis *continuousaxis.Axis[T], renderSettings *RenderSettings, properties ...util.PropertyUpdate) *BarChart[T] {
 	return &BarChart[T]{
 		db: db.With(
 			valueAxis.Define(),
@@ -149,7 +150,7 @@ func (bc *BarChart[T]) Category(category *category.Category, properties ...util.
 }
 
 // Category represents a category lane within a bar chart.
-type Category[T float64 | time.Duration | time.Time] struct {
+type Category[T int64 | float64 | time.Duration | time.Time] struct {
 	db        util.DataBuilder
 	valueAxis *continuousaxis.Axis[T]
 }
@@ -197,7 +198,7 @@ func (c *Category[T]) BoxPlot(min, q1, q2, q3, max T) *BoxPlot[T] {
 }
 
 // StackedBars represents a collection of Bars within a Category.
-type StackedBars[T float64 | time.Duration | time.Time] struct {
+type StackedBars[T int64 | float64 | time.Duration | time.Time] struct {
 	db        util.DataBuilder
 	valueAxis *continuousaxis.Axis[T]
 }
@@ -209,7 +210,7 @@ func (sb *StackedBars[T]) Bar(lower, upper T) *Bar[T] {
 }
 
 // Bar represents a single bar within a Category or a StackedBars.
-type Bar[T float64 | time.Duration | time.Time] struct {
+type Bar[T int64 | float64 | time.Duration | time.Time] struct {
 	db util.DataBuilder
 	// Add valueAxis if we need value-aligned details within the bar.
 }
@@ -220,7 +221,7 @@ func (b *Bar[T]) With(properties ...util.PropertyUpdate) *Bar[T] {
 	return b
 }
 
-func newBar[T float64 | time.Duration | time.Time](parentDb util.DataBuilder, valueAxis *continuousaxis.Axis[T], lower, upper T) *Bar[T] {
+func newBar[T int64 | float64 | time.Duration | time.Time](parentDb util.DataBuilder, valueAxis *continuousaxis.Axis[T], lower, upper T) *Bar[T] {
 	return &Bar[T]{
 		db: parentDb.Child().With(
 			util.StringProperty(dataTypeKey, barKey),
@@ -231,7 +232,7 @@ func newBar[T float64 | time.Duration | time.Time](parentDb util.DataBuilder, va
 }
 
 // BoxPlot represents a box plot within a Category.
-type BoxPlot[T float64 | time.Duration | time.Time] struct {
+type BoxPlot[T int64 | float64 | time.Duration | time.Time] struct {
 	db util.DataBuilder
 	// Add valueAxis if we need value-aligned details within the bar.
 }
@@ -244,6 +245,6 @@ func (bp *BoxPlot[T]) With(properties ...util.PropertyUpdate) *BoxPlot[T] {
 
 // Property keys expected by the bar chart view.
 const (
-	DetailFormatKey = "detail_format"
-	LabelFormatKey  = "label_format"
+	DetailFormatKey = keys.DetailFormat
+	LabelFormatKey  = keys.LabelFormat
 )
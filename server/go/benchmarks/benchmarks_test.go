@@ -0,0 +1,191 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package benchmarks
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+)
+
+// buildDatums populates the provided DataBuilder with the specified number of
+// Datums, as a two-level tree of rows of 100 Datums each, each Datum having
+// string, integer, and double properties.
+func buildDatums(db util.DataBuilder, count int) {
+	var row util.DataBuilder
+	for i := 0; i < count; i++ {
+		if i%100 == 0 {
+			row = db.Child()
+		}
+		row.Child().With(
+			util.StringProperty("name", fmt.Sprintf("datum%d", i%1000)),
+			util.IntegerProperty("index", int64(i)),
+			util.DoubleProperty("weight", float64(i)/3),
+		)
+	}
+}
+
+func BenchmarkDataResponseBuilder(b *testing.B) {
+	for _, count := range []int{10000, 100000, 1000000} {
+		b.Run(fmt.Sprintf("%d_datums", count), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				drb := util.NewDataResponseBuilder()
+				buildDatums(drb.DataSeries(&util.DataSeriesRequest{SeriesName: "series"}), count)
+				if _, err := drb.Data(); err != nil {
+					b.Fatalf("failed to build response: %s", err)
+				}
+			}
+		})
+	}
+}
+
+var traceRenderSettings = &trace.RenderSettings{
+	SpanWidthCatPx:             16,
+	SpanPaddingCatPx:           1,
+	CategoryAxisRenderSettings: &categoryaxis.RenderSettings{},
+}
+
+func BenchmarkTrace(b *testing.B) {
+	const (
+		categories       = 100
+		spansPerCategory = 1000
+	)
+	cats := make([]*category.Category, categories)
+	for idx := range cats {
+		cats[idx] = category.New(fmt.Sprintf("cat%d", idx), fmt.Sprintf("Category %d", idx), "")
+	}
+	axis := continuousaxis.NewDurationAxis(category.New("x_axis", "Time", "Time"), 0, spansPerCategory*time.Microsecond)
+	for _, layout := range []bool{false, true} {
+		b.Run(fmt.Sprintf("100000_spans/layout=%t", layout), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				drb := util.NewDataResponseBuilder()
+				t := trace.New(drb.DataSeries(&util.DataSeriesRequest{SeriesName: "series"}), axis, traceRenderSettings)
+				if layout {
+					t.WithLayout()
+				}
+				for _, cat := range cats {
+					traceCat := t.Category(cat)
+					for span := 0; span < spansPerCategory; span++ {
+						start := time.Duration(span) * time.Microsecond
+						traceCat.Span(start, start+time.Microsecond/2,
+							util.IntegerProperty("pid", int64(span%10)),
+						)
+					}
+				}
+				if layout {
+					t.Layout()
+				}
+				if _, err := drb.Data(); err != nil {
+					b.Fatalf("failed to build response: %s", err)
+				}
+			}
+		})
+	}
+}
+
+// treeNode is a weightedtree.TreeNode with a precomputed total weight.
+type treeNode struct {
+	path     []weightedtree.ScopeID
+	total    int64
+	children []weightedtree.TreeNode
+}
+
+func (tn *treeNode) Path() []weightedtree.ScopeID {
+	return tn.path
+}
+
+func (tn *treeNode) Children(scopeIDs ...weightedtree.ScopeID) ([]weightedtree.TreeNode, error) {
+	if len(scopeIDs) == 0 {
+		return tn.children, nil
+	}
+	ret := make([]weightedtree.TreeNode, 0, len(scopeIDs))
+	for _, scopeID := range scopeIDs {
+		if int(scopeID) < len(tn.children) {
+			ret = append(ret, tn.children[scopeID])
+		}
+	}
+	return ret, nil
+}
+
+// newTree returns a complete tree of the specified fanout and depth, whose
+// nodes each have a self weight of their scope ID plus one.
+func newTree(path []weightedtree.ScopeID, fanout, depth int) *treeNode {
+	ret := &treeNode{
+		path:  path,
+		total: 1,
+	}
+	if len(path) > 0 {
+		ret.total += int64(path[len(path)-1])
+	}
+	if depth == 0 {
+		return ret
+	}
+	for scopeID := 0; scopeID < fanout; scopeID++ {
+		childPath := append(path[:len(path):len(path)], weightedtree.ScopeID(scopeID))
+		child := newTree(childPath, fanout, depth-1)
+		ret.total += child.total
+		ret.children = append(ret.children, child)
+	}
+	return ret
+}
+
+func compareTotal(a, b weightedtree.Comparable) (int, error) {
+	var aSum, bSum int64
+	for _, tn := range a.TreeNodes {
+		aSum += tn.(*treeNode).total
+	}
+	for _, tn := range b.TreeNodes {
+		bSum += tn.(*treeNode).total
+	}
+	switch {
+	case aSum < bSum:
+		return -1, nil
+	case aSum > bSum:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	// 111,111 nodes.
+	root := newTree(nil, 10, 5)
+	for _, test := range []struct {
+		name string
+		opts []weightedtree.WalkOption
+	}{{
+		name: "all_nodes",
+	}, {
+		name: "max_1000_nodes",
+		opts: []weightedtree.WalkOption{weightedtree.MaxNodes(1000)},
+	}} {
+		b.Run(test.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := weightedtree.Walk(root, compareTotal, test.opts...); err != nil {
+					b.Fatalf("failed to walk tree: %s", err)
+				}
+			}
+		})
+	}
+}
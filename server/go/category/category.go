@@ -19,14 +19,15 @@
 package category
 
 import (
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/util"
 )
 
 const (
-	categoryDefinedIDKey   = "category_defined_id"
-	categoryDescriptionKey = "category_description"
-	categoryDisplayNameKey = "category_display_name"
-	categoryIDsKey         = "category_ids"
+	categoryDefinedIDKey   = keys.CategoryDefinedID
+	categoryDescriptionKey = keys.CategoryDescription
+	categoryDisplayNameKey = keys.CategoryDisplayName
+	categoryIDsKey         = keys.CategoryIDs
 )
 
 // Category defines a data category.
@@ -54,6 +55,49 @@ func (c *Category) Define() util.PropertyUpdate {
 	)
 }
 
+// Defined returns the Category defined on the provided Datum, whose string
+// indices refer to the provided string table, and true, or false if no
+// Category is defined there.
+func Defined(d *util.Datum, st []string) (*Category, bool) {
+	str := func(key string) string {
+		v, ok := d.Property(st, key)
+		if !ok || v.T != util.StringIndexValueType {
+			return ""
+		}
+		if strIdx, ok := v.V.(int64); ok && strIdx >= 0 && strIdx < int64(len(st)) {
+			return st[strIdx]
+		}
+		return ""
+	}
+	id := str(categoryDefinedIDKey)
+	if id == "" {
+		return nil, false
+	}
+	return New(id, str(categoryDisplayNameKey), str(categoryDescriptionKey)), true
+}
+
+// TagsOf returns the IDs of the Categories tagging the provided Datum, whose
+// string indices refer to the provided string table.
+func TagsOf(d *util.Datum, st []string) []string {
+	v, ok := d.Property(st, categoryIDsKey)
+	if !ok || v.T != util.StringIndicesValueType {
+		return nil
+	}
+	strIdxs, _ := v.V.([]int64)
+	ret := make([]string, 0, len(strIdxs))
+	for _, strIdx := range strIdxs {
+		if strIdx >= 0 && strIdx < int64(len(st)) {
+			ret = append(ret, st[strIdx])
+		}
+	}
+	return ret
+}
+
+// DisplayName returns the category's display name.
+func (c *Category) DisplayName() string {
+	return c.displayName
+}
+
 // ID returns the category's ID.
 func (c *Category) ID() string {
 	return c.id
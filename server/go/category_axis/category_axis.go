@@ -14,15 +14,19 @@
 // Package categoryaxis provides helpers for defining category axis data.
 package categoryaxis
 
-import "github.com/google/traceviz/server/go/util"
+import (
+	"github.com/google/traceviz/server/go/util"
+
+	"github.com/google/traceviz/server/go/keys"
+)
 
 const (
-	categoryHeaderCatPxKey    = "category_header_cat_px"
-	categoryHandleValPxKey    = "category_handle_val_px"
-	categoryPaddingCatPxKey   = "category_padding_cat_px"
-	categoryMarginValPxKey    = "category_margin_val_px"
-	categoryMinWidthCatPxKey  = "category_min_width_cat_px"
-	categoryBaseWidthValPxKey = "category_base_width_val_px"
+	categoryHeaderCatPxKey    = keys.CategoryHeaderCatPx
+	categoryHandleValPxKey    = keys.CategoryHandleValPx
+	categoryPaddingCatPxKey   = keys.CategoryPaddingCatPx
+	categoryMarginValPxKey    = keys.CategoryMarginValPx
+	categoryMinWidthCatPxKey  = keys.CategoryMinWidthCatPx
+	categoryBaseWidthValPxKey = keys.CategoryBaseWidthValPx
 )
 
 // RenderSettings is a collection of rendering settings for category axes.  A
@@ -69,3 +73,21 @@ func (rs *RenderSettings) Define() util.PropertyUpdate {
 		util.IntegerProperty(categoryBaseWidthValPxKey, rs.CategoryBaseWidthValPx),
 	)
 }
+
+// DefinedRenderSettings returns the RenderSettings defined on the provided
+// Datum, whose string indices refer to the provided string table.  Settings
+// not defined there are zero.
+func DefinedRenderSettings(d *util.Datum, st []string) *RenderSettings {
+	px := func(key string) int64 {
+		f, _ := d.PropertyNumber(st, key)
+		return int64(f)
+	}
+	return &RenderSettings{
+		CategoryHeaderCatPx:    px(categoryHeaderCatPxKey),
+		CategoryHandleValPx:    px(categoryHandleValPxKey),
+		CategoryPaddingCatPx:   px(categoryPaddingCatPxKey),
+		CategoryMarginValPx:    px(categoryMarginValPxKey),
+		CategoryMinWidthCatPx:  px(categoryMinWidthCatPxKey),
+		CategoryBaseWidthValPx: px(categoryBaseWidthValPxKey),
+	}
+}
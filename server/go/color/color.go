@@ -73,23 +73,27 @@
 // color for a single type in multiple ways, the result is undefined.
 package color
 
-import "github.com/google/traceviz/server/go/util"
+import (
+	"github.com/google/traceviz/server/go/util"
+
+	"github.com/google/traceviz/server/go/keys"
+)
 
 const (
 	// colorSpaceNamePrefix defines a color space.
 	colorSpaceNamePrefix = "color_space_"
 	// The primary color space and value, or raw color.
-	primaryColorSpaceKey      = "primary_color_space"
-	primaryColorSpaceValueKey = "primary_color_space_value"
-	primaryColorKey           = "primary_color"
+	primaryColorSpaceKey      = keys.PrimaryColorSpace
+	primaryColorSpaceValueKey = keys.PrimaryColorSpaceValue
+	primaryColorKey           = keys.PrimaryColor
 	// The secondary color space and value, or raw color.
-	secondaryColorSpaceKey      = "secondary_color_space"
-	secondaryColorSpaceValueKey = "secondary_color_space_value"
-	secondaryColorKey           = "secondary_color"
+	secondaryColorSpaceKey      = keys.SecondaryColorSpace
+	secondaryColorSpaceValueKey = keys.SecondaryColorSpaceValue
+	secondaryColorKey           = keys.SecondaryColor
 	// The stroke color space and value, or raw color.
-	strokeColorSpaceKey      = "stroke_color_space"
-	strokeColorSpaceValueKey = "stroke_color_space_value"
-	strokeColorKey           = "stroke_color"
+	strokeColorSpaceKey      = keys.StrokeColorSpace
+	strokeColorSpaceValueKey = keys.StrokeColorSpaceValue
+	strokeColorKey           = keys.StrokeColor
 )
 
 // Space represents a color space: a color continuum that can map double
@@ -14,6 +14,7 @@
 package color
 
 import (
+	stdcolor "image/color"
 	"testing"
 
 	testutil "github.com/google/traceviz/server/go/test_util"
@@ -112,3 +113,63 @@ func TestColorDeclarations(t *testing.T) {
 		})
 	}
 }
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		str     string
+		want    stdcolor.NRGBA
+		wantErr bool
+	}{
+		{str: "Red", want: stdcolor.NRGBA{255, 0, 0, 255}},
+		{str: "#C0C0C0", want: stdcolor.NRGBA{192, 192, 192, 255}},
+		{str: "#0f0", want: stdcolor.NRGBA{0, 255, 0, 255}},
+		{str: "#00000080", want: stdcolor.NRGBA{0, 0, 0, 128}},
+		{str: "rgb(1, 2, 3)", want: stdcolor.NRGBA{1, 2, 3, 255}},
+		{str: "rgba(153, 0, 0, .5)", want: stdcolor.NRGBA{153, 0, 0, 128}},
+		{str: "#12345", wantErr: true},
+		{str: "rgb(1, 2)", wantErr: true},
+		{str: "hsl(0, 100%, 50%)", wantErr: true},
+	} {
+		t.Run(test.str, func(t *testing.T) {
+			got, err := Parse(test.str)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Parse() yielded error %v, wanted error: %t", err, test.wantErr)
+			}
+			if err == nil && got != test.want {
+				t.Errorf("Parse() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestColoring(t *testing.T) {
+	whiteToBlack := NewSpace("white_to_black", "white", "black")
+	drb := util.NewDataResponseBuilder()
+	root := drb.DataSeries(&util.DataSeriesRequest{SeriesName: "series"}).With(whiteToBlack.Define())
+	root.Child().With(whiteToBlack.PrimaryColor(.5), Stroke("blue"))
+	root.Child().With(Primary("#00ff00"))
+	root.Child()
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("failed to build data: %s", err)
+	}
+	st, rootDatum := data.StringTable, data.DataSeries[0].Root
+	coloring := NewColoring(st, rootDatum)
+	for idx, test := range []struct {
+		wantPrimary, wantStroke stdcolor.NRGBA
+		wantPrimaryOK           bool
+		wantStrokeOK            bool
+	}{
+		{wantPrimary: stdcolor.NRGBA{128, 128, 128, 255}, wantPrimaryOK: true, wantStroke: stdcolor.NRGBA{0, 0, 255, 255}, wantStrokeOK: true},
+		{wantPrimary: stdcolor.NRGBA{0, 255, 0, 255}, wantPrimaryOK: true},
+		{},
+	} {
+		d := rootDatum.Children[idx]
+		if got, ok := coloring.Primary(d, st); ok != test.wantPrimaryOK || got != test.wantPrimary {
+			t.Errorf("child %d: Primary() = %v, %t; want %v, %t", idx, got, ok, test.wantPrimary, test.wantPrimaryOK)
+		}
+		if got, ok := coloring.Stroke(d, st); ok != test.wantStrokeOK || got != test.wantStroke {
+			t.Errorf("child %d: Stroke() = %v, %t; want %v, %t", idx, got, ok, test.wantStroke, test.wantStrokeOK)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package color
+
+import (
+	"fmt"
+	stdcolor "image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// namedColors maps common HTML color names to their RGB values.
+var namedColors = map[string]stdcolor.RGBA{
+	"black":       {0, 0, 0, 255},
+	"white":       {255, 255, 255, 255},
+	"red":         {255, 0, 0, 255},
+	"green":       {0, 128, 0, 255},
+	"blue":        {0, 0, 255, 255},
+	"yellow":      {255, 255, 0, 255},
+	"orange":      {255, 165, 0, 255},
+	"purple":      {128, 0, 128, 255},
+	"gray":        {128, 128, 128, 255},
+	"grey":        {128, 128, 128, 255},
+	"silver":      {192, 192, 192, 255},
+	"lightgray":   {211, 211, 211, 255},
+	"lightgrey":   {211, 211, 211, 255},
+	"darkgray":    {169, 169, 169, 255},
+	"darkgrey":    {169, 169, 169, 255},
+	"cyan":        {0, 255, 255, 255},
+	"magenta":     {255, 0, 255, 255},
+	"brown":       {165, 42, 42, 255},
+	"pink":        {255, 192, 203, 255},
+	"teal":        {0, 128, 128, 255},
+	"navy":        {0, 0, 128, 255},
+	"maroon":      {128, 0, 0, 255},
+	"olive":       {128, 128, 0, 255},
+	"lime":        {0, 255, 0, 255},
+	"transparent": {0, 0, 0, 0},
+}
+
+// Parse parses the provided HTML color string: a common color name, a hex
+// color specifier ('#rgb', '#rrggbb', or '#rrggbbaa'), or an 'rgb()' or
+// 'rgba()' specifier.  HSL and HSLA specifiers are not supported.  The
+// returned color is not alpha-premultiplied.
+func Parse(str string) (stdcolor.NRGBA, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+	if c, ok := namedColors[str]; ok {
+		return stdcolor.NRGBA{c.R, c.G, c.B, c.A}, nil
+	}
+	if hex, ok := strings.CutPrefix(str, "#"); ok {
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) == 6 {
+			hex += "ff"
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if len(hex) != 8 || err != nil {
+			return stdcolor.NRGBA{}, fmt.Errorf("malformed hex color '%s'", str)
+		}
+		return stdcolor.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+	}
+	for _, prefix := range []string{"rgba(", "rgb("} {
+		args, ok := strings.CutPrefix(str, prefix)
+		if !ok {
+			continue
+		}
+		args, ok = strings.CutSuffix(args, ")")
+		parts := strings.Split(args, ",")
+		if !ok || len(parts) < 3 || len(parts) > 4 {
+			return stdcolor.NRGBA{}, fmt.Errorf("malformed color '%s'", str)
+		}
+		var channels [4]float64
+		channels[3] = 1
+		for idx, part := range parts {
+			f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				return stdcolor.NRGBA{}, fmt.Errorf("malformed color '%s'", str)
+			}
+			channels[idx] = f
+		}
+		clamp := func(f float64) uint8 {
+			return uint8(math.Round(math.Max(0, math.Min(255, f))))
+		}
+		return stdcolor.NRGBA{clamp(channels[0]), clamp(channels[1]), clamp(channels[2]), clamp(channels[3] * 255)}, nil
+	}
+	return stdcolor.NRGBA{}, fmt.Errorf("unsupported color '%s'", str)
+}
+
+// Coloring resolves the colors of Datums in a response, using the color
+// spaces defined on their ancestors.  It is the server-side counterpart of the
+// frontend's Coloring, for use by renderers and exporters.
+type Coloring struct {
+	spaces map[string][]stdcolor.NRGBA
+}
+
+// NewColoring returns a new Coloring with the color spaces defined on the
+// provided Datums, whose string indices refer to the provided string table.
+// Color spaces containing unparseable colors are ignored.
+func NewColoring(st []string, ds ...*util.Datum) *Coloring {
+	ret := &Coloring{
+		spaces: map[string][]stdcolor.NRGBA{},
+	}
+	for _, d := range ds {
+		ret.With(st, d)
+	}
+	return ret
+}
+
+// With adds the color spaces defined on the provided Datum, whose string
+// indices refer to the provided string table, to the receiver, returning the
+// receiver.
+func (c *Coloring) With(st []string, d *util.Datum) *Coloring {
+	for k := range d.Properties {
+		if k < 0 || k >= int64(len(st)) || !strings.HasPrefix(st[k], colorSpaceNamePrefix) {
+			continue
+		}
+		strs, ok := d.PropertyStrings(st, st[k])
+		if !ok || len(strs) == 0 {
+			continue
+		}
+		colors := make([]stdcolor.NRGBA, 0, len(strs))
+		for _, str := range strs {
+			parsed, err := Parse(str)
+			if err != nil {
+				break
+			}
+			colors = append(colors, parsed)
+		}
+		if len(colors) == len(strs) {
+			c.spaces[st[k]] = colors
+		}
+	}
+	return c
+}
+
+// interpolate returns the color at the specified position, between 0 and 1,
+// along the provided color space.
+func interpolate(space []stdcolor.NRGBA, pos float64) stdcolor.NRGBA {
+	if len(space) == 1 || pos <= 0 || math.IsNaN(pos) {
+		return space[0]
+	}
+	if pos >= 1 {
+		return space[len(space)-1]
+	}
+	scaled := pos * float64(len(space)-1)
+	idx := int(scaled)
+	frac := scaled - float64(idx)
+	a, b := space[idx], space[idx+1]
+	lerp := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + frac*(float64(y)-float64(x))))
+	}
+	return stdcolor.NRGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A)}
+}
+
+func (c *Coloring) color(d *util.Datum, st []string, colorKey, spaceKey, spaceValueKey string) (stdcolor.NRGBA, bool) {
+	if str, ok := d.PropertyString(st, colorKey); ok {
+		parsed, err := Parse(str)
+		return parsed, err == nil
+	}
+	spaceName, ok := d.PropertyString(st, spaceKey)
+	if !ok {
+		return stdcolor.NRGBA{}, false
+	}
+	space, ok := c.spaces[spaceName]
+	if !ok {
+		return stdcolor.NRGBA{}, false
+	}
+	pos, _ := d.PropertyNumber(st, spaceValueKey)
+	return interpolate(space, pos), true
+}
+
+// Primary returns the primary color of the provided Datum, whose string
+// indices refer to the provided string table, and true, or false if it has
+// no primary color or its color can't be resolved.
+func (c *Coloring) Primary(d *util.Datum, st []string) (stdcolor.NRGBA, bool) {
+	return c.color(d, st, primaryColorKey, primaryColorSpaceKey, primaryColorSpaceValueKey)
+}
+
+// Secondary returns the secondary color of the provided Datum, whose string
+// indices refer to the provided string table, and true, or false if it has
+// no secondary color or its color can't be resolved.
+func (c *Coloring) Secondary(d *util.Datum, st []string) (stdcolor.NRGBA, bool) {
+	return c.color(d, st, secondaryColorKey, secondaryColorSpaceKey, secondaryColorSpaceValueKey)
+}
+
+// Stroke returns the stroke color of the provided Datum, whose string indices
+// refer to the provided string table, and true, or false if it has no stroke
+// color or its color can't be resolved.
+func (c *Coloring) Stroke(d *util.Datum, st []string) (stdcolor.NRGBA, bool) {
+	return c.color(d, st, strokeColorKey, strokeColorSpaceKey, strokeColorSpaceValueKey)
+}
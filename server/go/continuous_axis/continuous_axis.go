@@ -13,30 +13,42 @@
 
 // Package continuousaxis provides decorator helpers for defining continuous
 // axes.  An axis has a name, a label, a type which describes that axis'
-// domain, and minimum and maximum points along that domain.
+// domain, and minimum and maximum points along that domain.  Axes may also
+// carry optional presentation hints, such as a unit, a logarithmic scale, or
+// explicit ticks, for domains where automatic ticking is inadequate.
 package continuousaxis
 
 import (
+	"fmt"
 	"math"
 	"time"
 
 	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/util"
 )
 
 const (
-	axisTypeKey = "axis_type"
-	axisMinKey  = "axis_min"
-	axisMaxKey  = "axis_max"
+	axisTypeKey = keys.AxisType
+	axisMinKey  = keys.AxisMin
+	axisMaxKey  = keys.AxisMax
+
+	axisTickCountKey  = keys.AxisTickCount
+	axisTicksKey      = keys.AxisTicks
+	axisTickLabelsKey = keys.AxisTickLabels
+	axisUnitKey       = keys.AxisUnit
+	axisLogScaleKey   = keys.AxisLogScale
+	axisAnchorKey     = keys.AxisAnchor
 
 	timestampAxisType = "timestamp"
 	durationAxisType  = "duration"
 	doubleAxisType    = "double"
+	integerAxisType   = "integer"
 
-	xAxisRenderLabelHeightPxKey   = "x_axis_render_label_height_px"
-	xAxisRenderMarkersHeightPxKey = "x_axis_render_markers_height_px"
-	yAxisRenderLabelHeightPxKey   = "y_axis_render_label_width_px"
-	yAxisRenderMarkersHeightPxKey = "y_axis_render_markers_width_px"
+	xAxisRenderLabelHeightPxKey   = keys.XAxisRenderLabelHeightPx
+	xAxisRenderMarkersHeightPxKey = keys.XAxisRenderMarkersHeightPx
+	yAxisRenderLabelHeightPxKey   = keys.YAxisRenderLabelWidthPx
+	yAxisRenderMarkersHeightPxKey = keys.YAxisRenderMarkersWidthPx
 )
 
 // XAxisRenderSettings contains configuring an X axis.
@@ -68,14 +80,24 @@ func (y YAxisRenderSettings) Apply() util.PropertyUpdate {
 }
 
 // Axis is implemented by types that can act as axes.
-type Axis[T float64 | time.Duration | time.Time] struct {
+type Axis[T int64 | float64 | time.Duration | time.Time] struct {
 	axisType string
 	cat      *category.Category
 	Value    func(key string, v T) util.PropertyUpdate
 	min, max T
+
+	// Optional presentation hints.
+	tickCount  int64
+	ticks      []T
+	tickLabels []string
+	unit       string
+	logScale   bool
+
+	// For duration axes, the absolute time of the zero duration, if known.
+	anchor time.Time
 }
 
-func newAxis[T float64 | time.Duration | time.Time](
+func newAxis[T int64 | float64 | time.Duration | time.Time](
 	axisType string,
 	cat *category.Category,
 	valueFn func(key string, v T) util.PropertyUpdate,
@@ -96,9 +118,68 @@ func (a *Axis[T]) Define() util.PropertyUpdate {
 		util.StringProperty(axisTypeKey, a.axisType),
 		a.Value(axisMinKey, a.min),
 		a.Value(axisMaxKey, a.max),
+		util.If(a.tickCount > 0, util.IntegerProperty(axisTickCountKey, a.tickCount)),
+		a.defineTicks(),
+		util.If(a.unit != "", util.StringProperty(axisUnitKey, a.unit)),
+		util.If(a.logScale, util.IntegerProperty(axisLogScaleKey, 1)),
+		util.If(!a.anchor.IsZero(), util.TimestampProperty(axisAnchorKey, a.anchor)),
 	)
 }
 
+// defineTicks annotates with the receiver's explicit ticks, if it has any.
+// Since tick values take the axis' type, the number of ticks is defined under
+// axisTicksKey, and the ith tick's value under axisTicksKey + "_i".
+func (a *Axis[T]) defineTicks() util.PropertyUpdate {
+	if len(a.ticks) == 0 {
+		return util.EmptyUpdate
+	}
+	updates := []util.PropertyUpdate{
+		util.IntegerProperty(axisTicksKey, int64(len(a.ticks))),
+		util.StringsProperty(axisTickLabelsKey, a.tickLabels...),
+	}
+	for idx, tick := range a.ticks {
+		updates = append(updates, a.Value(fmt.Sprintf("%s_%d", axisTicksKey, idx), tick))
+	}
+	return util.Chain(updates...)
+}
+
+// WithTickCount sets the receiver's preferred number of ticks, which the
+// frontend uses when choosing ticks automatically.  Returns the receiver.
+func (a *Axis[T]) WithTickCount(tickCount int64) *Axis[T] {
+	a.tickCount = tickCount
+	return a
+}
+
+// WithTick adds an explicit tick at the specified value, with the specified
+// label.  If the label is empty, the frontend formats the value itself.  Once
+// any explicit ticks are added, the frontend shows only explicit ticks.
+// Returns the receiver.
+func (a *Axis[T]) WithTick(value T, label string) *Axis[T] {
+	a.ticks = append(a.ticks, value)
+	a.tickLabels = append(a.tickLabels, label)
+	return a
+}
+
+// WithUnit sets the unit, such as 'bytes' or 'req/s', suffixed to the
+// receiver's tick labels.  Returns the receiver.
+func (a *Axis[T]) WithUnit(unit string) *Axis[T] {
+	a.unit = unit
+	return a
+}
+
+// WithLogScale specifies that the receiver should be presented with a
+// logarithmic scale.  This is only meaningful for double and integer axes
+// with positive extents.  Returns the receiver.
+func (a *Axis[T]) WithLogScale() *Axis[T] {
+	a.logScale = true
+	return a
+}
+
+// Extents returns the minimum and maximum extents of the receiving Axis.
+func (a *Axis[T]) Extents() (min, max T) {
+	return a.min, a.max
+}
+
 // CategoryID returns the category ID of the receiving Axis.
 func (a *Axis[T]) CategoryID() string {
 	return a.cat.ID()
@@ -144,6 +225,57 @@ func NewDurationAxis(cat *category.Category, extents ...time.Duration) *Axis[tim
 		}, min, max)
 }
 
+// WithAnchor specifies the absolute time corresponding to zero on the
+// receiving duration axis.  Anchored duration axes from different sources may
+// be reconciled with Rebase, then unioned.  It is only meaningful for duration
+// axes.  Returns the receiver.
+func (a *Axis[T]) WithAnchor(anchor time.Time) *Axis[T] {
+	a.anchor = anchor
+	return a
+}
+
+// Anchor returns the receiver's anchor, and whether it has one.
+func (a *Axis[T]) Anchor() (time.Time, bool) {
+	return a.anchor, !a.anchor.IsZero()
+}
+
+// CheckDurationUnion returns an error if the provided duration axes cannot be
+// unioned as they are, because they may not share a zero point: if some but
+// not all of them are anchored, or if they are anchored at different times.
+// Unanchored axes are assumed to share a zero point.
+func CheckDurationUnion(axes ...*Axis[time.Duration]) error {
+	for idx, axis := range axes {
+		first := axes[0]
+		if axis.anchor.IsZero() != first.anchor.IsZero() {
+			return fmt.Errorf("duration axes '%s' and '%s' cannot be unioned: only one is anchored", first.CategoryID(), axis.CategoryID())
+		}
+		if !axis.anchor.Equal(first.anchor) {
+			return fmt.Errorf("duration axes '%s' and '%s' cannot be unioned: anchored at %s and %s (axis %d must be rebased)", first.CategoryID(), axis.CategoryID(), first.anchor, axis.anchor, idx)
+		}
+	}
+	return nil
+}
+
+// Rebase returns a copy of the provided anchored duration axis, anchored
+// instead at the specified time, and the offset that must be added to values
+// on the provided axis to convert them to the returned one.  Its extents and
+// any explicit ticks are adjusted accordingly.
+func Rebase(axis *Axis[time.Duration], anchor time.Time) (*Axis[time.Duration], time.Duration, error) {
+	if axis.anchor.IsZero() {
+		return nil, 0, fmt.Errorf("duration axis '%s' has no anchor and cannot be rebased", axis.CategoryID())
+	}
+	offset := axis.anchor.Sub(anchor)
+	ret := *axis
+	ret.anchor = anchor
+	ret.min, ret.max = axis.min+offset, axis.max+offset
+	ret.ticks = make([]time.Duration, len(axis.ticks))
+	for idx, tick := range axis.ticks {
+		ret.ticks[idx] = tick + offset
+	}
+	ret.tickLabels = append([]string{}, axis.tickLabels...)
+	return &ret, offset, nil
+}
+
 // NewDoubleAxis returns a new DoubleAxis with the specified category.
 // If the optional extents are provided, the axis' minimum and maximum extents
 // will be initialized to the lowest and highest of those extents.
@@ -163,3 +295,272 @@ func NewDoubleAxis(cat *category.Category, extents ...float64) *Axis[float64] {
 			return util.DoubleProperty(key, v)
 		}, min, max)
 }
+
+// NewIntegerAxis returns a new IntegerAxis with the specified category.
+// Integer axes suit counting domains, like queue depths or goroutine counts,
+// whose values and ticks should be presented as whole numbers.  If the
+// optional extents are provided, the axis' minimum and maximum extents will be
+// initialized to the lowest and highest of those extents.
+func NewIntegerAxis(cat *category.Category, extents ...int64) *Axis[int64] {
+	var min, max int64 = math.MaxInt64, math.MinInt64
+	for _, extent := range extents {
+		if min > extent {
+			min = extent
+		}
+		if max < extent {
+			max = extent
+		}
+	}
+	return newAxis[int64](
+		integerAxisType, cat,
+		func(key string, v int64) util.PropertyUpdate {
+			return util.IntegerProperty(key, v)
+		}, min, max)
+}
+
+// Positioner maps values along an axis defined in a response to fractions of
+// that axis' extent.  It supports rendering and exporting responses on the
+// server.
+type Positioner struct {
+	axisType string
+	min, max *util.V
+}
+
+// DefinedPositioner returns a Positioner for the axis defined on the provided
+// Datum, whose string indices refer to the provided string table, and true,
+// or false if no axis is defined there.
+func DefinedPositioner(d *util.Datum, st []string) (*Positioner, bool) {
+	axisType, ok := d.PropertyString(st, axisTypeKey)
+	if !ok {
+		return nil, false
+	}
+	min, minOK := d.Property(st, axisMinKey)
+	max, maxOK := d.Property(st, axisMaxKey)
+	if !minOK || !maxOK {
+		return nil, false
+	}
+	return &Positioner{
+		axisType: axisType,
+		min:      min,
+		max:      max,
+	}, true
+}
+
+// Value returns the value of the provided Datum's property with the specified
+// key, whose string indices refer to the provided string table, as a
+// fraction of the receiver's extent: 0 at its minimum and 1 at its maximum.
+// Returns false if the Datum has no such property, or if it is of the wrong
+// type.
+func (p *Positioner) Value(d *util.Datum, st []string, key string) (float64, bool) {
+	v, ok := d.Property(st, key)
+	if !ok {
+		return 0, false
+	}
+	return p.Fraction(v)
+}
+
+// Fraction returns the provided value as a fraction of the receiver's extent:
+// 0 at its minimum and 1 at its maximum.  Returns false if the value is of the
+// wrong type.  If the receiver's extent is empty, all values are at 0.
+func (p *Positioner) Fraction(v *util.V) (float64, bool) {
+	// Distances from the axis minimum, as float64s.
+	var dist func(v *util.V) (float64, error)
+	switch p.axisType {
+	case timestampAxisType:
+		dist = func(v *util.V) (float64, error) {
+			min, err := util.ExpectTimestampValue(p.min)
+			if err != nil {
+				return 0, err
+			}
+			t, err := util.ExpectTimestampValue(v)
+			return float64(t.Sub(min)), err
+		}
+	case durationAxisType:
+		dist = func(v *util.V) (float64, error) {
+			min, err := util.ExpectDurationValue(p.min)
+			if err != nil {
+				return 0, err
+			}
+			dur, err := util.ExpectDurationValue(v)
+			return float64(dur - min), err
+		}
+	case doubleAxisType:
+		dist = func(v *util.V) (float64, error) {
+			min, err := util.ExpectDoubleValue(p.min)
+			if err != nil {
+				return 0, err
+			}
+			f, err := util.ExpectDoubleValue(v)
+			return f - min, err
+		}
+	case integerAxisType:
+		dist = func(v *util.V) (float64, error) {
+			min, err := util.ExpectIntegerValue(p.min)
+			if err != nil {
+				return 0, err
+			}
+			i, err := util.ExpectIntegerValue(v)
+			return float64(i - min), err
+		}
+	default:
+		return 0, false
+	}
+	extent, err := dist(p.max)
+	if err != nil {
+		return 0, false
+	}
+	d, err := dist(v)
+	if err != nil {
+		return 0, false
+	}
+	if extent <= 0 {
+		return 0, true
+	}
+	return d / extent, true
+}
+
+// ValueAt returns the value along the receiver corresponding to the provided
+// absolute time: the time itself on timestamp axes, or its offset from the
+// anchor on anchored duration axes.  Returns an error on other axes, or if
+// the value lies outside the receiver's extent.
+func (a *Axis[T]) ValueAt(at time.Time) (T, error) {
+	var ret any
+	switch any(a.min).(type) {
+	case time.Time:
+		ret = at
+	case time.Duration:
+		if a.anchor.IsZero() {
+			var zero T
+			return zero, fmt.Errorf("duration axis '%s' has no anchor, so absolute times cannot be placed on it", a.CategoryID())
+		}
+		ret = at.Sub(a.anchor)
+	default:
+		var zero T
+		return zero, fmt.Errorf("%s axis '%s' does not support absolute times", a.axisType, a.CategoryID())
+	}
+	v := ret.(T)
+	if a.Distance(a.min, v) < 0 || a.Distance(v, a.max) < 0 {
+		return v, fmt.Errorf("time %s lies outside the extent of axis '%s'", at.UTC().Format(time.RFC3339Nano), a.CategoryID())
+	}
+	return v, nil
+}
+
+// Distance returns the distance from one value along the receiver to another,
+// in the receiver's units: nanoseconds for timestamp and duration axes.
+func (a *Axis[T]) Distance(from, to T) float64 {
+	switch f := any(from).(type) {
+	case time.Time:
+		return float64(any(to).(time.Time).Sub(f))
+	case time.Duration:
+		return float64(any(to).(time.Duration) - f)
+	case float64:
+		return any(to).(float64) - f
+	case int64:
+		return float64(any(to).(int64) - f)
+	}
+	return 0
+}
+
+// At returns the value at the provided fraction of the receiver's extent: its
+// minimum at 0 and its maximum at 1.  Integer values are truncated.
+func (a *Axis[T]) At(fraction float64) T {
+	var ret any
+	switch min := any(a.min).(type) {
+	case time.Time:
+		ret = min.Add(time.Duration(fraction * a.Distance(a.min, a.max)))
+	case time.Duration:
+		ret = min + time.Duration(fraction*a.Distance(a.min, a.max))
+	case float64:
+		ret = min + fraction*a.Distance(a.min, a.max)
+	case int64:
+		ret = min + int64(fraction*a.Distance(a.min, a.max))
+	}
+	return ret.(T)
+}
+
+// Fraction returns the provided value as a fraction of the receiver's extent:
+// 0 at its minimum and 1 at its maximum.  If the receiver's extent is empty,
+// all values are at 0.
+func (a *Axis[T]) Fraction(v T) float64 {
+	extent := a.Distance(a.min, a.max)
+	if extent <= 0 {
+		return 0
+	}
+	return a.Distance(a.min, v) / extent
+}
+
+// Column returns a PropertyUpdate setting the provided values along the
+// receiver as a single columnar property with the specified key, to compactly
+// encode dense data.  Integer and double axes' values are encoded as Integers
+// and Doubles respectively; duration axes' values as Integers of nanoseconds;
+// and timestamp axes' values as Integers of nanoseconds since the receiver's
+// minimum, which, unlike nanoseconds since the epoch, frontends can represent
+// exactly.
+func (a *Axis[T]) Column(key string, vs []T) util.PropertyUpdate {
+	switch col := any(vs).(type) {
+	case []int64:
+		return util.IntegersProperty(key, col...)
+	case []float64:
+		return util.DoublesProperty(key, col...)
+	case []time.Duration:
+		ints := make([]int64, len(col))
+		for idx, dur := range col {
+			ints[idx] = int64(dur)
+		}
+		return util.IntegersProperty(key, ints...)
+	case []time.Time:
+		min := any(a.min).(time.Time)
+		ints := make([]int64, len(col))
+		for idx, ts := range col {
+			ints[idx] = int64(ts.Sub(min))
+		}
+		return util.IntegersProperty(key, ints...)
+	}
+	return util.EmptyUpdate
+}
+
+// ColumnFractions returns the values of the provided column, encoded as by
+// Axis.Column, as fractions of the receiver's extent.  Returns false if the
+// column is of the wrong type.
+func (p *Positioner) ColumnFractions(col *util.V) ([]float64, bool) {
+	var vs []*util.V
+	if p.axisType == doubleAxisType {
+		dbls, err := util.ExpectDoublesValue(col)
+		if err != nil {
+			return nil, false
+		}
+		vs = make([]*util.V, len(dbls))
+		for idx, dbl := range dbls {
+			vs[idx] = util.DoubleValue(dbl)
+		}
+	} else {
+		ints, err := util.ExpectIntegersValue(col)
+		if err != nil {
+			return nil, false
+		}
+		vs = make([]*util.V, len(ints))
+		for idx, i := range ints {
+			switch p.axisType {
+			case timestampAxisType:
+				min, err := util.ExpectTimestampValue(p.min)
+				if err != nil {
+					return nil, false
+				}
+				vs[idx] = util.TimestampValue(min.Add(time.Duration(i)))
+			case durationAxisType:
+				vs[idx] = util.DurationValue(time.Duration(i))
+			default:
+				vs[idx] = util.IntegerValue(i)
+			}
+		}
+	}
+	ret := make([]float64, len(vs))
+	for idx, v := range vs {
+		f, ok := p.Fraction(v)
+		if !ok {
+			return nil, false
+		}
+		ret[idx] = f
+	}
+	return ret, true
+}
@@ -24,14 +24,14 @@ import (
 
 const timeLayout = "Jan 2, 2006 at 3:04pm (MST)"
 
-type testcase[T float64 | time.Duration | time.Time] struct {
+type testcase[T int64 | float64 | time.Duration | time.Time] struct {
 	description string
 	axis        *Axis[T]
 	wantUpdates []util.PropertyUpdate
 	wantValues  map[T]util.PropertyUpdate
 }
 
-func runTests[T float64 | time.Duration | time.Time](t *testing.T, testcases []testcase[T]) {
+func runTests[T int64 | float64 | time.Duration | time.Time](t *testing.T, testcases []testcase[T]) {
 	for _, test := range testcases {
 		t.Run(test.description, func(t *testing.T) {
 			gotUpdates := test.axis.Define()
@@ -105,4 +105,136 @@ func TestAxis(t *testing.T) {
 			5.5: util.DoubleProperty("axis", 5.5),
 		},
 	}})
+	runTests(t, []testcase[int64]{{
+		description: "integer",
+		axis:        NewIntegerAxis(cat, 3, -2, 10),
+		wantUpdates: []util.PropertyUpdate{
+			cat.Define(),
+			util.StringProperty(axisTypeKey, integerAxisType),
+			util.IntegerProperty(axisMinKey, -2),
+			util.IntegerProperty(axisMaxKey, 10),
+		},
+		wantValues: map[int64]util.PropertyUpdate{
+			5: util.IntegerProperty("axis", 5),
+		},
+	}})
+	runTests(t, []testcase[float64]{{
+		description: "double with hints",
+		axis: NewDoubleAxis(cat, 1, 1000).
+			WithTickCount(4).
+			WithUnit("bytes").
+			WithLogScale(),
+		wantUpdates: []util.PropertyUpdate{
+			cat.Define(),
+			util.StringProperty(axisTypeKey, doubleAxisType),
+			util.DoubleProperty(axisMinKey, 1),
+			util.DoubleProperty(axisMaxKey, 1000),
+			util.IntegerProperty(axisTickCountKey, 4),
+			util.StringProperty(axisUnitKey, "bytes"),
+			util.IntegerProperty(axisLogScaleKey, 1),
+		},
+	}})
+	runTests(t, []testcase[time.Duration]{{
+		description: "duration with explicit ticks",
+		axis: NewDurationAxis(cat, 0, time.Minute).
+			WithTick(0, "start").
+			WithTick(30*time.Second, ""),
+		wantUpdates: []util.PropertyUpdate{
+			cat.Define(),
+			util.StringProperty(axisTypeKey, durationAxisType),
+			util.DurationProperty(axisMinKey, 0),
+			util.DurationProperty(axisMaxKey, time.Minute),
+			util.IntegerProperty(axisTicksKey, 2),
+			util.StringsProperty(axisTickLabelsKey, "start", ""),
+			util.DurationProperty(axisTicksKey+"_0", 0),
+			util.DurationProperty(axisTicksKey+"_1", 30*time.Second),
+		},
+	}})
+}
+
+func TestDurationAxisAnchors(t *testing.T) {
+	anchor := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	cat := category.New("axis", "My axis", "All about my axis")
+	runTests(t, []testcase[time.Duration]{{
+		description: "anchored duration",
+		axis:        NewDurationAxis(cat, 0, time.Minute).WithAnchor(anchor),
+		wantUpdates: []util.PropertyUpdate{
+			cat.Define(),
+			util.StringProperty(axisTypeKey, durationAxisType),
+			util.DurationProperty(axisMinKey, 0),
+			util.DurationProperty(axisMaxKey, time.Minute),
+			util.TimestampProperty(axisAnchorKey, anchor),
+		},
+	}})
+	if err := CheckDurationUnion(NewDurationAxis(cat), NewDurationAxis(cat)); err != nil {
+		t.Errorf("CheckDurationUnion() of unanchored axes yielded unexpected error %s", err)
+	}
+	if err := CheckDurationUnion(NewDurationAxis(cat), NewDurationAxis(cat).WithAnchor(anchor)); err == nil {
+		t.Errorf("CheckDurationUnion() of anchored and unanchored axes yielded no error, but expected one")
+	}
+	later := NewDurationAxis(cat, 0, time.Minute).WithAnchor(anchor.Add(time.Hour)).WithTick(time.Minute, "end")
+	if err := CheckDurationUnion(NewDurationAxis(cat).WithAnchor(anchor), later); err == nil {
+		t.Errorf("CheckDurationUnion() of differently-anchored axes yielded no error, but expected one")
+	}
+	if _, _, err := Rebase(NewDurationAxis(cat), anchor); err == nil {
+		t.Errorf("Rebase() of unanchored axis yielded no error, but expected one")
+	}
+	rebased, offset, err := Rebase(later, anchor)
+	if err != nil {
+		t.Fatalf("Rebase() yielded unexpected error %s", err)
+	}
+	if offset != time.Hour {
+		t.Errorf("Rebase() yielded offset %s, want %s", offset, time.Hour)
+	}
+	if err := CheckDurationUnion(NewDurationAxis(cat).WithAnchor(anchor), rebased); err != nil {
+		t.Errorf("CheckDurationUnion() of rebased axis yielded unexpected error %s", err)
+	}
+	runTests(t, []testcase[time.Duration]{{
+		description: "rebased duration",
+		axis:        rebased,
+		wantUpdates: []util.PropertyUpdate{
+			cat.Define(),
+			util.StringProperty(axisTypeKey, durationAxisType),
+			util.DurationProperty(axisMinKey, time.Hour),
+			util.DurationProperty(axisMaxKey, time.Hour+time.Minute),
+			util.IntegerProperty(axisTicksKey, 1),
+			util.StringsProperty(axisTickLabelsKey, "end"),
+			util.DurationProperty(axisTicksKey+"_0", time.Hour+time.Minute),
+			util.TimestampProperty(axisAnchorKey, anchor),
+		},
+	}})
+}
+
+func TestFraction(t *testing.T) {
+	cat := category.New("axis", "My axis", "All about my axis")
+	if got := NewDurationAxis(cat, 10*time.Second, 20*time.Second).Fraction(15 * time.Second); got != .5 {
+		t.Errorf("duration Fraction() = %g, want .5", got)
+	}
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if got := NewTimestampAxis(cat, start, start.Add(time.Hour)).Fraction(start.Add(15 * time.Minute)); got != .25 {
+		t.Errorf("timestamp Fraction() = %g, want .25", got)
+	}
+	if got := NewIntegerAxis(cat, 0, 4).Fraction(5); got != 1.25 {
+		t.Errorf("integer Fraction() = %g, want 1.25", got)
+	}
+	if got := NewDoubleAxis(cat, 1, 1).Fraction(3); got != 0 {
+		t.Errorf("empty double Fraction() = %g, want 0", got)
+	}
+}
+
+func TestAt(t *testing.T) {
+	cat := category.New("axis", "My axis", "All about my axis")
+	if got := NewDurationAxis(cat, 10*time.Second, 20*time.Second).At(.5); got != 15*time.Second {
+		t.Errorf("duration At() = %s, want 15s", got)
+	}
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if got := NewTimestampAxis(cat, start, start.Add(time.Hour)).At(.25); !got.Equal(start.Add(15 * time.Minute)) {
+		t.Errorf("timestamp At() = %s, want %s", got, start.Add(15*time.Minute))
+	}
+	if got := NewIntegerAxis(cat, 0, 4).At(.6); got != 2 {
+		t.Errorf("integer At() = %d, want 2", got)
+	}
+	if got := NewDoubleAxis(cat, 1, 3).At(1.5); got != 4 {
+		t.Errorf("double At() = %g, want 4", got)
+	}
 }
@@ -0,0 +1,123 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package decorators supports defining named, reusable bundles of properties,
+// such as styling rules, once per response and referring to them by ID from
+// many Datums, rather than expanding the same properties inline on each.
+//
+// A Set is populated with decorators, each mapping an ID to a set of
+// properties:
+//
+//	decs := decorators.New().
+//		With("error-style", util.StringProperty("style_fill", "red")).
+//		With("gc-span-style", util.StringProperty("style_fill", "gray"))
+//
+// Datums then refer to decorators by ID via Apply:
+//
+//	span.With(decs.Apply("error-style"))
+//
+// Finally, the definitions of every applied decorator are attached, once, to
+// a Datum enclosing all the decorated Datums (generally the data series root)
+// via Define:
+//
+//	series.With(decs.Define())
+//
+// A decorator's properties are defined on the enclosing Datum with each key
+// prefixed by 'decorator:<id>:'.  Consumers of the response should expand a
+// decorated Datum's properties with those of the decorators it refers to, in
+// the order they were applied, with the Datum's own properties taking
+// precedence.
+package decorators
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	// The string slice of IDs of the decorators applied to a Datum.
+	decoratorsKey = keys.Decorators
+
+	definitionPrefix    = "decorator:"
+	definitionSeparator = ":"
+)
+
+// Set is a set of decorators.  It is thread-safe, and may be shared among all
+// data series in a single response, but since it tracks which decorators
+// have been applied, a new Set should be used for each response.
+type Set struct {
+	mu      sync.Mutex
+	defs    map[string][]util.PropertyUpdate
+	applied map[string]struct{}
+}
+
+// New returns a new, empty Set.
+func New() *Set {
+	return &Set{
+		defs:    map[string][]util.PropertyUpdate{},
+		applied: map[string]struct{}{},
+	}
+}
+
+// With defines a decorator with the specified ID and properties in the
+// receiver, replacing any existing decorator with that ID.  IDs may not be
+// empty or contain ':'.
+func (s *Set) With(id string, properties ...util.PropertyUpdate) *Set {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defs[id] = properties
+	return s
+}
+
+// Apply returns a PropertyUpdate referring a Datum to the specified
+// decorators.  It may be applied multiple times to the same Datum; later
+// decorators take precedence over earlier ones.
+func (s *Set) Apply(ids ...string) util.PropertyUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if id == "" || strings.Contains(id, definitionSeparator) {
+			return util.ErrorProperty(fmt.Errorf("invalid decorator ID '%s'", id))
+		}
+		if _, ok := s.defs[id]; !ok {
+			return util.ErrorProperty(fmt.Errorf("undefined decorator '%s'", id))
+		}
+		s.applied[id] = struct{}{}
+	}
+	return util.StringsPropertyExtended(decoratorsKey, ids...)
+}
+
+// Define returns a PropertyUpdate defining every decorator applied so far.
+// It should be applied once, after all decorated Datums have been built, to a
+// Datum enclosing them all.  Decorators that were never applied are not
+// defined, so a Set may hold many more decorators than any single response
+// uses.
+func (s *Set) Define() util.PropertyUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.applied))
+	for id := range s.applied {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	ret := make([]util.PropertyUpdate, len(ids))
+	for idx, id := range ids {
+		ret[idx] = util.PrefixedProperties(definitionPrefix+id+definitionSeparator, s.defs[id]...)
+	}
+	return util.Chain(ret...)
+}
@@ -0,0 +1,106 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package decorators
+
+import (
+	"testing"
+
+	testutil "github.com/google/traceviz/server/go/test_util"
+	"github.com/google/traceviz/server/go/util"
+)
+
+func TestDecorators(t *testing.T) {
+	for _, test := range []struct {
+		description    string
+		buildDecorated func(db util.DataBuilder)
+		buildExplicit  func(db testutil.TestDataBuilder)
+	}{{
+		description: "applied decorators are defined once",
+		buildDecorated: func(db util.DataBuilder) {
+			decs := New().
+				With("error", util.StringProperty("style_fill", "red"), util.IntegerProperty("weight", 2)).
+				With("gc", util.StringProperty("style_fill", "gray")).
+				With("unused", util.StringProperty("style_fill", "blue"))
+			db.Child().With(util.StringProperty("name", "a"), decs.Apply("error"))
+			db.Child().With(util.StringProperty("name", "b"), decs.Apply("gc", "error"))
+			db.Child().With(util.StringProperty("name", "c"))
+			db.With(decs.Define())
+		},
+		buildExplicit: func(db testutil.TestDataBuilder) {
+			db.With(
+				util.StringProperty("decorator:error:style_fill", "red"),
+				util.IntegerProperty("decorator:error:weight", 2),
+				util.StringProperty("decorator:gc:style_fill", "gray"),
+			).Child().With(
+				util.StringProperty("name", "a"),
+				util.StringsProperty(decoratorsKey, "error"),
+			).AndChild().With(
+				util.StringProperty("name", "b"),
+				util.StringsProperty(decoratorsKey, "gc", "error"),
+			).AndChild().With(
+				util.StringProperty("name", "c"),
+			)
+		},
+	}, {
+		description: "repeated application extends",
+		buildDecorated: func(db util.DataBuilder) {
+			decs := New().
+				With("a", util.StringProperty("x", "1")).
+				With("b", util.StringProperty("y", "2"))
+			db.Child().With(decs.Apply("a"), decs.Apply("b"))
+			db.With(decs.Define())
+		},
+		buildExplicit: func(db testutil.TestDataBuilder) {
+			db.With(
+				util.StringProperty("decorator:a:x", "1"),
+				util.StringProperty("decorator:b:y", "2"),
+			).Child().With(
+				util.StringsProperty(decoratorsKey, "a", "b"),
+			)
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if err := testutil.CompareResponses(t, test.buildDecorated, test.buildExplicit); err != nil {
+				t.Fatalf("encountered unexpected error building the decorators: %s", err)
+			}
+		})
+	}
+}
+
+func TestDecoratorErrors(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		id          string
+	}{{
+		description: "undefined",
+		id:          "missing",
+	}, {
+		description: "empty",
+		id:          "",
+	}, {
+		description: "separator",
+		id:          "a:b",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			decs := New().
+				With("", util.StringProperty("x", "1")).
+				With("a:b", util.StringProperty("x", "1"))
+			drb := util.NewDataResponseBuilder()
+			drb.DataSeries(&util.DataSeriesRequest{SeriesName: "1"}).Child().With(decs.Apply(test.id))
+			if _, err := drb.Data(); err == nil {
+				t.Fatalf("expected error applying decorator '%s', got none", test.id)
+			}
+		})
+	}
+}
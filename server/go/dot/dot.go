@@ -151,23 +151,24 @@
 package dot
 
 import (
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/util"
 )
 
 const (
-	strictnessKey          = "dot_strictness"
-	directionalityKey      = "dot_directionality"
-	layoutEngineKey        = "dot_layout_engine"
-	statementTypeKey       = "dot_statement_type"
-	attrStatementTargetKey = "dot_attr_statement_target"
-	attributesKey          = "dot_attributes"
+	strictnessKey          = keys.DotStrictness
+	directionalityKey      = keys.DotDirectionality
+	layoutEngineKey        = keys.DotLayoutEngine
+	statementTypeKey       = keys.DotStatementType
+	attrStatementTargetKey = keys.DotAttrStatementTarget
+	attributesKey          = keys.DotAttributes
 
 	// Keys associated with node and subgraph IDs
-	subgraphIDKey  = "dot_subgraph_id"
-	nodeIDKey      = "dot_node_id"
-	edgeIDKey      = "dot_edge_id"
-	startNodeIDKey = "dot_start_node_id"
-	endNodeIDKey   = "dot_end_node_id"
+	subgraphIDKey  = keys.DotSubgraphID
+	nodeIDKey      = keys.DotNodeID
+	edgeIDKey      = keys.DotEdgeID
+	startNodeIDKey = keys.DotStartNodeID
+	endNodeIDKey   = keys.DotEndNodeID
 
 	strict     = "strict"
 	nonstrict  = "nonstrict"
@@ -0,0 +1,408 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package eventtable
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+const (
+	// Global filters
+	collectionNameKey = "collection_name"
+	startTimestampKey = "start_timestamp"
+	endTimestampKey   = "end_timestamp"
+
+	// Query names, within the DataSource's namespace
+	traceQuery      = "trace"
+	tableQuery      = "table"
+	timeseriesQuery = "timeseries"
+	treeQuery       = "tree"
+
+	// Options
+	binCountKey = "bin_count"
+
+	// Properties
+	timestampKey = "timestamp"
+	durationKey  = "duration"
+	weightKey    = "weight"
+	nameKey      = "name"
+)
+
+// allEventsPath is the category path of events in tables with no category
+// columns.
+var allEventsPath = []string{"events"}
+
+// TraceRenderSettings are the render settings used for trace queries.
+var TraceRenderSettings = &trace.RenderSettings{
+	SpanWidthCatPx:   16,
+	SpanPaddingCatPx: 1,
+	CategoryAxisRenderSettings: &categoryaxis.RenderSettings{
+		CategoryHeaderCatPx:    16,
+		CategoryHandleValPx:    8,
+		CategoryPaddingCatPx:   2,
+		CategoryMarginValPx:    20,
+		CategoryMinWidthCatPx:  20,
+		CategoryBaseWidthValPx: 120,
+	},
+}
+
+// TreeRenderSettings are the render settings used for tree queries.
+var TreeRenderSettings = &weightedtree.RenderSettings{
+	FrameHeightPx: 20,
+}
+
+// DataSource is a QueryDispatcher data source serving Tables.  Within its
+// namespace <ns>, it supports the queries:
+//
+//   - '<ns>.trace', a trace with a category for each category path, nested
+//     by path, holding a span for each event.
+//   - '<ns>.table', a table with a row for each event, in time order.
+//   - '<ns>.timeseries', an xy chart with a series for each outermost
+//     category, holding the total event weight within each of 'bin_count'
+//     time bins.
+//   - '<ns>.tree', a weighted tree of category paths, weighted by the total
+//     weight of the events with each path.
+//
+// The 'collection_name' global filter names the Table to query, and the
+// optional 'start_timestamp' and 'end_timestamp' global filters restrict the
+// query to events overlapping that interval.
+type DataSource struct {
+	namespace string
+	tables    map[string]*Table
+}
+
+// NewDataSource returns a new DataSource serving the provided Tables by name,
+// with queries in the specified namespace.
+func NewDataSource(namespace string, tables map[string]*Table) *DataSource {
+	return &DataSource{
+		namespace: namespace,
+		tables:    tables,
+	}
+}
+
+func (ds *DataSource) queryName(name string) string {
+	return ds.namespace + "." + name
+}
+
+// SupportedDataSeriesQueries returns the queries supported by DataSource.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	return []string{
+		ds.queryName(traceQuery),
+		ds.queryName(tableQuery),
+		ds.queryName(timeseriesQuery),
+		ds.queryName(treeQuery),
+	}
+}
+
+// queryFilters holds the global filters applying to a query.
+type queryFilters struct {
+	table      *Table
+	start, end time.Time
+}
+
+// events returns the events overlapping the receiver's interval, in time
+// order.
+func (qf *queryFilters) events() []*Event {
+	var ret []*Event
+	for _, ev := range qf.table.Events {
+		if ev.Time.After(qf.end) {
+			break
+		}
+		if !ev.End().Before(qf.start) {
+			ret = append(ret, ev)
+		}
+	}
+	return ret
+}
+
+func (ds *DataSource) filters(globalState map[string]*util.V) (*queryFilters, error) {
+	collectionNameVal, ok := globalState[collectionNameKey]
+	if !ok {
+		return nil, fmt.Errorf("missing required global filter '%s'", collectionNameKey)
+	}
+	collectionName, err := util.ExpectStringValue(collectionNameVal)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := ds.tables[collectionName]
+	if !ok {
+		return nil, fmt.Errorf("no event table named '%s'", collectionName)
+	}
+	qf := &queryFilters{
+		table: t,
+		start: t.Start,
+		end:   t.End,
+	}
+	if tsv, ok := globalState[startTimestampKey]; ok {
+		if qf.start, err = util.ExpectTimestampValue(tsv); err != nil {
+			return nil, err
+		}
+	}
+	if tsv, ok := globalState[endTimestampKey]; ok {
+		if qf.end, err = util.ExpectTimestampValue(tsv); err != nil {
+			return nil, err
+		}
+	}
+	if qf.end.Before(qf.start) {
+		return nil, fmt.Errorf("'%s' must not precede '%s'", endTimestampKey, startTimestampKey)
+	}
+	return qf, nil
+}
+
+// HandleDataSeriesRequests handles the provided DataSeriesRequests.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	qf, err := ds.filters(globalState)
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		var err error
+		switch req.QueryName {
+		case ds.queryName(traceQuery):
+			err = handleTraceQuery(qf, drb.DataSeries(req), req.Options)
+		case ds.queryName(tableQuery):
+			err = handleTableQuery(qf, drb.DataSeries(req), req.Options)
+		case ds.queryName(timeseriesQuery):
+			err = handleTimeseriesQuery(qf, drb.DataSeries(req), req.Options)
+		case ds.queryName(treeQuery):
+			err = handleTreeQuery(qf, drb.DataSeries(req), req.Options)
+		default:
+			err = fmt.Errorf("unsupported data query `%s`", req.QueryName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func path(ev *Event) []string {
+	if len(ev.Path) == 0 {
+		return allEventsPath
+	}
+	return ev.Path
+}
+
+// pathCategory returns the category for the provided category path.
+func pathCategory(path []string) *category.Category {
+	name := path[len(path)-1]
+	return category.New(strings.Join(path, "/"), name, strings.Join(path, " > "))
+}
+
+func eventProperties(cfg *Config, ev *Event) []util.PropertyUpdate {
+	ret := []util.PropertyUpdate{
+		util.If(cfg.WeightColumn != "", util.DoubleProperty(weightKey, ev.Weight)),
+	}
+	for idx, key := range cfg.PropertyColumns {
+		ret = append(ret, util.StringProperty(key, ev.Properties[idx]))
+	}
+	return ret
+}
+
+func handleTraceQuery(qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	for key := range reqOpts {
+		return fmt.Errorf("unsupported option '%s'", key)
+	}
+	t := trace.New(series, continuousaxis.NewTimestampAxis(
+		category.New("x_axis", "Time", "Event time"),
+		qf.start, qf.end), TraceRenderSettings)
+	type catNode struct {
+		cat      *trace.Category[time.Time]
+		children map[string]*catNode
+	}
+	root := &catNode{children: map[string]*catNode{}}
+	for _, ev := range qf.events() {
+		p := path(ev)
+		node := root
+		for idx, name := range p {
+			child, ok := node.children[name]
+			if !ok {
+				child = &catNode{children: map[string]*catNode{}}
+				if node == root {
+					child.cat = t.Category(pathCategory(p[:idx+1]))
+				} else {
+					child.cat = node.cat.Category(pathCategory(p[:idx+1]))
+				}
+				node.children[name] = child
+			}
+			node = child
+		}
+		node.cat.Span(ev.Time, ev.End(), eventProperties(qf.table.Config, ev)...)
+	}
+	return nil
+}
+
+func handleTableQuery(qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	for key := range reqOpts {
+		return fmt.Errorf("unsupported option '%s'", key)
+	}
+	cfg := qf.table.Config
+	column := func(id string) *table.ColumnUpdate {
+		return table.Column(category.New(id, id, id))
+	}
+	timestampCol := column(cfg.TimestampColumn)
+	columns := []*table.ColumnUpdate{timestampCol}
+	var durationCol, weightCol *table.ColumnUpdate
+	if cfg.DurationColumn != "" {
+		durationCol = column(cfg.DurationColumn)
+		columns = append(columns, durationCol)
+	}
+	var categoryCols, propertyCols []*table.ColumnUpdate
+	for _, name := range cfg.CategoryColumns {
+		categoryCols = append(categoryCols, column(name))
+	}
+	columns = append(columns, categoryCols...)
+	if cfg.WeightColumn != "" {
+		weightCol = column(cfg.WeightColumn)
+		columns = append(columns, weightCol)
+	}
+	for _, name := range cfg.PropertyColumns {
+		propertyCols = append(propertyCols, column(name))
+	}
+	columns = append(columns, propertyCols...)
+	t := table.New(series, nil, columns...)
+	for _, ev := range qf.events() {
+		cells := []table.CellUpdate{table.Cell(timestampCol, util.Timestamp(ev.Time))}
+		if durationCol != nil {
+			cells = append(cells, table.Cell(durationCol, util.Duration(ev.Duration)))
+		}
+		for idx, col := range categoryCols {
+			cells = append(cells, table.Cell(col, util.String(ev.Path[idx])))
+		}
+		if weightCol != nil {
+			cells = append(cells, table.Cell(weightCol, util.Double(ev.Weight)))
+		}
+		for idx, col := range propertyCols {
+			cells = append(cells, table.Cell(col, util.String(ev.Properties[idx])))
+		}
+		t.Row(cells...)
+	}
+	return nil
+}
+
+func handleTimeseriesQuery(qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	var binCount int64
+	var err error
+	for key, val := range reqOpts {
+		switch key {
+		case binCountKey:
+			binCount, err = util.ExpectIntegerValue(val)
+		default:
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if binCount <= 1 {
+		return fmt.Errorf("timeseries bin count must be >1")
+	}
+	// As in other timeseries, the last bin holds only events at the end of the
+	// interval; each bin includes its lower bound but not its upper bound.
+	binWidth := qf.end.Sub(qf.start) / time.Duration(binCount-1)
+	if binWidth <= 0 {
+		binWidth = 1
+	}
+	var seriesNames []string
+	pointsBySeries := map[string][]float64{}
+	for _, ev := range qf.events() {
+		if ev.Time.Before(qf.start) {
+			continue
+		}
+		name := path(ev)[0]
+		points, ok := pointsBySeries[name]
+		if !ok {
+			points = make([]float64, binCount)
+			pointsBySeries[name] = points
+			seriesNames = append(seriesNames, name)
+		}
+		points[int(ev.Time.Sub(qf.start)/binWidth)] += ev.Weight
+	}
+	sort.Strings(seriesNames)
+	var yExtents []float64
+	for _, points := range pointsBySeries {
+		yExtents = append(yExtents, points...)
+	}
+	chart := xychart.New(series,
+		continuousaxis.NewTimestampAxis(category.New("x_axis", "Time", "Event time"), qf.start, qf.end),
+		continuousaxis.NewDoubleAxis(category.New("y_axis", "Weight", "Total event weight"), yExtents...),
+	)
+	for _, name := range seriesNames {
+		s := chart.AddSeries(pathCategory([]string{name}))
+		for idx, val := range pointsBySeries[name] {
+			s.WithPoint(qf.start.Add(time.Duration(idx)*binWidth), val)
+		}
+	}
+	return nil
+}
+
+// treeNode is a node in a tree of category paths.
+type treeNode struct {
+	weight   float64
+	children map[string]*treeNode
+}
+
+func (tn *treeNode) emit(name string, parent func(float64, ...util.PropertyUpdate) *weightedtree.Node) {
+	node := parent(tn.weight, util.StringProperty(nameKey, name))
+	names := make([]string, 0, len(tn.children))
+	for childName := range tn.children {
+		names = append(names, childName)
+	}
+	sort.Strings(names)
+	for _, childName := range names {
+		tn.children[childName].emit(childName, node.Node)
+	}
+}
+
+func handleTreeQuery(qf *queryFilters, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	for key := range reqOpts {
+		return fmt.Errorf("unsupported option '%s'", key)
+	}
+	root := &treeNode{children: map[string]*treeNode{}}
+	for _, ev := range qf.events() {
+		node := root
+		for _, name := range path(ev) {
+			child, ok := node.children[name]
+			if !ok {
+				child = &treeNode{children: map[string]*treeNode{}}
+				node.children[name] = child
+			}
+			node = child
+		}
+		node.weight += ev.Weight
+	}
+	tree := weightedtree.New(series, TreeRenderSettings)
+	names := make([]string, 0, len(root.children))
+	for name := range root.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		root.children[name].emit(name, tree.Node)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package eventtable loads tables of events, one per row, and serves them as
+// traces, tables, timeseries, and weighted trees.  It is a 'bring your own
+// events' path for users with event data but no custom data source: a small
+// Config declares which columns hold each event's timestamp, duration,
+// category path, weight, and properties.  For example, the Config
+//
+//	{
+//	  "TimestampColumn": "start",
+//	  "DurationColumn": "latency_ms",
+//	  "DurationUnit": "ms",
+//	  "CategoryColumns": ["service", "method"],
+//	  "PropertyColumns": ["status"]
+//	}
+//
+// describes a CSV file like
+//
+//	start,service,method,latency_ms,status
+//	2023-01-01T00:00:00Z,frontend,GET,12.5,200
+//
+// CSV, TSV, and flat Parquet files are read directly.  Other tabular formats
+// may be read with Load and a RowReader adapting a reader for that format.
+package eventtable
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config declares the roles of an event table's columns.  Columns it does not
+// name are ignored.
+type Config struct {
+	// The column holding each event's start time.  Required.
+	TimestampColumn string
+	// How timestamps are represented: 'rfc3339' (the default) for RFC 3339
+	// timestamps, or one of 's', 'ms', 'us', or 'ns' for numeric offsets, in
+	// that unit, from the Unix epoch.
+	TimestampFormat string
+	// The column holding each event's duration.  If empty, events are
+	// instantaneous.
+	DurationColumn string
+	// The unit of numeric durations: one of 's', 'ms', 'us', or 'ns' (the
+	// default).  Durations may also be given in Go duration syntax, e.g.
+	// '1.5ms'.
+	DurationUnit string
+	// The columns holding each event's category path, outermost first.
+	CategoryColumns []string
+	// The column holding each event's numeric weight.  If empty, each event
+	// has weight 1.
+	WeightColumn string
+	// The columns holding additional event properties.
+	PropertyColumns []string
+}
+
+// ReadConfig reads a JSON-encoded Config from the provided Reader.
+func ReadConfig(r io.Reader) (*Config, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	cfg := &Config{}
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to read event table config: %s", err)
+	}
+	return cfg, nil
+}
+
+var units = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+func unit(name, def string) (time.Duration, error) {
+	if name == "" {
+		name = def
+	}
+	u, ok := units[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported unit '%s'", name)
+	}
+	return u, nil
+}
+
+// Event is a single row of an event table.
+type Event struct {
+	Time     time.Time
+	Duration time.Duration
+	// The event's category path, outermost first.
+	Path   []string
+	Weight float64
+	// The event's property values, in Config.PropertyColumns order.
+	Properties []string
+}
+
+// End returns the receiver's end time.
+func (e *Event) End() time.Time {
+	return e.Time.Add(e.Duration)
+}
+
+// Table is a loaded event table.
+type Table struct {
+	Config *Config
+	// The table's events, in increasing time order.
+	Events []*Event
+	// The earliest event start and latest event end.
+	Start, End time.Time
+}
+
+// RowReader reads rows of string cells.  *csv.Reader is a RowReader.
+type RowReader interface {
+	// Returns the next row, or io.EOF if there are no more rows.
+	Read() ([]string, error)
+}
+
+// rowParser extracts Events from rows given a header row.
+type rowParser struct {
+	cfg                              *Config
+	timestampUnit, durationUnit      time.Duration
+	timestampCol, durationCol, wtCol int
+	categoryCols, propertyCols       []int
+}
+
+func newRowParser(cfg *Config, header []string) (*rowParser, error) {
+	cols := map[string]int{}
+	for idx, name := range header {
+		cols[strings.TrimSpace(name)] = idx
+	}
+	col := func(name string, required bool) (int, error) {
+		if name == "" && !required {
+			return -1, nil
+		}
+		idx, ok := cols[name]
+		if !ok {
+			return 0, fmt.Errorf("event table has no column '%s'", name)
+		}
+		return idx, nil
+	}
+	rp := &rowParser{cfg: cfg}
+	var err error
+	if rp.timestampCol, err = col(cfg.TimestampColumn, true); err != nil {
+		return nil, err
+	}
+	if rp.durationCol, err = col(cfg.DurationColumn, false); err != nil {
+		return nil, err
+	}
+	if rp.wtCol, err = col(cfg.WeightColumn, false); err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.CategoryColumns {
+		idx, err := col(name, true)
+		if err != nil {
+			return nil, err
+		}
+		rp.categoryCols = append(rp.categoryCols, idx)
+	}
+	for _, name := range cfg.PropertyColumns {
+		idx, err := col(name, true)
+		if err != nil {
+			return nil, err
+		}
+		rp.propertyCols = append(rp.propertyCols, idx)
+	}
+	if cfg.TimestampFormat != "" && cfg.TimestampFormat != "rfc3339" {
+		if rp.timestampUnit, err = unit(cfg.TimestampFormat, ""); err != nil {
+			return nil, fmt.Errorf("bad timestamp format: %s", err)
+		}
+	}
+	if rp.durationUnit, err = unit(cfg.DurationUnit, "ns"); err != nil {
+		return nil, fmt.Errorf("bad duration unit: %s", err)
+	}
+	return rp, nil
+}
+
+func (rp *rowParser) cell(row []string, idx int) (string, error) {
+	if idx >= len(row) {
+		return "", fmt.Errorf("row has %d cells, want at least %d", len(row), idx+1)
+	}
+	return strings.TrimSpace(row[idx]), nil
+}
+
+func (rp *rowParser) parse(row []string) (*Event, error) {
+	ev := &Event{
+		Weight: 1,
+	}
+	cell, err := rp.cell(row, rp.timestampCol)
+	if err != nil {
+		return nil, err
+	}
+	if rp.timestampUnit == 0 {
+		if ev.Time, err = time.Parse(time.RFC3339Nano, cell); err != nil {
+			return nil, fmt.Errorf("bad timestamp '%s'", cell)
+		}
+	} else {
+		offset, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad timestamp '%s'", cell)
+		}
+		ev.Time = time.Unix(0, int64(offset*float64(rp.timestampUnit))).UTC()
+	}
+	if rp.durationCol >= 0 {
+		if cell, err = rp.cell(row, rp.durationCol); err != nil {
+			return nil, err
+		}
+		if num, err := strconv.ParseFloat(cell, 64); err == nil {
+			ev.Duration = time.Duration(num * float64(rp.durationUnit))
+		} else if ev.Duration, err = time.ParseDuration(cell); err != nil {
+			return nil, fmt.Errorf("bad duration '%s'", cell)
+		}
+		if ev.Duration < 0 {
+			return nil, fmt.Errorf("negative duration '%s'", cell)
+		}
+	}
+	if rp.wtCol >= 0 {
+		if cell, err = rp.cell(row, rp.wtCol); err != nil {
+			return nil, err
+		}
+		if ev.Weight, err = strconv.ParseFloat(cell, 64); err != nil {
+			return nil, fmt.Errorf("bad weight '%s'", cell)
+		}
+	}
+	for _, idx := range rp.categoryCols {
+		if cell, err = rp.cell(row, idx); err != nil {
+			return nil, err
+		}
+		ev.Path = append(ev.Path, cell)
+	}
+	for _, idx := range rp.propertyCols {
+		if cell, err = rp.cell(row, idx); err != nil {
+			return nil, err
+		}
+		ev.Properties = append(ev.Properties, cell)
+	}
+	return ev, nil
+}
+
+// Load reads an event table, whose first row is a header naming its columns,
+// from the provided RowReader, interpreting it according to the provided
+// Config.
+func Load(cfg *Config, rr RowReader) (*Table, error) {
+	header, err := rr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event table header: %s", err)
+	}
+	rp, err := newRowParser(cfg, header)
+	if err != nil {
+		return nil, err
+	}
+	t := &Table{
+		Config: cfg,
+	}
+	for rowNum := 2; ; rowNum++ {
+		row, err := rr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ev, err := rp.parse(row)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %s", rowNum, err)
+		}
+		if len(t.Events) == 0 || ev.Time.Before(t.Start) {
+			t.Start = ev.Time
+		}
+		if len(t.Events) == 0 || ev.End().After(t.End) {
+			t.End = ev.End()
+		}
+		t.Events = append(t.Events, ev)
+	}
+	sort.SliceStable(t.Events, func(a, b int) bool {
+		return t.Events[a].Time.Before(t.Events[b].Time)
+	})
+	return t, nil
+}
+
+// LoadFile reads the specified CSV (with a '.csv' extension), TSV (with a
+// '.tsv' extension), or Parquet (with a '.parquet' extension) event table
+// file, interpreting it according to the provided Config.
+func LoadFile(cfg *Config, filename string) (*Table, error) {
+	var delimiter rune
+	switch ext := strings.ToLower(filepath.Ext(filename)); ext {
+	case ".csv":
+		delimiter = ','
+	case ".tsv":
+		delimiter = '\t'
+	case ".parquet":
+	default:
+		return nil, fmt.Errorf("unsupported event table file type '%s'; use Load with a RowReader for that format", ext)
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if delimiter == 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		pr, err := NewParquetReader(file, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		return Load(cfg, pr)
+	}
+	r := csv.NewReader(file)
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = delimiter == '\t'
+	return Load(cfg, r)
+}
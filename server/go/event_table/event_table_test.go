@@ -0,0 +1,227 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package eventtable
+
+import (
+	"context"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+const (
+	configJSON = `{
+  "TimestampColumn": "start",
+  "DurationColumn": "latency_ms",
+  "DurationUnit": "ms",
+  "CategoryColumns": ["service", "method"],
+  "PropertyColumns": ["status"]
+}`
+	events = `start,service,method,latency_ms,status
+2023-01-01T00:00:02Z,frontend,GET,1000,200
+2023-01-01T00:00:00Z,frontend,POST,500,500
+2023-01-01T00:00:01Z,backend,GET,1500,200
+`
+)
+
+var epoch = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func sec(s float64) time.Time {
+	return epoch.Add(time.Duration(s * float64(time.Second)))
+}
+
+func loadTestTable(t *testing.T) *Table {
+	t.Helper()
+	cfg, err := ReadConfig(strings.NewReader(configJSON))
+	if err != nil {
+		t.Fatalf("ReadConfig() yielded unexpected error %s", err)
+	}
+	tbl, err := Load(cfg, csv.NewReader(strings.NewReader(events)))
+	if err != nil {
+		t.Fatalf("Load() yielded unexpected error %s", err)
+	}
+	return tbl
+}
+
+func TestLoad(t *testing.T) {
+	tbl := loadTestTable(t)
+	wantEvents := []*Event{{
+		Time: sec(0), Duration: 500 * time.Millisecond, Path: []string{"frontend", "POST"}, Weight: 1, Properties: []string{"500"},
+	}, {
+		Time: sec(1), Duration: 1500 * time.Millisecond, Path: []string{"backend", "GET"}, Weight: 1, Properties: []string{"200"},
+	}, {
+		Time: sec(2), Duration: time.Second, Path: []string{"frontend", "GET"}, Weight: 1, Properties: []string{"200"},
+	}}
+	if diff := cmp.Diff(wantEvents, tbl.Events); diff != "" {
+		t.Errorf("Load() yielded unexpected events (-want +got):\n%s", diff)
+	}
+	if !tbl.Start.Equal(sec(0)) || !tbl.End.Equal(sec(3)) {
+		t.Errorf("Load() yielded extent %v-%v, want %v-%v", tbl.Start, tbl.End, sec(0), sec(3))
+	}
+	for _, test := range []struct {
+		description string
+		cfg         *Config
+		events      string
+	}{{
+		description: "missing column",
+		cfg:         &Config{TimestampColumn: "time"},
+		events:      "start\n2023-01-01T00:00:00Z\n",
+	}, {
+		description: "bad timestamp",
+		cfg:         &Config{TimestampColumn: "start", TimestampFormat: "ms"},
+		events:      "start\nyesterday\n",
+	}, {
+		description: "bad duration unit",
+		cfg:         &Config{TimestampColumn: "start", DurationColumn: "dur", DurationUnit: "fortnights"},
+		events:      "start,dur\n0,1\n",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if _, err := Load(test.cfg, csv.NewReader(strings.NewReader(test.events))); err == nil {
+				t.Errorf("Load() yielded no error")
+			}
+		})
+	}
+}
+
+func TestDataSource(t *testing.T) {
+	ds := NewDataSource("events", map[string]*Table{"requests": loadTestTable(t)})
+	globalFilters := map[string]*util.V{
+		collectionNameKey: util.StringValue("requests"),
+	}
+	xAxis := func(start, end time.Time) *continuousaxis.Axis[time.Time] {
+		return continuousaxis.NewTimestampAxis(category.New("x_axis", "Time", "Event time"), start, end)
+	}
+	for _, test := range []struct {
+		description   string
+		globalFilters map[string]*util.V
+		req           *util.DataSeriesRequest
+		wantErr       bool
+		buildWant     func(db util.DataBuilder)
+	}{{
+		description: "trace",
+		req: &util.DataSeriesRequest{
+			QueryName: "events.trace",
+		},
+		buildWant: func(db util.DataBuilder) {
+			tr := trace.New(db, xAxis(sec(0), sec(3)), TraceRenderSettings)
+			frontend := tr.Category(pathCategory([]string{"frontend"}))
+			frontend.Category(pathCategory([]string{"frontend", "POST"})).
+				Span(sec(0), sec(.5), util.StringProperty("status", "500"))
+			tr.Category(pathCategory([]string{"backend"})).
+				Category(pathCategory([]string{"backend", "GET"})).
+				Span(sec(1), sec(2.5), util.StringProperty("status", "200"))
+			frontend.Category(pathCategory([]string{"frontend", "GET"})).
+				Span(sec(2), sec(3), util.StringProperty("status", "200"))
+		},
+	}, {
+		description: "table filtered by time",
+		globalFilters: map[string]*util.V{
+			startTimestampKey: util.TimestampValue(sec(2.6)),
+		},
+		req: &util.DataSeriesRequest{
+			QueryName: "events.table",
+		},
+		buildWant: func(db util.DataBuilder) {
+			col := func(id string) *table.ColumnUpdate {
+				return table.Column(category.New(id, id, id))
+			}
+			start, latency, service, method, status := col("start"), col("latency_ms"), col("service"), col("method"), col("status")
+			table.New(db, nil, start, latency, service, method, status).Row(
+				table.Cell(start, util.Timestamp(sec(2))),
+				table.Cell(latency, util.Duration(time.Second)),
+				table.Cell(service, util.String("frontend")),
+				table.Cell(method, util.String("GET")),
+				table.Cell(status, util.String("200")),
+			)
+		},
+	}, {
+		description: "timeseries",
+		req: &util.DataSeriesRequest{
+			QueryName: "events.timeseries",
+			Options: map[string]*util.V{
+				binCountKey: util.IntegerValue(2),
+			},
+		},
+		buildWant: func(db util.DataBuilder) {
+			chart := xychart.New(db, xAxis(sec(0), sec(3)),
+				continuousaxis.NewDoubleAxis(category.New("y_axis", "Weight", "Total event weight"), 1, 0, 2, 0))
+			chart.AddSeries(pathCategory([]string{"backend"})).
+				WithPoint(sec(0), 1).
+				WithPoint(sec(3), 0)
+			chart.AddSeries(pathCategory([]string{"frontend"})).
+				WithPoint(sec(0), 2).
+				WithPoint(sec(3), 0)
+		},
+	}, {
+		description: "timeseries without bin count",
+		req: &util.DataSeriesRequest{
+			QueryName: "events.timeseries",
+		},
+		wantErr: true,
+	}, {
+		description: "tree",
+		req: &util.DataSeriesRequest{
+			QueryName: "events.tree",
+		},
+		buildWant: func(db util.DataBuilder) {
+			tree := weightedtree.New(db, TreeRenderSettings)
+			tree.Node(0, util.StringProperty(nameKey, "backend")).
+				Node(1, util.StringProperty(nameKey, "GET"))
+			frontend := tree.Node(0, util.StringProperty(nameKey, "frontend"))
+			frontend.Node(1, util.StringProperty(nameKey, "GET"))
+			frontend.Node(1, util.StringProperty(nameKey, "POST"))
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			gf := map[string]*util.V{}
+			for k, v := range globalFilters {
+				gf[k] = v
+			}
+			for k, v := range test.globalFilters {
+				gf[k] = v
+			}
+			drb := util.NewDataResponseBuilder()
+			err := ds.HandleDataSeriesRequests(context.Background(), gf, drb, []*util.DataSeriesRequest{test.req})
+			if (err != nil) != test.wantErr {
+				t.Fatalf("HandleDataSeriesRequests() yielded error %v, wanted error: %t", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, err := drb.Data()
+			if err != nil {
+				t.Fatalf("Data() yielded unexpected error %s", err)
+			}
+			wantDrb := util.NewDataResponseBuilder()
+			test.buildWant(wantDrb.DataSeries(test.req))
+			want, err := wantDrb.Data()
+			if err != nil {
+				t.Fatalf("Data() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(want.PrettyPrint(), got.PrettyPrint()); diff != "" {
+				t.Errorf("HandleDataSeriesRequests() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
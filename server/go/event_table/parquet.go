@@ -0,0 +1,855 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package eventtable
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// Parquet physical types.
+const (
+	parquetBoolean           = 0
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetInt96             = 3
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+)
+
+// Parquet encodings.
+const (
+	parquetPlain                = 0
+	parquetPlainDictionary      = 2
+	parquetRLE                  = 3
+	parquetDeltaBinaryPacked    = 5
+	parquetDeltaLengthByteArray = 6
+	parquetDeltaByteArray       = 7
+	parquetRLEDictionary        = 8
+	parquetByteStreamSplit      = 9
+)
+
+// Parquet compression codecs.
+const (
+	parquetUncompressed = 0
+	parquetSnappy       = 1
+	parquetGzip         = 2
+)
+
+// Parquet converted types, which older writers use in place of logical types.
+const (
+	parquetConvertedDecimal         = 5
+	parquetConvertedDate            = 6
+	parquetConvertedTimestampMillis = 9
+	parquetConvertedTimestampMicros = 10
+	parquetConvertedUint8           = 11
+	parquetConvertedUint64          = 14
+)
+
+// Parquet repetition types.
+const (
+	parquetOptional = 1
+	parquetRepeated = 2
+)
+
+const (
+	parquetMagic = "PAR1"
+	// The maximum size of Parquet metadata and of any decompressed page, and
+	// the maximum number of values in a page, guarding against malicious
+	// files.
+	maxParquetMetadataBytes = 64 << 20
+	maxParquetPageBytes     = 256 << 20
+	maxParquetPageValues    = 1 << 24
+	// The Julian day number of the Unix epoch, for INT96 timestamps.
+	julianUnixEpoch = 2440588
+)
+
+// parquetColumn is a column of a flat Parquet table.
+type parquetColumn struct {
+	name     string
+	typ      int
+	optional bool
+	// The width of FIXED_LEN_BYTE_ARRAY values.
+	typeLength int
+	// If positive, integer values are timestamps in this unit.
+	timeUnit time.Duration
+	// If true, integer values are days since the Unix epoch.
+	date bool
+	// If true, values are decimals with the specified scale.
+	decimal bool
+	scale   int
+	// If true, integer values are unsigned.
+	unsigned bool
+}
+
+func newParquetColumn(se *parquetSchemaElement) (*parquetColumn, error) {
+	if se.numChildren > 0 || se.repetition == parquetRepeated {
+		return nil, fmt.Errorf("Parquet column '%s' is nested or repeated; only flat tables are supported", se.name)
+	}
+	col := &parquetColumn{
+		name:       se.name,
+		typ:        se.typ,
+		optional:   se.repetition == parquetOptional,
+		typeLength: se.typeLength,
+		scale:      se.scale,
+	}
+	if col.typ < parquetBoolean || col.typ > parquetFixedLenByteArray {
+		return nil, fmt.Errorf("Parquet column '%s' has unsupported type %d", se.name, se.typ)
+	}
+	if col.typ == parquetFixedLenByteArray && col.typeLength <= 0 {
+		return nil, fmt.Errorf("Parquet column '%s' has bad length %d", se.name, se.typeLength)
+	}
+	switch se.convertedType {
+	case parquetConvertedDecimal:
+		col.decimal = true
+	case parquetConvertedDate:
+		col.date = true
+	case parquetConvertedTimestampMillis:
+		col.timeUnit = time.Millisecond
+	case parquetConvertedTimestampMicros:
+		col.timeUnit = time.Microsecond
+	}
+	if se.convertedType >= parquetConvertedUint8 && se.convertedType <= parquetConvertedUint64 {
+		col.unsigned = true
+	}
+	if lt := se.logicalType; lt != nil {
+		switch lt.kind {
+		case 5:
+			col.decimal, col.scale = true, lt.scale
+		case 6:
+			col.date = true
+		case 8:
+			col.timeUnit = map[int16]time.Duration{1: time.Millisecond, 2: time.Microsecond, 3: time.Nanosecond}[lt.unit]
+		case 10:
+			col.unsigned = lt.unsigned
+		}
+	}
+	return col, nil
+}
+
+// formatInt formats the provided integer value of the receiver.
+func (col *parquetColumn) formatInt(val int64) string {
+	switch {
+	case col.timeUnit > 0:
+		return time.Unix(0, val*int64(col.timeUnit)).UTC().Format(time.RFC3339Nano)
+	case col.date:
+		return time.Unix(val*24*60*60, 0).UTC().Format("2006-01-02")
+	case col.decimal:
+		return formatDecimal(big.NewInt(val), col.scale)
+	case col.unsigned && col.typ == parquetInt32:
+		return strconv.FormatUint(uint64(uint32(val)), 10)
+	case col.unsigned:
+		return strconv.FormatUint(uint64(val), 10)
+	}
+	return strconv.FormatInt(val, 10)
+}
+
+// formatBytes formats the provided byte array value of the receiver.
+func (col *parquetColumn) formatBytes(val []byte) string {
+	if !col.decimal {
+		return string(val)
+	}
+	// Decimals are big-endian two's complement integers.
+	unscaled := new(big.Int).SetBytes(val)
+	if len(val) > 0 && val[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(val))))
+	}
+	return formatDecimal(unscaled, col.scale)
+}
+
+// formatDecimal formats the provided unscaled decimal value with the
+// specified scale.
+func formatDecimal(unscaled *big.Int, scale int) string {
+	if scale <= 0 {
+		return unscaled.String()
+	}
+	digits := new(big.Int).Abs(unscaled).String()
+	if len(digits) <= scale {
+		digits = string(bytes.Repeat([]byte("0"), scale-len(digits)+1)) + digits
+	}
+	ret := digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+	if unscaled.Sign() < 0 {
+		ret = "-" + ret
+	}
+	return ret
+}
+
+// decodePlain decodes the specified number of PLAIN-encoded values of the
+// receiver from the provided buffer.
+func (col *parquetColumn) decodePlain(buf []byte, n int) ([]string, error) {
+	ret := make([]string, 0, n)
+	width := map[int]int{
+		parquetInt32:             4,
+		parquetInt64:             8,
+		parquetInt96:             12,
+		parquetFloat:             4,
+		parquetDouble:            8,
+		parquetFixedLenByteArray: col.typeLength,
+	}[col.typ]
+	switch col.typ {
+	case parquetBoolean:
+		if len(buf) < (n+7)/8 {
+			return nil, fmt.Errorf("truncated page")
+		}
+		for idx := 0; idx < n; idx++ {
+			ret = append(ret, strconv.FormatBool(buf[idx/8]&(1<<(idx%8)) != 0))
+		}
+		return ret, nil
+	case parquetByteArray:
+		for idx := 0; idx < n; idx++ {
+			if len(buf) < 4 {
+				return nil, fmt.Errorf("truncated page")
+			}
+			valLen := binary.LittleEndian.Uint32(buf)
+			if uint64(valLen) > uint64(len(buf)-4) {
+				return nil, fmt.Errorf("truncated page")
+			}
+			ret = append(ret, col.formatBytes(buf[4:4+valLen]))
+			buf = buf[4+valLen:]
+		}
+		return ret, nil
+	}
+	if len(buf)/width < n {
+		return nil, fmt.Errorf("truncated page")
+	}
+	for idx := 0; idx < n; idx++ {
+		val := buf[idx*width : (idx+1)*width]
+		var cell string
+		switch col.typ {
+		case parquetInt32:
+			cell = col.formatInt(int64(int32(binary.LittleEndian.Uint32(val))))
+		case parquetInt64:
+			cell = col.formatInt(int64(binary.LittleEndian.Uint64(val)))
+		case parquetInt96:
+			// Legacy timestamps: nanoseconds within the day, then the Julian day.
+			nanos := int64(binary.LittleEndian.Uint64(val))
+			days := int64(binary.LittleEndian.Uint32(val[8:])) - julianUnixEpoch
+			cell = time.Unix(days*24*60*60, nanos).UTC().Format(time.RFC3339Nano)
+		case parquetFloat:
+			cell = strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(val))), 'g', -1, 32)
+		case parquetDouble:
+			cell = strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(val)), 'g', -1, 64)
+		case parquetFixedLenByteArray:
+			if col.decimal {
+				cell = col.formatBytes(val)
+			} else {
+				cell = hex.EncodeToString(val)
+			}
+		}
+		ret = append(ret, cell)
+	}
+	return ret, nil
+}
+
+// decodeValues decodes the specified number of values of the receiver, in the
+// specified encoding, from the provided buffer, using the provided dictionary
+// if the values are dictionary-encoded.
+func (col *parquetColumn) decodeValues(encoding int, buf []byte, n int, dict []string) ([]string, error) {
+	if n < 0 || n > maxParquetPageValues {
+		return nil, fmt.Errorf("bad value count %d", n)
+	}
+	switch encoding {
+	case parquetPlain:
+		return col.decodePlain(buf, n)
+	case parquetPlainDictionary, parquetRLEDictionary:
+		if len(buf) < 1 {
+			return nil, fmt.Errorf("truncated page")
+		}
+		indices, err := decodeHybrid(buf[1:], int(buf[0]), n)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]string, len(indices))
+		for idx, dictIdx := range indices {
+			if dictIdx >= len(dict) {
+				return nil, fmt.Errorf("dictionary index %d out of range", dictIdx)
+			}
+			ret[idx] = dict[dictIdx]
+		}
+		return ret, nil
+	case parquetRLE:
+		if col.typ != parquetBoolean {
+			break
+		}
+		// Booleans are prefixed by their length.
+		if len(buf) < 4 {
+			return nil, fmt.Errorf("truncated page")
+		}
+		bits, err := decodeHybrid(buf[4:], 1, n)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]string, len(bits))
+		for idx, bit := range bits {
+			ret[idx] = strconv.FormatBool(bit != 0)
+		}
+		return ret, nil
+	case parquetDeltaBinaryPacked:
+		if col.typ != parquetInt32 && col.typ != parquetInt64 {
+			break
+		}
+		vals, _, err := decodeDeltaBinaryPacked(buf, n)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]string, len(vals))
+		for idx, val := range vals {
+			if col.typ == parquetInt32 {
+				val = int64(int32(val))
+			}
+			ret[idx] = col.formatInt(val)
+		}
+		return ret, nil
+	case parquetDeltaLengthByteArray, parquetDeltaByteArray:
+		if col.typ != parquetByteArray {
+			break
+		}
+		var prefixLens []int64
+		if encoding == parquetDeltaByteArray {
+			var err error
+			var k int
+			if prefixLens, k, err = decodeDeltaBinaryPacked(buf, n); err != nil {
+				return nil, err
+			}
+			buf = buf[k:]
+		}
+		lens, k, err := decodeDeltaBinaryPacked(buf, n)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[k:]
+		ret := make([]string, n)
+		var prev []byte
+		for idx, valLen := range lens {
+			if valLen < 0 || valLen > int64(len(buf)) {
+				return nil, fmt.Errorf("truncated page")
+			}
+			val := buf[:valLen]
+			buf = buf[valLen:]
+			if prefixLens != nil {
+				if prefixLens[idx] < 0 || prefixLens[idx] > int64(len(prev)) {
+					return nil, fmt.Errorf("bad prefix length %d", prefixLens[idx])
+				}
+				val = append(append([]byte{}, prev[:prefixLens[idx]]...), val...)
+			}
+			ret[idx], prev = col.formatBytes(val), val
+		}
+		return ret, nil
+	case parquetByteStreamSplit:
+		// The Kth bytes of each value are stored together, so reassemble the
+		// values and decode them as PLAIN-encoded.
+		width := map[int]int{
+			parquetInt32:             4,
+			parquetInt64:             8,
+			parquetFloat:             4,
+			parquetDouble:            8,
+			parquetFixedLenByteArray: col.typeLength,
+		}[col.typ]
+		if width == 0 {
+			break
+		}
+		if len(buf)/width < n {
+			return nil, fmt.Errorf("truncated page")
+		}
+		plain := make([]byte, n*width)
+		for idx := range plain {
+			plain[idx] = buf[(idx%width)*n+idx/width]
+		}
+		return col.decodePlain(plain, n)
+	default:
+		return nil, fmt.Errorf("unsupported encoding %d", encoding)
+	}
+	return nil, fmt.Errorf("encoding %d is unsupported for type %d", encoding, col.typ)
+}
+
+// decodeDeltaBinaryPacked decodes the specified number of values from the
+// provided buffer in Parquet's DELTA_BINARY_PACKED encoding, returning them
+// and the number of bytes they occupied.
+func decodeDeltaBinaryPacked(buf []byte, n int) ([]int64, int, error) {
+	pos := 0
+	uvarint := func() (uint64, error) {
+		val, k := binary.Uvarint(buf[pos:])
+		if k <= 0 {
+			return 0, fmt.Errorf("truncated page")
+		}
+		pos += k
+		return val, nil
+	}
+	varint := func() (int64, error) {
+		val, err := uvarint()
+		return int64(val>>1) ^ -int64(val&1), err
+	}
+	blockSize, err := uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	miniblocks, err := uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := uvarint()
+	if err != nil {
+		return nil, 0, err
+	}
+	first, err := varint()
+	if err != nil {
+		return nil, 0, err
+	}
+	if miniblocks == 0 || blockSize == 0 || blockSize%(8*miniblocks) != 0 || blockSize > maxParquetPageBytes {
+		return nil, 0, fmt.Errorf("bad delta block size %d with %d miniblocks", blockSize, miniblocks)
+	}
+	if total < uint64(n) {
+		return nil, 0, fmt.Errorf("page has %d values, want %d", total, n)
+	}
+	if n == 0 {
+		return nil, pos, nil
+	}
+	miniblockSize := blockSize / miniblocks
+	ret := make([]int64, 1, n)
+	ret[0] = first
+	for len(ret) < n {
+		minDelta, err := varint()
+		if err != nil {
+			return nil, 0, err
+		}
+		if uint64(len(buf)-pos) < miniblocks {
+			return nil, 0, fmt.Errorf("truncated page")
+		}
+		bitWidths := buf[pos : pos+int(miniblocks)]
+		pos += int(miniblocks)
+		for _, bitWidth := range bitWidths {
+			if len(ret) >= n {
+				break
+			}
+			if bitWidth > 64 {
+				return nil, 0, fmt.Errorf("bad bit width %d", bitWidth)
+			}
+			packedLen := int(miniblockSize) * int(bitWidth) / 8
+			if len(buf)-pos < packedLen {
+				return nil, 0, fmt.Errorf("truncated page")
+			}
+			packed := buf[pos : pos+packedLen]
+			pos += packedLen
+			for idx := 0; idx < int(miniblockSize) && len(ret) < n; idx++ {
+				var delta uint64
+				for bit := 0; bit < int(bitWidth); bit++ {
+					bitPos := idx*int(bitWidth) + bit
+					delta |= uint64(packed[bitPos/8]>>(bitPos%8)&1) << bit
+				}
+				// Arithmetic wraps, as values may span the full range.
+				ret = append(ret, int64(uint64(ret[len(ret)-1])+uint64(minDelta)+delta))
+			}
+		}
+	}
+	return ret, pos, nil
+}
+
+// decodeHybrid decodes the specified number of values of the specified bit
+// width from the provided buffer in Parquet's RLE/bit-packing hybrid
+// encoding.
+func decodeHybrid(buf []byte, bitWidth, n int) ([]int, error) {
+	if bitWidth > 32 {
+		return nil, fmt.Errorf("bad bit width %d", bitWidth)
+	}
+	ret := make([]int, 0, n)
+	byteWidth := (bitWidth + 7) / 8
+	for len(ret) < n {
+		header, k := binary.Uvarint(buf)
+		if k <= 0 {
+			return nil, fmt.Errorf("truncated page")
+		}
+		buf = buf[k:]
+		if header&1 == 0 {
+			// A run of a repeated value.
+			if len(buf) < byteWidth {
+				return nil, fmt.Errorf("truncated page")
+			}
+			val := 0
+			for idx := 0; idx < byteWidth; idx++ {
+				val |= int(buf[idx]) << (8 * idx)
+			}
+			buf = buf[byteWidth:]
+			for count := header >> 1; count > 0 && len(ret) < n; count-- {
+				ret = append(ret, val)
+			}
+			continue
+		}
+		// Groups of eight bit-packed values, least significant bit first.
+		count := (header >> 1) * 8
+		packedLen := uint64(len(buf))
+		if bits := count * uint64(bitWidth); bits/8 < packedLen {
+			packedLen = bits / 8
+		}
+		for idx := uint64(0); idx < count && len(ret) < n; idx++ {
+			val := 0
+			for bit := 0; bit < bitWidth; bit++ {
+				pos := idx*uint64(bitWidth) + uint64(bit)
+				if pos/8 >= packedLen {
+					return nil, fmt.Errorf("truncated page")
+				}
+				val |= int(buf[pos/8]>>(pos%8)&1) << bit
+			}
+			ret = append(ret, val)
+		}
+		buf = buf[packedLen:]
+	}
+	return ret, nil
+}
+
+// decompress decompresses the provided page data, which has the specified
+// uncompressed size, with the specified codec.
+func decompress(codec int, data []byte, size int) ([]byte, error) {
+	if size < 0 || size > maxParquetPageBytes {
+		return nil, fmt.Errorf("bad page size %d", size)
+	}
+	var ret []byte
+	switch codec {
+	case parquetUncompressed:
+		ret = data
+	case parquetSnappy:
+		var err error
+		if ret, err = decodeSnappy(data, size); err != nil {
+			return nil, err
+		}
+	case parquetGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if ret, err = io.ReadAll(io.LimitReader(r, int64(size)+1)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Parquet compression codec %d; use uncompressed, Snappy, or gzip", codec)
+	}
+	if len(ret) != size {
+		return nil, fmt.Errorf("page has %d bytes, want %d", len(ret), size)
+	}
+	return ret, nil
+}
+
+// decodeSnappy decodes the provided Snappy block, whose decoded length must be
+// the specified size.
+func decodeSnappy(src []byte, size int) ([]byte, error) {
+	decodedLen, n := binary.Uvarint(src)
+	if n <= 0 || decodedLen != uint64(size) {
+		return nil, fmt.Errorf("bad Snappy block")
+	}
+	src = src[n:]
+	dst := make([]byte, 0, size)
+	for len(src) > 0 {
+		tag := src[0]
+		src = src[1:]
+		var length, offset int
+		switch tag & 0x03 {
+		case 0:
+			// A literal, whose length may follow the tag.
+			length = int(tag >> 2)
+			if length >= 60 {
+				lenBytes := length - 59
+				if len(src) < lenBytes {
+					return nil, fmt.Errorf("bad Snappy block")
+				}
+				length = 0
+				for idx := 0; idx < lenBytes; idx++ {
+					length |= int(src[idx]) << (8 * idx)
+				}
+				src = src[lenBytes:]
+			}
+			length++
+			if length > len(src) || length > size-len(dst) {
+				return nil, fmt.Errorf("bad Snappy block")
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1:
+			if len(src) < 1 {
+				return nil, fmt.Errorf("bad Snappy block")
+			}
+			length = 4 + int(tag>>2&0x07)
+			offset = int(tag&0xe0)<<3 | int(src[0])
+			src = src[1:]
+		case 2:
+			if len(src) < 2 {
+				return nil, fmt.Errorf("bad Snappy block")
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src))
+			src = src[2:]
+		case 3:
+			if len(src) < 4 {
+				return nil, fmt.Errorf("bad Snappy block")
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src))
+			src = src[4:]
+		}
+		// Copies may overlap their output, so are made byte by byte.
+		if offset <= 0 || offset > len(dst) || length > size-len(dst) {
+			return nil, fmt.Errorf("bad Snappy block")
+		}
+		for start := len(dst) - offset; length > 0; length-- {
+			dst = append(dst, dst[start])
+			start++
+		}
+	}
+	return dst, nil
+}
+
+// ParquetReader is a RowReader over a Parquet file holding a flat table,
+// whose columns are neither nested nor repeated.  Its first row is a header
+// naming the table's columns; each subsequent row holds one record's values,
+// formatted as strings.  Null values are empty.  Timestamps are formatted in
+// RFC 3339 format, dates as YYYY-MM-DD, and fixed-length byte arrays other
+// than decimals in hexadecimal.  Pages may be uncompressed, or
+// compressed with Snappy or gzip, and PLAIN- or dictionary-encoded.  Records
+// are read one row group at a time.
+type ParquetReader struct {
+	r       io.ReaderAt
+	size    int64
+	md      *parquetFileMetaData
+	columns []*parquetColumn
+	// True once the header has been read.
+	readHeader bool
+	// The next row group to read.
+	nextRowGroup int
+	// The current row group's cells, by column, and the next row to return.
+	cells   [][]string
+	nextRow int
+}
+
+// NewParquetReader returns a new ParquetReader over the provided Parquet
+// file, of the specified size.
+func NewParquetReader(r io.ReaderAt, size int64) (*ParquetReader, error) {
+	if size < int64(2*len(parquetMagic)+4) {
+		return nil, fmt.Errorf("not a Parquet file")
+	}
+	tail := make([]byte, 4+len(parquetMagic))
+	if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != parquetMagic {
+		return nil, fmt.Errorf("not a Parquet file")
+	}
+	mdLen := int64(binary.LittleEndian.Uint32(tail))
+	if mdLen > maxParquetMetadataBytes || mdLen > size-int64(len(parquetMagic)+len(tail)) {
+		return nil, fmt.Errorf("bad Parquet metadata length %d", mdLen)
+	}
+	buf := make([]byte, mdLen)
+	if _, err := r.ReadAt(buf, size-int64(len(tail))-mdLen); err != nil {
+		return nil, err
+	}
+	md, err := (&thriftReader{buf: buf}).fileMetaData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Parquet metadata: %s", err)
+	}
+	if len(md.schema) == 0 || md.schema[0].numChildren != len(md.schema)-1 {
+		return nil, fmt.Errorf("Parquet table is not flat; only flat tables are supported")
+	}
+	pr := &ParquetReader{
+		r:    r,
+		size: size,
+		md:   md,
+	}
+	for _, se := range md.schema[1:] {
+		col, err := newParquetColumn(se)
+		if err != nil {
+			return nil, err
+		}
+		pr.columns = append(pr.columns, col)
+	}
+	for _, rg := range md.rowGroups {
+		if len(rg.columns) != len(pr.columns) {
+			return nil, fmt.Errorf("Parquet row group has %d columns, want %d", len(rg.columns), len(pr.columns))
+		}
+	}
+	return pr, nil
+}
+
+// Read returns the receiver's next row, or io.EOF if there are no more rows.
+func (pr *ParquetReader) Read() ([]string, error) {
+	if !pr.readHeader {
+		pr.readHeader = true
+		header := make([]string, len(pr.columns))
+		for idx, col := range pr.columns {
+			header[idx] = col.name
+		}
+		return header, nil
+	}
+	for pr.cells == nil || pr.nextRow >= len(pr.cells[0]) {
+		if pr.nextRowGroup >= len(pr.md.rowGroups) || len(pr.columns) == 0 {
+			return nil, io.EOF
+		}
+		rg := pr.md.rowGroups[pr.nextRowGroup]
+		pr.nextRowGroup++
+		pr.cells, pr.nextRow = make([][]string, len(pr.columns)), 0
+		for idx, col := range pr.columns {
+			cells, err := pr.readColumnChunk(col, rg.columns[idx], rg.numRows)
+			if err != nil {
+				return nil, fmt.Errorf("Parquet column '%s': %s", col.name, err)
+			}
+			pr.cells[idx] = cells
+		}
+	}
+	row := make([]string, len(pr.columns))
+	for idx := range pr.columns {
+		row[idx] = pr.cells[idx][pr.nextRow]
+	}
+	pr.nextRow++
+	return row, nil
+}
+
+// readColumnChunk reads the specified number of values of the provided
+// column from the provided column chunk.
+func (pr *ParquetReader) readColumnChunk(col *parquetColumn, cmd *parquetColumnMetaData, numRows int64) ([]string, error) {
+	if cmd.typ != col.typ {
+		return nil, fmt.Errorf("column chunk has type %d, want %d", cmd.typ, col.typ)
+	}
+	start := cmd.dataPageOffset
+	if cmd.dictionaryPageOffset > 0 && cmd.dictionaryPageOffset < start {
+		start = cmd.dictionaryPageOffset
+	}
+	if start < int64(len(parquetMagic)) || cmd.totalCompressedSize < 0 || cmd.totalCompressedSize > pr.size-start || numRows < 0 {
+		return nil, fmt.Errorf("bad column chunk bounds")
+	}
+	chunk := make([]byte, cmd.totalCompressedSize)
+	if _, err := pr.r.ReadAt(chunk, start); err != nil {
+		return nil, err
+	}
+	tr := &thriftReader{buf: chunk}
+	var dict []string
+	var ret []string
+	for int64(len(ret)) < numRows {
+		if tr.pos >= len(chunk) {
+			return nil, fmt.Errorf("column chunk has %d values, want %d", len(ret), numRows)
+		}
+		ph, err := tr.pageHeader()
+		if err != nil {
+			return nil, fmt.Errorf("bad page header: %s", err)
+		}
+		if ph.compressedSize < 0 || ph.compressedSize > len(chunk)-tr.pos {
+			return nil, fmt.Errorf("truncated page")
+		}
+		page := chunk[tr.pos : tr.pos+ph.compressedSize]
+		tr.pos += ph.compressedSize
+		switch ph.typ {
+		case parquetDictionaryPage:
+			if ph.dictionaryEnc != parquetPlain && ph.dictionaryEnc != parquetPlainDictionary {
+				return nil, fmt.Errorf("unsupported dictionary encoding %d", ph.dictionaryEnc)
+			}
+			data, err := decompress(cmd.codec, page, ph.uncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+			if dict, err = col.decodeValues(parquetPlain, data, ph.dictionaryNumValues, nil); err != nil {
+				return nil, err
+			}
+		case parquetDataPage, parquetDataPageV2:
+			if ph.dataPage == nil {
+				return nil, fmt.Errorf("data page has no header")
+			}
+			cells, err := col.decodeDataPage(ph, cmd.codec, page, dict)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, cells...)
+		}
+	}
+	if int64(len(ret)) != numRows {
+		return nil, fmt.Errorf("column chunk has %d values, want %d", len(ret), numRows)
+	}
+	return ret, nil
+}
+
+// decodeDataPage decodes the values of the provided data page of the
+// receiver, with the provided header and dictionary, if any.
+func (col *parquetColumn) decodeDataPage(ph *parquetPageHeader, codec int, page []byte, dict []string) ([]string, error) {
+	dph := ph.dataPage
+	if dph.numValues < 0 || dph.numValues > maxParquetPageValues {
+		return nil, fmt.Errorf("bad value count %d", dph.numValues)
+	}
+	var defLevels []byte
+	var values []byte
+	if ph.typ == parquetDataPageV2 {
+		// Levels precede the values, and are never compressed.
+		if dph.repLevelsLen != 0 {
+			return nil, fmt.Errorf("repeated values are unsupported")
+		}
+		if dph.defLevelsLen < 0 || dph.defLevelsLen > len(page) {
+			return nil, fmt.Errorf("truncated page")
+		}
+		defLevels, values = page[:dph.defLevelsLen], page[dph.defLevelsLen:]
+		if !dph.uncompressed {
+			var err error
+			if values, err = decompress(codec, values, ph.uncompressedSize-dph.defLevelsLen); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		data, err := decompress(codec, page, ph.uncompressedSize)
+		if err != nil {
+			return nil, err
+		}
+		values = data
+		if col.optional {
+			// Levels are prefixed by their length.
+			if len(data) < 4 || uint64(binary.LittleEndian.Uint32(data)) > uint64(len(data)-4) {
+				return nil, fmt.Errorf("truncated page")
+			}
+			levelsLen := int(binary.LittleEndian.Uint32(data))
+			defLevels, values = data[4:4+levelsLen], data[4+levelsLen:]
+		}
+	}
+	// In a flat table, a value is present if its definition level is 1.
+	present := dph.numValues
+	var defs []int
+	if col.optional {
+		var err error
+		if defs, err = decodeHybrid(defLevels, 1, dph.numValues); err != nil {
+			return nil, err
+		}
+		present = 0
+		for _, def := range defs {
+			present += def
+		}
+	}
+	vals, err := col.decodeValues(dph.encoding, values, present, dict)
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) != present {
+		return nil, fmt.Errorf("page has %d values, want %d", len(vals), present)
+	}
+	if !col.optional {
+		return vals, nil
+	}
+	ret := make([]string, len(defs))
+	for idx, def := range defs {
+		if def == 1 {
+			ret[idx], vals = vals[0], vals[1:]
+		}
+	}
+	return ret, nil
+}
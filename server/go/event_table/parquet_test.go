@@ -0,0 +1,202 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package eventtable
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// The testdata Parquet files hold the same events, in two row groups, written
+// with different compression codecs, data page versions, and encodings by an
+// independent Parquet implementation.
+var parquetFiles = []string{
+	"events_snappy.parquet",
+	"events_gzip_v1.parquet",
+	"events_uncompressed.parquet",
+	"events_delta.parquet",
+}
+
+func TestParquetReader(t *testing.T) {
+	wantRows := [][]string{
+		{"start", "service", "method", "latency_ms", "status", "bytes", "ok", "cost"},
+		{"2023-01-01T00:00:02Z", "frontend", "GET", "1000", "200", "4000000000", "true", "1.25"},
+		{"2023-01-01T00:00:00Z", "frontend", "POST", "500", "500", "7", "false", "-0.05"},
+		{"2023-01-01T00:00:01Z", "backend", "GET", "1500", "", "0", "true", "0.00"},
+	}
+	for _, filename := range parquetFiles {
+		t.Run(filename, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", filename))
+			if err != nil {
+				t.Fatalf("Failed to read test file: %s", err)
+			}
+			pr, err := NewParquetReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("NewParquetReader() yielded unexpected error %s", err)
+			}
+			if len(pr.md.rowGroups) != 2 {
+				t.Errorf("Got %d row groups, want 2", len(pr.md.rowGroups))
+			}
+			var gotRows [][]string
+			for {
+				row, err := pr.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Read() yielded unexpected error %s", err)
+				}
+				gotRows = append(gotRows, row)
+			}
+			if diff := cmp.Diff(wantRows, gotRows); diff != "" {
+				t.Errorf("Got rows diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadParquetFile(t *testing.T) {
+	cfg := &Config{
+		TimestampColumn: "start",
+		DurationColumn:  "latency_ms",
+		DurationUnit:    "ms",
+		CategoryColumns: []string{"service", "method"},
+		WeightColumn:    "bytes",
+		PropertyColumns: []string{"status"},
+	}
+	wantEvents := []*Event{{
+		Time: sec(0), Duration: 500 * time.Millisecond, Path: []string{"frontend", "POST"}, Weight: 7, Properties: []string{"500"},
+	}, {
+		Time: sec(1), Duration: 1500 * time.Millisecond, Path: []string{"backend", "GET"}, Weight: 0, Properties: []string{""},
+	}, {
+		Time: sec(2), Duration: time.Second, Path: []string{"frontend", "GET"}, Weight: 4000000000, Properties: []string{"200"},
+	}}
+	for _, filename := range parquetFiles {
+		t.Run(filename, func(t *testing.T) {
+			tbl, err := LoadFile(cfg, filepath.Join("testdata", filename))
+			if err != nil {
+				t.Fatalf("LoadFile() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(wantEvents, tbl.Events); diff != "" {
+				t.Errorf("LoadFile() yielded unexpected events (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMalformedParquet(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", parquetFiles[0]))
+	if err != nil {
+		t.Fatalf("Failed to read test file: %s", err)
+	}
+	footerLen := int(data[len(data)-8]) | int(data[len(data)-7])<<8
+	for _, test := range []struct {
+		description string
+		data        []byte
+	}{{
+		description: "empty",
+	}, {
+		description: "not Parquet",
+		data:        []byte("start,service\n2023-01-01T00:00:00Z,frontend\n"),
+	}, {
+		description: "truncated",
+		data:        data[len(data)/2:],
+	}, {
+		description: "corrupt metadata",
+		data: func() []byte {
+			corrupt := append([]byte{}, data...)
+			for idx := len(data) - 8 - footerLen; idx < len(data)-8; idx++ {
+				corrupt[idx] = 0xff
+			}
+			return corrupt
+		}(),
+	}, {
+		description: "corrupt pages",
+		data: func() []byte {
+			corrupt := append([]byte{}, data...)
+			for idx := 4; idx < len(data)-8-footerLen; idx++ {
+				corrupt[idx] = 0xff
+			}
+			return corrupt
+		}(),
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			pr, err := NewParquetReader(bytes.NewReader(test.data), int64(len(test.data)))
+			for err == nil {
+				_, err = pr.Read()
+			}
+			if err == io.EOF {
+				t.Errorf("Reading malformed Parquet yielded no error")
+			}
+		})
+	}
+}
+
+func TestDecodeDeltaBinaryPacked(t *testing.T) {
+	// The second example from the Parquet encoding specification: 7, 5, 3, 1,
+	// 2, 3, 4, 5 has deltas -2, -2, -2, 1, 1, 1, 1, stored relative to the
+	// minimum delta -2 as 0, 0, 0, 3, 3, 3, 3 with bit width 2.
+	buf := []byte{
+		0x80, 0x01, // block size 128
+		0x04,                   // 4 miniblocks
+		0x08,                   // 8 values
+		0x0e,                   // first value 7
+		0x03,                   // minimum delta -2
+		0x02, 0x00, 0x00, 0x00, // miniblock bit widths
+		0xc0, 0x3f, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	got, n, err := decodeDeltaBinaryPacked(buf, 8)
+	if err != nil {
+		t.Fatalf("decodeDeltaBinaryPacked() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff([]int64{7, 5, 3, 1, 2, 3, 4, 5}, got); diff != "" {
+		t.Errorf("decodeDeltaBinaryPacked() yielded diff (-want +got):\n%s", diff)
+	}
+	if n != len(buf) {
+		t.Errorf("decodeDeltaBinaryPacked() consumed %d bytes, want %d", n, len(buf))
+	}
+	if _, _, err := decodeDeltaBinaryPacked(buf[:12], 8); err == nil {
+		t.Errorf("decodeDeltaBinaryPacked() of a truncated buffer yielded no error")
+	}
+}
+
+func TestDecodeSnappy(t *testing.T) {
+	// A literal 'abc', then an overlapping nine-byte copy from offset 3.
+	block := []byte{0x0c, 0x08, 'a', 'b', 'c', 0x15, 0x03}
+	got, err := decodeSnappy(block, 12)
+	if err != nil {
+		t.Fatalf("decodeSnappy() yielded unexpected error %s", err)
+	}
+	if string(got) != "abcabcabcabc" {
+		t.Errorf("decodeSnappy() = '%s', want 'abcabcabcabc'", got)
+	}
+	for _, bad := range [][]byte{
+		// Wrong length.
+		{0x0d, 0x08, 'a', 'b', 'c', 0x15, 0x03},
+		// Copy from before the start.
+		{0x0c, 0x08, 'a', 'b', 'c', 0x15, 0x04},
+		// Truncated literal.
+		{0x0c, 0x08, 'a'},
+	} {
+		if _, err := decodeSnappy(bad, 12); err == nil {
+			t.Errorf("decodeSnappy(%v) yielded no error", bad)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package eventtable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Thrift compact protocol type identifiers.
+const (
+	thriftBoolTrue  = 1
+	thriftBoolFalse = 2
+	thriftByte      = 3
+	thriftI16       = 4
+	thriftI32       = 5
+	thriftI64       = 6
+	thriftDouble    = 7
+	thriftBinary    = 8
+	thriftList      = 9
+	thriftSet       = 10
+	thriftMap       = 11
+	thriftStruct    = 12
+)
+
+// The maximum nesting depth of Thrift structures, guarding against malicious
+// files.
+const maxThriftDepth = 32
+
+// thriftReader decodes the Thrift compact protocol, in which Parquet metadata
+// is encoded.
+type thriftReader struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+func (tr *thriftReader) byte() (byte, error) {
+	if tr.pos >= len(tr.buf) {
+		return 0, fmt.Errorf("truncated metadata")
+	}
+	tr.pos++
+	return tr.buf[tr.pos-1], nil
+}
+
+func (tr *thriftReader) uvarint() (uint64, error) {
+	val, n := binary.Uvarint(tr.buf[tr.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("bad varint in metadata")
+	}
+	tr.pos += n
+	return val, nil
+}
+
+// int reads a zigzag-encoded integer.
+func (tr *thriftReader) int() (int64, error) {
+	val, err := tr.uvarint()
+	return int64(val>>1) ^ -int64(val&1), err
+}
+
+func (tr *thriftReader) binary() ([]byte, error) {
+	n, err := tr.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(tr.buf)-tr.pos) {
+		return nil, fmt.Errorf("truncated metadata")
+	}
+	tr.pos += int(n)
+	return tr.buf[tr.pos-int(n) : tr.pos], nil
+}
+
+func (tr *thriftReader) string() (string, error) {
+	val, err := tr.binary()
+	return string(val), err
+}
+
+// listHeader reads the header of a list or set, returning its element type
+// and size.
+func (tr *thriftReader) listHeader() (byte, int, error) {
+	b, err := tr.byte()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := uint64(b >> 4)
+	if size == 15 {
+		if size, err = tr.uvarint(); err != nil {
+			return 0, 0, err
+		}
+	}
+	// Each element occupies at least one byte.
+	if size > uint64(len(tr.buf)-tr.pos) {
+		return 0, 0, fmt.Errorf("truncated metadata")
+	}
+	return b & 0x0f, int(size), nil
+}
+
+// list reads a list, invoking the provided function to read each element.
+func (tr *thriftReader) list(elem func(typ byte) error) error {
+	typ, size, err := tr.listHeader()
+	if err != nil {
+		return err
+	}
+	for idx := 0; idx < size; idx++ {
+		if err := elem(typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// structure reads a struct, invoking the provided function to read each
+// field.  Boolean fields carry their value in their type; the function should
+// call skip for fields it does not recognize.
+func (tr *thriftReader) structure(field func(id int16, typ byte) error) error {
+	if tr.depth >= maxThriftDepth {
+		return fmt.Errorf("metadata nested too deeply")
+	}
+	tr.depth++
+	defer func() { tr.depth-- }()
+	var id int16
+	for {
+		b, err := tr.byte()
+		if err != nil {
+			return err
+		}
+		if b == 0 {
+			return nil
+		}
+		if delta := b >> 4; delta != 0 {
+			id += int16(delta)
+		} else {
+			val, err := tr.int()
+			if err != nil {
+				return err
+			}
+			id = int16(val)
+		}
+		if err := field(id, b&0x0f); err != nil {
+			return err
+		}
+	}
+}
+
+// skip reads and discards a value of the specified type.
+func (tr *thriftReader) skip(typ byte) error {
+	var err error
+	switch typ {
+	case thriftBoolTrue, thriftBoolFalse:
+	case thriftByte:
+		_, err = tr.byte()
+	case thriftI16, thriftI32, thriftI64:
+		_, err = tr.uvarint()
+	case thriftDouble:
+		if len(tr.buf)-tr.pos < 8 {
+			return fmt.Errorf("truncated metadata")
+		}
+		tr.pos += 8
+	case thriftBinary:
+		_, err = tr.binary()
+	case thriftList, thriftSet:
+		err = tr.list(func(typ byte) error {
+			if typ == thriftBoolTrue || typ == thriftBoolFalse {
+				// Booleans in lists occupy a byte.
+				_, err := tr.byte()
+				return err
+			}
+			return tr.skip(typ)
+		})
+	case thriftMap:
+		var size uint64
+		if size, err = tr.uvarint(); err != nil || size == 0 {
+			return err
+		}
+		var types byte
+		if types, err = tr.byte(); err != nil {
+			return err
+		}
+		for ; size > 0 && err == nil; size-- {
+			if err = tr.skip(types >> 4); err == nil {
+				err = tr.skip(types & 0x0f)
+			}
+		}
+	case thriftStruct:
+		err = tr.structure(func(id int16, typ byte) error {
+			return tr.skip(typ)
+		})
+	default:
+		err = fmt.Errorf("bad metadata type %d", typ)
+	}
+	return err
+}
+
+// i32 reads an I32 value, which must fit in an int.
+func (tr *thriftReader) i32() (int, error) {
+	val, err := tr.int()
+	if err == nil && (val < math.MinInt32 || val > math.MaxInt32) {
+		err = fmt.Errorf("metadata value %d out of range", val)
+	}
+	return int(val), err
+}
+
+// The Parquet metadata used by parquetReader.  Fields not needed to read flat
+// tables are skipped.
+
+type parquetLogicalType struct {
+	// The union member set, by field ID: 1 for STRING, 5 for DECIMAL, 6 for
+	// DATE, 8 for TIMESTAMP, 10 for INTEGER, and so on.
+	kind int16
+	// For DECIMAL.
+	scale int
+	// For TIMESTAMP, the union member set for the unit: 1 for milliseconds, 2
+	// for microseconds, or 3 for nanoseconds.
+	unit int16
+	// For INTEGER.
+	unsigned bool
+}
+
+type parquetSchemaElement struct {
+	typ, typeLength, repetition int
+	name                        string
+	numChildren                 int
+	convertedType               int
+	scale                       int
+	logicalType                 *parquetLogicalType
+}
+
+type parquetColumnMetaData struct {
+	typ                                  int
+	path                                 []string
+	codec                                int
+	numValues, totalCompressedSize       int64
+	dataPageOffset, dictionaryPageOffset int64
+}
+
+type parquetRowGroup struct {
+	columns []*parquetColumnMetaData
+	numRows int64
+}
+
+type parquetFileMetaData struct {
+	schema    []*parquetSchemaElement
+	numRows   int64
+	rowGroups []*parquetRowGroup
+}
+
+type parquetDataPageHeader struct {
+	numValues, encoding int
+	// For version 2 data pages.
+	defLevelsLen, repLevelsLen int
+	uncompressed               bool
+}
+
+type parquetPageHeader struct {
+	typ                                int
+	uncompressedSize, compressedSize   int
+	dataPage                           *parquetDataPageHeader
+	dictionaryNumValues, dictionaryEnc int
+}
+
+// Parquet page types.
+const (
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+)
+
+func (tr *thriftReader) logicalType() (*parquetLogicalType, error) {
+	lt := &parquetLogicalType{}
+	err := tr.structure(func(id int16, typ byte) error {
+		lt.kind = id
+		if typ != thriftStruct {
+			return tr.skip(typ)
+		}
+		return tr.structure(func(memberID int16, typ byte) error {
+			switch {
+			case id == 5 && memberID == 1 && typ == thriftI32:
+				var err error
+				lt.scale, err = tr.i32()
+				return err
+			case id == 10 && memberID == 2 && typ == thriftBoolFalse:
+				lt.unsigned = true
+				return nil
+			case id == 8 && memberID == 2 && typ == thriftStruct:
+				return tr.structure(func(unitID int16, typ byte) error {
+					lt.unit = unitID
+					return tr.skip(typ)
+				})
+			}
+			return tr.skip(typ)
+		})
+	})
+	return lt, err
+}
+
+func (tr *thriftReader) schemaElement() (*parquetSchemaElement, error) {
+	se := &parquetSchemaElement{
+		typ:           -1,
+		repetition:    -1,
+		convertedType: -1,
+	}
+	err := tr.structure(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == thriftI32:
+			se.typ, err = tr.i32()
+		case id == 2 && typ == thriftI32:
+			se.typeLength, err = tr.i32()
+		case id == 3 && typ == thriftI32:
+			se.repetition, err = tr.i32()
+		case id == 4 && typ == thriftBinary:
+			se.name, err = tr.string()
+		case id == 5 && typ == thriftI32:
+			se.numChildren, err = tr.i32()
+		case id == 6 && typ == thriftI32:
+			se.convertedType, err = tr.i32()
+		case id == 7 && typ == thriftI32:
+			se.scale, err = tr.i32()
+		case id == 10 && typ == thriftStruct:
+			se.logicalType, err = tr.logicalType()
+		default:
+			err = tr.skip(typ)
+		}
+		return err
+	})
+	return se, err
+}
+
+func (tr *thriftReader) columnMetaData() (*parquetColumnMetaData, error) {
+	cmd := &parquetColumnMetaData{}
+	err := tr.structure(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == thriftI32:
+			cmd.typ, err = tr.i32()
+		case id == 3 && typ == thriftList:
+			err = tr.list(func(typ byte) error {
+				if typ != thriftBinary {
+					return fmt.Errorf("bad column path")
+				}
+				name, err := tr.string()
+				cmd.path = append(cmd.path, name)
+				return err
+			})
+		case id == 4 && typ == thriftI32:
+			cmd.codec, err = tr.i32()
+		case id == 5 && typ == thriftI64:
+			cmd.numValues, err = tr.int()
+		case id == 7 && typ == thriftI64:
+			cmd.totalCompressedSize, err = tr.int()
+		case id == 9 && typ == thriftI64:
+			cmd.dataPageOffset, err = tr.int()
+		case id == 11 && typ == thriftI64:
+			cmd.dictionaryPageOffset, err = tr.int()
+		default:
+			err = tr.skip(typ)
+		}
+		return err
+	})
+	return cmd, err
+}
+
+func (tr *thriftReader) rowGroup() (*parquetRowGroup, error) {
+	rg := &parquetRowGroup{}
+	err := tr.structure(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == thriftList:
+			err = tr.list(func(typ byte) error {
+				if typ != thriftStruct {
+					return fmt.Errorf("bad column chunk")
+				}
+				var cmd *parquetColumnMetaData
+				err := tr.structure(func(id int16, typ byte) error {
+					switch {
+					case id == 1 && typ == thriftBinary:
+						// Column chunks in other files are unsupported.
+						path, err := tr.string()
+						if err == nil && path != "" {
+							err = fmt.Errorf("column chunks in external files are unsupported")
+						}
+						return err
+					case id == 3 && typ == thriftStruct:
+						var err error
+						cmd, err = tr.columnMetaData()
+						return err
+					}
+					return tr.skip(typ)
+				})
+				if err == nil && cmd == nil {
+					err = fmt.Errorf("column chunk has no metadata")
+				}
+				rg.columns = append(rg.columns, cmd)
+				return err
+			})
+		case id == 3 && typ == thriftI64:
+			rg.numRows, err = tr.int()
+		default:
+			err = tr.skip(typ)
+		}
+		return err
+	})
+	return rg, err
+}
+
+func (tr *thriftReader) fileMetaData() (*parquetFileMetaData, error) {
+	fmd := &parquetFileMetaData{}
+	err := tr.structure(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 2 && typ == thriftList:
+			err = tr.list(func(typ byte) error {
+				if typ != thriftStruct {
+					return fmt.Errorf("bad schema element")
+				}
+				se, err := tr.schemaElement()
+				fmd.schema = append(fmd.schema, se)
+				return err
+			})
+		case id == 3 && typ == thriftI64:
+			fmd.numRows, err = tr.int()
+		case id == 4 && typ == thriftList:
+			err = tr.list(func(typ byte) error {
+				if typ != thriftStruct {
+					return fmt.Errorf("bad row group")
+				}
+				rg, err := tr.rowGroup()
+				fmd.rowGroups = append(fmd.rowGroups, rg)
+				return err
+			})
+		default:
+			err = tr.skip(typ)
+		}
+		return err
+	})
+	return fmd, err
+}
+
+func (tr *thriftReader) pageHeader() (*parquetPageHeader, error) {
+	ph := &parquetPageHeader{}
+	err := tr.structure(func(id int16, typ byte) error {
+		var err error
+		switch {
+		case id == 1 && typ == thriftI32:
+			ph.typ, err = tr.i32()
+		case id == 2 && typ == thriftI32:
+			ph.uncompressedSize, err = tr.i32()
+		case id == 3 && typ == thriftI32:
+			ph.compressedSize, err = tr.i32()
+		case id == 5 && typ == thriftStruct:
+			ph.dataPage = &parquetDataPageHeader{}
+			err = tr.structure(func(id int16, typ byte) error {
+				var err error
+				switch {
+				case id == 1 && typ == thriftI32:
+					ph.dataPage.numValues, err = tr.i32()
+				case id == 2 && typ == thriftI32:
+					ph.dataPage.encoding, err = tr.i32()
+				default:
+					err = tr.skip(typ)
+				}
+				return err
+			})
+		case id == 7 && typ == thriftStruct:
+			err = tr.structure(func(id int16, typ byte) error {
+				var err error
+				switch {
+				case id == 1 && typ == thriftI32:
+					ph.dictionaryNumValues, err = tr.i32()
+				case id == 2 && typ == thriftI32:
+					ph.dictionaryEnc, err = tr.i32()
+				default:
+					err = tr.skip(typ)
+				}
+				return err
+			})
+		case id == 8 && typ == thriftStruct:
+			ph.dataPage = &parquetDataPageHeader{}
+			err = tr.structure(func(id int16, typ byte) error {
+				var err error
+				switch {
+				case id == 1 && typ == thriftI32:
+					ph.dataPage.numValues, err = tr.i32()
+				case id == 4 && typ == thriftI32:
+					ph.dataPage.encoding, err = tr.i32()
+				case id == 5 && typ == thriftI32:
+					ph.dataPage.defLevelsLen, err = tr.i32()
+				case id == 6 && typ == thriftI32:
+					ph.dataPage.repLevelsLen, err = tr.i32()
+				case id == 7 && typ == thriftBoolFalse:
+					ph.dataPage.uncompressed = true
+				default:
+					err = tr.skip(typ)
+				}
+				return err
+			})
+		default:
+			err = tr.skip(typ)
+		}
+		return err
+	})
+	return ph, err
+}
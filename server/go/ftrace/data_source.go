@@ -0,0 +1,74 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ftrace
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	// The global filter specifying the name of the Trace to query.
+	collectionNameKey = "collection_name"
+
+	// Queries
+	schedQuery = "ftrace.sched"
+)
+
+// DataSource is a QueryDispatcher data source serving Traces.  It supports
+// the query 'ftrace.sched', a per-CPU trace of scheduling and interrupt
+// activity.  The 'collection_name' global filter names the Trace to query.
+type DataSource struct {
+	traces map[string]*Trace
+}
+
+// NewDataSource returns a new DataSource serving the provided Traces by name.
+func NewDataSource(traces map[string]*Trace) *DataSource {
+	return &DataSource{
+		traces: traces,
+	}
+}
+
+// SupportedDataSeriesQueries returns the queries supported by DataSource.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	return []string{schedQuery}
+}
+
+// HandleDataSeriesRequests handles the provided DataSeriesRequests.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	collectionNameVal, ok := globalState[collectionNameKey]
+	if !ok {
+		return fmt.Errorf("missing required global filter '%s'", collectionNameKey)
+	}
+	collectionName, err := util.ExpectStringValue(collectionNameVal)
+	if err != nil {
+		return err
+	}
+	t, ok := ds.traces[collectionName]
+	if !ok {
+		return fmt.Errorf("no ftrace trace named '%s'", collectionName)
+	}
+	for _, req := range reqs {
+		if req.QueryName != schedQuery {
+			return fmt.Errorf("unsupported data query `%s`", req.QueryName)
+		}
+		for key := range req.Options {
+			return fmt.Errorf("unsupported option '%s'", key)
+		}
+		t.Sched.Trace(drb.DataSeries(req), t.Start, t.End, nil)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package ftrace imports Linux kernel scheduling traces in the ftrace text
+// format, as read from /sys/kernel/tracing/trace or produced by
+// `trace-cmd report` or systrace, and serves them as per-CPU traces of running
+// and waiting tasks and interrupt handlers.  The sched_switch, sched_wakeup,
+// sched_wakeup_new, irq_handler_entry, and irq_handler_exit events are used;
+// other events are ignored.
+package ftrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	schedtrace "github.com/google/traceviz/server/go/sched_trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Trace is a parsed ftrace trace.
+type Trace struct {
+	// Scheduler and interrupt events.
+	Sched *schedtrace.Sched
+	// The times of the earliest and latest events in the trace.
+	Start, End time.Duration
+}
+
+// Event is a single ftrace event line.
+type Event struct {
+	Comm    string
+	PID     int64
+	CPU     int
+	Time    time.Duration
+	Name    string
+	Payload string
+}
+
+// Matches an event line, e.g.
+//
+//	<idle>-0       [001] d..2  1234.567890: sched_switch: prev_comm=...
+//	  bash-1234  ( 1234) [000] d..3  1234.567900: sched_wakeup: comm=...
+//
+// The TGID column and the flags column are optional.
+var eventRE = regexp.MustCompile(`^\s*(.+?)-(\d+)\s+(?:\(\s*(?:\d+|-+)\)\s+)?\[(\d+)\]\s+(?:\S+\s+)?(\d+)\.(\d+):\s+(\w+):\s*(.*)$`)
+
+// ParseEvent parses the provided ftrace event line.
+func ParseEvent(line string) (*Event, error) {
+	m := eventRE.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("unrecognized event '%s'", line)
+	}
+	ev := &Event{
+		Comm:    m[1],
+		Name:    m[6],
+		Payload: m[7],
+	}
+	var err error
+	if ev.PID, err = strconv.ParseInt(m[2], 10, 64); err != nil {
+		return nil, err
+	}
+	if ev.CPU, err = strconv.Atoi(m[3]); err != nil {
+		return nil, err
+	}
+	if ev.Time, err = util.ParseTimestamp(m[4], m[5]); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// Parse parses the provided ftrace text output.  Comment lines, beginning with
+// '#', and lost-event notices are skipped.
+func Parse(r io.Reader) (*Trace, error) {
+	t := &Trace{
+		Sched: schedtrace.New(),
+	}
+	seen := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "[LOST") {
+			continue
+		}
+		ev, err := ParseEvent(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+		if !seen || ev.Time < t.Start {
+			t.Start = ev.Time
+		}
+		if !seen || ev.Time > t.End {
+			t.End = ev.Time
+		}
+		seen = true
+		switch ev.Name {
+		case "sched_switch":
+			sw, err := schedtrace.ParseSwitch(ev.Time, ev.CPU, ev.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+			t.Sched.Switch(sw)
+		case "sched_wakeup", "sched_wakeup_new":
+			w, err := schedtrace.ParseWakeup(ev.Time, ev.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+			t.Sched.Wakeup(w)
+		case "irq_handler_entry":
+			irq, err := schedtrace.ParseIRQ(ev.Time, ev.CPU, ev.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+			t.Sched.IRQEntry(irq)
+		case "irq_handler_exit":
+			t.Sched.IRQExit(ev.Time, ev.CPU)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ParseFile parses the specified file of ftrace text output.
+func ParseFile(filename string) (*Trace, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
@@ -0,0 +1,114 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package ftrace
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	schedtrace "github.com/google/traceviz/server/go/sched_trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const ftraceText = `# tracer: nop
+#
+# entries-in-buffer/entries-written: 7/7   #P:2
+#
+#           TASK-PID     CPU#  ||||   TIMESTAMP  FUNCTION
+#              | |         |   ||||      |         |
+            bash-1234    [000] d..3  100.000010: sched_wakeup: comm=my prog pid=2000 prio=120 target_cpu=000
+            bash-1234    [000] d..2  100.000020: sched_switch: prev_comm=bash prev_pid=1234 prev_prio=120 prev_state=S ==> next_comm=my prog next_pid=2000 next_prio=120
+          <idle>-0       [001] d.h1  100.000030: irq_handler_entry: irq=24 name=eth0
+          <idle>-0       [001] d.h1  100.000035: irq_handler_exit: irq=24 ret=handled
+ kworker/u8:1-mm-77      [001] ....  100.000040: workqueue_execute_start: work struct 0000
+CPU:1 [LOST 12 EVENTS]
+         my prog-2000  ( 2000) [000] d..2  100.000050: sched_switch: prev_comm=my prog prev_pid=2000 prev_prio=120 prev_state=R ==> next_comm=swapper/0 next_pid=0 next_prio=120
+`
+
+func us(dur float64) time.Duration {
+	return time.Duration(dur * float64(time.Microsecond))
+}
+
+func TestParseEvent(t *testing.T) {
+	ev, err := ParseEvent(" kworker/u8:1-mm-77      [001] ....  100.000040: workqueue_execute_start: work struct 0000")
+	if err != nil {
+		t.Fatalf("ParseEvent() yielded unexpected error %s", err)
+	}
+	want := &Event{
+		Comm:    "kworker/u8:1-mm",
+		PID:     77,
+		CPU:     1,
+		Time:    100*time.Second + us(40),
+		Name:    "workqueue_execute_start",
+		Payload: "work struct 0000",
+	}
+	if diff := cmp.Diff(want, ev); diff != "" {
+		t.Errorf("ParseEvent() diff (-want +got):\n%s", diff)
+	}
+	if _, err := ParseEvent("bash [000] 100.0: sched_switch:"); err == nil {
+		t.Errorf("ParseEvent() of a malformed line yielded no error")
+	}
+}
+
+func TestDataSource(t *testing.T) {
+	tr, err := Parse(strings.NewReader(ftraceText))
+	if err != nil {
+		t.Fatalf("Parse() yielded unexpected error %s", err)
+	}
+	start, end := 100*time.Second+us(10), 100*time.Second+us(50)
+	if tr.Start != start || tr.End != end {
+		t.Errorf("Parse() yielded extent %v-%v, want %v-%v", tr.Start, tr.End, start, end)
+	}
+	ds := NewDataSource(map[string]*Trace{"trace": tr})
+	req := &util.DataSeriesRequest{
+		QueryName: schedQuery,
+	}
+	gotDRB := util.NewDataResponseBuilder()
+	if err := ds.HandleDataSeriesRequests(context.Background(), map[string]*util.V{
+		collectionNameKey: util.StringValue("trace"),
+	}, gotDRB, []*util.DataSeriesRequest{req}); err != nil {
+		t.Fatalf("HandleDataSeriesRequests() yielded unexpected error %s", err)
+	}
+	gotData, err := gotDRB.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+
+	bash := schedtrace.Task{PID: 1234, Comm: "bash"}
+	prog := schedtrace.Task{PID: 2000, Comm: "my prog"}
+	idle := schedtrace.Task{PID: 0, Comm: "swapper/0"}
+	wantDRB := util.NewDataResponseBuilder()
+	schedtrace.New().
+		Wakeup(&schedtrace.Wakeup{Time: 100*time.Second + us(10), Task: prog, TargetCPU: 0}).
+		Switch(&schedtrace.Switch{Time: 100*time.Second + us(20), CPU: 0, Prev: bash, PrevState: "S", Next: prog}).
+		IRQEntry(&schedtrace.IRQ{Start: 100*time.Second + us(30), End: 100*time.Second + us(30), CPU: 1, IRQ: 24, Name: "eth0"}).
+		IRQExit(100*time.Second+us(35), 1).
+		Switch(&schedtrace.Switch{Time: 100*time.Second + us(50), CPU: 0, Prev: prog, PrevState: "R", Next: idle}).
+		Trace(wantDRB.DataSeries(req), start, end, nil)
+	wantData, err := wantDRB.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+		t.Errorf("HandleDataSeriesRequests() diff (-want +got):\n%s", diff)
+	}
+	if err := ds.HandleDataSeriesRequests(context.Background(), map[string]*util.V{
+		collectionNameKey: util.StringValue("nonexistent"),
+	}, util.NewDataResponseBuilder(), []*util.DataSeriesRequest{req}); err == nil {
+		t.Errorf("HandleDataSeriesRequests() for a nonexistent trace yielded no error")
+	}
+}
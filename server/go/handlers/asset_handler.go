@@ -15,9 +15,14 @@
 package handlers
 
 import (
+	"bytes"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"path"
+	"strings"
 
 	"github.com/google/safehtml"
 )
@@ -112,15 +117,22 @@ func (afa *AnnotatedFileAsset) HTTPHandler(w http.ResponseWriter, req *http.Requ
 }
 
 // AssetHandler implements http.Handler, and serves static assets (HTML, JS,
-// CSS, etc.)
+// CSS, etc.)  Assets may be registered individually, with With, or as whole
+// directories, with WithDir and WithFS.  If an SPA fallback Asset is set with
+// WithSPAFallback, GET requests for unknown paths are answered with it, so
+// that single-page application routes needn't be enumerated.
 type AssetHandler struct {
 	handlersByPath map[string]func(http.ResponseWriter, *http.Request)
+	// Directory filesystems, by request path prefix.
+	dirsByPrefix map[string]fs.FS
+	fallback     Asset
 }
 
 // NewAssetHandler returns a new, empty Handler.
 func NewAssetHandler() *AssetHandler {
 	return &AssetHandler{
 		handlersByPath: map[string]func(http.ResponseWriter, *http.Request){},
+		dirsByPrefix:   map[string]fs.FS{},
 	}
 }
 
@@ -131,8 +143,96 @@ func (ah *AssetHandler) With(requestPath string, asset Asset) *AssetHandler {
 	return ah
 }
 
+// WithDir serves every file under the provided local directory beneath the
+// provided request path prefix, as by WithFS.
+func (ah *AssetHandler) WithDir(requestPrefix, dir string) *AssetHandler {
+	return ah.WithFS(requestPrefix, os.DirFS(dir))
+}
+
+// WithFS serves every file in the provided filesystem beneath the provided
+// request path prefix: for example, with the prefix '/static/', the file
+// 'js/main.js' is served at '/static/js/main.js'.  Requests for directories
+// are answered with the directory's 'index.html', if present.  Each file's
+// content type is determined by its extension or, if the extension is
+// unknown, by its contents.  Any filesystem previously served under that
+// prefix is replaced.
+func (ah *AssetHandler) WithFS(requestPrefix string, fsys fs.FS) *AssetHandler {
+	if !strings.HasSuffix(requestPrefix, "/") {
+		requestPrefix = requestPrefix + "/"
+	}
+	ah.dirsByPrefix[requestPrefix] = fsys
+	return ah
+}
+
+// WithSPAFallback configures the receiver to answer GET and HEAD requests for
+// unknown paths with the provided Asset, typically a single-page
+// application's index.html, so that the application's client-side routes
+// load it.  Requests for unknown paths whose final element has an extension,
+// such as '/missing.js', are assumed to be for missing assets, and still
+// yield HTTP status 404 (Not Found).
+func (ah *AssetHandler) WithSPAFallback(index Asset) *AssetHandler {
+	ah.fallback = index
+	return ah
+}
+
+// notFound answers the provided request for an unknown path, with the
+// receiver's SPA fallback if appropriate.
+func (ah *AssetHandler) notFound(w http.ResponseWriter, req *http.Request) {
+	if ah.fallback != nil && (req.Method == http.MethodGet || req.Method == http.MethodHead) && path.Ext(req.URL.Path) == "" {
+		ah.fallback.HTTPHandler(w, req)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+// dirHandler returns a handler serving files from the provided filesystem
+// beneath the provided request path prefix.
+func (ah *AssetHandler) dirHandler(requestPrefix string, fsys fs.FS) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		// Cleaning the rooted path removes any '..' elements.
+		name, ok := strings.CutPrefix(path.Clean("/"+req.URL.Path)+"/", requestPrefix)
+		if !ok {
+			ah.notFound(w, req)
+			return
+		}
+		name = strings.TrimSuffix(name, "/")
+		if name == "" {
+			name = "."
+		}
+		info, err := fs.Stat(fsys, name)
+		if err == nil && info.IsDir() {
+			name = path.Join(name, "index.html")
+			info, err = fs.Stat(fsys, name)
+		}
+		if err != nil || info.IsDir() {
+			ah.notFound(w, req)
+			return
+		}
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			http.Error(w, "Failed to fetch asset at "+safehtml.HTMLEscaped(req.URL.Path).String()+": "+safehtml.HTMLEscaped(err.Error()).String(), http.StatusInternalServerError)
+			return
+		}
+		http.ServeContent(w, req, name, info.ModTime(), bytes.NewReader(contents))
+	}
+}
+
 // HandlersByPath returns a mapping of HTTP request path to HTTP handler for
-// this Handler.
+// this Handler.  If an SPA fallback is set, and no directory is served at '/',
+// the fallback is served at '/', and so answers all otherwise unhandled
+// requests.
 func (ah *AssetHandler) HandlersByPath() map[string]func(http.ResponseWriter, *http.Request) {
-	return ah.handlersByPath
+	ret := make(map[string]func(http.ResponseWriter, *http.Request), len(ah.handlersByPath)+len(ah.dirsByPrefix)+1)
+	for requestPath, handler := range ah.handlersByPath {
+		ret[requestPath] = handler
+	}
+	for requestPrefix, fsys := range ah.dirsByPrefix {
+		if _, ok := ret[requestPrefix]; !ok {
+			ret[requestPrefix] = ah.dirHandler(requestPrefix, fsys)
+		}
+	}
+	if _, ok := ret["/"]; !ok && ah.fallback != nil {
+		ret["/"] = ah.notFound
+	}
+	return ret
 }
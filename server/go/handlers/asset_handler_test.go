@@ -0,0 +1,158 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAssetHandler(t *testing.T) {
+	// The served directory is 'public'; 'secret.txt' lies beside it, and must
+	// not be reachable.
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"secret.txt":             "secret",
+		"public/index.html":      "<html><body>dir</body></html>",
+		"public/js/main.js":      "console.log('hi');",
+		"public/docs/index.html": "<html><body>docs</body></html>",
+	} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %s", err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write file: %s", err)
+		}
+	}
+	app := fstest.MapFS{
+		"index.html": {Data: []byte("<html><body>app</body></html>")},
+		"data/blob":  {Data: []byte("%PDF-1.4")},
+	}
+	ea, err := NewEmbeddedAssets(app, "/app/")
+	if err != nil {
+		t.Fatalf("NewEmbeddedAssets() yielded unexpected error %s", err)
+	}
+	index, ok := ea.Asset("index.html")
+	if !ok {
+		t.Fatalf("Asset('index.html') found no asset")
+	}
+	handlers := NewAssetHandler().
+		WithDir("/static", filepath.Join(root, "public")).
+		WithFS("/app/", app).
+		WithSPAFallback(index).
+		HandlersByPath()
+	mux := http.NewServeMux()
+	for path, handler := range handlers {
+		mux.HandleFunc(path, handler)
+	}
+	for _, test := range []struct {
+		description string
+		method      string
+		path        string
+		// If true, the request is passed directly to the '/static/'
+		// handler, as ServeMux would otherwise clean its path.
+		direct          bool
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{{
+		description:     "file from directory",
+		path:            "/static/js/main.js",
+		wantStatus:      http.StatusOK,
+		wantContentType: "text/javascript; charset=utf-8",
+		wantBody:        "console.log('hi');",
+	}, {
+		description:     "directory index",
+		path:            "/static/docs/",
+		wantStatus:      http.StatusOK,
+		wantContentType: "text/html; charset=utf-8",
+		wantBody:        "<html><body>docs</body></html>",
+	}, {
+		description:     "content type detected",
+		path:            "/app/data/blob",
+		wantStatus:      http.StatusOK,
+		wantContentType: "application/pdf",
+		wantBody:        "%PDF-1.4",
+	}, {
+		description:     "SPA route",
+		path:            "/timeline/123",
+		wantStatus:      http.StatusOK,
+		wantContentType: "text/html; charset=utf-8",
+		wantBody:        "<html><body>app</body></html>",
+	}, {
+		description:     "SPA route beneath a directory",
+		path:            "/static/some/route",
+		wantStatus:      http.StatusOK,
+		wantContentType: "text/html; charset=utf-8",
+		wantBody:        "<html><body>app</body></html>",
+	}, {
+		description: "missing asset",
+		path:        "/static/missing.js",
+		wantStatus:  http.StatusNotFound,
+	}, {
+		description: "SPA route with unsafe method",
+		method:      http.MethodPost,
+		path:        "/timeline/123",
+		wantStatus:  http.StatusNotFound,
+	}, {
+		description: "traversal",
+		path:        "/static/../secret.txt",
+		direct:      true,
+		wantStatus:  http.StatusNotFound,
+	}, {
+		description: "escaped traversal",
+		path:        "/static/..%2fsecret.txt",
+		direct:      true,
+		wantStatus:  http.StatusNotFound,
+	}, {
+		description: "nested traversal",
+		path:        "/static/js/../../../secret.txt",
+		direct:      true,
+		wantStatus:  http.StatusNotFound,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, test.path, nil)
+			handler, _ := mux.Handler(req)
+			if test.direct {
+				handler = http.HandlerFunc(handlers["/static/"])
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Fatalf("Got status %d, wanted %d (body %q)", rec.Code, test.wantStatus, rec.Body.String())
+			}
+			if test.wantStatus != http.StatusOK {
+				if got := rec.Body.String(); got == "secret" {
+					t.Errorf("Served a file outside the served directory")
+				}
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("Got Content-Type '%s', wanted '%s'", got, test.wantContentType)
+			}
+			if got := rec.Body.String(); got != test.wantBody {
+				t.Errorf("Got body '%s', wanted '%s'", got, test.wantBody)
+			}
+		})
+	}
+}
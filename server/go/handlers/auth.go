@@ -0,0 +1,144 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+var (
+	// ErrUnauthenticated is returned by Authenticators unable to establish the
+	// principal making a request.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrPermissionDenied is returned by Authorizers denying a request.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Authenticator establishes the Principal making an HTTP request.
+type Authenticator interface {
+	// Authenticate returns the Principal making the provided request, or an
+	// error if the principal cannot be established.
+	Authenticate(req *http.Request) (*util.Principal, error)
+}
+
+// Authorizer decides whether DataRequests may be handled.  The authenticated
+// Principal, if any, is available from the provided Context via
+// util.PrincipalFrom.
+type Authorizer interface {
+	// Authorize returns nil if the provided DataRequest may be handled, and an
+	// error otherwise.
+	Authorize(ctx context.Context, req *util.DataRequest) error
+}
+
+// AuthorizerFunc is an Authorizer implemented by a function.
+type AuthorizerFunc func(ctx context.Context, req *util.DataRequest) error
+
+// Authorize invokes the receiver.
+func (af AuthorizerFunc) Authorize(ctx context.Context, req *util.DataRequest) error {
+	return af(ctx, req)
+}
+
+// DenyAll returns an Authorizer denying all DataRequests.
+func DenyAll() Authorizer {
+	return AuthorizerFunc(func(ctx context.Context, req *util.DataRequest) error {
+		return ErrPermissionDenied
+	})
+}
+
+// AllowAll returns an Authorizer allowing all DataRequests.  It is suitable
+// only for servers that are not shared, or that are protected by other means.
+func AllowAll() Authorizer {
+	return AuthorizerFunc(func(ctx context.Context, req *util.DataRequest) error {
+		return nil
+	})
+}
+
+// HeaderAuth is an example Authenticator and Authorizer.  It authenticates
+// principals by the value of a request header, as might be set by a trusted
+// authenticating proxy, and authorizes DataRequests only from principals in
+// an allowlist.  Since headers are easily forged, HeaderAuth must only be
+// used behind such a proxy.
+type HeaderAuth struct {
+	header  string
+	allowed map[string]struct{}
+}
+
+// NewHeaderAuth returns a new HeaderAuth authenticating principals by the
+// specified header, and allowing the specified principal names.
+func NewHeaderAuth(header string, allowedPrincipals ...string) *HeaderAuth {
+	ha := &HeaderAuth{
+		header:  header,
+		allowed: map[string]struct{}{},
+	}
+	for _, name := range allowedPrincipals {
+		ha.allowed[name] = struct{}{}
+	}
+	return ha
+}
+
+// Authenticate returns a Principal named by the receiver's header.
+func (ha *HeaderAuth) Authenticate(req *http.Request) (*util.Principal, error) {
+	name := req.Header.Get(ha.header)
+	if name == "" {
+		return nil, fmt.Errorf("%w: missing header '%s'", ErrUnauthenticated, ha.header)
+	}
+	return &util.Principal{
+		Name: name,
+	}, nil
+}
+
+// Authorize allows DataRequests from allowlisted principals.
+func (ha *HeaderAuth) Authorize(ctx context.Context, req *util.DataRequest) error {
+	p := util.PrincipalFrom(ctx)
+	if p == nil {
+		return ErrUnauthenticated
+	}
+	if _, ok := ha.allowed[p.Name]; !ok {
+		return fmt.Errorf("%w: principal '%s' is not allowed", ErrPermissionDenied, p.Name)
+	}
+	return nil
+}
+
+// RequireAuth returns a WrapFunc that authenticates each request with the
+// provided Authenticator, if it is non-nil, and authorizes it with the
+// provided Authorizer as an empty DataRequest, responding with HTTP status 401
+// (Unauthorized) or 403 (Forbidden) on failure.  It applies a QueryHandler's
+// policy to endpoints that aren't data queries, such as status pages; only
+// Authorizers deciding by Principal alone, like HeaderAuth, are meaningful
+// for them.
+func RequireAuth(authenticator Authenticator, authorizer Authorizer) WrapFunc {
+	return func(hf HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			ctx := req.Context()
+			if authenticator != nil {
+				principal, err := authenticator.Authenticate(req)
+				if err != nil {
+					http.Error(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+					return
+				}
+				ctx = util.WithPrincipal(ctx, principal)
+			}
+			if err := authorizer.Authorize(ctx, &util.DataRequest{}); err != nil {
+				http.Error(w, "Request not authorized: "+err.Error(), http.StatusForbidden)
+				return
+			}
+			hf(w, req.WithContext(ctx))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/util"
+)
+
+func TestHandlersByPathRequiresAuth(t *testing.T) {
+	qd, err := querydispatcher.New(&testDataSource{queries: []string{"test.query"}})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("HandlersByPath() without Auth did not panic")
+		}
+	}()
+	NewQueryHandler(qd).HandlersByPath()
+}
+
+func TestQueryHandlerAuth(t *testing.T) {
+	const header = "X-Test-User"
+	headerAuth := NewHeaderAuth(header, "alice")
+	form := encodeDataRequest(t, &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			"collection_name": util.StringValue("a"),
+		},
+		SeriesRequests: seriesRequests(1),
+	}).Encode()
+	for _, test := range []struct {
+		description   string
+		authenticator Authenticator
+		authorizer    Authorizer
+		user          string
+		wantStatus    int
+	}{{
+		description: "allow all",
+		authorizer:  AllowAll(),
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "deny all",
+		authorizer:  DenyAll(),
+		wantStatus:  http.StatusForbidden,
+	}, {
+		description:   "unauthenticated",
+		authenticator: headerAuth,
+		authorizer:    headerAuth,
+		wantStatus:    http.StatusUnauthorized,
+	}, {
+		description:   "unauthorized",
+		authenticator: headerAuth,
+		authorizer:    headerAuth,
+		user:          "mallory",
+		wantStatus:    http.StatusForbidden,
+	}, {
+		description:   "authorized",
+		authenticator: headerAuth,
+		authorizer:    headerAuth,
+		user:          "alice",
+		wantStatus:    http.StatusOK,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			qd, err := querydispatcher.New(&testDataSource{queries: []string{"test.query"}})
+			if err != nil {
+				t.Fatalf("Failed to create QueryDispatcher: %s", err)
+			}
+			handlers := NewQueryHandler(qd).Auth(test.authenticator, test.authorizer).HandlersByPath()
+			for _, path := range []string{dataMethod, warmupMethod, changesMethod} {
+				req := httptest.NewRequest(http.MethodGet, path+"?"+form, nil)
+				if test.user != "" {
+					req.Header.Set(header, test.user)
+				}
+				rec := httptest.NewRecorder()
+				handlers[path](rec, req)
+				if rec.Code != test.wantStatus {
+					t.Errorf("%s: got status %d, wanted %d (body %q)", path, rec.Code, test.wantStatus, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	const header = "X-Test-User"
+	headerAuth := NewHeaderAuth(header, "alice")
+	var gotPrincipal *util.Principal
+	handler := RequireAuth(headerAuth, headerAuth)(func(w http.ResponseWriter, req *http.Request) {
+		gotPrincipal = util.PrincipalFrom(req.Context())
+	})
+	for _, test := range []struct {
+		user       string
+		wantStatus int
+	}{{
+		wantStatus: http.StatusUnauthorized,
+	}, {
+		user:       "mallory",
+		wantStatus: http.StatusForbidden,
+	}, {
+		user:       "alice",
+		wantStatus: http.StatusOK,
+	}} {
+		gotPrincipal = nil
+		req := httptest.NewRequest(http.MethodGet, "/statusz", nil)
+		if test.user != "" {
+			req.Header.Set(header, test.user)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != test.wantStatus {
+			t.Errorf("User '%s': got status %d, wanted %d", test.user, rec.Code, test.wantStatus)
+		}
+		if test.wantStatus == http.StatusOK && (gotPrincipal == nil || gotPrincipal.Name != test.user) {
+			t.Errorf("User '%s': handler got Principal %v", test.user, gotPrincipal)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// changesHandler streams Server-Sent Events notifying the client each time
+// the collection specified by a DataRequest changes, so that the client may
+// re-issue its queries.  As for Warmup, the 'req' form value holds a
+// JSON-encoded DataRequest whose global filters specify the collection; its
+// series requests are ignored.  A 'changed' event is sent on each change.  If
+// changes to the collection cannot be observed, an 'error' event carrying the
+// JSON-encoded error message is sent, and the stream ends.  Opening a stream
+// is subject to the receiver's rate and concurrency limits, but, as streams
+// are long-lived, an open stream holds no concurrent request slot.
+func (qh *queryHandler) changesHandler(w http.ResponseWriter, req *http.Request) {
+	dataReq := &util.DataRequest{}
+	if !qh.parseForm(w, req) {
+		return
+	}
+	if err := json.Unmarshal([]byte(req.Form.Get("req")), &dataReq); err != nil {
+		http.Error(w, "Failed to parse DataRequest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dataReq.SeriesRequests = nil
+	if re := qh.requestLimits.checkDataRequest(dataReq); re != nil {
+		badRequest(w, re)
+		return
+	}
+	ctx, principal, err := qh.authenticate(req.Context(), req)
+	if err != nil {
+		http.Error(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	release, ok := qh.limit(w, req, principal)
+	if !ok {
+		return
+	}
+	release()
+	if err := qh.authorizer.Authorize(ctx, dataReq); err != nil {
+		http.Error(w, "Change notifications not authorized: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// Send a comment, so that the client sees the stream open.
+	fmt.Fprint(w, ": watching\n\n")
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	for {
+		if err := qh.qd.WaitForChange(ctx, dataReq.GlobalFilters); err != nil {
+			if ctx.Err() == nil {
+				msg, _ := json.Marshal(err.Error())
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", msg)
+				rc.Flush()
+			}
+			return
+		}
+		fmt.Fprint(w, "event: changed\ndata: {}\n\n")
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
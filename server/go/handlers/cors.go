@@ -0,0 +1,93 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures Cross-Origin Resource Sharing for TraceViz handlers,
+// permitting a frontend served from one origin to query a data service on
+// another.
+type CORSConfig struct {
+	// The origins, such as "https://traceviz.example.com", allowed to make
+	// requests.  "*" allows any origin, but may not be combined with
+	// AllowCredentials.
+	AllowedOrigins []string
+	// The methods allowed in cross-origin requests.  Defaults to GET and POST.
+	AllowedMethods []string
+	// Any non-simple request headers, such as a CSRF token header, allowed in
+	// cross-origin requests.
+	AllowedHeaders []string
+	// If true, cross-origin requests may include credentials such as cookies.
+	AllowCredentials bool
+	// If positive, how long browsers may cache preflight responses.
+	MaxAge time.Duration
+}
+
+// CORS returns a WrapFunc applying the provided CORSConfig.  Preflight
+// (OPTIONS) requests from allowed origins are answered directly, without
+// invoking the wrapped HandlerFunc.  Since wrappers are applied in order,
+// CORS should be the last WrapFunc provided to a QueryHandler, so that it
+// answers preflight requests before any other wrapper sees them.
+func CORS(config CORSConfig) WrapFunc {
+	allowAny := false
+	allowed := map[string]struct{}{}
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+		allowed[origin] = struct{}{}
+	}
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodPost}
+	}
+	return func(hf HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			origin := req.Header.Get("Origin")
+			_, ok := allowed[origin]
+			if origin == "" || !(ok || allowAny) {
+				hf(w, req)
+				return
+			}
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if allowAny && !config.AllowCredentials {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if config.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+				hf(w, req)
+				return
+			}
+			// Answer the preflight request.
+			h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(config.AllowedHeaders) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(config.AllowedHeaders, ", "))
+			}
+			if config.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	defaultCSRFHeaderName = "X-CSRF-Token"
+	defaultCSRFCookieName = "traceviz_csrf"
+	csrfTokenMethod       = "/CSRFToken"
+	csrfTokenBytes        = 32
+)
+
+// CSRFConfig configures Cross-Site Request Forgery protection.
+type CSRFConfig struct {
+	// The request header carrying the CSRF token.  Defaults to X-CSRF-Token.
+	HeaderName string
+	// The cookie holding the CSRF token.  Defaults to traceviz_csrf.
+	CookieName string
+	// If true, the cookie is only sent over HTTPS.
+	Secure bool
+	// The SameSite attribute of the cookie.  Defaults to Lax; cross-origin
+	// deployments should use None, which requires Secure.
+	SameSite http.SameSite
+}
+
+// CSRFProtection protects TraceViz handlers against Cross-Site Request
+// Forgery using the double-submit pattern: clients fetch a token from its
+// /CSRFToken handler, which also sets that token in a cookie, and must send
+// the token in a request header with each protected request.  Since other
+// origins can neither read the token nor set the header, they cannot forge
+// protected requests.
+type CSRFProtection struct {
+	config CSRFConfig
+}
+
+// NewCSRFProtection returns a new CSRFProtection with the provided
+// configuration.
+func NewCSRFProtection(config CSRFConfig) *CSRFProtection {
+	if config.HeaderName == "" {
+		config.HeaderName = defaultCSRFHeaderName
+	}
+	if config.CookieName == "" {
+		config.CookieName = defaultCSRFCookieName
+	}
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+	return &CSRFProtection{
+		config: config,
+	}
+}
+
+// HeaderName returns the request header in which clients must send the CSRF
+// token.  Cross-origin deployments must allow this header in their
+// CORSConfig.
+func (cp *CSRFProtection) HeaderName() string {
+	return cp.config.HeaderName
+}
+
+// HandlersByPath returns a mapping of HTTP request path to HTTP handler for
+// the receiver's token handler.
+func (cp *CSRFProtection) HandlersByPath() map[string]func(http.ResponseWriter, *http.Request) {
+	return map[string]func(http.ResponseWriter, *http.Request){
+		csrfTokenMethod: cp.tokenHandler,
+	}
+}
+
+// tokenHandler responds with the client's CSRF token as JSON, issuing a new
+// token cookie if the client lacks one.
+func (cp *CSRFProtection) tokenHandler(w http.ResponseWriter, req *http.Request) {
+	token := ""
+	if cookie, err := req.Cookie(cp.config.CookieName); err == nil && len(cookie.Value) == 2*csrfTokenBytes {
+		token = cookie.Value
+	} else {
+		buf := make([]byte, csrfTokenBytes)
+		if _, err := rand.Read(buf); err != nil {
+			http.Error(w, "Failed to generate CSRF token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		token = hex.EncodeToString(buf)
+		http.SetCookie(w, &http.Cookie{
+			Name:     cp.config.CookieName,
+			Value:    token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   cp.config.Secure,
+			SameSite: cp.config.SameSite,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"header": cp.config.HeaderName,
+		"token":  token,
+	})
+}
+
+// exempt returns true if the provided request needn't carry a CSRF token:
+// safe-method requests for exports and renders, which browsers make by
+// navigating to a download link or loading an image, and so cannot add a
+// header to.  Since these change nothing, and other origins cannot read their
+// responses, they needn't be protected.
+func exempt(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	switch req.URL.Path {
+	case csvMethod, tsvMethod, svgMethod, pngMethod:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wrap is a WrapFunc rejecting, with HTTP status 403, requests whose CSRF
+// token header doesn't match their token cookie.  GET and HEAD requests for
+// CSV and TSV exports, and for SVG and PNG renders, are exempt.
+func (cp *CSRFProtection) Wrap(hf HandlerFunc) HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if exempt(req) {
+			hf(w, req)
+			return
+		}
+		cookie, err := req.Cookie(cp.config.CookieName)
+		header := req.Header.Get(cp.config.HeaderName)
+		if err != nil || header == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			http.Error(w, "Missing or invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		hf(w, req)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFToken(t *testing.T) {
+	cp := NewCSRFProtection(CSRFConfig{Secure: true})
+	handler := cp.HandlersByPath()[csrfTokenMethod]
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, csrfTokenMethod, nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Got %d cookies, wanted 1", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Name != defaultCSRFCookieName || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Got cookie %v, wanted a secure, HTTP-only, SameSite=Lax '%s' cookie", cookie, defaultCSRFCookieName)
+	}
+	got := map[string]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal token response: %s", err)
+	}
+	if got["header"] != defaultCSRFHeaderName || got["token"] != cookie.Value || len(cookie.Value) != 2*csrfTokenBytes {
+		t.Errorf("Got token response %v for cookie value '%s'", got, cookie.Value)
+	}
+	// A client already holding a token gets it back, with no new cookie.
+	req := httptest.NewRequest(http.MethodGet, csrfTokenMethod, nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	handler(rec, req)
+	if len(rec.Result().Cookies()) != 0 {
+		t.Errorf("Got a new cookie for a client holding a token")
+	}
+	got = map[string]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal token response: %s", err)
+	}
+	if got["token"] != cookie.Value {
+		t.Errorf("Got token '%s', wanted the existing token '%s'", got["token"], cookie.Value)
+	}
+}
+
+func TestCSRFWrap(t *testing.T) {
+	const (
+		token  = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		origin = "https://traceviz.example.com"
+	)
+	cp := NewCSRFProtection(CSRFConfig{})
+	cors := CORS(CORSConfig{
+		AllowedOrigins:   []string{origin},
+		AllowedHeaders:   []string{cp.HeaderName()},
+		AllowCredentials: true,
+	})
+	handler := cors(cp.Wrap(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	for _, test := range []struct {
+		description string
+		method      string
+		path        string
+		cookie      string
+		header      string
+		preflight   bool
+		wantStatus  int
+	}{{
+		description: "matching token",
+		method:      http.MethodPost,
+		path:        dataMethod,
+		cookie:      token,
+		header:      token,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "missing header",
+		method:      http.MethodPost,
+		path:        dataMethod,
+		cookie:      token,
+		wantStatus:  http.StatusForbidden,
+	}, {
+		description: "missing cookie",
+		method:      http.MethodGet,
+		path:        dataMethod,
+		header:      token,
+		wantStatus:  http.StatusForbidden,
+	}, {
+		description: "mismatched token",
+		method:      http.MethodGet,
+		path:        dataMethod,
+		cookie:      token,
+		header:      "forged",
+		wantStatus:  http.StatusForbidden,
+	}, {
+		description: "CSV download",
+		method:      http.MethodGet,
+		path:        csvMethod,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "TSV download",
+		method:      http.MethodHead,
+		path:        tsvMethod,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "CSV export by POST",
+		method:      http.MethodPost,
+		path:        csvMethod,
+		wantStatus:  http.StatusForbidden,
+	}, {
+		description: "SVG render",
+		method:      http.MethodGet,
+		path:        svgMethod,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "PNG render",
+		method:      http.MethodHead,
+		path:        pngMethod,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "PNG render by POST",
+		method:      http.MethodPost,
+		path:        pngMethod,
+		wantStatus:  http.StatusForbidden,
+	}, {
+		description: "preflight",
+		method:      http.MethodOptions,
+		path:        dataMethod,
+		preflight:   true,
+		wantStatus:  http.StatusNoContent,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.Header.Set("Origin", origin)
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: defaultCSRFCookieName, Value: test.cookie})
+			}
+			if test.header != "" {
+				req.Header.Set(defaultCSRFHeaderName, test.header)
+			}
+			if test.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				req.Header.Set("Access-Control-Request-Headers", defaultCSRFHeaderName)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Errorf("Got status %d, wanted %d", rec.Code, test.wantStatus)
+			}
+			if test.preflight {
+				if got := rec.Header().Get("Access-Control-Allow-Headers"); got != defaultCSRFHeaderName {
+					t.Errorf("Got Access-Control-Allow-Headers '%s', wanted '%s'", got, defaultCSRFHeaderName)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// The number of hexadecimal digits of an asset's content hash included in
+	// its fingerprinted path.
+	fingerprintLen = 12
+
+	// Cache-Control headers for fingerprinted assets, whose contents never
+	// change, and for unfingerprinted ones, which must be revalidated.
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	revalidateCacheControl = "no-cache"
+)
+
+// embeddedAsset is a single static asset held in memory.
+type embeddedAsset struct {
+	contents    []byte
+	contentType string
+	etag        string
+	immutable   bool
+}
+
+// HTTPHandler serves the receiver, answering conditional requests against its
+// ETag.
+func (ea *embeddedAsset) HTTPHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ea.contentType)
+	w.Header().Set("ETag", ea.etag)
+	if ea.immutable {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", revalidateCacheControl)
+	}
+	http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(ea.contents))
+}
+
+// EmbeddedAssets is a Handler serving static assets from a filesystem, such as
+// an embed.FS populated with go:embed, so that a server may be shipped as a
+// single self-contained binary.  Assets are read once, when the
+// EmbeddedAssets is created.
+//
+// Each asset is served under its path within the filesystem, prefixed with the
+// EmbeddedAssets' request path prefix, and also under a fingerprinted path
+// that includes a hash of the asset's contents: for example, 'main.js' might
+// also be served as 'main.3f2a9c0b71de.js'.  Fingerprinted paths change
+// whenever their contents do, so they are served as immutable, and may be
+// cached indefinitely; unfingerprinted paths must be revalidated against the
+// asset's ETag on each use.  Use Path to find the fingerprinted path of an
+// asset.
+type EmbeddedAssets struct {
+	prefix       string
+	assetsByPath map[string]*embeddedAsset
+	// Maps each asset's name to its fingerprinted request path.
+	fingerprintedPaths map[string]string
+}
+
+// NewEmbeddedAssets returns a new EmbeddedAssets serving every file in the
+// provided filesystem under the provided request path prefix, such as '/' or
+// '/static/'.  Each asset's content type is determined by its extension or,
+// if the extension is unknown, by its contents.
+func NewEmbeddedAssets(fsys fs.FS, prefix string) (*EmbeddedAssets, error) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	ea := &EmbeddedAssets{
+		prefix:             prefix,
+		assetsByPath:       map[string]*embeddedAsset{},
+		fingerprintedPaths: map[string]string{},
+	}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		contents, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = http.DetectContentType(contents)
+		}
+		sum := sha256.Sum256(contents)
+		hash := hex.EncodeToString(sum[:])[:fingerprintLen]
+		etag := fmt.Sprintf("%q", hash)
+		fingerprintedPath := prefix + fingerprint(name, hash)
+		ea.assetsByPath[prefix+name] = &embeddedAsset{
+			contents:    contents,
+			contentType: contentType,
+			etag:        etag,
+		}
+		ea.assetsByPath[fingerprintedPath] = &embeddedAsset{
+			contents:    contents,
+			contentType: contentType,
+			etag:        etag,
+			immutable:   true,
+		}
+		ea.fingerprintedPaths[name] = fingerprintedPath
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded assets: %s", err)
+	}
+	return ea, nil
+}
+
+// fingerprint returns the provided asset name with the provided hash inserted
+// before its extension.
+func fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// Path returns the fingerprinted request path of the asset with the provided
+// name, which is its path within the filesystem, for use in links to that
+// asset.  Returns false if there is no such asset.
+func (ea *EmbeddedAssets) Path(name string) (string, bool) {
+	ret, ok := ea.fingerprintedPaths[name]
+	return ret, ok
+}
+
+// Asset returns the asset with the provided name, which is its path within
+// the filesystem, served as from its unfingerprinted path; for example, for
+// use as an AssetHandler's SPA fallback.  Returns false if there is no such
+// asset.
+func (ea *EmbeddedAssets) Asset(name string) (Asset, bool) {
+	asset, ok := ea.assetsByPath[ea.prefix+name]
+	if !ok {
+		return nil, false
+	}
+	return asset, true
+}
+
+// HandlersByPath returns a mapping of HTTP request path to HTTP handler for
+// this Handler.
+func (ea *EmbeddedAssets) HandlersByPath() map[string]func(http.ResponseWriter, *http.Request) {
+	ret := make(map[string]func(http.ResponseWriter, *http.Request), len(ea.assetsByPath))
+	for requestPath, asset := range ea.assetsByPath {
+		ret[requestPath] = asset.HTTPHandler
+	}
+	return ret
+}
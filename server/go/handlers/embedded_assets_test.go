@@ -0,0 +1,119 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"testing/fstest"
+)
+
+var testAssets = fstest.MapFS{
+	"index.html":   {Data: []byte("<html><body>TraceViz</body></html>")},
+	"js/main.js":   {Data: []byte("console.log('hi');")},
+	"data/LICENSE": {Data: []byte("Apache License")},
+}
+
+func TestEmbeddedAssets(t *testing.T) {
+	ea, err := NewEmbeddedAssets(testAssets, "/static")
+	if err != nil {
+		t.Fatalf("NewEmbeddedAssets() yielded unexpected error %s", err)
+	}
+	fingerprintedMain, ok := ea.Path("js/main.js")
+	if !ok {
+		t.Fatalf("Path('js/main.js') found no asset")
+	}
+	if !regexp.MustCompile(`^/static/js/main\.[0-9a-f]{12}\.js$`).MatchString(fingerprintedMain) {
+		t.Errorf("Path('js/main.js') = '%s', wanted a fingerprinted path", fingerprintedMain)
+	}
+	if _, ok := ea.Path("missing.js"); ok {
+		t.Errorf("Path('missing.js') found an asset, wanted none")
+	}
+	handlers := ea.HandlersByPath()
+	for _, test := range []struct {
+		description      string
+		path             string
+		wantContentType  string
+		wantCacheControl string
+		wantBody         string
+	}{{
+		description:      "unfingerprinted",
+		path:             "/static/js/main.js",
+		wantContentType:  "text/javascript; charset=utf-8",
+		wantCacheControl: revalidateCacheControl,
+		wantBody:         "console.log('hi');",
+	}, {
+		description:      "fingerprinted",
+		path:             fingerprintedMain,
+		wantContentType:  "text/javascript; charset=utf-8",
+		wantCacheControl: immutableCacheControl,
+		wantBody:         "console.log('hi');",
+	}, {
+		description:      "html",
+		path:             "/static/index.html",
+		wantContentType:  "text/html; charset=utf-8",
+		wantCacheControl: revalidateCacheControl,
+		wantBody:         "<html><body>TraceViz</body></html>",
+	}, {
+		description:      "content type detected",
+		path:             "/static/data/LICENSE",
+		wantContentType:  "text/plain; charset=utf-8",
+		wantCacheControl: revalidateCacheControl,
+		wantBody:         "Apache License",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			handler, ok := handlers[test.path]
+			if !ok {
+				t.Fatalf("No handler for path '%s'", test.path)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Got status %d, wanted %d", rec.Code, http.StatusOK)
+			}
+			if got := rec.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("Got Content-Type '%s', wanted '%s'", got, test.wantContentType)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != test.wantCacheControl {
+				t.Errorf("Got Cache-Control '%s', wanted '%s'", got, test.wantCacheControl)
+			}
+			if got := rec.Body.String(); got != test.wantBody {
+				t.Errorf("Got body '%s', wanted '%s'", got, test.wantBody)
+			}
+			// A conditional request with the asset's ETag is not modified.
+			etag := rec.Header().Get("ETag")
+			if etag == "" {
+				t.Fatalf("Got no ETag")
+			}
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			req.Header.Set("If-None-Match", etag)
+			rec = httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != http.StatusNotModified {
+				t.Errorf("Got status %d for conditional request, wanted %d", rec.Code, http.StatusNotModified)
+			}
+		})
+	}
+	asset, ok := ea.Asset("index.html")
+	if !ok {
+		t.Fatalf("Asset('index.html') found no asset")
+	}
+	rec := httptest.NewRecorder()
+	asset.HTTPHandler(rec, httptest.NewRequest(http.MethodGet, "/some/route", nil))
+	if got := rec.Header().Get("Cache-Control"); got != revalidateCacheControl {
+		t.Errorf("Got Cache-Control '%s' for Asset(), wanted '%s'", got, revalidateCacheControl)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	exportQueryParam        = "query"
+	exportOptionParamPrefix = "option."
+)
+
+// exportRequest returns the DataRequest specified by the provided export
+// request's form.  This may be given in full, as JSON, in the 'req' form
+// value, as for GetData.  Otherwise, the 'query' form value names the query;
+// each 'option.<key>' form value sets a string-valued option <key>; and every
+// other form value sets a string-valued global filter.  Either way, the
+// DataRequest must contain exactly one DataSeriesRequest.
+func exportRequest(form url.Values) (*util.DataRequest, error) {
+	dataReq := &util.DataRequest{}
+	if reqJSON := form.Get("req"); reqJSON != "" {
+		if err := json.Unmarshal([]byte(reqJSON), dataReq); err != nil {
+			return nil, fmt.Errorf("failed to parse DataRequest: %s", err)
+		}
+	} else {
+		seriesReq := &util.DataSeriesRequest{
+			QueryName:  form.Get(exportQueryParam),
+			SeriesName: "export",
+			Options:    map[string]*util.V{},
+		}
+		if seriesReq.QueryName == "" {
+			return nil, fmt.Errorf("missing '%s' parameter", exportQueryParam)
+		}
+		dataReq.GlobalFilters = map[string]*util.V{}
+		for key, vals := range form {
+			if key == exportQueryParam || len(vals) == 0 {
+				continue
+			}
+			// String Values in requests are expected to be URL-escaped.
+			val := util.StringValue(url.QueryEscape(vals[0]))
+			if option, ok := strings.CutPrefix(key, exportOptionParamPrefix); ok {
+				seriesReq.Options[option] = val
+			} else {
+				dataReq.GlobalFilters[key] = val
+			}
+		}
+		dataReq.SeriesRequests = []*util.DataSeriesRequest{seriesReq}
+	}
+	if len(dataReq.SeriesRequests) != 1 {
+		return nil, fmt.Errorf("export requests must contain exactly one data series request")
+	}
+	// Exports always need the full series, and never a fingerprint.
+	dataReq.SeriesRequests[0].Fingerprint = ""
+	dataReq.SeriesRequests[0].WantFingerprint = false
+	return dataReq, nil
+}
+
+// exportWriter streams an export to an http.ResponseWriter, setting the
+// response's headers just before its first write, so that exports failing
+// before writing anything may still respond with an error.
+type exportWriter struct {
+	w          http.ResponseWriter
+	setHeaders func()
+	wrote      bool
+}
+
+func (ew *exportWriter) Write(data []byte) (int, error) {
+	if !ew.wrote {
+		ew.wrote = true
+		ew.setHeaders()
+	}
+	return ew.w.Write(data)
+}
+
+// exportHandler returns a HandlerFunc running a single table-producing query
+// and responding with the resulting table as delimited text, with the
+// specified field delimiter, content type, and file extension.
+func (qh *queryHandler) exportHandler(delimiter rune, contentType, extension string) HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !qh.parseForm(w, req) {
+			return
+		}
+		dataReq, err := exportRequest(req.Form)
+		if err != nil {
+			http.Error(w, "Bad export request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		qh.handleDataRequest(w, req, dataReq, func(resp *util.Data, w http.ResponseWriter) {
+			if len(resp.DataSeries) != 1 {
+				http.Error(w, "Export query produced no data series", http.StatusInternalServerError)
+				return
+			}
+			filename := dataReq.SeriesRequests[0].QueryName
+			ew := &exportWriter{
+				w: w,
+				setHeaders: func() {
+					w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+					w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+"."+extension))
+				},
+			}
+			if err := table.WriteCSV(ew, resp.DataSeries[0].Root, resp.StringTable, delimiter); err != nil {
+				if ew.wrote {
+					// The table is partly sent, so abort the response rather
+					// than leave the client with a truncated table.
+					panic(http.ErrAbortHandler)
+				}
+				http.Error(w, "Export query did not produce a table: "+err.Error(), http.StatusInternalServerError)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/category"
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	tableQuery      = "test.table"
+	emptyTableQuery = "test.empty_table"
+)
+
+// tableDataSource responds to 'test.table' with a small table, and to
+// 'test.empty_table' with a series that is not a table.
+type tableDataSource struct{}
+
+func (tds *tableDataSource) SupportedDataSeriesQueries() []string {
+	return []string{tableQuery, emptyTableQuery}
+}
+
+func (tds *tableDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		series := drb.DataSeries(req)
+		if req.QueryName != tableQuery {
+			continue
+		}
+		nameCol := table.Column(category.New("name", "Name", "The name"))
+		t := table.New(series, &table.RenderSettings{RowHeightPx: 20, FontSizePx: 14}, nameCol)
+		t.Row(table.Cell(nameCol, util.String("plain")))
+		t.Row(table.Cell(nameCol, util.String("=1+1")))
+	}
+	return nil
+}
+
+// failingResponseWriter is an http.ResponseWriter whose writes all fail.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (frw failingResponseWriter) Write(data []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func newExportHandlers(t *testing.T) map[string]func(http.ResponseWriter, *http.Request) {
+	t.Helper()
+	qd, err := querydispatcher.New(&tableDataSource{})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	return NewQueryHandler(qd).Auth(nil, AllowAll()).HandlersByPath()
+}
+
+func TestExport(t *testing.T) {
+	for _, test := range []struct {
+		description     string
+		path            string
+		query           string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{{
+		description:     "CSV",
+		path:            csvMethod,
+		query:           tableQuery,
+		wantStatus:      http.StatusOK,
+		wantContentType: "text/csv; charset=utf-8",
+		wantBody:        "Name\nplain\n'=1+1\n",
+	}, {
+		description:     "TSV",
+		path:            tsvMethod,
+		query:           tableQuery,
+		wantStatus:      http.StatusOK,
+		wantContentType: "text/tab-separated-values; charset=utf-8",
+		wantBody:        "Name\nplain\n'=1+1\n",
+	}, {
+		description:     "not a table",
+		path:            csvMethod,
+		query:           emptyTableQuery,
+		wantStatus:      http.StatusInternalServerError,
+		wantContentType: "text/plain; charset=utf-8",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			handler := newExportHandlers(t)[test.path]
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, test.path+"?query="+test.query, nil))
+			if rec.Code != test.wantStatus {
+				t.Fatalf("Got status %d, want %d (body %q)", rec.Code, test.wantStatus, rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("Got Content-Type %q, want %q", got, test.wantContentType)
+			}
+			if test.wantStatus != http.StatusOK {
+				if got := rec.Header().Get("Content-Disposition"); got != "" {
+					t.Errorf("Failed export got Content-Disposition %q, want none", got)
+				}
+				return
+			}
+			if diff := cmp.Diff(test.wantBody, strings.ReplaceAll(rec.Body.String(), "\r\n", "\n")); diff != "" {
+				t.Errorf("Got body diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExportAbortsPartialResponse(t *testing.T) {
+	handler := newExportHandlers(t)[csvMethod]
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("Got panic %v, want http.ErrAbortHandler", r)
+		}
+	}()
+	handler(failingResponseWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, csvMethod+"?query="+tableQuery, nil))
+}
@@ -0,0 +1,261 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// RequestInfo describes the handling of a single DataRequest.
+type RequestInfo struct {
+	// The handled DataRequest.
+	DataRequest *util.DataRequest
+	// The HTTP request carrying the DataRequest.
+	HTTPRequest *http.Request
+	// The authenticated Principal making the DataRequest, or nil if the
+	// request was not, or not yet, authenticated.
+	Principal *util.Principal
+	// The time at which handling began, and how long it took.
+	Start    time.Time
+	Duration time.Duration
+	// The HTTP status code of the response.
+	StatusCode int
+	// Any error encountered while handling the DataRequest.
+	Err error
+	// The errors of any data series that failed individually, by series
+	// name.
+	SeriesErrs map[string]string
+}
+
+// QueryNames returns the names of the queries in the described DataRequest,
+// in request order.
+func (ri *RequestInfo) QueryNames() []string {
+	ret := make([]string, 0, len(ri.DataRequest.SeriesRequests))
+	for _, seriesReq := range ri.DataRequest.SeriesRequests {
+		ret = append(ret, seriesReq.QueryName)
+	}
+	return ret
+}
+
+// FailedQueryNames returns the query names of the described DataRequest's
+// failed data series, in request order.
+func (ri *RequestInfo) FailedQueryNames() []string {
+	var ret []string
+	for _, seriesReq := range ri.DataRequest.SeriesRequests {
+		if _, ok := ri.SeriesErrs[seriesReq.SeriesName]; ok {
+			ret = append(ret, seriesReq.QueryName)
+		}
+	}
+	return ret
+}
+
+// Observer is notified of the handling of each DataRequest by a QueryHandler.
+// Observers may be used to emit request logs, record metrics, or open tracing
+// spans.  Observers must support concurrent use.
+type Observer interface {
+	// RequestStarted is invoked after a DataRequest is parsed, and before it is
+	// dispatched.  The returned Context is used to handle the DataRequest, so
+	// may carry, e.g., a tracing span.
+	RequestStarted(ctx context.Context, req *util.DataRequest) context.Context
+	// RequestFinished is invoked once the DataRequest has been handled, with
+	// the Context returned from RequestStarted.
+	RequestFinished(ctx context.Context, info *RequestInfo)
+}
+
+// logObserver is an Observer emitting a structured log line per DataRequest.
+type logObserver struct {
+	logger *log.Logger
+}
+
+// NewLogObserver returns an Observer logging each handled DataRequest, as
+// space-separated key=value pairs, to the provided Logger.
+func NewLogObserver(logger *log.Logger) Observer {
+	return &logObserver{
+		logger: logger,
+	}
+}
+
+func (lo *logObserver) RequestStarted(ctx context.Context, req *util.DataRequest) context.Context {
+	return ctx
+}
+
+func (lo *logObserver) RequestFinished(ctx context.Context, info *RequestInfo) {
+	errStr := ""
+	if info.Err != nil {
+		errStr = info.Err.Error()
+	}
+	lo.logger.Printf("traceviz_request remote_addr=%q queries=%q status=%d duration_ms=%.3f error=%q",
+		info.HTTPRequest.RemoteAddr,
+		strings.Join(info.QueryNames(), ","),
+		info.StatusCode,
+		float64(info.Duration)/float64(time.Millisecond),
+		errStr,
+	)
+}
+
+// queryMetrics holds the metrics for a single query name.
+type queryMetrics struct {
+	requests, errors uint64
+	seriesErrors     uint64
+	latencySeconds   float64
+}
+
+// unknownQueryName is the query name under which MetricsObservers record
+// queries not supported by their QueryDispatcher.
+const unknownQueryName = "unknown"
+
+// MetricsObserver is an Observer recording, for each query name, the number
+// of DataRequests including that query, the number of those that failed, and
+// their total latency.  It serves these metrics in the Prometheus text
+// exposition format.  Queries are recorded under the supported query name
+// that handles them, and unsupported queries under 'unknown', so that
+// clients cannot grow the set of recorded query names without bound.
+type MetricsObserver struct {
+	qd                 *querydispatcher.QueryDispatcher
+	mu                 sync.Mutex
+	metricsByQueryName map[string]*queryMetrics
+}
+
+// NewMetricsObserver returns a new, empty MetricsObserver recording the
+// queries supported by the provided QueryDispatcher.
+func NewMetricsObserver(qd *querydispatcher.QueryDispatcher) *MetricsObserver {
+	return &MetricsObserver{
+		qd:                 qd,
+		metricsByQueryName: map[string]*queryMetrics{},
+	}
+}
+
+// recordedQueryName returns the name under which the provided requested query
+// name is recorded.
+func (mo *MetricsObserver) recordedQueryName(queryName string) string {
+	if resolved, ok := mo.qd.ResolveQueryName(queryName); ok {
+		return resolved
+	}
+	return unknownQueryName
+}
+
+// labelValueEscaper escapes Prometheus label values, in which only
+// backslashes, double quotes, and newlines are escaped.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (mo *MetricsObserver) RequestStarted(ctx context.Context, req *util.DataRequest) context.Context {
+	return ctx
+}
+
+func (mo *MetricsObserver) RequestFinished(ctx context.Context, info *RequestInfo) {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+	metrics := func(queryName string) *queryMetrics {
+		qm, ok := mo.metricsByQueryName[queryName]
+		if !ok {
+			qm = &queryMetrics{}
+			mo.metricsByQueryName[queryName] = qm
+		}
+		return qm
+	}
+	seen := map[string]struct{}{}
+	for _, queryName := range info.QueryNames() {
+		queryName = mo.recordedQueryName(queryName)
+		if _, ok := seen[queryName]; ok {
+			continue
+		}
+		seen[queryName] = struct{}{}
+		qm := metrics(queryName)
+		qm.requests++
+		if info.Err != nil {
+			qm.errors++
+		}
+		qm.latencySeconds += info.Duration.Seconds()
+	}
+	for _, queryName := range info.FailedQueryNames() {
+		metrics(mo.recordedQueryName(queryName)).seriesErrors++
+	}
+}
+
+// ServeHTTP serves the receiver's metrics in the Prometheus text exposition
+// format.
+func (mo *MetricsObserver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+	queryNames := make([]string, 0, len(mo.metricsByQueryName))
+	for queryName := range mo.metricsByQueryName {
+		queryNames = append(queryNames, queryName)
+	}
+	sort.Strings(queryNames)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, metric := range []struct {
+		name, help, kind string
+		value            func(qm *queryMetrics) string
+	}{{
+		"traceviz_query_requests_total", "DataRequests including the query.", "counter",
+		func(qm *queryMetrics) string { return fmt.Sprint(qm.requests) },
+	}, {
+		"traceviz_query_errors_total", "Failed DataRequests including the query.", "counter",
+		func(qm *queryMetrics) string { return fmt.Sprint(qm.errors) },
+	}, {
+		"traceviz_query_series_errors_total", "Data series of the query that failed individually, e.g. by panicking.", "counter",
+		func(qm *queryMetrics) string { return fmt.Sprint(qm.seriesErrors) },
+	}, {
+		"traceviz_query_latency_seconds_total", "Total latency of DataRequests including the query.", "counter",
+		func(qm *queryMetrics) string { return fmt.Sprint(qm.latencySeconds) },
+	}} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for _, queryName := range queryNames {
+			fmt.Fprintf(w, "%s{query=\"%s\"} %s\n", metric.name, labelValueEscaper.Replace(queryName), metric.value(mo.metricsByQueryName[queryName]))
+		}
+	}
+}
+
+// SpanStarter starts a tracing span with the provided name as a child of any
+// span in the provided Context, returning a Context carrying the new span and
+// a function ending the span with the provided outcome.  With OpenTelemetry,
+// for example, a SpanStarter might call Tracer.Start, then record the error
+// and end the span in the returned function.
+type SpanStarter func(ctx context.Context, name string) (context.Context, func(err error))
+
+type spanKey struct{}
+
+// tracingObserver is an Observer opening a tracing span for each DataRequest.
+type tracingObserver struct {
+	startSpan SpanStarter
+}
+
+// NewTracingObserver returns an Observer opening a server span, using the
+// provided SpanStarter, around the handling of each DataRequest.
+func NewTracingObserver(startSpan SpanStarter) Observer {
+	return &tracingObserver{
+		startSpan: startSpan,
+	}
+}
+
+func (to *tracingObserver) RequestStarted(ctx context.Context, req *util.DataRequest) context.Context {
+	ctx, end := to.startSpan(ctx, "traceviz.GetData")
+	return context.WithValue(ctx, spanKey{}, end)
+}
+
+func (to *tracingObserver) RequestFinished(ctx context.Context, info *RequestInfo) {
+	if end, ok := ctx.Value(spanKey{}).(func(error)); ok {
+		end(info.Err)
+	}
+}
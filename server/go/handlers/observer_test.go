@@ -0,0 +1,113 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/util"
+)
+
+func TestMetricsObserver(t *testing.T) {
+	qd, err := querydispatcher.New(&testDataSource{queries: []string{"test.query", "test.query@v2"}})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	mo := NewMetricsObserver(qd)
+	requestInfo := func(err error, seriesErrs map[string]string, queryNames ...string) *RequestInfo {
+		ri := &RequestInfo{
+			DataRequest: &util.DataRequest{},
+			Duration:    500 * time.Millisecond,
+			Err:         err,
+			SeriesErrs:  seriesErrs,
+		}
+		for idx, queryName := range queryNames {
+			ri.DataRequest.SeriesRequests = append(ri.DataRequest.SeriesRequests, &util.DataSeriesRequest{
+				QueryName:  queryName,
+				SeriesName: string(rune('a' + idx)),
+			})
+		}
+		return ri
+	}
+	for _, ri := range []*RequestInfo{
+		// Versions are recorded under the supported version handling them.
+		requestInfo(nil, nil, "test.query@v1", "test.query@v2", "test.query@v7"),
+		requestInfo(errors.New("oops"), nil, "test.query@v1"),
+		requestInfo(nil, map[string]string{"b": "panic"}, "test.query@v2", "bogus"),
+		// Unsupported names, however crafted, are all recorded as 'unknown'.
+		requestInfo(nil, nil, "made.up", "made.up@v1", "evil\"} 1\n# TYPE"),
+	} {
+		mo.RequestFinished(mo.RequestStarted(context.Background(), ri.DataRequest), ri)
+	}
+	rec := httptest.NewRecorder()
+	mo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `# HELP traceviz_query_requests_total DataRequests including the query.
+# TYPE traceviz_query_requests_total counter
+traceviz_query_requests_total{query="test.query"} 2
+traceviz_query_requests_total{query="test.query@v2"} 2
+traceviz_query_requests_total{query="unknown"} 2
+# HELP traceviz_query_errors_total Failed DataRequests including the query.
+# TYPE traceviz_query_errors_total counter
+traceviz_query_errors_total{query="test.query"} 1
+traceviz_query_errors_total{query="test.query@v2"} 0
+traceviz_query_errors_total{query="unknown"} 0
+# HELP traceviz_query_series_errors_total Data series of the query that failed individually, e.g. by panicking.
+# TYPE traceviz_query_series_errors_total counter
+traceviz_query_series_errors_total{query="test.query"} 0
+traceviz_query_series_errors_total{query="test.query@v2"} 0
+traceviz_query_series_errors_total{query="unknown"} 1
+# HELP traceviz_query_latency_seconds_total Total latency of DataRequests including the query.
+# TYPE traceviz_query_latency_seconds_total counter
+traceviz_query_latency_seconds_total{query="test.query"} 1
+traceviz_query_latency_seconds_total{query="test.query@v2"} 1
+traceviz_query_latency_seconds_total{query="unknown"} 1
+`
+	if diff := cmp.Diff(want, rec.Body.String()); diff != "" {
+		t.Errorf("ServeHTTP() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestLabelValueEscaping(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  string
+	}{{
+		value: "logs.raw_entries@v2",
+		want:  "logs.raw_entries@v2",
+	}, {
+		value: `back\slash`,
+		want:  `back\\slash`,
+	}, {
+		value: `"quoted"`,
+		want:  `\"quoted\"`,
+	}, {
+		value: "new\nline",
+		want:  `new\nline`,
+	}, {
+		// Unlike Go string quoting, other characters are not escaped.
+		value: "tab\tand ünïcode",
+		want:  "tab\tand ünïcode",
+	}} {
+		if got := labelValueEscaper.Replace(test.value); got != test.want {
+			t.Errorf("labelValueEscaper.Replace(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
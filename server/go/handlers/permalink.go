@@ -0,0 +1,127 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/traceviz/server/go/permalink"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	savePermalinkMethod = "/SavePermalink"
+	getPermalinkMethod  = "/GetPermalink"
+)
+
+// PermalinkResponse is the JSON response to a SavePermalink request.
+type PermalinkResponse struct {
+	ID string
+}
+
+// PermalinkHandler is a Handler for saving and resolving permalinks.  It
+// supports a Wrap method that wraps all handlers.
+type PermalinkHandler interface {
+	Handler
+	Wrap(...WrapFunc) Handler
+}
+
+// permalinkHandler is a PermalinkHandler.
+type permalinkHandler struct {
+	links    *permalink.Links
+	wrappers []WrapFunc
+}
+
+// NewPermalinkHandler returns a new Handler saving and resolving permalinks
+// with the provided Links.  It serves two paths:
+//
+//   - '/SavePermalink', which persists the JSON-encoded DataRequest in the
+//     'req' form value, as for GetData, and responds with a JSON-encoded
+//     PermalinkResponse holding its ID;
+//   - '/GetPermalink', which responds with the JSON-encoded DataRequest saved
+//     under the ID in the 'id' form value.
+//
+// Resolving a permalink only yields the DataRequest; fetching its data is
+// subject to the QueryHandler's usual authorization.
+func NewPermalinkHandler(links *permalink.Links) PermalinkHandler {
+	return &permalinkHandler{
+		links: links,
+	}
+}
+
+func (ph *permalinkHandler) Wrap(wrappers ...WrapFunc) Handler {
+	ph.wrappers = append(ph.wrappers, wrappers...)
+	return ph
+}
+
+// HandlersByPath returns a mapping of HTTP request path to HTTP handler for
+// this Handler.
+func (ph *permalinkHandler) HandlersByPath() map[string]func(http.ResponseWriter, *http.Request) {
+	var sh, gh HandlerFunc = ph.saveHandler, ph.getHandler
+	for _, wrapper := range ph.wrappers {
+		sh, gh = wrapper(sh), wrapper(gh)
+	}
+	return map[string]func(http.ResponseWriter, *http.Request){
+		savePermalinkMethod: sh,
+		getPermalinkMethod:  gh,
+	}
+}
+
+func sendJSON(w http.ResponseWriter, resp any) {
+	respStr, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, "Failed to marshal response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprint(w, string(respStr))
+}
+
+func (ph *permalinkHandler) saveHandler(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dataReq, err := util.DataRequestFromJSON([]byte(req.Form.Get("req")))
+	if err != nil {
+		http.Error(w, "Failed to parse DataRequest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := ph.links.Save(req.Context(), dataReq)
+	if err != nil {
+		http.Error(w, "Failed to save permalink: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendJSON(w, &PermalinkResponse{ID: id})
+}
+
+func (ph *permalinkHandler) getHandler(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dataReq, err := ph.links.Load(req.Context(), req.Form.Get("id"))
+	if errors.Is(err, permalink.ErrNotFound) {
+		http.Error(w, "Permalink not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load permalink: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendJSON(w, dataReq)
+}
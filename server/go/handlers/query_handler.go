@@ -18,9 +18,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
 	"github.com/google/traceviz/server/go/util"
+	"github.com/google/traceviz/server/go/validation"
 )
 
 // HandlerFunc is a HTTP handler function.
@@ -35,10 +37,19 @@ type Handler interface {
 }
 
 // QueryHandler is a Handler for data queries.  It supports a Wrap method that
-// wraps all handlers, e.g. adding cookies.
+// wraps all handlers, e.g. adding cookies, an Observe method that adds
+// Observers of each handled DataRequest, an Auth method that configures
+// authentication and authorization, a Limit method that configures rate and
+// concurrency limits, a LimitRequests method that configures request size and
+// shape limits, and a Validate method that enables response validation.
 type QueryHandler interface {
 	Handler
 	Wrap(...WrapFunc) Handler
+	Observe(...Observer) QueryHandler
+	Auth(Authenticator, Authorizer) QueryHandler
+	Limit(Limits) QueryHandler
+	LimitRequests(RequestLimits) QueryHandler
+	Validate() QueryHandler
 }
 
 // sendHTTPResponse serializes the provided protobuf and sends it along the
@@ -55,12 +66,27 @@ func sendHTTPResponse(resp *util.Data, w http.ResponseWriter) {
 
 // queryHandler is an http.Handler serving TraceViz queries.
 type queryHandler struct {
-	qd       *querydispatcher.QueryDispatcher
-	wrappers []WrapFunc
+	qd        *querydispatcher.QueryDispatcher
+	wrappers  []WrapFunc
+	observers []Observer
+	// If non-nil, establishes the Principal making each request.
+	authenticator Authenticator
+	// Decides which DataRequests are handled.  It has no default, and must be
+	// set with Auth.
+	authorizer Authorizer
+	// If non-nil, enforces rate and concurrency limits.
+	limiter *limiter
+	// Request size and shape limits; the zero value enforces none.
+	requestLimits RequestLimits
+	// If true, responses are checked against the well-known data models
+	// before they are sent.
+	validate bool
 }
 
 // NewQueryHandler returns a new Handler serving TraceViz requests using the
-// provided QueryDispatcher.
+// provided QueryDispatcher.  Auth must be used to configure which requests
+// are allowed before HandlersByPath is invoked; to allow all requests, use
+// Auth(nil, AllowAll()).
 func NewQueryHandler(qd *querydispatcher.QueryDispatcher) QueryHandler {
 	return &queryHandler{
 		qd: qd,
@@ -68,7 +94,14 @@ func NewQueryHandler(qd *querydispatcher.QueryDispatcher) QueryHandler {
 }
 
 const (
-	dataMethod = "/GetData"
+	dataMethod    = "/GetData"
+	schemaMethod  = "/GetSchema"
+	csvMethod     = "/data.csv"
+	tsvMethod     = "/data.tsv"
+	svgMethod     = "/render.svg"
+	pngMethod     = "/render.png"
+	warmupMethod  = "/Warmup"
+	changesMethod = "/Changes"
 )
 
 type contextKey string
@@ -97,34 +130,233 @@ func (qh *queryHandler) Wrap(wrappers ...WrapFunc) Handler {
 	return qh
 }
 
+// Observe adds the provided Observers, which are notified in order when each
+// DataRequest starts, and in reverse order when it finishes.
+func (qh *queryHandler) Observe(observers ...Observer) QueryHandler {
+	qh.observers = append(qh.observers, observers...)
+	return qh
+}
+
+// Auth configures the receiver to authenticate each request with the provided
+// Authenticator, if it is non-nil, placing the authenticated Principal in the
+// request Context, then to handle only DataRequests allowed by the provided
+// Authorizer.
+func (qh *queryHandler) Auth(authenticator Authenticator, authorizer Authorizer) QueryHandler {
+	qh.authenticator = authenticator
+	qh.authorizer = authorizer
+	return qh
+}
+
+// Limit configures the receiver to enforce the provided Limits, responding to
+// DataRequests exceeding them with HTTP status 429 (Too Many Requests).
+func (qh *queryHandler) Limit(limits Limits) QueryHandler {
+	qh.limiter = newLimiter(limits)
+	return qh
+}
+
+// LimitRequests configures the receiver to enforce the provided
+// RequestLimits, responding to requests exceeding them with HTTP status 400
+// (Bad Request) and a JSON-encoded RequestError.
+func (qh *queryHandler) LimitRequests(limits RequestLimits) QueryHandler {
+	qh.requestLimits = limits
+	return qh
+}
+
+// Validate configures the receiver to check each response against the
+// well-known data models, as by validation.Validate, responding to DataRequests
+// yielding malformed responses with HTTP status 500 (Internal Server Error).
+// Validation walks every response, so is intended for debugging.
+func (qh *queryHandler) Validate() QueryHandler {
+	qh.validate = true
+	return qh
+}
+
 // HandlersByPath returns a mapping of HTTP request path to HTTP handler for
-// this Handler.
+// this Handler.  It panics if no Authorizer was configured with Auth, rather
+// than serving handlers that would refuse every request.
 func (qh *queryHandler) HandlersByPath() map[string]func(http.ResponseWriter, *http.Request) {
-	var dh HandlerFunc = qh.getDataHandler
+	if qh.authorizer == nil {
+		panic("handlers: QueryHandler has no Authorizer; configure one with Auth, e.g. Auth(nil, AllowAll()) to allow all requests")
+	}
+	var dh, sh HandlerFunc = qh.getDataHandler, qh.getSchemaHandler
+	var ch, th HandlerFunc = qh.exportHandler(',', "text/csv", "csv"), qh.exportHandler('\t', "text/tab-separated-values", "tsv")
+	var vh, ph HandlerFunc = qh.renderHandler(false), qh.renderHandler(true)
+	var wh, nh HandlerFunc = qh.warmupHandler, qh.changesHandler
 	for _, wrapper := range qh.wrappers {
-		dh = wrapper(dh)
+		dh, sh, ch, th, wh = wrapper(dh), wrapper(sh), wrapper(ch), wrapper(th), wrapper(wh)
+		vh, ph, nh = wrapper(vh), wrapper(ph), wrapper(nh)
 	}
 	return map[string]func(http.ResponseWriter, *http.Request){
-		dataMethod: dh,
+		dataMethod:    dh,
+		schemaMethod:  sh,
+		csvMethod:     ch,
+		tsvMethod:     th,
+		svgMethod:     vh,
+		pngMethod:     ph,
+		warmupMethod:  wh,
+		changesMethod: nh,
+	}
+}
+
+// getSchemaHandler responds with the JSON-encoded querydispatcher.Schema of
+// the receiver's QueryDispatcher, allowing clients to discover which queries,
+// and which versions of them, are supported.
+func (qh *queryHandler) getSchemaHandler(w http.ResponseWriter, req *http.Request) {
+	respStr, err := json.Marshal(qh.qd.Schema())
+	if err != nil {
+		http.Error(w, "Failed to marshal schema: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprint(w, string(respStr))
 }
 
 func (qh *queryHandler) getDataHandler(w http.ResponseWriter, req *http.Request) {
 	dataReq := &util.DataRequest{}
-	if err := req.ParseForm(); err != nil {
-		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+	if !qh.parseForm(w, req) {
+		return
 	}
 	if err := json.Unmarshal([]byte(req.Form.Get("req")), &dataReq); err != nil {
 		http.Error(w, "Failed to parse DataRequest: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	qh.handleDataRequest(w, req, dataReq, sendHTTPResponse)
+}
+
+// handleDataRequest checks, authenticates, limits, authorizes, and handles the
+// provided DataRequest, notifying the receiver's Observers, then passes the
+// response to the provided function for sending.  The DataRequest is
+// handled under the HTTP request's Context, which is canceled if the client
+// disconnects, so data sources checking it may abandon their work.
+func (qh *queryHandler) handleDataRequest(w http.ResponseWriter, req *http.Request, dataReq *util.DataRequest, send func(*util.Data, http.ResponseWriter)) {
 	ctx := req.Context()
-	resp, err := qh.qd.HandleDataRequest(context.WithValue(ctx, httpReqKey, req), dataReq)
+	observerCtxs := make([]context.Context, len(qh.observers))
+	for idx, observer := range qh.observers {
+		ctx = observer.RequestStarted(ctx, dataReq)
+		observerCtxs[idx] = ctx
+	}
+	info := &RequestInfo{
+		DataRequest: dataReq,
+		HTTPRequest: req,
+		Start:       time.Now(),
+		StatusCode:  http.StatusOK,
+	}
+	defer func() {
+		info.Duration = time.Since(info.Start)
+		for idx := len(qh.observers) - 1; idx >= 0; idx-- {
+			qh.observers[idx].RequestFinished(observerCtxs[idx], info)
+		}
+	}()
+	if re := qh.requestLimits.checkDataRequest(dataReq); re != nil {
+		info.Err, info.StatusCode = re, http.StatusBadRequest
+		badRequest(w, re)
+		return
+	}
+	ctx, principal, err := qh.authenticate(ctx, req)
+	if err != nil {
+		info.Err, info.StatusCode = err, http.StatusUnauthorized
+		http.Error(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	info.Principal = principal
+	release, ok := qh.limit(w, req, principal)
+	if !ok {
+		info.StatusCode = http.StatusTooManyRequests
+		return
+	}
+	defer release()
+	if err := qh.authorizer.Authorize(ctx, dataReq); err != nil {
+		info.Err, info.StatusCode = err, http.StatusForbidden
+		http.Error(w, "DataRequest not authorized: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	resp, err := qh.qd.HandleDataRequest(ctx, dataReq)
 	if err != nil {
+		info.Err, info.StatusCode = err, http.StatusInternalServerError
 		http.Error(w, "DataRequest failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	sendHTTPResponse(resp, w)
+	for _, series := range resp.DataSeries {
+		if series.Err != "" {
+			if info.SeriesErrs == nil {
+				info.SeriesErrs = map[string]string{}
+			}
+			info.SeriesErrs[series.SeriesName] = series.Err
+		}
+	}
+	if qh.validate {
+		if err := validation.Validate(resp); err != nil {
+			info.Err, info.StatusCode = err, http.StatusInternalServerError
+			http.Error(w, "DataRequest yielded a malformed response: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	send(resp, w)
+}
+
+// authenticate returns a copy of the provided Context carrying the provided
+// http Request and, if the receiver has an Authenticator, the Principal it
+// authenticates, which is also returned.
+func (qh *queryHandler) authenticate(ctx context.Context, req *http.Request) (context.Context, *util.Principal, error) {
+	ctx = context.WithValue(ctx, httpReqKey, req)
+	if qh.authenticator == nil {
+		return ctx, nil, nil
+	}
+	principal, err := qh.authenticator.Authenticate(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return util.WithPrincipal(ctx, principal), principal, nil
+}
+
+// warmupHandler starts, if necessary, a warmup of the receiver's
+// QueryDispatcher, and responds with the JSON-encoded
+// querydispatcher.WarmupStatus of that warmup.  The 'req' form value holds a
+// JSON-encoded DataRequest, as for GetData, whose global filters are the
+// warmup hints; its series requests are ignored.  Clients may poll this
+// endpoint with the same DataRequest to show a loading state until the
+// warmup is done.  Warmup requests are subject to the receiver's rate and
+// concurrency limits.
+func (qh *queryHandler) warmupHandler(w http.ResponseWriter, req *http.Request) {
+	dataReq := &util.DataRequest{}
+	if !qh.parseForm(w, req) {
+		return
+	}
+	if err := json.Unmarshal([]byte(req.Form.Get("req")), &dataReq); err != nil {
+		http.Error(w, "Failed to parse DataRequest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	dataReq.SeriesRequests = nil
+	if re := qh.requestLimits.checkDataRequest(dataReq); re != nil {
+		badRequest(w, re)
+		return
+	}
+	ctx, principal, err := qh.authenticate(req.Context(), req)
+	if err != nil {
+		http.Error(w, "Authentication failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	release, ok := qh.limit(w, req, principal)
+	if !ok {
+		return
+	}
+	defer release()
+	if err := qh.authorizer.Authorize(ctx, dataReq); err != nil {
+		http.Error(w, "Warmup not authorized: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := qh.qd.StartWarmup(ctx, dataReq.GlobalFilters)
+	if err != nil {
+		http.Error(w, "Warmup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respStr, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, "Failed to marshal warmup status: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprint(w, string(respStr))
 }
 
 // HTTPRequestFromContext returns the *http.Request stored in the provided context, or nil if no
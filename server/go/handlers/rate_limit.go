@@ -0,0 +1,183 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Limits configures the rate and concurrency limits of a QueryHandler.
+type Limits struct {
+	// If positive, the sustained rate of DataRequests, per second, allowed to
+	// each client.  Clients are identified by their authenticated Principal,
+	// or, if they have none, by their remote IP address.
+	RequestsPerSecond float64
+	// The number of DataRequests a client may make in a burst above
+	// RequestsPerSecond.  Treated as 1 if less than 1.
+	Burst int
+	// If positive, the maximum number of DataRequests handled concurrently
+	// across all clients.
+	MaxConcurrentRequests int
+}
+
+// The number of client token buckets above which full buckets, which are
+// equivalent to absent ones, are discarded.
+const maxIdleBuckets = 1024
+
+// tokenBucket is a token bucket rate limiter for a single client.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter enforces Limits.  It is safe for concurrent use.
+type limiter struct {
+	limits Limits
+	now    func() time.Time
+	// A semaphore bounding concurrent requests, or nil if unbounded.
+	sem chan struct{}
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newLimiter(limits Limits) *limiter {
+	if limits.Burst < 1 {
+		limits.Burst = 1
+	}
+	l := &limiter{
+		limits:  limits,
+		now:     time.Now,
+		buckets: map[string]*tokenBucket{},
+	}
+	if limits.MaxConcurrentRequests > 0 {
+		l.sem = make(chan struct{}, limits.MaxConcurrentRequests)
+	}
+	return l
+}
+
+// clientKey returns the key identifying the client making the provided
+// request, authenticated as the provided Principal if it is non-nil.
+func clientKey(req *http.Request, principal *util.Principal) string {
+	if principal != nil {
+		return "principal:" + principal.Name
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// allow consumes a token from the specified client's bucket, returning true
+// if one was available.  Otherwise, it returns false and the time until a
+// token will be available.
+func (l *limiter) allow(key string) (bool, time.Duration) {
+	if l.limits.RequestsPerSecond <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	burst := float64(l.limits.Burst)
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.discardFullBuckets(now)
+		}
+		b = &tokenBucket{
+			tokens: burst,
+			last:   now,
+		}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.limits.RequestsPerSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.limits.RequestsPerSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// discardFullBuckets discards all buckets that would be full at the provided
+// time.  l.mu must be held.
+func (l *limiter) discardFullBuckets(now time.Time) {
+	burst := float64(l.limits.Burst)
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limits.RequestsPerSecond >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// acquire reserves a concurrent request slot without blocking, returning
+// false if none is available.  Successful acquires must be followed by a
+// release.
+func (l *limiter) acquire() bool {
+	if l.sem == nil {
+		return true
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *limiter) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// limit applies the receiver's rate and concurrency limits, if any, to the
+// provided request, authenticated as the provided Principal if it is non-nil.
+// If the request is within the limits, limit returns true and a function
+// releasing its concurrent request slot, which must be invoked once the
+// request is handled.  Otherwise, it responds with HTTP 429 and returns
+// false.
+func (qh *queryHandler) limit(w http.ResponseWriter, req *http.Request, principal *util.Principal) (func(), bool) {
+	if qh.limiter == nil {
+		return func() {}, true
+	}
+	if ok, retryAfter := qh.limiter.allow(clientKey(req, principal)); !ok {
+		tooManyRequests(w, "Rate limit exceeded", retryAfter)
+		return nil, false
+	}
+	if !qh.limiter.acquire() {
+		tooManyRequests(w, "Too many concurrent requests", time.Second)
+		return nil, false
+	}
+	return qh.limiter.release, true
+}
+
+// tooManyRequests responds with HTTP 429, advising the client to retry after
+// the provided duration, rounded up to a whole number of seconds.
+func tooManyRequests(w http.ResponseWriter, msg string, retryAfter time.Duration) {
+	secs := int64(math.Ceil(retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(secs))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
@@ -0,0 +1,197 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+var limiterStartTime = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// testLimiter returns a new limiter enforcing the provided Limits, whose clock
+// is advanced by the returned function.
+func testLimiter(limits Limits) (*limiter, func(time.Duration)) {
+	l := newLimiter(limits)
+	now := limiterStartTime
+	l.now = func() time.Time {
+		return now
+	}
+	return l, func(d time.Duration) {
+		now = now.Add(d)
+	}
+}
+
+func TestLimiterAllow(t *testing.T) {
+	l, advance := testLimiter(Limits{
+		RequestsPerSecond: 2,
+		Burst:             2,
+	})
+	for _, test := range []struct {
+		description    string
+		advance        time.Duration
+		key            string
+		wantOK         bool
+		wantRetryAfter time.Duration
+	}{{
+		description: "burst",
+		key:         "a",
+		wantOK:      true,
+	}, {
+		description: "burst",
+		key:         "a",
+		wantOK:      true,
+	}, {
+		description:    "burst exhausted",
+		key:            "a",
+		wantRetryAfter: 500 * time.Millisecond,
+	}, {
+		description: "other client unaffected",
+		key:         "b",
+		wantOK:      true,
+	}, {
+		description:    "partially refilled",
+		advance:        250 * time.Millisecond,
+		key:            "a",
+		wantRetryAfter: 250 * time.Millisecond,
+	}, {
+		description: "refilled",
+		advance:     250 * time.Millisecond,
+		key:         "a",
+		wantOK:      true,
+	}} {
+		advance(test.advance)
+		ok, retryAfter := l.allow(test.key)
+		if ok != test.wantOK || retryAfter != test.wantRetryAfter {
+			t.Errorf("%s: allow('%s') = %t, %s, wanted %t, %s", test.description, test.key, ok, retryAfter, test.wantOK, test.wantRetryAfter)
+		}
+	}
+}
+
+func TestLimiterPrunesIdleBuckets(t *testing.T) {
+	l, advance := testLimiter(Limits{
+		RequestsPerSecond: 1,
+		Burst:             10,
+	})
+	// The first client exhausts its burst; the rest use a single token.
+	for idx := 0; idx < 10; idx++ {
+		l.allow("busy")
+	}
+	for idx := 1; idx < maxIdleBuckets; idx++ {
+		l.allow(fmt.Sprintf("idle%d", idx))
+	}
+	if got := len(l.buckets); got != maxIdleBuckets {
+		t.Fatalf("Got %d buckets, wanted %d", got, maxIdleBuckets)
+	}
+	// After 5s, the idle clients' buckets are full, but the busy client's is
+	// not, so a new client discards only the idle buckets.
+	advance(5 * time.Second)
+	if ok, _ := l.allow("new"); !ok {
+		t.Errorf("allow('new') was denied")
+	}
+	if got := len(l.buckets); got != 2 {
+		t.Errorf("Got %d buckets after pruning, wanted 2", got)
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Errorf("Busy client's bucket was discarded")
+	}
+	// The busy client's state survived pruning: of its 5 refilled tokens, 4
+	// remain after this request.
+	if ok, _ := l.allow("busy"); !ok || l.buckets["busy"].tokens != 4 {
+		t.Errorf("allow('busy') = %t with %f tokens remaining, wanted true with 4", ok, l.buckets["busy"].tokens)
+	}
+}
+
+func TestLimiterAcquire(t *testing.T) {
+	l := newLimiter(Limits{MaxConcurrentRequests: 2})
+	if !l.acquire() || !l.acquire() {
+		t.Fatalf("acquire() failed below the concurrency cap")
+	}
+	if l.acquire() {
+		t.Errorf("acquire() succeeded at the concurrency cap")
+	}
+	l.release()
+	if !l.acquire() {
+		t.Errorf("acquire() failed after release()")
+	}
+	unlimited := newLimiter(Limits{})
+	for idx := 0; idx < 100; idx++ {
+		if !unlimited.acquire() {
+			t.Fatalf("acquire() failed without a concurrency cap")
+		}
+	}
+}
+
+func TestQueryHandlerLimits(t *testing.T) {
+	form := encodeDataRequest(t, &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			"collection_name": util.StringValue("a"),
+		},
+		SeriesRequests: seriesRequests(1),
+	}).Encode()
+	for _, path := range []string{dataMethod, warmupMethod, changesMethod} {
+		t.Run(path, func(t *testing.T) {
+			qh := newTestQueryHandler(t).Limit(Limits{
+				RequestsPerSecond:     0.5,
+				MaxConcurrentRequests: 1,
+			}).(*queryHandler)
+			now := limiterStartTime
+			qh.limiter.now = func() time.Time {
+				return now
+			}
+			handler := qh.HandlersByPath()[path]
+			do := func(remoteAddr string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodGet, path+"?"+form, nil)
+				req.RemoteAddr = remoteAddr
+				rec := httptest.NewRecorder()
+				handler(rec, req)
+				return rec
+			}
+			if rec := do("10.0.0.1:1234"); rec.Code != http.StatusOK {
+				t.Fatalf("Got status %d, wanted %d (body %q)", rec.Code, http.StatusOK, rec.Body.String())
+			}
+			// The same client is rate limited, even from another port.
+			rec := do("10.0.0.1:5678")
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("Got status %d for rate-limited request, wanted %d", rec.Code, http.StatusTooManyRequests)
+			}
+			if got := rec.Header().Get("Retry-After"); got != "2" {
+				t.Errorf("Got Retry-After '%s' for rate-limited request, wanted '2'", got)
+			}
+			// Another client is limited only by concurrency.
+			qh.limiter.acquire()
+			rec = do("10.0.0.2:1234")
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("Got status %d at the concurrency cap, wanted %d", rec.Code, http.StatusTooManyRequests)
+			}
+			if got := rec.Header().Get("Retry-After"); got != "1" {
+				t.Errorf("Got Retry-After '%s' at the concurrency cap, wanted '1'", got)
+			}
+			qh.limiter.release()
+			now = now.Add(2 * time.Second)
+			if rec := do("10.0.0.2:1234"); rec.Code != http.StatusOK {
+				t.Errorf("Got status %d below the concurrency cap, wanted %d", rec.Code, http.StatusOK)
+			}
+			// Each request released its concurrent request slot.
+			if !qh.limiter.acquire() {
+				t.Errorf("Concurrent request slot was not released")
+			}
+		})
+	}
+}
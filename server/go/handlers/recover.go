@@ -0,0 +1,43 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// RecoverPanics is a WrapFunc recovering from any panic in the wrapped
+// HandlerFunc, logging it with its stack, and responding with an internal
+// server error.  Panics in dataSources are recovered by the QueryDispatcher;
+// this catches those elsewhere, e.g. in encoding responses.
+func RecoverPanics(hf HandlerFunc) HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+			pe := util.Recovered(r)
+			log.Printf("Recovered from panic handling %s: %v\n%s", req.URL.Path, pe.Value, pe.Stack)
+			http.Error(w, "Internal error: "+pe.Error(), http.StatusInternalServerError)
+		}()
+		hf(w, req)
+	}
+}
@@ -0,0 +1,121 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/google/traceviz/server/go/render"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+)
+
+const (
+	// The width, in pixels, of rendered images.
+	renderWidthParam = "render.width"
+	// For weighted trees, the node property labeling each frame.
+	renderLabelParam = "render.label"
+
+	defaultRenderWidthPx = 1000
+	maxRenderWidthPx     = 10000
+	// Rendered scenes taller than this are rejected rather than rendered,
+	// since a PNG's pixels are all allocated up front.
+	maxRenderHeightPx = 20000
+)
+
+// renderScene lays out the provided data series root, which may be a trace or
+// a weighted tree, as a render.Scene.
+func renderScene(root *util.Datum, st []string, widthPx float64, label string) (*render.Scene, error) {
+	if scene, err := trace.Render(root, st, widthPx); err == nil {
+		return scene, nil
+	}
+	if scene, err := weightedtree.Render(root, st, widthPx, label); err == nil {
+		return scene, nil
+	}
+	return nil, fmt.Errorf("query did not produce a trace or weighted tree")
+}
+
+// renderHandler returns a HandlerFunc running a single trace- or
+// weighted tree-producing query, specified as for exportHandler, and
+// responding with the result rendered as an SVG or PNG image.  The
+// 'render.width' form value sets the image width in pixels, and the
+// 'render.label' form value names the property labeling weighted tree frames.
+// Queries whose rendered scene would be more than maxRenderHeightPx tall, as
+// for traces with very many rows, are rejected.
+func (qh *queryHandler) renderHandler(png bool) HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !qh.parseForm(w, req) {
+			return
+		}
+		form := url.Values{}
+		for key, vals := range req.Form {
+			form[key] = vals
+		}
+		widthPx := defaultRenderWidthPx
+		if widthStr := form.Get(renderWidthParam); widthStr != "" {
+			var err error
+			widthPx, err = strconv.Atoi(widthStr)
+			if err != nil || widthPx <= 0 || widthPx > maxRenderWidthPx {
+				http.Error(w, fmt.Sprintf("Bad render request: '%s' must be between 1 and %d", renderWidthParam, maxRenderWidthPx), http.StatusBadRequest)
+				return
+			}
+		}
+		label := form.Get(renderLabelParam)
+		form.Del(renderWidthParam)
+		form.Del(renderLabelParam)
+		dataReq, err := exportRequest(form)
+		if err != nil {
+			http.Error(w, "Bad render request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		qh.handleDataRequest(w, req, dataReq, func(resp *util.Data, w http.ResponseWriter) {
+			if len(resp.DataSeries) != 1 {
+				http.Error(w, "Render query produced no data series", http.StatusInternalServerError)
+				return
+			}
+			if resp.DataSeries[0].Root == nil {
+				http.Error(w, "Render query failed: "+resp.DataSeries[0].Err, http.StatusInternalServerError)
+				return
+			}
+			scene, err := renderScene(resp.DataSeries[0].Root, resp.StringTable, float64(widthPx), label)
+			if err != nil {
+				http.Error(w, "Failed to render: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if scene.Height > maxRenderHeightPx {
+				http.Error(w, fmt.Sprintf("Bad render request: the rendered image would be %.0f pixels tall, more than %d; filter the query to fewer rows", scene.Height, maxRenderHeightPx), http.StatusBadRequest)
+				return
+			}
+			buf := &bytes.Buffer{}
+			contentType := "image/svg+xml"
+			if png {
+				contentType = "image/png"
+				err = scene.WritePNG(buf)
+			} else {
+				err = scene.WriteSVG(buf)
+			}
+			if err != nil {
+				http.Error(w, "Failed to render: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Write(buf.Bytes())
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// traceDataSource responds to 'test.trace' with a trace having one category,
+// holding one span, per the integer 'rows' option.
+type traceDataSource struct{}
+
+func (tds *traceDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"test.trace"}
+}
+
+func (tds *traceDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		rows, err := util.ExpectIntegerValue(req.Options["rows"])
+		if err != nil {
+			return err
+		}
+		tr := trace.New(drb.DataSeries(req),
+			continuousaxis.NewDurationAxis(category.New("x_axis", "Time", "Time"), 0, time.Second),
+			&trace.RenderSettings{
+				SpanWidthCatPx:   10,
+				SpanPaddingCatPx: 2,
+				CategoryAxisRenderSettings: &categoryaxis.RenderSettings{
+					CategoryHeaderCatPx:  20,
+					CategoryMarginValPx:  5,
+					CategoryPaddingCatPx: 4,
+				},
+			})
+		for row := int64(0); row < rows; row++ {
+			id := fmt.Sprintf("row%d", row)
+			tr.Category(category.New(id, id, id)).Span(0, time.Second)
+		}
+	}
+	return nil
+}
+
+func TestRenderHeightLimit(t *testing.T) {
+	qd, err := querydispatcher.New(&traceDataSource{})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	handlers := NewQueryHandler(qd).Auth(nil, AllowAll()).HandlersByPath()
+	for _, test := range []struct {
+		description string
+		path        string
+		rows        int64
+		wantStatus  int
+	}{{
+		description: "short PNG",
+		path:        pngMethod,
+		rows:        10,
+		wantStatus:  http.StatusOK,
+	}, {
+		description: "tall PNG",
+		path:        pngMethod,
+		rows:        5000,
+		wantStatus:  http.StatusBadRequest,
+	}, {
+		description: "tall SVG",
+		path:        svgMethod,
+		rows:        5000,
+		wantStatus:  http.StatusBadRequest,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			dataReq := &util.DataRequest{
+				SeriesRequests: []*util.DataSeriesRequest{{
+					QueryName:  "test.trace",
+					SeriesName: "trace",
+					Options: map[string]*util.V{
+						"rows": util.IntegerValue(test.rows),
+					},
+				}},
+			}
+			form := encodeDataRequest(t, dataReq)
+			rec := httptest.NewRecorder()
+			handlers[test.path](rec, httptest.NewRequest(http.MethodGet, test.path+"?"+form.Encode(), nil))
+			if rec.Code != test.wantStatus {
+				t.Errorf("Got status %d, want %d (body %q)", rec.Code, test.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
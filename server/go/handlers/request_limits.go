@@ -0,0 +1,151 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// RequestLimits configures the request size and shape limits of a
+// QueryHandler.  Each limit is enforced only if it is positive.
+type RequestLimits struct {
+	// The maximum size, in bytes, of a request body, and of the JSON-encoded
+	// DataRequest in a request's 'req' form value.
+	MaxRequestBytes int64
+	// The maximum number of DataSeriesRequests in a DataRequest.
+	MaxSeriesRequests int
+	// The maximum number of global filters in a DataRequest, and of options in
+	// each of its DataSeriesRequests.
+	MaxOptions int
+}
+
+// Names of the limits reported in RequestErrors.
+const (
+	maxRequestBytesLimit   = "max_request_bytes"
+	maxSeriesRequestsLimit = "max_series_requests"
+	maxGlobalFiltersLimit  = "max_global_filters"
+	maxOptionsLimit        = "max_options"
+)
+
+// RequestError is the JSON-encoded body of a QueryHandler's HTTP status 400
+// (Bad Request) response to a request exceeding its RequestLimits.
+type RequestError struct {
+	// A human-readable description of the error.
+	Message string `json:"message"`
+	// The name of the exceeded limit: one of 'max_request_bytes',
+	// 'max_series_requests', 'max_global_filters', or 'max_options'.
+	Limit string `json:"limit"`
+	// The value of the exceeded limit.
+	Max int64 `json:"max"`
+	// If the exceeded limit is 'max_options', the name of the
+	// DataSeriesRequest exceeding it.
+	SeriesName string `json:"series_name,omitempty"`
+}
+
+func (re *RequestError) Error() string {
+	return re.Message
+}
+
+// limitBody limits the provided request's body to the receiver's
+// MaxRequestBytes.  It should be invoked before the request's form is parsed.
+func (rl RequestLimits) limitBody(w http.ResponseWriter, req *http.Request) {
+	if rl.MaxRequestBytes > 0 && req.Body != nil {
+		req.Body = http.MaxBytesReader(w, req.Body, rl.MaxRequestBytes)
+	}
+}
+
+// checkForm returns a RequestError if the provided form parsing error was due
+// to the request body exceeding the receiver's MaxRequestBytes, or if the
+// parsed 'req' form value exceeds it.
+func (rl RequestLimits) checkForm(req *http.Request, parseErr error) *RequestError {
+	if rl.MaxRequestBytes <= 0 {
+		return nil
+	}
+	var mbe *http.MaxBytesError
+	if errors.As(parseErr, &mbe) || int64(len(req.Form.Get("req"))) > rl.MaxRequestBytes {
+		return &RequestError{
+			Message: fmt.Sprintf("request exceeds %d bytes", rl.MaxRequestBytes),
+			Limit:   maxRequestBytesLimit,
+			Max:     rl.MaxRequestBytes,
+		}
+	}
+	return nil
+}
+
+// checkDataRequest returns a RequestError if the provided DataRequest exceeds
+// any of the receiver's limits.
+func (rl RequestLimits) checkDataRequest(dataReq *util.DataRequest) *RequestError {
+	if rl.MaxSeriesRequests > 0 && len(dataReq.SeriesRequests) > rl.MaxSeriesRequests {
+		return &RequestError{
+			Message: fmt.Sprintf("DataRequest has %d series requests, more than the maximum of %d", len(dataReq.SeriesRequests), rl.MaxSeriesRequests),
+			Limit:   maxSeriesRequestsLimit,
+			Max:     int64(rl.MaxSeriesRequests),
+		}
+	}
+	if rl.MaxOptions <= 0 {
+		return nil
+	}
+	if len(dataReq.GlobalFilters) > rl.MaxOptions {
+		return &RequestError{
+			Message: fmt.Sprintf("DataRequest has %d global filters, more than the maximum of %d", len(dataReq.GlobalFilters), rl.MaxOptions),
+			Limit:   maxGlobalFiltersLimit,
+			Max:     int64(rl.MaxOptions),
+		}
+	}
+	for _, seriesReq := range dataReq.SeriesRequests {
+		if seriesReq != nil && len(seriesReq.Options) > rl.MaxOptions {
+			return &RequestError{
+				Message:    fmt.Sprintf("series request '%s' has %d options, more than the maximum of %d", seriesReq.SeriesName, len(seriesReq.Options), rl.MaxOptions),
+				Limit:      maxOptionsLimit,
+				Max:        int64(rl.MaxOptions),
+				SeriesName: seriesReq.SeriesName,
+			}
+		}
+	}
+	return nil
+}
+
+// badRequest responds with HTTP 400 and the provided JSON-encoded
+// RequestError.
+func badRequest(w http.ResponseWriter, re *RequestError) {
+	respStr, err := json.Marshal(re)
+	if err != nil {
+		http.Error(w, re.Message, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprint(w, string(respStr))
+}
+
+// parseForm parses the provided request's form, enforcing the receiver's
+// MaxRequestBytes.  On failure, it responds with HTTP 400 and returns false.
+func (qh *queryHandler) parseForm(w http.ResponseWriter, req *http.Request) bool {
+	qh.requestLimits.limitBody(w, req)
+	err := req.ParseForm()
+	if re := qh.requestLimits.checkForm(req, err); re != nil {
+		badRequest(w, re)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
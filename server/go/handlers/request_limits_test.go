@@ -0,0 +1,233 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// testDataSource responds to each request with an empty series.
+type testDataSource struct {
+	queries []string
+}
+
+func (tds *testDataSource) SupportedDataSeriesQueries() []string {
+	return tds.queries
+}
+
+func (tds *testDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		drb.DataSeries(req)
+	}
+	return nil
+}
+
+// newTestQueryHandler returns a QueryHandler allowing all requests, backed by
+// a testDataSource supporting the query 'test.query'.
+func newTestQueryHandler(t *testing.T) QueryHandler {
+	t.Helper()
+	qd, err := querydispatcher.New(&testDataSource{queries: []string{"test.query"}})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	return NewQueryHandler(qd).Auth(nil, AllowAll())
+}
+
+// encodeDataRequest returns the provided DataRequest encoded as the 'req'
+// form value.
+func encodeDataRequest(t *testing.T, dataReq *util.DataRequest) url.Values {
+	t.Helper()
+	reqStr, err := json.Marshal(dataReq)
+	if err != nil {
+		t.Fatalf("Failed to marshal DataRequest: %s", err)
+	}
+	return url.Values{"req": []string{string(reqStr)}}
+}
+
+// seriesRequests returns count DataSeriesRequests for 'test.query'.
+func seriesRequests(count int) []*util.DataSeriesRequest {
+	ret := make([]*util.DataSeriesRequest, count)
+	for idx := range ret {
+		ret[idx] = &util.DataSeriesRequest{
+			QueryName:  "test.query",
+			SeriesName: string(rune('a' + idx)),
+		}
+	}
+	return ret
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := RequestLimits{
+		MaxRequestBytes:   1024,
+		MaxSeriesRequests: 2,
+		MaxOptions:        2,
+	}
+	for _, test := range []struct {
+		description string
+		path        string
+		post        bool
+		form        func(t *testing.T) url.Values
+		wantStatus  int
+		wantErr     *RequestError
+	}{{
+		description: "within limits",
+		path:        dataMethod,
+		form: func(t *testing.T) url.Values {
+			return encodeDataRequest(t, &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					"collection_name": util.StringValue("a"),
+				},
+				SeriesRequests: seriesRequests(2),
+			})
+		},
+		wantStatus: http.StatusOK,
+	}, {
+		description: "oversized body",
+		path:        dataMethod,
+		post:        true,
+		form: func(t *testing.T) url.Values {
+			return url.Values{"req": []string{strings.Repeat("x", 2048)}}
+		},
+		wantStatus: http.StatusBadRequest,
+		wantErr: &RequestError{
+			Message: "request exceeds 1024 bytes",
+			Limit:   maxRequestBytesLimit,
+			Max:     1024,
+		},
+	}, {
+		description: "oversized query string",
+		path:        dataMethod,
+		form: func(t *testing.T) url.Values {
+			return url.Values{"req": []string{strings.Repeat("x", 2048)}}
+		},
+		wantStatus: http.StatusBadRequest,
+		wantErr: &RequestError{
+			Message: "request exceeds 1024 bytes",
+			Limit:   maxRequestBytesLimit,
+			Max:     1024,
+		},
+	}, {
+		description: "too many series",
+		path:        dataMethod,
+		post:        true,
+		form: func(t *testing.T) url.Values {
+			return encodeDataRequest(t, &util.DataRequest{
+				SeriesRequests: seriesRequests(3),
+			})
+		},
+		wantStatus: http.StatusBadRequest,
+		wantErr: &RequestError{
+			Message: "DataRequest has 3 series requests, more than the maximum of 2",
+			Limit:   maxSeriesRequestsLimit,
+			Max:     2,
+		},
+	}, {
+		description: "too many global filters",
+		path:        dataMethod,
+		form: func(t *testing.T) url.Values {
+			return encodeDataRequest(t, &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					"a": util.StringValue("a"),
+					"b": util.StringValue("b"),
+					"c": util.StringValue("c"),
+				},
+			})
+		},
+		wantStatus: http.StatusBadRequest,
+		wantErr: &RequestError{
+			Message: "DataRequest has 3 global filters, more than the maximum of 2",
+			Limit:   maxGlobalFiltersLimit,
+			Max:     2,
+		},
+	}, {
+		description: "too many options",
+		path:        dataMethod,
+		form: func(t *testing.T) url.Values {
+			seriesReqs := seriesRequests(1)
+			seriesReqs[0].Options = map[string]*util.V{
+				"a": util.StringValue("a"),
+				"b": util.StringValue("b"),
+				"c": util.StringValue("c"),
+			}
+			return encodeDataRequest(t, &util.DataRequest{
+				SeriesRequests: seriesReqs,
+			})
+		},
+		wantStatus: http.StatusBadRequest,
+		wantErr: &RequestError{
+			Message:    "series request 'a' has 3 options, more than the maximum of 2",
+			Limit:      maxOptionsLimit,
+			Max:        2,
+			SeriesName: "a",
+		},
+	}, {
+		description: "warmup with too many global filters",
+		path:        warmupMethod,
+		form: func(t *testing.T) url.Values {
+			return encodeDataRequest(t, &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					"a": util.StringValue("a"),
+					"b": util.StringValue("b"),
+					"c": util.StringValue("c"),
+				},
+			})
+		},
+		wantStatus: http.StatusBadRequest,
+		wantErr: &RequestError{
+			Message: "DataRequest has 3 global filters, more than the maximum of 2",
+			Limit:   maxGlobalFiltersLimit,
+			Max:     2,
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			handler := newTestQueryHandler(t).LimitRequests(limits).HandlersByPath()[test.path]
+			form := test.form(t)
+			var req *http.Request
+			if test.post {
+				req = httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(http.MethodGet, test.path+"?"+form.Encode(), nil)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code != test.wantStatus {
+				t.Fatalf("Got status %d, wanted %d (body %q)", rec.Code, test.wantStatus, rec.Body.String())
+			}
+			if test.wantErr == nil {
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Got Content-Type '%s', wanted 'application/json'", got)
+			}
+			gotErr := &RequestError{}
+			if err := json.Unmarshal(rec.Body.Bytes(), gotErr); err != nil {
+				t.Fatalf("Failed to unmarshal RequestError: %s", err)
+			}
+			if diff := cmp.Diff(test.wantErr, gotErr); diff != "" {
+				t.Errorf("RequestError diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+)
+
+// ResourceStatsHandler returns a HandlerFunc serving the JSON-encoded
+// querydispatcher.ResourceReport of the provided QueryDispatcher, which must
+// perform resource accounting, so that operators may identify expensive
+// queries and collections.
+func ResourceStatsHandler(qd *querydispatcher.QueryDispatcher) HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		report := qd.ResourceReport()
+		if report == nil {
+			http.Error(w, "Resource accounting is not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			http.Error(w, "Failed to marshal resource report: "+err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package keys is the registry of well-known Datum property keys.  It exports
+// the keys used by TraceViz's core packages, and allows data sources to
+// register the keys they use too, so that two packages using the same key for
+// different purposes are detected at init time, rather than by a confused
+// frontend.  Keys in use may be listed with Registered, or served by a
+// QueryDispatcher as its built-in keys query.
+package keys
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Property keys used by core packages.
+const (
+	// bar_chart
+	BarChartDataType              = "bar_chart_data_type"
+	BarChartStackedBars           = "bar_chart_stacked_bars"
+	BarChartBar                   = "bar_chart_bar"
+	BarChartBoxPlot               = "bar_chart_box_plot"
+	BarChartBarLowerExtent        = "bar_chart_bar_lower_extent"
+	BarChartBarUpperExtent        = "bar_chart_bar_upper_extent"
+	BarChartBoxPlotMin            = "bar_chart_box_plot_min"
+	BarChartBoxPlotQ1             = "bar_chart_box_plot_q1"
+	BarChartBoxPlotQ2             = "bar_chart_box_plot_q2"
+	BarChartBoxPlotQ3             = "bar_chart_box_plot_q3"
+	BarChartBoxPlotMax            = "bar_chart_box_plot_max"
+	BarChartBarWidthCatPx         = "bar_chart_bar_width_cat_px"
+	BarChartBarPaddingCatPx       = "bar_chart_bar_padding_cat_px"
+	BarChartCategoryMinWidthCatPx = "bar_chart_category_min_width_cat_px"
+	BarChartCategoryPaddingCatPx  = "bar_chart_category_padding_cat_px"
+	BarChartCategoryWidthValPx    = "bar_chart_category_width_val_px"
+	DetailFormat                  = "detail_format"
+
+	// category
+	CategoryDefinedID   = "category_defined_id"
+	CategoryDescription = "category_description"
+	CategoryDisplayName = "category_display_name"
+	CategoryIDs         = "category_ids"
+
+	// category_axis
+	CategoryHeaderCatPx    = "category_header_cat_px"
+	CategoryHandleValPx    = "category_handle_val_px"
+	CategoryPaddingCatPx   = "category_padding_cat_px"
+	CategoryMarginValPx    = "category_margin_val_px"
+	CategoryMinWidthCatPx  = "category_min_width_cat_px"
+	CategoryBaseWidthValPx = "category_base_width_val_px"
+
+	// color
+	PrimaryColorSpace        = "primary_color_space"
+	PrimaryColorSpaceValue   = "primary_color_space_value"
+	PrimaryColor             = "primary_color"
+	SecondaryColorSpace      = "secondary_color_space"
+	SecondaryColorSpaceValue = "secondary_color_space_value"
+	SecondaryColor           = "secondary_color"
+	StrokeColorSpace         = "stroke_color_space"
+	StrokeColorSpaceValue    = "stroke_color_space_value"
+	StrokeColor              = "stroke_color"
+
+	// continuous_axis
+	AxisType                   = "axis_type"
+	AxisMin                    = "axis_min"
+	AxisMax                    = "axis_max"
+	AxisTickCount              = "axis_tick_count"
+	AxisTicks                  = "axis_ticks"
+	AxisTickLabels             = "axis_tick_labels"
+	AxisUnit                   = "axis_unit"
+	AxisLogScale               = "axis_log_scale"
+	AxisAnchor                 = "axis_anchor"
+	XAxisRenderLabelHeightPx   = "x_axis_render_label_height_px"
+	XAxisRenderMarkersHeightPx = "x_axis_render_markers_height_px"
+	YAxisRenderLabelWidthPx    = "y_axis_render_label_width_px"
+	YAxisRenderMarkersWidthPx  = "y_axis_render_markers_width_px"
+
+	// decorators
+	Decorators = "decorators"
+
+	// dot
+	DotStrictness          = "dot_strictness"
+	DotDirectionality      = "dot_directionality"
+	DotLayoutEngine        = "dot_layout_engine"
+	DotStatementType       = "dot_statement_type"
+	DotAttrStatementTarget = "dot_attr_statement_target"
+	DotAttributes          = "dot_attributes"
+	DotSubgraphID          = "dot_subgraph_id"
+	DotNodeID              = "dot_node_id"
+	DotEdgeID              = "dot_edge_id"
+	DotStartNodeID         = "dot_start_node_id"
+	DotEndNodeID           = "dot_end_node_id"
+
+	// label
+	LabelFormat = "label_format"
+
+	// magnitude
+	SelfMagnitude = "self_magnitude"
+
+	// payload
+	PayloadType = "payload_type"
+
+	// table
+	TableCell          = "table_cell"
+	TableFormattedCell = "table_formatted_cell"
+	TableNodeType      = "table_node_type"
+	TableRowCollapsed  = "table_row_collapsed"
+	TableMatchRanges   = "table_match_ranges"
+	TableColumn        = "table_column"
+	TableRowHeightPx   = "table_row_height_px"
+	TableFontSizePx    = "table_font_size_px"
+
+	// trace
+	TraceStart              = "trace_start"
+	TraceEnd                = "trace_end"
+	TraceNodeType           = "trace_node_type"
+	SpanWidthCatPx          = "span_width_cat_px"
+	SpanPaddingCatPx        = "span_padding_cat_px"
+	SpanRow                 = "span_row"
+	SpanDepth               = "span_depth"
+	CategoryRows            = "category_rows"
+	CategoryHeightCatPx     = "category_height_cat_px"
+	AggregateSpanCount      = "aggregate_span_count"
+	AggregateSpanTotal      = "aggregate_span_total"
+	AggregateSpanLabel      = "aggregate_span_label"
+	OverviewBinCount        = "overview_bin_count"
+	OverviewBusyPermille    = "overview_busy_permille"
+	LogLineTimestamp        = "log_line_timestamp"
+	LogLineText             = "log_line_text"
+	StackFrameFunction      = "stack_frame_function"
+	StackFrameFile          = "stack_frame_file"
+	StackFrameLine          = "stack_frame_line"
+	LinkURL                 = "link_url"
+	LinkLabel               = "link_label"
+	TraceSearchMatchCount   = "trace_search_match_count"
+	TraceSearchCategoryPath = "trace_search_category_path"
+	TraceSearchSpanOffsets  = "trace_search_span_offsets"
+	SpanClippedStart        = "span_clipped_start"
+	SpanClippedEnd          = "span_clipped_end"
+
+	// trace_edge
+	TraceEdgeNodeID          = "trace_edge_node_id"
+	TraceEdgeStart           = "trace_edge_start"
+	TraceEdgeEndpointNodeIDs = "trace_edge_endpoint_node_ids"
+
+	// weighted_tree
+	WeightedTreeTotalMagnitude = "weighted_tree_total_magnitude"
+	WeightedTreeSampleCount    = "weighted_tree_sample_count"
+	WeightedTreePercentOfRoot  = "weighted_tree_percent_of_root"
+	WeightedTreeOrigins        = "weighted_tree_origins"
+	WeightedTreePrunedNodes    = "weighted_tree_pruned_nodes"
+	WeightedTreeFrameHeightPx  = "weighted_tree_frame_height_px"
+	WeightedTreeDirection      = "weighted_tree_direction"
+	WeightedTreeNextPageToken  = "weighted_tree_next_page_token"
+
+	// xy_chart
+	XYChartGapStart = "xy_chart_gap_start"
+	XYChartGapEnd   = "xy_chart_gap_end"
+	XYChartPointsX  = "xy_chart_points_x"
+	XYChartPointsY  = "xy_chart_points_y"
+
+	// util
+	ChildName = "child_name"
+	DatumID   = "datum_id"
+)
+
+// coreKeys are the keys used by core packages, by owning package.
+var coreKeys = []struct {
+	owner string
+	keys  []string
+}{
+	{"barchart", []string{
+		BarChartDataType, BarChartStackedBars, BarChartBar, BarChartBoxPlot, BarChartBarLowerExtent, BarChartBarUpperExtent, BarChartBoxPlotMin, BarChartBoxPlotQ1, BarChartBoxPlotQ2, BarChartBoxPlotQ3, BarChartBoxPlotMax, BarChartBarWidthCatPx, BarChartBarPaddingCatPx, BarChartCategoryMinWidthCatPx, BarChartCategoryPaddingCatPx, BarChartCategoryWidthValPx, DetailFormat,
+	}},
+	{"category", []string{
+		CategoryDefinedID, CategoryDescription, CategoryDisplayName, CategoryIDs,
+	}},
+	{"categoryaxis", []string{
+		CategoryHeaderCatPx, CategoryHandleValPx, CategoryPaddingCatPx, CategoryMarginValPx, CategoryMinWidthCatPx, CategoryBaseWidthValPx,
+	}},
+	{"color", []string{
+		PrimaryColorSpace, PrimaryColorSpaceValue, PrimaryColor, SecondaryColorSpace, SecondaryColorSpaceValue, SecondaryColor, StrokeColorSpace, StrokeColorSpaceValue, StrokeColor,
+	}},
+	{"continuousaxis", []string{
+		AxisType, AxisMin, AxisMax, AxisTickCount, AxisTicks, AxisTickLabels, AxisUnit, AxisLogScale, AxisAnchor, XAxisRenderLabelHeightPx, XAxisRenderMarkersHeightPx, YAxisRenderLabelWidthPx, YAxisRenderMarkersWidthPx,
+	}},
+	{"decorators", []string{
+		Decorators,
+	}},
+	{"dot", []string{
+		DotStrictness, DotDirectionality, DotLayoutEngine, DotStatementType, DotAttrStatementTarget, DotAttributes, DotSubgraphID, DotNodeID, DotEdgeID, DotStartNodeID, DotEndNodeID,
+	}},
+	{"label", []string{
+		LabelFormat,
+	}},
+	{"magnitude", []string{
+		SelfMagnitude,
+	}},
+	{"payload", []string{
+		PayloadType,
+	}},
+	{"table", []string{
+		TableCell, TableFormattedCell, TableNodeType, TableRowCollapsed, TableMatchRanges, TableColumn, TableRowHeightPx, TableFontSizePx,
+	}},
+	{"trace", []string{
+		TraceStart, TraceEnd, TraceNodeType, SpanWidthCatPx, SpanPaddingCatPx, SpanRow, SpanDepth, CategoryRows, CategoryHeightCatPx, AggregateSpanCount, AggregateSpanTotal, AggregateSpanLabel, OverviewBinCount, OverviewBusyPermille, LogLineTimestamp, LogLineText, StackFrameFunction, StackFrameFile, StackFrameLine, LinkURL, LinkLabel, TraceSearchMatchCount, TraceSearchCategoryPath, TraceSearchSpanOffsets, SpanClippedStart, SpanClippedEnd,
+	}},
+	{"traceedge", []string{
+		TraceEdgeNodeID, TraceEdgeStart, TraceEdgeEndpointNodeIDs,
+	}},
+	{"weightedtree", []string{
+		WeightedTreeTotalMagnitude, WeightedTreeSampleCount, WeightedTreePercentOfRoot, WeightedTreeOrigins, WeightedTreePrunedNodes, WeightedTreeFrameHeightPx, WeightedTreeDirection, WeightedTreeNextPageToken,
+	}},
+	{"xychart", []string{
+		XYChartGapStart, XYChartGapEnd, XYChartPointsX, XYChartPointsY,
+	}},
+	{"util", []string{
+		ChildName, DatumID,
+	}},
+}
+
+func init() {
+	for _, ck := range coreKeys {
+		MustRegister(ck.owner, ck.keys...)
+	}
+}
+
+// Registration records the registration of a property key.
+type Registration struct {
+	Key string
+	// The registering package or data source.
+	Owner string
+}
+
+var (
+	mu     sync.Mutex
+	owners = map[string]string{}
+)
+
+// Register registers the provided property keys as used by the provided
+// owner, typically the name of a package or data source.  Returns an error,
+// and registers none of the keys, if any is already registered, even by the
+// same owner.
+func Register(owner string, keys ...string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if existing, ok := owners[key]; ok {
+			return fmt.Errorf("property key '%s' registered by %s is already registered by %s", key, owner, existing)
+		}
+		if seen[key] {
+			return fmt.Errorf("property key '%s' registered twice by %s", key, owner)
+		}
+		seen[key] = true
+	}
+	for _, key := range keys {
+		owners[key] = owner
+	}
+	return nil
+}
+
+// MustRegister is like Register, but panics on error.  It is intended to be
+// called from init functions, so that key collisions are detected at
+// startup.
+func MustRegister(owner string, keys ...string) {
+	if err := Register(owner, keys...); err != nil {
+		panic(err)
+	}
+}
+
+// Owner returns the owner of the provided property key, and true, or false if
+// the key is not registered.
+func Owner(key string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	owner, ok := owners[key]
+	return owner, ok
+}
+
+// Registered returns all registered property keys, ordered by key.
+func Registered() []Registration {
+	mu.Lock()
+	defer mu.Unlock()
+	ret := make([]Registration, 0, len(owners))
+	for key, owner := range owners {
+		ret = append(ret, Registration{
+			Key:   key,
+			Owner: owner,
+		})
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].Key < ret[b].Key
+	})
+	return ret
+}
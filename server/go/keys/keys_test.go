@@ -0,0 +1,68 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package keys
+
+import (
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	if owner, ok := Owner(TraceStart); !ok || owner != "trace" {
+		t.Errorf("Owner(%q) = %q, %t, want 'trace', true", TraceStart, owner, ok)
+	}
+	for _, test := range []struct {
+		description string
+		owner       string
+		keys        []string
+		wantErr     bool
+	}{{
+		description: "new keys",
+		owner:       "test_source",
+		keys:        []string{"test_source_a", "test_source_b"},
+	}, {
+		description: "core key",
+		owner:       "test_source",
+		keys:        []string{"test_source_c", SelfMagnitude},
+		wantErr:     true,
+	}, {
+		description: "already registered by the same owner",
+		owner:       "test_source",
+		keys:        []string{"test_source_a"},
+		wantErr:     true,
+	}, {
+		description: "repeated key",
+		owner:       "test_source",
+		keys:        []string{"test_source_d", "test_source_d"},
+		wantErr:     true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			err := Register(test.owner, test.keys...)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Register(%q, %v) yielded error %v, wanted error %t", test.owner, test.keys, err, test.wantErr)
+			}
+		})
+	}
+	// Failed registrations register nothing.
+	for _, key := range []string{"test_source_c", "test_source_d"} {
+		if _, ok := Owner(key); ok {
+			t.Errorf("Owner(%q) found an owner after a failed registration", key)
+		}
+	}
+	regs := Registered()
+	for idx := 1; idx < len(regs); idx++ {
+		if regs[idx-1].Key >= regs[idx].Key {
+			t.Errorf("Registered() not ordered by key: %q before %q", regs[idx-1].Key, regs[idx].Key)
+		}
+	}
+}
@@ -14,11 +14,14 @@
 // Package label supports labeling renderable items.
 package label
 
-import "github.com/google/traceviz/server/go/util"
+import (
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/util"
+)
 
 const (
 	// labelFormatKey specifies the label format string used to label nodes.
-	labelFormatKey = "label_format"
+	labelFormatKey = keys.LabelFormat
 )
 
 // Format returns a PropertyUpdate that labels with the provided label format.
@@ -14,14 +14,24 @@
 // Package magnitude supports attaching magnitudes to items.
 package magnitude
 
-import "github.com/google/traceviz/server/go/util"
+import (
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/util"
+)
 
 const (
-	selfMagnitudeKey = "self_magnitude"
+	selfMagnitudeKey = keys.SelfMagnitude
 )
 
 // SelfMagnitude returns a PropertyUpdate that annotates with the provided
 // self-magnitude.
 func SelfMagnitude(selfMagnitude float64) util.PropertyUpdate {
 	return util.DoubleProperty(selfMagnitudeKey, selfMagnitude)
-}
\ No newline at end of file
+}
+
+// SelfMagnitudeOf returns the self-magnitude of the provided Datum, whose
+// string indices refer to the provided string table, and true, or false if it
+// has none.
+func SelfMagnitudeOf(d *util.Datum, st []string) (float64, bool) {
+	return d.PropertyNumber(st, selfMagnitudeKey)
+}
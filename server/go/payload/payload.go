@@ -29,14 +29,17 @@
 // should implement the Payloader interface.
 package payload
 
-import "github.com/google/traceviz/server/go/util"
+import (
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/util"
+)
 
 const (
 	// TypeKey, if present in a Datum's properties, indicates that that datum is
 	// an embedded payload.  properties[TypeKey] should be a string value
 	// indicating the type of the payload; each embeddable structured type
 	// should export a unique payload type string.
-	TypeKey = "payload_type"
+	TypeKey = keys.PayloadType
 )
 
 // Payloader is implemented by types able to accept payloads.
@@ -53,3 +56,10 @@ func New(parent Payloader, payloadType string) util.DataBuilder {
 		util.StringProperty(TypeKey, payloadType),
 	)
 }
+
+// TypeOf returns the payload type of the provided Datum, whose string indices
+// refer to the provided string table, and true, or false if the Datum is not
+// a payload.
+func TypeOf(d *util.Datum, st []string) (string, bool) {
+	return d.PropertyString(st, TypeKey)
+}
@@ -0,0 +1,203 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package perfscript
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	// The global filter specifying the name of the Profile to query.
+	collectionNameKey = "collection_name"
+
+	// Queries
+	stacksQuery = "perf.stacks"
+	schedQuery  = "perf.sched"
+
+	// Options
+	eventKey = "event"
+
+	// Properties
+	frameKey = "frame"
+	dsoKey   = "dso"
+)
+
+var (
+	globalSchema = []*util.OptionSpec{{
+		Key:      collectionNameKey,
+		Source:   util.FromGlobal,
+		Required: true,
+		Type:     util.StringValueType,
+	}}
+	stacksSchema = []*util.OptionSpec{{
+		Key:    eventKey,
+		Source: util.FromSeries,
+		Type:   util.StringValueType,
+	}}
+)
+
+var treeRenderSettings = &weightedtree.RenderSettings{
+	FrameHeightPx: 20,
+}
+
+// DataSource is a QueryDispatcher data source serving Profiles.  It supports
+// the queries:
+//
+//   - 'perf.stacks', a weighted tree of sampled callstacks, rooted at each
+//     sampled command, weighted by sample period.  The 'event' option
+//     restricts the tree to samples of the named event.
+//   - 'perf.sched', a per-CPU trace of scheduling activity.
+//
+// The 'collection_name' global filter names the Profile to query.
+type DataSource struct {
+	profiles map[string]*Profile
+}
+
+// NewDataSource returns a new DataSource serving the provided Profiles by
+// name.
+func NewDataSource(profiles map[string]*Profile) *DataSource {
+	return &DataSource{
+		profiles: profiles,
+	}
+}
+
+// SupportedDataSeriesQueries returns the queries supported by DataSource.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	return []string{stacksQuery, schedQuery}
+}
+
+// HandleDataSeriesRequests handles the provided DataSeriesRequests.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	globalOpts, err := util.ResolveOptions(globalState, nil, globalSchema)
+	if err != nil {
+		return err
+	}
+	collectionName, err := globalOpts.String(collectionNameKey)
+	if err != nil {
+		return err
+	}
+	p, ok := ds.profiles[collectionName]
+	if !ok {
+		return fmt.Errorf("no perf profile named '%s'", collectionName)
+	}
+	for _, req := range reqs {
+		var err error
+		switch req.QueryName {
+		case stacksQuery:
+			err = handleStacksQuery(p, drb.DataSeries(req), req.Options)
+		case schedQuery:
+			err = handleSchedQuery(p, drb.DataSeries(req), req.Options)
+		default:
+			err = fmt.Errorf("unsupported data query `%s`", req.QueryName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stackNode is a node in a prefix tree of callstacks.
+type stackNode struct {
+	frame    Frame
+	self     int64
+	children map[Frame]*stackNode
+}
+
+func newStackNode(frame Frame) *stackNode {
+	return &stackNode{
+		frame:    frame,
+		children: map[Frame]*stackNode{},
+	}
+}
+
+func (sn *stackNode) child(frame Frame) *stackNode {
+	child, ok := sn.children[frame]
+	if !ok {
+		child = newStackNode(frame)
+		sn.children[frame] = child
+	}
+	return child
+}
+
+// sortedChildren returns the receiver's children in a deterministic order.
+func (sn *stackNode) sortedChildren() []*stackNode {
+	ret := make([]*stackNode, 0, len(sn.children))
+	for _, child := range sn.children {
+		ret = append(ret, child)
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		if ret[a].frame.Symbol != ret[b].frame.Symbol {
+			return ret[a].frame.Symbol < ret[b].frame.Symbol
+		}
+		return ret[a].frame.DSO < ret[b].frame.DSO
+	})
+	return ret
+}
+
+type treeNode interface {
+	Node(selfMagnitude float64, properties ...util.PropertyUpdate) *weightedtree.Node
+}
+
+func (sn *stackNode) emit(parent treeNode) {
+	node := parent.Node(float64(sn.self),
+		util.StringProperty(frameKey, sn.frame.Symbol),
+		util.If(sn.frame.DSO != "", util.StringProperty(dsoKey, sn.frame.DSO)),
+	)
+	for _, child := range sn.sortedChildren() {
+		child.emit(node)
+	}
+}
+
+func handleStacksQuery(p *Profile, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	opts, err := util.ResolveOptions(nil, reqOpts, stacksSchema)
+	if err != nil {
+		return err
+	}
+	var event string
+	if opts.Has(eventKey) {
+		if event, err = opts.String(eventKey); err != nil {
+			return err
+		}
+	}
+	root := newStackNode(Frame{})
+	for _, sample := range p.Samples {
+		if event != "" && sample.Event != event {
+			continue
+		}
+		node := root.child(Frame{Symbol: sample.Comm})
+		for idx := len(sample.Stack) - 1; idx >= 0; idx-- {
+			node = node.child(sample.Stack[idx])
+		}
+		node.self += sample.Weight()
+	}
+	tree := weightedtree.New(series, treeRenderSettings)
+	for _, child := range root.sortedChildren() {
+		child.emit(tree)
+	}
+	return nil
+}
+
+func handleSchedQuery(p *Profile, series util.DataBuilder, reqOpts map[string]*util.V) error {
+	if _, err := util.ResolveOptions(nil, reqOpts, nil); err != nil {
+		return err
+	}
+	p.Sched.Trace(series, p.Start, p.End, nil)
+	return nil
+}
@@ -0,0 +1,205 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package perfscript imports Linux perf profiles, in the text format produced
+// by `perf script`, and serves them as TraceViz data: sampled callstacks as a
+// weighted tree, and scheduler tracepoints as per-CPU traces.  To import a
+// perf.data file, first convert it with
+//
+//	perf script -F comm,pid,tid,cpu,time,period,event,ip,sym,dso,trace > perf.txt
+package perfscript
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	schedtrace "github.com/google/traceviz/server/go/sched_trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Frame is a single stack frame.
+type Frame struct {
+	Symbol string
+	// The shared object, executable, or kernel image containing the frame.
+	DSO string
+}
+
+// Sample is a single perf event sample.
+type Sample struct {
+	Comm     string
+	PID, TID int64
+	CPU      int
+	// The time of the sample, since boot.
+	Time time.Duration
+	// The sample's period, or 0 if none was reported.
+	Period int64
+	Event  string
+	// Any event-specific text following the event name.
+	Payload string
+	// The sampled callstack, innermost frame first.
+	Stack []Frame
+}
+
+// Weight returns the sample's weight: its period if reported, and otherwise 1.
+func (s *Sample) Weight() int64 {
+	if s.Period > 0 {
+		return s.Period
+	}
+	return 1
+}
+
+// Profile is a parsed `perf script` profile.
+type Profile struct {
+	// All samples except scheduler tracepoints, in input order.
+	Samples []*Sample
+	// Scheduler tracepoints.
+	Sched *schedtrace.Sched
+	// The times of the earliest and latest events in the profile.
+	Start, End time.Duration
+}
+
+var (
+	// Matches a sample header line, e.g.
+	//   bash  1234/1235 [001] 12345.678901:     250000 cpu-clock:u:
+	//   bash  1234 [001] 12345.678901: sched:sched_switch: prev_comm=...
+	headerRE = regexp.MustCompile(`^(\S.*?)\s+(\d+)(?:/(\d+))?\s+\[(\d+)\]\s+(\d+)\.(\d+):\s+(?:(\d+)\s+)?(\S+):(?:\s+(.*))?$`)
+	// Matches a stack frame line, e.g.
+	//   	ffffffff81000000 native_safe_halt+0x6 ([kernel.kallsyms])
+	frameRE = regexp.MustCompile(`^\s+[0-9a-fA-F]+\s+(.*?)\s+\((.*)\)$`)
+	// Matches a symbol offset suffix, e.g. '+0x6'.
+	offsetRE = regexp.MustCompile(`\+0x[0-9a-fA-F]+$`)
+)
+
+func parseHeader(line string) (*Sample, error) {
+	m := headerRE.FindStringSubmatch(line)
+	if m == nil {
+		return nil, fmt.Errorf("unrecognized sample header '%s'", line)
+	}
+	s := &Sample{
+		Comm:    m[1],
+		Event:   m[8],
+		Payload: m[9],
+	}
+	var err error
+	if s.PID, err = strconv.ParseInt(m[2], 10, 64); err != nil {
+		return nil, err
+	}
+	s.TID = s.PID
+	if m[3] != "" {
+		if s.TID, err = strconv.ParseInt(m[3], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if s.CPU, err = strconv.Atoi(m[4]); err != nil {
+		return nil, err
+	}
+	if s.Time, err = util.ParseTimestamp(m[5], m[6]); err != nil {
+		return nil, err
+	}
+	if m[7] != "" {
+		if s.Period, err = strconv.ParseInt(m[7], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Parse parses the provided `perf script` output.
+func Parse(r io.Reader) (*Profile, error) {
+	p := &Profile{
+		Sched: schedtrace.New(),
+	}
+	var cur *Sample
+	seen := false
+	finish := func() error {
+		if cur == nil {
+			return nil
+		}
+		if !seen || cur.Time < p.Start {
+			p.Start = cur.Time
+		}
+		if !seen || cur.Time > p.End {
+			p.End = cur.Time
+		}
+		seen = true
+		switch strings.TrimPrefix(cur.Event, "sched:") {
+		case "sched_switch":
+			sw, err := schedtrace.ParseSwitch(cur.Time, cur.CPU, cur.Payload)
+			if err != nil {
+				return err
+			}
+			p.Sched.Switch(sw)
+		case "sched_wakeup", "sched_wakeup_new":
+			w, err := schedtrace.ParseWakeup(cur.Time, cur.Payload)
+			if err != nil {
+				return err
+			}
+			p.Sched.Wakeup(w)
+		default:
+			p.Samples = append(p.Samples, cur)
+		}
+		cur = nil
+		return nil
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			if err := finish(); err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+		case line[0] == ' ' || line[0] == '\t':
+			m := frameRE.FindStringSubmatch(line)
+			if cur == nil || m == nil {
+				return nil, fmt.Errorf("line %d: unexpected line '%s'", lineNum, line)
+			}
+			cur.Stack = append(cur.Stack, Frame{
+				Symbol: offsetRE.ReplaceAllString(m[1], ""),
+				DSO:    m[2],
+			})
+		default:
+			if err := finish(); err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+			var err error
+			if cur, err = parseHeader(line); err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNum, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ParseFile parses the specified file of `perf script` output.
+func ParseFile(filename string) (*Profile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
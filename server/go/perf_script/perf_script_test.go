@@ -0,0 +1,173 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package perfscript
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+)
+
+const perfScript = `my prog  1234/1235 [001] 100.000100:     250000 cpu-clock:u: 
+	    55d0c3a01234 inner+0x14 (/usr/bin/prog)
+	    55d0c3a05678 main+0x2a (/usr/bin/prog)
+	    7f0a12345678 __libc_start_main+0xf3 (/usr/lib/libc.so.6)
+
+my prog  1234/1235 [001] 100.000200:     250000 cpu-clock:u: 
+	    55d0c3a05678 main+0x2a (/usr/bin/prog)
+	    7f0a12345678 __libc_start_main+0xf3 (/usr/lib/libc.so.6)
+
+bash  99 [000] 100.000300:          1 cycles: 
+	ffffffff81000000 native_safe_halt ([kernel.kallsyms])
+
+bash    99 [000] 100.000400: sched:sched_switch: prev_comm=bash prev_pid=99 prev_prio=120 prev_state=R ==> next_comm=my prog next_pid=1234 next_prio=120
+swapper     0 [000] 100.000500: sched:sched_wakeup: comm=kworker pid=7 prio=120 target_cpu=000
+`
+
+func TestParse(t *testing.T) {
+	p, err := Parse(strings.NewReader(perfScript))
+	if err != nil {
+		t.Fatalf("Parse() yielded unexpected error %s", err)
+	}
+	prog := []Frame{
+		{Symbol: "main", DSO: "/usr/bin/prog"},
+		{Symbol: "__libc_start_main", DSO: "/usr/lib/libc.so.6"},
+	}
+	want := &Profile{
+		Samples: []*Sample{{
+			Comm: "my prog", PID: 1234, TID: 1235, CPU: 1,
+			Time:   100*time.Second + 100*time.Microsecond,
+			Period: 250000, Event: "cpu-clock:u",
+			Stack: append([]Frame{{Symbol: "inner", DSO: "/usr/bin/prog"}}, prog...),
+		}, {
+			Comm: "my prog", PID: 1234, TID: 1235, CPU: 1,
+			Time:   100*time.Second + 200*time.Microsecond,
+			Period: 250000, Event: "cpu-clock:u",
+			Stack: prog,
+		}, {
+			Comm: "bash", PID: 99, TID: 99, CPU: 0,
+			Time:   100*time.Second + 300*time.Microsecond,
+			Period: 1, Event: "cycles",
+			Stack: []Frame{{Symbol: "native_safe_halt", DSO: "[kernel.kallsyms]"}},
+		}},
+		Start: 100*time.Second + 100*time.Microsecond,
+		End:   100*time.Second + 500*time.Microsecond,
+	}
+	if diff := cmp.Diff(want, p, cmpopts.IgnoreFields(Profile{}, "Sched")); diff != "" {
+		t.Errorf("Parse() diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{0}, p.Sched.CPUs()); diff != "" {
+		t.Errorf("Parse() yielded sched events on unexpected CPUs, diff (-want +got):\n%s", diff)
+	}
+	if _, err := Parse(strings.NewReader("\tdeadbeef orphan (frame)\n")); err == nil {
+		t.Errorf("Parse() of a frame with no sample yielded no error")
+	}
+}
+
+func TestDataSource(t *testing.T) {
+	p, err := Parse(strings.NewReader(perfScript))
+	if err != nil {
+		t.Fatalf("Parse() yielded unexpected error %s", err)
+	}
+	ds := NewDataSource(map[string]*Profile{"prof": p})
+	globalFilters := map[string]*util.V{
+		collectionNameKey: util.StringValue("prof"),
+	}
+	frame := func(symbol, dso string) []util.PropertyUpdate {
+		return []util.PropertyUpdate{
+			util.StringProperty(frameKey, symbol),
+			util.If(dso != "", util.StringProperty(dsoKey, dso)),
+		}
+	}
+	for _, test := range []struct {
+		description string
+		req         *util.DataSeriesRequest
+		wantErr     bool
+		buildWant   func(db util.DataBuilder)
+	}{{
+		description: "all stacks",
+		req: &util.DataSeriesRequest{
+			QueryName: stacksQuery,
+		},
+		buildWant: func(db util.DataBuilder) {
+			tree := weightedtree.New(db, treeRenderSettings)
+			tree.Node(0, frame("bash", "")...).
+				Node(1, frame("native_safe_halt", "[kernel.kallsyms]")...)
+			tree.Node(0, frame("my prog", "")...).
+				Node(0, frame("__libc_start_main", "/usr/lib/libc.so.6")...).
+				Node(250000, frame("main", "/usr/bin/prog")...).
+				Node(250000, frame("inner", "/usr/bin/prog")...)
+		},
+	}, {
+		description: "stacks for one event",
+		req: &util.DataSeriesRequest{
+			QueryName: stacksQuery,
+			Options: map[string]*util.V{
+				eventKey: util.StringValue("cycles"),
+			},
+		},
+		buildWant: func(db util.DataBuilder) {
+			weightedtree.New(db, treeRenderSettings).
+				Node(0, frame("bash", "")...).
+				Node(1, frame("native_safe_halt", "[kernel.kallsyms]")...)
+		},
+	}, {
+		description: "sched",
+		req: &util.DataSeriesRequest{
+			QueryName: schedQuery,
+		},
+		buildWant: func(db util.DataBuilder) {
+			p.Sched.Trace(db, p.Start, p.End, nil)
+		},
+	}, {
+		description: "unsupported option",
+		req: &util.DataSeriesRequest{
+			QueryName: schedQuery,
+			Options: map[string]*util.V{
+				eventKey: util.StringValue("cycles"),
+			},
+		},
+		wantErr: true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := util.NewDataResponseBuilder()
+			err := ds.HandleDataSeriesRequests(context.Background(), globalFilters, drb, []*util.DataSeriesRequest{test.req})
+			if test.wantErr != (err != nil) {
+				t.Fatalf("HandleDataSeriesRequests() yielded unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			gotData, err := drb.Data()
+			if err != nil {
+				t.Fatalf("Data() yielded unexpected error %s", err)
+			}
+			wantDRB := util.NewDataResponseBuilder()
+			test.buildWant(wantDRB.DataSeries(test.req))
+			wantData, err := wantDRB.Data()
+			if err != nil {
+				t.Fatalf("Data() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+				t.Errorf("HandleDataSeriesRequests() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
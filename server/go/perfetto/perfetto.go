@@ -0,0 +1,311 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package perfetto imports traces in the Perfetto protobuf format, as produced
+// by Perfetto, Chrome, Android, and Fuchsia tracing, and assembles them into
+// TraceViz traces.  It reads track events, and the process, thread, and
+// counter track descriptors they refer to: tracks become trace categories,
+// slices become (possibly nested) spans, and counters become xy charts
+// embedded as payloads under the spans of their parent track.
+//
+// Only the subset of the Perfetto schema needed for this is read; in
+// particular, interned event names are not resolved.
+package perfetto
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Field numbers within the Perfetto protos.
+const (
+	// Trace
+	tracePacketField = 1
+	// TracePacket
+	timestampField       = 8
+	trackEventField      = 11
+	trackDescriptorField = 60
+	// TrackDescriptor
+	uuidField       = 1
+	nameField       = 2
+	processField    = 3
+	threadField     = 4
+	parentUUIDField = 5
+	counterField    = 8
+	// ProcessDescriptor
+	processPIDField  = 1
+	processNameField = 6
+	// ThreadDescriptor
+	threadPIDField  = 1
+	threadTIDField  = 2
+	threadNameField = 5
+	// TrackEvent
+	eventTypeField          = 9
+	eventTrackUUIDField     = 11
+	eventNameField          = 23
+	eventCounterValueField  = 30
+	eventDoubleCounterField = 44
+)
+
+// TrackEvent types.
+const (
+	sliceBeginType = 1
+	sliceEndType   = 2
+	instantType    = 3
+	counterType    = 4
+)
+
+// Slice is a named interval on a track.  Slices on the same track nest.
+type Slice struct {
+	Name       string
+	Start, End time.Duration
+	Children   []*Slice
+}
+
+// CounterValue is a single sample of a counter track.
+type CounterValue struct {
+	Time  time.Duration
+	Value float64
+}
+
+// Track is a Perfetto track: a timeline of slices, or of counter values.
+type Track struct {
+	UUID, ParentUUID uint64
+	Name             string
+	// Set for process and thread tracks.
+	PID int64
+	// Set for thread tracks.
+	TID       int64
+	IsProcess bool
+	IsThread  bool
+	IsCounter bool
+	// The track's top-level slices, in start order.
+	Slices []*Slice
+	// The track's counter values, in time order.
+	CounterValues []CounterValue
+	// The track's child tracks, in UUID order.
+	Children []*Track
+	// Slices begun but not yet ended, innermost last.
+	open []*Slice
+}
+
+// DisplayName returns the receiver's human-readable name.
+func (t *Track) DisplayName() string {
+	switch {
+	case t.IsThread:
+		return fmt.Sprintf("%s (tid %d)", t.Name, t.TID)
+	case t.IsProcess:
+		return fmt.Sprintf("%s (pid %d)", t.Name, t.PID)
+	case t.Name != "":
+		return t.Name
+	default:
+		return fmt.Sprintf("Track %d", t.UUID)
+	}
+}
+
+// Trace is a parsed Perfetto trace.
+type Trace struct {
+	// All tracks, by UUID.
+	Tracks map[uint64]*Track
+	// The tracks with no parent, in UUID order.
+	Roots []*Track
+	// The times of the earliest and latest events in the trace.
+	Start, End time.Duration
+}
+
+func (t *Trace) track(uuid uint64) *Track {
+	track, ok := t.Tracks[uuid]
+	if !ok {
+		track = &Track{UUID: uuid}
+		t.Tracks[uuid] = track
+	}
+	return track
+}
+
+func parseTrackDescriptor(t *Trace, msg []byte) error {
+	td := &Track{}
+	if err := forEachField(msg, func(f *field) error {
+		switch f.num {
+		case uuidField:
+			td.UUID = f.val
+		case parentUUIDField:
+			td.ParentUUID = f.val
+		case nameField:
+			td.Name = string(f.data)
+		case processField:
+			td.IsProcess = true
+			return forEachField(f.data, func(f *field) error {
+				switch f.num {
+				case processPIDField:
+					td.PID = int64(int32(f.val))
+				case processNameField:
+					td.Name = string(f.data)
+				}
+				return nil
+			})
+		case threadField:
+			td.IsThread = true
+			return forEachField(f.data, func(f *field) error {
+				switch f.num {
+				case threadPIDField:
+					td.PID = int64(int32(f.val))
+				case threadTIDField:
+					td.TID = int64(int32(f.val))
+				case threadNameField:
+					td.Name = string(f.data)
+				}
+				return nil
+			})
+		case counterField:
+			td.IsCounter = true
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("malformed track descriptor: %s", err)
+	}
+	track := t.track(td.UUID)
+	track.ParentUUID, track.Name, track.PID, track.TID = td.ParentUUID, td.Name, td.PID, td.TID
+	track.IsProcess, track.IsThread, track.IsCounter = td.IsProcess, td.IsThread, td.IsCounter
+	return nil
+}
+
+func parseTrackEvent(t *Trace, ts time.Duration, msg []byte) error {
+	var eventType, trackUUID uint64
+	var name string
+	var value float64
+	if err := forEachField(msg, func(f *field) error {
+		switch f.num {
+		case eventTypeField:
+			eventType = f.val
+		case eventTrackUUIDField:
+			trackUUID = f.val
+		case eventNameField:
+			name = string(f.data)
+		case eventCounterValueField:
+			value = float64(int64(f.val))
+		case eventDoubleCounterField:
+			value = math.Float64frombits(f.val)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("malformed track event: %s", err)
+	}
+	track := t.track(trackUUID)
+	switch eventType {
+	case sliceBeginType, instantType:
+		slice := &Slice{Name: name, Start: ts, End: ts}
+		if len(track.open) > 0 {
+			parent := track.open[len(track.open)-1]
+			parent.Children = append(parent.Children, slice)
+		} else {
+			track.Slices = append(track.Slices, slice)
+		}
+		if eventType == sliceBeginType {
+			track.open = append(track.open, slice)
+		}
+	case sliceEndType:
+		if len(track.open) > 0 {
+			track.open[len(track.open)-1].End = ts
+			track.open = track.open[:len(track.open)-1]
+		}
+	case counterType:
+		track.CounterValues = append(track.CounterValues, CounterValue{Time: ts, Value: value})
+	}
+	return nil
+}
+
+// Parse parses the provided serialized Perfetto Trace proto.  Slices left
+// open at the end of the trace end at the trace's end.
+func Parse(r io.Reader) (*Trace, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	t := &Trace{
+		Tracks: map[uint64]*Track{},
+	}
+	seen := false
+	if err := forEachField(buf, func(f *field) error {
+		if f.num != tracePacketField || f.wireType != lengthDelimitedWireType {
+			return nil
+		}
+		var ts time.Duration
+		var trackEvent, trackDescriptor []byte
+		if err := forEachField(f.data, func(f *field) error {
+			switch f.num {
+			case timestampField:
+				ts = time.Duration(f.val)
+			case trackEventField:
+				trackEvent = f.data
+			case trackDescriptorField:
+				trackDescriptor = f.data
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("malformed trace packet: %s", err)
+		}
+		if trackDescriptor != nil {
+			if err := parseTrackDescriptor(t, trackDescriptor); err != nil {
+				return err
+			}
+		}
+		if trackEvent != nil {
+			if !seen || ts < t.Start {
+				t.Start = ts
+			}
+			if !seen || ts > t.End {
+				t.End = ts
+			}
+			seen = true
+			return parseTrackEvent(t, ts, trackEvent)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	uuids := make([]uint64, 0, len(t.Tracks))
+	for uuid := range t.Tracks {
+		uuids = append(uuids, uuid)
+	}
+	sort.Slice(uuids, func(a, b int) bool { return uuids[a] < uuids[b] })
+	for _, uuid := range uuids {
+		track := t.Tracks[uuid]
+		for _, slice := range track.open {
+			slice.End = t.End
+		}
+		track.open = nil
+		sort.SliceStable(track.CounterValues, func(a, b int) bool {
+			return track.CounterValues[a].Time < track.CounterValues[b].Time
+		})
+		if parent, ok := t.Tracks[track.ParentUUID]; ok && track.ParentUUID != 0 && parent != track {
+			parent.Children = append(parent.Children, track)
+		} else {
+			t.Roots = append(t.Roots, track)
+		}
+	}
+	return t, nil
+}
+
+// ParseFile parses the specified Perfetto trace file.
+func ParseFile(filename string) (*Trace, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
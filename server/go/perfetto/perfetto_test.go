@@ -0,0 +1,189 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package perfetto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/payload"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+// Helpers for encoding test protos.
+func varintField(num int, val uint64) []byte {
+	ret := binary.AppendUvarint(nil, uint64(num<<3|varintWireType))
+	return binary.AppendUvarint(ret, val)
+}
+
+func doubleField(num int, val float64) []byte {
+	ret := binary.AppendUvarint(nil, uint64(num<<3|fixed64WireType))
+	return binary.LittleEndian.AppendUint64(ret, math.Float64bits(val))
+}
+
+func messageField(num int, fields ...[]byte) []byte {
+	contents := bytes.Join(fields, nil)
+	ret := binary.AppendUvarint(nil, uint64(num<<3|lengthDelimitedWireType))
+	ret = binary.AppendUvarint(ret, uint64(len(contents)))
+	return append(ret, contents...)
+}
+
+func stringField(num int, val string) []byte {
+	return messageField(num, []byte(val))
+}
+
+func packet(fields ...[]byte) []byte {
+	return messageField(tracePacketField, fields...)
+}
+
+func event(ts int, eventType int, trackUUID uint64, fields ...[]byte) []byte {
+	return packet(
+		varintField(timestampField, uint64(ts)),
+		messageField(trackEventField, append([][]byte{
+			varintField(eventTypeField, uint64(eventType)),
+			varintField(eventTrackUUIDField, trackUUID),
+		}, fields...)...),
+	)
+}
+
+func ns(dur int) time.Duration {
+	return time.Duration(dur) * time.Nanosecond
+}
+
+func testTrace() []byte {
+	return bytes.Join([][]byte{
+		packet(messageField(trackDescriptorField,
+			varintField(uuidField, 1),
+			messageField(processField,
+				varintField(processPIDField, 100),
+				stringField(processNameField, "server"),
+			),
+		)),
+		packet(messageField(trackDescriptorField,
+			varintField(uuidField, 2),
+			varintField(parentUUIDField, 1),
+			messageField(threadField,
+				varintField(threadPIDField, 100),
+				varintField(threadTIDField, 101),
+				stringField(threadNameField, "worker"),
+			),
+		)),
+		packet(messageField(trackDescriptorField,
+			varintField(uuidField, 3),
+			varintField(parentUUIDField, 2),
+			stringField(nameField, "queue depth"),
+			messageField(counterField),
+		)),
+		packet(messageField(trackDescriptorField,
+			varintField(uuidField, 4),
+			stringField(nameField, "memory"),
+			messageField(counterField),
+		)),
+		event(0, sliceBeginType, 2, stringField(eventNameField, "request")),
+		event(10, counterType, 3, varintField(eventCounterValueField, 4)),
+		event(20, sliceBeginType, 2, stringField(eventNameField, "parse")),
+		event(30, sliceEndType, 2),
+		event(40, counterType, 3, varintField(eventCounterValueField, 2)),
+		event(50, sliceEndType, 2),
+		event(60, instantType, 2, stringField(eventNameField, "tick")),
+		event(70, counterType, 4, doubleField(eventDoubleCounterField, 1.5)),
+		event(80, sliceBeginType, 2, stringField(eventNameField, "unterminated")),
+		event(90, counterType, 4, doubleField(eventDoubleCounterField, 2.5)),
+	}, nil)
+}
+
+func TestParse(t *testing.T) {
+	tr, err := Parse(bytes.NewReader(testTrace()))
+	if err != nil {
+		t.Fatalf("Parse() yielded unexpected error %s", err)
+	}
+	if tr.Start != ns(0) || tr.End != ns(90) {
+		t.Errorf("Parse() yielded extent %v-%v, want 0s-90ns", tr.Start, tr.End)
+	}
+	if len(tr.Roots) != 2 {
+		t.Fatalf("Parse() yielded %d root tracks, want 2", len(tr.Roots))
+	}
+	thread := tr.Tracks[2]
+	if got, want := thread.DisplayName(), "worker (tid 101)"; got != want {
+		t.Errorf("thread track has display name '%s', want '%s'", got, want)
+	}
+	wantSlices := []*Slice{
+		{Name: "request", Start: ns(0), End: ns(50), Children: []*Slice{
+			{Name: "parse", Start: ns(20), End: ns(30)},
+		}},
+		{Name: "tick", Start: ns(60), End: ns(60)},
+		{Name: "unterminated", Start: ns(80), End: ns(90)},
+	}
+	if diff := cmp.Diff(wantSlices, thread.Slices); diff != "" {
+		t.Errorf("Parse() yielded unexpected slices (-want +got):\n%s", diff)
+	}
+	if _, err := Parse(bytes.NewReader(packet([]byte{0xff}))); err == nil {
+		t.Errorf("Parse() of a malformed trace yielded no error")
+	}
+}
+
+func TestBuild(t *testing.T) {
+	tr, err := Parse(bytes.NewReader(testTrace()))
+	if err != nil {
+		t.Fatalf("Parse() yielded unexpected error %s", err)
+	}
+	drb := util.NewDataResponseBuilder()
+	tr.Build(drb.DataSeries(&util.DataSeriesRequest{}), nil)
+	got, err := drb.Data()
+	if err != nil {
+		t.Fatalf("failed to build response: %s", err)
+	}
+	wantDrb := util.NewDataResponseBuilder()
+	wantTrace := trace.New(wantDrb.DataSeries(&util.DataSeriesRequest{}),
+		continuousaxis.NewDurationAxis(timeCategory, ns(0), ns(90)), DefaultRenderSettings)
+	process := wantTrace.Category(TrackCategory(tr.Tracks[1]), util.IntegerProperty(pidKey, 100))
+	thread := process.Category(TrackCategory(tr.Tracks[2]),
+		util.IntegerProperty(pidKey, 100),
+		util.IntegerProperty(tidKey, 101),
+	)
+	request := thread.Span(ns(0), ns(50), util.StringProperty(nameKey, "request"))
+	request.Span(ns(20), ns(30), util.StringProperty(nameKey, "parse"))
+	xychart.New(
+		payload.New(request, CounterPayloadType),
+		continuousaxis.NewDurationAxis(timeCategory, ns(0), ns(50)),
+		continuousaxis.NewDoubleAxis(valueCategory, 2, 4),
+	).AddSeries(TrackCategory(tr.Tracks[3])).
+		WithPoint(ns(10), 4).
+		WithPoint(ns(40), 2)
+	thread.Span(ns(60), ns(60), util.StringProperty(nameKey, "tick"))
+	thread.Span(ns(80), ns(90), util.StringProperty(nameKey, "unterminated"))
+	memory := wantTrace.Category(TrackCategory(tr.Tracks[4])).
+		Span(ns(70), ns(90), util.StringProperty(nameKey, "memory"))
+	xychart.New(
+		payload.New(memory, CounterPayloadType),
+		continuousaxis.NewDurationAxis(timeCategory, ns(70), ns(90)),
+		continuousaxis.NewDoubleAxis(valueCategory, 1.5, 2.5),
+	).AddSeries(TrackCategory(tr.Tracks[4])).
+		WithPoint(ns(70), 1.5).
+		WithPoint(ns(90), 2.5)
+	want, err := wantDrb.Data()
+	if err != nil {
+		t.Fatalf("failed to build expected response: %s", err)
+	}
+	if diff := cmp.Diff(want.PrettyPrint(), got.PrettyPrint()); diff != "" {
+		t.Errorf("Build() yielded unexpected trace (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package perfetto
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/payload"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+const (
+	// The payload type of counter xy charts embedded under spans.
+	CounterPayloadType = "counters"
+
+	// Properties
+	nameKey = "name"
+	pidKey  = "pid"
+	tidKey  = "tid"
+)
+
+// DefaultRenderSettings are the trace render settings used if none are
+// specified.
+var DefaultRenderSettings = &trace.RenderSettings{
+	SpanWidthCatPx:   16,
+	SpanPaddingCatPx: 1,
+	CategoryAxisRenderSettings: &categoryaxis.RenderSettings{
+		CategoryHeaderCatPx:    16,
+		CategoryHandleValPx:    8,
+		CategoryPaddingCatPx:   2,
+		CategoryMarginValPx:    20,
+		CategoryMinWidthCatPx:  20,
+		CategoryBaseWidthValPx: 120,
+	},
+}
+
+var (
+	timeCategory  = category.New("x_axis", "Trace time", "Time from start of trace")
+	valueCategory = category.New("y_axis", "Value", "Counter value")
+)
+
+// TrackCategory returns the trace category for the provided Track.
+func TrackCategory(track *Track) *category.Category {
+	return category.New(
+		fmt.Sprintf("track%d", track.UUID),
+		track.DisplayName(),
+		track.DisplayName(),
+	)
+}
+
+// Build returns a new trace, populating the provided DataBuilder, presenting
+// the receiver.  Each slice track becomes a trace category, nested within the
+// category of its parent track, and each of its slices becomes a span.
+// Counter tracks whose parent track has slices are presented as xy charts,
+// with one series per counter, in 'counters' payloads under each of that
+// parent's top-level spans, containing the counter values within that span;
+// other counter tracks become their own categories, with a single span
+// carrying all their values.  If renderSettings is nil, DefaultRenderSettings
+// are used.
+func (t *Trace) Build(db util.DataBuilder, renderSettings *trace.RenderSettings) *trace.Trace[time.Duration] {
+	if renderSettings == nil {
+		renderSettings = DefaultRenderSettings
+	}
+	tr := trace.New(db, continuousaxis.NewDurationAxis(timeCategory, t.Start, t.End), renderSettings)
+	for _, track := range t.Roots {
+		if track.hidden() {
+			continue
+		}
+		addTrack(tr.Category(TrackCategory(track), trackProperties(track)...), track)
+	}
+	return tr
+}
+
+// hidden returns true if the receiver is a counter track with no values.
+func (track *Track) hidden() bool {
+	return track.IsCounter && len(track.CounterValues) == 0
+}
+
+// counters returns the receiver's child counter tracks with values, if the
+// receiver has slices to embed them under.
+func (track *Track) counters() []*Track {
+	if len(track.Slices) == 0 {
+		return nil
+	}
+	var ret []*Track
+	for _, child := range track.Children {
+		if child.IsCounter && len(child.CounterValues) > 0 {
+			ret = append(ret, child)
+		}
+	}
+	return ret
+}
+
+func trackProperties(track *Track) []util.PropertyUpdate {
+	return []util.PropertyUpdate{
+		util.If(track.IsProcess || track.IsThread, util.IntegerProperty(pidKey, track.PID)),
+		util.If(track.IsThread, util.IntegerProperty(tidKey, track.TID)),
+	}
+}
+
+func addTrack(cat *trace.Category[time.Duration], track *Track) {
+	if track.IsCounter {
+		start, end := track.CounterValues[0].Time, track.CounterValues[len(track.CounterValues)-1].Time
+		span := cat.Span(start, end, util.StringProperty(nameKey, track.DisplayName()))
+		addCounters(span, start, end, []*Track{track})
+	}
+	counters := track.counters()
+	for _, slice := range track.Slices {
+		span := cat.Span(slice.Start, slice.End, util.StringProperty(nameKey, slice.Name))
+		addSlices(span, slice.Children)
+		addCounters(span, slice.Start, slice.End, counters)
+	}
+	embedded := map[*Track]struct{}{}
+	for _, counter := range counters {
+		embedded[counter] = struct{}{}
+	}
+	for _, child := range track.Children {
+		if _, ok := embedded[child]; ok || child.hidden() {
+			continue
+		}
+		addTrack(cat.Category(TrackCategory(child), trackProperties(child)...), child)
+	}
+}
+
+func addSlices(parent *trace.Span[time.Duration], slices []*Slice) {
+	for _, slice := range slices {
+		addSlices(parent.Span(slice.Start, slice.End, util.StringProperty(nameKey, slice.Name)), slice.Children)
+	}
+}
+
+// addCounters embeds, under the provided span, an xy chart of the values of
+// the provided counter tracks lying within the specified interval.  If no
+// counter has values within the interval, no chart is embedded.
+func addCounters(span *trace.Span[time.Duration], start, end time.Duration, counters []*Track) {
+	var xyc *xychart.XYChart[time.Duration, float64]
+	for _, counter := range counters {
+		var series *xychart.Series[time.Duration, float64]
+		for _, cv := range counter.CounterValues {
+			if cv.Time < start || cv.Time > end {
+				continue
+			}
+			if xyc == nil {
+				xyc = xychart.New(
+					payload.New(span, CounterPayloadType),
+					continuousaxis.NewDurationAxis(timeCategory, start, end),
+					continuousaxis.NewDoubleAxis(valueCategory, minMax(counters, start, end)...),
+				)
+			}
+			if series == nil {
+				series = xyc.AddSeries(TrackCategory(counter))
+			}
+			series.WithPoint(cv.Time, cv.Value)
+		}
+	}
+}
+
+// minMax returns the extents of the values of the provided counter tracks
+// lying within the specified interval.
+func minMax(counters []*Track, start, end time.Duration) []float64 {
+	var ret []float64
+	for _, counter := range counters {
+		for _, cv := range counter.CounterValues {
+			if cv.Time >= start && cv.Time <= end {
+				ret = append(ret, cv.Value)
+			}
+		}
+	}
+	return ret
+}
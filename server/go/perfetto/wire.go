@@ -0,0 +1,84 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package perfetto
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Protobuf wire types.
+const (
+	varintWireType          = 0
+	fixed64WireType         = 1
+	lengthDelimitedWireType = 2
+	fixed32WireType         = 5
+)
+
+// field is a single decoded protobuf field.  For varint and fixed-width
+// fields, val holds the value; for length-delimited fields, data holds the
+// contents.
+type field struct {
+	num      int
+	wireType int
+	val      uint64
+	data     []byte
+}
+
+// forEachField decodes the provided serialized protobuf message, invoking the
+// provided callback on each of its fields in order.  Groups are unsupported.
+// This avoids a dependency on a protobuf library for the small subset of the
+// Perfetto schema this package reads.
+func forEachField(msg []byte, fn func(f *field) error) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return fmt.Errorf("malformed field tag")
+		}
+		msg = msg[n:]
+		f := &field{
+			num:      int(tag >> 3),
+			wireType: int(tag & 7),
+		}
+		switch f.wireType {
+		case varintWireType:
+			if f.val, n = binary.Uvarint(msg); n <= 0 {
+				return fmt.Errorf("malformed varint in field %d", f.num)
+			}
+			msg = msg[n:]
+		case fixed64WireType:
+			if len(msg) < 8 {
+				return fmt.Errorf("truncated fixed64 in field %d", f.num)
+			}
+			f.val, msg = binary.LittleEndian.Uint64(msg), msg[8:]
+		case lengthDelimitedWireType:
+			length, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < length {
+				return fmt.Errorf("malformed length-delimited field %d", f.num)
+			}
+			f.data, msg = msg[n:n+int(length)], msg[n+int(length):]
+		case fixed32WireType:
+			if len(msg) < 4 {
+				return fmt.Errorf("truncated fixed32 in field %d", f.num)
+			}
+			f.val, msg = uint64(binary.LittleEndian.Uint32(msg)), msg[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", f.wireType, f.num)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
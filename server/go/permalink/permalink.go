@@ -0,0 +1,185 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package permalink persists DataRequests, including their global filters,
+// under short generated IDs, so that a specific investigation state can be
+// shared as a link and later restored.  Storage is pluggable; in-memory and
+// filesystem Stores are provided.
+package permalink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// ErrNotFound is returned by Stores, and by Links.Load, for unknown IDs.
+var ErrNotFound = errors.New("permalink not found")
+
+// Store persists permalink contents by ID.  Stores must support concurrent
+// use.
+type Store interface {
+	// Put stores the provided contents under the specified ID, replacing any
+	// existing contents.
+	Put(ctx context.Context, id string, contents []byte) error
+	// Get returns the contents stored under the specified ID, or ErrNotFound.
+	Get(ctx context.Context, id string) ([]byte, error)
+}
+
+// MemoryStore is a Store holding permalinks in memory.  Its permalinks do not
+// survive process restarts.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	contents map[string][]byte
+}
+
+// NewMemoryStore returns a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		contents: map[string][]byte{},
+	}
+}
+
+// Put stores the provided contents under the specified ID.
+func (ms *MemoryStore) Put(ctx context.Context, id string, contents []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.contents[id] = append([]byte(nil), contents...)
+	return nil
+}
+
+// Get returns the contents stored under the specified ID.
+func (ms *MemoryStore) Get(ctx context.Context, id string) ([]byte, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	contents, ok := ms.contents[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return contents, nil
+}
+
+// FileStore is a Store holding each permalink in a file in a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a new FileStore in the specified directory, creating it
+// if necessary.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create permalink directory: %s", err)
+	}
+	return &FileStore{
+		dir: dir,
+	}, nil
+}
+
+// validIDRE matches IDs that are safe to use as filenames.
+var validIDRE = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (fs *FileStore) path(id string) (string, error) {
+	if !validIDRE.MatchString(id) {
+		return "", fmt.Errorf("invalid permalink ID '%s'", id)
+	}
+	return filepath.Join(fs.dir, id+".json"), nil
+}
+
+// Put stores the provided contents under the specified ID.  Contents are
+// written to a temporary file and renamed into place, so that concurrent
+// readers never see partial contents.
+func (fs *FileStore) Put(ctx context.Context, id string, contents []byte) error {
+	path, err := fs.path(id)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(fs.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Get returns the contents stored under the specified ID.
+func (fs *FileStore) Get(ctx context.Context, id string) ([]byte, error) {
+	path, err := fs.path(id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return contents, err
+}
+
+// Links saves and loads permalinked DataRequests.
+type Links struct {
+	store Store
+}
+
+// New returns a new Links persisting permalinks in the provided Store.
+func New(store Store) *Links {
+	return &Links{
+		store: store,
+	}
+}
+
+// idLen is the length, in bytes, of the content hash prefix used as an ID.
+const idLen = 12
+
+// Save persists the provided DataRequest and returns its ID.  IDs are derived
+// from the DataRequest's contents, so saving the same DataRequest twice yields
+// the same ID.
+func (l *Links) Save(ctx context.Context, req *util.DataRequest) (string, error) {
+	contents, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal DataRequest: %s", err)
+	}
+	hash := sha256.Sum256(contents)
+	id := base64.RawURLEncoding.EncodeToString(hash[:idLen])
+	if err := l.store.Put(ctx, id, contents); err != nil {
+		return "", fmt.Errorf("failed to store permalink: %s", err)
+	}
+	return id, nil
+}
+
+// Load returns the DataRequest saved under the specified ID, or ErrNotFound.
+func (l *Links) Load(ctx context.Context, id string) (*util.DataRequest, error) {
+	contents, err := l.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	req, err := util.DataRequestFromJSON(contents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal permalinked DataRequest: %s", err)
+	}
+	return req, nil
+}
@@ -0,0 +1,78 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package permalink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/util"
+)
+
+func TestLinks(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore() yielded unexpected error %s", err)
+	}
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			"collection_name": util.StringValue("coll"),
+			"start_timestamp": util.TimestampValue(time.Unix(100, 0)),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "logs.raw_entries",
+			SeriesName: "1",
+			Options: map[string]*util.V{
+				"limit": util.IntegerValue(10),
+			},
+		}},
+	}
+	for _, test := range []struct {
+		description string
+		store       Store
+	}{{
+		description: "memory",
+		store:       NewMemoryStore(),
+	}, {
+		description: "file",
+		store:       fileStore,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			ctx := context.Background()
+			links := New(test.store)
+			id, err := links.Save(ctx, req)
+			if err != nil {
+				t.Fatalf("Save() yielded unexpected error %s", err)
+			}
+			if id2, err := links.Save(ctx, req); err != nil || id2 != id {
+				t.Errorf("Save() of the same DataRequest yielded (%s, %v), want (%s, nil)", id2, err, id)
+			}
+			got, err := links.Load(ctx, id)
+			if err != nil {
+				t.Fatalf("Load() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(req, got); diff != "" {
+				t.Errorf("Load() yielded unexpected DataRequest (-want +got):\n%s", diff)
+			}
+			for _, badID := range []string{"nonexistent", "../escape"} {
+				if _, err := links.Load(ctx, badID); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Load(%q) yielded error %v, want ErrNotFound", badID, err)
+				}
+			}
+		})
+	}
+}
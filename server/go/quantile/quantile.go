@@ -0,0 +1,183 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package quantile provides Sketch, a compact, mergeable streaming summary of
+// a distribution of values from which approximate quantiles, such as median
+// or 99th-percentile latency, may be read.
+//
+// Sketch is a merging t-digest (Dunning and Ertl, 'Computing Extremely
+// Accurate Quantiles Using t-Digests'): values are clustered into weighted
+// centroids, with clusters kept small near the tails of the distribution, so
+// that extreme quantiles are more accurate than central ones.  A Sketch's size
+// is bounded by its compression, regardless of how many values it summarizes.
+package quantile
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultCompression is a Sketch compression balancing size and accuracy:
+// Sketches with this compression hold no more than a few hundred centroids,
+// and generally estimate quantiles to within a fraction of a percent.
+const DefaultCompression = 100
+
+// centroid is a cluster of values, summarized by their mean and count.
+type centroid struct {
+	mean, weight float64
+}
+
+// Sketch is a streaming summary of a distribution of values.  It is not
+// thread-safe.
+type Sketch struct {
+	compression float64
+	// Merged centroids, in increasing mean order.
+	centroids []centroid
+	// Values added since the last merge.
+	buffer   []centroid
+	weight   float64
+	min, max float64
+}
+
+// New returns a new, empty Sketch with the specified compression.  Higher
+// compressions yield larger, more accurate Sketches.  If compression is not
+// positive, DefaultCompression is used.
+func New(compression float64) *Sketch {
+	if compression <= 0 {
+		compression = DefaultCompression
+	}
+	return &Sketch{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// Add adds the provided value to the receiver.
+func (s *Sketch) Add(value float64) {
+	s.AddWeighted(value, 1)
+}
+
+// AddWeighted adds the provided value, with the provided positive weight, to
+// the receiver.  NaN values and non-positive weights are ignored.
+func (s *Sketch) AddWeighted(value, weight float64) {
+	if math.IsNaN(value) || !(weight > 0) {
+		return
+	}
+	s.buffer = append(s.buffer, centroid{value, weight})
+	s.weight += weight
+	if value < s.min {
+		s.min = value
+	}
+	if value > s.max {
+		s.max = value
+	}
+	if len(s.buffer) >= int(s.compression)*4 {
+		s.merge()
+	}
+}
+
+// Merge adds all the values summarized by the provided Sketch to the
+// receiver.
+func (s *Sketch) Merge(other *Sketch) {
+	if other.weight == 0 {
+		return
+	}
+	s.buffer = append(s.buffer, other.centroids...)
+	s.buffer = append(s.buffer, other.buffer...)
+	s.weight += other.weight
+	if other.min < s.min {
+		s.min = other.min
+	}
+	if other.max > s.max {
+		s.max = other.max
+	}
+	s.merge()
+}
+
+// Count returns the total weight of values added to the receiver.
+func (s *Sketch) Count() float64 {
+	return s.weight
+}
+
+// scale maps a quantile to the t-digest k1 scale, under which each centroid
+// may span at most one unit.
+func (s *Sketch) scale(q float64) float64 {
+	return s.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// merge folds the receiver's buffered values into its centroids.
+func (s *Sketch) merge() {
+	if len(s.buffer) == 0 {
+		return
+	}
+	all := append(s.centroids, s.buffer...)
+	sort.Slice(all, func(a, b int) bool {
+		return all[a].mean < all[b].mean
+	})
+	merged := make([]centroid, 0, len(s.centroids)+1)
+	cur := all[0]
+	var weightSoFar float64
+	for _, next := range all[1:] {
+		proposed := cur.weight + next.weight
+		if s.scale((weightSoFar+proposed)/s.weight)-s.scale(weightSoFar/s.weight) <= 1 {
+			cur.mean += (next.mean - cur.mean) * next.weight / proposed
+			cur.weight = proposed
+			continue
+		}
+		merged = append(merged, cur)
+		weightSoFar += cur.weight
+		cur = next
+	}
+	s.centroids = append(merged, cur)
+	s.buffer = nil
+}
+
+// Quantile returns an estimate of the value at the provided quantile, from 0
+// (the minimum) to 1 (the maximum), of the values added to the receiver.
+// Returns NaN if the receiver is empty.
+func (s *Sketch) Quantile(q float64) float64 {
+	s.merge()
+	if s.weight == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+	target := q * s.weight
+	// Each centroid's values are assumed to be centered on its mean, and values
+	// between adjacent centroids' centers are linearly interpolated.
+	prevCenter, prevMean := 0.0, s.min
+	var weightSoFar float64
+	for _, c := range s.centroids {
+		center := weightSoFar + c.weight/2
+		if target < center {
+			return interpolate(target, prevCenter, center, prevMean, c.mean)
+		}
+		prevCenter, prevMean = center, c.mean
+		weightSoFar += c.weight
+	}
+	return interpolate(target, prevCenter, s.weight, prevMean, s.max)
+}
+
+// interpolate returns the value at x along the line through (x0, y0) and
+// (x1, y1).
+func interpolate(x, x0, x1, y0, y1 float64) float64 {
+	if x1 <= x0 {
+		return y1
+	}
+	return y0 + (y1-y0)*(x-x0)/(x1-x0)
+}
@@ -0,0 +1,102 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package quantile
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestQuantile(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		values      []float64
+		q           float64
+		want        float64
+	}{{
+		description: "median of few",
+		values:      []float64{5, 1, 4, 2, 3},
+		q:           .5,
+		want:        3,
+	}, {
+		description: "min",
+		values:      []float64{5, 1, 4, 2, 3},
+		q:           0,
+		want:        1,
+	}, {
+		description: "max",
+		values:      []float64{5, 1, 4, 2, 3},
+		q:           1,
+		want:        5,
+	}, {
+		description: "interpolated",
+		values:      []float64{10, 20},
+		q:           .5,
+		want:        15,
+	}, {
+		description: "single value",
+		values:      []float64{7},
+		q:           .9,
+		want:        7,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			s := New(0)
+			for _, v := range test.values {
+				s.Add(v)
+			}
+			if got := s.Quantile(test.q); got != test.want {
+				t.Errorf("Quantile(%g) = %g, want %g", test.q, got, test.want)
+			}
+		})
+	}
+	if got := New(0).Quantile(.5); !math.IsNaN(got) {
+		t.Errorf("Quantile() of empty Sketch = %g, want NaN", got)
+	}
+}
+
+func TestAccuracy(t *testing.T) {
+	const n = 100000
+	r := rand.New(rand.NewSource(1))
+	whole, a, b := New(0), New(0), New(0)
+	for _, idx := range r.Perm(n) {
+		v := float64(idx)
+		whole.Add(v)
+		if idx%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+	a.Merge(b)
+	for _, s := range []*Sketch{whole, a} {
+		if s.Count() != n {
+			t.Errorf("Count() = %g, want %d", s.Count(), n)
+		}
+		for _, q := range []float64{.01, .1, .5, .9, .99, .999} {
+			want := q * n
+			// Tail quantiles should be more accurate than central ones.
+			tolerance := n * .005
+			if q < .05 || q > .95 {
+				tolerance = n * .001
+			}
+			if got := s.Quantile(q); math.Abs(got-want) > tolerance {
+				t.Errorf("Quantile(%g) = %g, want %g +/- %g", q, got, want, tolerance)
+			}
+		}
+		if len(s.centroids) > 2*DefaultCompression {
+			t.Errorf("Sketch has %d centroids, want at most %d", len(s.centroids), 2*DefaultCompression)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"encoding/json"
+	"runtime/metrics"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// DebugResourceStatsKey is a global filter which, if present in a
+// DataRequest, requests that the response include the resources used to
+// produce each of its data series.
+const DebugResourceStatsKey = "debug_resource_stats"
+
+// The number of most recently handled data series retained for reports.
+const recentSeriesCapacity = 100
+
+// ResourceUsage aggregates the resources used to produce many data series.
+// As in util.SeriesStats, the Process fields are process-wide, and include
+// work done concurrently with each series.
+type ResourceUsage struct {
+	Series              int64
+	WallTime            time.Duration
+	ProcessCPUTime      time.Duration
+	ProcessAllocBytes   uint64
+	ProcessAllocObjects uint64
+	Datums              int64
+	ResponseBytes       int64
+}
+
+func (ru *ResourceUsage) add(stats *util.SeriesStats) {
+	ru.Series++
+	ru.WallTime += stats.WallTime
+	ru.ProcessCPUTime += stats.ProcessCPUTime
+	ru.ProcessAllocBytes += stats.ProcessAllocBytes
+	ru.ProcessAllocObjects += stats.ProcessAllocObjects
+	ru.Datums += stats.Datums
+	ru.ResponseBytes += stats.ResponseBytes
+}
+
+// ResourceReport describes the resources used by a QueryDispatcher.
+type ResourceReport struct {
+	// Aggregate resource usage by query name.
+	ByQuery map[string]*ResourceUsage
+	// Aggregate resource usage by collection.
+	ByCollection map[string]*ResourceUsage
+	// The most recently produced data series, most recent last.
+	Recent []*util.SeriesStats
+}
+
+// accountant records the resources used by a QueryDispatcher.
+type accountant struct {
+	// The global filter naming the collection each DataRequest queries.
+	collectionKey string
+	mu            sync.Mutex
+	byQuery       map[string]*ResourceUsage
+	byCollection  map[string]*ResourceUsage
+	recent        []*util.SeriesStats
+}
+
+// collection returns the name of the collection queried with the provided
+// global filters, or the empty string if none is named.  Multiple collection
+// names are comma-separated.
+func (a *accountant) collection(globalFilters map[string]*util.V) string {
+	val, ok := globalFilters[a.collectionKey]
+	if !ok {
+		return ""
+	}
+	if names, err := util.ExpectStringsValue(val); err == nil {
+		return strings.Join(names, ",")
+	}
+	name, _ := util.ExpectStringValue(val)
+	return name
+}
+
+func (a *accountant) record(globalFilters map[string]*util.V, stats []*util.SeriesStats) {
+	collection := a.collection(globalFilters)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range stats {
+		usage := func(m map[string]*ResourceUsage, key string) *ResourceUsage {
+			ru, ok := m[key]
+			if !ok {
+				ru = &ResourceUsage{}
+				m[key] = ru
+			}
+			return ru
+		}
+		usage(a.byQuery, s.QueryName).add(s)
+		usage(a.byCollection, collection).add(s)
+		a.recent = append(a.recent, s)
+	}
+	if len(a.recent) > recentSeriesCapacity {
+		a.recent = append([]*util.SeriesStats{}, a.recent[len(a.recent)-recentSeriesCapacity:]...)
+	}
+}
+
+// WithResourceAccounting specifies that the receiver should record the
+// resources used to produce each data series, aggregated by query name and by
+// the collection named in the specified global filter, and returns the
+// receiver.  The recorded resources are reported by ResourceReport.
+func (qd *QueryDispatcher) WithResourceAccounting(collectionKey string) *QueryDispatcher {
+	qd.accountant = &accountant{
+		collectionKey: collectionKey,
+		byQuery:       map[string]*ResourceUsage{},
+		byCollection:  map[string]*ResourceUsage{},
+	}
+	return qd
+}
+
+// ResourceReport returns a snapshot of the resources recorded by the
+// receiver, or nil if it does not perform resource accounting.
+func (qd *QueryDispatcher) ResourceReport() *ResourceReport {
+	a := qd.accountant
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ret := &ResourceReport{
+		ByQuery:      make(map[string]*ResourceUsage, len(a.byQuery)),
+		ByCollection: make(map[string]*ResourceUsage, len(a.byCollection)),
+		Recent:       make([]*util.SeriesStats, len(a.recent)),
+	}
+	for key, ru := range a.byQuery {
+		ruCopy := *ru
+		ret.ByQuery[key] = &ruCopy
+	}
+	for key, ru := range a.byCollection {
+		ruCopy := *ru
+		ret.ByCollection[key] = &ruCopy
+	}
+	for idx, s := range a.recent {
+		sCopy := *s
+		ret.Recent[idx] = &sCopy
+	}
+	return ret
+}
+
+// The runtime metrics sampled around each data series.
+var usageMetrics = []string{
+	"/cpu/classes/user:cpu-seconds",
+	"/gc/heap/allocs:bytes",
+	"/gc/heap/allocs:objects",
+}
+
+// usage is a snapshot of process-wide resource usage.
+type usage struct {
+	at           time.Time
+	cpu          time.Duration
+	allocBytes   uint64
+	allocObjects uint64
+}
+
+func readUsage() usage {
+	samples := make([]metrics.Sample, len(usageMetrics))
+	for idx, name := range usageMetrics {
+		samples[idx].Name = name
+	}
+	metrics.Read(samples)
+	ret := usage{
+		at: time.Now(),
+	}
+	if samples[0].Value.Kind() == metrics.KindFloat64 {
+		ret.cpu = time.Duration(samples[0].Value.Float64() * float64(time.Second))
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		ret.allocBytes = samples[1].Value.Uint64()
+	}
+	if samples[2].Value.Kind() == metrics.KindUint64 {
+		ret.allocObjects = samples[2].Value.Uint64()
+	}
+	return ret
+}
+
+// seriesStats returns SeriesStats for the provided request, with the wall time
+// elapsed, and the process-wide resources used, since the provided usage
+// snapshot was taken.
+func seriesStats(req *util.DataSeriesRequest, since usage) *util.SeriesStats {
+	now := readUsage()
+	return &util.SeriesStats{
+		SeriesName:          req.SeriesName,
+		QueryName:           req.QueryName,
+		WallTime:            now.at.Sub(since.at),
+		ProcessCPUTime:      now.cpu - since.cpu,
+		ProcessAllocBytes:   now.allocBytes - since.allocBytes,
+		ProcessAllocObjects: now.allocObjects - since.allocObjects,
+	}
+}
+
+// completeSeriesStats fills in the size of each series in the provided Data
+// into the corresponding provided SeriesStats.
+func completeSeriesStats(data *util.Data, stats []*util.SeriesStats) {
+	statsBySeries := make(map[string]*util.SeriesStats, len(stats))
+	for _, s := range stats {
+		statsBySeries[s.SeriesName] = s
+	}
+	for _, series := range data.DataSeries {
+		s, ok := statsBySeries[series.SeriesName]
+		if !ok {
+			continue
+		}
+		s.Datums = series.DatumCount()
+		if j, err := json.Marshal(series); err == nil {
+			s.ResponseBytes = int64(len(j))
+		}
+	}
+	sort.Slice(stats, func(a, b int) bool {
+		return stats[a].SeriesName < stats[b].SeriesName
+	})
+}
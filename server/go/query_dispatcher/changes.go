@@ -0,0 +1,81 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// watchingDataSource is implemented by dataSources whose collections may
+// change while they are being served, e.g. logs that are still being written.
+type watchingDataSource interface {
+	// Changed returns a channel that is closed when the collection specified
+	// by the provided global filters next changes, or nil if the dataSource
+	// cannot observe changes to that collection.  Any state used to watch for
+	// changes should be released once the provided Context is done.
+	Changed(ctx context.Context, globalFilters map[string]*util.V) (<-chan struct{}, error)
+}
+
+// WaitForChange blocks until a dataSource reports that the collection
+// specified by the provided global filters, after applying the receiver's
+// GlobalFilterMiddleware to them, has changed, and then returns nil.  If the
+// provided Context is done first, its error is returned.  An error is
+// returned immediately if no dataSource can observe changes to the
+// collection.
+func (qd *QueryDispatcher) WaitForChange(ctx context.Context, globalFilters map[string]*util.V) error {
+	globalFilters, err := qd.applyGlobalFilterMiddleware(ctx, globalFilters)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	changed := make(chan struct{}, 1)
+	watched := false
+	for _, ds := range qd.dataSources {
+		wds, ok := ds.(watchingDataSource)
+		if !ok {
+			continue
+		}
+		ch, err := wds.Changed(ctx, globalFilters)
+		if err != nil {
+			return err
+		}
+		if ch == nil {
+			continue
+		}
+		watched = true
+		go func() {
+			select {
+			case <-ch:
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+			}
+		}()
+	}
+	if !watched {
+		return fmt.Errorf("no data source can observe changes to the requested collection")
+	}
+	select {
+	case <-changed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
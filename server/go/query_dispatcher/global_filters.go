@@ -0,0 +1,110 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// GlobalFilterMiddleware validates, normalizes, or otherwise updates a
+// DataRequest's global filters before any dataSource sees them.  It may
+// modify the provided map in place.  Any returned error will cancel the entire
+// DataRequest and surface to the client.
+type GlobalFilterMiddleware func(ctx context.Context, globalFilters map[string]*util.V) error
+
+// UseGlobalFilterMiddleware adds the provided GlobalFilterMiddleware to the
+// receiver.  Middleware is applied in the order it was added to a copy of
+// each DataRequest's global filters; the resulting filters are passed to all
+// dataSources, and returned to the client in Data.GlobalFilters.
+func (qd *QueryDispatcher) UseGlobalFilterMiddleware(middleware ...GlobalFilterMiddleware) *QueryDispatcher {
+	qd.globalFilterMiddleware = append(qd.globalFilterMiddleware, middleware...)
+	return qd
+}
+
+// applyGlobalFilterMiddleware returns a copy of the provided global filters
+// transformed by the receiver's GlobalFilterMiddleware.
+func (qd *QueryDispatcher) applyGlobalFilterMiddleware(ctx context.Context, globalFilters map[string]*util.V) (map[string]*util.V, error) {
+	if len(qd.globalFilterMiddleware) == 0 {
+		return globalFilters, nil
+	}
+	ret := make(map[string]*util.V, len(globalFilters))
+	for key, val := range globalFilters {
+		ret[key] = val
+	}
+	for _, mw := range qd.globalFilterMiddleware {
+		if err := mw(ctx, ret); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// RequireGlobalFilters returns a GlobalFilterMiddleware rejecting DataRequests
+// lacking any of the specified global filters.
+func RequireGlobalFilters(keys ...string) GlobalFilterMiddleware {
+	return func(ctx context.Context, globalFilters map[string]*util.V) error {
+		for _, key := range keys {
+			if _, ok := globalFilters[key]; !ok {
+				return fmt.Errorf("missing required global filter '%s'", key)
+			}
+		}
+		return nil
+	}
+}
+
+// DefaultGlobalFilters returns a GlobalFilterMiddleware setting each of the
+// provided global filters that a DataRequest does not specify.
+func DefaultGlobalFilters(defaults map[string]*util.V) GlobalFilterMiddleware {
+	return func(ctx context.Context, globalFilters map[string]*util.V) error {
+		for key, val := range defaults {
+			if _, ok := globalFilters[key]; !ok {
+				globalFilters[key] = val
+			}
+		}
+		return nil
+	}
+}
+
+// ClampTimeRange returns a GlobalFilterMiddleware ensuring that the time range
+// specified by the provided start and end timestamp global filters, if both
+// are present, is well-formed and no longer than the specified maximum
+// duration.  Overlong ranges are shortened by moving their end earlier.
+func ClampTimeRange(startKey, endKey string, maxDuration time.Duration) GlobalFilterMiddleware {
+	return func(ctx context.Context, globalFilters map[string]*util.V) error {
+		startVal, okStart := globalFilters[startKey]
+		endVal, okEnd := globalFilters[endKey]
+		if !okStart || !okEnd {
+			return nil
+		}
+		start, err := util.ExpectTimestampValue(startVal)
+		if err != nil {
+			return fmt.Errorf("global filter '%s': %s", startKey, err)
+		}
+		end, err := util.ExpectTimestampValue(endVal)
+		if err != nil {
+			return fmt.Errorf("global filter '%s': %s", endKey, err)
+		}
+		if end.Before(start) {
+			return fmt.Errorf("global filter '%s' is before '%s'", endKey, startKey)
+		}
+		if maxDuration > 0 && end.Sub(start) > maxDuration {
+			globalFilters[endKey] = util.TimestampValue(start.Add(maxDuration))
+		}
+		return nil
+	}
+}
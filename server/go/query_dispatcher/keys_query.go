@@ -0,0 +1,67 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+
+	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// KeysQuery is the built-in query, supported by QueryDispatchers with the
+// keys query enabled, listing the property keys registered in the keys
+// registry as a table with one row per key, and columns for the key and its
+// owner.  It is intended for debugging key collisions.
+const KeysQuery = "traceviz.keys"
+
+var (
+	keysQueryKeyCol   = table.Column(category.New("key", "Key", "The property key"))
+	keysQueryOwnerCol = table.Column(category.New("owner", "Owner", "The package or data source that registered the key"))
+)
+
+// keysSource is a dataSource serving KeysQuery.
+type keysSource struct{}
+
+// WithKeysQuery specifies that the receiver should serve the built-in
+// KeysQuery, and returns the receiver.  Returns an error if another
+// dataSource already supports KeysQuery.
+func (qd *QueryDispatcher) WithKeysQuery() (*QueryDispatcher, error) {
+	if err := qd.addDataSource(keysSource{}); err != nil {
+		return nil, err
+	}
+	return qd, nil
+}
+
+func (keysSource) SupportedDataSeriesQueries() []string {
+	return []string{KeysQuery}
+}
+
+func (keysSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		if _, err := util.ResolveOptions(nil, req.Options, nil); err != nil {
+			return err
+		}
+		t := table.New(drb.DataSeries(req), nil, keysQueryKeyCol, keysQueryOwnerCol)
+		for _, reg := range keys.Registered() {
+			t.Row(
+				table.Cell(keysQueryKeyCol, util.String(reg.Key)),
+				table.Cell(keysQueryOwnerCol, util.String(reg.Owner)),
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+// collectionLoadingDataSource is implemented by dataSources that maintain
+// state derived from each loaded collection, such as indices or weight
+// caches, and build it as collections are loaded into a cache.
+type collectionLoadingDataSource interface {
+	// OnCollectionLoaded is invoked when the named collection is loaded into
+	// a cache, with the loaded collection, whose type is specific to that
+	// cache.  It may be invoked concurrently with itself, with
+	// OnCollectionEvicted, and with HandleDataSeriesRequests.
+	OnCollectionLoaded(collectionName string, collection any)
+}
+
+// collectionEvictingDataSource is implemented by dataSources that maintain
+// state derived from each loaded collection, and release it as collections
+// are evicted from a cache.
+type collectionEvictingDataSource interface {
+	// OnCollectionEvicted is invoked when the named collection is evicted
+	// from a cache, whether to make room for others, because it expired, or
+	// because it changed.  It may be invoked concurrently with itself, with
+	// OnCollectionLoaded, and with HandleDataSeriesRequests.
+	OnCollectionEvicted(collectionName string)
+}
+
+// CollectionLoaded notifies each of the receiver's dataSources implementing
+// OnCollectionLoaded that the named collection was loaded into a cache.  It
+// should be invoked by the cache, or by the fetcher populating it.
+func (qd *QueryDispatcher) CollectionLoaded(collectionName string, collection any) {
+	for _, ds := range qd.dataSources {
+		if lds, ok := ds.(collectionLoadingDataSource); ok {
+			lds.OnCollectionLoaded(collectionName, collection)
+		}
+	}
+}
+
+// CollectionEvicted notifies each of the receiver's dataSources implementing
+// OnCollectionEvicted that the named collection was evicted from a cache.  It
+// should be invoked by the cache.
+func (qd *QueryDispatcher) CollectionEvicted(collectionName string) {
+	for _, ds := range qd.dataSources {
+		if eds, ok := ds.(collectionEvictingDataSource); ok {
+			eds.OnCollectionEvicted(collectionName)
+		}
+	}
+}
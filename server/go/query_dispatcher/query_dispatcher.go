@@ -18,6 +18,10 @@ package querydispatcher
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/traceviz/server/go/util"
 	"golang.org/x/sync/errgroup"
@@ -29,7 +33,9 @@ type dataSource interface {
 	// SupportedDataSeriesQueries returns the list of
 	// tracevizpb.DataSeriesRequest.QueryNames this dataSource is able to handle.
 	// Query names should be unique to their dataSource: e.g., they may have the
-	// dataSource's fully qualified type name prepended.
+	// dataSource's fully qualified type name prepended.  Query names following
+	// the QueryName convention may be versioned; a dataSource may support
+	// several versions of the same query.
 	SupportedDataSeriesQueries() []string
 	// HandleDataSeriesRequests handles a set of DataSeriesRequests for the
 	// supplied collection name, with the supplied global options.  dataSource
@@ -39,6 +45,17 @@ type dataSource interface {
 	HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error
 }
 
+// authorizingDataSource is implemented by dataSources that restrict which
+// DataSeriesRequests they handle, e.g. to limit which collections each
+// Principal (available via util.PrincipalFrom) may fetch.
+type authorizingDataSource interface {
+	// AuthorizeDataSeriesRequests returns nil if the provided
+	// DataSeriesRequests may be handled with the provided global filters in
+	// the provided Context, and an error otherwise.  Any returned error will
+	// cancel the entire DataRequest before any dataSource handles it.
+	AuthorizeDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, reqs []*util.DataSeriesRequest) error
+}
+
 // QueryDispatcher multiplexes multiple data query handlers, which may be from
 // entirely different datasets and analysis libraries, allowing common queries
 // to be satisfied by a variety of data providers.
@@ -47,52 +64,257 @@ type QueryDispatcher struct {
 	// Maps data series query names to indices (in dataSources) of the
 	// dataSources that handle those queries.
 	dataSeriesQueryHandlers map[string]int
+	// Maps unversioned data series query names to the supported versions of
+	// those queries, in increasing order.
+	versionsByQuery map[string][]int
+	// Maps canonical versioned query names (see QueryName.String) to the
+	// query names as the supporting dataSource reported them.
+	registeredNames map[string]string
+	// Applied, in order, to each DataRequest's global filters.
+	globalFilterMiddleware []GlobalFilterMiddleware
+	// Warmups started with StartWarmup, keyed by their JSON-encoded hints.
+	warmupMu sync.Mutex
+	warmups  map[string]*warmup
+	// If non-nil, records the resources used to produce each data series.
+	accountant *accountant
+	// Notified of each panic recovered while handling a DataSeriesRequest.
+	panicHandler PanicHandler
+	// The number of panics recovered.
+	panics atomic.Uint64
+	// If non-nil, records the handling of recent DataRequests.
+	selfTracer *selfTracer
+}
+
+// QuerySchema describes a single data series query supported by a
+// QueryDispatcher.
+type QuerySchema struct {
+	// The unversioned query name.
+	Name string
+	// The supported versions of the query, in increasing order.
+	Versions []int
+}
+
+// Schema describes the data series queries supported by a QueryDispatcher.
+type Schema struct {
+	Queries []*QuerySchema
 }
 
 // New returns a *QueryDispatcher wrapping the provided dataSources.
 func New(dss ...dataSource) (*QueryDispatcher, error) {
 	qd := &QueryDispatcher{
 		dataSeriesQueryHandlers: map[string]int{},
+		versionsByQuery:         map[string][]int{},
+		registeredNames:         map[string]string{},
+		warmups:                 map[string]*warmup{},
+		panicHandler:            LogPanics,
 	}
-	for dsIdx, ds := range dss {
-		qd.dataSources = append(qd.dataSources, ds)
-		for _, traceQueryName := range ds.SupportedDataSeriesQueries() {
-			if _, ok := qd.dataSeriesQueryHandlers[traceQueryName]; ok {
-				return nil, fmt.Errorf(
-					"multiple dataSources handle trace query `%s`", traceQueryName)
-			}
-			qd.dataSeriesQueryHandlers[traceQueryName] = dsIdx
+	for _, ds := range dss {
+		if err := qd.addDataSource(ds); err != nil {
+			return nil, err
 		}
 	}
 	return qd, nil
 }
 
+// WithDataSource adds the provided dataSource to the receiver, as if it had
+// been provided to New, and returns the receiver.  This is useful when
+// dataSources are optional.  It must not be called while the receiver handles
+// DataRequests.  Returns an error if the dataSource supports any query
+// another dataSource already does.
+func (qd *QueryDispatcher) WithDataSource(ds dataSource) (*QueryDispatcher, error) {
+	if err := qd.addDataSource(ds); err != nil {
+		return nil, err
+	}
+	return qd, nil
+}
+
+// addDataSource adds the provided dataSource to the receiver, returning an
+// error if it supports any query another dataSource already does.
+func (qd *QueryDispatcher) addDataSource(ds dataSource) error {
+	dsIdx := len(qd.dataSources)
+	qd.dataSources = append(qd.dataSources, ds)
+	for _, traceQueryName := range ds.SupportedDataSeriesQueries() {
+		qn, err := ParseQueryName(traceQueryName)
+		if err != nil {
+			return err
+		}
+		if qn.Version == 0 {
+			qn.Version = 1
+		}
+		if _, ok := qd.dataSeriesQueryHandlers[qn.String()]; ok {
+			return fmt.Errorf(
+				"multiple dataSources handle trace query `%s`", traceQueryName)
+		}
+		qd.dataSeriesQueryHandlers[qn.String()] = dsIdx
+		qd.registeredNames[qn.String()] = traceQueryName
+		qd.versionsByQuery[qn.Base()] = append(qd.versionsByQuery[qn.Base()], qn.Version)
+	}
+	for _, versions := range qd.versionsByQuery {
+		sort.Ints(versions)
+	}
+	return nil
+}
+
+// resolve returns the supported query name, as reported by its dataSource,
+// best satisfying the provided requested query name: the highest supported
+// version of the requested query no greater than the requested version, or,
+// if the request is unversioned, the highest supported version.  It also
+// returns the index of the dataSource handling that query.
+func (qd *QueryDispatcher) resolve(queryName string) (string, int, error) {
+	qn, err := ParseQueryName(queryName)
+	if err != nil {
+		return "", 0, err
+	}
+	versions := qd.versionsByQuery[qn.Base()]
+	for idx := len(versions) - 1; idx >= 0; idx-- {
+		if qn.Version == 0 || versions[idx] <= qn.Version {
+			qn.Version = versions[idx]
+			return qd.registeredNames[qn.String()], qd.dataSeriesQueryHandlers[qn.String()], nil
+		}
+	}
+	if len(versions) > 0 {
+		return "", 0, fmt.Errorf("data query `%s` is not supported at version %d or earlier", qn.Base(), qn.Version)
+	}
+	return "", 0, fmt.Errorf("unsupported data query `%s`", queryName)
+}
+
+// ResolveQueryName returns the supported query name, as reported by its
+// dataSource, that would handle a DataSeriesRequest with the provided query
+// name, or false if no supported query would handle it.
+func (qd *QueryDispatcher) ResolveQueryName(queryName string) (string, bool) {
+	resolved, _, err := qd.resolve(queryName)
+	return resolved, err == nil
+}
+
+// Schema returns a description of the data series queries, and their
+// versions, supported by the receiver.
+func (qd *QueryDispatcher) Schema() *Schema {
+	ret := &Schema{
+		Queries: make([]*QuerySchema, 0, len(qd.versionsByQuery)),
+	}
+	for name, versions := range qd.versionsByQuery {
+		ret.Queries = append(ret.Queries, &QuerySchema{
+			Name:     name,
+			Versions: append([]int{}, versions...),
+		})
+	}
+	sort.Slice(ret.Queries, func(a, b int) bool {
+		return ret.Queries[a].Name < ret.Queries[b].Name
+	})
+	return ret
+}
+
 // HandleDataRequest distributes the provided tracevizpb.DataRequest's
 // constituent DataSeriesRequests to their appropriate dataSources for processing,
 // then assembles the returned tracevizpb.DataSeries into a
-// tracevizpb.DataResponse.
+// tracevizpb.DataResponse.  Each DataSeriesRequest is handled by the highest
+// supported version of its query no greater than the requested version, or
+// by the highest supported version if the request is unversioned; the
+// dataSource receives the DataSeriesRequest with its QueryName rewritten to
+// that version.  DataSeries whose fingerprints match those requested are
+// returned as NotModified stubs.  If the receiver performs resource
+// accounting or self-tracing, or the DataRequest has the
+// DebugResourceStatsKey global filter, each DataSeriesRequest is passed to
+// its dataSource separately, so that the resources used to produce it may be
+// measured.  A DataSeriesRequest whose
+// dataSource panics yields a DataSeries reporting the panic, and the rest of
+// the response is unaffected.
 func (qd *QueryDispatcher) HandleDataRequest(ctx context.Context, req *util.DataRequest) (*util.Data, error) {
-	drb := util.NewDataResponseBuilder()
+	globalFilters, err := qd.applyGlobalFilterMiddleware(ctx, req.GlobalFilters)
+	if err != nil {
+		return nil, err
+	}
+	drb := util.NewDataResponseBuilder().
+		WithGlobalFilters(globalFilters).
+		WithRequestedFingerprints(req.SeriesRequests...)
 	// A mapping from dataSource index to a set of DataRequests that source can
 	// handle.
 	groupedReqs := map[int][]*util.DataSeriesRequest{}
 	for _, seriesReq := range req.SeriesRequests {
-		dsIdx, ok := qd.dataSeriesQueryHandlers[seriesReq.QueryName]
-		if !ok {
-			return nil, fmt.Errorf("unsupported data query `%s`", seriesReq.QueryName)
+		queryName, dsIdx, err := qd.resolve(seriesReq.QueryName)
+		if err != nil {
+			return nil, err
+		}
+		if queryName != seriesReq.QueryName {
+			resolvedReq := *seriesReq
+			resolvedReq.QueryName = queryName
+			seriesReq = &resolvedReq
 		}
 		groupedReqs[dsIdx] = append(groupedReqs[dsIdx], seriesReq)
 	}
+	for dsIdx, seriesReqs := range groupedReqs {
+		if ads, ok := qd.dataSources[dsIdx].(authorizingDataSource); ok {
+			if err := ads.AuthorizeDataSeriesRequests(ctx, globalFilters, seriesReqs); err != nil {
+				return nil, err
+			}
+		}
+	}
+	_, debugStats := globalFilters[DebugResourceStatsKey]
+	account := qd.accountant != nil || debugStats
+	rt := qd.selfTracer.begin(req)
+	if rt != nil {
+		defer qd.selfTracer.record(rt)
+	}
+	var statsMu sync.Mutex
+	var stats []*util.SeriesStats
 	errg, ctx := errgroup.WithContext(ctx)
 	for dsIdx, seriesReqs := range groupedReqs {
 		func(ds dataSource, seriesReqs []*util.DataSeriesRequest) {
 			errg.Go(func() error {
-				return ds.HandleDataSeriesRequests(ctx, req.GlobalFilters, drb, seriesReqs)
+				if !account && rt == nil {
+					return qd.handle(ctx, ds, globalFilters, drb, seriesReqs)
+				}
+				// Handle each DataSeriesRequest separately, so that the
+				// resources used can be attributed to it.
+				for _, seriesReq := range seriesReqs {
+					var start usage
+					if account {
+						start = readUsage()
+					}
+					seriesCtx := ctx
+					var st *util.SeriesTrace
+					if rt != nil {
+						st = util.NewSeriesTrace(seriesReq)
+						seriesCtx = util.WithSeriesTrace(ctx, st)
+					}
+					err := qd.handle(seriesCtx, ds, globalFilters, drb, []*util.DataSeriesRequest{seriesReq})
+					if st != nil {
+						st.Finish()
+						statsMu.Lock()
+						rt.series = append(rt.series, st)
+						statsMu.Unlock()
+					}
+					if err != nil {
+						return err
+					}
+					if !account {
+						continue
+					}
+					s := seriesStats(seriesReq, start)
+					statsMu.Lock()
+					stats = append(stats, s)
+					statsMu.Unlock()
+				}
+				return nil
 			})
 		}(qd.dataSources[dsIdx], seriesReqs)
 	}
 	if err := errg.Wait(); err != nil {
 		return nil, err
 	}
-	return drb.Data()
+	if rt != nil {
+		rt.buildStart = time.Now()
+	}
+	data, err := drb.Data()
+	if err != nil || !account {
+		return data, err
+	}
+	completeSeriesStats(data, stats)
+	if qd.accountant != nil {
+		qd.accountant.record(globalFilters, stats)
+	}
+	if debugStats {
+		data.SeriesStats = stats
+	}
+	return data, nil
 }
@@ -16,11 +16,15 @@ package querydispatcher
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/util"
 )
 
@@ -96,12 +100,38 @@ func TestQueryDispatcherCreation(t *testing.T) {
 			newTestDataSource(queries[0]),
 		},
 		wantErr: true,
+	}, {
+		description: "versioned query conflict",
+		dataSources: []dataSource{
+			newTestDataSource([]string{"logs.raw_entries@v1"}),
+			newTestDataSource([]string{"logs.raw_entries"}),
+		},
+		wantErr: true,
+	}, {
+		description: "multiple versions across data sources",
+		dataSources: []dataSource{
+			newTestDataSource([]string{"logs.raw_entries"}),
+			newTestDataSource([]string{"logs.raw_entries@v2"}),
+		},
 	}} {
 		t.Run(test.description, func(t *testing.T) {
 			_, err := New(test.dataSources...)
 			if test.wantErr != (err != nil) {
 				t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
 			}
+			// Adding the data sources one at a time should behave the same.
+			qd, err := New()
+			if err != nil {
+				t.Fatalf("Unexpected error creating empty QueryDispatcher: %s", err)
+			}
+			for _, ds := range test.dataSources {
+				if _, err = qd.WithDataSource(ds); err != nil {
+					break
+				}
+			}
+			if test.wantErr != (err != nil) {
+				t.Fatalf("Unexpected error adding data sources to QueryDispatcher: %s", err)
+			}
 		})
 	}
 }
@@ -281,3 +311,778 @@ func TestHandleDataRequest(t *testing.T) {
 		})
 	}
 }
+
+// authorizingTestDataSource is a testDataSource only handling requests from
+// the principal 'alice'.
+type authorizingTestDataSource struct {
+	*testDataSource
+}
+
+func (atds *authorizingTestDataSource) AuthorizeDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, reqs []*util.DataSeriesRequest) error {
+	if p := util.PrincipalFrom(ctx); p == nil || p.Name != "alice" {
+		return errors.New("only alice may query")
+	}
+	return nil
+}
+
+func TestAuthorizeDataSeriesRequests(t *testing.T) {
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll1"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{
+			&util.DataSeriesRequest{
+				QueryName:  "ThreadIntervals",
+				SeriesName: "1",
+			},
+		},
+	}
+	for _, test := range []struct {
+		description string
+		principal   *util.Principal
+		wantErr     bool
+	}{{
+		description: "no principal",
+		wantErr:     true,
+	}, {
+		description: "disallowed principal",
+		principal:   &util.Principal{Name: "bob"},
+		wantErr:     true,
+	}, {
+		description: "allowed principal",
+		principal:   &util.Principal{Name: "alice"},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			tds := newTestDataSource(queries[0])
+			qd, err := New(&authorizingTestDataSource{tds})
+			if err != nil {
+				t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+			}
+			ctx := context.Background()
+			if test.principal != nil {
+				ctx = util.WithPrincipal(ctx, test.principal)
+			}
+			if _, err := qd.HandleDataRequest(ctx, req); test.wantErr != (err != nil) {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %v", err)
+			}
+			wantHandled := 1
+			if test.wantErr {
+				wantHandled = 0
+			}
+			if got := tds.handledQueries["ThreadIntervals"]; got != wantHandled {
+				t.Errorf("HandleDataRequest() handled %d queries, want %d", got, wantHandled)
+			}
+		})
+	}
+}
+
+func TestParseQueryName(t *testing.T) {
+	for _, test := range []struct {
+		queryName string
+		want      QueryName
+		wantStr   string
+		wantErr   bool
+	}{{
+		queryName: "ThreadIntervals",
+		want:      QueryName{Name: "ThreadIntervals"},
+		wantStr:   "ThreadIntervals",
+	}, {
+		queryName: "logs.raw_entries",
+		want:      QueryName{Namespace: "logs", Name: "raw_entries"},
+		wantStr:   "logs.raw_entries",
+	}, {
+		queryName: "logs.raw_entries@v1",
+		want:      QueryName{Namespace: "logs", Name: "raw_entries", Version: 1},
+		wantStr:   "logs.raw_entries",
+	}, {
+		queryName: "com.example.logs.raw_entries@v12",
+		want:      QueryName{Namespace: "com.example.logs", Name: "raw_entries", Version: 12},
+		wantStr:   "com.example.logs.raw_entries@v12",
+	}, {
+		queryName: "logs.raw_entries@v0",
+		wantErr:   true,
+	}, {
+		queryName: "logs.raw_entries@vtwo",
+		want:      QueryName{Namespace: "logs", Name: "raw_entries@vtwo"},
+		wantStr:   "logs.raw_entries@vtwo",
+	}, {
+		queryName: "logs.raw_entries@v2x",
+		want:      QueryName{Namespace: "logs", Name: "raw_entries@v2x"},
+		wantStr:   "logs.raw_entries@v2x",
+	}, {
+		queryName: "logs.users@vip@v3",
+		want:      QueryName{Namespace: "logs", Name: "users@vip", Version: 3},
+		wantStr:   "logs.users@vip@v3",
+	}, {
+		queryName: "logs.raw_entries@v",
+		want:      QueryName{Namespace: "logs", Name: "raw_entries@v"},
+		wantStr:   "logs.raw_entries@v",
+	}, {
+		queryName: "logs.raw_entries@v99999999999999999999",
+		wantErr:   true,
+	}, {
+		queryName: "logs.@v2",
+		wantErr:   true,
+	}} {
+		t.Run(test.queryName, func(t *testing.T) {
+			got, err := ParseQueryName(test.queryName)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("ParseQueryName() yielded unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ParseQueryName() = %v, diff (-want +got):\n%s", got, diff)
+			}
+			if gotStr := got.String(); gotStr != test.wantStr {
+				t.Errorf("String() = %s, want %s", gotStr, test.wantStr)
+			}
+		})
+	}
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	newDataSources := func() []dataSource {
+		return []dataSource{
+			newTestDataSource([]string{"logs.raw_entries", "logs.raw_entries@v3"}),
+			newTestDataSource([]string{"logs.timeseries@v2"}),
+		}
+	}
+	for _, test := range []struct {
+		description       string
+		queryName         string
+		wantErr           bool
+		wantDataSourceIdx int
+		wantHandledQuery  string
+	}{{
+		description:      "unversioned request selects latest version",
+		queryName:        "logs.raw_entries",
+		wantHandledQuery: "logs.raw_entries@v3",
+	}, {
+		description:      "version 1",
+		queryName:        "logs.raw_entries@v1",
+		wantHandledQuery: "logs.raw_entries",
+	}, {
+		description:      "exact version",
+		queryName:        "logs.raw_entries@v3",
+		wantHandledQuery: "logs.raw_entries@v3",
+	}, {
+		description:      "newer client falls back to highest supported version",
+		queryName:        "logs.raw_entries@v5",
+		wantHandledQuery: "logs.raw_entries@v3",
+	}, {
+		description:      "intermediate version falls back",
+		queryName:        "logs.raw_entries@v2",
+		wantHandledQuery: "logs.raw_entries",
+	}, {
+		description:       "other data source",
+		queryName:         "logs.timeseries@v4",
+		wantDataSourceIdx: 1,
+		wantHandledQuery:  "logs.timeseries@v2",
+	}, {
+		description:       "unversioned request for versioned-only query",
+		queryName:         "logs.timeseries",
+		wantDataSourceIdx: 1,
+		wantHandledQuery:  "logs.timeseries@v2",
+	}, {
+		description: "older client than any supported version",
+		queryName:   "logs.timeseries@v1",
+		wantErr:     true,
+	}, {
+		description: "unknown query",
+		queryName:   "logs.magic@v2",
+		wantErr:     true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			dss := newDataSources()
+			qd, err := New(dss...)
+			if err != nil {
+				t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+			}
+			if got, ok := qd.ResolveQueryName(test.queryName); ok == test.wantErr || got != test.wantHandledQuery {
+				t.Errorf("ResolveQueryName('%s') = '%s', %t, want '%s', %t", test.queryName, got, ok, test.wantHandledQuery, !test.wantErr)
+			}
+			req := &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					collectionNameKey: util.StringValue("coll1"),
+				},
+				SeriesRequests: []*util.DataSeriesRequest{
+					&util.DataSeriesRequest{
+						QueryName:  test.queryName,
+						SeriesName: "1",
+					},
+				},
+			}
+			gotData, err := qd.HandleDataRequest(context.Background(), req)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if len(gotData.DataSeries) != 1 || gotData.DataSeries[0].SeriesName != "1" {
+				t.Errorf("HandleDataRequest() = %s, want a single series '1'", gotData.PrettyPrint())
+			}
+			tds := dss[test.wantDataSourceIdx].(*testDataSource)
+			if got := tds.handledQueries[test.wantHandledQuery]; got != 1 {
+				t.Errorf("Data source %d handled query '%s' %d times, want 1", test.wantDataSourceIdx, test.wantHandledQuery, got)
+			}
+			if req.SeriesRequests[0].QueryName != test.queryName {
+				t.Errorf("HandleDataRequest() modified the requested query name to '%s'", req.SeriesRequests[0].QueryName)
+			}
+		})
+	}
+}
+
+func TestSchema(t *testing.T) {
+	qd, err := New(
+		newTestDataSource([]string{"logs.raw_entries@v3", "logs.raw_entries"}),
+		newTestDataSource([]string{"ThreadIntervals"}),
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	want := &Schema{
+		Queries: []*QuerySchema{{
+			Name:     "ThreadIntervals",
+			Versions: []int{1},
+		}, {
+			Name:     "logs.raw_entries",
+			Versions: []int{1, 3},
+		}},
+	}
+	if diff := cmp.Diff(want, qd.Schema()); diff != "" {
+		t.Errorf("Schema() diff (-want +got):\n%s", diff)
+	}
+}
+
+// filterRecordingDataSource is a testDataSource recording the global filters
+// with which it was invoked.
+type filterRecordingDataSource struct {
+	*testDataSource
+	gotGlobalFilters map[string]*util.V
+}
+
+func (frds *filterRecordingDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	frds.gotGlobalFilters = globalState
+	return frds.testDataSource.HandleDataSeriesRequests(ctx, globalState, drb, reqs)
+}
+
+func TestGlobalFilterMiddleware(t *testing.T) {
+	start := time.Unix(1000, 0)
+	for _, test := range []struct {
+		description       string
+		middleware        []GlobalFilterMiddleware
+		globalFilters     map[string]*util.V
+		wantErr           bool
+		wantGlobalFilters map[string]*util.V
+	}{{
+		description: "no middleware",
+		globalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll1"),
+		},
+		wantGlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll1"),
+		},
+	}, {
+		description: "missing required filter",
+		middleware: []GlobalFilterMiddleware{
+			RequireGlobalFilters(collectionNameKey),
+		},
+		globalFilters: map[string]*util.V{},
+		wantErr:       true,
+	}, {
+		description: "defaults applied before requirement",
+		middleware: []GlobalFilterMiddleware{
+			DefaultGlobalFilters(map[string]*util.V{
+				collectionNameKey: util.StringValue("default"),
+				"verbose":         util.IntValue(1),
+			}),
+			RequireGlobalFilters(collectionNameKey),
+		},
+		globalFilters: map[string]*util.V{
+			"verbose": util.IntValue(0),
+		},
+		wantGlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("default"),
+			"verbose":         util.IntValue(0),
+		},
+	}, {
+		description: "time range clamped",
+		middleware: []GlobalFilterMiddleware{
+			ClampTimeRange("start", "end", time.Hour),
+		},
+		globalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll1"),
+			"start":           util.TimestampValue(start),
+			"end":             util.TimestampValue(start.Add(2 * time.Hour)),
+		},
+		wantGlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll1"),
+			"start":           util.TimestampValue(start),
+			"end":             util.TimestampValue(start.Add(time.Hour)),
+		},
+	}, {
+		description: "inverted time range",
+		middleware: []GlobalFilterMiddleware{
+			ClampTimeRange("start", "end", time.Hour),
+		},
+		globalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll1"),
+			"start":           util.TimestampValue(start),
+			"end":             util.TimestampValue(start.Add(-time.Hour)),
+		},
+		wantErr: true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			ds := &filterRecordingDataSource{testDataSource: newTestDataSource(queries[0])}
+			qd, err := New(ds)
+			if err != nil {
+				t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+			}
+			qd.UseGlobalFilterMiddleware(test.middleware...)
+			origFilterCount := len(test.globalFilters)
+			gotData, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+				GlobalFilters: test.globalFilters,
+				SeriesRequests: []*util.DataSeriesRequest{
+					&util.DataSeriesRequest{
+						QueryName:  "ThreadIntervals",
+						SeriesName: "1",
+					},
+				},
+			})
+			if test.wantErr != (err != nil) {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(test.wantGlobalFilters, ds.gotGlobalFilters); diff != "" {
+				t.Errorf("Data source got global filters diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantGlobalFilters, gotData.GlobalFilters); diff != "" {
+				t.Errorf("HandleDataRequest() returned global filters diff (-want +got):\n%s", diff)
+			}
+			if len(test.globalFilters) != origFilterCount {
+				t.Errorf("HandleDataRequest() modified the request's global filters")
+			}
+		})
+	}
+}
+
+type warmingTestDataSource struct {
+	*testDataSource
+	// Closed to let warmups finish.
+	release   chan struct{}
+	mu        sync.Mutex
+	gotHints  []map[string]*util.V
+	failCount int
+}
+
+func (wtds *warmingTestDataSource) Warmup(ctx context.Context, hints map[string]*util.V) error {
+	wtds.mu.Lock()
+	wtds.gotHints = append(wtds.gotHints, hints)
+	fail := wtds.failCount > 0
+	if fail {
+		wtds.failCount--
+	}
+	wtds.mu.Unlock()
+	util.ReportProgress(ctx, .5)
+	<-wtds.release
+	if fail {
+		return errors.New("warmup failed")
+	}
+	return nil
+}
+
+func TestWarmup(t *testing.T) {
+	hints := map[string]*util.V{
+		collectionNameKey: util.StringValue("coll1"),
+	}
+	wtds := &warmingTestDataSource{
+		testDataSource: newTestDataSource(queries[0]),
+		release:        make(chan struct{}),
+		failCount:      1,
+	}
+	// The second dataSource doesn't support warmup, so doesn't affect progress.
+	qd, err := New(wtds, newTestDataSource(queries[1]))
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	waitFor := func(want *WarmupStatus) {
+		t.Helper()
+		var got *WarmupStatus
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if got, err = qd.StartWarmup(context.Background(), hints); err != nil {
+				t.Fatalf("StartWarmup() yielded unexpected error %s", err)
+			}
+			if cmp.Equal(want, got) {
+				return
+			}
+		}
+		t.Fatalf("StartWarmup() yielded status %v, want %v", got, want)
+	}
+	waitFor(&WarmupStatus{Progress: .5})
+	close(wtds.release)
+	waitFor(&WarmupStatus{Progress: .5, Done: true, Err: "warmup failed"})
+	// The failed warmup is retried, and its retry succeeds.
+	waitFor(&WarmupStatus{Progress: 1, Done: true})
+	// Successful warmups aren't repeated.
+	waitFor(&WarmupStatus{Progress: 1, Done: true})
+	if len(wtds.gotHints) != 2 {
+		t.Errorf("Warmup was invoked %d times, want 2", len(wtds.gotHints))
+	}
+	if diff := cmp.Diff(hints, wtds.gotHints[0]); diff != "" {
+		t.Errorf("Warmup got unexpected hints (-want +got):\n%s", diff)
+	}
+	// Once expired, finished warmups are forgotten, and are repeated.
+	qd.warmupMu.Lock()
+	for _, w := range qd.warmups {
+		w.mu.Lock()
+		w.finished = w.finished.Add(-2 * warmupRetention)
+		w.mu.Unlock()
+	}
+	qd.warmupMu.Unlock()
+	waitFor(&WarmupStatus{Progress: 1, Done: true})
+	if len(wtds.gotHints) != 3 {
+		t.Errorf("Warmup was invoked %d times after expiry, want 3", len(wtds.gotHints))
+	}
+	if err := qd.Warmup(context.Background(), hints); err != nil {
+		t.Errorf("Warmup() yielded unexpected error %s", err)
+	}
+	// Only maxWarmups warmups are tracked at once.
+	qd.warmupMu.Lock()
+	for idx := len(qd.warmups); idx < maxWarmups; idx++ {
+		qd.warmups[fmt.Sprintf("running%d", idx)] = &warmup{}
+	}
+	qd.warmupMu.Unlock()
+	if _, err := qd.StartWarmup(context.Background(), map[string]*util.V{
+		collectionNameKey: util.StringValue("coll2"),
+	}); err == nil {
+		t.Errorf("StartWarmup() beyond maxWarmups yielded no error")
+	}
+}
+
+// watchingTestDataSource is a testDataSource whose collections may be changed
+// by closing their channels.
+type watchingTestDataSource struct {
+	*testDataSource
+	changed map[string]chan struct{}
+}
+
+func (wtds *watchingTestDataSource) Changed(ctx context.Context, globalFilters map[string]*util.V) (<-chan struct{}, error) {
+	collectionName, err := util.ExpectStringValue(globalFilters[collectionNameKey])
+	if err != nil {
+		return nil, err
+	}
+	ch, ok := wtds.changed[collectionName]
+	if !ok {
+		return nil, nil
+	}
+	return ch, nil
+}
+
+func TestWaitForChange(t *testing.T) {
+	wtds := &watchingTestDataSource{
+		testDataSource: newTestDataSource(queries[0]),
+		changed: map[string]chan struct{}{
+			"coll1": make(chan struct{}),
+		},
+	}
+	qd, err := New(wtds, newTestDataSource(queries[1]))
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	filters := func(collectionName string) map[string]*util.V {
+		return map[string]*util.V{
+			collectionNameKey: util.StringValue(collectionName),
+		}
+	}
+	if err := qd.WaitForChange(context.Background(), filters("coll2")); err == nil {
+		t.Errorf("WaitForChange() of an unwatched collection yielded no error")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := qd.WaitForChange(ctx, filters("coll1")); err != context.DeadlineExceeded {
+		t.Errorf("WaitForChange() of an unchanged collection yielded %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(wtds.changed["coll1"])
+	if err := qd.WaitForChange(context.Background(), filters("coll1")); err != nil {
+		t.Errorf("WaitForChange() of a changed collection yielded unexpected error %s", err)
+	}
+}
+
+// lifecycleTestDataSource is a testDataSource recording collection lifecycle
+// events.
+type lifecycleTestDataSource struct {
+	*testDataSource
+	events []string
+}
+
+func (ltds *lifecycleTestDataSource) OnCollectionLoaded(collectionName string, collection any) {
+	ltds.events = append(ltds.events, "loaded "+collectionName+" "+collection.(string))
+}
+
+func (ltds *lifecycleTestDataSource) OnCollectionEvicted(collectionName string) {
+	ltds.events = append(ltds.events, "evicted "+collectionName)
+}
+
+func TestCollectionLifecycle(t *testing.T) {
+	ltds := &lifecycleTestDataSource{
+		testDataSource: newTestDataSource(queries[0]),
+	}
+	qd, err := New(ltds, newTestDataSource(queries[1]))
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	qd.CollectionLoaded("coll1", "contents1")
+	qd.CollectionLoaded("coll2", "contents2")
+	qd.CollectionEvicted("coll1")
+	want := []string{
+		"loaded coll1 contents1",
+		"loaded coll2 contents2",
+		"evicted coll1",
+	}
+	if diff := cmp.Diff(want, ltds.events); diff != "" {
+		t.Errorf("Got lifecycle events diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestResourceAccounting(t *testing.T) {
+	qd, err := New(newTestDataSource(queries[0]), newTestDataSource(queries[1]))
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	if report := qd.ResourceReport(); report != nil {
+		t.Errorf("ResourceReport() without accounting = %v, want nil", report)
+	}
+	qd.WithResourceAccounting(collectionNameKey)
+	req := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "ThreadIntervals",
+			SeriesName: "1",
+		}, {
+			QueryName:  "CPUIntervals",
+			SeriesName: "2",
+		}, {
+			QueryName:  "RPCIntervals",
+			SeriesName: "3",
+		}},
+	}
+	data, err := qd.HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if data.SeriesStats != nil {
+		t.Errorf("HandleDataRequest() without %s yielded stats", DebugResourceStatsKey)
+	}
+	req.GlobalFilters[DebugResourceStatsKey] = util.IntegerValue(1)
+	data, err = qd.HandleDataRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	var gotSeries []string
+	for _, stats := range data.SeriesStats {
+		gotSeries = append(gotSeries, stats.SeriesName+":"+stats.QueryName)
+		if stats.Datums != 1 || stats.ResponseBytes == 0 {
+			t.Errorf("series %s has %d datums in %d bytes, want 1 datum in a nonzero number of bytes", stats.SeriesName, stats.Datums, stats.ResponseBytes)
+		}
+	}
+	if diff := cmp.Diff([]string{"1:ThreadIntervals", "2:CPUIntervals", "3:RPCIntervals"}, gotSeries); diff != "" {
+		t.Errorf("SeriesStats diff (-want +got):\n%s", diff)
+	}
+	report := qd.ResourceReport()
+	if got, want := report.ByQuery["RPCIntervals"].Series, int64(2); got != want {
+		t.Errorf("ByQuery[RPCIntervals].Series = %d, want %d", got, want)
+	}
+	if got, want := report.ByCollection["coll"].Datums, int64(6); got != want {
+		t.Errorf("ByCollection[coll].Datums = %d, want %d", got, want)
+	}
+	if got, want := len(report.Recent), 6; got != want {
+		t.Errorf("len(Recent) = %d, want %d", got, want)
+	}
+}
+
+// panickingTestDataSource is a testDataSource that panics when handling the
+// query 'CPUIntervals'.
+type panickingTestDataSource struct {
+	*testDataSource
+}
+
+func (ptds *panickingTestDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		drb.DataSeries(req).With(util.StringProperty("query", req.QueryName))
+		if req.QueryName == "CPUIntervals" {
+			var m map[string]int
+			m["boom"]++
+		}
+	}
+	return nil
+}
+
+func TestPanicRecovery(t *testing.T) {
+	qd, err := New(&panickingTestDataSource{newTestDataSource(queries[0])}, newTestDataSource(queries[1]))
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	var panicked []string
+	qd.OnPanic(func(ctx context.Context, req *util.DataSeriesRequest, pe *util.PanicError) {
+		if len(pe.Stack) == 0 {
+			t.Errorf("PanicError has no stack")
+		}
+		panicked = append(panicked, req.SeriesName)
+	})
+	data, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll"),
+		},
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  "ThreadIntervals",
+			SeriesName: "1",
+		}, {
+			QueryName:  "CPUIntervals",
+			SeriesName: "2",
+		}, {
+			QueryName:  "RPCIntervals",
+			SeriesName: "3",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	got := map[string]string{}
+	for _, series := range data.DataSeries {
+		got[series.SeriesName] = series.PrettyPrint("", data.StringTable)
+	}
+	want := map[string]string{
+		"1": "Series 1\n  Root:\n    Prop 'query': 'ThreadIntervals'",
+		"2": "Series 2 failed: panic: assignment to entry in nil map",
+		"3": "Series 3\n  Root:\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("HandleDataRequest() diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"2"}, panicked); diff != "" {
+		t.Errorf("Panicked series diff (-want +got):\n%s", diff)
+	}
+	if got, want := qd.Panics(), uint64(1); got != want {
+		t.Errorf("Panics() = %d, want %d", got, want)
+	}
+}
+
+// phasedTestDataSource is a testDataSource that reports the phases of its
+// handling.
+type phasedTestDataSource struct {
+	*testDataSource
+}
+
+func (ptds *phasedTestDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	util.BeginPhase(ctx, "fetch")
+	util.BeginPhase(ctx, "aggregate")
+	return ptds.testDataSource.HandleDataSeriesRequests(ctx, globalState, drb, reqs)
+}
+
+func TestSelfTrace(t *testing.T) {
+	qd, err := New(&phasedTestDataSource{newTestDataSource(queries[0])})
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	if _, err := qd.WithSelfTrace(2); err != nil {
+		t.Fatalf("WithSelfTrace() yielded unexpected error %s", err)
+	}
+	if _, err := qd.WithSelfTrace(2); err == nil {
+		t.Errorf("WithSelfTrace() on a self-tracing QueryDispatcher yielded no error")
+	}
+	globalFilters := map[string]*util.V{
+		collectionNameKey: util.StringValue("coll"),
+	}
+	for _, seriesNames := range [][]string{{"1"}, {"2", "3"}, {"4"}} {
+		req := &util.DataRequest{
+			GlobalFilters: globalFilters,
+		}
+		for _, seriesName := range seriesNames {
+			req.SeriesRequests = append(req.SeriesRequests, &util.DataSeriesRequest{
+				QueryName:  "ThreadIntervals",
+				SeriesName: seriesName,
+			})
+		}
+		if _, err := qd.HandleDataRequest(context.Background(), req); err != nil {
+			t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+		}
+	}
+	data, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+		GlobalFilters: globalFilters,
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  SelfTraceQuery,
+			SeriesName: "self",
+			Options: map[string]*util.V{
+				selfTraceRequestCountKey: util.IntegerValue(1),
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("HandleDataRequest() for the self-trace yielded unexpected error %s", err)
+	}
+	if len(data.DataSeries) != 1 {
+		t.Errorf("HandleDataRequest() for the self-trace yielded %d series, want 1", len(data.DataSeries))
+	}
+	// Only the last two requests, and not the self-trace request, are
+	// retained.
+	var got []string
+	for _, rt := range qd.selfTracer.recent(0) {
+		if rt.buildStart.IsZero() || rt.end.Before(rt.buildStart) {
+			t.Errorf("request %d has build phase from %v to %v", rt.seq, rt.buildStart, rt.end)
+		}
+		for _, st := range rt.series {
+			var phases []string
+			for _, phase := range st.Phases() {
+				phases = append(phases, phase.Name)
+				if phase.Start.Before(st.Start) || phase.End.After(st.End) {
+					t.Errorf("series %s phase %s lies outside its series", st.SeriesName, phase.Name)
+				}
+			}
+			got = append(got, fmt.Sprintf("%d:%s:%s", rt.seq, st.SeriesName, strings.Join(phases, ",")))
+		}
+	}
+	sort.Strings(got)
+	want := []string{"2:2:fetch,aggregate", "2:3:fetch,aggregate", "3:4:fetch,aggregate"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Self-traced requests diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestKeysQuery(t *testing.T) {
+	qd, err := New(newTestDataSource(queries[0]))
+	if err != nil {
+		t.Fatalf("Unexpected error creating QueryDispatcher: %s", err)
+	}
+	if _, err := qd.WithKeysQuery(); err != nil {
+		t.Fatalf("WithKeysQuery() yielded unexpected error %s", err)
+	}
+	if _, err := qd.WithKeysQuery(); err == nil {
+		t.Errorf("WithKeysQuery() on a QueryDispatcher already serving it yielded no error")
+	}
+	data, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+		SeriesRequests: []*util.DataSeriesRequest{{
+			QueryName:  KeysQuery,
+			SeriesName: "keys",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("HandleDataRequest() for the keys query yielded unexpected error %s", err)
+	}
+	if len(data.DataSeries) != 1 {
+		t.Fatalf("HandleDataRequest() for the keys query yielded %d series, want 1", len(data.DataSeries))
+	}
+	// The table has a column definition child, then one row per key.
+	if got, want := len(data.DataSeries[0].Root.Children)-1, len(keys.Registered()); got != want {
+		t.Errorf("Keys query yielded %d rows, want %d", got, want)
+	}
+	if pp := data.PrettyPrint(); !strings.Contains(pp, keys.TraceStart) {
+		t.Errorf("Keys query response %s doesn't list %q", pp, keys.TraceStart)
+	}
+}
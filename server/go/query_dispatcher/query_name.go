@@ -0,0 +1,104 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// QueryName is a parsed data series query name.  By convention, query names
+// have the form
+//
+//	<namespace>.<name>[@v<version>]
+//
+// for example 'logs.raw_entries@v2'.  The namespace, which should be unique to
+// a dataSource, may itself contain dots; the name may not.  Only a trailing
+// '@v' followed by digits is a version suffix; any other '@v' is part of the
+// name.  A query name with no version suffix is unversioned, with Version 0.
+// A dataSource's unversioned query is version 1 of that query, while an
+// unversioned request is for the latest supported version.
+type QueryName struct {
+	Namespace string
+	Name      string
+	Version   int
+}
+
+const versionSeparator = "@v"
+
+// NewQueryName returns a query name string in the conventional form for the
+// provided namespace, name, and version.
+func NewQueryName(namespace, name string, version int) string {
+	return QueryName{
+		Namespace: namespace,
+		Name:      name,
+		Version:   version,
+	}.String()
+}
+
+// ParseQueryName parses the provided query name string.  Query names lacking
+// a namespace are accepted, and yield an empty Namespace.
+func ParseQueryName(queryName string) (QueryName, error) {
+	ret := QueryName{}
+	base := queryName
+	if idx := strings.LastIndex(queryName, versionSeparator); idx >= 0 && isDigits(queryName[idx+len(versionSeparator):]) {
+		base = queryName[:idx]
+		version, err := strconv.Atoi(queryName[idx+len(versionSeparator):])
+		if err != nil || version < 1 {
+			return QueryName{}, fmt.Errorf("query name `%s` has malformed version", queryName)
+		}
+		ret.Version = version
+	}
+	if idx := strings.LastIndex(base, "."); idx >= 0 {
+		ret.Namespace, ret.Name = base[:idx], base[idx+1:]
+	} else {
+		ret.Name = base
+	}
+	if ret.Name == "" {
+		return QueryName{}, fmt.Errorf("query name `%s` has empty name", queryName)
+	}
+	return ret, nil
+}
+
+// isDigits returns true if the provided string is nonempty and consists only
+// of decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Base returns the receiver's namespace and name, without its version.
+func (qn QueryName) Base() string {
+	if qn.Namespace == "" {
+		return qn.Name
+	}
+	return qn.Namespace + "." + qn.Name
+}
+
+// String returns the receiver in conventional form.  Versions 0 and 1 are
+// elided.
+func (qn QueryName) String() string {
+	if qn.Version <= 1 {
+		return qn.Base()
+	}
+	return fmt.Sprintf("%s%s%d", qn.Base(), versionSeparator, qn.Version)
+}
@@ -0,0 +1,87 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// PanicHandler is notified of each panic recovered while handling a
+// DataSeriesRequest.
+type PanicHandler func(ctx context.Context, req *util.DataSeriesRequest, pe *util.PanicError)
+
+// LogPanics is a PanicHandler logging each panic, with its stack, to the
+// standard logger.
+func LogPanics(ctx context.Context, req *util.DataSeriesRequest, pe *util.PanicError) {
+	log.Printf("Recovered from panic handling series '%s' (query `%s`): %v\n%s", req.SeriesName, req.QueryName, pe.Value, pe.Stack)
+}
+
+// OnPanic specifies the PanicHandler notified of recovered panics, replacing
+// the default, LogPanics, and returns the receiver.
+func (qd *QueryDispatcher) OnPanic(handler PanicHandler) *QueryDispatcher {
+	qd.panicHandler = handler
+	return qd
+}
+
+// Panics returns the number of panics the receiver has recovered from.
+func (qd *QueryDispatcher) Panics() uint64 {
+	return qd.panics.Load()
+}
+
+// handleRecovering passes the provided DataSeriesRequests to the provided
+// dataSource, returning a util.PanicError if it panics.
+func handleRecovering(ctx context.Context, ds dataSource, globalFilters map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = util.Recovered(r)
+		}
+	}()
+	return ds.HandleDataSeriesRequests(ctx, globalFilters, drb, reqs)
+}
+
+// handle passes the provided DataSeriesRequests to the provided dataSource.
+// If the dataSource panics, the panic is recovered, any series it produced are
+// discarded, and each DataSeriesRequest is retried separately.  Those that
+// panic again fail individually, with their errors reported in their
+// DataSeries, without affecting other series.
+func (qd *QueryDispatcher) handle(ctx context.Context, ds dataSource, globalFilters map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	err := handleRecovering(ctx, ds, globalFilters, drb, reqs)
+	var pe *util.PanicError
+	if !errors.As(err, &pe) {
+		return err
+	}
+	if len(reqs) == 1 {
+		qd.recordPanic(ctx, reqs[0], pe)
+		drb.FailDataSeries(reqs[0], pe)
+		return nil
+	}
+	drb.RemoveDataSeries(reqs...)
+	for _, req := range reqs {
+		if err := qd.handle(ctx, ds, globalFilters, drb, []*util.DataSeriesRequest{req}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qd *QueryDispatcher) recordPanic(ctx context.Context, req *util.DataSeriesRequest, pe *util.PanicError) {
+	qd.panics.Add(1)
+	if qd.panicHandler != nil {
+		qd.panicHandler(ctx, req, pe)
+	}
+}
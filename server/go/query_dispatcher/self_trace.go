@@ -0,0 +1,207 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// SelfTraceQuery is the built-in query, supported by QueryDispatchers with
+// self-tracing enabled, rendering the receiver's most recently handled
+// DataRequests as a trace.  Each DataRequest has its own Category, holding a
+// span for the request, with a 'build' subspan for assembling its response.
+// Beneath that span is a child span for each DataSeriesRequest, with a
+// subspan for each phase its dataSource reported via util.BeginPhase.  The
+// 'request_count' option limits the trace to that many of the most recent
+// DataRequests.
+const SelfTraceQuery = "traceviz.self_trace"
+
+const (
+	// Options
+	selfTraceRequestCountKey = "request_count"
+
+	// Properties
+	selfTraceSeriesNameKey = "series_name"
+	selfTraceQueryNameKey  = "query_name"
+	selfTracePhaseKey      = "phase"
+	selfTraceDurationKey   = "duration"
+)
+
+var selfTraceSchema = []*util.OptionSpec{{
+	Key:    selfTraceRequestCountKey,
+	Source: util.FromSeries,
+	Type:   util.IntegerValueType,
+}}
+
+var selfTraceRenderSettings = &trace.RenderSettings{
+	SpanWidthCatPx:   16,
+	SpanPaddingCatPx: 1,
+	CategoryAxisRenderSettings: &categoryaxis.RenderSettings{
+		CategoryHeaderCatPx:    16,
+		CategoryHandleValPx:    8,
+		CategoryPaddingCatPx:   4,
+		CategoryMarginValPx:    8,
+		CategoryMinWidthCatPx:  16,
+		CategoryBaseWidthValPx: 200,
+	},
+}
+
+// requestTrace records the handling of a single DataRequest.
+type requestTrace struct {
+	seq        int64
+	start, end time.Time
+	// When the DataResponse began to be built from its DataSeries.  Zero if
+	// it never was, e.g. because handling failed.
+	buildStart time.Time
+	series     []*util.SeriesTrace
+}
+
+// selfTracer retains traces of the most recently handled DataRequests, and
+// serves SelfTraceQuery.
+type selfTracer struct {
+	capacity int
+	mu       sync.Mutex
+	seq      int64
+	requests []*requestTrace
+}
+
+// WithSelfTrace specifies that the receiver should trace its own handling of
+// the specified number of most recent DataRequests, and serve those traces as
+// the built-in SelfTraceQuery, and returns the receiver.  Returns an error if
+// another dataSource already supports SelfTraceQuery.
+func (qd *QueryDispatcher) WithSelfTrace(capacity int) (*QueryDispatcher, error) {
+	st := &selfTracer{
+		capacity: capacity,
+	}
+	if err := qd.addDataSource(st); err != nil {
+		return nil, err
+	}
+	qd.selfTracer = st
+	return qd, nil
+}
+
+// begin returns a new requestTrace for the provided DataRequest, or nil if
+// the receiver is nil, or the DataRequest only queries SelfTraceQuery, so
+// that inspecting self-traces doesn't crowd them out.
+func (st *selfTracer) begin(req *util.DataRequest) *requestTrace {
+	if st == nil {
+		return nil
+	}
+	for _, seriesReq := range req.SeriesRequests {
+		if qn, err := ParseQueryName(seriesReq.QueryName); err != nil || qn.Base() != SelfTraceQuery {
+			return &requestTrace{
+				start: time.Now(),
+			}
+		}
+	}
+	return nil
+}
+
+// record ends the provided requestTrace now, and retains it, evicting the
+// oldest requestTrace if the receiver is at capacity.
+func (st *selfTracer) record(rt *requestTrace) {
+	rt.end = time.Now()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.seq++
+	rt.seq = st.seq
+	st.requests = append(st.requests, rt)
+	if len(st.requests) > st.capacity {
+		st.requests = append([]*requestTrace{}, st.requests[len(st.requests)-st.capacity:]...)
+	}
+}
+
+// recent returns up to the specified number of the receiver's most recent
+// requestTraces, oldest first.  If count is not positive, all are returned.
+func (st *selfTracer) recent(count int) []*requestTrace {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ret := st.requests
+	if count > 0 && len(ret) > count {
+		ret = ret[len(ret)-count:]
+	}
+	return append([]*requestTrace{}, ret...)
+}
+
+func (st *selfTracer) SupportedDataSeriesQueries() []string {
+	return []string{SelfTraceQuery}
+}
+
+func (st *selfTracer) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		opts, err := util.ResolveOptions(nil, req.Options, selfTraceSchema)
+		if err != nil {
+			return err
+		}
+		var count int64
+		if opts.Has(selfTraceRequestCountKey) {
+			if count, err = opts.Integer(selfTraceRequestCountKey); err != nil {
+				return err
+			}
+		}
+		emitSelfTrace(drb.DataSeries(req), st.recent(int(count)))
+	}
+	return nil
+}
+
+// emitSelfTrace emits the provided requestTraces as a trace populating the
+// provided DataBuilder.
+func emitSelfTrace(db util.DataBuilder, requests []*requestTrace) {
+	var extents []time.Time
+	for _, rt := range requests {
+		extents = append(extents, rt.start, rt.end)
+	}
+	if len(extents) == 0 {
+		now := time.Now()
+		extents = []time.Time{now, now}
+	}
+	t := trace.New(db, continuousaxis.NewTimestampAxis(
+		category.New("x_axis", "Time", "Time at which requests were handled"),
+		extents...), selfTraceRenderSettings)
+	for _, rt := range requests {
+		name := fmt.Sprintf("Request %d", rt.seq)
+		span := t.Category(category.New(fmt.Sprintf("request_%d", rt.seq), name, name+", handled at "+rt.start.Format(time.RFC3339Nano))).
+			Span(rt.start, rt.end, util.DurationProperty(selfTraceDurationKey, rt.end.Sub(rt.start)))
+		if !rt.buildStart.IsZero() {
+			span.Subspan(rt.buildStart, rt.end, util.StringProperty(selfTracePhaseKey, "build"))
+		}
+		series := append([]*util.SeriesTrace{}, rt.series...)
+		sort.Slice(series, func(a, b int) bool {
+			return series[a].Start.Before(series[b].Start)
+		})
+		for _, s := range series {
+			child := span.Span(s.Start, s.End,
+				util.StringProperty(selfTraceSeriesNameKey, s.SeriesName),
+				util.StringProperty(selfTraceQueryNameKey, s.QueryName),
+				util.DurationProperty(selfTraceDurationKey, s.End.Sub(s.Start)),
+			)
+			for _, phase := range s.Phases() {
+				child.Subspan(phase.Start, phase.End,
+					util.StringProperty(selfTracePhaseKey, phase.Name),
+					util.DurationProperty(selfTraceDurationKey, phase.End.Sub(phase.Start)),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package querydispatcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/traceviz/server/go/util"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// How long a finished warmup's status is retained, so that clients
+	// polling StartWarmup observe its completion.  Once it expires, a
+	// StartWarmup call with the same hints warms again, as is needed if the
+	// state the warmup precomputed has since been evicted.
+	warmupRetention = time.Minute
+	// The maximum number of warmups tracked at once, bounding the memory
+	// used by warmups with distinct, client-supplied hints.
+	maxWarmups = 1024
+)
+
+// warmingDataSource is implemented by dataSources that can precompute state,
+// such as parsed collections, indices, or heavy aggregations, before any
+// DataRequest needs it.
+type warmingDataSource interface {
+	// Warmup precomputes state for DataRequests with the provided hints,
+	// which are typically the global filters, such as a collection name, that
+	// those DataRequests will carry.  Warmup may be invoked concurrently with
+	// itself and with HandleDataSeriesRequests.  Long-running warmups should
+	// report their progress with util.ReportProgress.
+	Warmup(ctx context.Context, hints map[string]*util.V) error
+}
+
+// WarmupStatus describes the progress of a warmup.
+type WarmupStatus struct {
+	// The fraction, between 0 and 1, of the warmup that is complete.
+	Progress float64
+	// True if the warmup has finished, successfully or not.
+	Done bool
+	// If the warmup failed, the error it failed with.
+	Err string `json:",omitempty"`
+}
+
+// warmup tracks a single warmup across all warming dataSources.
+type warmup struct {
+	mu sync.Mutex
+	// The progress of each warming dataSource.
+	progress []float64
+	done     bool
+	err      error
+	// When the warmup finished.
+	finished time.Time
+}
+
+// expired returns true if the receiver finished more than warmupRetention
+// before the provided time.
+func (w *warmup) expired(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.done && now.Sub(w.finished) > warmupRetention
+}
+
+func (w *warmup) status() *WarmupStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ret := &WarmupStatus{
+		Progress: 1,
+		Done:     w.done,
+	}
+	if len(w.progress) > 0 {
+		var total float64
+		for _, p := range w.progress {
+			total += p
+		}
+		ret.Progress = total / float64(len(w.progress))
+	}
+	if w.err != nil {
+		ret.Err = w.err.Error()
+	}
+	return ret
+}
+
+func (qd *QueryDispatcher) warmingDataSources() []warmingDataSource {
+	var ret []warmingDataSource
+	for _, ds := range qd.dataSources {
+		if wds, ok := ds.(warmingDataSource); ok {
+			ret = append(ret, wds)
+		}
+	}
+	return ret
+}
+
+// run warms all the provided dataSources with the provided hints, recording
+// progress in the receiver.
+func (w *warmup) run(ctx context.Context, wdss []warmingDataSource, hints map[string]*util.V) error {
+	errg, ctx := errgroup.WithContext(ctx)
+	for idx, wds := range wdss {
+		func(idx int, wds warmingDataSource) {
+			errg.Go(func() error {
+				dsCtx := util.WithProgressReporter(ctx, func(fraction float64) {
+					w.mu.Lock()
+					defer w.mu.Unlock()
+					w.progress[idx] = fraction
+				})
+				if err := wds.Warmup(dsCtx, hints); err != nil {
+					return err
+				}
+				util.ReportProgress(dsCtx, 1)
+				return nil
+			})
+		}(idx, wds)
+	}
+	err := errg.Wait()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done, w.err, w.finished = true, err, time.Now()
+	return err
+}
+
+// Warmup warms all dataSources supporting warmup with the provided hints,
+// after applying the receiver's GlobalFilterMiddleware to them, and returns
+// once all have finished.
+func (qd *QueryDispatcher) Warmup(ctx context.Context, hints map[string]*util.V) error {
+	hints, err := qd.applyGlobalFilterMiddleware(ctx, hints)
+	if err != nil {
+		return err
+	}
+	wdss := qd.warmingDataSources()
+	w := &warmup{
+		progress: make([]float64, len(wdss)),
+	}
+	return w.run(ctx, wdss, hints)
+}
+
+// StartWarmup begins warming all dataSources supporting warmup with the
+// provided hints, after applying the receiver's GlobalFilterMiddleware to
+// them, and returns the warmup's status.  Warmups are deduplicated by hints:
+// if a warmup with the same hints is running or finished within the last
+// warmupRetention, its status is returned and no new warmup is started.  A
+// failed warmup's status is returned once, and the next call with the same
+// hints retries it.  At most maxWarmups warmups are tracked at once.  Since
+// the warmup outlives the provided Context, only the Principal it carries, if
+// any, is passed on to the dataSources.
+func (qd *QueryDispatcher) StartWarmup(ctx context.Context, hints map[string]*util.V) (*WarmupStatus, error) {
+	hints, err := qd.applyGlobalFilterMiddleware(ctx, hints)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := json.Marshal(hints)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal warmup hints: %s", err)
+	}
+	key := string(keyBytes)
+	qd.warmupMu.Lock()
+	defer qd.warmupMu.Unlock()
+	now := time.Now()
+	for k, w := range qd.warmups {
+		if w.expired(now) {
+			delete(qd.warmups, k)
+		}
+	}
+	if w, ok := qd.warmups[key]; ok {
+		status := w.status()
+		if status.Err != "" {
+			delete(qd.warmups, key)
+		}
+		return status, nil
+	}
+	if len(qd.warmups) >= maxWarmups {
+		return nil, fmt.Errorf("too many warmups in progress")
+	}
+	wdss := qd.warmingDataSources()
+	w := &warmup{
+		progress: make([]float64, len(wdss)),
+	}
+	qd.warmups[key] = w
+	warmupCtx := util.WithPrincipal(context.Background(), util.PrincipalFrom(ctx))
+	go w.run(warmupCtx, wdss, hints)
+	return w.status(), nil
+}
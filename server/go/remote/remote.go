@@ -0,0 +1,248 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package remote provides DataSource, a QueryDispatcher data source that
+// forwards DataSeriesRequests to another TraceViz server over HTTP.  This
+// allows a single central TraceViz UI to federate several TraceViz data
+// backends, e.g. one per cluster.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	dataMethod   = "/GetData"
+	schemaMethod = "/GetSchema"
+
+	// The default maximum size of a remote server's response.
+	defaultMaxResponseBytes = 256 << 20
+)
+
+// RequestDecorator is invoked on each outgoing HTTP request before it is sent
+// to the remote server, with the Context of the DataRequest that prompted it.
+// It may, for example, add credentials identifying the requesting Principal.
+type RequestDecorator func(ctx context.Context, req *http.Request) error
+
+// Option configures a DataSource.
+type Option func(ds *DataSource)
+
+// WithHTTPClient specifies the http.Client used to contact the remote server.
+// By default, http.DefaultClient is used.
+func WithHTTPClient(client *http.Client) Option {
+	return func(ds *DataSource) {
+		ds.client = client
+	}
+}
+
+// WithRequestDecorator specifies a RequestDecorator to apply to each outgoing
+// request.
+func WithRequestDecorator(decorator RequestDecorator) Option {
+	return func(ds *DataSource) {
+		ds.decorators = append(ds.decorators, decorator)
+	}
+}
+
+// WithQueries specifies the query names the DataSource forwards to the remote
+// server.  If unspecified, all queries reported by the remote server's schema
+// are forwarded.
+func WithQueries(queries ...string) Option {
+	return func(ds *DataSource) {
+		ds.queries = queries
+	}
+}
+
+// WithNamespace specifies a namespace prefixed, with a dot, to each query name
+// the DataSource forwards: for example, with the namespace 'cluster_a', the
+// remote query 'logs.raw_entries@v2' is supported locally as
+// 'cluster_a.logs.raw_entries@v2'.  The prefix is removed from forwarded
+// requests.  This allows several DataSources forwarding the same queries to
+// different remote servers to share a QueryDispatcher.
+func WithNamespace(namespace string) Option {
+	return func(ds *DataSource) {
+		ds.prefix = namespace + "."
+	}
+}
+
+// WithMaxResponseBytes specifies the maximum size of a remote server's
+// response; larger responses fail.  By default, responses are limited to
+// 256MiB.
+func WithMaxResponseBytes(maxBytes int64) Option {
+	return func(ds *DataSource) {
+		ds.maxResponseBytes = maxBytes
+	}
+}
+
+// DataSource is a QueryDispatcher data source forwarding DataSeriesRequests to
+// a remote TraceViz server, and splicing the returned DataSeries into the
+// local response.
+type DataSource struct {
+	baseURL    string
+	client     *http.Client
+	decorators []RequestDecorator
+	// The query names supported by the remote server.
+	queries []string
+	// If non-empty, prefixed to each of queries to yield the supported query
+	// names.
+	prefix           string
+	maxResponseBytes int64
+}
+
+// New returns a new DataSource forwarding requests to the TraceViz server
+// at the provided base URL, which should serve that server's query handlers.
+// Unless WithQueries is specified, New fetches the remote server's schema to
+// learn which queries it supports.
+func New(ctx context.Context, baseURL string, opts ...Option) (*DataSource, error) {
+	ds := &DataSource{
+		baseURL:          strings.TrimSuffix(baseURL, "/"),
+		client:           http.DefaultClient,
+		maxResponseBytes: defaultMaxResponseBytes,
+	}
+	for _, opt := range opts {
+		opt(ds)
+	}
+	if ds.queries == nil {
+		schema := &querydispatcher.Schema{}
+		if err := ds.do(ctx, http.MethodGet, schemaMethod, nil, schema); err != nil {
+			return nil, fmt.Errorf("failed to fetch schema from %s: %s", ds.baseURL, err)
+		}
+		for _, qs := range schema.Queries {
+			qn, err := querydispatcher.ParseQueryName(qs.Name)
+			if err != nil {
+				return nil, err
+			}
+			for _, version := range qs.Versions {
+				qn.Version = version
+				ds.queries = append(ds.queries, qn.String())
+			}
+		}
+	}
+	return ds, nil
+}
+
+// SupportedDataSeriesQueries returns the query names forwarded to the remote
+// server, with any namespace prefix.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	if ds.prefix == "" {
+		return ds.queries
+	}
+	ret := make([]string, len(ds.queries))
+	for idx, queryName := range ds.queries {
+		ret[idx] = ds.prefix + queryName
+	}
+	return ret
+}
+
+// remoteQueryName returns the query name with which the remote server is
+// asked for the provided supported query name.  Requests are resolved to
+// supported query names as the remote server reported them, in which version
+// 1 is unversioned; since the remote server would resolve an unversioned
+// request to its latest version, version 1 is requested explicitly.
+func remoteQueryName(queryName string) string {
+	if qn, err := querydispatcher.ParseQueryName(queryName); err == nil && qn.Version == 0 {
+		return queryName + "@v1"
+	}
+	return queryName
+}
+
+// HandleDataSeriesRequests forwards the provided DataSeriesRequests, with the
+// provided global filters, to the remote server in a single DataRequest, and
+// adds the DataSeries it returns to the provided DataResponseBuilder.  Fails
+// if the remote server's response lacks any requested DataSeries, or includes
+// any unrequested ones.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	remoteReqs := make([]*util.DataSeriesRequest, len(reqs))
+	for idx, req := range reqs {
+		remoteReq := *req
+		remoteReq.QueryName = remoteQueryName(strings.TrimPrefix(req.QueryName, ds.prefix))
+		remoteReqs[idx] = &remoteReq
+	}
+	reqJSON, err := json.Marshal(&util.DataRequest{
+		GlobalFilters:  globalState,
+		SeriesRequests: remoteReqs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal remote DataRequest: %s", err)
+	}
+	form := url.Values{}
+	form.Set("req", string(reqJSON))
+	data := &util.Data{}
+	if err := ds.do(ctx, http.MethodPost, dataMethod, strings.NewReader(form.Encode()), data); err != nil {
+		return fmt.Errorf("remote DataRequest to %s failed: %s", ds.baseURL, err)
+	}
+	wantSeries := make(map[string]struct{}, len(reqs))
+	for _, req := range reqs {
+		wantSeries[req.SeriesName] = struct{}{}
+	}
+	for _, series := range data.DataSeries {
+		if _, ok := wantSeries[series.SeriesName]; !ok {
+			return fmt.Errorf("remote server %s returned unrequested data series '%s'", ds.baseURL, series.SeriesName)
+		}
+		delete(wantSeries, series.SeriesName)
+		if err := drb.AddDataSeries(series, data.StringTable); err != nil {
+			return fmt.Errorf("remote server %s returned malformed data: %s", ds.baseURL, err)
+		}
+	}
+	if len(wantSeries) > 0 {
+		missing := make([]string, 0, len(wantSeries))
+		for seriesName := range wantSeries {
+			missing = append(missing, seriesName)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("remote server %s did not return requested data series '%s'", ds.baseURL, strings.Join(missing, "', '"))
+	}
+	return nil
+}
+
+// do issues a request with the provided method and body to the specified
+// path on the remote server, and unmarshals the JSON response into resp.
+func (ds *DataSource) do(ctx context.Context, method, path string, body io.Reader, resp any) error {
+	httpReq, err := http.NewRequestWithContext(ctx, method, ds.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for _, decorator := range ds.decorators {
+		if err := decorator(ctx, httpReq); err != nil {
+			return err
+		}
+	}
+	httpResp, err := ds.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	respBytes, err := io.ReadAll(io.LimitReader(httpResp.Body, ds.maxResponseBytes+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(respBytes)) > ds.maxResponseBytes {
+		return fmt.Errorf("response exceeds %d bytes", ds.maxResponseBytes)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", httpResp.Status, strings.TrimSpace(string(respBytes)))
+	}
+	return json.Unmarshal(respBytes, resp)
+}
@@ -0,0 +1,299 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package remote
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/handlers"
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const collectionNameKey = "collection_name"
+
+// testDataSource responds to each request with a series describing the
+// request's collection.  For the collection 'partial', it responds only to
+// the first request.
+type testDataSource struct {
+	queries []string
+	// If non-empty, each series' root also names this server.
+	server string
+}
+
+func (tds *testDataSource) SupportedDataSeriesQueries() []string {
+	return tds.queries
+}
+
+func (tds *testDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	collectionName, err := util.ExpectStringValue(globalState[collectionNameKey])
+	if err != nil {
+		return err
+	}
+	if collectionName == "error" {
+		return errors.New("oops")
+	}
+	if collectionName == "partial" {
+		reqs = reqs[:1]
+	}
+	for _, req := range reqs {
+		root := drb.DataSeries(req).With(
+			util.StringProperty("query", req.QueryName),
+		)
+		if tds.server != "" {
+			root.With(util.StringProperty("server", tds.server))
+		}
+		root.Child().With(
+			util.StringProperty("collection", collectionName),
+			util.StringsProperty("tags", "remote", collectionName),
+		)
+	}
+	return nil
+}
+
+// newRemoteServer returns a test TraceViz server backed by the provided
+// dataSource.
+func newRemoteServer(t *testing.T, tds *testDataSource) *httptest.Server {
+	t.Helper()
+	qd, err := querydispatcher.New(tds)
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	mux := http.NewServeMux()
+	for path, handler := range handlers.NewQueryHandler(qd).Auth(nil, handlers.AllowAll()).HandlersByPath() {
+		mux.HandleFunc(path, handler)
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSupportedDataSeriesQueries(t *testing.T) {
+	srv := newRemoteServer(t, &testDataSource{
+		queries: []string{"logs.raw_entries", "logs.raw_entries@v2", "ThreadIntervals"},
+	})
+	ds, err := New(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	want := []string{"ThreadIntervals", "logs.raw_entries", "logs.raw_entries@v2"}
+	if diff := cmp.Diff(want, ds.SupportedDataSeriesQueries()); diff != "" {
+		t.Errorf("SupportedDataSeriesQueries() diff (-want +got):\n%s", diff)
+	}
+	ds, err = New(context.Background(), srv.URL, WithQueries("ThreadIntervals"))
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff([]string{"ThreadIntervals"}, ds.SupportedDataSeriesQueries()); diff != "" {
+		t.Errorf("SupportedDataSeriesQueries() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestFederation(t *testing.T) {
+	srv := newRemoteServer(t, &testDataSource{
+		queries: []string{"remote.query@v2"},
+	})
+	var decorated int
+	remoteDS, err := New(context.Background(), srv.URL, WithRequestDecorator(
+		func(ctx context.Context, req *http.Request) error {
+			decorated++
+			return nil
+		}))
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	qd, err := querydispatcher.New(
+		remoteDS,
+		&testDataSource{queries: []string{"local.query"}},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	for _, test := range []struct {
+		description    string
+		collectionName string
+		wantErr        bool
+		wantData       string
+	}{{
+		description:    "splices remote series",
+		collectionName: "coll",
+		wantData: `Data:
+  Series local
+    Root:
+      Prop 'query': 'local.query'
+      Child:
+        Prop 'collection': 'coll'
+        Prop 'tags': [ 'remote', 'coll' ]
+  Series remote
+    Root:
+      Prop 'query': 'remote.query@v2'
+      Child:
+        Prop 'collection': 'coll'
+        Prop 'tags': [ 'remote', 'coll' ]`,
+	}, {
+		description:    "remote failure",
+		collectionName: "error",
+		wantErr:        true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			data, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+				GlobalFilters: map[string]*util.V{
+					collectionNameKey: util.StringValue(test.collectionName),
+				},
+				SeriesRequests: []*util.DataSeriesRequest{{
+					QueryName:  "local.query",
+					SeriesName: "local",
+				}, {
+					QueryName:  "remote.query@v3",
+					SeriesName: "remote",
+				}},
+			})
+			if test.wantErr != (err != nil) {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if data.DataSeries[0].SeriesName != "local" {
+				data.DataSeries[0], data.DataSeries[1] = data.DataSeries[1], data.DataSeries[0]
+			}
+			if diff := cmp.Diff(test.wantData, data.PrettyPrint()); diff != "" {
+				t.Errorf("HandleDataRequest() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+	if decorated == 0 {
+		t.Errorf("RequestDecorator was never invoked")
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	var remotes []*DataSource
+	for _, server := range []string{"a", "b"} {
+		srv := newRemoteServer(t, &testDataSource{
+			queries: []string{"logs.raw_entries", "logs.raw_entries@v2"},
+			server:  server,
+		})
+		remoteDS, err := New(context.Background(), srv.URL, WithNamespace("cluster_"+server))
+		if err != nil {
+			t.Fatalf("New() yielded unexpected error %s", err)
+		}
+		remotes = append(remotes, remoteDS)
+	}
+	want := []string{"cluster_a.logs.raw_entries", "cluster_a.logs.raw_entries@v2"}
+	if diff := cmp.Diff(want, remotes[0].SupportedDataSeriesQueries()); diff != "" {
+		t.Errorf("SupportedDataSeriesQueries() diff (-want +got):\n%s", diff)
+	}
+	// Both remotes serve the same queries, but don't conflict.
+	qd, err := querydispatcher.New(remotes[0], remotes[1])
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	seriesReqs := []*util.DataSeriesRequest{{
+		QueryName:  "cluster_a.logs.raw_entries@v2",
+		SeriesName: "a",
+	}, {
+		QueryName:  "cluster_b.logs.raw_entries@v1",
+		SeriesName: "b",
+	}}
+	data, err := qd.HandleDataRequest(context.Background(), &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue("coll"),
+		},
+		SeriesRequests: seriesReqs,
+	})
+	if err != nil {
+		t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+	}
+	if data.DataSeries[0].SeriesName != "a" {
+		data.DataSeries[0], data.DataSeries[1] = data.DataSeries[1], data.DataSeries[0]
+	}
+	wantData := `Data:
+  Series a
+    Root:
+      Prop 'query': 'logs.raw_entries@v2'
+      Prop 'server': 'a'
+      Child:
+        Prop 'collection': 'coll'
+        Prop 'tags': [ 'remote', 'coll' ]
+  Series b
+    Root:
+      Prop 'query': 'logs.raw_entries'
+      Prop 'server': 'b'
+      Child:
+        Prop 'collection': 'coll'
+        Prop 'tags': [ 'remote', 'coll' ]`
+	if diff := cmp.Diff(wantData, data.PrettyPrint()); diff != "" {
+		t.Errorf("HandleDataRequest() diff (-want +got):\n%s", diff)
+	}
+	if seriesReqs[0].QueryName != "cluster_a.logs.raw_entries@v2" {
+		t.Errorf("HandleDataRequest() modified the requested query name to '%s'", seriesReqs[0].QueryName)
+	}
+}
+
+func TestMalformedResponses(t *testing.T) {
+	srv := newRemoteServer(t, &testDataSource{
+		queries: []string{"remote.query"},
+	})
+	for _, test := range []struct {
+		description    string
+		opts           []Option
+		collectionName string
+		wantErr        string
+	}{{
+		description:    "complete response",
+		collectionName: "coll",
+	}, {
+		description:    "missing series",
+		collectionName: "partial",
+		wantErr:        "did not return requested data series 'b', 'c'",
+	}, {
+		description:    "oversized response",
+		opts:           []Option{WithMaxResponseBytes(64)},
+		collectionName: "coll",
+		wantErr:        "response exceeds 64 bytes",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			ds, err := New(context.Background(), srv.URL, test.opts...)
+			if err != nil {
+				t.Fatalf("New() yielded unexpected error %s", err)
+			}
+			var reqs []*util.DataSeriesRequest
+			for _, seriesName := range []string{"a", "b", "c"} {
+				reqs = append(reqs, &util.DataSeriesRequest{
+					QueryName:  "remote.query",
+					SeriesName: seriesName,
+				})
+			}
+			err = ds.HandleDataSeriesRequests(context.Background(), map[string]*util.V{
+				collectionNameKey: util.StringValue(test.collectionName),
+			}, util.NewDataResponseBuilder(), reqs)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("HandleDataSeriesRequests() yielded unexpected error %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("HandleDataSeriesRequests() yielded error %v, wanted one containing '%s'", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package render draws TraceViz visualizations as static SVG or PNG images,
+// without a browser, for use in thumbnails, emails, dashboards, and CI
+// artifacts.  Visualization packages lay their response data out into a
+// Scene of filled, optionally labeled, rectangles, which may then be written
+// in either format.  For example, see trace.Render and weightedtree.Render.
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"image"
+	stdcolor "image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+)
+
+// Rect is a filled rectangle within a Scene.
+type Rect struct {
+	X, Y, Width, Height float64
+	Fill                stdcolor.NRGBA
+	// If nonempty, a label drawn within the rectangle in SVG output.
+	Label string
+}
+
+// Scene is a set of rectangles to be drawn, in order, onto a white
+// background.
+type Scene struct {
+	Width, Height float64
+	Rects         []*Rect
+}
+
+// NewScene returns a new, empty Scene with the specified width and height.
+// The Scene's height grows to accommodate its Rects.
+func NewScene(width, height float64) *Scene {
+	return &Scene{
+		Width:  width,
+		Height: height,
+	}
+}
+
+// Rect adds a new Rect to the receiver.
+func (s *Scene) Rect(x, y, width, height float64, fill stdcolor.NRGBA, label string) {
+	s.Rects = append(s.Rects, &Rect{
+		X:      x,
+		Y:      y,
+		Width:  width,
+		Height: height,
+		Fill:   fill,
+		Label:  label,
+	})
+	if y+height > s.Height {
+		s.Height = y + height
+	}
+}
+
+// Labels are drawn only in rectangles at least this wide.
+const minLabelWidthPx = 20
+
+func cssColor(c stdcolor.NRGBA) string {
+	return fmt.Sprintf("rgba(%d,%d,%d,%.3g)", c.R, c.G, c.B, float64(c.A)/255)
+}
+
+// WriteSVG writes the receiver to the provided Writer as an SVG document.
+func (s *Scene) WriteSVG(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" viewBox="0 0 %g %g" font-family="sans-serif">`+"\n", s.Width, s.Height, s.Width, s.Height)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	for _, r := range s.Rects {
+		if r.Label == "" {
+			fmt.Fprintf(bw, `<rect x="%g" y="%g" width="%g" height="%g" fill="%s"/>`+"\n", r.X, r.Y, r.Width, r.Height, cssColor(r.Fill))
+			continue
+		}
+		// A nested svg element clips its content, here the label, to its
+		// viewport.
+		fmt.Fprintf(bw, `<svg x="%g" y="%g" width="%g" height="%g"><title>%s</title><rect width="100%%" height="100%%" fill="%s"/>`, r.X, r.Y, r.Width, r.Height, html.EscapeString(r.Label), cssColor(r.Fill))
+		if r.Width >= minLabelWidthPx {
+			fmt.Fprintf(bw, `<text x="2" y="%g" font-size="%g">%s</text>`, r.Height*.75, math.Max(1, r.Height*.7), html.EscapeString(r.Label))
+		}
+		fmt.Fprintf(bw, "</svg>\n")
+	}
+	fmt.Fprintf(bw, "</svg>\n")
+	return bw.Flush()
+}
+
+// WritePNG writes the receiver to the provided Writer as a PNG image.  Labels
+// are not drawn.
+func (s *Scene) WritePNG(w io.Writer) error {
+	img := image.NewNRGBA(image.Rect(0, 0, int(math.Ceil(s.Width)), int(math.Ceil(s.Height))))
+	draw.Draw(img, img.Bounds(), image.NewUniform(stdcolor.White), image.Point{}, draw.Src)
+	for _, r := range s.Rects {
+		// Rectangles are at least a pixel wide and tall, so that narrow spans
+		// remain visible.
+		x0, y0 := int(math.Floor(r.X)), int(math.Floor(r.Y))
+		x1, y1 := int(math.Max(math.Round(r.X+r.Width), float64(x0+1))), int(math.Max(math.Round(r.Y+r.Height), float64(y0+1)))
+		draw.Draw(img, image.Rect(x0, y0, x1, y1), image.NewUniform(r.Fill), image.Point{}, draw.Over)
+	}
+	return png.Encode(w, img)
+}
+
+// DefaultFill returns a fill color for items with no color of their own,
+// chosen deterministically from a warm palette by the provided key, so that
+// like-keyed items are colored alike.
+func DefaultFill(key string) stdcolor.NRGBA {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	v := h.Sum32()
+	return stdcolor.NRGBA{
+		R: uint8(205 + v%50),
+		G: uint8(80 + (v>>8)%150),
+		B: uint8(40 + (v>>16)%60),
+		A: 255,
+	}
+}
@@ -0,0 +1,78 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	stdcolor "image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+var (
+	red  = stdcolor.NRGBA{255, 0, 0, 255}
+	blue = stdcolor.NRGBA{0, 0, 255, 255}
+)
+
+func testScene() *Scene {
+	s := NewScene(100, 10)
+	s.Rect(0, 0, 50, 10, red, "")
+	s.Rect(50, 10, 50, 10, blue, "a < b")
+	return s
+}
+
+func TestWriteSVG(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := testScene().WriteSVG(buf); err != nil {
+		t.Fatalf("WriteSVG() yielded unexpected error %s", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`width="100" height="20"`,
+		`<rect x="0" y="0" width="50" height="10" fill="rgba(255,0,0,1)"/>`,
+		`<svg x="50" y="10" width="50" height="10"><title>a &lt; b</title>`,
+		`<text x="2" y="7.5" font-size="7">a &lt; b</text>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("WriteSVG() = %s, want it to contain %s", got, want)
+		}
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := testScene().WritePNG(buf); err != nil {
+		t.Fatalf("WritePNG() yielded unexpected error %s", err)
+	}
+	img, err := png.Decode(buf)
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %s", err)
+	}
+	if got := img.Bounds().Size(); got.X != 100 || got.Y != 20 {
+		t.Errorf("WritePNG() image size = %v, want 100x20", got)
+	}
+	for _, test := range []struct {
+		x, y int
+		want stdcolor.NRGBA
+	}{
+		{10, 5, red},
+		{60, 15, blue},
+		{60, 5, stdcolor.NRGBA{255, 255, 255, 255}},
+	} {
+		if got := stdcolor.NRGBAModel.Convert(img.At(test.x, test.y)); got != test.want {
+			t.Errorf("pixel (%d, %d) = %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package schedtrace assembles kernel scheduling events, such as those
+// reported by ftrace or `perf script`, into per-CPU traces.  Each CPU is shown
+// as a trace category with two subcategories:
+//
+//	CPU 0      |
+//	|- Running | [ pid 100 ][200][   pid 100  ]
+//	|- Waiting |            [100][200][200,300]
+//
+// 'Running' holds a span for each interval in which a single task ran on that
+// CPU; 'Waiting' holds a span for each interval in which the set of runnable,
+// but not running, tasks queued on that CPU was constant and nonempty.  CPUs
+// on which interrupt handlers ran also have an 'Interrupts' subcategory,
+// holding a span for each handler invocation.
+package schedtrace
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	pidKey     = "pid"
+	commandKey = "command"
+	pidsKey    = "pids"
+	irqKey     = "irq"
+	nameKey    = "name"
+)
+
+// The idle task's PID.  Intervals in which the idle task runs are not shown.
+const idlePID = 0
+
+// DefaultRenderSettings are the trace render settings used if none are
+// specified.
+var DefaultRenderSettings = &trace.RenderSettings{
+	SpanWidthCatPx:   16,
+	SpanPaddingCatPx: 1,
+	CategoryAxisRenderSettings: &categoryaxis.RenderSettings{
+		CategoryHeaderCatPx:    16,
+		CategoryHandleValPx:    8,
+		CategoryPaddingCatPx:   2,
+		CategoryMarginValPx:    20,
+		CategoryMinWidthCatPx:  20,
+		CategoryBaseWidthValPx: 120,
+	},
+}
+
+// Task identifies a scheduled task.
+type Task struct {
+	PID  int64
+	Comm string
+}
+
+// Switch is a context switch on a CPU from one task to another.
+type Switch struct {
+	Time time.Duration
+	CPU  int
+	Prev Task
+	// The state of the previous task after the switch, e.g. 'R' if it was
+	// preempted and remains runnable, or 'S' if it is sleeping.
+	PrevState string
+	Next      Task
+}
+
+// Wakeup is a task becoming runnable on a CPU.
+type Wakeup struct {
+	Time      time.Duration
+	Task      Task
+	TargetCPU int
+}
+
+// IRQ is an interrupt handler invocation on a CPU.
+type IRQ struct {
+	Start, End time.Duration
+	CPU        int
+	IRQ        int64
+	// The name of the handler's device, e.g. 'eth0'.
+	Name string
+}
+
+// fieldKeyRE matches the start of a 'key=' field in a tracepoint payload.
+var fieldKeyRE = regexp.MustCompile(`(?:^|\s)(\w+)=`)
+
+// fields parses the provided tracepoint payload, of the form
+// 'key=value key=value ...', into a map.  Values, such as task names, may
+// contain spaces; the '==>' separator in sched_switch payloads is ignored.
+func fields(payload string) map[string]string {
+	ret := map[string]string{}
+	matches := fieldKeyRE.FindAllStringSubmatchIndex(payload, -1)
+	for idx, m := range matches {
+		end := len(payload)
+		if idx+1 < len(matches) {
+			end = matches[idx+1][0]
+		}
+		val := strings.TrimSpace(payload[m[1]:end])
+		ret[payload[m[2]:m[3]]] = strings.TrimSpace(strings.TrimSuffix(val, "==>"))
+	}
+	return ret
+}
+
+func task(f map[string]string, commKey, pidKey string) (Task, error) {
+	pid, err := strconv.ParseInt(f[pidKey], 10, 64)
+	if err != nil {
+		return Task{}, fmt.Errorf("bad %s '%s'", pidKey, f[pidKey])
+	}
+	return Task{PID: pid, Comm: f[commKey]}, nil
+}
+
+// ParseSwitch parses the payload of a sched_switch tracepoint, e.g.
+//
+//	prev_comm=bash prev_pid=1234 prev_prio=120 prev_state=S ==> next_comm=swapper/1 next_pid=0 next_prio=120
+//
+// which occurred at the specified time on the specified CPU.
+func ParseSwitch(t time.Duration, cpu int, payload string) (*Switch, error) {
+	f := fields(payload)
+	prev, err := task(f, "prev_comm", "prev_pid")
+	if err != nil {
+		return nil, fmt.Errorf("malformed sched_switch: %s", err)
+	}
+	next, err := task(f, "next_comm", "next_pid")
+	if err != nil {
+		return nil, fmt.Errorf("malformed sched_switch: %s", err)
+	}
+	return &Switch{
+		Time:      t,
+		CPU:       cpu,
+		Prev:      prev,
+		PrevState: f["prev_state"],
+		Next:      next,
+	}, nil
+}
+
+// ParseWakeup parses the payload of a sched_wakeup or sched_wakeup_new
+// tracepoint, e.g.
+//
+//	comm=bash pid=1234 prio=120 target_cpu=001
+//
+// which occurred at the specified time.
+func ParseWakeup(t time.Duration, payload string) (*Wakeup, error) {
+	f := fields(payload)
+	tk, err := task(f, "comm", "pid")
+	if err != nil {
+		return nil, fmt.Errorf("malformed sched_wakeup: %s", err)
+	}
+	cpu, err := strconv.Atoi(f["target_cpu"])
+	if err != nil {
+		return nil, fmt.Errorf("malformed sched_wakeup: bad target_cpu '%s'", f["target_cpu"])
+	}
+	return &Wakeup{
+		Time:      t,
+		Task:      tk,
+		TargetCPU: cpu,
+	}, nil
+}
+
+// ParseIRQ parses the payload of an irq_handler_entry tracepoint, e.g.
+//
+//	irq=24 name=eth0
+//
+// which occurred at the specified time on the specified CPU.  The returned
+// IRQ ends when it starts; its End should be updated upon the corresponding
+// irq_handler_exit.
+func ParseIRQ(t time.Duration, cpu int, payload string) (*IRQ, error) {
+	f := fields(payload)
+	irq, err := strconv.ParseInt(f["irq"], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed irq_handler_entry: bad irq '%s'", f["irq"])
+	}
+	return &IRQ{
+		Start: t,
+		End:   t,
+		CPU:   cpu,
+		IRQ:   irq,
+		Name:  f["name"],
+	}, nil
+}
+
+// Sched accumulates scheduling events for assembly into a trace.
+type Sched struct {
+	switches []*Switch
+	wakeups  []*Wakeup
+	irqs     []*IRQ
+	// The interrupt handlers currently running on each CPU, innermost last.
+	openIRQs map[int][]*IRQ
+	cpus     map[int]struct{}
+}
+
+// New returns a new, empty Sched.
+func New() *Sched {
+	return &Sched{
+		openIRQs: map[int][]*IRQ{},
+		cpus:     map[int]struct{}{},
+	}
+}
+
+// Switch adds the provided context switch to the receiver.
+func (s *Sched) Switch(sw *Switch) *Sched {
+	s.switches = append(s.switches, sw)
+	s.cpus[sw.CPU] = struct{}{}
+	return s
+}
+
+// Wakeup adds the provided wakeup to the receiver.
+func (s *Sched) Wakeup(w *Wakeup) *Sched {
+	s.wakeups = append(s.wakeups, w)
+	s.cpus[w.TargetCPU] = struct{}{}
+	return s
+}
+
+// IRQEntry adds the provided interrupt handler invocation to the receiver.
+// Until a subsequent IRQExit on the same CPU, the invocation is taken to run
+// until the end of the trace.
+func (s *Sched) IRQEntry(irq *IRQ) *Sched {
+	s.irqs = append(s.irqs, irq)
+	s.openIRQs[irq.CPU] = append(s.openIRQs[irq.CPU], irq)
+	s.cpus[irq.CPU] = struct{}{}
+	return s
+}
+
+// IRQExit ends the innermost interrupt handler invocation running on the
+// specified CPU at the specified time.  It has no effect if no handler is
+// running on that CPU.
+func (s *Sched) IRQExit(t time.Duration, cpu int) *Sched {
+	if open := s.openIRQs[cpu]; len(open) > 0 {
+		open[len(open)-1].End = t
+		s.openIRQs[cpu] = open[:len(open)-1]
+	}
+	return s
+}
+
+// Empty returns true if the receiver has no events.
+func (s *Sched) Empty() bool {
+	return len(s.switches) == 0 && len(s.wakeups) == 0 && len(s.irqs) == 0
+}
+
+// CPUs returns the CPUs on which the receiver has events, in increasing
+// order.
+func (s *Sched) CPUs() []int {
+	ret := make([]int, 0, len(s.cpus))
+	for cpu := range s.cpus {
+		ret = append(ret, cpu)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// cpuEvent is a single switch or wakeup affecting a CPU.
+type cpuEvent struct {
+	time   time.Duration
+	sw     *Switch
+	wakeup *Wakeup
+}
+
+// eventsByCPU returns the receiver's events grouped by CPU, in time order.
+func (s *Sched) eventsByCPU() map[int][]*cpuEvent {
+	ret := map[int][]*cpuEvent{}
+	for _, sw := range s.switches {
+		ret[sw.CPU] = append(ret[sw.CPU], &cpuEvent{time: sw.Time, sw: sw})
+	}
+	for _, w := range s.wakeups {
+		ret[w.TargetCPU] = append(ret[w.TargetCPU], &cpuEvent{time: w.Time, wakeup: w})
+	}
+	for _, evs := range ret {
+		sort.SliceStable(evs, func(a, b int) bool {
+			return evs[a].time < evs[b].time
+		})
+	}
+	return ret
+}
+
+// CPUCategory returns the trace category for the specified CPU.
+func CPUCategory(cpu int) *category.Category {
+	return category.New(
+		fmt.Sprintf("cpu%d", cpu),
+		fmt.Sprintf("CPU %d", cpu),
+		fmt.Sprintf("Activity on CPU %d", cpu),
+	)
+}
+
+var (
+	runningCategory = category.New("running", "Running", "Running tasks")
+	waitingCategory = category.New("waiting", "Waiting", "Runnable tasks waiting to run")
+	irqCategory     = category.New("irq", "Interrupts", "Interrupt handlers")
+)
+
+// Trace returns a new trace, populating the provided DataBuilder, spanning the
+// specified interval.  The trace has a category for each CPU, ordered by CPU,
+// containing the receiver's running and waiting intervals, and interrupt
+// handler invocations, on that CPU.  The
+// returned trace's CPU categories may be amended via CPUCategory.  If
+// renderSettings is nil, DefaultRenderSettings are used.
+func (s *Sched) Trace(db util.DataBuilder, start, end time.Duration, renderSettings *trace.RenderSettings) (*trace.Trace[time.Duration], map[int]*trace.Category[time.Duration]) {
+	if renderSettings == nil {
+		renderSettings = DefaultRenderSettings
+	}
+	t := trace.New(db, continuousaxis.NewDurationAxis(
+		category.New("x_axis", "Trace time", "Time from start of trace"),
+		start, end), renderSettings)
+	eventsByCPU := s.eventsByCPU()
+	irqsByCPU := map[int][]*IRQ{}
+	for _, irq := range s.irqs {
+		irqsByCPU[irq.CPU] = append(irqsByCPU[irq.CPU], irq)
+	}
+	open := map[*IRQ]struct{}{}
+	for _, irqs := range s.openIRQs {
+		for _, irq := range irqs {
+			open[irq] = struct{}{}
+		}
+	}
+	cpuCats := map[int]*trace.Category[time.Duration]{}
+	for _, cpu := range s.CPUs() {
+		cpuCat := t.Category(CPUCategory(cpu))
+		cpuCats[cpu] = cpuCat
+		addRunning(cpuCat.Category(runningCategory), eventsByCPU[cpu], start, end)
+		addWaiting(cpuCat.Category(waitingCategory), eventsByCPU[cpu], start, end)
+		if irqs := irqsByCPU[cpu]; len(irqs) > 0 {
+			addIRQs(cpuCat.Category(irqCategory), irqs, open, end)
+		}
+	}
+	return t, cpuCats
+}
+
+func addRunning(cat *trace.Category[time.Duration], evs []*cpuEvent, start, end time.Duration) {
+	var running *Task
+	runningSince := start
+	emit := func(until time.Duration) {
+		if running != nil && running.PID != idlePID && until > runningSince {
+			cat.Span(runningSince, until,
+				util.IntegerProperty(pidKey, running.PID),
+				util.StringProperty(commandKey, running.Comm),
+			)
+		}
+	}
+	for _, ev := range evs {
+		if ev.sw == nil {
+			continue
+		}
+		if running == nil {
+			// Before the first switch, the previous task was running.
+			prev := ev.sw.Prev
+			running = &prev
+		}
+		emit(ev.time)
+		next := ev.sw.Next
+		running, runningSince = &next, ev.time
+	}
+	emit(end)
+}
+
+func addWaiting(cat *trace.Category[time.Duration], evs []*cpuEvent, start, end time.Duration) {
+	waiting := map[int64]struct{}{}
+	waitingSince := start
+	emit := func(until time.Duration) {
+		if len(waiting) == 0 || until <= waitingSince {
+			return
+		}
+		pids := make([]int64, 0, len(waiting))
+		for pid := range waiting {
+			pids = append(pids, pid)
+		}
+		sort.Slice(pids, func(a, b int) bool { return pids[a] < pids[b] })
+		cat.Span(waitingSince, until, util.IntegersProperty(pidsKey, pids...))
+	}
+	update := func(t time.Duration, change func()) {
+		emit(t)
+		change()
+		waitingSince = t
+	}
+	for _, ev := range evs {
+		switch {
+		case ev.wakeup != nil:
+			if _, ok := waiting[ev.wakeup.Task.PID]; !ok {
+				update(ev.time, func() { waiting[ev.wakeup.Task.PID] = struct{}{} })
+			}
+		case ev.sw != nil:
+			_, nextWaiting := waiting[ev.sw.Next.PID]
+			_, prevWaiting := waiting[ev.sw.Prev.PID]
+			// A preempted task remains runnable.
+			prevRunnable := strings.HasPrefix(ev.sw.PrevState, "R") && ev.sw.Prev.PID != idlePID
+			if nextWaiting || (prevRunnable && !prevWaiting) {
+				update(ev.time, func() {
+					delete(waiting, ev.sw.Next.PID)
+					if prevRunnable {
+						waiting[ev.sw.Prev.PID] = struct{}{}
+					}
+				})
+			}
+		}
+	}
+	emit(end)
+}
+
+func addIRQs(cat *trace.Category[time.Duration], irqs []*IRQ, open map[*IRQ]struct{}, end time.Duration) {
+	sort.SliceStable(irqs, func(a, b int) bool {
+		return irqs[a].Start < irqs[b].Start
+	})
+	for _, irq := range irqs {
+		irqEnd := irq.End
+		if _, ok := open[irq]; ok {
+			// Handlers with no exit run until the end of the trace.
+			irqEnd = end
+		}
+		cat.Span(irq.Start, irqEnd,
+			util.IntegerProperty(irqKey, irq.IRQ),
+			util.If(irq.Name != "", util.StringProperty(nameKey, irq.Name)),
+		)
+	}
+}
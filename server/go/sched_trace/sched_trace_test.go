@@ -0,0 +1,142 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package schedtrace
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+)
+
+func ns(dur int) time.Duration {
+	return time.Duration(dur) * time.Nanosecond
+}
+
+func TestParse(t *testing.T) {
+	sw, err := ParseSwitch(ns(10), 1, "prev_comm=my prog prev_pid=1234 prev_prio=120 prev_state=R+ ==> next_comm=swapper/1 next_pid=0 next_prio=120")
+	if err != nil {
+		t.Fatalf("ParseSwitch() yielded unexpected error %s", err)
+	}
+	wantSw := &Switch{
+		Time:      ns(10),
+		CPU:       1,
+		Prev:      Task{PID: 1234, Comm: "my prog"},
+		PrevState: "R+",
+		Next:      Task{PID: 0, Comm: "swapper/1"},
+	}
+	if diff := cmp.Diff(wantSw, sw); diff != "" {
+		t.Errorf("ParseSwitch() diff (-want +got):\n%s", diff)
+	}
+	w, err := ParseWakeup(ns(20), "comm=bash pid=1234 prio=120 target_cpu=003")
+	if err != nil {
+		t.Fatalf("ParseWakeup() yielded unexpected error %s", err)
+	}
+	wantW := &Wakeup{
+		Time:      ns(20),
+		Task:      Task{PID: 1234, Comm: "bash"},
+		TargetCPU: 3,
+	}
+	if diff := cmp.Diff(wantW, w); diff != "" {
+		t.Errorf("ParseWakeup() diff (-want +got):\n%s", diff)
+	}
+	if _, err := ParseSwitch(0, 0, "prev_comm=bash prev_pid=x"); err == nil {
+		t.Errorf("ParseSwitch() of a malformed payload yielded no error")
+	}
+	if _, err := ParseWakeup(0, "comm=bash pid=1"); err == nil {
+		t.Errorf("ParseWakeup() of a payload with no target_cpu yielded no error")
+	}
+	irq, err := ParseIRQ(ns(30), 2, "irq=24 name=eth0")
+	if err != nil {
+		t.Fatalf("ParseIRQ() yielded unexpected error %s", err)
+	}
+	wantIRQ := &IRQ{
+		Start: ns(30),
+		End:   ns(30),
+		CPU:   2,
+		IRQ:   24,
+		Name:  "eth0",
+	}
+	if diff := cmp.Diff(wantIRQ, irq); diff != "" {
+		t.Errorf("ParseIRQ() diff (-want +got):\n%s", diff)
+	}
+	if _, err := ParseIRQ(0, 0, "name=eth0"); err == nil {
+		t.Errorf("ParseIRQ() of a payload with no irq yielded no error")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	// CPU 0         |
+	// |- Running    | [ pid 100 ][200][   pid 100  ]
+	// |- Waiting    |            [100][200][200,300]
+	// CPU 1         |
+	// |- Running    |      [400]
+	// |- Waiting    |
+	// |- Interrupts |       [24]               [24 ]
+	task := func(pid int64) Task {
+		return Task{PID: pid, Comm: "t"}
+	}
+	s := New().
+		Switch(&Switch{Time: ns(100), CPU: 0, Prev: task(100), PrevState: "R", Next: task(200)}).
+		Switch(&Switch{Time: ns(150), CPU: 0, Prev: task(200), PrevState: "R", Next: task(100)}).
+		Wakeup(&Wakeup{Time: ns(200), Task: task(300), TargetCPU: 0}).
+		Switch(&Switch{Time: ns(50), CPU: 1, Prev: task(idlePID), PrevState: "R", Next: task(400)}).
+		Switch(&Switch{Time: ns(100), CPU: 1, Prev: task(400), PrevState: "S", Next: task(idlePID)}).
+		IRQEntry(&IRQ{Start: ns(60), End: ns(60), CPU: 1, IRQ: 24, Name: "eth0"}).
+		IRQExit(ns(70), 1).
+		IRQEntry(&IRQ{Start: ns(250), End: ns(250), CPU: 1, IRQ: 24, Name: "eth0"})
+	gotDRB := util.NewDataResponseBuilder()
+	s.Trace(gotDRB.DataSeries(&util.DataSeriesRequest{}), ns(0), ns(300), nil)
+	gotData, err := gotDRB.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+
+	wantDRB := util.NewDataResponseBuilder()
+	tr := trace.New(wantDRB.DataSeries(&util.DataSeriesRequest{}), continuousaxis.NewDurationAxis(
+		category.New("x_axis", "Trace time", "Time from start of trace"),
+		ns(0), ns(300)), DefaultRenderSettings)
+	running := func(pid int64) []util.PropertyUpdate {
+		return []util.PropertyUpdate{
+			util.IntegerProperty(pidKey, pid),
+			util.StringProperty(commandKey, "t"),
+		}
+	}
+	cpu0 := tr.Category(CPUCategory(0))
+	cpu0Running := cpu0.Category(runningCategory)
+	cpu0Running.Span(ns(0), ns(100), running(100)...)
+	cpu0Running.Span(ns(100), ns(150), running(200)...)
+	cpu0Running.Span(ns(150), ns(300), running(100)...)
+	cpu0Waiting := cpu0.Category(waitingCategory)
+	cpu0Waiting.Span(ns(100), ns(150), util.IntegersProperty(pidsKey, 100))
+	cpu0Waiting.Span(ns(150), ns(200), util.IntegersProperty(pidsKey, 200))
+	cpu0Waiting.Span(ns(200), ns(300), util.IntegersProperty(pidsKey, 200, 300))
+	cpu1 := tr.Category(CPUCategory(1))
+	cpu1.Category(runningCategory).Span(ns(50), ns(100), running(400)...)
+	cpu1.Category(waitingCategory)
+	cpu1IRQ := cpu1.Category(irqCategory)
+	cpu1IRQ.Span(ns(60), ns(70), util.IntegerProperty(irqKey, 24), util.StringProperty(nameKey, "eth0"))
+	cpu1IRQ.Span(ns(250), ns(300), util.IntegerProperty(irqKey, 24), util.StringProperty(nameKey, "eth0"))
+	wantData, err := wantDRB.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+		t.Errorf("Trace() diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Binary gen writes the JSON Schema and TypeScript declarations describing
+// the TraceViz wire format and well-known property keys.  It is run by
+// `go generate` in the schema package.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/google/traceviz/server/go/schema"
+)
+
+var (
+	root       = flag.String("root", "", "The server/go directory, under which well-known keys are declared.")
+	jsonSchema = flag.String("json_schema", "", "If set, the path to which the JSON Schema is written.")
+	typescript = flag.String("typescript", "", "If set, the path to which the TypeScript declarations are written.")
+)
+
+func main() {
+	flag.Parse()
+	keys, err := schema.KeysUnder(*root)
+	if err != nil {
+		log.Fatalf("Failed to read well-known keys: %s", err)
+	}
+	for _, out := range []struct {
+		path string
+		gen  func([]schema.Key) ([]byte, error)
+	}{
+		{*jsonSchema, schema.JSONSchema},
+		{*typescript, schema.TypeScript},
+	} {
+		if out.path == "" {
+			continue
+		}
+		contents, err := out.gen(keys)
+		if err != nil {
+			log.Fatalf("Failed to generate %s: %s", out.path, err)
+		}
+		if err := os.WriteFile(out.path, contents, 0644); err != nil {
+			log.Fatalf("Failed to write %s: %s", out.path, err)
+		}
+	}
+}
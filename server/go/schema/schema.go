@@ -0,0 +1,532 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package schema describes the TraceViz wire format -- the JSON encodings of
+// util.DataRequest and util.Data -- and the well-known property keys used by
+// the trace, table, xy_chart, and weighted_tree packages, as a JSON Schema
+// and as TypeScript type declarations.  The generated files are checked in;
+// after changing the Go value model or adding a well-known key, regenerate
+// them with `go generate`.
+package schema
+
+//go:generate go run ./gen --root=.. --json_schema=traceviz.schema.json --typescript=../../../client/core/src/protocol/wire_types.ts
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// KeyPackages are the directories, relative to the server/go root, of the
+// packages whose well-known property keys are described.
+var KeyPackages = []string{"trace", "table", "xy_chart", "weighted_tree"}
+
+// Key is a well-known property key.
+type Key struct {
+	// The name of the package declaring the key.
+	Package string
+	// The name of the constant declaring the key.
+	Name string
+	// The key itself.
+	Value string
+}
+
+// stringConsts returns the string constants declared in the non-test source
+// of the package in the provided directory, by name: those with string
+// literal values, and, if keyConsts is non-nil, those whose values are
+// constants of the keys package, which keyConsts maps by name to their
+// values.
+func stringConsts(dir string, keyConsts map[string]string) (string, map[string]string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	var pkgName string
+	ret := map[string]string{}
+	for name, pkg := range pkgs {
+		pkgName = name
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				gd, ok := decl.(*ast.GenDecl)
+				if !ok || gd.Tok != token.CONST {
+					continue
+				}
+				for _, spec := range gd.Specs {
+					vs := spec.(*ast.ValueSpec)
+					for idx, ident := range vs.Names {
+						if idx >= len(vs.Values) {
+							continue
+						}
+						switch val := vs.Values[idx].(type) {
+						case *ast.BasicLit:
+							if val.Kind != token.STRING {
+								continue
+							}
+							str, err := strconv.Unquote(val.Value)
+							if err != nil {
+								return "", nil, fmt.Errorf("%s: %s", fset.Position(val.Pos()), err)
+							}
+							ret[ident.Name] = str
+						case *ast.SelectorExpr:
+							if pkgIdent, ok := val.X.(*ast.Ident); !ok || pkgIdent.Name != "keys" || keyConsts == nil {
+								continue
+							}
+							str, ok := keyConsts[val.Sel.Name]
+							if !ok {
+								return "", nil, fmt.Errorf("%s: keys.%s is not a string constant", fset.Position(val.Pos()), val.Sel.Name)
+							}
+							ret[ident.Name] = str
+						}
+					}
+				}
+			}
+		}
+	}
+	return pkgName, ret, nil
+}
+
+// KeysUnder returns the well-known property keys declared in the non-test
+// source of KeyPackages, under the provided server/go root directory: the
+// string constants whose names end with 'Key', whose values may be given by
+// constants of the keys package.  Keys are returned ordered by package, then
+// value.
+func KeysUnder(root string) ([]Key, error) {
+	_, keyConsts, err := stringConsts(filepath.Join(root, "keys"), nil)
+	if err != nil {
+		return nil, err
+	}
+	var ret []Key
+	for _, pkg := range KeyPackages {
+		pkgName, consts, err := stringConsts(filepath.Join(root, pkg), keyConsts)
+		if err != nil {
+			return nil, err
+		}
+		for name, val := range consts {
+			if strings.HasSuffix(name, "Key") {
+				ret = append(ret, Key{
+					Package: pkgName,
+					Name:    name,
+					Value:   val,
+				})
+			}
+		}
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		if ret[a].Package != ret[b].Package {
+			return ret[a].Package < ret[b].Package
+		}
+		if ret[a].Value != ret[b].Value {
+			return ret[a].Value < ret[b].Value
+		}
+		return ret[a].Name < ret[b].Name
+	})
+	return ret, nil
+}
+
+// valueEncoding describes the JSON encoding of the value within a V of a
+// particular type.
+type valueEncoding struct {
+	t          int
+	name       string
+	tsType     string
+	jsonSchema map[string]any
+}
+
+var (
+	jsonInteger  = map[string]any{"type": "integer"}
+	jsonIntegers = map[string]any{"type": "array", "items": jsonInteger}
+)
+
+// valueEncodings describes the encoding of each value type; see V.MarshalJSON.
+var valueEncodings = []valueEncoding{
+	{0, "Unset", "null", map[string]any{"type": "null"}},
+	{int(util.StringValueType), "String", "string", map[string]any{"type": "string"}},
+	{int(util.StringIndexValueType), "StringIndex", "number", jsonInteger},
+	{int(util.StringsValueType), "Strings", "string[]", map[string]any{"type": "array", "items": map[string]any{"type": "string"}}},
+	{int(util.StringIndicesValueType), "StringIndices", "number[]", jsonIntegers},
+	{int(util.IntegerValueType), "Integer", "number", jsonInteger},
+	{int(util.IntegersValueType), "Integers", "number[]", jsonIntegers},
+	{int(util.DoubleValueType), "Double", "number", map[string]any{"type": "number"}},
+	{int(util.DurationValueType), "Duration", "number", jsonInteger},
+	{int(util.TimestampValueType), "Timestamp", "[number, number]", tuple(jsonInteger, jsonInteger)},
+	{int(util.ReferenceValueType), "Reference", "[number, number]", tuple(jsonInteger, jsonInteger)},
+	{int(util.DoublesValueType), "Doubles", "number[]", map[string]any{"type": "array", "items": map[string]any{"type": "number"}}},
+}
+
+// wireTypes are the structs encoded with the default JSON encoding, in the
+// order they are declared.  util.V and util.Datum have custom encodings,
+// described by valueEncodings and datumSchema.
+var wireTypes = []reflect.Type{
+	reflect.TypeOf(util.DataRequest{}),
+	reflect.TypeOf(util.DataSeriesRequest{}),
+	reflect.TypeOf(util.Data{}),
+	reflect.TypeOf(util.DataSeries{}),
+	reflect.TypeOf(util.SeriesStats{}),
+}
+
+var (
+	vType        = reflect.TypeOf(util.V{})
+	datumType    = reflect.TypeOf(util.Datum{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// field describes a JSON-encoded struct field.
+// Pointer fields may be null.
+type field struct {
+	name     string
+	t        reflect.Type
+	optional bool
+}
+
+func fieldsOf(t reflect.Type) []field {
+	var ret []field
+	for idx := 0; idx < t.NumField(); idx++ {
+		sf := t.Field(idx)
+		if !sf.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		ret = append(ret, field{
+			name:     name,
+			t:        sf.Type,
+			optional: strings.Contains(opts, "omitempty"),
+		})
+	}
+	return ret
+}
+
+func tuple(items ...map[string]any) map[string]any {
+	prefixItems := make([]any, len(items))
+	for idx, item := range items {
+		prefixItems[idx] = item
+	}
+	return map[string]any{
+		"type":        "array",
+		"prefixItems": prefixItems,
+		"items":       false,
+	}
+}
+
+func ref(name string) map[string]any {
+	return map[string]any{"$ref": "#/$defs/" + name}
+}
+
+func jsonSchemaOf(t reflect.Type) (map[string]any, error) {
+	switch {
+	case t == vType:
+		return ref("V"), nil
+	case t == datumType:
+		return ref("Datum"), nil
+	case t == durationType:
+		return jsonInteger, nil
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchemaOf(t.Elem())
+	case reflect.Struct:
+		return ref(t.Name()), nil
+	case reflect.Slice:
+		elem, err := jsonSchemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": elem}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		elem, err := jsonSchemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": elem}, nil
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonInteger, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+// JSONSchema returns a JSON Schema describing the TraceViz wire format, and
+// enumerating the provided well-known property keys.
+func JSONSchema(wellKnown []Key) ([]byte, error) {
+	vs := make([]any, len(valueEncodings))
+	for idx, ve := range valueEncodings {
+		vs[idx] = tuple(map[string]any{"const": ve.t}, ve.jsonSchema)
+	}
+	defs := map[string]any{
+		"V": map[string]any{
+			"description": "A value, encoded as [value type, value].",
+			"oneOf":       vs,
+		},
+		"KV": map[string]any{
+			"description": "A property, encoded as [key string index, value].",
+			"type":        "array",
+			"prefixItems": []any{map[string]any{"type": []any{"integer", "string"}}, ref("V")},
+			"items":       false,
+		},
+		"Datum": map[string]any{
+			"description": "A datum, encoded as [properties, children].",
+			"type":        "array",
+			"prefixItems": []any{
+				map[string]any{"type": "array", "items": ref("KV")},
+				map[string]any{"type": "array", "items": ref("Datum")},
+			},
+			"items": false,
+		},
+	}
+	for _, t := range wireTypes {
+		props := map[string]any{}
+		required := []any{}
+		for _, f := range fieldsOf(t) {
+			fs, err := jsonSchemaOf(f.t)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", t.Name(), f.name, err)
+			}
+			if f.t.Kind() == reflect.Pointer {
+				fs = map[string]any{"anyOf": []any{fs, map[string]any{"type": "null"}}}
+			}
+			props[f.name] = fs
+			if !f.optional {
+				required = append(required, f.name)
+			}
+		}
+		defs[t.Name()] = map[string]any{
+			"type":       "object",
+			"properties": props,
+			"required":   required,
+		}
+	}
+	keyValues := []any{}
+	for _, val := range keyValuesOf(wellKnown) {
+		keyValues = append(keyValues, val)
+	}
+	defs["WellKnownKey"] = map[string]any{
+		"description": "A well-known property key.",
+		"enum":        keyValues,
+	}
+	ret, err := json.MarshalIndent(map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "TraceViz data response",
+		"description": "Generated by server/go/schema; DO NOT EDIT.",
+		"$ref":        "#/$defs/Data",
+		"$defs":       defs,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(ret, '\n'), nil
+}
+
+// keyValuesOf returns the distinct values of the provided Keys, in increasing
+// order.
+func keyValuesOf(wellKnown []Key) []string {
+	seen := map[string]bool{}
+	var ret []string
+	for _, key := range wellKnown {
+		if !seen[key.Value] {
+			seen[key.Value] = true
+			ret = append(ret, key.Value)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func tsTypeOf(t reflect.Type) (string, error) {
+	switch {
+	case t == vType:
+		return "V", nil
+	case t == datumType:
+		return "Datum", nil
+	case t == durationType:
+		return "number", nil
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return tsTypeOf(t.Elem())
+	case reflect.Struct:
+		return t.Name(), nil
+	case reflect.Slice:
+		elem, err := tsTypeOf(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return elem + "[]", nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return "", fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		elem, err := tsTypeOf(t.Elem())
+		if err != nil {
+			return "", err
+		}
+		return "{[key: string]: " + elem + "}", nil
+	case reflect.String:
+		return "string", nil
+	case reflect.Bool:
+		return "boolean", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number", nil
+	default:
+		return "", fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+// tsName returns the TypeScript property name for the provided Key: its
+// constant name, without the 'Key' suffix, in upper snake case.
+func tsName(key Key) string {
+	return strings.ToUpper(snake(strings.TrimSuffix(key.Name, "Key")))
+}
+
+func tsString(str string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(str) + "'"
+}
+
+const tsHeader = `/*
+        Copyright 2023 Google Inc.
+        Licensed under the Apache License, Version 2.0 (the "License");
+        you may not use this file except in compliance with the License.
+        You may obtain a copy of the License at
+                https://www.apache.org/licenses/LICENSE-2.0
+        Unless required by applicable law or agreed to in writing, software
+        distributed under the License is distributed on an "AS IS" BASIS,
+        WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+        See the License for the specific language governing permissions and
+        limitations under the License.
+*/
+
+// Code generated by server/go/schema; DO NOT EDIT.
+
+/**
+ * @fileoverview Types describing the JSON encoding of backend requests and
+ * responses, and the well-known property keys used by core visualizations.
+ */
+
+`
+
+// TypeScript returns TypeScript declarations of the TraceViz wire format and
+// the provided well-known property keys.
+func TypeScript(wellKnown []Key) ([]byte, error) {
+	var b strings.Builder
+	b.WriteString(tsHeader)
+	b.WriteString("/** Value types, as encoded in the first element of a V. */\n")
+	b.WriteString("export enum ValueType {\n")
+	for _, ve := range valueEncodings {
+		fmt.Fprintf(&b, "  %s = %d,\n", strings.ToUpper(snake(ve.name)), ve.t)
+	}
+	b.WriteString("}\n\n")
+	b.WriteString("/** A value, encoded as [value type, value]. */\n")
+	b.WriteString("export type V =")
+	for _, ve := range valueEncodings {
+		fmt.Fprintf(&b, "\n    |[ValueType.%s, %s]", strings.ToUpper(snake(ve.name)), ve.tsType)
+	}
+	b.WriteString(";\n\n")
+	b.WriteString("/** A property, encoded as [key string index, value]. */\n")
+	b.WriteString("export type KV = [number|string, V];\n\n")
+	b.WriteString("/** A datum, encoded as [properties, children]. */\n")
+	b.WriteString("export type Datum = [KV[], Datum[]];\n\n")
+	b.WriteString("// These objects are serialized to JSON, so their names must be formatted as\n")
+	b.WriteString("// expected on the backend.\n")
+	b.WriteString("// tslint:disable:enforce-name-casing\n")
+	for _, t := range wireTypes {
+		fmt.Fprintf(&b, "\n/** Encodes the backend's %s. */\n", t)
+		fmt.Fprintf(&b, "export interface %s {\n", t.Name())
+		for _, f := range fieldsOf(t) {
+			ts, err := tsTypeOf(f.t)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", t.Name(), f.name, err)
+			}
+			if f.t.Kind() == reflect.Pointer {
+				ts += "|null"
+			}
+			optional := ""
+			if f.optional {
+				optional = "?"
+			}
+			fmt.Fprintf(&b, "  %s%s: %s;\n", f.name, optional, ts)
+		}
+		b.WriteString("}\n")
+	}
+	b.WriteString("// tslint:enable:enforce-name-casing\n\n")
+	b.WriteString("/** Well-known property keys, by declaring backend package. */\n")
+	b.WriteString("export const WELL_KNOWN_KEYS = {\n")
+	for idx, key := range wellKnown {
+		if idx == 0 || wellKnown[idx-1].Package != key.Package {
+			if idx > 0 {
+				b.WriteString("  },\n")
+			}
+			fmt.Fprintf(&b, "  %s: {\n", key.Package)
+		}
+		fmt.Fprintf(&b, "    %s: %s,\n", tsName(key), tsString(key.Value))
+	}
+	if len(wellKnown) > 0 {
+		b.WriteString("  },\n")
+	}
+	b.WriteString("} as const;\n\n")
+	b.WriteString("/** A well-known property key. */\n")
+	b.WriteString("export type WellKnownKey =")
+	for _, val := range keyValuesOf(wellKnown) {
+		fmt.Fprintf(&b, "\n    |%s", tsString(val))
+	}
+	if len(wellKnown) == 0 {
+		b.WriteString(" never")
+	}
+	b.WriteString(";\n")
+	return []byte(b.String()), nil
+}
+
+// snake returns the provided camelCase or CamelCase name in snake_case.
+// Initialisms are kept together, so 'linkURL' becomes 'link_url'.
+func snake(name string) string {
+	rs := []rune(name)
+	var ret []rune
+	for idx, r := range rs {
+		if idx > 0 && unicode.IsUpper(r) &&
+			(!unicode.IsUpper(rs[idx-1]) || (idx+1 < len(rs) && unicode.IsLower(rs[idx+1]))) {
+			ret = append(ret, '_')
+		}
+		ret = append(ret, unicode.ToLower(r))
+	}
+	return string(ret)
+}
@@ -0,0 +1,86 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package schema
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKeys(t *testing.T) {
+	keys, err := KeysUnder("..")
+	if err != nil {
+		t.Fatalf("KeysUnder yielded unexpected error %s", err)
+	}
+	want := map[Key]bool{
+		{"trace", "startKey", "trace_start"}:                                   true,
+		{"trace", "GroupByKey", "group_by"}:                                    true,
+		{"table", "cellKey", "table_cell"}:                                     true,
+		{"xychart", "gapStartKey", "xy_chart_gap_start"}:                       true,
+		{"weightedtree", "totalMagnitudeKey", "weighted_tree_total_magnitude"}: true,
+	}
+	for _, key := range keys {
+		delete(want, key)
+	}
+	if len(want) > 0 {
+		t.Errorf("KeysUnder(..) = %v, missing %v", keys, want)
+	}
+}
+
+func TestSnake(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+	}{
+		{"start", "start"},
+		{"ViewportWidthPx", "viewport_width_px"},
+		{"linkURL", "link_url"},
+		{"StringIndex", "string_index"},
+		{"CPUTime", "cpu_time"},
+	} {
+		if got := snake(test.name); got != test.want {
+			t.Errorf("snake(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// TestGeneratedFilesUpToDate fails if the checked-in generated files differ
+// from those that `go generate` would produce.
+func TestGeneratedFilesUpToDate(t *testing.T) {
+	wellKnown, err := KeysUnder("..")
+	if err != nil {
+		t.Fatalf("KeysUnder yielded unexpected error %s", err)
+	}
+	for _, test := range []struct {
+		path string
+		gen  func([]Key) ([]byte, error)
+	}{
+		{"traceviz.schema.json", JSONSchema},
+		{"../../../client/core/src/protocol/wire_types.ts", TypeScript},
+	} {
+		want, err := test.gen(wellKnown)
+		if err != nil {
+			t.Fatalf("Failed to generate %s: %s", test.path, err)
+		}
+		got, err := os.ReadFile(test.path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s", test.path, err)
+		}
+		if diff := cmp.Diff(string(want), string(got)); diff != "" {
+			t.Errorf("%s is stale; run `go generate` in server/go/schema.  Diff (-want +got):\n%s", test.path, diff)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package sqlsource provides DataSource, a QueryDispatcher data source
+// answering named queries by running parameterized SQL statements against a
+// database/sql database, and emitting their results as tables or xy charts.
+//
+// For example,
+//
+//	ds, err := sqlsource.New(db, &sqlsource.Query{
+//		Name:   "metrics.cpu_usage",
+//		SQL:    "SELECT ts, usage FROM cpu WHERE host = ? ORDER BY ts",
+//		Params: []sqlsource.Param{{Key: "host"}},
+//		Output: sqlsource.XYChartOutput,
+//	})
+//
+// serves the query 'metrics.cpu_usage' as an xy chart of usage over time,
+// for the host named by the 'host' option or global filter.
+package sqlsource
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+// Output specifies how a Query's result rows are emitted.
+type Output int
+
+const (
+	// TableOutput emits each result row as a table row, with one column per
+	// result column.
+	TableOutput Output = iota
+	// XYChartOutput emits an xy chart.  The first result column, which must
+	// hold timestamps or numbers, supplies the x value of each point; each
+	// other result column, which must hold numbers, is a series.  NULL y
+	// values are omitted.
+	XYChartOutput
+)
+
+// Param binds a SQL statement placeholder to a value in the request.
+type Param struct {
+	// The key of the series option or, if no such option is present, global
+	// filter supplying the value.
+	Key string
+	// The value to use if the request provides none.  If nil, the value is
+	// required.
+	Default *util.V
+}
+
+// Query maps a query name to a parameterized SQL statement.
+type Query struct {
+	// The data series query name.
+	Name string
+	// The SQL statement, using the placeholder syntax of the database driver.
+	SQL string
+	// The statement's parameters, in placeholder order.  Requests specifying
+	// series options not bound to any parameter are rejected.
+	Params []Param
+	// How the statement's result rows are emitted.
+	Output Output
+}
+
+// DataSource is a QueryDispatcher data source answering Queries from a SQL
+// database.
+type DataSource struct {
+	db        *sql.DB
+	queryList []string
+	queries   map[string]*Query
+}
+
+// New returns a new DataSource running the provided Queries against the
+// provided database.
+func New(db *sql.DB, queries ...*Query) (*DataSource, error) {
+	ds := &DataSource{
+		db:      db,
+		queries: map[string]*Query{},
+	}
+	for _, query := range queries {
+		if _, ok := ds.queries[query.Name]; ok {
+			return nil, fmt.Errorf("multiple SQL queries named `%s`", query.Name)
+		}
+		ds.queries[query.Name] = query
+		ds.queryList = append(ds.queryList, query.Name)
+	}
+	return ds, nil
+}
+
+// SupportedDataSeriesQueries returns the names of the receiver's Queries.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	return ds.queryList
+}
+
+// HandleDataSeriesRequests runs the Query named by each provided
+// DataSeriesRequest, and emits its results into a new DataSeries.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		query, ok := ds.queries[req.QueryName]
+		if !ok {
+			return fmt.Errorf("unsupported SQL query `%s`", req.QueryName)
+		}
+		args, err := query.args(globalState, req.Options)
+		if err != nil {
+			return err
+		}
+		rows, err := ds.db.QueryContext(ctx, query.SQL, args...)
+		if err != nil {
+			return fmt.Errorf("SQL query `%s` failed: %s", query.Name, err)
+		}
+		err = query.emit(drb.DataSeries(req), rows)
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("SQL query `%s` failed: %s", query.Name, err)
+		}
+	}
+	return nil
+}
+
+// args returns the statement arguments bound to the receiver's Params.
+func (q *Query) args(globalState, options map[string]*util.V) ([]any, error) {
+	schema := make([]*util.OptionSpec, len(q.Params))
+	for idx, param := range q.Params {
+		schema[idx] = &util.OptionSpec{
+			Key:      param.Key,
+			Default:  param.Default,
+			Required: true,
+		}
+	}
+	opts, err := util.ResolveOptions(globalState, options, schema)
+	if err != nil {
+		return nil, fmt.Errorf("SQL query `%s`: %s", q.Name, err)
+	}
+	ret := make([]any, len(q.Params))
+	for idx, param := range q.Params {
+		val, _ := opts.Value(param.Key)
+		arg, err := Arg(val)
+		if err != nil {
+			return nil, fmt.Errorf("SQL query `%s` parameter '%s': %s", q.Name, param.Key, err)
+		}
+		ret[idx] = arg
+	}
+	return ret, nil
+}
+
+// Arg returns the SQL statement argument corresponding to the provided request
+// value.  Durations are passed as integer nanoseconds.
+func Arg(val *util.V) (any, error) {
+	switch val.T {
+	case util.StringValueType:
+		return util.ExpectStringValue(val)
+	case util.IntegerValueType:
+		return util.ExpectIntegerValue(val)
+	case util.DoubleValueType:
+		return util.ExpectDoubleValue(val)
+	case util.DurationValueType:
+		dur, err := util.ExpectDurationValue(val)
+		return int64(dur), err
+	case util.TimestampValueType:
+		return util.ExpectTimestampValue(val)
+	default:
+		return nil, fmt.Errorf("unsupported parameter value type %d", val.T)
+	}
+}
+
+// Value returns the util.Value corresponding to the provided value scanned
+// from a SQL result column.  Booleans become integers 0 or 1; byte slices
+// become strings; NULLs become util.Nothing.
+func Value(col any) (util.Value, error) {
+	switch v := col.(type) {
+	case nil:
+		return util.Nothing, nil
+	case int64:
+		return util.Integer(v), nil
+	case float64:
+		return util.Double(v), nil
+	case bool:
+		if v {
+			return util.Integer(1), nil
+		}
+		return util.Integer(0), nil
+	case []byte:
+		return util.String(string(v)), nil
+	case string:
+		return util.String(v), nil
+	case time.Time:
+		return util.Timestamp(v), nil
+	default:
+		return nil, fmt.Errorf("unsupported SQL value type %T", col)
+	}
+}
+
+// scan returns the names of the provided result rows' columns, and the values
+// of each row.
+func scan(rows *sql.Rows) ([]string, [][]any, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var ret [][]any
+	for rows.Next() {
+		row := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for idx := range row {
+			ptrs[idx] = &row[idx]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		ret = append(ret, row)
+	}
+	return cols, ret, rows.Err()
+}
+
+// emit emits the provided result rows into the provided DataBuilder.
+func (q *Query) emit(db util.DataBuilder, rows *sql.Rows) error {
+	cols, vals, err := scan(rows)
+	if err != nil {
+		return err
+	}
+	switch q.Output {
+	case TableOutput:
+		return emitTable(db, cols, vals)
+	case XYChartOutput:
+		return emitXYChart(db, cols, vals)
+	default:
+		return fmt.Errorf("unsupported output %d", q.Output)
+	}
+}
+
+func emitTable(db util.DataBuilder, cols []string, vals [][]any) error {
+	columns := make([]*table.ColumnUpdate, len(cols))
+	for idx, col := range cols {
+		columns[idx] = table.Column(category.New(col, col, col))
+	}
+	t := table.New(db, nil, columns...)
+	for _, row := range vals {
+		cells := make([]table.CellUpdate, 0, len(row))
+		for idx, col := range row {
+			if col == nil {
+				continue
+			}
+			val, err := Value(col)
+			if err != nil {
+				return fmt.Errorf("column '%s': %s", cols[idx], err)
+			}
+			cells = append(cells, table.Cell(columns[idx], val))
+		}
+		t.Row(cells...)
+	}
+	return nil
+}
+
+// number returns the provided numeric SQL value as a float64.
+func number(col any) (float64, bool) {
+	switch v := col.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func emitXYChart(db util.DataBuilder, cols []string, vals [][]any) error {
+	if len(cols) < 2 {
+		return fmt.Errorf("xy chart output requires at least two columns")
+	}
+	xCat := category.New(cols[0], cols[0], cols[0])
+	yCat := category.New("y", "Value", "Value")
+	// The x axis type is determined by the first row.
+	if len(vals) > 0 {
+		if _, ok := vals[0][0].(time.Time); ok {
+			return emitXYSeries(db, continuousaxis.NewTimestampAxis(xCat), continuousaxis.NewDoubleAxis(yCat), cols, vals,
+				func(col any) (time.Time, bool) {
+					ts, ok := col.(time.Time)
+					return ts, ok
+				})
+		}
+	}
+	return emitXYSeries(db, continuousaxis.NewDoubleAxis(xCat), continuousaxis.NewDoubleAxis(yCat), cols, vals, number)
+}
+
+func emitXYSeries[X float64 | time.Time](db util.DataBuilder, xAxis *continuousaxis.Axis[X], yAxis *continuousaxis.Axis[float64], cols []string, vals [][]any, xOf func(col any) (X, bool)) error {
+	chart := xychart.New(db, xAxis, yAxis)
+	for colIdx, col := range cols[1:] {
+		series := chart.AddSeries(category.New(col, col, col))
+		for _, row := range vals {
+			x, ok := xOf(row[0])
+			if !ok {
+				return fmt.Errorf("column '%s' has unsupported x value %v", cols[0], row[0])
+			}
+			yCol := row[colIdx+1]
+			if yCol == nil {
+				continue
+			}
+			y, ok := number(yCol)
+			if !ok {
+				return fmt.Errorf("column '%s' has non-numeric value %v", col, yCol)
+			}
+			series.WithPoint(x, y)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package sqlsource
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+// fakeResult is the canned result of a fake SQL statement.  Its rows function
+// receives the statement's arguments.
+type fakeResult struct {
+	cols []string
+	rows func(args []driver.Value) [][]driver.Value
+}
+
+// fakeDriver is a database/sql driver serving canned results by statement.
+type fakeDriver struct {
+	results map[string]*fakeResult
+}
+
+func (fd *fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{fd}, nil
+}
+
+type fakeConn struct {
+	fd *fakeDriver
+}
+
+func (fc *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	res, ok := fc.fd.results[query]
+	if !ok {
+		return nil, fmt.Errorf("unknown statement %q", query)
+	}
+	return &fakeStmt{res}, nil
+}
+
+func (fc *fakeConn) Close() error {
+	return nil
+}
+
+func (fc *fakeConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions unsupported")
+}
+
+type fakeStmt struct {
+	res *fakeResult
+}
+
+func (fs *fakeStmt) Close() error {
+	return nil
+}
+
+func (fs *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (fs *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("exec unsupported")
+}
+
+func (fs *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{cols: fs.res.cols, rows: fs.res.rows(args)}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (fr *fakeRows) Columns() []string {
+	return fr.cols
+}
+
+func (fr *fakeRows) Close() error {
+	return nil
+}
+
+func (fr *fakeRows) Next(dest []driver.Value) error {
+	if len(fr.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, fr.rows[0])
+	fr.rows = fr.rows[1:]
+	return nil
+}
+
+var driverCount int
+
+// newFakeDB returns a database serving the provided canned results.
+func newFakeDB(t *testing.T, results map[string]*fakeResult) *sql.DB {
+	t.Helper()
+	driverCount++
+	name := fmt.Sprintf("sqlsource_fake_%d", driverCount)
+	sql.Register(name, &fakeDriver{results: results})
+	db, err := sql.Open(name, "")
+	if err != nil {
+		t.Fatalf("Failed to open fake database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDataSource(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	db := newFakeDB(t, map[string]*fakeResult{
+		"SELECT name, pid, cpu, ok FROM procs WHERE host = ?": {
+			cols: []string{"name", "pid", "cpu", "ok"},
+			rows: func(args []driver.Value) [][]driver.Value {
+				return [][]driver.Value{
+					{[]byte(args[0].(string) + "/init"), int64(1), 0.5, true},
+					{"sshd", int64(22), nil, false},
+				}
+			},
+		},
+		"SELECT ts, user, sys FROM cpu WHERE since > ?": {
+			cols: []string{"ts", "user", "sys"},
+			rows: func(args []driver.Value) [][]driver.Value {
+				return [][]driver.Value{
+					{start, 0.25, int64(1)},
+					{start.Add(time.Second), nil, int64(2)},
+				}
+			},
+		},
+	})
+	ds, err := New(db, &Query{
+		Name:   "sql.procs",
+		SQL:    "SELECT name, pid, cpu, ok FROM procs WHERE host = ?",
+		Params: []Param{{Key: "host"}},
+		Output: TableOutput,
+	}, &Query{
+		Name:   "sql.cpu",
+		SQL:    "SELECT ts, user, sys FROM cpu WHERE since > ?",
+		Params: []Param{{Key: "since", Default: util.DurationValue(time.Minute)}},
+		Output: XYChartOutput,
+	})
+	if err != nil {
+		t.Fatalf("New() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff([]string{"sql.procs", "sql.cpu"}, ds.SupportedDataSeriesQueries()); diff != "" {
+		t.Errorf("SupportedDataSeriesQueries() diff (-want +got):\n%s", diff)
+	}
+	for _, test := range []struct {
+		description   string
+		globalFilters map[string]*util.V
+		req           *util.DataSeriesRequest
+		wantErr       bool
+		buildWant     func(db util.DataBuilder)
+	}{{
+		description: "table from option",
+		req: &util.DataSeriesRequest{
+			QueryName: "sql.procs",
+			Options: map[string]*util.V{
+				"host": util.StringValue("h1"),
+			},
+		},
+		buildWant: func(db util.DataBuilder) {
+			cols := []*table.ColumnUpdate{
+				table.Column(category.New("name", "name", "name")),
+				table.Column(category.New("pid", "pid", "pid")),
+				table.Column(category.New("cpu", "cpu", "cpu")),
+				table.Column(category.New("ok", "ok", "ok")),
+			}
+			t := table.New(db, nil, cols...)
+			t.Row(
+				table.Cell(cols[0], util.String("h1/init")),
+				table.Cell(cols[1], util.Integer(1)),
+				table.Cell(cols[2], util.Double(0.5)),
+				table.Cell(cols[3], util.Integer(1)),
+			)
+			t.Row(
+				table.Cell(cols[0], util.String("sshd")),
+				table.Cell(cols[1], util.Integer(22)),
+				table.Cell(cols[3], util.Integer(0)),
+			)
+		},
+	}, {
+		description: "table from global filter",
+		globalFilters: map[string]*util.V{
+			"host": util.StringValue("h2"),
+		},
+		req: &util.DataSeriesRequest{
+			QueryName: "sql.procs",
+		},
+		buildWant: func(db util.DataBuilder) {
+			cols := []*table.ColumnUpdate{
+				table.Column(category.New("name", "name", "name")),
+				table.Column(category.New("pid", "pid", "pid")),
+				table.Column(category.New("cpu", "cpu", "cpu")),
+				table.Column(category.New("ok", "ok", "ok")),
+			}
+			t := table.New(db, nil, cols...)
+			t.Row(
+				table.Cell(cols[0], util.String("h2/init")),
+				table.Cell(cols[1], util.Integer(1)),
+				table.Cell(cols[2], util.Double(0.5)),
+				table.Cell(cols[3], util.Integer(1)),
+			)
+			t.Row(
+				table.Cell(cols[0], util.String("sshd")),
+				table.Cell(cols[1], util.Integer(22)),
+				table.Cell(cols[3], util.Integer(0)),
+			)
+		},
+	}, {
+		description: "missing required parameter",
+		req: &util.DataSeriesRequest{
+			QueryName: "sql.procs",
+		},
+		wantErr: true,
+	}, {
+		description: "xy chart with default parameter",
+		req: &util.DataSeriesRequest{
+			QueryName: "sql.cpu",
+		},
+		buildWant: func(db util.DataBuilder) {
+			chart := xychart.New(db,
+				continuousaxis.NewTimestampAxis(category.New("ts", "ts", "ts")),
+				continuousaxis.NewDoubleAxis(category.New("y", "Value", "Value")))
+			chart.AddSeries(category.New("user", "user", "user")).
+				WithPoint(start, 0.25)
+			chart.AddSeries(category.New("sys", "sys", "sys")).
+				WithPoint(start, 1).
+				WithPoint(start.Add(time.Second), 2)
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := util.NewDataResponseBuilder()
+			err := ds.HandleDataSeriesRequests(context.Background(), test.globalFilters, drb, []*util.DataSeriesRequest{test.req})
+			if test.wantErr != (err != nil) {
+				t.Fatalf("HandleDataSeriesRequests() yielded unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			gotData, err := drb.Data()
+			if err != nil {
+				t.Fatalf("Data() yielded unexpected error %s", err)
+			}
+			wantDRB := util.NewDataResponseBuilder()
+			test.buildWant(wantDRB.DataSeries(test.req))
+			wantData, err := wantDRB.Data()
+			if err != nil {
+				t.Fatalf("Data() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+				t.Errorf("HandleDataSeriesRequests() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
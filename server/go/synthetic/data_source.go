@@ -0,0 +1,228 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package synthetic
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	// Queries
+	rpcTraceQuery = "synthetic.rpc_trace"
+	schedQuery    = "synthetic.sched"
+	treeQuery     = "synthetic.tree"
+	logsQuery     = "synthetic.logs"
+
+	// Options
+	seedKey     = "seed"
+	fanoutKey   = "fanout"
+	depthKey    = "depth"
+	cpusKey     = "cpus"
+	tasksKey    = "tasks"
+	switchesKey = "switches"
+	nodesKey    = "nodes"
+	entriesKey  = "entries"
+
+	// The largest number of items any query may generate.
+	maxItems = 10000000
+)
+
+// The duration of synthetic traces, and the start time of synthetic logs.
+var (
+	traceDuration = time.Second
+	logStart      = time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+var (
+	timeCol    = table.Column(category.New("time", "Time", "Time of the log entry"))
+	levelCol   = table.Column(category.New("level", "Level", "Severity of the log entry"))
+	sourceCol  = table.Column(category.New("source", "Source", "Source location of the log entry"))
+	messageCol = table.Column(category.New("message", "Message", "Log message"))
+)
+
+// DataSource is a QueryDispatcher data source serving synthetic data.  It
+// supports the queries:
+//
+//   - 'synthetic.rpc_trace', a trace of an RPC fanout.  Options 'fanout'
+//     (default 3) and 'depth' (default 4) shape the call tree.
+//   - 'synthetic.sched', a per-CPU trace of a CPU schedule.  Options 'cpus'
+//     (default 4), 'tasks' (default 16), and 'switches' (default 10000) size
+//     the schedule.
+//   - 'synthetic.tree', a weighted tree.  Options 'nodes' (default 1000) and
+//     'fanout' (default 5) shape the tree.
+//   - 'synthetic.logs', a table of log entries.  Option 'entries' (default
+//     1000) sizes the table.
+//
+// All queries accept the integer option 'seed' (default 1); the same options
+// always yield the same data.  No query may generate more than ten million
+// items.
+type DataSource struct{}
+
+// NewDataSource returns a new DataSource.
+func NewDataSource() *DataSource {
+	return &DataSource{}
+}
+
+// SupportedDataSeriesQueries returns the queries supported by DataSource.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	return []string{rpcTraceQuery, schedQuery, treeQuery, logsQuery}
+}
+
+// HandleDataSeriesRequests handles the provided DataSeriesRequests.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		var err error
+		switch req.QueryName {
+		case rpcTraceQuery:
+			err = handleRPCTraceQuery(drb.DataSeries(req), req.Options)
+		case schedQuery:
+			err = handleSchedQuery(drb.DataSeries(req), req.Options)
+		case treeQuery:
+			err = handleTreeQuery(drb.DataSeries(req), req.Options)
+		case logsQuery:
+			err = handleLogsQuery(drb.DataSeries(req), req.Options)
+		default:
+			err = fmt.Errorf("unsupported data query `%s`", req.QueryName)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// intOptions returns the values of the provided request options, which must
+// all be positive integers named in the provided defaults, with any missing
+// options taking their default values.  A seed option is always accepted.
+func intOptions(reqOpts map[string]*util.V, defaults map[string]int) (map[string]int, error) {
+	schema := []*util.OptionSpec{{
+		Key:     seedKey,
+		Source:  util.FromSeries,
+		Default: util.IntegerValue(1),
+		Type:    util.IntegerValueType,
+	}}
+	for key, def := range defaults {
+		schema = append(schema, &util.OptionSpec{
+			Key:     key,
+			Source:  util.FromSeries,
+			Default: util.IntegerValue(int64(def)),
+			Type:    util.IntegerValueType,
+		})
+	}
+	opts, err := util.ResolveOptions(nil, reqOpts, schema)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]int, len(schema))
+	for _, spec := range schema {
+		i, err := opts.Integer(spec.Key)
+		if err != nil {
+			return nil, err
+		}
+		if i <= 0 || i > maxItems {
+			return nil, fmt.Errorf("option '%s' must be between 1 and %d", spec.Key, maxItems)
+		}
+		ret[spec.Key] = int(i)
+	}
+	return ret, nil
+}
+
+func handleRPCTraceQuery(series util.DataBuilder, reqOpts map[string]*util.V) error {
+	opts, err := intOptions(reqOpts, map[string]int{
+		fanoutKey: 3,
+		depthKey:  4,
+	})
+	if err != nil {
+		return err
+	}
+	params := RPCParams{
+		Fanout:   opts[fanoutKey],
+		Depth:    opts[depthKey],
+		Duration: traceDuration,
+	}
+	// Check the call tree's size level by level, to avoid overflow.
+	for count, level, depth := 0, 1, 0; depth < params.Depth; depth++ {
+		if count += level; count > maxItems {
+			return fmt.Errorf("RPC fanout would exceed %d RPCs", maxItems)
+		}
+		level *= params.Fanout
+	}
+	NewRPCFanout(rand.New(rand.NewSource(int64(opts[seedKey]))), params).Trace(series)
+	return nil
+}
+
+func handleSchedQuery(series util.DataBuilder, reqOpts map[string]*util.V) error {
+	opts, err := intOptions(reqOpts, map[string]int{
+		cpusKey:     4,
+		tasksKey:    16,
+		switchesKey: 10000,
+	})
+	if err != nil {
+		return err
+	}
+	NewSchedule(rand.New(rand.NewSource(int64(opts[seedKey]))), ScheduleParams{
+		CPUs:     opts[cpusKey],
+		Tasks:    opts[tasksKey],
+		Switches: opts[switchesKey],
+		Duration: traceDuration,
+	}).Trace(series, 0, traceDuration, nil)
+	return nil
+}
+
+func handleTreeQuery(series util.DataBuilder, reqOpts map[string]*util.V) error {
+	opts, err := intOptions(reqOpts, map[string]int{
+		nodesKey:  1000,
+		fanoutKey: 5,
+	})
+	if err != nil {
+		return err
+	}
+	NewTree(series, rand.New(rand.NewSource(int64(opts[seedKey]))), TreeParams{
+		Nodes:     opts[nodesKey],
+		MaxFanout: opts[fanoutKey],
+	})
+	return nil
+}
+
+func handleLogsQuery(series util.DataBuilder, reqOpts map[string]*util.V) error {
+	opts, err := intOptions(reqOpts, map[string]int{
+		entriesKey: 1000,
+	})
+	if err != nil {
+		return err
+	}
+	entries := NewLogs(rand.New(rand.NewSource(int64(opts[seedKey]))), LogParams{
+		Entries:      opts[entriesKey],
+		Start:        logStart,
+		MeanInterval: 10 * time.Millisecond,
+		Sources:      10,
+	})
+	tbl := table.New(series, nil, timeCol, levelCol, sourceCol, messageCol)
+	for _, entry := range entries {
+		tbl.Row(
+			table.Cell(timeCol, util.Timestamp(entry.Time)),
+			table.Cell(levelCol, util.String(string(entry.Level))),
+			table.Cell(sourceCol, util.String(fmt.Sprintf("%s:%d", entry.SourceFile, entry.SourceLine))),
+			table.Cell(messageCol, util.String(entry.Message)),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package synthetic generates parameterized fake TraceViz data -- RPC fanout
+// traces, CPU schedules, weighted trees, and log streams -- of configurable
+// size, for demos, load testing, and exercising frontends without real data.
+// All generators are deterministic given their *rand.Rand, so a fixed seed
+// reproduces the same data.
+//
+// DataSource serves these generators as data series queries.
+package synthetic
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	schedtrace "github.com/google/traceviz/server/go/sched_trace"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+)
+
+const (
+	// Properties
+	serviceKey = "service"
+	methodKey  = "method"
+	frameKey   = "frame"
+)
+
+var (
+	methods = []string{"Get", "Put", "List", "Lookup", "Query", "Update", "Delete", "Watch"}
+	frames  = []string{"main", "serve", "handle", "parse", "lookup", "encode", "decode", "compress", "hash", "sort", "alloc", "flush", "read", "write", "wait"}
+)
+
+// between returns a uniformly random duration in [min, max].
+func between(r *rand.Rand, min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(r.Int63n(int64(max-min)+1))
+}
+
+// RPC is a synthetic remote procedure call, and the calls it fans out to.
+type RPC struct {
+	Service, Method string
+	Start, End      time.Duration
+	Children        []*RPC
+}
+
+// RPCParams parameterizes a synthetic RPC fanout.
+type RPCParams struct {
+	// The number of calls made by each non-leaf RPC.
+	Fanout int
+	// The depth of the call tree; a depth of 1 is a single RPC.
+	Depth int
+	// The duration of the root RPC.
+	Duration time.Duration
+}
+
+// NewRPCFanout returns a synthetic RPC call tree with the specified
+// parameters.  RPCs at each depth of the tree are served by the same service,
+// and each RPC's calls lie within it.
+func NewRPCFanout(r *rand.Rand, params RPCParams) *RPC {
+	return newRPC(r, params, 0, 0, params.Duration)
+}
+
+func newRPC(r *rand.Rand, params RPCParams, depth int, start, end time.Duration) *RPC {
+	ret := &RPC{
+		Service: fmt.Sprintf("service%d", depth),
+		Method:  methods[r.Intn(len(methods))],
+		Start:   start,
+		End:     end,
+	}
+	if depth+1 >= params.Depth {
+		return ret
+	}
+	for i := 0; i < params.Fanout; i++ {
+		childStart := between(r, start, start+(end-start)/2)
+		childEnd := between(r, childStart+(end-childStart)/4, end)
+		ret.Children = append(ret.Children, newRPC(r, params, depth+1, childStart, childEnd))
+	}
+	return ret
+}
+
+// DefaultRenderSettings are the trace render settings used for synthetic
+// traces.
+var DefaultRenderSettings = &trace.RenderSettings{
+	SpanWidthCatPx:   16,
+	SpanPaddingCatPx: 1,
+	CategoryAxisRenderSettings: &categoryaxis.RenderSettings{
+		CategoryHeaderCatPx:    16,
+		CategoryHandleValPx:    8,
+		CategoryPaddingCatPx:   4,
+		CategoryMarginValPx:    8,
+		CategoryMinWidthCatPx:  16,
+		CategoryBaseWidthValPx: 200,
+	},
+}
+
+// Trace emits the receiver and its descendants as a trace populating the
+// provided DataBuilder, with a Category for each service holding a span for
+// each RPC it served.  Concurrent RPCs are laid out in separate rows.
+func (rpc *RPC) Trace(db util.DataBuilder) *trace.Trace[time.Duration] {
+	t := trace.New(db, continuousaxis.NewDurationAxis(
+		category.New("x_axis", "Trace time", "Time from start of trace"),
+		rpc.Start, rpc.End), DefaultRenderSettings).WithLayout()
+	cats := map[string]*trace.Category[time.Duration]{}
+	// Visit RPCs breadth-first, so that services' categories appear in call
+	// order.
+	queue := []*RPC{rpc}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		cat, ok := cats[cur.Service]
+		if !ok {
+			cat = t.Category(category.New(cur.Service, cur.Service, "RPCs served by "+cur.Service))
+			cats[cur.Service] = cat
+		}
+		cat.Span(cur.Start, cur.End,
+			util.StringProperty(serviceKey, cur.Service),
+			util.StringProperty(methodKey, cur.Method),
+		)
+		queue = append(queue, cur.Children...)
+	}
+	t.Layout()
+	return t
+}
+
+// ScheduleParams parameterizes a synthetic CPU schedule.
+type ScheduleParams struct {
+	CPUs int
+	// The number of tasks, which are distributed evenly across CPUs.
+	Tasks int
+	// The total number of context switches, which are distributed evenly
+	// across CPUs.
+	Switches int
+	// The duration of the schedule.
+	Duration time.Duration
+}
+
+// NewSchedule returns a synthetic CPU schedule with the specified parameters.
+// Each task runs only on its own CPU; tasks are woken shortly before they are
+// switched in, and CPUs are occasionally idle.
+func NewSchedule(r *rand.Rand, params ScheduleParams) *schedtrace.Sched {
+	ret := schedtrace.New()
+	for cpu := 0; cpu < params.CPUs; cpu++ {
+		idle := schedtrace.Task{PID: 0, Comm: fmt.Sprintf("swapper/%d", cpu)}
+		var tasks []schedtrace.Task
+		for pid := cpu; pid < params.Tasks; pid += params.CPUs {
+			tasks = append(tasks, schedtrace.Task{
+				PID:  int64(100 + pid),
+				Comm: fmt.Sprintf("task%d", pid),
+			})
+		}
+		if len(tasks) == 0 {
+			continue
+		}
+		times := make([]time.Duration, params.Switches/params.CPUs)
+		for idx := range times {
+			times[idx] = between(r, 0, params.Duration)
+		}
+		sort.Slice(times, func(a, b int) bool {
+			return times[a] < times[b]
+		})
+		cur, last := idle, time.Duration(0)
+		for _, t := range times {
+			next := idle
+			if r.Intn(10) > 0 {
+				next = tasks[r.Intn(len(tasks))]
+			}
+			if next == cur {
+				continue
+			}
+			if next != idle {
+				ret.Wakeup(&schedtrace.Wakeup{
+					Time:      between(r, last, t),
+					Task:      next,
+					TargetCPU: cpu,
+				})
+			}
+			prevState := "S"
+			if r.Intn(2) == 0 {
+				prevState = "R"
+			}
+			ret.Switch(&schedtrace.Switch{
+				Time:      t,
+				CPU:       cpu,
+				Prev:      cur,
+				PrevState: prevState,
+				Next:      next,
+			})
+			cur, last = next, t
+		}
+	}
+	return ret
+}
+
+// TreeParams parameterizes a synthetic weighted tree.
+type TreeParams struct {
+	// The total number of nodes in the tree.
+	Nodes int
+	// The maximum number of children of any node.
+	MaxFanout int
+}
+
+// treeNode is implemented by weightedtree.Tree and weightedtree.Node.
+type treeNode interface {
+	Node(selfMagnitude float64, properties ...util.PropertyUpdate) *weightedtree.Node
+}
+
+// NewTree returns a synthetic weighted tree, resembling a profile of sampled
+// callstacks, with the specified parameters, populating the provided
+// DataBuilder.
+func NewTree(db util.DataBuilder, r *rand.Rand, params TreeParams) *weightedtree.Tree {
+	ret := weightedtree.New(db, &weightedtree.RenderSettings{FrameHeightPx: 20})
+	// Populate the tree breadth-first until it has the requested number of
+	// nodes.
+	queue := []treeNode{ret}
+	for count := 0; count < params.Nodes && len(queue) > 0; {
+		parent := queue[0]
+		queue = queue[1:]
+		fanout := 1 + r.Intn(params.MaxFanout)
+		for i := 0; i < fanout && count < params.Nodes; i++ {
+			queue = append(queue, parent.Node(float64(r.Intn(100)),
+				util.StringProperty(frameKey, frames[r.Intn(len(frames))]),
+			))
+			count++
+		}
+	}
+	return ret
+}
+
+// LogEntry is a synthetic log entry.
+type LogEntry struct {
+	Time time.Time
+	// One of 'I', 'W', or 'E'.
+	Level      byte
+	SourceFile string
+	SourceLine int
+	Message    string
+}
+
+// String returns the receiver formatted as a log line, in the form
+//
+//	yyyy/mm/dd hh:mm:ss.uuuuuu file:line: [L] message
+func (le *LogEntry) String() string {
+	return fmt.Sprintf("%s %s:%d: [%c] %s", le.Time.UTC().Format("2006/01/02 15:04:05.000000"), le.SourceFile, le.SourceLine, le.Level, le.Message)
+}
+
+// LogParams parameterizes a synthetic log stream.
+type LogParams struct {
+	Entries int
+	// The time of the first entry.
+	Start time.Time
+	// The mean interval between successive entries.
+	MeanInterval time.Duration
+	// The number of distinct source files logging.
+	Sources int
+}
+
+var logMessages = []struct {
+	level  byte
+	format string
+}{
+	{'I', "handled request req=r%d in %dms"},
+	{'I', "cache hit for key k%d (%d bytes)"},
+	{'I', "flushed %d records to shard %d"},
+	{'W', "cache miss for key k%d after %dms"},
+	{'W', "retrying connection to backend%d (attempt %d)"},
+	{'E', "request req=r%d failed with status %d"},
+}
+
+// NewLogs returns a synthetic log stream with the specified parameters, in
+// time order.
+func NewLogs(r *rand.Rand, params LogParams) []*LogEntry {
+	ret := make([]*LogEntry, params.Entries)
+	t := params.Start
+	for idx := range ret {
+		// Most entries are informational.
+		msg := logMessages[r.Intn(3)]
+		if r.Intn(10) == 0 {
+			msg = logMessages[3+r.Intn(len(logMessages)-3)]
+		}
+		source := r.Intn(params.Sources)
+		ret[idx] = &LogEntry{
+			Time:       t,
+			Level:      msg.level,
+			SourceFile: fmt.Sprintf("source%d.go", source),
+			SourceLine: 10 + 10*source + r.Intn(10),
+			Message:    fmt.Sprintf(msg.format, r.Intn(1000), r.Intn(500)),
+		}
+		t = t.Add(time.Duration(r.ExpFloat64() * float64(params.MeanInterval)))
+	}
+	return ret
+}
+
+// WriteLogs writes the provided log entries to the provided Writer, one per
+// line.
+func WriteLogs(w io.Writer, entries []*LogEntry) error {
+	for _, entry := range entries {
+		if _, err := fmt.Fprintln(w, entry.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package synthetic
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/traceviz/server/go/util"
+	"github.com/google/traceviz/server/go/validation"
+)
+
+func countRPCs(rpc *RPC) int {
+	ret := 1
+	for _, child := range rpc.Children {
+		if child.Start < rpc.Start || child.End > rpc.End || child.Start > child.End {
+			return -1
+		}
+		ret += countRPCs(child)
+	}
+	return ret
+}
+
+func TestRPCFanout(t *testing.T) {
+	params := RPCParams{Fanout: 3, Depth: 4, Duration: time.Second}
+	rpc := NewRPCFanout(rand.New(rand.NewSource(1)), params)
+	if got, want := countRPCs(rpc), 1+3+9+27; got != want {
+		t.Errorf("NewRPCFanout() yielded %d well-nested RPCs, want %d", got, want)
+	}
+	again := NewRPCFanout(rand.New(rand.NewSource(1)), params)
+	if diff := cmp.Diff(rpc, again); diff != "" {
+		t.Errorf("NewRPCFanout() with the same seed yielded different RPCs, diff (-first +second):\n%s", diff)
+	}
+}
+
+func TestLogs(t *testing.T) {
+	entries := NewLogs(rand.New(rand.NewSource(1)), LogParams{
+		Entries:      100,
+		Start:        logStart,
+		MeanInterval: time.Millisecond,
+		Sources:      3,
+	})
+	if len(entries) != 100 {
+		t.Fatalf("NewLogs() yielded %d entries, want 100", len(entries))
+	}
+	var buf bytes.Buffer
+	if err := WriteLogs(&buf, entries); err != nil {
+		t.Fatalf("WriteLogs() yielded unexpected error %s", err)
+	}
+	lineRE := regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}\.\d{6} source\d\.go:\d+: \[[IWE]\] .+$`)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	for idx, line := range lines {
+		if !lineRE.MatchString(line) {
+			t.Errorf("log line %d '%s' is malformed", idx, line)
+		}
+		if idx > 0 && entries[idx].Time.Before(entries[idx-1].Time) {
+			t.Errorf("log entry %d precedes its predecessor", idx)
+		}
+	}
+}
+
+func TestDataSource(t *testing.T) {
+	for _, test := range []struct {
+		queryName string
+		options   map[string]*util.V
+		wantErr   bool
+	}{{
+		queryName: rpcTraceQuery,
+		options:   map[string]*util.V{fanoutKey: util.IntegerValue(2), depthKey: util.IntegerValue(3)},
+	}, {
+		queryName: rpcTraceQuery,
+		options:   map[string]*util.V{fanoutKey: util.IntegerValue(1000), depthKey: util.IntegerValue(4)},
+		wantErr:   true,
+	}, {
+		queryName: schedQuery,
+		options:   map[string]*util.V{switchesKey: util.IntegerValue(1000)},
+	}, {
+		queryName: treeQuery,
+		options:   map[string]*util.V{nodesKey: util.IntegerValue(500), seedKey: util.IntegerValue(7)},
+	}, {
+		queryName: logsQuery,
+		options:   map[string]*util.V{entriesKey: util.IntegerValue(50)},
+	}, {
+		queryName: logsQuery,
+		options:   map[string]*util.V{"color": util.IntegerValue(50)},
+		wantErr:   true,
+	}, {
+		queryName: treeQuery,
+		options:   map[string]*util.V{nodesKey: util.IntegerValue(-1)},
+		wantErr:   true,
+	}} {
+		t.Run(test.queryName, func(t *testing.T) {
+			build := func() (*util.Data, error) {
+				drb := util.NewDataResponseBuilder()
+				if err := NewDataSource().HandleDataSeriesRequests(context.Background(), nil, drb, []*util.DataSeriesRequest{{
+					QueryName:  test.queryName,
+					SeriesName: "series",
+					Options:    test.options,
+				}}); err != nil {
+					return nil, err
+				}
+				return drb.Data()
+			}
+			data, err := build()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("handling query yielded error %v, wanted error %t", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if err := validation.Validate(data); err != nil {
+				t.Errorf("query yielded malformed response: %s", err)
+			}
+			if _, ok := validation.Detect(data.DataSeries[0].Root, data.StringTable); !ok {
+				t.Errorf("query yielded a response of no well-known data model")
+			}
+			again, err := build()
+			if err != nil {
+				t.Fatalf("handling query again yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(data.PrettyPrint(), again.PrettyPrint()); diff != "" {
+				t.Errorf("query yielded different responses with the same options, diff (-first +second):\n%s", diff)
+			}
+		})
+	}
+}
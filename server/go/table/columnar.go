@@ -0,0 +1,207 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package table
+
+import (
+	"fmt"
+
+	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// ColumnarNode represents a table embedded in a TraceViz response whose rows
+// are encoded columnarly: rather than as a datum per row and a datum per
+// cell, the cells of each column are emitted as a single array, with the
+// i'th entry of each array belonging to the i'th row.  This drastically cuts
+// serialization cost for tables with many rows.  Columnar tables support only
+// string, integer, and double cells, which must not carry other properties,
+// and every row must have exactly one cell in every column; rows may not have
+// properties, payloads, or child rows, and cells may not be formatted.
+//
+// Encoded into the TraceViz data model, the rows of a columnar table are
+// replaced by a single datum:
+//
+//	columnar rows
+//	  properties
+//	    * nodeTypeKey: columnarRowsNodeType
+//	    * repeated columnKey:<column ID>:
+//	        StringsValue, IntegersValue, or DoublesValue (the column's cells)
+type ColumnarNode struct {
+	tableDb     util.DataBuilder
+	db          util.DataBuilder
+	columns     []*columnarColumn
+	columnsByID map[string]*columnarColumn
+	rowCount    int
+}
+
+// columnarColumn accumulates the cells of a column in a columnar table.
+type columnarColumn struct {
+	id  string
+	key string
+	// The value type of the column's cells, set by its first cell.  String
+	// index cells are recorded as strings.
+	t    int
+	ints []int64
+	dbls []float64
+}
+
+// Columnar switches the receiving table, which must not yet have any rows, to
+// the columnar encoding, returning a ColumnarNode to which rows may be added.
+func (n *Node) Columnar() *ColumnarNode {
+	ret := &ColumnarNode{
+		tableDb:     n.db,
+		db:          n.db.Child().With(util.IntegerProperty(nodeTypeKey, int64(columnarRowsNodeType))),
+		columnsByID: map[string]*columnarColumn{},
+	}
+	for _, column := range n.columns {
+		col := &columnarColumn{
+			id:  column.cat.ID(),
+			key: columnKey + ":" + column.cat.ID(),
+		}
+		ret.columns = append(ret.columns, col)
+		ret.columnsByID[col.id] = col
+	}
+	return ret
+}
+
+// With annotates the receiving table with the provided properties.
+func (cn *ColumnarNode) With(properties ...util.PropertyUpdate) *ColumnarNode {
+	cn.tableDb.With(properties...)
+	return cn
+}
+
+// Row adds a row with the provided cells, one per column, to the receiving
+// table.  Cells which cannot be columnarly encoded yield an error when the
+// response is built.
+func (cn *ColumnarNode) Row(cells ...CellUpdate) *ColumnarNode {
+	if err := cn.row(cells); err != nil {
+		cn.db.With(util.ErrorProperty(fmt.Errorf("columnar table row %d: %s", cn.rowCount, err)))
+	}
+	cn.rowCount++
+	return cn
+}
+
+func (cn *ColumnarNode) row(cells []CellUpdate) error {
+	if len(cells) != len(cn.columns) {
+		return fmt.Errorf("has %d cells, but the table has %d columns", len(cells), len(cn.columns))
+	}
+	seen := map[*columnarColumn]bool{}
+	for _, cell := range cells {
+		d, st, err := util.PropertyDatum(util.PropertyUpdate(cell))
+		if err != nil {
+			return err
+		}
+		if _, ok := d.Property(st, formattedCellKey); ok {
+			return fmt.Errorf("formatted cells are not supported")
+		}
+		v, ok := d.Property(st, cellKey)
+		tags := category.TagsOf(d, st)
+		if !ok || len(tags) != 1 || len(d.Properties) != 2 {
+			return fmt.Errorf("cells must hold only a value and a column tag")
+		}
+		col, ok := cn.columnsByID[tags[0]]
+		if !ok {
+			return fmt.Errorf("cell belongs to undefined column '%s'", tags[0])
+		}
+		if seen[col] {
+			return fmt.Errorf("has more than one cell in column '%s'", col.id)
+		}
+		seen[col] = true
+		if err := cn.add(col, v, st); err != nil {
+			return fmt.Errorf("column '%s': %s", col.id, err)
+		}
+	}
+	return nil
+}
+
+// add appends the provided cell value, whose string indices refer to the
+// provided string table, to the provided column.
+func (cn *ColumnarNode) add(col *columnarColumn, v *util.V, st []string) error {
+	t := int(v.T)
+	if v.T == util.StringIndexValueType {
+		t = int(util.StringValueType)
+	}
+	if cn.rowCount == 0 {
+		col.t = t
+	} else if t != col.t {
+		return fmt.Errorf("cell value type %d differs from the column's value type %d", t, col.t)
+	}
+	switch v.T {
+	case util.StringIndexValueType:
+		strIdx, _ := v.V.(int64)
+		cn.db.With(util.StringsPropertyExtended(col.key, st[strIdx]))
+	case util.StringValueType:
+		str, _ := v.V.(string)
+		cn.db.With(util.StringsPropertyExtended(col.key, str))
+	case util.IntegerValueType:
+		i, _ := v.V.(int64)
+		col.ints = append(col.ints, i)
+		cn.db.With(util.IntegersProperty(col.key, col.ints...))
+	case util.DoubleValueType:
+		f, _ := v.V.(float64)
+		col.dbls = append(col.dbls, f)
+		cn.db.With(util.DoublesProperty(col.key, col.dbls...))
+	default:
+		return fmt.Errorf("unsupported cell value type %d", v.T)
+	}
+	return nil
+}
+
+// isColumnarRows reports whether the provided Datum holds a columnar table's
+// rows.
+func isColumnarRows(d *util.Datum, st []string) bool {
+	nt, ok := d.PropertyNumber(st, nodeTypeKey)
+	return ok && tableNodeType(nt) == columnarRowsNodeType
+}
+
+// columnarCells returns the cells of each of the provided columns in the
+// provided columnar rows Datum, by column ID, and the number of rows, or an
+// error if a column's cells are malformed or the columns' lengths differ.
+func columnarCells(d *util.Datum, st []string, columnIDs []string) (map[string][]*util.V, int, error) {
+	ret := map[string][]*util.V{}
+	rowCount := -1
+	for _, colID := range columnIDs {
+		var cells []*util.V
+		if v, ok := d.Property(st, columnKey+":"+colID); ok {
+			switch v.T {
+			case util.StringIndicesValueType:
+				strIdxs, _ := v.V.([]int64)
+				for _, strIdx := range strIdxs {
+					cells = append(cells, util.StringIndexValue(strIdx))
+				}
+			case util.IntegersValueType:
+				ints, _ := v.V.([]int64)
+				for _, i := range ints {
+					cells = append(cells, util.IntegerValue(i))
+				}
+			case util.DoublesValueType:
+				dbls, _ := v.V.([]float64)
+				for _, f := range dbls {
+					cells = append(cells, util.DoubleValue(f))
+				}
+			default:
+				return nil, 0, fmt.Errorf("columnar table column '%s' has unsupported value type %d", colID, v.T)
+			}
+		}
+		if rowCount >= 0 && len(cells) != rowCount {
+			return nil, 0, fmt.Errorf("columnar table column '%s' has %d cells, but expected %d", colID, len(cells), rowCount)
+		}
+		rowCount = len(cells)
+		ret[colID] = cells
+	}
+	if rowCount < 0 {
+		rowCount = 0
+	}
+	return ret, rowCount, nil
+}
@@ -0,0 +1,255 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package table
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// WriteCSV writes the table rooted at the provided Datum, whose string indices
+// refer to the provided string table, to the provided Writer as delimited
+// text: a header line of column display names, then one line per row, with
+// child rows following their parents regardless of collapse state.  Fields
+// are separated by the specified delimiter, e.g. ',' for CSV or '\t' for TSV.
+// Formatted cells are expanded using their own properties; payloads are
+// omitted.  Fields beginning with '=', '+', '-', or '@' are prefixed with a
+// single quote, so that spreadsheet applications opening the output don't
+// evaluate them as formulas.
+func WriteCSV(w io.Writer, root *util.Datum, st []string, delimiter rune) error {
+	if len(root.Children) == 0 {
+		return fmt.Errorf("table has no column definitions")
+	}
+	var header, columnIDs []string
+	colIdxsByID := map[string]int{}
+	for _, colDef := range root.Children[0].Children {
+		cat, ok := category.Defined(colDef, st)
+		if !ok {
+			return fmt.Errorf("table column %d has no category", len(header))
+		}
+		colIdxsByID[cat.ID()] = len(header)
+		header = append(header, cat.DisplayName())
+		columnIDs = append(columnIDs, cat.ID())
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma = delimiter
+	if err := writeRecord(cw, header); err != nil {
+		return err
+	}
+	for _, row := range root.Children[1:] {
+		if isColumnarRows(row, st) {
+			if err := writeColumnarRows(cw, row, st, columnIDs); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeRow(cw, row, st, colIdxsByID, len(header)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeRow writes the provided row, then its child rows, to the provided
+// csv.Writer.
+func writeRow(cw *csv.Writer, row *util.Datum, st []string, colIdxsByID map[string]int, colCount int) error {
+	record := make([]string, colCount)
+	var childRows []*util.Datum
+	for _, cell := range row.Children {
+		if isChildRow(cell, st) {
+			childRows = append(childRows, cell)
+			continue
+		}
+		text, ok, err := cellText(cell, st)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// Not a cell; likely a payload.
+			continue
+		}
+		for _, catID := range category.TagsOf(cell, st) {
+			if colIdx, ok := colIdxsByID[catID]; ok {
+				record[colIdx] = text
+				break
+			}
+		}
+	}
+	if err := writeRecord(cw, record); err != nil {
+		return err
+	}
+	for _, childRow := range childRows {
+		if err := writeRow(cw, childRow, st, colIdxsByID, colCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeColumnarRows writes the rows of the provided columnar rows Datum, whose
+// cells belong to the provided columns in order, to the provided csv.Writer.
+func writeColumnarRows(cw *csv.Writer, d *util.Datum, st []string, columnIDs []string) error {
+	cells, rowCount, err := columnarCells(d, st, columnIDs)
+	if err != nil {
+		return err
+	}
+	for rowIdx := 0; rowIdx < rowCount; rowIdx++ {
+		record := make([]string, len(columnIDs))
+		for colIdx, colID := range columnIDs {
+			record[colIdx] = valueText(cells[colID][rowIdx], st)
+		}
+		if err := writeRecord(cw, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRecord writes the provided record to the provided csv.Writer, escaping
+// any fields that spreadsheet applications would evaluate as formulas.
+func writeRecord(cw *csv.Writer, record []string) error {
+	for idx, field := range record {
+		if field != "" && strings.ContainsRune("=+-@", rune(field[0])) {
+			record[idx] = "'" + field
+		}
+	}
+	return cw.Write(record)
+}
+
+// cellText returns the text of the provided cell Datum and true, or false if
+// the Datum is not a cell.
+func cellText(cell *util.Datum, st []string) (string, bool, error) {
+	if v, ok := cell.Property(st, cellKey); ok {
+		return valueText(v, st), true, nil
+	}
+	v, ok := cell.Property(st, formattedCellKey)
+	if !ok {
+		return "", false, nil
+	}
+	text, err := expandFormat(valueText(v, st), cell, st)
+	return text, true, err
+}
+
+// formatRefRE matches '$$' or '$(<property key>)' within a format string.
+var formatRefRE = regexp.MustCompile(`\$\$|\$\([a-zA-Z_\-0-9]+\)`)
+
+// formatRefs returns the property keys referenced by the provided format
+// string, in order of first reference.
+func formatRefs(format string) []string {
+	var ret []string
+	seen := map[string]bool{}
+	for _, ref := range formatRefRE.FindAllString(format, -1) {
+		if ref == "$$" {
+			continue
+		}
+		if key := ref[2 : len(ref)-1]; !seen[key] {
+			seen[key] = true
+			ret = append(ret, key)
+		}
+	}
+	return ret
+}
+
+// expandFormat expands the provided format string, as used in formatted cells,
+// with the properties of the provided Datum.
+func expandFormat(format string, d *util.Datum, st []string) (string, error) {
+	var err error
+	ret := formatRefRE.ReplaceAllStringFunc(format, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		key := ref[2 : len(ref)-1]
+		v, ok := d.Property(st, key)
+		if !ok {
+			err = fmt.Errorf("format string '%s' refers to missing property '%s'", format, key)
+			return ""
+		}
+		return valueText(v, st)
+	})
+	return ret, err
+}
+
+// valueText returns a textual rendering of the provided value.
+func valueText(v *util.V, st []string) string {
+	str := func(strIdx int64) string {
+		if strIdx < 0 || strIdx >= int64(len(st)) {
+			return ""
+		}
+		return st[strIdx]
+	}
+	switch v.T {
+	case util.StringValueType:
+		s, _ := v.V.(string)
+		return s
+	case util.StringIndexValueType:
+		strIdx, _ := v.V.(int64)
+		return str(strIdx)
+	case util.StringsValueType:
+		strs, _ := v.V.([]string)
+		return strings.Join(strs, ", ")
+	case util.StringIndicesValueType:
+		strIdxs, _ := v.V.([]int64)
+		strs := make([]string, len(strIdxs))
+		for idx, strIdx := range strIdxs {
+			strs[idx] = str(strIdx)
+		}
+		return strings.Join(strs, ", ")
+	case util.IntegerValueType:
+		i, _ := v.V.(int64)
+		return strconv.FormatInt(i, 10)
+	case util.IntegersValueType:
+		ints, _ := v.V.([]int64)
+		strs := make([]string, len(ints))
+		for idx, i := range ints {
+			strs[idx] = strconv.FormatInt(i, 10)
+		}
+		return strings.Join(strs, ", ")
+	case util.DoubleValueType:
+		f, _ := v.V.(float64)
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	case util.DoublesValueType:
+		dbls, _ := v.V.([]float64)
+		strs := make([]string, len(dbls))
+		for idx, f := range dbls {
+			strs[idx] = strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		return strings.Join(strs, ", ")
+	case util.DurationValueType:
+		dur, _ := v.V.(time.Duration)
+		return dur.String()
+	case util.TimestampValueType:
+		ts, err := util.ExpectTimestampValue(v)
+		if err != nil {
+			return ""
+		}
+		return ts.UTC().Format(time.RFC3339Nano)
+	case util.ReferenceValueType:
+		ref, err := util.ExpectReferenceValue(v, st)
+		if err != nil {
+			return ""
+		}
+		return ref.String()
+	default:
+		return ""
+	}
+}
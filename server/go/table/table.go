@@ -31,13 +31,29 @@
 //
 //	payloadDb := row.Payload(payloadName) // or cell.Payload(payloadName)
 //
+// Rows may also have child rows, forming an expandable hierarchy, via
+//
+//	childRow := row.Row(...<Cell() or FormattedCell()>)
+//
+// and may be marked as collapsed, hiding their child rows until expanded, via
+//
+//	row.Collapsed()
+//
+// Tables with many simple rows may instead be encoded columnarly, with each
+// column's cells emitted as a single array, via
+//
+//	columnar := table.Columnar()
+//	columnar.Row(...<Cell()>)
+//
+// See columnar.go for more detail.
+//
 // The structure of a table in a TraceViz response, with each level
 // representing a DataSeries or nested Datum is:
 //
 //	table
 //	  children:
 //	    * header row
-//	    * repeated rows
+//	    * repeated rows, or a single columnar rows datum (see columnar.go)
 //
 //	header row
 //	  children
@@ -50,9 +66,19 @@
 //
 //	row
 //	  properties
+//	    * collapsedKey: IntegerValue (1 if child rows are initially hidden)
+//	    * repeated matchRangesKey:<column ID>[:<property key>]:
+//	        IntegersValue (search match ranges, as [start, end) pairs)
 //	    * <decorators>
 //	  children
-//	    * repeated cells, formatted cells and payloads
+//	    * repeated cells, formatted cells, child rows, and payloads
+//
+//	child row
+//	  properties
+//	    * nodeTypeKey: rowNodeType
+//	    * <as row>
+//	  children
+//	    * <as row>
 //
 //	cell
 //	  properties
@@ -79,16 +105,24 @@
 package table
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/util"
 )
 
 const (
-	cellKey          = "table_cell"
-	formattedCellKey = "table_formatted_cell"
+	cellKey          = keys.TableCell
+	formattedCellKey = keys.TableFormattedCell
+	nodeTypeKey      = keys.TableNodeType
+	collapsedKey     = keys.TableRowCollapsed
+	matchRangesKey   = keys.TableMatchRanges
+	columnKey        = keys.TableColumn
 
-	rowHeightPxKey = "table_row_height_px"
-	fontSizePxKey  = "table_font_size_px"
+	rowHeightPxKey = keys.TableRowHeightPx
+	fontSizePxKey  = keys.TableFontSizePx
 )
 
 // RenderSettings is a collection of rendering settings for trees.
@@ -154,8 +188,13 @@ func Cell(column *ColumnUpdate, value util.Value, cellUpdates ...util.PropertyUp
 // the column specified by the provided columnID and holding the specified
 // string value, which should be interpreted as a format string.  Any specified
 // PropertyUpdates, such as those referenced in the format string, are also
-// applied.
+// applied.  The PropertyUpdates must set exactly the properties referenced in
+// the format string; if any referenced property is missing, or any set
+// property is unreferenced, the returned CellUpdate yields an error.
 func FormattedCell(column *ColumnUpdate, value string, cellUpdates ...util.PropertyUpdate) CellUpdate {
+	if err := checkFormat(value, cellUpdates...); err != nil {
+		return CellUpdate(util.ErrorProperty(err))
+	}
 	cellUpdates = append(cellUpdates,
 		column.cat.Tag(),
 		util.StringProperty(formattedCellKey, value),
@@ -163,9 +202,77 @@ func FormattedCell(column *ColumnUpdate, value string, cellUpdates ...util.Prope
 	return CellUpdate(util.Chain(cellUpdates...))
 }
 
+// checkFormat returns an error if the provided updates do not set exactly the
+// properties referenced by the provided format string.  Errors yielded by the
+// updates themselves are left to be reported when they are applied.
+func checkFormat(format string, updates ...util.PropertyUpdate) error {
+	provided, err := util.PropertyKeys(updates...)
+	if err != nil {
+		return nil
+	}
+	referenced := map[string]bool{}
+	for _, key := range formatRefs(format) {
+		referenced[key] = true
+	}
+	var unused []string
+	for _, key := range provided {
+		if !referenced[key] {
+			unused = append(unused, key)
+		}
+		delete(referenced, key)
+	}
+	missing := make([]string, 0, len(referenced))
+	for key := range referenced {
+		missing = append(missing, key)
+	}
+	sort.Strings(missing)
+	switch {
+	case len(missing) > 0 && len(unused) > 0:
+		return fmt.Errorf("format string '%s' refers to missing properties %v, and properties %v are unused", format, missing, unused)
+	case len(missing) > 0:
+		return fmt.Errorf("format string '%s' refers to missing properties %v", format, missing)
+	case len(unused) > 0:
+		return fmt.Errorf("format string '%s' does not use properties %v", format, unused)
+	}
+	return nil
+}
+
+// MatchRange is a half-open range [Start, End) of byte offsets within a
+// cell's text that matched an active search.
+type MatchRange struct {
+	Start, End int
+}
+
+func matchRanges(key string, ranges []MatchRange) util.PropertyUpdate {
+	offsets := make([]int64, 0, 2*len(ranges))
+	for _, r := range ranges {
+		if r.Start < 0 || r.End < r.Start {
+			return util.ErrorProperty(fmt.Errorf("invalid match range [%d, %d)", r.Start, r.End))
+		}
+		offsets = append(offsets, int64(r.Start), int64(r.End))
+	}
+	return util.IntegersProperty(key, offsets...)
+}
+
+// MatchRanges returns a PropertyUpdate which, applied to a row, marks the
+// provided ranges of the value of the row's cell in the specified column as
+// matching an active search, so that they may be highlighted without
+// searching again on the client.
+func MatchRanges(column *ColumnUpdate, ranges ...MatchRange) util.PropertyUpdate {
+	return matchRanges(matchRangesKey+":"+column.cat.ID(), ranges)
+}
+
+// PropertyMatchRanges is like MatchRanges, but for formatted cells: the
+// provided ranges are within the value of the specified property referenced
+// by the cell's format string.
+func PropertyMatchRanges(column *ColumnUpdate, key string, ranges ...MatchRange) util.PropertyUpdate {
+	return matchRanges(matchRangesKey+":"+column.cat.ID()+":"+key, ranges)
+}
+
 // Node represents a table embedded in a TraceViz response.
 type Node struct {
-	db util.DataBuilder
+	db      util.DataBuilder
+	columns []*ColumnUpdate
 }
 
 // With annotates the receiving table with the provided properties.
@@ -183,10 +290,22 @@ func New(db util.DataBuilder, renderSettings *RenderSettings, columns ...*Column
 	}
 	db.With(renderSettings.define())
 	return &Node{
-		db: db,
+		db:      db,
+		columns: columns,
 	}
 }
 
+type tableNodeType int64
+
+const (
+	// rowNodeType marks a child row, distinguishing it from its parent row's
+	// cells and payloads.
+	rowNodeType tableNodeType = iota
+	// columnarRowsNodeType marks the datum holding all rows of a columnar
+	// table.  See columnar.go.
+	columnarRowsNodeType
+)
+
 // RowNode represents a row embedded in a TraceViz response.
 type RowNode struct {
 	db util.DataBuilder
@@ -199,7 +318,10 @@ type RowNode struct {
 // If this is required -- e.g., for nested tables -- outer tables must be
 // explicitly created.
 func (n *Node) Row(cells ...CellUpdate) *RowNode {
-	db := n.db.Child()
+	return row(n.db.Child(), cells...)
+}
+
+func row(db util.DataBuilder, cells ...CellUpdate) *RowNode {
 	for _, cell := range cells {
 		db.Child().With(util.PropertyUpdate(cell))
 	}
@@ -214,6 +336,20 @@ func (rn *RowNode) With(properties ...util.PropertyUpdate) *RowNode {
 	return rn
 }
 
+// Row adds a new child row to the receiving row, then adds the specified
+// cells as children to that new row, returning the new row.  Child rows are
+// shown beneath their parent, and may be hidden by collapsing it.
+func (rn *RowNode) Row(cells ...CellUpdate) *RowNode {
+	return row(rn.db.Child().With(util.IntegerProperty(nodeTypeKey, int64(rowNodeType))), cells...)
+}
+
+// Collapsed marks the receiving row as collapsed, so that its child rows are
+// hidden until it is expanded.
+func (rn *RowNode) Collapsed() *RowNode {
+	rn.db.With(util.IntegerProperty(collapsedKey, 1))
+	return rn
+}
+
 // CellNode is a table cell to which payloads and properties may be attached.
 type CellNode struct {
 	db util.DataBuilder
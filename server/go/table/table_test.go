@@ -14,8 +14,11 @@
 package table
 
 import (
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/traceviz/server/go/category"
 	"github.com/google/traceviz/server/go/payload"
 	testutil "github.com/google/traceviz/server/go/test_util"
@@ -145,6 +148,91 @@ func TestColumns(t *testing.T) {
 				nameCol.cat.Tag(),
 				util.StringProperty(formattedCellKey, "thing"),
 			)
+		},
+	}, {
+		description: "match ranges",
+		buildTabular: func(db util.DataBuilder) {
+			New(db, nil, nameCol, puzzleCol).Row(
+				Cell(nameCol, util.String("banana")),
+				FormattedCell(puzzleCol, "Find $(word)", util.StringProperty("word", "an")),
+			).With(
+				MatchRanges(nameCol, MatchRange{1, 3}, MatchRange{3, 5}),
+				PropertyMatchRanges(puzzleCol, "word", MatchRange{0, 2}),
+			)
+		},
+		buildExplicit: func(db testutil.TestDataBuilder) {
+			db.Child(). // column definitions
+					Child().With(nameCol.cat.Define()).
+					AndChild().With(puzzleCol.cat.Define())
+			db.Child().With( // row 0
+				util.IntegersProperty(matchRangesKey+":name", 1, 3, 3, 5),
+				util.IntegersProperty(matchRangesKey+":puzzle:word", 0, 2),
+			).Child().With( // row 0 cell 0
+				nameCol.cat.Tag(),
+				util.StringProperty(cellKey, "banana"),
+			).AndChild().With( // row 0 cell 1
+				puzzleCol.cat.Tag(),
+				util.StringProperty(formattedCellKey, "Find $(word)"),
+				util.StringProperty("word", "an"),
+			)
+		},
+	}, {
+		description: "child rows",
+		buildTabular: func(db util.DataBuilder) {
+			row := New(db, nil, nameCol, answerCol).Row(
+				Cell(nameCol, util.String("a.cc")),
+				Cell(answerCol, util.Integer(3)),
+			).Collapsed()
+			row.Row(
+				Cell(nameCol, util.String("a.cc:10")),
+				Cell(answerCol, util.Integer(1)),
+			)
+			row.Row(
+				Cell(nameCol, util.String("a.cc:20")),
+				Cell(answerCol, util.Integer(2)),
+			).Row(
+				Cell(nameCol, util.String("a.cc:20 (detail)")),
+			)
+		},
+		buildExplicit: func(db testutil.TestDataBuilder) {
+			db.Child(). // column definitions
+					Child().With(nameCol.cat.Define()).
+					AndChild().With(answerCol.cat.Define())
+			row := db.Child().With( // row 0
+				util.IntegerProperty(collapsedKey, 1),
+			)
+			row.Child().With( // row 0 cell 0
+				nameCol.cat.Tag(),
+				util.StringProperty(cellKey, "a.cc"),
+			).AndChild().With( // row 0 cell 1
+				answerCol.cat.Tag(),
+				util.IntegerProperty(cellKey, 3),
+			)
+			row.Child().With( // row 0 child row 0
+				util.IntegerProperty(nodeTypeKey, int64(rowNodeType)),
+			).Child().With( // row 0 child row 0 cell 0
+				nameCol.cat.Tag(),
+				util.StringProperty(cellKey, "a.cc:10"),
+			).AndChild().With( // row 0 child row 0 cell 1
+				answerCol.cat.Tag(),
+				util.IntegerProperty(cellKey, 1),
+			)
+			childRow := row.Child().With( // row 0 child row 1
+				util.IntegerProperty(nodeTypeKey, int64(rowNodeType)),
+			)
+			childRow.Child().With( // row 0 child row 1 cell 0
+				nameCol.cat.Tag(),
+				util.StringProperty(cellKey, "a.cc:20"),
+			).AndChild().With( // row 0 child row 1 cell 1
+				answerCol.cat.Tag(),
+				util.IntegerProperty(cellKey, 2),
+			)
+			childRow.Child().With( // row 0 child row 1 child row 0
+				util.IntegerProperty(nodeTypeKey, int64(rowNodeType)),
+			).Child().With( // row 0 child row 1 child row 0 cell 0
+				nameCol.cat.Tag(),
+				util.StringProperty(cellKey, "a.cc:20 (detail)"),
+			)
 		}}} {
 		t.Run(test.description, func(t *testing.T) {
 			if err := testutil.CompareResponses(t, test.buildTabular, test.buildExplicit); err != nil {
@@ -153,3 +241,217 @@ func TestColumns(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteCSV(t *testing.T) {
+	drb := util.NewDataResponseBuilder()
+	tab := New(drb.DataSeries(&util.DataSeriesRequest{}), renderSettings, puzzleCol, answerCol, hintCol)
+	tab.Row(
+		Cell(puzzleCol, util.String("I in a F")),
+		Cell(answerCol, util.Integer(12)),
+		Cell(hintCol, util.String(`"length", mostly`)),
+	)
+	row := tab.Row(
+		Cell(answerCol, util.Duration(90*time.Second)),
+		FormattedCell(puzzleCol, "$(count) $$ in a $(unit)",
+			util.IntegerProperty("count", 100),
+			util.StringProperty("unit", "D"),
+		),
+	)
+	payload.New(row, "details").With(util.StringProperty("puzzle", "ignored"))
+	row.Collapsed().Row(
+		Cell(puzzleCol, util.String("10 D in a C")),
+	)
+	// Fields that spreadsheets would evaluate as formulas are escaped.
+	tab.Row(
+		Cell(puzzleCol, util.String("=HYPERLINK(\"http://example.com\")")),
+		Cell(answerCol, util.Integer(-1)),
+		Cell(hintCol, util.String("@SUM(1+1)")),
+	)
+	tab.Row(
+		Cell(puzzleCol, util.String("+1 in a row")),
+		Cell(hintCol, util.String("a=b")),
+	)
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	for _, test := range []struct {
+		description string
+		delimiter   rune
+		want        string
+	}{{
+		description: "csv",
+		delimiter:   ',',
+		want: `Puzzle,Answer,Hint
+I in a F,12,"""length"", mostly"
+100 $ in a D,1m30s,
+10 D in a C,,
+"'=HYPERLINK(""http://example.com"")",'-1,'@SUM(1+1)
+'+1 in a row,,a=b
+`,
+	}, {
+		description: "tsv",
+		delimiter:   '\t',
+		want: "Puzzle\tAnswer\tHint\n" +
+			"I in a F\t12\t\"\"\"length\"\", mostly\"\n" +
+			"100 $ in a D\t1m30s\t\n" +
+			"10 D in a C\t\t\n" +
+			"\"'=HYPERLINK(\"\"http://example.com\"\")\"\t'-1\t'@SUM(1+1)\n" +
+			"'+1 in a row\t\ta=b\n",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			buf := &strings.Builder{}
+			if err := WriteCSV(buf, data.DataSeries[0].Root, data.StringTable, test.delimiter); err != nil {
+				t.Fatalf("WriteCSV() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.want, buf.String()); diff != "" {
+				t.Errorf("WriteCSV() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFormattedCellValidation(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		format      string
+		updates     []util.PropertyUpdate
+		wantErr     bool
+	}{{
+		description: "all references supplied",
+		format:      "$(first_name) $(last_name) $$ $(first_name)",
+		updates: []util.PropertyUpdate{
+			util.StringProperty("first_name", "Jane"),
+			util.StringProperty("last_name", "Doe"),
+		},
+	}, {
+		description: "no references",
+		format:      "$$5",
+	}, {
+		description: "missing reference",
+		format:      "$(first_name) $(last_name)",
+		updates: []util.PropertyUpdate{
+			util.StringProperty("first_name", "Jane"),
+		},
+		wantErr: true,
+	}, {
+		description: "unused property",
+		format:      "$(first_name)",
+		updates: []util.PropertyUpdate{
+			util.StringProperty("first_name", "Jane"),
+			util.StringProperty("last_name", "Doe"),
+		},
+		wantErr: true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := util.NewDataResponseBuilder()
+			New(drb.DataSeries(&util.DataSeriesRequest{}), nil, nameCol).Row(
+				FormattedCell(nameCol, test.format, test.updates...),
+			)
+			if _, err := drb.Data(); (err != nil) != test.wantErr {
+				t.Errorf("Data() yielded error %v, wanted error: %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestColumnar(t *testing.T) {
+	scoreCol := Column(category.New("score", "Score", "How well did we do?"))
+	buildColumnar := func(db util.DataBuilder) {
+		New(db, renderSettings, puzzleCol, answerCol, scoreCol).Columnar().Row(
+			Cell(puzzleCol, util.String("I in a F")),
+			Cell(answerCol, util.Integer(12)),
+			Cell(scoreCol, util.Double(.5)),
+		).Row(
+			Cell(scoreCol, util.Double(1)),
+			Cell(answerCol, util.Integer(100)),
+			Cell(puzzleCol, util.String("D in a C")),
+		)
+	}
+	buildExplicit := func(db testutil.TestDataBuilder) {
+		db.With(renderSettings.define()).Child().
+			Child().With(puzzleCol.define()).
+			AndChild().With(answerCol.define()).
+			AndChild().With(scoreCol.define())
+		db.Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(columnarRowsNodeType)),
+			util.StringsProperty(columnKey+":puzzle", "I in a F", "D in a C"),
+			util.IntegersProperty(columnKey+":answer", 12, 100),
+			util.DoublesProperty(columnKey+":score", .5, 1),
+		)
+	}
+	if err := testutil.CompareResponses(t, buildColumnar, buildExplicit); err != nil {
+		t.Fatalf("encountered unexpected error building the table: %s", err)
+	}
+	drb := util.NewDataResponseBuilder()
+	buildColumnar(drb.DataSeries(&util.DataSeriesRequest{}))
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	root := data.DataSeries[0].Root
+	if err := Validate(root, data.StringTable); err != nil {
+		t.Errorf("Validate() yielded unexpected error %s", err)
+	}
+	buf := &strings.Builder{}
+	if err := WriteCSV(buf, root, data.StringTable, ','); err != nil {
+		t.Fatalf("WriteCSV() yielded unexpected error %s", err)
+	}
+	wantCSV := "Puzzle,Answer,Score\nI in a F,12,0.5\nD in a C,100,1\n"
+	if diff := cmp.Diff(wantCSV, buf.String()); diff != "" {
+		t.Errorf("WriteCSV() diff (-want +got):\n%s", diff)
+	}
+	for _, test := range []struct {
+		description string
+		cells       [][]CellUpdate
+	}{{
+		description: "missing cell",
+		cells: [][]CellUpdate{{
+			Cell(puzzleCol, util.String("I in a F")),
+		}},
+	}, {
+		description: "repeated column",
+		cells: [][]CellUpdate{{
+			Cell(puzzleCol, util.String("I in a F")),
+			Cell(puzzleCol, util.String("D in a C")),
+		}},
+	}, {
+		description: "mismatched value types",
+		cells: [][]CellUpdate{{
+			Cell(puzzleCol, util.String("I in a F")),
+			Cell(answerCol, util.Integer(12)),
+		}, {
+			Cell(puzzleCol, util.String("D in a C")),
+			Cell(answerCol, util.String("100")),
+		}},
+	}, {
+		description: "unsupported value type",
+		cells: [][]CellUpdate{{
+			Cell(puzzleCol, util.String("I in a F")),
+			Cell(answerCol, util.Duration(time.Second)),
+		}},
+	}, {
+		description: "formatted cell",
+		cells: [][]CellUpdate{{
+			Cell(puzzleCol, util.String("I in a F")),
+			FormattedCell(answerCol, "$(n)", util.IntegerProperty("n", 12)),
+		}},
+	}, {
+		description: "cell with decorators",
+		cells: [][]CellUpdate{{
+			Cell(puzzleCol, util.String("I in a F")),
+			Cell(answerCol, util.Integer(12), util.StringProperty("color", "red")),
+		}},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := util.NewDataResponseBuilder()
+			tab := New(drb.DataSeries(&util.DataSeriesRequest{}), nil, puzzleCol, answerCol).Columnar()
+			for _, row := range test.cells {
+				tab.Row(row...)
+			}
+			if _, err := drb.Data(); err == nil {
+				t.Errorf("Data() yielded no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package table
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/payload"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Detect reports whether the provided Datum, whose string indices refer to the
+// provided string table, appears to be the root of a table: that is, whether
+// its first child holds only column definitions.
+func Detect(root *util.Datum, st []string) bool {
+	if len(root.Children) == 0 || len(root.Children[0].Children) == 0 {
+		return false
+	}
+	for _, colDef := range root.Children[0].Children {
+		if _, ok := category.Defined(colDef, st); !ok || len(colDef.Children) > 0 {
+			return false
+		}
+		if _, ok := continuousaxis.DefinedPositioner(colDef, st); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks that the table rooted at the provided Datum, whose string
+// indices refer to the provided string table, conforms to the table data
+// model: that its columns are uniquely defined, and that each row holds only
+// payloads, child rows, and cells, each of which belongs to a defined column
+// and holds either a value or a format string; and that any match ranges
+// annotate defined columns.  For columnar tables, it checks that the columnar
+// rows hold only well-formed cell arrays of equal length for defined columns.
+func Validate(root *util.Datum, st []string) error {
+	if len(root.Children) == 0 || len(root.Children[0].Children) == 0 {
+		return fmt.Errorf("table has no column definitions")
+	}
+	columns := map[string]bool{}
+	var columnIDs []string
+	for colIdx, colDef := range root.Children[0].Children {
+		cat, ok := category.Defined(colDef, st)
+		if !ok {
+			return fmt.Errorf("table column %d has no category", colIdx)
+		}
+		if columns[cat.ID()] {
+			return fmt.Errorf("table column '%s' is defined more than once", cat.ID())
+		}
+		columns[cat.ID()] = true
+		columnIDs = append(columnIDs, cat.ID())
+	}
+	for rowIdx, row := range root.Children[1:] {
+		if isColumnarRows(row, st) {
+			if len(root.Children) != 2 {
+				return fmt.Errorf("columnar table has rows besides its columnar rows")
+			}
+			return validateColumnarRows(row, st, columns, columnIDs)
+		}
+		if err := validateRow(row, st, columns, fmt.Sprintf("table row %d", rowIdx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isChildRow reports whether the provided Datum is a child row.
+func isChildRow(d *util.Datum, st []string) bool {
+	nt, ok := d.PropertyNumber(st, nodeTypeKey)
+	return ok && tableNodeType(nt) == rowNodeType
+}
+
+// validateRow checks that the provided row, and any child rows beneath it,
+// hold only payloads, child rows, and cells belonging to the provided columns.
+// desc describes the row in returned errors.
+func validateRow(row *util.Datum, st []string, columns map[string]bool, desc string) error {
+	if _, ok := row.Property(st, collapsedKey); ok {
+		if _, ok := row.PropertyNumber(st, collapsedKey); !ok {
+			return fmt.Errorf("%s has a non-numeric collapsed flag", desc)
+		}
+	}
+	for keyIdx, v := range row.Properties {
+		if keyIdx < 0 || keyIdx >= int64(len(st)) || !strings.HasPrefix(st[keyIdx], matchRangesKey+":") {
+			continue
+		}
+		colID, _, _ := strings.Cut(strings.TrimPrefix(st[keyIdx], matchRangesKey+":"), ":")
+		if !columns[colID] {
+			return fmt.Errorf("%s has match ranges for undefined column '%s'", desc, colID)
+		}
+		if offsets, err := util.ExpectIntegersValue(v); err != nil || len(offsets)%2 != 0 {
+			return fmt.Errorf("%s has malformed match ranges for column '%s'", desc, colID)
+		}
+	}
+	childRowIdx := 0
+	for cellIdx, cell := range row.Children {
+		if _, ok := payload.TypeOf(cell, st); ok {
+			continue
+		}
+		if isChildRow(cell, st) {
+			if err := validateRow(cell, st, columns, fmt.Sprintf("%s child row %d", desc, childRowIdx)); err != nil {
+				return err
+			}
+			childRowIdx++
+			continue
+		}
+		_, isCell := cell.Property(st, cellKey)
+		_, isFormatted := cell.Property(st, formattedCellKey)
+		if isCell == isFormatted {
+			return fmt.Errorf("%s cell %d must have exactly one of a value or a format string", desc, cellIdx)
+		}
+		if isFormatted {
+			if _, ok := cell.PropertyString(st, formattedCellKey); !ok {
+				return fmt.Errorf("%s cell %d has a non-string format", desc, cellIdx)
+			}
+		}
+		inColumn := false
+		for _, catID := range category.TagsOf(cell, st) {
+			inColumn = inColumn || columns[catID]
+		}
+		if !inColumn {
+			return fmt.Errorf("%s cell %d belongs to no defined column", desc, cellIdx)
+		}
+		for childIdx, child := range cell.Children {
+			if _, ok := payload.TypeOf(child, st); !ok {
+				return fmt.Errorf("%s cell %d child %d is not a payload", desc, cellIdx, childIdx)
+			}
+		}
+	}
+	return nil
+}
+
+// validateColumnarRows checks that the provided columnar rows Datum holds
+// only well-formed, equal-length cell arrays for the provided columns.
+func validateColumnarRows(d *util.Datum, st []string, columns map[string]bool, columnIDs []string) error {
+	for keyIdx := range d.Properties {
+		if keyIdx < 0 || keyIdx >= int64(len(st)) {
+			return fmt.Errorf("columnar table has property with out-of-range key")
+		}
+		key := st[keyIdx]
+		if key == nodeTypeKey {
+			continue
+		}
+		colID, ok := strings.CutPrefix(key, columnKey+":")
+		if !ok || !columns[colID] {
+			return fmt.Errorf("columnar table has unexpected property '%s'", key)
+		}
+	}
+	if len(d.Children) > 0 {
+		return fmt.Errorf("columnar table rows may not have children")
+	}
+	_, _, err := columnarCells(d, st, columnIDs)
+	return err
+}
@@ -0,0 +1,88 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package testutil
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var update = flag.Bool("update", false, "If true, golden files are rewritten with the responses under test rather than compared against them")
+
+// GoldenText returns the stable, prettyprinted text form in which the
+// provided response, which must be a *util.DataResponseBuilder or a
+// *util.Data, is stored in golden files.
+func GoldenText(got any) (string, error) {
+	data, err := dataOf(got)
+	if err != nil {
+		return "", err
+	}
+	return data.PrettyPrint() + "\n", nil
+}
+
+// WriteGolden writes the provided response, which must be a
+// *util.DataResponseBuilder or a *util.Data, to the golden file at the
+// provided path, creating its directory if necessary.
+func WriteGolden(path string, got any) error {
+	text, err := GoldenText(got)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(text), 0644)
+}
+
+// goldenDiff returns the difference between the provided response and the
+// golden file at the provided path, or the empty string if there is none.
+func goldenDiff(path string, got any) (string, error) {
+	gotText, err := GoldenText(got)
+	if err != nil {
+		return "", err
+	}
+	wantText, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read golden file (rerun with -update to create it): %w", err)
+	}
+	return cmp.Diff(string(wantText), gotText), nil
+}
+
+// CompareGolden compares the provided response, which must be a
+// *util.DataResponseBuilder or a *util.Data, with the golden file at the
+// provided path, conventionally under the package's 'testdata' directory.  If
+// the two differ, raises an error on the provided testing.T object.  If the
+// test is run with the -update flag, the golden file is instead rewritten
+// with the provided response, so that changes to large responses can be
+// reviewed as golden file diffs.  If another problem is encountered, returns
+// it as an error.
+func CompareGolden(t *testing.T, path string, got any) error {
+	t.Helper()
+	if *update {
+		return WriteGolden(path, got)
+	}
+	diff, err := goldenDiff(path, got)
+	if err != nil {
+		return err
+	}
+	if diff != "" {
+		t.Errorf("Got data differing from golden file %s (rerun with -update to accept), diff (-want, +got) %s", path, diff)
+	}
+	return nil
+}
@@ -78,6 +78,7 @@ func (uc *UpdateComparator) Compare(t *testing.T) (string, bool) {
 type TestDataBuilder interface {
 	With(updates ...util.PropertyUpdate) TestDataBuilder
 	Child() TestDataBuilder
+	ChildNamed(name string) TestDataBuilder
 	AndChild() TestDataBuilder
 	Parent() TestDataBuilder
 }
@@ -107,6 +108,16 @@ func (tdb *testDataBuilder) Child() TestDataBuilder {
 	}
 }
 
+// ChildNamed returns a DataBuilder for the receiver's child with the provided
+// name, adding that child if it does not yet exist.  It supports chaining.
+func (tdb *testDataBuilder) ChildNamed(name string) TestDataBuilder {
+	db := tdb.db.ChildNamed(name)
+	return &testDataBuilder{
+		db:     db,
+		parent: tdb,
+	}
+}
+
 // AndChild adds a sibling datum, adding a new Datum to the receiver's parent
 // and returning a DataBuilder for that new Datum.  If the receiver has no parent,
 // adds a child to the receiver.
@@ -14,6 +14,7 @@
 package testutil
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/google/traceviz/server/go/util"
@@ -79,3 +80,30 @@ func TestUpdateComparator(t *testing.T) {
 		})
 	}
 }
+
+func goldenResponse(greeting string) *util.DataResponseBuilder {
+	drb := util.NewDataResponseBuilder()
+	series := drb.DataSeries(&util.DataSeriesRequest{SeriesName: "greetings"})
+	series.With(util.StringProperty("greeting", greeting))
+	series.Child().With(util.IntegerProperty("tuba_count", 5))
+	return drb
+}
+
+func TestGolden(t *testing.T) {
+	if err := CompareGolden(t, filepath.Join("testdata", "greetings.golden"), goldenResponse("hello")); err != nil {
+		t.Fatalf("CompareGolden() yielded unexpected error %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "new", "greetings.golden")
+	if _, err := goldenDiff(path, goldenResponse("hello")); err == nil {
+		t.Errorf("goldenDiff() with a missing golden file yielded no error, but expected one")
+	}
+	if err := WriteGolden(path, goldenResponse("hello")); err != nil {
+		t.Fatalf("WriteGolden() yielded unexpected error %s", err)
+	}
+	if diff, err := goldenDiff(path, goldenResponse("hello")); err != nil || diff != "" {
+		t.Errorf("goldenDiff() with a matching response = '%s', %v; want no difference", diff, err)
+	}
+	if diff, err := goldenDiff(path, goldenResponse("howdy, partner!")); err != nil || diff == "" {
+		t.Errorf("goldenDiff() with a differing response = '%s', %v; want a difference", diff, err)
+	}
+}
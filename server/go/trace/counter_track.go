@@ -0,0 +1,44 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/payload"
+	"github.com/google/traceviz/server/go/util"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+// CounterTrackPayloadType is the payload type of counter tracks.
+const CounterTrackPayloadType = "counter_track"
+
+// NewCounterTrack adds a counter track, showing overtime numeric series such
+// as CPU utilization, memory use, or queue depth, as a new subcategory,
+// specified by trackCat, of the provided Category.  The subcategory contains a
+// single span covering the trace's axis, carrying a CounterTrackPayloadType
+// payload: an xy chart whose x axis is the trace's axis and whose y axis is
+// the provided one.  The chart is returned, so that series may be added to it:
+//
+//	cpu := trace.NewCounterTrack(process, cpuCat, continuousaxis.NewDoubleAxis(utilCat, 0, 100))
+//	cpu.AddSeries(utilCat).WithPoint(t0, 12.5).WithPoint(t1, 80)
+//
+// The provided properties annotate the subcategory.
+func NewCounterTrack[T int64 | float64 | time.Duration | time.Time, Y int64 | float64 | time.Duration | time.Time](parent *Category[T], trackCat *category.Category, yAxis *continuousaxis.Axis[Y], properties ...util.PropertyUpdate) *xychart.XYChart[T, Y] {
+	min, max := parent.axis.Extents()
+	span := parent.Category(trackCat, properties...).Span(min, max)
+	return xychart.New(payload.New(span, CounterTrackPayloadType), parent.axis, yAxis)
+}
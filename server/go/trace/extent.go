@@ -0,0 +1,175 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Extent-checking property keys.  These are only set on traces whose
+// ExtentPolicy is ClampExtents.
+const (
+	clippedStartKey = keys.SpanClippedStart
+	clippedEndKey   = keys.SpanClippedEnd
+)
+
+// ExtentPolicy specifies how a Trace handles spans and subspans whose extents
+// lie partly or wholly outside its axis.  Such spans are otherwise emitted
+// as-is, and render incorrectly.
+type ExtentPolicy int
+
+const (
+	// UncheckedExtents emits spans as-is, regardless of the axis extent.  This
+	// is the default.
+	UncheckedExtents ExtentPolicy = iota
+	// ClampExtents clamps span endpoints lying outside the axis extent to the
+	// nearest axis extreme, and annotates each clamped endpoint with the
+	// 'span_clipped_start' or 'span_clipped_end' property, so that frontends can indicate
+	// that the span continues beyond the visible trace.
+	ClampExtents
+	// RejectExtents emits spans as-is, but records an error describing the
+	// first span lying outside the axis extent, which is returned by
+	// Trace.Err.
+	RejectExtents
+)
+
+// extentChecker applies an ExtentPolicy to spans as they are created.  Its
+// methods are nil-safe, so that checking may be skipped under
+// UncheckedExtents.
+type extentChecker[T int64 | float64 | time.Duration | time.Time] struct {
+	policy ExtentPolicy
+	axis   *continuousaxis.Axis[T]
+	err    error
+}
+
+// check returns the extent at which the described span, in the Category with
+// the provided path, should be emitted, and any properties it should be
+// annotated with.
+func (ec *extentChecker[T]) check(desc string, path []string, start, end T) (T, T, util.PropertyUpdate) {
+	if ec == nil {
+		return start, end, util.EmptyUpdate
+	}
+	min, max := ec.axis.Extents()
+	clipStart, clipEnd := compare(start, min) < 0, compare(end, max) > 0
+	if !clipStart && !clipEnd {
+		return start, end, util.EmptyUpdate
+	}
+	switch ec.policy {
+	case ClampExtents:
+		if clipStart {
+			start = min
+		}
+		if clipEnd {
+			end = max
+		}
+		// Spans lying wholly outside the extent collapse onto the nearer
+		// axis extreme.
+		if compare(start, max) > 0 {
+			start = max
+		}
+		if compare(end, min) < 0 {
+			end = min
+		}
+		return start, end, util.Chain(
+			util.If(clipStart, util.IntegerProperty(clippedStartKey, 1)),
+			util.If(clipEnd, util.IntegerProperty(clippedEndKey, 1)),
+		)
+	case RejectExtents:
+		if ec.err == nil {
+			ec.err = fmt.Errorf("%s [%s, %s] in trace category '%s' lies outside the extent [%s, %s] of axis '%s'",
+				desc, format(start), format(end), strings.Join(path, "/"), format(min), format(max), ec.axis.CategoryID())
+		}
+	}
+	return start, end, util.EmptyUpdate
+}
+
+// format formats the provided axis value for an error message.
+func format[T int64 | float64 | time.Duration | time.Time](v T) string {
+	if ts, ok := any(v).(time.Time); ok {
+		return ts.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// WithExtentPolicy sets the ExtentPolicy of the receiving Trace, which must
+// not yet have any Categories.  Returns the receiver.
+func (t *Trace[T]) WithExtentPolicy(policy ExtentPolicy) *Trace[T] {
+	if policy == UncheckedExtents {
+		t.extent = nil
+		return t
+	}
+	t.extent = &extentChecker[T]{
+		policy: policy,
+		axis:   t.axis,
+	}
+	return t
+}
+
+// Err returns an error describing the first span or subspan lying outside
+// the receiving Trace's axis extent, if the Trace's ExtentPolicy is
+// RejectExtents and any such span was created.  A Trace for which Err
+// returns an error should not be served.
+func (t *Trace[T]) Err() error {
+	if t.extent == nil {
+		return nil
+	}
+	return t.extent.err
+}
+
+// Extent is an interval along a trace axis.
+type Extent[T int64 | float64 | time.Duration | time.Time] struct {
+	Start, End T
+}
+
+// Split splits the interval from start to end at each of the provided
+// boundaries lying strictly within it, such as the endpoints of a time-range
+// filter, and returns the resulting pieces in order.  Boundaries outside the
+// interval, or coinciding with its endpoints or with one another, are
+// ignored.
+func Split[T int64 | float64 | time.Duration | time.Time](start, end T, boundaries ...T) []Extent[T] {
+	sorted := append([]T{}, boundaries...)
+	sort.Slice(sorted, func(a, b int) bool {
+		return compare(sorted[a], sorted[b]) < 0
+	})
+	ret := []Extent[T]{}
+	for _, boundary := range sorted {
+		if compare(boundary, start) <= 0 || compare(boundary, end) >= 0 {
+			continue
+		}
+		ret = append(ret, Extent[T]{start, boundary})
+		start = boundary
+	}
+	return append(ret, Extent[T]{start, end})
+}
+
+// SplitSpan creates a Span under the receiving Category for each piece of the
+// interval from start to end, split at the provided boundaries as by Split,
+// and returns them in order.  Each piece receives the provided properties.
+// This allows a span straddling the edge of a filtered time range to be
+// emitted as separate inside and outside portions.
+func (c *Category[T]) SplitSpan(start, end T, boundaries []T, properties ...util.PropertyUpdate) []*Span[T] {
+	pieces := Split(start, end, boundaries...)
+	ret := make([]*Span[T], len(pieces))
+	for idx, piece := range pieces {
+		ret[idx] = c.Span(piece.Start, piece.End, properties...)
+	}
+	return ret
+}
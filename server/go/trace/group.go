@@ -0,0 +1,181 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// GroupByKey is the request option specifying, as a string slice of
+// Dimension keys, outermost first, how spans should be grouped into
+// Categories.
+const GroupByKey = "group_by"
+
+// Dimension is a dimension by which spans may be grouped, such as thread or
+// connection.
+type Dimension struct {
+	// The key identifying the dimension, e.g. 'thread'.
+	Key string
+	// The dimension's display name, e.g. 'Thread'.  If empty, Key is used.
+	DisplayName string
+}
+
+func (d *Dimension) displayName() string {
+	if d.DisplayName == "" {
+		return d.Key
+	}
+	return d.DisplayName
+}
+
+// DimensionsFromOptions returns the Dimensions, from among those available,
+// named by the GroupByKey option in the provided request options, in the
+// requested order.  If the option is absent, the provided defaults are
+// returned.  Returns an error if the option names an unavailable Dimension.
+func DimensionsFromOptions(reqOpts map[string]*util.V, available []Dimension, defaults ...Dimension) ([]Dimension, error) {
+	val, ok := reqOpts[GroupByKey]
+	if !ok {
+		return defaults, nil
+	}
+	keys, err := util.ExpectStringsValue(val)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]Dimension, 0, len(keys))
+	for _, key := range keys {
+		found := false
+		for _, dim := range available {
+			if dim.Key == key {
+				ret = append(ret, dim)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unsupported %s dimension '%s'", GroupByKey, key)
+		}
+	}
+	return ret, nil
+}
+
+// GroupKeys maps Dimension keys to a span's value in that dimension, e.g.
+// {"thread": "123", "connection": "db-1"}.
+type GroupKeys map[string]string
+
+// categoryParent is implemented by Trace and Category.
+type categoryParent[T int64 | float64 | time.Duration | time.Time] interface {
+	Category(category *category.Category, properties ...util.PropertyUpdate) *Category[T]
+}
+
+// groupNode is a Category materialized by a Grouper.
+type groupNode[T int64 | float64 | time.Duration | time.Time] struct {
+	id       string
+	cat      *Category[T]
+	children map[string]*groupNode[T]
+}
+
+func newGroupNode[T int64 | float64 | time.Duration | time.Time](id string, cat *Category[T]) *groupNode[T] {
+	return &groupNode[T]{
+		id:       id,
+		cat:      cat,
+		children: map[string]*groupNode[T]{},
+	}
+}
+
+// Grouper emits a flat stream of spans, each tagged with GroupKeys, into a
+// hierarchy of Categories materialized from a list of Dimensions: each span
+// is placed in a Category for its value in the innermost Dimension, nested
+// within Categories for its values in each enclosing Dimension.  This allows
+// data sources to offer user-selectable grouping (see DimensionsFromOptions)
+// without restructuring their emission code.  For example,
+//
+//	g := tr.GroupBy(Dimension{"process", "Process"}, Dimension{"thread", "Thread"})
+//	g.Span(GroupKeys{"process": "12", "thread": "34"}, start, end)
+//
+// emits the span into a 'Thread 34' Category within a 'Process 12' Category.
+// Spans lacking a value in some Dimension are grouped under the empty value.
+//
+// Categories are created as their first spans are added, so appear in order
+// of first appearance in the stream.  Each Category's ID is the path of
+// Dimension key and value pairs leading to it, e.g. 'process=12/thread=34',
+// and, if the Grouper was created from a Category, prefixed with that
+// Category's ID.
+type Grouper[T int64 | float64 | time.Duration | time.Time] struct {
+	parent categoryParent[T]
+	dims   []Dimension
+	// The root group, whose Category is nil if the parent is a Trace.
+	root *groupNode[T]
+}
+
+// GroupBy returns a new Grouper materializing Categories for the specified
+// Dimensions, outermost first, within the receiving Trace.  With no
+// Dimensions, all spans are emitted into a single Category.
+func (t *Trace[T]) GroupBy(dims ...Dimension) *Grouper[T] {
+	return &Grouper[T]{
+		parent: t,
+		dims:   dims,
+		root:   newGroupNode[T]("", nil),
+	}
+}
+
+// GroupBy returns a new Grouper materializing Categories for the specified
+// Dimensions, outermost first, within the receiving Category.  With no
+// Dimensions, all spans are emitted directly into the receiver.
+func (c *Category[T]) GroupBy(dims ...Dimension) *Grouper[T] {
+	return &Grouper[T]{
+		parent: c,
+		dims:   dims,
+		root:   newGroupNode(c.cat.ID(), c),
+	}
+}
+
+// Category returns the Category for spans with the provided GroupKeys,
+// creating it and any enclosing Categories if necessary.
+func (g *Grouper[T]) Category(keys GroupKeys) *Category[T] {
+	node := g.root
+	for _, dim := range g.dims {
+		value := keys[dim.Key]
+		child, ok := node.children[value]
+		if !ok {
+			id := dim.Key + "=" + value
+			if node.id != "" {
+				id = node.id + "/" + id
+			}
+			parent := g.parent
+			if node.cat != nil {
+				parent = node.cat
+			}
+			child = newGroupNode(id, parent.Category(
+				category.New(id, strings.TrimSpace(dim.displayName()+" "+value), "Spans with "+dim.displayName()+" '"+value+"'"),
+			))
+			node.children[value] = child
+		}
+		node = child
+	}
+	if node.cat == nil {
+		// With no Dimensions, all spans within a Trace share a single Category.
+		node.cat = g.parent.Category(category.New("all", "All", "All spans"))
+	}
+	return node.cat
+}
+
+// Span creates a new Span with the specified extent and properties in the
+// Category for the provided GroupKeys, and returns it.
+func (g *Grouper[T]) Span(keys GroupKeys, start, end T, properties ...util.PropertyUpdate) *Span[T] {
+	return g.Category(keys).Span(start, end, properties...)
+}
@@ -0,0 +1,236 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Layout property keys.  These are only set on traces with layout enabled.
+const (
+	// The span's row within its Category, counting from 0 at the top of the
+	// Category.
+	spanRowKey = keys.SpanRow
+	// The span's nesting depth: 0 for spans directly under a Category, 1 for
+	// their child spans, and so forth.
+	spanDepthKey = keys.SpanDepth
+	// The number of rows occupied by the Category's own spans, excluding those
+	// in its subcategories.
+	categoryRowsKey = keys.CategoryRows
+	// The extent of the Category along the category axis, in pixels, including
+	// its subcategories.
+	categoryHeightCatPxKey = keys.CategoryHeightCatPx
+)
+
+// layoutCategory tracks the spans and subcategories of a trace Category (or,
+// at the root, the Trace itself) for layout.  Its methods are nil-safe, so that
+// tracking may be skipped when layout is disabled.
+type layoutCategory[T int64 | float64 | time.Duration | time.Time] struct {
+	db         util.DataBuilder
+	spans      []*layoutSpan[T]
+	categories []*layoutCategory[T]
+}
+
+func (lc *layoutCategory[T]) category(db util.DataBuilder) *layoutCategory[T] {
+	if lc == nil {
+		return nil
+	}
+	ret := &layoutCategory[T]{db: db}
+	lc.categories = append(lc.categories, ret)
+	return ret
+}
+
+func (lc *layoutCategory[T]) span(db util.DataBuilder, start, end T) *layoutSpan[T] {
+	if lc == nil {
+		return nil
+	}
+	ret := &layoutSpan[T]{db: db, start: start, end: end}
+	lc.spans = append(lc.spans, ret)
+	return ret
+}
+
+// layoutSpan tracks a span and its child spans for layout.
+type layoutSpan[T int64 | float64 | time.Duration | time.Time] struct {
+	db         util.DataBuilder
+	start, end T
+	children   []*layoutSpan[T]
+	// The number of rows occupied by the span and its descendants, and the
+	// span's row offset among its siblings.
+	height, offset int64
+}
+
+func (ls *layoutSpan[T]) span(db util.DataBuilder, start, end T) *layoutSpan[T] {
+	if ls == nil {
+		return nil
+	}
+	ret := &layoutSpan[T]{db: db, start: start, end: end}
+	ls.children = append(ls.children, ret)
+	return ret
+}
+
+// compare returns a negative number if a < b, a positive number if a > b,
+// and zero otherwise.
+func compare[T int64 | float64 | time.Duration | time.Time](a, b T) int {
+	switch av := any(a).(type) {
+	case time.Time:
+		return av.Compare(any(b).(time.Time))
+	case int64:
+		return compareOrdered(av, any(b).(int64))
+	case float64:
+		return compareOrdered(av, any(b).(float64))
+	case time.Duration:
+		return compareOrdered(av, any(b).(time.Duration))
+	}
+	return 0
+}
+
+func compareOrdered[T int64 | float64 | time.Duration](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// overlaps returns true if the provided spans cannot share a row: if they
+// intersect other than at an endpoint, or start at the same point.
+func overlaps[T int64 | float64 | time.Duration | time.Time](a, b *layoutSpan[T]) bool {
+	return compare(a.start, b.start) == 0 ||
+		(compare(a.start, b.end) < 0 && compare(b.start, a.end) < 0)
+}
+
+// pack measures each of the provided sibling spans, then assigns each a row
+// offset such that no two overlapping siblings (or their descendants) share a
+// row.  Spans are placed in start order, each at the lowest offset at which it
+// fits.  Returns the total number of rows the siblings occupy.
+func pack[T int64 | float64 | time.Duration | time.Time](spans []*layoutSpan[T]) int64 {
+	for _, span := range spans {
+		span.height = 1 + pack(span.children)
+	}
+	sorted := make([]*layoutSpan[T], len(spans))
+	copy(sorted, spans)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return compare(sorted[a].start, sorted[b].start) < 0
+	})
+	var height int64
+	// Placed spans that may overlap subsequent ones.
+	var active []*layoutSpan[T]
+	for _, span := range sorted {
+		var blocking []*layoutSpan[T]
+		remaining := active[:0]
+		for _, placed := range active {
+			// Spans are placed in start order, so a placed span ending at or
+			// before this one's start can't overlap any subsequent span.
+			if compare(placed.end, span.start) <= 0 && compare(placed.start, span.start) < 0 {
+				continue
+			}
+			remaining = append(remaining, placed)
+			if overlaps(placed, span) {
+				blocking = append(blocking, placed)
+			}
+		}
+		active = remaining
+		sort.Slice(blocking, func(a, b int) bool {
+			return blocking[a].offset < blocking[b].offset
+		})
+		var offset int64
+		for _, b := range blocking {
+			if offset+span.height <= b.offset {
+				break
+			}
+			if b.offset+b.height > offset {
+				offset = b.offset + b.height
+			}
+		}
+		span.offset = offset
+		active = append(active, span)
+		if offset+span.height > height {
+			height = offset + span.height
+		}
+	}
+	return height
+}
+
+// place annotates the provided span and its descendants with their rows and
+// depths, given the row of the span's parent.
+func place[T int64 | float64 | time.Duration | time.Time](span *layoutSpan[T], baseRow, depth int64) {
+	row := baseRow + span.offset
+	span.db.With(
+		util.IntegerProperty(spanRowKey, row),
+		util.IntegerProperty(spanDepthKey, depth),
+	)
+	for _, child := range span.children {
+		place(child, row+1, depth+1)
+	}
+}
+
+// layout lays out the receiver's spans and subcategories, annotating them
+// accordingly, and returns the receiver's height in pixels.
+func (lc *layoutCategory[T]) layout(rs *RenderSettings) int64 {
+	rows := pack(lc.spans)
+	for _, span := range lc.spans {
+		place(span, 0, 0)
+	}
+	var heightPx int64
+	if rows > 0 {
+		heightPx = rows*rs.SpanWidthCatPx + (rows-1)*rs.SpanPaddingCatPx
+	}
+	for _, cat := range lc.categories {
+		catHeightPx := cat.layout(rs)
+		if heightPx > 0 && catHeightPx > 0 {
+			heightPx += rs.SpanPaddingCatPx
+		}
+		heightPx += catHeightPx
+	}
+	if lc.db != nil {
+		lc.db.With(
+			util.IntegerProperty(categoryRowsKey, rows),
+			util.IntegerProperty(categoryHeightCatPxKey, heightPx),
+		)
+	}
+	return heightPx
+}
+
+// WithLayout enables server-side layout for the receiving Trace, which must
+// not yet have any Categories.  With layout enabled, Layout may be invoked
+// once the Trace is fully populated.  Tracking spans for layout requires
+// additional memory proportional to the number of spans.  Returns the
+// receiver.
+func (t *Trace[T]) WithLayout() *Trace[T] {
+	t.layout = &layoutCategory[T]{}
+	return t
+}
+
+// Layout performs a layout pass over the receiving Trace, which must have had
+// layout enabled with WithLayout, and which should be fully populated.  Each
+// span is assigned a row within its Category, such that overlapping sibling
+// spans, and their descendants, occupy different rows, and child spans lie in
+// the rows below their parent.  Each span is annotated with its row and its
+// nesting depth; each Category is annotated with the number of rows its own
+// spans occupy and with its total extent, in pixels, along the category axis
+// per the Trace's RenderSettings.  This spares frontends and image exporters
+// from having to pack spans themselves.
+func (t *Trace[T]) Layout() {
+	if t.layout == nil {
+		return
+	}
+	t.layout.layout(t.renderSettings)
+}
@@ -0,0 +1,188 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	// Request options specifying the level of detail at which a trace is
+	// viewed: the extent of the visible portion of the trace axis, as a
+	// duration for timestamp and duration axes and a double otherwise, and the
+	// width in pixels of the view.
+	ViewportExtentKey  = "viewport_extent"
+	ViewportWidthPxKey = "viewport_width_px"
+
+	// Properties of aggregate spans: the number of spans aggregated, their
+	// total extent (a duration for timestamp and duration axes, and a double
+	// otherwise), and a summary label such as '12 spans'.
+	aggregateCountKey = keys.AggregateSpanCount
+	aggregateTotalKey = keys.AggregateSpanTotal
+	aggregateLabelKey = keys.AggregateSpanLabel
+)
+
+// LevelOfDetail specifies the resolution at which a trace is viewed.
+type LevelOfDetail struct {
+	// The extent of the visible portion of the trace axis, in axis units:
+	// nanoseconds for timestamp and duration axes.
+	ViewportExtent float64
+	// The width of the view, in pixels.
+	ViewportWidthPx int64
+}
+
+// LevelOfDetailFromOptions returns the LevelOfDetail specified by the
+// ViewportExtentKey and ViewportWidthPxKey options in the provided request
+// options, and true, or false if either is absent.  Returns an error if either
+// is malformed.
+func LevelOfDetailFromOptions(reqOpts map[string]*util.V) (*LevelOfDetail, bool, error) {
+	extentVal, extentOK := reqOpts[ViewportExtentKey]
+	widthVal, widthOK := reqOpts[ViewportWidthPxKey]
+	if !extentOK || !widthOK {
+		return nil, false, nil
+	}
+	ret := &LevelOfDetail{}
+	switch extentVal.T {
+	case util.DurationValueType:
+		dur, err := util.ExpectDurationValue(extentVal)
+		if err != nil {
+			return nil, false, err
+		}
+		ret.ViewportExtent = float64(dur)
+	default:
+		f, err := util.ExpectDoubleValue(extentVal)
+		if err != nil {
+			return nil, false, fmt.Errorf("option '%s' must be a duration or double", ViewportExtentKey)
+		}
+		ret.ViewportExtent = f
+	}
+	var err error
+	if ret.ViewportWidthPx, err = util.ExpectIntegerValue(widthVal); err != nil {
+		return nil, false, err
+	}
+	if ret.ViewportExtent <= 0 || ret.ViewportWidthPx <= 0 {
+		return nil, false, fmt.Errorf("options '%s' and '%s' must be positive", ViewportExtentKey, ViewportWidthPxKey)
+	}
+	return ret, true, nil
+}
+
+// LevelOfDetailFromViewport returns the LevelOfDetail at which the provided
+// Viewport displays a timestamp or duration trace axis.
+func LevelOfDetailFromViewport(vp *util.Viewport) *LevelOfDetail {
+	return &LevelOfDetail{
+		ViewportExtent:  float64(vp.Extent()),
+		ViewportWidthPx: vp.WidthPx,
+	}
+}
+
+// pixel returns the extent of a single pixel, in axis units.
+func (lod *LevelOfDetail) pixel() float64 {
+	return lod.ViewportExtent / float64(lod.ViewportWidthPx)
+}
+
+// pendingSpan is a span whose emission is deferred, in case it can be
+// aggregated with its successors.
+type pendingSpan[T int64 | float64 | time.Duration | time.Time] struct {
+	start, end T
+	properties []util.PropertyUpdate
+	count      int64
+	total      float64
+}
+
+// SpanAggregator emits spans into a Category at a specified LevelOfDetail,
+// merging runs of consecutive spans narrower than a pixel, and separated by
+// less than a pixel, into aggregate spans.  This bounds the number of spans
+// emitted at any zoom level.  An aggregate span covers the spans it
+// aggregates, and is annotated with their count and total extent, and a
+// summary label; it does not carry those spans' own properties.  A run of a
+// single narrow span is emitted unaggregated.
+//
+// Spans must be added in increasing start order, and Flush must be invoked
+// once all spans are added.
+type SpanAggregator[T int64 | float64 | time.Duration | time.Time] struct {
+	cat     *Category[T]
+	pixel   float64
+	pending *pendingSpan[T]
+}
+
+// Aggregate returns a new SpanAggregator emitting spans into the receiving
+// Category at the specified LevelOfDetail.  If the LevelOfDetail is nil, no
+// spans are aggregated.
+func (c *Category[T]) Aggregate(lod *LevelOfDetail) *SpanAggregator[T] {
+	ret := &SpanAggregator[T]{
+		cat: c,
+	}
+	if lod != nil {
+		ret.pixel = lod.pixel()
+	}
+	return ret
+}
+
+// Span adds a span with the specified extent and properties to the receiver.
+// If the span is at least a pixel wide, it is emitted, and returned so that
+// it may be further decorated; otherwise, its emission is deferred, and nil is
+// returned.
+func (sa *SpanAggregator[T]) Span(start, end T, properties ...util.PropertyUpdate) *Span[T] {
+	width := sa.cat.axis.Distance(start, end)
+	if width >= sa.pixel {
+		sa.Flush()
+		return sa.cat.Span(start, end, properties...)
+	}
+	if sa.pending != nil && sa.cat.axis.Distance(sa.pending.end, start) < sa.pixel {
+		sa.pending.count++
+		sa.pending.total += width
+		if sa.cat.axis.Distance(sa.pending.end, end) > 0 {
+			sa.pending.end = end
+		}
+		return nil
+	}
+	sa.Flush()
+	sa.pending = &pendingSpan[T]{
+		start:      start,
+		end:        end,
+		properties: properties,
+		count:      1,
+		total:      width,
+	}
+	return nil
+}
+
+// Flush emits any deferred spans.
+func (sa *SpanAggregator[T]) Flush() {
+	p := sa.pending
+	if p == nil {
+		return
+	}
+	sa.pending = nil
+	if p.count == 1 {
+		sa.cat.Span(p.start, p.end, p.properties...)
+		return
+	}
+	var total util.PropertyUpdate
+	switch any(p.start).(type) {
+	case time.Time, time.Duration:
+		total = util.DurationProperty(aggregateTotalKey, time.Duration(p.total))
+	default:
+		total = util.DoubleProperty(aggregateTotalKey, p.total)
+	}
+	sa.cat.Span(p.start, p.end,
+		util.IntegerProperty(aggregateCountKey, p.count),
+		total,
+		util.StringProperty(aggregateLabelKey, fmt.Sprintf("%d spans", p.count)),
+	)
+}
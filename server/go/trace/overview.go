@@ -0,0 +1,139 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"math"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/payload"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	// OverviewPayloadType is the payload type of trace overviews.
+	OverviewPayloadType = "trace_overview"
+
+	// The number of bins in an overview.
+	overviewBinCountKey = keys.OverviewBinCount
+	// The fraction of each bin during which a category was busy, in
+	// thousandths.
+	overviewBusyPermilleKey = keys.OverviewBusyPermille
+)
+
+// Overview computes a low-resolution summary of trace activity, for powering
+// minimap or overview widgets without a second query.  The trace axis is
+// divided into equal-width bins, and for each Category, the fraction of each
+// bin covered by its spans is recorded.
+//
+// An Overview is populated as spans are emitted into Categories it is attached
+// to with Category.WithOverview, or explicitly with Add, then attached to a
+// span as a payload with Emit:
+//
+//	overview := NewOverview(axis, 100)
+//	process := trace.Category(processCat)
+//	processSpan := process.Span(start, end)
+//	thread := process.Category(threadCat).WithOverview(overview)
+//	thread.Span(...)
+//	overview.Emit(processSpan)
+//
+// Encoded into the TraceViz data model, an overview is a payload:
+//
+//	properties
+//	  * payload.TypeKey: OverviewPayloadType
+//	  * overviewBinCountKey: the number of bins
+//	children
+//	  * repeated per-category overviews, in order of first activity
+//
+// per-category overview
+//
+//	properties
+//	  * category definition
+//	  * overviewBusyPermilleKey: the busy fraction of each bin, in thousandths
+type Overview[T int64 | float64 | time.Duration | time.Time] struct {
+	axis       *continuousaxis.Axis[T]
+	binCount   int
+	categories []*category.Category
+	// The busy fraction of each bin, per category ID.
+	busyByCategoryID map[string][]float64
+}
+
+// NewOverview returns a new Overview over the provided axis, with the
+// specified number of bins, which must be positive.
+func NewOverview[T int64 | float64 | time.Duration | time.Time](axis *continuousaxis.Axis[T], binCount int) *Overview[T] {
+	return &Overview[T]{
+		axis:             axis,
+		binCount:         binCount,
+		busyByCategoryID: map[string][]float64{},
+	}
+}
+
+// WithOverview attaches the provided Overview to the receiving Category and
+// to its subsequently-created subcategories: spans subsequently created
+// directly under any of them are added to the Overview under their Category.
+// Child spans and subspans, which lie within their parents, are not added.
+// Returns the receiver.
+func (c *Category[T]) WithOverview(overview *Overview[T]) *Category[T] {
+	c.overview = overview
+	return c
+}
+
+// add is a nil-safe Add.
+func (o *Overview[T]) add(cat *category.Category, start, end T) {
+	if o == nil {
+		return
+	}
+	o.Add(cat, start, end)
+}
+
+// Add records activity between the specified start and end points in the
+// specified Category.  Activity outside the Overview's axis is ignored, and
+// a Category's busy time in each bin is capped at the bin's width.
+func (o *Overview[T]) Add(cat *category.Category, start, end T) {
+	busy, ok := o.busyByCategoryID[cat.ID()]
+	if !ok {
+		busy = make([]float64, o.binCount)
+		o.busyByCategoryID[cat.ID()] = busy
+		o.categories = append(o.categories, cat)
+	}
+	// Positions are in units of bins.
+	startPos := math.Max(0, o.axis.Fraction(start)*float64(o.binCount))
+	endPos := math.Min(float64(o.binCount), o.axis.Fraction(end)*float64(o.binCount))
+	for bin := int(startPos); bin < o.binCount && float64(bin) < endPos; bin++ {
+		covered := math.Min(endPos, float64(bin+1)) - math.Max(startPos, float64(bin))
+		busy[bin] = math.Min(1, busy[bin]+covered)
+	}
+}
+
+// Emit attaches the receiver, as an OverviewPayloadType payload, to the
+// provided Payloader, typically a Span covering the summarized Categories.
+func (o *Overview[T]) Emit(parent payload.Payloader) {
+	db := payload.New(parent, OverviewPayloadType).With(
+		util.IntegerProperty(overviewBinCountKey, int64(o.binCount)),
+	)
+	for _, cat := range o.categories {
+		busy := o.busyByCategoryID[cat.ID()]
+		permille := make([]int64, len(busy))
+		for idx, b := range busy {
+			permille[idx] = int64(math.Round(b * 1000))
+		}
+		db.Child().With(
+			cat.Define(),
+			util.IntegersProperty(overviewBusyPermilleKey, permille...),
+		)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"time"
+
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/payload"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Payload types for common kinds of span details.  Each is emitted with a
+// standard schema, so that frontends can present any of them in a generic
+// detail pane.
+const (
+	// LogExcerptPayloadType is the payload type of log excerpts.
+	LogExcerptPayloadType = "trace_log_excerpt"
+	// StackTracePayloadType is the payload type of stack traces.
+	StackTracePayloadType = "trace_stack_trace"
+	// LinkPayloadType is the payload type of links.
+	LinkPayloadType = "trace_link"
+
+	logLineTimestampKey = keys.LogLineTimestamp
+	logLineTextKey      = keys.LogLineText
+
+	stackFrameFunctionKey = keys.StackFrameFunction
+	stackFrameFileKey     = keys.StackFrameFile
+	stackFrameLineKey     = keys.StackFrameLine
+
+	linkURLKey   = keys.LinkURL
+	linkLabelKey = keys.LinkLabel
+)
+
+// LogLine is a single timestamped line of a log excerpt.
+type LogLine struct {
+	Timestamp time.Time
+	Text      string
+}
+
+// NewLogExcerpt attaches the provided log lines, as a LogExcerptPayloadType
+// payload, to the provided Payloader, typically a Span, and returns the
+// payload so that it may be further annotated.
+//
+// Encoded into the TraceViz data model, a log excerpt is a payload:
+//
+//	properties
+//	  * payload.TypeKey: LogExcerptPayloadType
+//	children
+//	  * repeated log lines, in the provided order
+//
+// log line
+//
+//	properties
+//	  * logLineTimestampKey: the line's timestamp
+//	  * logLineTextKey: the line's text
+func NewLogExcerpt(parent payload.Payloader, lines ...LogLine) util.DataBuilder {
+	db := payload.New(parent, LogExcerptPayloadType)
+	for _, line := range lines {
+		db.Child().With(
+			util.TimestampProperty(logLineTimestampKey, line.Timestamp),
+			util.StringProperty(logLineTextKey, line.Text),
+		)
+	}
+	return db
+}
+
+// StackFrame is a single frame of a stack trace.  File and Line are optional.
+type StackFrame struct {
+	Function string
+	File     string
+	Line     int64
+}
+
+// NewStackTrace attaches the provided stack frames, innermost first, as a
+// StackTracePayloadType payload, to the provided Payloader, typically a Span,
+// and returns the payload so that it may be further annotated.
+//
+// Encoded into the TraceViz data model, a stack trace is a payload:
+//
+//	properties
+//	  * payload.TypeKey: StackTracePayloadType
+//	children
+//	  * repeated stack frames, innermost first
+//
+// stack frame
+//
+//	properties
+//	  * stackFrameFunctionKey: the frame's function
+//	  * stackFrameFileKey: the frame's source file, if known
+//	  * stackFrameLineKey: the frame's source line, if known
+func NewStackTrace(parent payload.Payloader, frames ...StackFrame) util.DataBuilder {
+	db := payload.New(parent, StackTracePayloadType)
+	for _, frame := range frames {
+		db.Child().With(
+			util.StringProperty(stackFrameFunctionKey, frame.Function),
+			util.If(frame.File != "", util.StringProperty(stackFrameFileKey, frame.File)),
+			util.If(frame.Line > 0, util.IntegerProperty(stackFrameLineKey, frame.Line)),
+		)
+	}
+	return db
+}
+
+// NewLink attaches a link to the provided URL, displayed with the provided
+// label, as a LinkPayloadType payload, to the provided Payloader, typically a
+// Span, and returns the payload so that it may be further annotated.
+//
+// Encoded into the TraceViz data model, a link is a payload:
+//
+//	properties
+//	  * payload.TypeKey: LinkPayloadType
+//	  * linkURLKey: the link's URL
+//	  * linkLabelKey: the link's label
+func NewLink(parent payload.Payloader, url, label string) util.DataBuilder {
+	return payload.New(parent, LinkPayloadType).With(
+		util.StringProperty(linkURLKey, url),
+		util.StringProperty(linkLabelKey, label),
+	)
+}
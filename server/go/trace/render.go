@@ -0,0 +1,197 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"fmt"
+	stdcolor "image/color"
+	"math"
+
+	"github.com/google/traceviz/server/go/category"
+	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	"github.com/google/traceviz/server/go/color"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/render"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Defaults used when rendering traces that don't specify their own
+// RenderSettings.
+const (
+	defaultSpanWidthCatPx      = 16
+	defaultSpanPaddingCatPx    = 2
+	defaultCategoryHeaderCatPx = 16
+	defaultCategoryMarginValPx = 8
+)
+
+var (
+	defaultSpanFill    = stdcolor.NRGBA{R: 70, G: 130, B: 180, A: 255}
+	categoryHeaderFill = stdcolor.NRGBA{R: 230, G: 230, B: 230, A: 255}
+)
+
+// traceRenderer renders a trace response into a render.Scene.
+type traceRenderer struct {
+	st       []string
+	scene    *render.Scene
+	pos      *continuousaxis.Positioner
+	coloring *color.Coloring
+	catRS    *categoryaxis.RenderSettings
+	// Extents along the category axis.
+	spanWidthPx, spanPaddingPx, headerPx, marginPx float64
+	// The current offset along the category axis.
+	y float64
+}
+
+func nodeType(d *util.Datum, st []string) (traceNodeType, bool) {
+	nt, ok := d.PropertyNumber(st, nodeTypeKey)
+	return traceNodeType(nt), ok
+}
+
+// Render lays out the trace rooted at the provided Datum, whose string indices
+// refer to the provided string table, as a render.Scene of the specified
+// width.  The trace's time axis runs horizontally across the Scene; each
+// Category has a labeled header, beneath which its spans are packed into rows
+// as by Layout, followed by its subcategories, indented.  Spans and subspans
+// are filled with their primary colors.
+func Render(root *util.Datum, st []string, widthPx float64) (*render.Scene, error) {
+	pos, ok := continuousaxis.DefinedPositioner(root, st)
+	if !ok {
+		return nil, fmt.Errorf("trace has no axis definition")
+	}
+	px := func(key string, def int64) float64 {
+		if f, ok := root.PropertyNumber(st, key); ok && f > 0 {
+			return f
+		}
+		return float64(def)
+	}
+	tr := &traceRenderer{
+		st:            st,
+		scene:         render.NewScene(widthPx, 0),
+		pos:           pos,
+		coloring:      color.NewColoring(st, root),
+		catRS:         categoryaxis.DefinedRenderSettings(root, st),
+		spanWidthPx:   px(spanWidthCatPxKey, defaultSpanWidthCatPx),
+		spanPaddingPx: px(spanPaddingCatPxKey, defaultSpanPaddingCatPx),
+	}
+	tr.headerPx = math.Max(float64(tr.catRS.CategoryHeaderCatPx), defaultCategoryHeaderCatPx)
+	tr.marginPx = float64(tr.catRS.CategoryMarginValPx)
+	if tr.marginPx <= 0 {
+		tr.marginPx = defaultCategoryMarginValPx
+	}
+	for _, child := range root.Children {
+		if nt, ok := nodeType(child, st); ok && nt == categoryNodeType {
+			if err := tr.category(child, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tr.scene, nil
+}
+
+// spanTree returns a layoutSpan tree, with fractional extents, for the
+// provided span Datum.
+func (tr *traceRenderer) spanTree(d *util.Datum) (*layoutSpan[float64], error) {
+	start, startOK := tr.pos.Value(d, tr.st, startKey)
+	end, endOK := tr.pos.Value(d, tr.st, endKey)
+	if !startOK || !endOK {
+		return nil, fmt.Errorf("trace span has missing or malformed extents")
+	}
+	ret := &layoutSpan[float64]{start: start, end: end}
+	for _, child := range d.Children {
+		if nt, ok := nodeType(child, tr.st); ok && nt == spanNodeType {
+			childSpan, err := tr.spanTree(child)
+			if err != nil {
+				return nil, err
+			}
+			ret.children = append(ret.children, childSpan)
+		}
+	}
+	return ret, nil
+}
+
+// span draws the provided span Datum, whose layoutSpan is provided, along
+// with its subspans and descendant spans.
+func (tr *traceRenderer) span(d *util.Datum, ls *layoutSpan[float64], baseRow int64, top float64) {
+	row := baseRow + ls.offset
+	y := top + float64(row)*(tr.spanWidthPx+tr.spanPaddingPx)
+	fill := func(d *util.Datum, def stdcolor.NRGBA) stdcolor.NRGBA {
+		if c, ok := tr.coloring.Primary(d, tr.st); ok {
+			return c
+		}
+		return def
+	}
+	spanFill := fill(d, defaultSpanFill)
+	tr.scene.Rect(ls.start*tr.scene.Width, y, (ls.end-ls.start)*tr.scene.Width, tr.spanWidthPx, spanFill, "")
+	childIdx := 0
+	for _, child := range d.Children {
+		nt, ok := nodeType(child, tr.st)
+		if !ok {
+			continue
+		}
+		switch nt {
+		case subspanNodeType:
+			start, startOK := tr.pos.Value(child, tr.st, startKey)
+			end, endOK := tr.pos.Value(child, tr.st, endKey)
+			if startOK && endOK {
+				tr.scene.Rect(start*tr.scene.Width, y, (end-start)*tr.scene.Width, tr.spanWidthPx, fill(child, spanFill), "")
+			}
+		case spanNodeType:
+			tr.span(child, ls.children[childIdx], row+1, top)
+			childIdx++
+		}
+	}
+}
+
+// category draws the provided category Datum, at the specified nesting depth,
+// along with its spans and subcategories.
+func (tr *traceRenderer) category(d *util.Datum, depth int) error {
+	tr.coloring.With(tr.st, d)
+	label := ""
+	if cat, ok := category.Defined(d, tr.st); ok {
+		label = cat.DisplayName()
+	}
+	indent := float64(depth) * tr.marginPx
+	tr.scene.Rect(indent, tr.y, math.Max(0, tr.scene.Width-indent), tr.headerPx, categoryHeaderFill, label)
+	tr.y += tr.headerPx + tr.spanPaddingPx
+	var spanDatums []*util.Datum
+	var spans []*layoutSpan[float64]
+	for _, child := range d.Children {
+		if nt, ok := nodeType(child, tr.st); ok && nt == spanNodeType {
+			ls, err := tr.spanTree(child)
+			if err != nil {
+				return err
+			}
+			spanDatums = append(spanDatums, child)
+			spans = append(spans, ls)
+		}
+	}
+	rows := pack(spans)
+	for idx, spanDatum := range spanDatums {
+		tr.span(spanDatum, spans[idx], 0, tr.y)
+	}
+	tr.y += float64(rows) * (tr.spanWidthPx + tr.spanPaddingPx)
+	for _, child := range d.Children {
+		if nt, ok := nodeType(child, tr.st); ok && nt == categoryNodeType {
+			if err := tr.category(child, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	tr.y += float64(tr.catRS.CategoryPaddingCatPx)
+	// Ensure the scene includes any trailing padding.
+	if tr.y > tr.scene.Height {
+		tr.scene.Height = tr.y
+	}
+	return nil
+}
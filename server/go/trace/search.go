@@ -0,0 +1,184 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"time"
+
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const (
+	// The total number of matching spans, which may exceed the number of
+	// search results.
+	searchMatchCountKey = keys.TraceSearchMatchCount
+	// The IDs of a matching span's Category and its ancestors, outermost
+	// first.
+	searchCategoryPathKey = keys.TraceSearchCategoryPath
+	// The offsets locating a matching span within its Category: the first is
+	// the index of its outermost ancestor span (or itself) among the spans
+	// directly under the Category, and each subsequent one is the index of
+	// the next span among its parent's child spans.
+	searchSpanOffsetsKey = keys.TraceSearchSpanOffsets
+)
+
+// SearchedSpan describes a span considered by a Search.
+type SearchedSpan[T int64 | float64 | time.Duration | time.Time] struct {
+	// The IDs of the span's Category and its ancestors, outermost first.
+	CategoryPath []string
+	Start, End   T
+	// The properties with which the span was created, and the string table
+	// to which their string indices refer.  Properties subsequently applied
+	// with Span.With are not included.
+	Properties  *util.Datum
+	StringTable []string
+}
+
+// SpanPredicate returns true if the provided span matches a search.
+type SpanPredicate[T int64 | float64 | time.Duration | time.Time] func(span *SearchedSpan[T]) bool
+
+// Search implements server-side span search, so that trace UIs can find spans
+// across traces too large to search on the frontend.  Spans created in
+// Categories a Search is attached to with Category.WithSearch, and their child
+// spans, are tested against the Search's predicate as they are emitted, and
+// references to matching spans are collected.  Subspans are not searched.
+// Once the trace is populated, the results are emitted, typically into a
+// separate data series, with Emit:
+//
+//	search := NewSearch(axis, func(span *SearchedSpan[time.Duration]) bool {
+//		name, _ := span.Properties.PropertyString(span.StringTable, nameKey)
+//		return strings.Contains(name, query)
+//	}, 100)
+//	process := trace.Category(processCat).WithSearch(search)
+//	process.Span(...)
+//	search.Emit(searchSeries)
+//
+// Encoded into the TraceViz data model, search results are:
+//
+//	properties
+//	  * axis definition
+//	  * searchMatchCountKey: the number of matching spans
+//	children
+//	  * repeated search results, in order of emission
+//
+// search result
+//
+//	properties
+//	  * searchCategoryPathKey: the matching span's category path
+//	  * searchSpanOffsetsKey: the matching span's offsets in its category
+//	  * startKey: axis value type
+//	  * endKey: axis value type
+type Search[T int64 | float64 | time.Duration | time.Time] struct {
+	axis       *continuousaxis.Axis[T]
+	predicate  SpanPredicate[T]
+	maxResults int
+	matches    int
+	results    []*searchedSpan[T]
+}
+
+// NewSearch returns a new Search over spans on the provided axis, matching
+// spans for which the provided predicate returns true.  At most maxResults
+// results are collected, though all matches are counted; if maxResults is
+// not positive, all results are collected.
+func NewSearch[T int64 | float64 | time.Duration | time.Time](axis *continuousaxis.Axis[T], predicate SpanPredicate[T], maxResults int) *Search[T] {
+	return &Search[T]{
+		axis:       axis,
+		predicate:  predicate,
+		maxResults: maxResults,
+	}
+}
+
+// WithSearch attaches the provided Search to the receiving Category and to
+// its subsequently-created subcategories: spans subsequently created under
+// any of them, and those spans' child spans, are tested against the Search.
+// Returns the receiver.
+func (c *Category[T]) WithSearch(search *Search[T]) *Category[T] {
+	c.search = search
+	return c
+}
+
+// searchedSpan locates a span considered by a Search.
+type searchedSpan[T int64 | float64 | time.Duration | time.Time] struct {
+	search       *Search[T]
+	categoryPath []string
+	offsets      []int64
+	start, end   T
+	// The number of child spans created under the span.
+	childCount int64
+}
+
+// consider tests the span at the provided location against the receiver,
+// recording it if it matches, and returns its searchedSpan.  It is nil-safe,
+// returning nil if the receiver is nil.
+func (s *Search[T]) consider(categoryPath []string, parentOffsets []int64, offset int64, start, end T, properties []util.PropertyUpdate) *searchedSpan[T] {
+	if s == nil {
+		return nil
+	}
+	ss := &searchedSpan[T]{
+		search:       s,
+		categoryPath: categoryPath,
+		offsets:      append(append([]int64{}, parentOffsets...), offset),
+		start:        start,
+		end:          end,
+	}
+	d, st, err := util.PropertyDatum(properties...)
+	if err != nil {
+		// The error will surface when the span is emitted.
+		return ss
+	}
+	if s.predicate(&SearchedSpan[T]{
+		CategoryPath: categoryPath,
+		Start:        start,
+		End:          end,
+		Properties:   d,
+		StringTable:  st,
+	}) {
+		s.matches++
+		if s.maxResults <= 0 || len(s.results) < s.maxResults {
+			s.results = append(s.results, ss)
+		}
+	}
+	return ss
+}
+
+// child tests a new child span of the receiver against the receiver's
+// Search, and returns the child's searchedSpan.  It is nil-safe, returning nil
+// if the receiver is nil.
+func (ss *searchedSpan[T]) child(start, end T, properties []util.PropertyUpdate) *searchedSpan[T] {
+	if ss == nil {
+		return nil
+	}
+	offset := ss.childCount
+	ss.childCount++
+	return ss.search.consider(ss.categoryPath, ss.offsets, offset, start, end, properties)
+}
+
+// Emit emits the receiver's results into the provided DataBuilder, which
+// should be dedicated to them.
+func (s *Search[T]) Emit(db util.DataBuilder) {
+	db.With(
+		s.axis.Define(),
+		util.IntegerProperty(searchMatchCountKey, int64(s.matches)),
+	)
+	for _, result := range s.results {
+		db.Child().With(
+			util.StringsProperty(searchCategoryPathKey, result.categoryPath...),
+			util.IntegersProperty(searchSpanOffsetsKey, result.offsets...),
+			s.axis.Value(startKey, result.start),
+			s.axis.Value(endKey, result.end),
+		)
+	}
+}
@@ -86,7 +86,39 @@
 //	payload.New(subspan, payloadType)
 //
 // which allocate the payload and return its *util.DataBuilder.  See payload.go
-// for more detail.
+// for more detail.  Common kinds of span details -- log excerpts, stack
+// traces, and links -- have typed payloads with standard schemas; see
+// payloads.go.
+//
+// Traces may optionally be laid out on the server, assigning each span a row
+// within its Category so that overlapping spans don't collide, via
+//
+//	trace := New(tableRoot, axis, renderSettings).WithLayout()
+//	// ... populate trace ...
+//	trace.Layout()
+//
+// See layout.go for more detail.
+//
+// By default, spans are emitted with the extents they are given, even if those
+// lie outside the trace's axis.  A trace may instead clamp such spans to the
+// axis, annotating them as clipped, or reject them with a descriptive error,
+// via
+//
+//	trace := New(tableRoot, axis, renderSettings).WithExtentPolicy(ClampExtents)
+//
+// Spans straddling a time-range filter boundary may be split at it with
+// Category.SplitSpan.  See extent.go for more detail.
+//
+// Overtime numeric series, such as CPU utilization or queue depth, may be
+// shown beneath a Category as counter tracks; see counter_track.go.
+//
+// A low-resolution overview of activity per Category, suitable for minimaps,
+// may be computed as spans are emitted and attached as a payload; see
+// overview.go for more detail.
+//
+// Spans may be searched server-side as they are emitted, with references to
+// matching spans collected into a separate search results series; see
+// search.go for more detail.
 //
 // This format supports composition, or 'unioning', on the frontend, which
 // allows multiple distinct data sources to contribute to a single trace view
@@ -105,7 +137,10 @@
 // restrictions are recommended:
 //   - It should be an error if any two traces in S have different axis types,
 //     and if all traces in S have 'Duration'-type axes, they must have, or be
-//     corrected to have, the same start point;
+//     corrected to have, the same start point.  Data sources that know the
+//     absolute time of their start point should declare it with
+//     Axis.WithAnchor; continuousaxis.CheckDurationUnion detects mismatched
+//     start points, and continuousaxis.Rebase corrects them;
 //   - It should be an error if two different datasources specify a category
 //     with the same path P but with different display names or descriptions.
 //     In other words, categories must be identical to be merged;
@@ -164,17 +199,18 @@ import (
 	"github.com/google/traceviz/server/go/category"
 	categoryaxis "github.com/google/traceviz/server/go/category_axis"
 	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/util"
 )
 
 const (
-	startKey    = "trace_start"
-	endKey      = "trace_end"
-	nodeTypeKey = "trace_node_type"
+	startKey    = keys.TraceStart
+	endKey      = keys.TraceEnd
+	nodeTypeKey = keys.TraceNodeType
 
 	// Rendering property keys
-	spanWidthCatPxKey   = "span_width_cat_px"
-	spanPaddingCatPxKey = "span_padding_cat_px"
+	spanWidthCatPxKey   = keys.SpanWidthCatPx
+	spanPaddingCatPxKey = keys.SpanPaddingCatPx
 )
 
 // RenderSettings is a collection of rendering settings for traces.  A trace is
@@ -226,19 +262,25 @@ func traceNode(parentDb util.DataBuilder, nodeType traceNodeType) util.DataBuild
 // visualized traces.
 // Every trace has a single axis, provided at its creation, extending across
 // the portion of the trace to be visualized.
-type Trace[T float64 | time.Duration | time.Time] struct {
-	db   util.DataBuilder
-	axis *continuousaxis.Axis[T]
+type Trace[T int64 | float64 | time.Duration | time.Time] struct {
+	db             util.DataBuilder
+	axis           *continuousaxis.Axis[T]
+	renderSettings *RenderSettings
+	// Non-nil if layout is enabled.  See layout.go.
+	layout *layoutCategory[T]
+	// Non-nil if span extents are checked.  See extent.go.
+	extent *extentChecker[T]
 }
 
 // New returns a new Trace populating the provided data builder.
-func New[T float64 | time.Duration | time.Time](db util.DataBuilder, axis *continuousaxis.Axis[T], renderSettings *RenderSettings) *Trace[T] {
+func New[T int64 | float64 | time.Duration | time.Time](db util.DataBuilder, axis *continuousaxis.Axis[T], renderSettings *RenderSettings) *Trace[T] {
 	return &Trace[T]{
 		db: db.With(
 			axis.Define(),
 			renderSettings.Define(),
 		),
-		axis: axis,
+		axis:           axis,
+		renderSettings: renderSettings,
 	}
 }
 
@@ -255,8 +297,12 @@ func (t *Trace[T]) Category(category *category.Category, properties ...util.Prop
 		With(category.Define()).
 		With(properties...)
 	return &Category[T]{
-		db:   db,
-		axis: t.axis,
+		db:     db,
+		cat:    category,
+		path:   []string{category.ID()},
+		axis:   t.axis,
+		layout: t.layout.category(db),
+		extent: t.extent,
 	}
 }
 
@@ -278,9 +324,22 @@ func (t *Trace[T]) Category(category *category.Category, properties ...util.Prop
 // under their parent's; or a system-wide trace might include a Category for
 // each CPU or thread in the system (that is, for each sequential line of
 // execution in the concurrent system.)
-type Category[T float64 | time.Duration | time.Time] struct {
-	db   util.DataBuilder
-	axis *continuousaxis.Axis[T]
+type Category[T int64 | float64 | time.Duration | time.Time] struct {
+	db  util.DataBuilder
+	cat *category.Category
+	// The IDs of this Category and its ancestors, outermost first.
+	path   []string
+	axis   *continuousaxis.Axis[T]
+	layout *layoutCategory[T]
+	// Non-nil if the Category's spans are summarized in an Overview.  See
+	// overview.go.
+	overview *Overview[T]
+	// Non-nil if the Category's spans are searched.  See search.go.
+	search *Search[T]
+	// Non-nil if span extents are checked.  See extent.go.
+	extent *extentChecker[T]
+	// The number of spans created directly under this Category.
+	spanCount int64
 }
 
 // Category adds and returns a sub-Category under the receiving Category.
@@ -289,25 +348,63 @@ func (c *Category[T]) Category(category *category.Category, properties ...util.P
 		With(category.Define()).
 		With(properties...)
 	return &Category[T]{
-		db:   db,
-		axis: c.axis,
+		db:       db,
+		cat:      category,
+		path:     append(append([]string{}, c.path...), category.ID()),
+		axis:     c.axis,
+		layout:   c.layout.category(db),
+		overview: c.overview,
+		search:   c.search,
+		extent:   c.extent,
 	}
 }
 
 // Span creates a new Span with the specified start and end points under the
 // receiving Category, and returns it.
 func (c *Category[T]) Span(start, end T, properties ...util.PropertyUpdate) *Span[T] {
+	start, end, clipped := c.extent.check("span", c.path, start, end)
 	db := traceNode(c.db, spanNodeType).
 		With(
 			c.axis.Value(startKey, start),
 			c.axis.Value(endKey, end),
+			clipped,
 		).With(properties...)
+	c.overview.add(c.cat, start, end)
+	offset := c.spanCount
+	c.spanCount++
 	return &Span[T]{
-		db:   db,
-		axis: c.axis,
+		db:     db,
+		axis:   c.axis,
+		path:   c.path,
+		layout: c.layout.span(db, start, end),
+		search: c.search.consider(c.path, nil, offset, start, end, properties),
+		extent: c.extent,
 	}
 }
 
+// extentAt returns the values along the provided axis of the provided
+// absolute start and end times.
+func extentAt[T int64 | float64 | time.Duration | time.Time](axis *continuousaxis.Axis[T], start, end time.Time) (T, T, error) {
+	startVal, err := axis.ValueAt(start)
+	if err != nil {
+		return startVal, startVal, err
+	}
+	endVal, err := axis.ValueAt(end)
+	return startVal, endVal, err
+}
+
+// SpanAt is like Span, but accepts absolute start and end times, which are
+// converted to values along the receiver's axis.  It is only supported on
+// timestamp axes and anchored duration axes, and returns an error if either
+// time lies outside the axis' extent.
+func (c *Category[T]) SpanAt(start, end time.Time, properties ...util.PropertyUpdate) (*Span[T], error) {
+	startVal, endVal, err := extentAt(c.axis, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return c.Span(startVal, endVal, properties...), nil
+}
+
 // With applies a set of properties to the receiving Category, returning that Category
 // to facilitate chaining.
 func (c *Category[T]) With(properties ...util.PropertyUpdate) *Category[T] {
@@ -322,25 +419,48 @@ func (c *Category[T]) With(properties ...util.PropertyUpdate) *Category[T] {
 // 'subspans', which should be rendered atop their parent hierarchical span and
 // represent phases of that parent span, or events within it.  Subspans may not
 // have children.
-type Span[T float64 | time.Duration | time.Time] struct {
+type Span[T int64 | float64 | time.Duration | time.Time] struct {
 	db   util.DataBuilder
 	axis *continuousaxis.Axis[T]
+	// The path of the Span's Category.
+	path   []string
+	layout *layoutSpan[T]
+	// Non-nil if the Span's Category is searched.  See search.go.
+	search *searchedSpan[T]
+	// Non-nil if span extents are checked.  See extent.go.
+	extent *extentChecker[T]
 }
 
 // Span creates a new Span with the specified start and end point under the
 // receiving Span, and returns it.
 func (s *Span[T]) Span(start, end T, properties ...util.PropertyUpdate) *Span[T] {
+	start, end, clipped := s.extent.check("span", s.path, start, end)
 	db := traceNode(s.db, spanNodeType).
 		With(
 			s.axis.Value(startKey, start),
 			s.axis.Value(endKey, end),
+			clipped,
 		).With(properties...)
 	return &Span[T]{
-		db:   db,
-		axis: s.axis,
+		db:     db,
+		axis:   s.axis,
+		path:   s.path,
+		layout: s.layout.span(db, start, end),
+		search: s.search.child(start, end, properties),
+		extent: s.extent,
 	}
 }
 
+// SpanAt is like Span, but accepts absolute start and end times.  See
+// Category.SpanAt.
+func (s *Span[T]) SpanAt(start, end time.Time, properties ...util.PropertyUpdate) (*Span[T], error) {
+	startVal, endVal, err := extentAt(s.axis, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return s.Span(startVal, endVal, properties...), nil
+}
+
 // With applies a set of properties to the receiving Span, returning that Span
 // to facilitate chaining.
 func (s *Span[T]) With(properties ...util.PropertyUpdate) *Span[T] {
@@ -356,10 +476,12 @@ func (s *Span[T]) Payload() util.DataBuilder {
 // Subspan creates a new Subspan with the specified start and end points under
 // the receiving Span, and returns it.
 func (s *Span[T]) Subspan(start, end T, properties ...util.PropertyUpdate) *Subspan {
+	start, end, clipped := s.extent.check("subspan", s.path, start, end)
 	db := traceNode(s.db, subspanNodeType).
 		With(
 			s.axis.Value(startKey, start),
 			s.axis.Value(endKey, end),
+			clipped,
 		).
 		With(properties...)
 	return &Subspan{
@@ -367,6 +489,16 @@ func (s *Span[T]) Subspan(start, end T, properties ...util.PropertyUpdate) *Subs
 	}
 }
 
+// SubspanAt is like Subspan, but accepts absolute start and end times.  See
+// Category.SpanAt.
+func (s *Span[T]) SubspanAt(start, end time.Time, properties ...util.PropertyUpdate) (*Subspan, error) {
+	startVal, endVal, err := extentAt(s.axis, start, end)
+	if err != nil {
+		return nil, err
+	}
+	return s.Subspan(startVal, endVal, properties...), nil
+}
+
 // Subspan is a part of a parent Span, often representing a phase or event
 // within that Span.
 type Subspan struct {
@@ -15,11 +15,14 @@ package trace
 
 import (
 	"fmt"
+	"math"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/traceviz/server/go/category"
 	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	"github.com/google/traceviz/server/go/color"
 	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
 	"github.com/google/traceviz/server/go/payload"
 	testutil "github.com/google/traceviz/server/go/test_util"
@@ -456,3 +459,684 @@ func TestTraceData(t *testing.T) {
 		})
 	}
 }
+
+func TestLayout(t *testing.T) {
+	layoutRS := &RenderSettings{
+		SpanWidthCatPx:             10,
+		SpanPaddingCatPx:           2,
+		CategoryAxisRenderSettings: rs.CategoryAxisRenderSettings,
+	}
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	threadCat := category.New("thread", "Thread", "Thread")
+	idleCat := category.New("idle", "Idle", "Idle thread")
+	// Thread  | [    a    ][    c    ]
+	//         |  [a1]
+	//         |      [    b    ]
+	// |- Idle |
+	buildTrace := func(db util.DataBuilder) {
+		trace := New(db, continuousaxis.NewDurationAxis(cat, ns(0), ns(200)), layoutRS).WithLayout()
+		thread := trace.Category(threadCat)
+		thread.Span(ns(0), ns(100)).Span(ns(10), ns(20))
+		thread.Span(ns(50), ns(150))
+		thread.Span(ns(100), ns(200))
+		thread.Category(idleCat)
+		trace.Layout()
+	}
+	span := func(start, end int, row, depth int64) util.PropertyUpdate {
+		return util.Chain(
+			util.IntegerProperty(nodeTypeKey, int64(spanNodeType)),
+			util.DurationProperty(startKey, ns(start)),
+			util.DurationProperty(endKey, ns(end)),
+			util.IntegerProperty(spanRowKey, row),
+			util.IntegerProperty(spanDepthKey, depth),
+		)
+	}
+	buildExplicit := func(db testutil.TestDataBuilder) {
+		db.With(
+			continuousaxis.NewDurationAxis(cat, ns(0), ns(200)).Define(),
+			layoutRS.Define(),
+		).Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			threadCat.Define(),
+			util.IntegerProperty(categoryRowsKey, 3),
+			// Three rows of spans, plus padding.
+			util.IntegerProperty(categoryHeightCatPxKey, 34),
+		).Child().With(
+			span(0, 100, 0, 0),
+		).Child().With(
+			span(10, 20, 1, 1),
+		).Parent().AndChild().With(
+			span(50, 150, 2, 0),
+		).AndChild().With(
+			span(100, 200, 0, 0),
+		).AndChild().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			idleCat.Define(),
+			util.IntegerProperty(categoryRowsKey, 0),
+			util.IntegerProperty(categoryHeightCatPxKey, 0),
+		)
+	}
+	if err := testutil.CompareResponses(t, buildTrace, buildExplicit); err != nil {
+		t.Fatalf("encountered unexpected error building the trace: %s", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	drb := util.NewDataResponseBuilder()
+	renderRS := &RenderSettings{
+		SpanWidthCatPx:   10,
+		SpanPaddingCatPx: 2,
+		CategoryAxisRenderSettings: &categoryaxis.RenderSettings{
+			CategoryHeaderCatPx:  20,
+			CategoryMarginValPx:  5,
+			CategoryPaddingCatPx: 4,
+		},
+	}
+	tr := New(drb.DataSeries(&util.DataSeriesRequest{SeriesName: "trace"}),
+		continuousaxis.NewDurationAxis(category.New("x_axis", "Time", "Time"), ns(0), ns(100)), renderRS)
+	thread := tr.Category(category.New("thread", "Thread", "Thread"))
+	span := thread.Span(ns(0), ns(50))
+	span.Subspan(ns(10), ns(20), color.Primary("red"))
+	span.Span(ns(20), ns(30))
+	thread.Span(ns(25), ns(100))
+	thread.Category(category.New("idle", "Idle", "Idle"))
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("failed to build trace: %s", err)
+	}
+	scene, err := Render(data.DataSeries[0].Root, data.StringTable, 200)
+	if err != nil {
+		t.Fatalf("Render() yielded unexpected error %s", err)
+	}
+	type rect struct {
+		x, y, w, h float64
+		label      string
+	}
+	var got []rect
+	for _, r := range scene.Rects {
+		round := func(f float64) float64 {
+			return math.Round(f*1000) / 1000
+		}
+		got = append(got, rect{round(r.X), round(r.Y), round(r.Width), round(r.Height), r.Label})
+	}
+	want := []rect{
+		{0, 0, 200, 20, "Thread"},
+		// The first span, its subspan, and its child.
+		{0, 22, 100, 10, ""},
+		{20, 22, 20, 10, ""},
+		{40, 34, 20, 10, ""},
+		// The overlapping second span is packed below the first's child.
+		{50, 46, 150, 10, ""},
+		{5, 58, 195, 20, "Idle"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Render() yielded rects %v, want %v", got, want)
+	}
+	for idx := range want {
+		if got[idx] != want[idx] {
+			t.Errorf("Render() rect %d = %v, want %v", idx, got[idx], want[idx])
+		}
+	}
+	if scene.Rects[2].Fill.R != 255 || scene.Rects[2].Fill.G != 0 {
+		t.Errorf("Render() subspan fill = %v, want red", scene.Rects[2].Fill)
+	}
+	if _, err := Render(data.DataSeries[0].Root.Children[0], data.StringTable, 200); err == nil {
+		t.Errorf("Render() of a non-trace yielded no error, but expected one")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	processCat := category.New("process", "Process", "Process")
+	threadCat := category.New("thread", "Thread", "Thread")
+	axis := continuousaxis.NewDurationAxis(cat, ns(0), ns(400))
+	name := func(name string) util.PropertyUpdate {
+		return util.StringProperty("name", name)
+	}
+	buildSearchResults := func(maxResults int) func(db util.DataBuilder) {
+		return func(db util.DataBuilder) {
+			search := NewSearch(axis, func(span *SearchedSpan[time.Duration]) bool {
+				name, _ := span.Properties.PropertyString(span.StringTable, "name")
+				return name == "rpc"
+			}, maxResults)
+			tr := New(util.NewDataResponseBuilder().DataSeries(&util.DataSeriesRequest{}), axis, rs)
+			process := tr.Category(processCat)
+			// Spans created before the search is attached are counted, but not
+			// searched.
+			process.Span(ns(0), ns(10), name("rpc"))
+			process.WithSearch(search)
+			process.Span(ns(10), ns(20), name("rpc"))
+			thread := process.Category(threadCat)
+			threadSpan := thread.Span(ns(0), ns(100), name("work"))
+			threadSpan.Span(ns(0), ns(10), name("lock"))
+			threadSpan.Span(ns(10), ns(20), name("rpc")).Subspan(ns(10), ns(15), name("rpc"))
+			thread.Span(ns(100), ns(200), name("rpc"))
+			search.Emit(db)
+		}
+	}
+	result := func(db testutil.TestDataBuilder, start, end int, categoryPath []string, offsets ...int64) {
+		db.Child().With(
+			util.StringsProperty(searchCategoryPathKey, categoryPath...),
+			util.IntegersProperty(searchSpanOffsetsKey, offsets...),
+			util.DurationProperty(startKey, ns(start)),
+			util.DurationProperty(endKey, ns(end)),
+		)
+	}
+	for _, test := range []struct {
+		description string
+		maxResults  int
+		buildWant   func(db testutil.TestDataBuilder)
+	}{{
+		description: "all results",
+		buildWant: func(db testutil.TestDataBuilder) {
+			db.With(
+				axis.Define(),
+				util.IntegerProperty(searchMatchCountKey, 3),
+			)
+			result(db, 10, 20, []string{"process"}, 1)
+			result(db, 10, 20, []string{"process", "thread"}, 0, 1)
+			result(db, 100, 200, []string{"process", "thread"}, 1)
+		},
+	}, {
+		description: "max results",
+		maxResults:  1,
+		buildWant: func(db testutil.TestDataBuilder) {
+			db.With(
+				axis.Define(),
+				util.IntegerProperty(searchMatchCountKey, 3),
+			)
+			result(db, 10, 20, []string{"process"}, 1)
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			if err := testutil.CompareResponses(t, buildSearchResults(test.maxResults), test.buildWant); err != nil {
+				t.Fatalf("encountered unexpected error building the search results: %s", err)
+			}
+		})
+	}
+}
+
+func TestOverview(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	processCat := category.New("process", "Process", "Process")
+	thread1Cat := category.New("thread1", "Thread 1", "Thread 1")
+	thread2Cat := category.New("thread2", "Thread 2", "Thread 2")
+	axis := continuousaxis.NewDurationAxis(cat, ns(0), ns(400))
+	buildTrace := func(db util.DataBuilder) {
+		overview := NewOverview(axis, 4)
+		process := New(db, axis, rs).Category(processCat)
+		processSpan := process.Span(ns(0), ns(400))
+		thread1 := process.Category(thread1Cat).WithOverview(overview)
+		thread1.Span(ns(0), ns(150)).Span(ns(0), ns(100))
+		// Overlapping spans are capped at the bin width.
+		thread1.Span(ns(300), ns(400))
+		thread1.Span(ns(350), ns(400))
+		thread2 := thread1.Category(thread2Cat)
+		thread2.Span(ns(250), ns(500))
+		overview.Emit(processSpan)
+	}
+	span := func(start, end int) util.PropertyUpdate {
+		return util.Chain(
+			util.IntegerProperty(nodeTypeKey, int64(spanNodeType)),
+			util.DurationProperty(startKey, ns(start)),
+			util.DurationProperty(endKey, ns(end)),
+		)
+	}
+	buildExplicit := func(db testutil.TestDataBuilder) {
+		process := db.With(
+			axis.Define(),
+			rs.Define(),
+		).Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			processCat.Define(),
+		)
+		overview := process.Child().With(span(0, 400)).Child().With(
+			util.StringProperty(payload.TypeKey, OverviewPayloadType),
+			util.IntegerProperty(overviewBinCountKey, 4),
+		)
+		overview.Child().With(
+			thread1Cat.Define(),
+			util.IntegersProperty(overviewBusyPermilleKey, 1000, 500, 0, 1000),
+		)
+		overview.Child().With(
+			thread2Cat.Define(),
+			util.IntegersProperty(overviewBusyPermilleKey, 0, 0, 500, 1000),
+		)
+		thread1 := process.Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			thread1Cat.Define(),
+		)
+		thread1.Child().With(span(0, 150)).Child().With(span(0, 100))
+		thread1.Child().With(span(300, 400))
+		thread1.Child().With(span(350, 400))
+		thread1.Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			thread2Cat.Define(),
+		).Child().With(span(250, 500))
+	}
+	if err := testutil.CompareResponses(t, buildTrace, buildExplicit); err != nil {
+		t.Fatalf("encountered unexpected error building the trace: %s", err)
+	}
+}
+
+func TestSpanAggregator(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	threadCat := category.New("thread", "Thread", "Thread")
+	axis := continuousaxis.NewDurationAxis(cat, ns(0), ns(1000))
+	lod, ok, err := LevelOfDetailFromOptions(map[string]*util.V{
+		ViewportExtentKey:  util.DurationValue(ns(1000)),
+		ViewportWidthPxKey: util.IntegerValue(100),
+	})
+	if err != nil || !ok {
+		t.Fatalf("LevelOfDetailFromOptions() = %v, %t, %v; want a LevelOfDetail", lod, ok, err)
+	}
+	buildTrace := func(db util.DataBuilder) {
+		agg := New(db, axis, rs).Category(threadCat).Aggregate(lod)
+		// Three narrow, nearby spans are aggregated.
+		agg.Span(ns(0), ns(5), pid(1))
+		agg.Span(ns(8), ns(12), pid(2))
+		agg.Span(ns(15), ns(17), pid(3))
+		// A wide span is emitted as is.
+		agg.Span(ns(20), ns(100), pid(4)).With(pid(5))
+		// A lone narrow span is emitted as is.
+		agg.Span(ns(200), ns(201), pid(6))
+		agg.Flush()
+	}
+	span := func(start, end int) util.PropertyUpdate {
+		return util.Chain(
+			util.IntegerProperty(nodeTypeKey, int64(spanNodeType)),
+			util.DurationProperty(startKey, ns(start)),
+			util.DurationProperty(endKey, ns(end)),
+		)
+	}
+	buildExplicit := func(db testutil.TestDataBuilder) {
+		db.With(
+			axis.Define(),
+			rs.Define(),
+		).Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			threadCat.Define(),
+		).Child().With(
+			span(0, 17),
+			util.IntegerProperty(aggregateCountKey, 3),
+			util.DurationProperty(aggregateTotalKey, ns(11)),
+			util.StringProperty(aggregateLabelKey, "3 spans"),
+		).AndChild().With(
+			span(20, 100),
+			pid(5),
+		).AndChild().With(
+			span(200, 201),
+			pid(6),
+		)
+	}
+	if err := testutil.CompareResponses(t, buildTrace, buildExplicit); err != nil {
+		t.Fatalf("encountered unexpected error building the trace: %s", err)
+	}
+	if _, ok, err := LevelOfDetailFromOptions(map[string]*util.V{}); ok || err != nil {
+		t.Errorf("LevelOfDetailFromOptions() with no options = %t, %v; want false, nil", ok, err)
+	}
+	if _, _, err := LevelOfDetailFromOptions(map[string]*util.V{
+		ViewportExtentKey:  util.StringValue("wide"),
+		ViewportWidthPxKey: util.IntegerValue(100),
+	}); err == nil {
+		t.Errorf("LevelOfDetailFromOptions() with malformed options yielded no error, but expected one")
+	}
+	vpLOD := LevelOfDetailFromViewport(&util.Viewport{
+		Start:   time.Time{},
+		End:     time.Time{}.Add(ns(1000)),
+		WidthPx: 100,
+	})
+	if *vpLOD != *lod {
+		t.Errorf("LevelOfDetailFromViewport() = %v, want %v", vpLOD, lod)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	axis := continuousaxis.NewDurationAxis(cat, ns(0), ns(1000))
+	available := []Dimension{{Key: "process", DisplayName: "Process"}, {Key: "thread", DisplayName: "Thread"}}
+	dims, err := DimensionsFromOptions(map[string]*util.V{
+		GroupByKey: util.StringsValue("process", "thread"),
+	}, available)
+	if err != nil {
+		t.Fatalf("DimensionsFromOptions() yielded unexpected error %s", err)
+	}
+	buildTrace := func(db util.DataBuilder) {
+		g := New(db, axis, rs).GroupBy(dims...)
+		g.Span(GroupKeys{"process": "1", "thread": "10"}, ns(0), ns(10), pid(1))
+		g.Span(GroupKeys{"process": "2", "thread": "20"}, ns(5), ns(15), pid(2))
+		g.Span(GroupKeys{"process": "1", "thread": "11"}, ns(10), ns(20), pid(3))
+		g.Span(GroupKeys{"process": "1", "thread": "10"}, ns(20), ns(30), pid(4))
+	}
+	catNode := func(id, displayName, description string) util.PropertyUpdate {
+		return util.Chain(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			category.New(id, displayName, description).Define(),
+		)
+	}
+	span := func(start, end int, pidVal int64) util.PropertyUpdate {
+		return util.Chain(
+			util.IntegerProperty(nodeTypeKey, int64(spanNodeType)),
+			util.DurationProperty(startKey, ns(start)),
+			util.DurationProperty(endKey, ns(end)),
+			pid(pidVal),
+		)
+	}
+	buildExplicit := func(db testutil.TestDataBuilder) {
+		db.With(
+			axis.Define(),
+			rs.Define(),
+		).Child().With(
+			catNode("process=1", "Process 1", "Spans with Process '1'"),
+		).Child().With(
+			catNode("process=1/thread=10", "Thread 10", "Spans with Thread '10'"),
+		).Child().With(
+			span(0, 10, 1),
+		).AndChild().With(
+			span(20, 30, 4),
+		).Parent().AndChild().With(
+			catNode("process=1/thread=11", "Thread 11", "Spans with Thread '11'"),
+		).Child().With(
+			span(10, 20, 3),
+		).Parent().Parent().AndChild().With(
+			catNode("process=2", "Process 2", "Spans with Process '2'"),
+		).Child().With(
+			catNode("process=2/thread=20", "Thread 20", "Spans with Thread '20'"),
+		).Child().With(
+			span(5, 15, 2),
+		)
+	}
+	if err := testutil.CompareResponses(t, buildTrace, buildExplicit); err != nil {
+		t.Fatalf("encountered unexpected error building the trace: %s", err)
+	}
+	if got, err := DimensionsFromOptions(map[string]*util.V{}, available, available[1]); err != nil || len(got) != 1 || got[0] != available[1] {
+		t.Errorf("DimensionsFromOptions() with no options = %v, %v; want the defaults", got, err)
+	}
+	if _, err := DimensionsFromOptions(map[string]*util.V{
+		GroupByKey: util.StringsValue("connection"),
+	}, available); err == nil {
+		t.Errorf("DimensionsFromOptions() with unavailable dimension yielded no error, but expected one")
+	}
+}
+
+func pid(pid int64) util.PropertyUpdate {
+	return util.IntegerProperty("pid", pid)
+}
+
+func TestTypedPayloads(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	processCat := category.New("process", "Process", "Process")
+	axis := continuousaxis.NewDurationAxis(cat, ns(0), ns(100))
+	logTime := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	buildTrace := func(db util.DataBuilder) {
+		span := New(db, axis, rs).Category(processCat).Span(ns(0), ns(100))
+		NewLogExcerpt(span,
+			LogLine{Timestamp: logTime, Text: "starting"},
+			LogLine{Timestamp: logTime.Add(time.Second), Text: "done"},
+		)
+		NewStackTrace(span,
+			StackFrame{Function: "work", File: "work.go", Line: 10},
+			StackFrame{Function: "main"},
+		)
+		NewLink(span, "https://example.com/1", "Details").With(pid(1))
+	}
+	buildExplicit := func(db testutil.TestDataBuilder) {
+		span := db.With(
+			axis.Define(),
+			rs.Define(),
+		).Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			processCat.Define(),
+		).Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(spanNodeType)),
+			util.DurationProperty(startKey, ns(0)),
+			util.DurationProperty(endKey, ns(100)),
+		)
+		logExcerpt := span.Child().With(
+			util.StringProperty(payload.TypeKey, LogExcerptPayloadType),
+		)
+		logExcerpt.Child().With(
+			util.TimestampProperty(logLineTimestampKey, logTime),
+			util.StringProperty(logLineTextKey, "starting"),
+		)
+		logExcerpt.Child().With(
+			util.TimestampProperty(logLineTimestampKey, logTime.Add(time.Second)),
+			util.StringProperty(logLineTextKey, "done"),
+		)
+		stackTrace := span.Child().With(
+			util.StringProperty(payload.TypeKey, StackTracePayloadType),
+		)
+		stackTrace.Child().With(
+			util.StringProperty(stackFrameFunctionKey, "work"),
+			util.StringProperty(stackFrameFileKey, "work.go"),
+			util.IntegerProperty(stackFrameLineKey, 10),
+		)
+		stackTrace.Child().With(
+			util.StringProperty(stackFrameFunctionKey, "main"),
+		)
+		span.Child().With(
+			util.StringProperty(payload.TypeKey, LinkPayloadType),
+			util.StringProperty(linkURLKey, "https://example.com/1"),
+			util.StringProperty(linkLabelKey, "Details"),
+			pid(1),
+		)
+	}
+	if err := testutil.CompareResponses(t, buildTrace, buildExplicit); err != nil {
+		t.Fatalf("encountered unexpected error building the trace: %s", err)
+	}
+}
+
+func TestCounterTrack(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	processCat := category.New("process", "Process", "Process")
+	cpuCat := category.New("cpu", "CPU", "CPU utilization")
+	utilCat := category.New("util", "Utilization", "Utilization (%)")
+	axis := continuousaxis.NewDurationAxis(cat, ns(0), ns(100))
+	yAxis := continuousaxis.NewDoubleAxis(utilCat, 0, 100)
+	buildTrace := func(db util.DataBuilder) {
+		process := New(db, axis, rs).Category(processCat)
+		NewCounterTrack(process, cpuCat, yAxis, pid(1)).
+			AddSeries(utilCat).
+			WithPoint(ns(0), 12.5).
+			WithPoint(ns(50), 80)
+	}
+	buildExplicit := func(db testutil.TestDataBuilder) {
+		chart := db.With(
+			axis.Define(),
+			rs.Define(),
+		).Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			processCat.Define(),
+		).Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			cpuCat.Define(),
+			pid(1),
+		).Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(spanNodeType)),
+			util.DurationProperty(startKey, ns(0)),
+			util.DurationProperty(endKey, ns(100)),
+		).Child().With(
+			util.StringProperty(payload.TypeKey, CounterTrackPayloadType),
+		)
+		axes := chart.Child()
+		axes.Child().With(axis.Define())
+		axes.Child().With(yAxis.Define())
+		series := chart.Child().With(utilCat.Define())
+		series.Child().With(
+			util.DurationProperty("x_axis", ns(0)),
+			util.DoubleProperty("util", 12.5),
+		)
+		series.Child().With(
+			util.DurationProperty("x_axis", ns(50)),
+			util.DoubleProperty("util", 80),
+		)
+	}
+	if err := testutil.CompareResponses(t, buildTrace, buildExplicit); err != nil {
+		t.Fatalf("encountered unexpected error building the trace: %s", err)
+	}
+}
+
+func TestSpanAt(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	cpuCat := category.New("cpu", "CPU", "CPU")
+	axis := continuousaxis.NewDurationAxis(cat, ns(0), ns(100)).WithAnchor(now)
+	buildTrace := func(db util.DataBuilder) {
+		span, err := New(db, axis, rs).Category(cpuCat).SpanAt(now.Add(ns(10)), now.Add(ns(90)))
+		if err != nil {
+			t.Fatalf("SpanAt yielded unexpected error %s", err)
+		}
+		if _, err := span.SubspanAt(now.Add(ns(20)), now.Add(ns(30))); err != nil {
+			t.Fatalf("SubspanAt yielded unexpected error %s", err)
+		}
+		if _, err := span.SpanAt(now.Add(ns(40)), now.Add(ns(50))); err != nil {
+			t.Fatalf("SpanAt yielded unexpected error %s", err)
+		}
+	}
+	buildExplicit := func(db util.DataBuilder) {
+		span := New(db, axis, rs).Category(cpuCat).Span(ns(10), ns(90))
+		span.Subspan(ns(20), ns(30))
+		span.Span(ns(40), ns(50))
+	}
+	if err := testutil.CompareResponses(t, buildTrace, buildExplicit); err != nil {
+		t.Fatalf("encountered unexpected error building the trace: %s", err)
+	}
+	for _, test := range []struct {
+		description string
+		spanAt      func(db util.DataBuilder) error
+	}{{
+		description: "start before axis",
+		spanAt: func(db util.DataBuilder) error {
+			_, err := New(db, axis, rs).Category(cpuCat).SpanAt(now.Add(-ns(1)), now.Add(ns(10)))
+			return err
+		},
+	}, {
+		description: "end after axis",
+		spanAt: func(db util.DataBuilder) error {
+			_, err := New(db, axis, rs).Category(cpuCat).SpanAt(now, now.Add(ns(101)))
+			return err
+		},
+	}, {
+		description: "unanchored duration axis",
+		spanAt: func(db util.DataBuilder) error {
+			_, err := New(db, continuousaxis.NewDurationAxis(cat, ns(0), ns(100)), rs).Category(cpuCat).SpanAt(now, now)
+			return err
+		},
+	}, {
+		description: "double axis",
+		spanAt: func(db util.DataBuilder) error {
+			_, err := New(db, continuousaxis.NewDoubleAxis(cat, 0, 100), rs).Category(cpuCat).SpanAt(now, now)
+			return err
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := util.NewDataResponseBuilder()
+			if err := test.spanAt(drb.DataSeries(&util.DataSeriesRequest{})); err == nil {
+				t.Errorf("SpanAt yielded no error")
+			}
+		})
+	}
+}
+
+func TestExtentPolicy(t *testing.T) {
+	cat := category.New("x_axis", "Trace time", "Time from start of trace")
+	cpuCat := category.New("cpu", "CPU", "CPU")
+	buildSpans := func(trace *Trace[time.Duration]) {
+		span := trace.Category(cpuCat).Span(ns(-10), ns(50))
+		span.Subspan(ns(40), ns(120))
+		span.Span(ns(10), ns(20))
+	}
+	span := func(nt traceNodeType, start, end int, properties ...util.PropertyUpdate) util.PropertyUpdate {
+		return util.Chain(
+			util.IntegerProperty(nodeTypeKey, int64(nt)),
+			util.DurationProperty(startKey, ns(start)),
+			util.DurationProperty(endKey, ns(end)),
+			util.Chain(properties...),
+		)
+	}
+	buildClamped := func(db util.DataBuilder) {
+		buildSpans(New(db, continuousaxis.NewDurationAxis(cat, ns(0), ns(100)), rs).WithExtentPolicy(ClampExtents))
+	}
+	buildExplicit := func(db testutil.TestDataBuilder) {
+		db.With(
+			continuousaxis.NewDurationAxis(cat, ns(0), ns(100)).Define(),
+			rs.Define(),
+		).Child().With(
+			util.IntegerProperty(nodeTypeKey, int64(categoryNodeType)),
+			cpuCat.Define(),
+		).Child().With(
+			span(spanNodeType, 0, 50, util.IntegerProperty(clippedStartKey, 1)),
+		).Child().With(
+			span(subspanNodeType, 40, 100, util.IntegerProperty(clippedEndKey, 1)),
+		).AndChild().With(
+			span(spanNodeType, 10, 20),
+		)
+	}
+	if err := testutil.CompareResponses(t, buildClamped, buildExplicit); err != nil {
+		t.Fatalf("encountered unexpected error building the trace: %s", err)
+	}
+	for _, test := range []struct {
+		description string
+		policy      ExtentPolicy
+		wantErr     string
+	}{{
+		description: "unchecked",
+		policy:      UncheckedExtents,
+	}, {
+		description: "clamped",
+		policy:      ClampExtents,
+	}, {
+		description: "rejected",
+		policy:      RejectExtents,
+		wantErr:     "span [-10ns, 50ns] in trace category 'cpu' lies outside the extent [0s, 100ns] of axis 'x_axis'",
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := util.NewDataResponseBuilder()
+			trace := New(drb.DataSeries(&util.DataSeriesRequest{}), continuousaxis.NewDurationAxis(cat, ns(0), ns(100)), rs).WithExtentPolicy(test.policy)
+			buildSpans(trace)
+			gotErr := ""
+			if err := trace.Err(); err != nil {
+				gotErr = err.Error()
+			}
+			if gotErr != test.wantErr {
+				t.Errorf("Err() = %q, want %q", gotErr, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		start, end  int
+		boundaries  []int
+		want        []Extent[time.Duration]
+	}{{
+		description: "no boundaries",
+		start:       10,
+		end:         20,
+		want:        []Extent[time.Duration]{{ns(10), ns(20)}},
+	}, {
+		description: "boundaries outside and at endpoints ignored",
+		start:       10,
+		end:         20,
+		boundaries:  []int{0, 10, 20, 30},
+		want:        []Extent[time.Duration]{{ns(10), ns(20)}},
+	}, {
+		description: "split at filter range",
+		start:       10,
+		end:         50,
+		boundaries:  []int{40, 20, 40},
+		want:        []Extent[time.Duration]{{ns(10), ns(20)}, {ns(20), ns(40)}, {ns(40), ns(50)}},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			boundaries := make([]time.Duration, len(test.boundaries))
+			for idx, boundary := range test.boundaries {
+				boundaries[idx] = ns(boundary)
+			}
+			got := Split(ns(test.start), ns(test.end), boundaries...)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Split() = %v, diff (-want +got):\n%s", got, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package trace
+
+import (
+	"fmt"
+
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/payload"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Detect reports whether the provided Datum, whose string indices refer to the
+// provided string table, appears to be the root of a trace: that is, whether
+// it defines an axis and has either no children or trace node children.
+func Detect(root *util.Datum, st []string) bool {
+	if _, ok := continuousaxis.DefinedPositioner(root, st); !ok {
+		return false
+	}
+	if len(root.Children) == 0 {
+		return true
+	}
+	_, ok := nodeType(root.Children[0], st)
+	return ok
+}
+
+// validator checks a trace response against the trace data model.
+type validator struct {
+	st  []string
+	pos *continuousaxis.Positioner
+}
+
+// Validate checks that the trace rooted at the provided Datum, whose string
+// indices refer to the provided string table, conforms to the trace data
+// model: that its axis is defined; that its categories, spans, subspans, and
+// payloads are legally nested; that its categories are defined; and that its
+// spans and subspans have extents lying within its axis.
+func Validate(root *util.Datum, st []string) error {
+	pos, ok := continuousaxis.DefinedPositioner(root, st)
+	if !ok {
+		return fmt.Errorf("trace has no axis definition")
+	}
+	v := &validator{
+		st:  st,
+		pos: pos,
+	}
+	for idx, child := range root.Children {
+		if err := v.child(child, "trace", idx, false, categoryNodeType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// child checks the provided Datum, the idx'th child of the described parent.
+// It must be a payload, if payloads are allowed, or a trace node of one of
+// the allowed types.
+func (v *validator) child(d *util.Datum, parent string, idx int, payloadsAllowed bool, allowed ...traceNodeType) error {
+	if _, ok := payload.TypeOf(d, v.st); ok && payloadsAllowed {
+		return nil
+	}
+	nt, ok := nodeType(d, v.st)
+	if !ok {
+		return fmt.Errorf("%s child %d is not a trace node", parent, idx)
+	}
+	for _, a := range allowed {
+		if nt != a {
+			continue
+		}
+		switch nt {
+		case categoryNodeType:
+			return v.category(d)
+		case spanNodeType:
+			return v.span(d, parent, true)
+		default:
+			return v.span(d, parent, false)
+		}
+	}
+	return fmt.Errorf("%s child %d has illegal trace node type %d", parent, idx, nt)
+}
+
+// category checks the provided trace category Datum.
+func (v *validator) category(d *util.Datum) error {
+	cat, ok := category.Defined(d, v.st)
+	if !ok {
+		return fmt.Errorf("trace category has no category definition")
+	}
+	desc := fmt.Sprintf("trace category '%s'", cat.ID())
+	for idx, child := range d.Children {
+		if err := v.child(child, desc, idx, false, categoryNodeType, spanNodeType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// span checks the provided span, if isSpan is true, or subspan Datum, under
+// the described parent.
+func (v *validator) span(d *util.Datum, parent string, isSpan bool) error {
+	desc := "subspan"
+	if isSpan {
+		desc = "span"
+	}
+	desc = parent + " " + desc
+	start, startOK := v.pos.Value(d, v.st, startKey)
+	end, endOK := v.pos.Value(d, v.st, endKey)
+	if !startOK || !endOK {
+		return fmt.Errorf("%s has missing or malformed extents", desc)
+	}
+	if start > end {
+		return fmt.Errorf("%s ends before it starts", desc)
+	}
+	if start < 0 || end > 1 {
+		return fmt.Errorf("%s lies outside the trace axis", desc)
+	}
+	for idx, child := range d.Children {
+		var err error
+		if isSpan {
+			err = v.child(child, desc, idx, true, spanNodeType, subspanNodeType)
+		} else {
+			err = v.child(child, desc, idx, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -34,27 +34,28 @@ import (
 	"time"
 
 	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/payload"
 	"github.com/google/traceviz/server/go/util"
 )
 
 const (
-	nodeIDKey          = "trace_edge_node_id"
-	startKey           = "trace_edge_start"
-	endpointNodeIDsKey = "trace_edge_endpoint_node_ids"
+	nodeIDKey          = keys.TraceEdgeNodeID
+	startKey           = keys.TraceEdgeStart
+	endpointNodeIDsKey = keys.TraceEdgeEndpointNodeIDs
 
 	// PayloadType defines the payload type for trace edge nodes.
 	PayloadType = "trace_edge_payload"
 )
 
 // Node defines an endpoint in a trace edge graph.
-type Node[T float64 | time.Duration | time.Time] struct {
+type Node[T int64 | float64 | time.Duration | time.Time] struct {
 	db util.DataBuilder
 }
 
 // New produces a new Node in the provided DataBuilder, with the provided
 // offset, ID, and endpoint node IDs.
-func New[T float64 | time.Duration | time.Time](axis *continuousaxis.Axis[T], parent payload.Payloader, start T, id string, edgeEndpointNodeIDs ...string) *Node[T] {
+func New[T int64 | float64 | time.Duration | time.Time](axis *continuousaxis.Axis[T], parent payload.Payloader, start T, id string, edgeEndpointNodeIDs ...string) *Node[T] {
 	return &Node[T]{
 		db: payload.New(parent, PayloadType).With(
 			util.StringProperty(nodeIDKey, id),
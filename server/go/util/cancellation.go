@@ -0,0 +1,50 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import "context"
+
+// CtxCheckInterval is the number of loop iterations between the Context
+// checks made by CheckCtx and WithCtxChecks.  Checking a Context is cheap, but
+// not free; checking periodically bounds both the overhead in tight loops and
+// the work done after a request is abandoned.
+const CtxCheckInterval = 1024
+
+// CheckCtx returns the provided Context's error if the provided loop
+// iteration is a multiple of CtxCheckInterval and the Context is done, and
+// nil otherwise.  Long-running loops in data sources should call it on each
+// iteration, returning any error, so that work for abandoned requests, e.g.
+// from a closed browser tab, stops promptly.
+func CheckCtx(ctx context.Context, iteration int) error {
+	if iteration%CtxCheckInterval != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// WithCtxChecks returns the provided callback wrapped to instead return the
+// provided Context's error once that Context is done, as checked by CheckCtx
+// on each invocation.  It is suitable for callback-driven iteration, such as
+// a ForEachEntry visitor.  The returned callback is not safe for concurrent
+// use.
+func WithCtxChecks[T any](ctx context.Context, fn func(T) error) func(T) error {
+	iteration := 0
+	return func(t T) error {
+		if err := CheckCtx(ctx, iteration); err != nil {
+			return err
+		}
+		iteration++
+		return fn(t)
+	}
+}
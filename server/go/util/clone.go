@@ -0,0 +1,208 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+)
+
+// CloneV returns a deep copy of the provided V.
+func CloneV(v *V) *V {
+	if v == nil {
+		return nil
+	}
+	ret := &V{
+		V: v.V,
+		T: v.T,
+	}
+	switch val := v.V.(type) {
+	case []string:
+		ret.V = append([]string{}, val...)
+	case []int64:
+		ret.V = append([]int64{}, val...)
+	case []float64:
+		ret.V = append([]float64{}, val...)
+	}
+	return ret
+}
+
+func cloneVs(vs map[string]*V) map[string]*V {
+	if vs == nil {
+		return nil
+	}
+	ret := make(map[string]*V, len(vs))
+	for k, v := range vs {
+		ret[k] = CloneV(v)
+	}
+	return ret
+}
+
+// CloneDatum returns a deep copy of the provided Datum and its descendants.
+// The copy's string indices refer to the same string table as the original's.
+func CloneDatum(d *Datum) *Datum {
+	if d == nil {
+		return nil
+	}
+	ret := &Datum{
+		Properties: make(map[int64]*V, len(d.Properties)),
+		Children:   make([]*Datum, len(d.Children)),
+	}
+	for k, v := range d.Properties {
+		ret.Properties[k] = CloneV(v)
+	}
+	for idx, child := range d.Children {
+		ret.Children[idx] = CloneDatum(child)
+	}
+	return ret
+}
+
+func cloneDataSeries(ds *DataSeries) *DataSeries {
+	ret := *ds
+	ret.Root = CloneDatum(ds.Root)
+	return &ret
+}
+
+// CloneData returns a deep copy of the provided Data, which may then be
+// modified without affecting the original.
+func CloneData(d *Data) *Data {
+	if d == nil {
+		return nil
+	}
+	ret := &Data{
+		StringTable:   append([]string{}, d.StringTable...),
+		DataSeries:    make([]*DataSeries, len(d.DataSeries)),
+		GlobalFilters: cloneVs(d.GlobalFilters),
+	}
+	for idx, ds := range d.DataSeries {
+		ret.DataSeries[idx] = cloneDataSeries(ds)
+	}
+	for _, stats := range d.SeriesStats {
+		stats := *stats
+		ret.SeriesStats = append(ret.SeriesStats, &stats)
+	}
+	return ret
+}
+
+// MergePolicy specifies how MergeData resolves data series, and global
+// filters, present in both its destination and its source.
+type MergePolicy int
+
+const (
+	// KeepDestination keeps the destination's data series or global filter,
+	// discarding the source's.
+	KeepDestination MergePolicy = iota
+	// ReplaceDestination replaces the destination's data series or global
+	// filter with the source's.  Replaced data series keep their position.
+	ReplaceDestination
+	// FailOnConflict fails the merge, leaving the destination unchanged.
+	// Global filters with equal values do not conflict.
+	FailOnConflict
+)
+
+// stringTableOf returns a stringTable holding the provided strings at their
+// current indices.
+func stringTableOf(strs []string) *stringTable {
+	ret := &stringTable{
+		stringsToIndices: make(map[string]int64, len(strs)),
+		stringsByIndex:   append([]string{}, strs...),
+	}
+	for idx, str := range strs {
+		if _, ok := ret.stringsToIndices[str]; !ok {
+			ret.stringsToIndices[str] = int64(idx)
+		}
+	}
+	return ret
+}
+
+// MergeData merges the data series, global filters, and series stats of src
+// into dst, rewriting the string indices of src's data series to refer to
+// dst's string table, which is extended as needed.  Data series and global
+// filters present in both are resolved by the provided MergePolicy; data
+// series from src that are not merged do not contribute series stats.  src is
+// not modified, and dst shares no values with it.
+func MergeData(dst, src *Data, policy MergePolicy) error {
+	dstSeriesIdxs := make(map[string]int, len(dst.DataSeries))
+	for idx, ds := range dst.DataSeries {
+		dstSeriesIdxs[ds.SeriesName] = idx
+	}
+	if policy == FailOnConflict {
+		for _, ds := range src.DataSeries {
+			if _, ok := dstSeriesIdxs[ds.SeriesName]; ok {
+				return fmt.Errorf("data series '%s' present in both merged responses", ds.SeriesName)
+			}
+		}
+		for k, srcV := range src.GlobalFilters {
+			if dstV, ok := dst.GlobalFilters[k]; ok && dstV.PrettyPrint(dst.StringTable) != srcV.PrettyPrint(src.StringTable) {
+				return fmt.Errorf("global filter '%s' differs between merged responses", k)
+			}
+		}
+	}
+	st := stringTableOf(dst.StringTable)
+	// Remap a copy of each source series first, so that a malformed source
+	// leaves dst unchanged.
+	srcSeries := make([]*DataSeries, len(src.DataSeries))
+	for idx, ds := range src.DataSeries {
+		ds = cloneDataSeries(ds)
+		if ds.Root != nil {
+			if err := remapDatum(ds.Root, src.StringTable, st); err != nil {
+				return fmt.Errorf("data series '%s': %s", ds.SeriesName, err)
+			}
+		}
+		srcSeries[idx] = ds
+	}
+	globalFilters := cloneVs(src.GlobalFilters)
+	for k, v := range globalFilters {
+		if err := remapV(v, src.StringTable, st); err != nil {
+			return fmt.Errorf("global filter '%s': %s", k, err)
+		}
+	}
+	merged := map[string]bool{}
+	for _, ds := range srcSeries {
+		idx, ok := dstSeriesIdxs[ds.SeriesName]
+		switch {
+		case !ok:
+			dstSeriesIdxs[ds.SeriesName] = len(dst.DataSeries)
+			dst.DataSeries = append(dst.DataSeries, ds)
+		case policy == ReplaceDestination:
+			dst.DataSeries[idx] = ds
+		default:
+			continue
+		}
+		merged[ds.SeriesName] = true
+	}
+	if len(globalFilters) > 0 && dst.GlobalFilters == nil {
+		dst.GlobalFilters = map[string]*V{}
+	}
+	for k, v := range globalFilters {
+		if _, ok := dst.GlobalFilters[k]; !ok || policy == ReplaceDestination {
+			dst.GlobalFilters[k] = v
+		}
+	}
+	// Series stats of replaced series are replaced too.
+	keptStats := dst.SeriesStats[:0]
+	for _, stats := range dst.SeriesStats {
+		if !merged[stats.SeriesName] {
+			keptStats = append(keptStats, stats)
+		}
+	}
+	dst.SeriesStats = keptStats
+	for _, stats := range src.SeriesStats {
+		if merged[stats.SeriesName] {
+			stats := *stats
+			dst.SeriesStats = append(dst.SeriesStats, &stats)
+		}
+	}
+	dst.StringTable = st.stringsByIndex
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/google/traceviz/server/go/util"
+)
+
+// snapshotKey identifies a captured DataSeries by the query, options, and
+// global filters that produced it.
+type snapshotKey struct {
+	queryName     string
+	options       string
+	globalFilters string
+}
+
+func newSnapshotKey(globalFilters map[string]*util.V, req *util.DataSeriesRequest) (snapshotKey, error) {
+	// JSON-encoding a map sorts its keys, so equal maps yield equal encodings.
+	options, err := json.Marshal(req.Options)
+	if err != nil {
+		return snapshotKey{}, err
+	}
+	gf, err := json.Marshal(globalFilters)
+	if err != nil {
+		return snapshotKey{}, err
+	}
+	return snapshotKey{
+		queryName:     req.QueryName,
+		options:       string(options),
+		globalFilters: string(gf),
+	}, nil
+}
+
+// snapshotSeries is a captured DataSeries with its string table.
+type snapshotSeries struct {
+	root        *util.Datum
+	stringTable []string
+}
+
+// DataSource is a QueryDispatcher data source serving captured Snapshots.  It
+// responds to each DataSeriesRequest with the captured DataSeries having the
+// same query name, options, and global filters, and fails requests for which
+// no such DataSeries was captured.
+type DataSource struct {
+	queries []string
+	series  map[snapshotKey]*snapshotSeries
+}
+
+// NewDataSource returns a new DataSource serving the provided Snapshots.  If
+// several Snapshots capture the same request, the latest one is served.
+func NewDataSource(snapshots ...*Snapshot) (*DataSource, error) {
+	ds := &DataSource{
+		series: map[snapshotKey]*snapshotSeries{},
+	}
+	queries := map[string]struct{}{}
+	for _, snapshot := range snapshots {
+		for _, series := range snapshot.Series {
+			key, err := newSnapshotKey(series.GlobalFilters, series.Request)
+			if err != nil {
+				return nil, err
+			}
+			ds.series[key] = &snapshotSeries{
+				root:        series.Root,
+				stringTable: snapshot.StringTable,
+			}
+			queries[series.Request.QueryName] = struct{}{}
+		}
+	}
+	for query := range queries {
+		ds.queries = append(ds.queries, query)
+	}
+	sort.Strings(ds.queries)
+	return ds, nil
+}
+
+// LoadDataSource returns a new DataSource serving the Snapshots in the
+// specified files.
+func LoadDataSource(filenames ...string) (*DataSource, error) {
+	snapshots := make([]*Snapshot, 0, len(filenames))
+	for _, filename := range filenames {
+		snapshot, err := ReadSnapshotFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot %s: %s", filename, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return NewDataSource(snapshots...)
+}
+
+// SupportedDataSeriesQueries returns the query names of all captured
+// DataSeries.
+func (ds *DataSource) SupportedDataSeriesQueries() []string {
+	return ds.queries
+}
+
+// HandleDataSeriesRequests responds to the provided DataSeriesRequests with
+// the matching captured DataSeries.
+func (ds *DataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	for _, req := range reqs {
+		key, err := newSnapshotKey(globalState, req)
+		if err != nil {
+			return err
+		}
+		series, ok := ds.series[key]
+		if !ok {
+			return fmt.Errorf("no snapshot of query '%s' with the requested options and global filters", req.QueryName)
+		}
+		// AddDataSeries remaps the DataSeries in place, so add a copy.
+		if err := drb.AddDataSeries(&util.DataSeries{
+			SeriesName: req.SeriesName,
+			Root:       cloneDatum(series.root),
+		}, series.stringTable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneDatum returns a copy of the provided Datum, suitable for passing to
+// DataResponseBuilder.AddDataSeries.
+func cloneDatum(d *util.Datum) *util.Datum {
+	ret := &util.Datum{
+		Properties: make(map[int64]*util.V, len(d.Properties)),
+		Children:   make([]*util.Datum, len(d.Children)),
+	}
+	for k, v := range d.Properties {
+		ret.Properties[k] = &util.V{V: v.V, T: v.T}
+	}
+	for idx, child := range d.Children {
+		ret.Children[idx] = cloneDatum(child)
+	}
+	return ret
+}
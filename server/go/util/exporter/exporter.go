@@ -0,0 +1,134 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package exporter supports capturing TraceViz Data responses into
+// standalone snapshot files, and serving those snapshots back as a
+// QueryDispatcher data source.  This allows the backend data of an
+// investigation to be shared and replayed without access to the original
+// traces or logs.
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// SnapshotVersion is the version of the snapshot format written by this
+// package.
+const SnapshotVersion = 1
+
+// SnapshotSeries is a single captured DataSeries, along with the request that
+// produced it.
+type SnapshotSeries struct {
+	GlobalFilters map[string]*util.V
+	Request       *util.DataSeriesRequest
+	// The root of the captured DataSeries.  Its string indices refer to the
+	// enclosing Snapshot's StringTable.
+	Root *util.Datum
+}
+
+// Snapshot is a set of captured DataSeries.
+type Snapshot struct {
+	Version     int
+	StringTable []string
+	Series      []*SnapshotSeries
+}
+
+// NewSnapshot returns a new Snapshot of the provided Data, which must be the
+// response to the provided DataRequest.
+func NewSnapshot(req *util.DataRequest, data *util.Data) (*Snapshot, error) {
+	seriesReqsByName := make(map[string]*util.DataSeriesRequest, len(req.SeriesRequests))
+	for _, seriesReq := range req.SeriesRequests {
+		seriesReqsByName[seriesReq.SeriesName] = seriesReq
+	}
+	ret := &Snapshot{
+		Version:     SnapshotVersion,
+		StringTable: data.StringTable,
+	}
+	for _, series := range data.DataSeries {
+		seriesReq, ok := seriesReqsByName[series.SeriesName]
+		if !ok {
+			return nil, fmt.Errorf("data series '%s' has no corresponding request", series.SeriesName)
+		}
+		if series.NotModified {
+			return nil, fmt.Errorf("data series '%s' was not modified, so has no content to capture", series.SeriesName)
+		}
+		ret.Series = append(ret.Series, &SnapshotSeries{
+			GlobalFilters: req.GlobalFilters,
+			Request:       seriesReq,
+			Root:          series.Root,
+		})
+	}
+	return ret, nil
+}
+
+// Capture handles the provided DataRequest with the provided QueryDispatcher,
+// and returns a Snapshot of the response.
+func Capture(ctx context.Context, qd *querydispatcher.QueryDispatcher, req *util.DataRequest) (*Snapshot, error) {
+	data, err := qd.HandleDataRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return NewSnapshot(req, data)
+}
+
+// Write writes the receiver, as JSON, to the provided Writer.
+func (s *Snapshot) Write(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		return fmt.Errorf("failed to write snapshot: %s", err)
+	}
+	return nil
+}
+
+// WriteFile writes the receiver, as JSON, to the specified file.
+func (s *Snapshot) WriteFile(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := s.Write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadSnapshot reads a Snapshot written by Snapshot.Write from the provided
+// Reader.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	ret := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(ret); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %s", err)
+	}
+	if ret.Version != SnapshotVersion {
+		return nil, fmt.Errorf("can't read snapshot version %d (want %d)", ret.Version, SnapshotVersion)
+	}
+	return ret, nil
+}
+
+// ReadSnapshotFile reads a Snapshot written by Snapshot.WriteFile from the
+// specified file.
+func ReadSnapshotFile(filename string) (*Snapshot, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadSnapshot(file)
+}
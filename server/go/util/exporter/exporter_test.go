@@ -0,0 +1,162 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	querydispatcher "github.com/google/traceviz/server/go/query_dispatcher"
+	"github.com/google/traceviz/server/go/util"
+)
+
+const collectionNameKey = "collection_name"
+
+// testDataSource responds to each request with a series describing the
+// request.
+type testDataSource struct{}
+
+func (tds *testDataSource) SupportedDataSeriesQueries() []string {
+	return []string{"test.query"}
+}
+
+func (tds *testDataSource) HandleDataSeriesRequests(ctx context.Context, globalState map[string]*util.V, drb *util.DataResponseBuilder, reqs []*util.DataSeriesRequest) error {
+	collectionName, err := util.ExpectStringValue(globalState[collectionNameKey])
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		depth, err := util.ExpectIntegerValue(req.Options["depth"])
+		if err != nil {
+			return err
+		}
+		db := drb.DataSeries(req).With(
+			util.StringProperty("collection", collectionName),
+			util.StringsProperty("tags", "a", "b"),
+		)
+		for i := int64(0); i < depth; i++ {
+			db = db.Child().With(
+				util.IntegerProperty("depth", i),
+				util.DurationProperty("dur", time.Duration(i)*time.Second),
+				util.TimestampProperty("at", time.Unix(i, 0)),
+			)
+		}
+	}
+	return nil
+}
+
+func newRequest(collectionName string, depths ...int64) *util.DataRequest {
+	ret := &util.DataRequest{
+		GlobalFilters: map[string]*util.V{
+			collectionNameKey: util.StringValue(collectionName),
+		},
+	}
+	for idx, depth := range depths {
+		ret.SeriesRequests = append(ret.SeriesRequests, &util.DataSeriesRequest{
+			QueryName:  "test.query",
+			SeriesName: string(rune('a' + idx)),
+			Options: map[string]*util.V{
+				"depth": util.IntValue(depth),
+			},
+		})
+	}
+	return ret
+}
+
+func sortedPrettyPrint(data *util.Data) string {
+	if len(data.DataSeries) == 2 && data.DataSeries[0].SeriesName > data.DataSeries[1].SeriesName {
+		data.DataSeries[0], data.DataSeries[1] = data.DataSeries[1], data.DataSeries[0]
+	}
+	return data.PrettyPrint()
+}
+
+func TestCaptureAndReplay(t *testing.T) {
+	liveQD, err := querydispatcher.New(&testDataSource{})
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	ctx := context.Background()
+	req := newRequest("coll", 1, 2)
+	snapshot, err := Capture(ctx, liveQD, req)
+	if err != nil {
+		t.Fatalf("Capture() yielded unexpected error %s", err)
+	}
+	// Round-trip the snapshot through both a buffer and a file.
+	buf := &bytes.Buffer{}
+	if err := snapshot.Write(buf); err != nil {
+		t.Fatalf("Write() yielded unexpected error %s", err)
+	}
+	readSnapshot, err := ReadSnapshot(buf)
+	if err != nil {
+		t.Fatalf("ReadSnapshot() yielded unexpected error %s", err)
+	}
+	filename := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := readSnapshot.WriteFile(filename); err != nil {
+		t.Fatalf("WriteFile() yielded unexpected error %s", err)
+	}
+	replayDS, err := LoadDataSource(filename)
+	if err != nil {
+		t.Fatalf("LoadDataSource() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff([]string{"test.query"}, replayDS.SupportedDataSeriesQueries()); diff != "" {
+		t.Errorf("SupportedDataSeriesQueries() diff (-want +got):\n%s", diff)
+	}
+	replayQD, err := querydispatcher.New(replayDS)
+	if err != nil {
+		t.Fatalf("Failed to create QueryDispatcher: %s", err)
+	}
+	for _, test := range []struct {
+		description string
+		req         *util.DataRequest
+		wantErr     bool
+	}{{
+		description: "same request",
+		req:         newRequest("coll", 1, 2),
+	}, {
+		description: "same request again",
+		req:         newRequest("coll", 1, 2),
+	}, {
+		description: "subset of request",
+		req:         newRequest("coll", 2),
+	}, {
+		description: "different options",
+		req:         newRequest("coll", 3),
+		wantErr:     true,
+	}, {
+		description: "different global filters",
+		req:         newRequest("other_coll", 1),
+		wantErr:     true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			gotData, err := replayQD.HandleDataRequest(ctx, test.req)
+			if test.wantErr != (err != nil) {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			wantData, err := liveQD.HandleDataRequest(ctx, test.req)
+			if err != nil {
+				t.Fatalf("HandleDataRequest() yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(sortedPrettyPrint(wantData), sortedPrettyPrint(gotData)); diff != "" {
+				t.Errorf("Replayed data diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
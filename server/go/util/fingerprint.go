@@ -0,0 +1,75 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+)
+
+// Fingerprint returns a content hash of the receiver and its descendants,
+// whose string indices refer to the provided string table.  Since string
+// indices are resolved, Datums with equal content have equal fingerprints
+// even if they were assembled with different string tables.
+func (d *Datum) Fingerprint(st []string) string {
+	h := sha256.New()
+	d.hash(h, st)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func (d *Datum) hash(h hash.Hash, st []string) {
+	str := func(strIdx int64) string {
+		if strIdx < 0 || strIdx >= int64(len(st)) {
+			return fmt.Sprintf("#%d", strIdx)
+		}
+		return st[strIdx]
+	}
+	keys := make([]int64, 0, len(d.Properties))
+	for k := range d.Properties {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		return str(keys[a]) < str(keys[b])
+	})
+	fmt.Fprintf(h, "(%d", len(keys))
+	for _, k := range keys {
+		v := d.Properties[k]
+		fmt.Fprintf(h, " %q:", str(k))
+		switch v.T {
+		case StringIndexValueType:
+			strIdx, _ := expectStringIndexValue(v)
+			fmt.Fprintf(h, "%d:%q", StringValueType, str(strIdx))
+		case StringIndicesValueType:
+			strIdxs, _ := expectStringIndicesValue(v)
+			strs := make([]string, len(strIdxs))
+			for idx, strIdx := range strIdxs {
+				strs[idx] = str(strIdx)
+			}
+			fmt.Fprintf(h, "%d:%q", StringsValueType, strs)
+		case ReferenceValueType:
+			ref, _ := ExpectReferenceValue(v, st)
+			fmt.Fprintf(h, "%d:%q:%q", v.T, ref.SeriesName, ref.ID)
+		default:
+			fmt.Fprintf(h, "%d:%#v", v.T, v.V)
+		}
+	}
+	fmt.Fprintf(h, " %d", len(d.Children))
+	for _, child := range d.Children {
+		child.hash(h, st)
+	}
+	fmt.Fprint(h, ")")
+}
@@ -0,0 +1,196 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// OptionSource specifies where in a DataRequest an option may be specified.
+type OptionSource int
+
+const (
+	// The option may be specified as a data series option or as a global
+	// filter.  A data series option takes precedence.
+	FromSeriesOrGlobal OptionSource = iota
+	// The option may only be specified as a data series option.
+	FromSeries
+	// The option may only be specified as a global filter.
+	FromGlobal
+)
+
+// OptionSpec describes a single option accepted by a data series query.
+type OptionSpec struct {
+	Key    string
+	Source OptionSource
+	// If non-nil, the value used when the request specifies none.
+	Default *V
+	// If true, and Default is nil, the request must specify the option.
+	Required bool
+	// If set, the type the option's value must have.  If unset, any type is
+	// accepted.
+	Type valueType
+}
+
+// ResolvedOptions holds the options of a data series request resolved by
+// ResolveOptions.
+type ResolvedOptions struct {
+	values   map[string]*V
+	consumed []string
+}
+
+// ResolveOptions merges the provided global filters and data series options
+// per the provided schema, so that query handlers need not do so by hand.
+// Each option in the schema takes its value from, in decreasing order of
+// precedence:
+//
+//   - the data series option with its key, if its Source permits;
+//   - the global filter with its key, if its Source permits;
+//   - its Default.
+//
+// An error is returned if a required option is unspecified, if a value has
+// the wrong type, or if a data series option is not in the schema.  Global
+// filters not in the schema are ignored, since global filters are shared by
+// all data series in a request.
+func ResolveOptions(globalFilters, seriesOptions map[string]*V, schema []*OptionSpec) (*ResolvedOptions, error) {
+	ret := &ResolvedOptions{
+		values: make(map[string]*V, len(schema)),
+	}
+	specsByKey := make(map[string]*OptionSpec, len(schema))
+	for _, spec := range schema {
+		specsByKey[spec.Key] = spec
+	}
+	for key := range seriesOptions {
+		if spec, ok := specsByKey[key]; !ok || spec.Source == FromGlobal {
+			return nil, fmt.Errorf("unsupported option '%s'", key)
+		}
+	}
+	for _, spec := range schema {
+		val, ok := seriesOptions[spec.Key]
+		kind := "option"
+		if !ok && spec.Source != FromSeries {
+			val, ok = globalFilters[spec.Key]
+			kind = "global filter"
+		}
+		if ok {
+			ret.consumed = append(ret.consumed, spec.Key)
+		} else {
+			val, kind = spec.Default, "default"
+		}
+		if val == nil {
+			if spec.Required {
+				if spec.Source == FromGlobal {
+					return nil, fmt.Errorf("missing required global filter '%s'", spec.Key)
+				}
+				return nil, fmt.Errorf("missing required option '%s'", spec.Key)
+			}
+			continue
+		}
+		if spec.Type != unsetValue && val.T != spec.Type {
+			return nil, fmt.Errorf("%s '%s' has the wrong value type", kind, spec.Key)
+		}
+		ret.values[spec.Key] = val
+	}
+	sort.Strings(ret.consumed)
+	return ret, nil
+}
+
+// Consumed returns the keys, in increasing order, of the options whose values
+// were taken from the request, rather than from defaults.
+func (ro *ResolvedOptions) Consumed() []string {
+	return ro.consumed
+}
+
+// Value returns the resolved value of the option with the specified key, and
+// true, or false if it has none.
+func (ro *ResolvedOptions) Value(key string) (*V, bool) {
+	val, ok := ro.values[key]
+	return val, ok
+}
+
+// Has returns true if the option with the specified key has a resolved value.
+func (ro *ResolvedOptions) Has(key string) bool {
+	_, ok := ro.values[key]
+	return ok
+}
+
+func (ro *ResolvedOptions) expect(key string) (*V, error) {
+	val, ok := ro.values[key]
+	if !ok {
+		return nil, fmt.Errorf("option '%s' is unspecified", key)
+	}
+	return val, nil
+}
+
+// String returns the resolved string value of the option with the specified
+// key, or an error if it has none or it is not a string.
+func (ro *ResolvedOptions) String(key string) (string, error) {
+	val, err := ro.expect(key)
+	if err != nil {
+		return "", err
+	}
+	return ExpectStringValue(val)
+}
+
+// Strings returns the resolved strings value of the option with the specified
+// key, or an error if it has none or it is not a strings.
+func (ro *ResolvedOptions) Strings(key string) ([]string, error) {
+	val, err := ro.expect(key)
+	if err != nil {
+		return nil, err
+	}
+	return ExpectStringsValue(val)
+}
+
+// Integer returns the resolved integer value of the option with the specified
+// key, or an error if it has none or it is not an integer.
+func (ro *ResolvedOptions) Integer(key string) (int64, error) {
+	val, err := ro.expect(key)
+	if err != nil {
+		return 0, err
+	}
+	return ExpectIntegerValue(val)
+}
+
+// Double returns the resolved double value of the option with the specified
+// key, or an error if it has none or it is not a double.
+func (ro *ResolvedOptions) Double(key string) (float64, error) {
+	val, err := ro.expect(key)
+	if err != nil {
+		return 0, err
+	}
+	return ExpectDoubleValue(val)
+}
+
+// Duration returns the resolved duration value of the option with the
+// specified key, or an error if it has none or it is not a duration.
+func (ro *ResolvedOptions) Duration(key string) (time.Duration, error) {
+	val, err := ro.expect(key)
+	if err != nil {
+		return 0, err
+	}
+	return ExpectDurationValue(val)
+}
+
+// Timestamp returns the resolved timestamp value of the option with the
+// specified key, or an error if it has none or it is not a timestamp.
+func (ro *ResolvedOptions) Timestamp(key string) (time.Time, error) {
+	val, err := ro.expect(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ExpectTimestampValue(val)
+}
@@ -0,0 +1,41 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import "context"
+
+// Principal identifies the authenticated user on whose behalf a DataRequest
+// is handled.
+type Principal struct {
+	// A unique name for the principal, such as a username or email address.
+	Name string
+	// Any groups the principal belongs to.
+	Groups []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the provided Context carrying the provided
+// Principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the Principal carried by the provided Context, or nil
+// if it carries none.  Data sources may use this to restrict the data a user
+// may fetch.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,45 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import "context"
+
+// ProgressReporter receives reports of the fraction, between 0 and 1, of a
+// long-running operation that is complete.
+type ProgressReporter func(fraction float64)
+
+type progressReporterKey struct{}
+
+// WithProgressReporter returns a copy of the provided Context carrying the
+// provided ProgressReporter.
+func WithProgressReporter(ctx context.Context, pr ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressReporterKey{}, pr)
+}
+
+// ReportProgress reports the fraction, between 0 and 1, of the operation
+// running under the provided Context that is complete.  Fractions outside
+// that range are clamped.  It has no effect if the Context carries no
+// ProgressReporter.
+func ReportProgress(ctx context.Context, fraction float64) {
+	pr, ok := ctx.Value(progressReporterKey{}).(ProgressReporter)
+	if !ok {
+		return
+	}
+	if fraction < 0 {
+		fraction = 0
+	} else if fraction > 1 {
+		fraction = 1
+	}
+	pr(fraction)
+}
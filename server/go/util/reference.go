@@ -0,0 +1,178 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/traceviz/server/go/keys"
+)
+
+// DatumIDKey is the property holding a Datum's ID, as assigned by ID.
+const DatumIDKey = keys.DatumID
+
+// datumIDs tracks the IDs assigned within a single DataSeries.
+type datumIDs struct {
+	mu   sync.Mutex
+	byID map[string]*Datum
+}
+
+func newDatumIDs() *datumIDs {
+	return &datumIDs{
+		byID: map[string]*Datum{},
+	}
+}
+
+// assign records that the provided Datum has the provided ID, returning an
+// error if another Datum already does.
+func (ids *datumIDs) assign(id string, d *Datum) error {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	if existing, ok := ids.byID[id]; ok && existing != d {
+		return fmt.Errorf("datum ID '%s' assigned more than once in the same data series", id)
+	}
+	ids.byID[id] = d
+	return nil
+}
+
+// ID returns a PropertyUpdate assigning the provided stable ID to a Datum, so
+// that it may be referred to, with a DatumRef, from elsewhere in the response.
+// IDs must be unique within a DataSeries; assigning an ID already assigned to
+// another Datum in the same DataSeries errors the response.
+func ID(id string) PropertyUpdate {
+	return func(db *datumBuilder) error {
+		if db.ids != nil {
+			if err := db.ids.assign(id, db.d); err != nil {
+				return err
+			}
+		}
+		db.withStr(DatumIDKey, id)
+		return nil
+	}
+}
+
+// DatumRef refers to a Datum by its ID.
+type DatumRef struct {
+	// The name of the DataSeries holding the referenced Datum.  If empty, the
+	// referenced Datum is in the same DataSeries as the referring one.
+	SeriesName string
+	// The ID assigned to the referenced Datum.
+	ID string
+}
+
+// String returns the receiver as 'series/id', or 'id' if it has no series
+// name.
+func (ref DatumRef) String() string {
+	if ref.SeriesName == "" {
+		return ref.ID
+	}
+	return ref.SeriesName + "/" + ref.ID
+}
+
+// Reference produces a Value referring to the Datum identified by the
+// provided DatumRef.
+func Reference(ref DatumRef) Value {
+	return func(key string) PropertyUpdate {
+		return ReferenceProperty(key, ref)
+	}
+}
+
+// ReferenceProperty returns a PropertyUpdate adding the specified reference
+// property.
+func ReferenceProperty(key string, ref DatumRef) PropertyUpdate {
+	return func(db *datumBuilder) error {
+		db.valsByKey[db.st.stringIndex(key)] = &V{
+			V: []int64{db.st.stringIndex(ref.SeriesName), db.st.stringIndex(ref.ID)},
+			T: ReferenceValueType,
+		}
+		return nil
+	}
+}
+
+// ExpectReferenceValue returns the DatumRef held by the provided V, whose
+// string indices refer to the provided string table, or an error if it does
+// not hold a well-formed reference.
+func ExpectReferenceValue(val *V, st []string) (DatumRef, error) {
+	if val.T != ReferenceValueType {
+		return DatumRef{}, fmt.Errorf("expected value type 'reference'")
+	}
+	strIdxs, ok := val.V.([]int64)
+	if !ok || len(strIdxs) != 2 {
+		return DatumRef{}, fmt.Errorf("reference Value is improperly formed")
+	}
+	seriesName, err := lookupString(st, strIdxs[0])
+	if err != nil {
+		return DatumRef{}, err
+	}
+	id, err := lookupString(st, strIdxs[1])
+	if err != nil {
+		return DatumRef{}, err
+	}
+	return DatumRef{
+		SeriesName: seriesName,
+		ID:         id,
+	}, nil
+}
+
+// ID returns the ID assigned to the receiver, whose string indices refer to
+// the provided string table, and true, or false if it has none.
+func (d *Datum) ID(st []string) (string, bool) {
+	return d.PropertyString(st, DatumIDKey)
+}
+
+// PropertyReference returns the value of the receiver's reference property
+// with the specified key, whose string indices refer to the provided string
+// table, and true, or false if the receiver has no such reference property.
+func (d *Datum) PropertyReference(st []string, key string) (DatumRef, bool) {
+	v, ok := d.Property(st, key)
+	if !ok {
+		return DatumRef{}, false
+	}
+	ref, err := ExpectReferenceValue(v, st)
+	return ref, err == nil
+}
+
+// find returns the Datum with the provided ID among the receiver and its
+// descendants, whose string indices refer to the provided string table.
+func (d *Datum) find(st []string, id string) (*Datum, bool) {
+	if got, ok := d.ID(st); ok && got == id {
+		return d, true
+	}
+	for _, child := range d.Children {
+		if ret, ok := child.find(st, id); ok {
+			return ret, true
+		}
+	}
+	return nil, false
+}
+
+// Resolve returns the Datum within the receiver referred to by the provided
+// DatumRef, found in a property of a Datum in the DataSeries with the provided
+// name.  Returns an error if the referenced DataSeries or Datum is absent.
+func (d *Data) Resolve(ref DatumRef, fromSeries string) (*Datum, error) {
+	seriesName := ref.SeriesName
+	if seriesName == "" {
+		seriesName = fromSeries
+	}
+	for _, ds := range d.DataSeries {
+		if ds.SeriesName != seriesName || ds.Root == nil {
+			continue
+		}
+		if ret, ok := ds.Root.find(d.StringTable, ref.ID); ok {
+			return ret, nil
+		}
+	}
+	return nil, fmt.Errorf("no datum with ID '%s' in data series '%s'", ref.ID, seriesName)
+}
@@ -0,0 +1,109 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TracePhase is a single phase, such as fetching, filtering, aggregating, or
+// building, of handling a DataSeriesRequest.
+type TracePhase struct {
+	Name       string
+	Start, End time.Time
+}
+
+// SeriesTrace records the phases of handling a single DataSeriesRequest, so
+// that a server may trace its own performance.
+type SeriesTrace struct {
+	SeriesName string
+	QueryName  string
+	Start, End time.Time
+	mu         sync.Mutex
+	phases     []*TracePhase
+}
+
+// NewSeriesTrace returns a new SeriesTrace for the provided
+// DataSeriesRequest, starting now.
+func NewSeriesTrace(req *DataSeriesRequest) *SeriesTrace {
+	return &SeriesTrace{
+		SeriesName: req.SeriesName,
+		QueryName:  req.QueryName,
+		Start:      time.Now(),
+	}
+}
+
+// beginPhase ends the receiver's current phase, if any, and begins a new
+// phase with the specified name.
+func (st *SeriesTrace) beginPhase(name string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	if len(st.phases) > 0 {
+		st.phases[len(st.phases)-1].End = now
+	}
+	st.phases = append(st.phases, &TracePhase{
+		Name:  name,
+		Start: now,
+	})
+}
+
+// Finish ends the receiver, and its current phase, now.
+func (st *SeriesTrace) Finish() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.End = time.Now()
+	if len(st.phases) > 0 {
+		st.phases[len(st.phases)-1].End = st.End
+	}
+}
+
+// Phases returns the receiver's phases, in order.
+func (st *SeriesTrace) Phases() []*TracePhase {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ret := make([]*TracePhase, len(st.phases))
+	for idx, phase := range st.phases {
+		phaseCopy := *phase
+		ret[idx] = &phaseCopy
+	}
+	return ret
+}
+
+type seriesTraceKey struct{}
+
+// WithSeriesTrace returns a copy of the provided Context carrying the provided
+// SeriesTrace.
+func WithSeriesTrace(ctx context.Context, st *SeriesTrace) context.Context {
+	return context.WithValue(ctx, seriesTraceKey{}, st)
+}
+
+// BeginPhase records the start of the named phase of handling the
+// DataSeriesRequest whose SeriesTrace is carried by the provided Context,
+// ending its previous phase; the last phase ends when handling does.  If the
+// Context carries no SeriesTrace, as when self-tracing is disabled, it does
+// nothing.  Data sources may call this to break their handling into phases,
+// e.g.,
+//
+//	util.BeginPhase(ctx, "fetch")
+//	coll, err := fetch(ctx, collectionName)
+//	...
+//	util.BeginPhase(ctx, "aggregate")
+func BeginPhase(ctx context.Context, name string) {
+	if st, ok := ctx.Value(seriesTraceKey{}).(*SeriesTrace); ok {
+		st.beginPhase(name)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import "time"
+
+// SeriesStats describes the resources used to produce a single DataSeries.
+type SeriesStats struct {
+	SeriesName string
+	QueryName  string
+	// The wall-clock time spent handling the series.
+	WallTime time.Duration
+	// The estimated CPU time spent running Go code, and the heap bytes and
+	// objects allocated, by the whole process while handling the series.  Go
+	// cannot attribute these to a single request, so they include any work
+	// done concurrently, such as handling other requests or series, and are
+	// only meaningful when the server is otherwise idle.
+	ProcessCPUTime      time.Duration
+	ProcessAllocBytes   uint64
+	ProcessAllocObjects uint64
+	// The number of Datums in the series.
+	Datums int64
+	// The size of the JSON-encoded series, excluding the string table.
+	ResponseBytes int64
+}
+
+// DatumCount returns the number of Datums in the receiver, including its
+// root.
+func (ds *DataSeries) DatumCount() int64 {
+	if ds.Root == nil {
+		return 0
+	}
+	var count func(d *Datum) int64
+	count = func(d *Datum) int64 {
+		ret := int64(1)
+		for _, child := range d.Children {
+			ret += count(child)
+		}
+		return ret
+	}
+	return count(ds.Root)
+}
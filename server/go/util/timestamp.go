@@ -0,0 +1,39 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTimestamp returns the duration represented by the provided decimal
+// seconds and fractional seconds strings, as found in the 'secs.frac'
+// timestamps of ftrace and perf script output.  Fractional digits beyond
+// nanoseconds are truncated.
+func ParseTimestamp(secs, frac string) (time.Duration, error) {
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if len(frac) > 9 {
+		frac = frac[:9]
+	}
+	ns, err := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(s)*time.Second + time.Duration(ns), nil
+}
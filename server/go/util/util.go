@@ -30,6 +30,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/url"
+	"reflect"
 	"sort"
 	"strconv"
 
@@ -37,6 +38,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/google/traceviz/server/go/keys"
 )
 
 type valueType int
@@ -53,6 +56,8 @@ const (
 	DoubleValueType
 	DurationValueType
 	TimestampValueType
+	ReferenceValueType
+	DoublesValueType
 )
 
 // V represents a value in a TraceViz request or response.
@@ -63,7 +68,9 @@ type V struct {
 
 // PrettyPrint returns the receiver, deterministically prettyprinted.
 // String-index-type values prettyprint the same as the corresponding
-// literal-string-type values.  Only for use in tests.
+// literal-string-type values, and timestamps are printed in UTC, so that the
+// output is independent of string table order and local time zone.  It is
+// intended for debugging and for snapshotting responses in tests.
 func (v *V) PrettyPrint(st []string) string {
 	var ret string
 	var err error
@@ -77,7 +84,8 @@ func (v *V) PrettyPrint(st []string) string {
 		var strIdx int64
 		strIdx, err = expectStringIndexValue(v)
 		if err == nil {
-			ret = "'" + st[strIdx] + "'"
+			ret, err = lookupString(st, strIdx)
+			ret = "'" + ret + "'"
 		}
 	case StringsValueType:
 		var strs []string
@@ -89,7 +97,9 @@ func (v *V) PrettyPrint(st []string) string {
 		if err == nil {
 			var strs = make([]string, len(strIdxs))
 			for idx, strIdx := range strIdxs {
-				strs[idx] = st[strIdx]
+				if strs[idx], err = lookupString(st, strIdx); err != nil {
+					break
+				}
 			}
 			ret = "[ '" + strings.Join(strs, "', '") + "' ]"
 		}
@@ -115,6 +125,16 @@ func (v *V) PrettyPrint(st []string) string {
 		if err == nil {
 			ret = fmt.Sprintf("%.6f", d)
 		}
+	case DoublesValueType:
+		var dbls []float64
+		dbls, err = ExpectDoublesValue(v)
+		if err == nil {
+			strs := make([]string, len(dbls))
+			for idx, d := range dbls {
+				strs[idx] = fmt.Sprintf("%.6f", d)
+			}
+			ret = "[ " + strings.Join(strs, ", ") + " ]"
+		}
 	case DurationValueType:
 		var dur time.Duration
 		dur, err = ExpectDurationValue(v)
@@ -122,7 +142,13 @@ func (v *V) PrettyPrint(st []string) string {
 	case TimestampValueType:
 		var ts time.Time
 		ts, err = ExpectTimestampValue(v)
-		ret = ts.String()
+		ret = ts.UTC().Format(time.RFC3339Nano)
+	case ReferenceValueType:
+		var ref DatumRef
+		ref, err = ExpectReferenceValue(v, st)
+		ret = "ref(" + ref.String() + ")"
+	default:
+		err = fmt.Errorf("unsupported value type %d", v.T)
 	}
 	if err != nil {
 		return "error: " + err.Error()
@@ -130,6 +156,15 @@ func (v *V) PrettyPrint(st []string) string {
 	return ret
 }
 
+// lookupString returns the string at the specified index in the provided
+// string table, or an error if the index is out of range.
+func lookupString(st []string, strIdx int64) (string, error) {
+	if strIdx < 0 || strIdx >= int64(len(st)) {
+		return "", fmt.Errorf("string index %d out of range", strIdx)
+	}
+	return st[strIdx], nil
+}
+
 type timestamp struct {
 	UnixSeconds int64
 	UnixNanos   int64
@@ -147,31 +182,81 @@ func (ts timestamp) MarshalJSON() ([]byte, error) {
 //	  string   |                      ; if string
 //	  number   |                      ; if integer, string index, double, or duration
 //	  string[] |                      ; if strings
-//	  number[] |                      ; if integers or string indices
+//	  number[] |                      ; if integers, doubles, or string indices
 //	  [number, number]                ; if timestamp ([secs, nanos] from epoch)
+//	                                   ; or reference ([series name, datum ID]
+//	                                   ; string indices)
 //	]
 func (v *V) MarshalJSON() ([]byte, error) {
 	ret := [2]any{v.T, v.V}
 	return json.Marshal(ret)
 }
 
+// jsonInt64 returns the provided decoded JSON value as an int64, or an error
+// if it is not an integer.
+func jsonInt64(a any) (int64, error) {
+	num, ok := a.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %T", a)
+	}
+	return num.Int64()
+}
+
+// jsonFloat64 returns the provided decoded JSON value as a float64, or an
+// error if it is not a number.
+func jsonFloat64(a any) (float64, error) {
+	num, ok := a.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, got %T", a)
+	}
+	return num.Float64()
+}
+
+// jsonArray returns the provided decoded JSON value as an array, or an error
+// if it is not an array.
+func jsonArray(a any) ([]any, error) {
+	arr, ok := a.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an array, got %T", a)
+	}
+	return arr, nil
+}
+
 func (v *V) fromAny(got []any) error {
-	t, err := got[0].(json.Number).Int64()
+	if len(got) != 2 {
+		return fmt.Errorf("encoded Value is improperly formed")
+	}
+	t, err := jsonInt64(got[0])
 	if err != nil {
 		return err
 	}
 	v.T = valueType(t)
 	tv := got[1]
 	switch v.T {
+	case unsetValue:
+		v.V = tv
+	case StringValueType:
+		str, ok := tv.(string)
+		if !ok {
+			return fmt.Errorf("string Value is improperly formed")
+		}
+		v.V = str
 	case StringIndexValueType, IntegerValueType:
-		if v.V, err = tv.(json.Number).Int64(); err != nil {
+		if v.V, err = jsonInt64(tv); err != nil {
 			return err
 		}
 	case StringsValueType:
-		strIfs := tv.([]any)
+		strIfs, err := jsonArray(tv)
+		if err != nil {
+			return err
+		}
 		strs := make([]string, len(strIfs))
 		for idx, strIf := range strIfs {
-			str, err := url.QueryUnescape(strIf.(string))
+			rawStr, ok := strIf.(string)
+			if !ok {
+				return fmt.Errorf("strings Value is improperly formed")
+			}
+			str, err := url.QueryUnescape(rawStr)
 			if err != nil {
 				return err
 			}
@@ -179,35 +264,54 @@ func (v *V) fromAny(got []any) error {
 		}
 		v.V = strs
 	case DoubleValueType:
-		if v.V, err = tv.(json.Number).Float64(); err != nil {
+		if v.V, err = jsonFloat64(tv); err != nil {
 			return err
 		}
 	case StringIndicesValueType, IntegersValueType:
-		nums := tv.([]any)
+		nums, err := jsonArray(tv)
+		if err != nil {
+			return err
+		}
 		ints := make([]int64, len(nums))
 		for idx, num := range nums {
-			ints[idx], err = num.(json.Number).Int64()
+			ints[idx], err = jsonInt64(num)
 			if err != nil {
 				return err
 			}
 		}
 		v.V = ints
+	case DoublesValueType:
+		nums, err := jsonArray(tv)
+		if err != nil {
+			return err
+		}
+		dbls := make([]float64, len(nums))
+		for idx, num := range nums {
+			dbls[idx], err = jsonFloat64(num)
+			if err != nil {
+				return err
+			}
+		}
+		v.V = dbls
 	case DurationValueType:
-		durNs, err := tv.(json.Number).Int64()
+		durNs, err := jsonInt64(tv)
 		if err != nil {
 			return err
 		}
 		v.V = time.Duration(durNs)
 	case TimestampValueType:
-		parts := tv.([]any)
+		parts, err := jsonArray(tv)
+		if err != nil {
+			return err
+		}
 		if len(parts) != 2 {
 			return fmt.Errorf("timestamp Value is improperly formed")
 		}
-		unixSecs, err := parts[0].(json.Number).Int64()
+		unixSecs, err := jsonInt64(parts[0])
 		if err != nil {
 			return err
 		}
-		unixNanos, err := parts[1].(json.Number).Int64()
+		unixNanos, err := jsonInt64(parts[1])
 		if err != nil {
 			return err
 		}
@@ -215,10 +319,25 @@ func (v *V) fromAny(got []any) error {
 			UnixSeconds: unixSecs,
 			UnixNanos:   unixNanos,
 		}
+	case ReferenceValueType:
+		parts, err := jsonArray(tv)
+		if err != nil {
+			return err
+		}
+		if len(parts) != 2 {
+			return fmt.Errorf("reference Value is improperly formed")
+		}
+		strIdxs := make([]int64, len(parts))
+		for idx, part := range parts {
+			if strIdxs[idx], err = jsonInt64(part); err != nil {
+				return err
+			}
+		}
+		v.V = strIdxs
 	default:
-		v.V = tv
+		return fmt.Errorf("unsupported Value type %d", v.T)
 	}
-	return err
+	return nil
 }
 
 // UnmarshalJSON unmarshals the provided JSON bytes into the receiving V.
@@ -238,21 +357,33 @@ type Datum struct {
 	Children   []*Datum
 }
 
-// PrettyPrint returns the receiver deterministically prettyprinted.
-// Only for use in tests.
+// PrettyPrint returns the receiver deterministically prettyprinted, with the
+// specified indent, resolving string indices in the provided string table.
+// Properties are printed in increasing key order, then children in order.  It
+// is intended for debugging and for snapshotting responses in tests.
 func (d *Datum) PrettyPrint(indent string, st []string) string {
 	ret := []string{}
+	keyName := func(k int64) string {
+		name, err := lookupString(st, k)
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return name
+	}
 	// Emit properties in increasing alphabetic order.
 	keys := make([]int64, 0, len(d.Properties))
 	for k := range d.Properties {
 		keys = append(keys, k)
 	}
 	sort.Slice(keys, func(a, b int) bool {
-		return st[keys[a]] < st[keys[b]]
+		if ka, kb := keyName(keys[a]), keyName(keys[b]); ka != kb {
+			return ka < kb
+		}
+		return keys[a] < keys[b]
 	})
 	for _, k := range keys {
 		ret = append(ret,
-			fmt.Sprintf("%sProp '%s': %s", indent, st[k], d.Properties[k].PrettyPrint(st)),
+			fmt.Sprintf("%sProp '%s': %s", indent, keyName(k), d.Properties[k].PrettyPrint(st)),
 		)
 	}
 	for _, child := range d.Children {
@@ -264,6 +395,89 @@ func (d *Datum) PrettyPrint(indent string, st []string) string {
 	return strings.Join(ret, "\n")
 }
 
+// Property returns the value of the receiver's property with the specified
+// key, whose string indices refer to the provided string table, and true, or
+// false if the receiver has no such property.
+func (d *Datum) Property(st []string, key string) (*V, bool) {
+	for k, v := range d.Properties {
+		if k >= 0 && k < int64(len(st)) && st[k] == key {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// PropertyString returns the value of the receiver's string property with the
+// specified key, whose string indices refer to the provided string table, and
+// true, or false if the receiver has no such string property.
+func (d *Datum) PropertyString(st []string, key string) (string, bool) {
+	v, ok := d.Property(st, key)
+	if !ok {
+		return "", false
+	}
+	switch v.T {
+	case StringValueType:
+		str, ok := v.V.(string)
+		return str, ok
+	case StringIndexValueType:
+		strIdx, ok := v.V.(int64)
+		if !ok || strIdx < 0 || strIdx >= int64(len(st)) {
+			return "", false
+		}
+		return st[strIdx], true
+	}
+	return "", false
+}
+
+// PropertyStrings returns the value of the receiver's string-list property
+// with the specified key, whose string indices refer to the provided string
+// table, and true, or false if the receiver has no such property.
+func (d *Datum) PropertyStrings(st []string, key string) ([]string, bool) {
+	v, ok := d.Property(st, key)
+	if !ok {
+		return nil, false
+	}
+	switch v.T {
+	case StringsValueType:
+		strs, ok := v.V.([]string)
+		return strs, ok
+	case StringIndicesValueType:
+		strIdxs, ok := v.V.([]int64)
+		if !ok {
+			return nil, false
+		}
+		ret := make([]string, len(strIdxs))
+		for idx, strIdx := range strIdxs {
+			if strIdx < 0 || strIdx >= int64(len(st)) {
+				return nil, false
+			}
+			ret[idx] = st[strIdx]
+		}
+		return ret, true
+	}
+	return nil, false
+}
+
+// PropertyNumber returns the value of the receiver's integer or double
+// property with the specified key, whose string indices refer to the provided
+// string table, as a float64, and true, or false if the receiver has no such
+// numeric property.
+func (d *Datum) PropertyNumber(st []string, key string) (float64, bool) {
+	v, ok := d.Property(st, key)
+	if !ok {
+		return 0, false
+	}
+	switch v.T {
+	case IntegerValueType:
+		i, ok := v.V.(int64)
+		return float64(i), ok
+	case DoubleValueType:
+		f, ok := v.V.(float64)
+		return f, ok
+	}
+	return 0, false
+}
+
 // MarshalJSON overrides the default JSON marshaling behavior for Datum to
 // reduce response sizes.  A Datum is encoded as the JS object `Datum`:
 //
@@ -293,24 +507,48 @@ func (d *Datum) MarshalJSON() ([]byte, error) {
 }
 
 func (d *Datum) fromAny(sd []any) error {
-	props := sd[0]
-	children := sd[1]
-	d.Properties = make(map[int64]*V, len(props.([]any)))
-	d.Children = make([]*Datum, len(children.([]any)))
-	for _, val := range props.([]any) {
-		k, err := ((val.([]any))[0].(json.Number)).Int64()
+	if len(sd) != 2 {
+		return fmt.Errorf("encoded Datum is improperly formed")
+	}
+	props, err := jsonArray(sd[0])
+	if err != nil {
+		return err
+	}
+	children, err := jsonArray(sd[1])
+	if err != nil {
+		return err
+	}
+	d.Properties = make(map[int64]*V, len(props))
+	d.Children = make([]*Datum, len(children))
+	for _, val := range props {
+		prop, err := jsonArray(val)
+		if err != nil {
+			return err
+		}
+		if len(prop) != 2 {
+			return fmt.Errorf("encoded Datum property is improperly formed")
+		}
+		k, err := jsonInt64(prop[0])
+		if err != nil {
+			return err
+		}
+		vAny, err := jsonArray(prop[1])
 		if err != nil {
 			return err
 		}
 		v := &V{}
-		if err := v.fromAny((val.([]any))[1].([]any)); err != nil {
+		if err := v.fromAny(vAny); err != nil {
 			return err
 		}
 		d.Properties[k] = v
 	}
-	for idx, val := range children.([]any) {
+	for idx, val := range children {
+		childAny, err := jsonArray(val)
+		if err != nil {
+			return err
+		}
 		child := &Datum{}
-		if err := child.fromAny(val.([]any)); err != nil {
+		if err := child.fromAny(childAny); err != nil {
 			return err
 		}
 		d.Children[idx] = child
@@ -335,17 +573,51 @@ type DataSeriesRequest struct {
 	QueryName  string
 	SeriesName string
 	Options    map[string]*V
+	// The fingerprint of the last response to this request seen by the
+	// client, if any.  If the new response has the same fingerprint, it is
+	// elided.
+	Fingerprint string `json:",omitempty"`
+	// If true, the response is fingerprinted even if Fingerprint is empty, so
+	// that the client may send that fingerprint with its next request.
+	// Responses to requests with neither are not fingerprinted.
+	WantFingerprint bool `json:",omitempty"`
+}
+
+// wantsFingerprint returns true if the response to the receiver should be
+// fingerprinted.
+func (dsr *DataSeriesRequest) wantsFingerprint() bool {
+	return dsr.Fingerprint != "" || dsr.WantFingerprint
 }
 
 // DataSeries represents a complete TraceViz data series response.
 type DataSeries struct {
 	SeriesName string
-	Root       *Datum
-}
-
-// PrettyPrint returns the receiver deterministically prettyprinted.
-// Only for use in tests.
+	// Nil if NotModified is true, or if Err is set.
+	Root *Datum
+	// The content hash of Root, or a fingerprint provided by the data source,
+	// if the request wanted one.
+	Fingerprint string `json:",omitempty"`
+	// If true, the series is unchanged from the response with the requested
+	// fingerprint, and its Root is omitted.
+	NotModified bool `json:",omitempty"`
+	// If non-empty, the series could not be produced, and Err describes why.
+	// Other series in the same response are unaffected.
+	Err string `json:",omitempty"`
+}
+
+// PrettyPrint returns the receiver deterministically prettyprinted, with the
+// specified indent, resolving string indices in the provided string table.  It
+// is intended for debugging and for snapshotting responses in tests.
 func (ds *DataSeries) PrettyPrint(indent string, st []string) string {
+	if ds.NotModified {
+		return fmt.Sprintf("%sSeries %s not modified", indent, ds.SeriesName)
+	}
+	if ds.Err != "" {
+		return fmt.Sprintf("%sSeries %s failed: %s", indent, ds.SeriesName, ds.Err)
+	}
+	if ds.Root == nil {
+		return fmt.Sprintf("%sSeries %s empty", indent, ds.SeriesName)
+	}
 	return strings.Join([]string{
 		fmt.Sprintf("%sSeries %s", indent, ds.SeriesName),
 		indent + "  " + "Root:",
@@ -371,10 +643,17 @@ func DataRequestFromJSON(j []byte) (*DataRequest, error) {
 type Data struct {
 	StringTable []string
 	DataSeries  []*DataSeries
+	// The global filters with which the response was produced.  These may
+	// differ from those in the DataRequest, e.g. if defaults were applied or
+	// ranges clamped; clients may update their own filters to match.
+	GlobalFilters map[string]*V `json:",omitempty"`
+	// If requested, the resources used to produce each data series.
+	SeriesStats []*SeriesStats `json:",omitempty"`
 }
 
-// PrettyPrint returns the receiver deterministically prettyprinted.
-// Only for use in tests.
+// PrettyPrint returns the receiver deterministically prettyprinted, with its
+// data series in response order.  It is intended for debugging and for
+// snapshotting responses in tests.
 func (d *Data) PrettyPrint() string {
 	ret := []string{"Data:"}
 	for _, series := range d.DataSeries {
@@ -410,6 +689,13 @@ func (st *stringTable) lookupStringIndex(str string) (int64, bool) {
 	return idx, ok
 }
 
+// lookup returns the string at the provided index in the receiver.
+func (st *stringTable) lookup(idx int64) string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.stringsByIndex[idx]
+}
+
 // stringIndex returns the index in the receiver StringTable for the provided
 // string, adding it to the receiver if necessary.
 func (st *stringTable) stringIndex(str string) int64 {
@@ -466,7 +752,16 @@ type DataResponseBuilder struct {
 	st   *stringTable
 	errs *errors
 	d    *Data
-	mu   sync.Mutex
+	// The fingerprints requested for each DataSeries wanting one, by series
+	// name; empty if the request opted in without a fingerprint.
+	requestedFingerprints map[string]string
+	// Fingerprints provided by data sources via NotModified, by series name.
+	// These are reported instead of content hashes.
+	sourceFingerprints map[string]string
+	// True if d.GlobalFilters is owned by the builder, rather than provided by
+	// WithGlobalFilters, and so may be modified.
+	ownsGlobalFilters bool
+	mu                sync.Mutex
 }
 
 // NewDataResponseBuilder returns a new DataResponseBuilder configured with the
@@ -479,34 +774,282 @@ func NewDataResponseBuilder() *DataResponseBuilder {
 			StringTable: []string{},
 			DataSeries:  []*DataSeries{},
 		},
+		requestedFingerprints: map[string]string{},
+		sourceFingerprints:    map[string]string{},
 	}
 }
 
+// WithGlobalFilters sets the global filters reported in the Data under
+// construction, returning the receiver.
+func (drb *DataResponseBuilder) WithGlobalFilters(globalFilters map[string]*V) *DataResponseBuilder {
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	drb.d.GlobalFilters = globalFilters
+	drb.ownsGlobalFilters = false
+	return drb
+}
+
+// SetGlobalFilter sets the value of the specified global filter reported in
+// the Data under construction, or removes it if the provided value is nil.
+// Data sources may use this to report the filters they actually applied, e.g.
+// after clamping a requested time range or resolving a default, so that
+// clients can keep their controls consistent with the response.  The map
+// provided to WithGlobalFilters is not modified.
+func (drb *DataResponseBuilder) SetGlobalFilter(key string, v *V) {
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	if !drb.ownsGlobalFilters {
+		globalFilters := make(map[string]*V, len(drb.d.GlobalFilters)+1)
+		for k, val := range drb.d.GlobalFilters {
+			globalFilters[k] = val
+		}
+		drb.d.GlobalFilters = globalFilters
+		drb.ownsGlobalFilters = true
+	}
+	if v == nil {
+		delete(drb.d.GlobalFilters, key)
+		return
+	}
+	drb.d.GlobalFilters[key] = v
+}
+
+// SetGlobalFilterIfChanged sets the specified global filter as with
+// SetGlobalFilter, but only if its value differs from its value in the
+// provided request global filters.  It returns true if the filter was set.
+func (drb *DataResponseBuilder) SetGlobalFilterIfChanged(requested map[string]*V, key string, v *V) bool {
+	if old, ok := requested[key]; ok == (v != nil) && (!ok || reflect.DeepEqual(old, v)) {
+		return false
+	}
+	drb.SetGlobalFilter(key, v)
+	return true
+}
+
+// WithRequestedFingerprints records the fingerprints requested by the provided
+// DataSeriesRequests, so that DataSeries added with AddDataSeries, as well as
+// with DataSeries, are fingerprinted, and may be elided if unchanged.  It
+// returns the receiver.
+func (drb *DataResponseBuilder) WithRequestedFingerprints(reqs ...*DataSeriesRequest) *DataResponseBuilder {
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	for _, req := range reqs {
+		if req.wantsFingerprint() {
+			drb.requestedFingerprints[req.SeriesName] = req.Fingerprint
+		}
+	}
+	return drb
+}
+
+// NotModified allows data sources to skip building the response to the
+// provided DataSeriesRequest if it is unchanged.  The provided fingerprint
+// must be computed without building the response, for instance from the
+// versions of the data and the request options it depends on, and must differ
+// whenever the response would.  If the request wants a fingerprint, the
+// provided one is reported for its DataSeries in place of a content hash.  If
+// it also matches the request's fingerprint, a NotModified DataSeries is added,
+// and NotModified returns true; the data source must not then build the
+// DataSeries.  NotModified is safe for concurrent use.
+func (drb *DataResponseBuilder) NotModified(req *DataSeriesRequest, fingerprint string) bool {
+	if !req.wantsFingerprint() {
+		return false
+	}
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	drb.requestedFingerprints[req.SeriesName] = req.Fingerprint
+	drb.sourceFingerprints[req.SeriesName] = fingerprint
+	if req.Fingerprint != fingerprint {
+		return false
+	}
+	drb.d.DataSeries = append(drb.d.DataSeries, &DataSeries{
+		SeriesName:  req.SeriesName,
+		Fingerprint: fingerprint,
+		NotModified: true,
+	})
+	return true
+}
+
 // DataBuilder is implemented by types that can assemble TraceViz responses.
 type DataBuilder interface {
 	With(updates ...PropertyUpdate) DataBuilder
 	Child() DataBuilder
+	ChildNamed(name string) DataBuilder
 }
 
+// ChildNameKey is the property identifying a child added with ChildNamed.
+const ChildNameKey = keys.ChildName
+
 // DataSeries returns a new DataBuilder for assembling the response to the
 // provided DataSeriesRequest.  DataSeries is safe for concurrent use.
 func (drb *DataResponseBuilder) DataSeries(req *DataSeriesRequest) DataBuilder {
 	ret := newDatumBuilder(drb.errs, drb.st)
+	ret.ids = newDatumIDs()
 	ds := &DataSeries{
 		SeriesName: req.SeriesName,
 		Root:       ret.d,
 	}
 	drb.mu.Lock()
 	drb.d.DataSeries = append(drb.d.DataSeries, ds)
+	if req.wantsFingerprint() {
+		drb.requestedFingerprints[req.SeriesName] = req.Fingerprint
+	}
 	drb.mu.Unlock()
 	return ret
 }
 
-// Data completes and returns the Data under construction.
+// FailDataSeries replaces any DataSeries for the provided request in the Data
+// under construction with one reporting the provided error.
+// FailDataSeries is safe for concurrent use.
+func (drb *DataResponseBuilder) FailDataSeries(req *DataSeriesRequest, err error) {
+	drb.RemoveDataSeries(req)
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	drb.d.DataSeries = append(drb.d.DataSeries, &DataSeries{
+		SeriesName: req.SeriesName,
+		Err:        err.Error(),
+	})
+}
+
+// RemoveDataSeries removes any DataSeries for the provided requests from the
+// Data under construction, e.g. so that they may be rebuilt.
+// RemoveDataSeries is safe for concurrent use.
+func (drb *DataResponseBuilder) RemoveDataSeries(reqs ...*DataSeriesRequest) {
+	names := make(map[string]struct{}, len(reqs))
+	for _, req := range reqs {
+		names[req.SeriesName] = struct{}{}
+	}
+	drb.mu.Lock()
+	defer drb.mu.Unlock()
+	kept := drb.d.DataSeries[:0]
+	for _, ds := range drb.d.DataSeries {
+		if _, ok := names[ds.SeriesName]; !ok {
+			kept = append(kept, ds)
+		}
+	}
+	drb.d.DataSeries = kept
+}
+
+// AddDataSeries adds the provided, already-assembled DataSeries, whose string
+// indices refer to the provided string table, to the Data under construction.
+// The DataSeries' string indices are remapped in place to refer to the
+// receiver's string table.  A NotModified or failed DataSeries, e.g. from a
+// remote server, is added as is.  AddDataSeries is safe for concurrent use.
+func (drb *DataResponseBuilder) AddDataSeries(ds *DataSeries, st []string) error {
+	if ds.Root == nil && !ds.NotModified && ds.Err == "" {
+		return fmt.Errorf("data series '%s' has no root", ds.SeriesName)
+	}
+	if ds.Root != nil {
+		if err := remapDatum(ds.Root, st, drb.st); err != nil {
+			return fmt.Errorf("data series '%s': %s", ds.SeriesName, err)
+		}
+	}
+	drb.mu.Lock()
+	drb.d.DataSeries = append(drb.d.DataSeries, ds)
+	drb.mu.Unlock()
+	return nil
+}
+
+// remapString returns the index in the provided stringTable of the string at
+// the provided index in the provided string table.
+func remapString(strIdx int64, st []string, to *stringTable) (int64, error) {
+	if strIdx < 0 || strIdx >= int64(len(st)) {
+		return 0, fmt.Errorf("string index %d out of range", strIdx)
+	}
+	return to.stringIndex(st[strIdx]), nil
+}
+
+// remapV rewrites any string indices within the provided V, which refer to
+// the provided string table, to refer to the provided stringTable.
+func remapV(v *V, st []string, to *stringTable) error {
+	switch v.T {
+	case StringIndexValueType:
+		strIdx, err := expectStringIndexValue(v)
+		if err != nil {
+			return err
+		}
+		if v.V, err = remapString(strIdx, st, to); err != nil {
+			return err
+		}
+	case StringIndicesValueType, ReferenceValueType:
+		strIdxs, ok := v.V.([]int64)
+		if !ok {
+			return fmt.Errorf("expected value type 'string indices' or 'reference'")
+		}
+		newStrIdxs := make([]int64, len(strIdxs))
+		for idx, strIdx := range strIdxs {
+			var err error
+			if newStrIdxs[idx], err = remapString(strIdx, st, to); err != nil {
+				return err
+			}
+		}
+		v.V = newStrIdxs
+	}
+	return nil
+}
+
+// remapDatum rewrites the string indices within the provided Datum and its
+// descendants, which refer to the provided string table, to refer to the
+// provided stringTable.
+func remapDatum(d *Datum, st []string, to *stringTable) error {
+	props := make(map[int64]*V, len(d.Properties))
+	for k, v := range d.Properties {
+		newK, err := remapString(k, st, to)
+		if err != nil {
+			return err
+		}
+		if err := remapV(v, st, to); err != nil {
+			return err
+		}
+		props[newK] = v
+	}
+	d.Properties = props
+	for _, child := range d.Children {
+		if err := remapDatum(child, st, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Data completes and returns the Data under construction.  Each DataSeries
+// whose request wants a fingerprint is fingerprinted, by the fingerprint its
+// data source provided to NotModified if any, and otherwise by the content
+// hash of its Root; those whose fingerprints match the fingerprint in their
+// DataSeriesRequest are marked NotModified and their contents are dropped,
+// along with any strings only they used.
 func (drb *DataResponseBuilder) Data() (*Data, error) {
 	if drb.errs.hasError {
 		return nil, drb.errs.toError()
 	}
+	st := drb.st.stringsByIndex
+	elided := false
+	for _, ds := range drb.d.DataSeries {
+		if ds.NotModified || ds.Root == nil {
+			continue
+		}
+		requested, ok := drb.requestedFingerprints[ds.SeriesName]
+		if !ok {
+			continue
+		}
+		if fp, ok := drb.sourceFingerprints[ds.SeriesName]; ok {
+			ds.Fingerprint = fp
+		} else {
+			ds.Fingerprint = ds.Root.Fingerprint(st)
+		}
+		if requested != "" && requested == ds.Fingerprint {
+			ds.Root, ds.NotModified, elided = nil, true, true
+		}
+	}
+	if elided {
+		// Rebuild the string table from the remaining series.
+		drb.st = newStringTable()
+		for _, ds := range drb.d.DataSeries {
+			if ds.Root == nil {
+				continue
+			}
+			if err := remapDatum(ds.Root, st, drb.st); err != nil {
+				return nil, fmt.Errorf("data series '%s': %s", ds.SeriesName, err)
+			}
+		}
+	}
 	drb.d.StringTable = drb.st.stringsByIndex
 	return drb.d, nil
 }
@@ -576,6 +1119,14 @@ func DoubleValue(f float64) *V {
 	}
 }
 
+// DoublesValue returns a new Value wrapping the provided float64s.
+func DoublesValue(dbls ...float64) *V {
+	return &V{
+		V: dbls,
+		T: DoublesValueType,
+	}
+}
+
 // DurationValue returns a new Value wrapping the provided Duration.
 func DurationValue(dur time.Duration) *V {
 	return &V{
@@ -662,6 +1213,15 @@ func ExpectDoubleValue(val *V) (float64, error) {
 	return val.V.(float64), nil
 }
 
+// ExpectDoublesValue expects the provided Value to be a Doubles, returning
+// that Doubles' contained float64 slice or an error if it isn't.
+func ExpectDoublesValue(val *V) ([]float64, error) {
+	if val.T != DoublesValueType {
+		return nil, fmt.Errorf("expected value type 'dbls'")
+	}
+	return val.V.([]float64), nil
+}
+
 // ExpectDurationValue expects the provided Value to be a duration, returning
 // that duration or an error if it isn't.
 func ExpectDurationValue(val *V) (time.Duration, error) {
@@ -707,6 +1267,11 @@ type datumBuilder struct {
 	st        *stringTable
 	valsByKey map[int64]*V
 	d         *Datum
+	// Children added with ChildNamed, by name.  Populated lazily.
+	namedChildren map[string]*datumBuilder
+	// The IDs assigned within the receiver's DataSeries.  Nil for scratch
+	// builders, whose IDs are not checked.
+	ids *datumIDs
 }
 
 // newDatumBuilder returns a new, empty datumBuilder.
@@ -740,10 +1305,28 @@ func (db *datumBuilder) With(updates ...PropertyUpdate) DataBuilder {
 
 func (db *datumBuilder) Child() DataBuilder {
 	child := newDatumBuilder(db.errs, db.st)
+	child.ids = db.ids
 	db.d.Children = append(db.d.Children, child.d)
 	return child
 }
 
+// ChildNamed returns the receiver's child with the provided name, adding it,
+// identified by a ChildNameKey property, if no such child exists yet.  This
+// allows several code paths to contribute to the same child; since later
+// updates replace earlier ones with the same key, repeating an update is
+// harmless.
+func (db *datumBuilder) ChildNamed(name string) DataBuilder {
+	if child, ok := db.namedChildren[name]; ok {
+		return child
+	}
+	if db.namedChildren == nil {
+		db.namedChildren = map[string]*datumBuilder{}
+	}
+	child := db.Child().(*datumBuilder).withStr(ChildNameKey, name)
+	db.namedChildren[name] = child
+	return child
+}
+
 // withStr sets the specified string value to the specified key within the map.
 // It supports chaining.
 func (db *datumBuilder) withStr(key, value string) *datumBuilder {
@@ -801,6 +1384,13 @@ func (db *datumBuilder) withDbl(key string, value float64) *datumBuilder {
 	return db
 }
 
+// withDbls sets the specified []float64 value to the specified key within
+// the map.  It supports chaining.
+func (db *datumBuilder) withDbls(key string, values ...float64) *datumBuilder {
+	db.valsByKey[db.st.stringIndex(key)] = DoublesValue(values...)
+	return db
+}
+
 // withDuration sets the specified duration value to the specified key within
 // the map.  It supports chaining.
 func (db *datumBuilder) withDuration(key string, value time.Duration) *datumBuilder {
@@ -847,6 +1437,53 @@ func Chain(updates ...PropertyUpdate) PropertyUpdate {
 	}
 }
 
+// PrefixedProperties applies the provided updates with each property key they
+// set prefixed by the provided prefix.  Only properties are retained; any
+// children the updates create are discarded.
+func PrefixedProperties(prefix string, updates ...PropertyUpdate) PropertyUpdate {
+	return func(db *datumBuilder) error {
+		scratch := newDatumBuilder(db.errs, db.st)
+		scratch.With(updates...)
+		for keyIdx, val := range scratch.valsByKey {
+			db.valsByKey[db.st.stringIndex(prefix+db.st.lookup(keyIdx))] = val
+		}
+		return nil
+	}
+}
+
+// PropertyKeys returns the keys of the properties set by the provided
+// updates, in increasing order, or the first error any update yields.
+func PropertyKeys(updates ...PropertyUpdate) ([]string, error) {
+	errs := &errors{}
+	st := newStringTable()
+	scratch := newDatumBuilder(errs, st)
+	scratch.With(updates...)
+	if err := errs.toError(); err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(scratch.valsByKey))
+	for keyIdx := range scratch.valsByKey {
+		ret = append(ret, st.lookup(keyIdx))
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// PropertyDatum returns a childless Datum holding the properties set by the
+// provided updates, and the string table its string indices refer to, or the
+// first error any update yields.  This allows properties to be inspected, via
+// the Datum's Property accessors, before they are emitted.
+func PropertyDatum(updates ...PropertyUpdate) (*Datum, []string, error) {
+	errs := &errors{}
+	st := newStringTable()
+	scratch := newDatumBuilder(errs, st)
+	scratch.With(updates...)
+	if err := errs.toError(); err != nil {
+		return nil, nil, err
+	}
+	return scratch.d, st.stringsByIndex, nil
+}
+
 // Nothing produces a Value setting nothing.  It is the Value equivalent
 // of EmptyUpdate, for use when a Value is required (e.g., in a function
 // argument) but nothing should be set.
@@ -889,6 +1526,13 @@ func Double(value float64) Value {
 	}
 }
 
+// Doubles produces a Value setting the specified []float64 value.
+func Doubles(values ...float64) Value {
+	return func(key string) PropertyUpdate {
+		return DoublesProperty(key, values...)
+	}
+}
+
 // Duration produces a Value setting the specified time.Duration value.
 func Duration(value time.Duration) Value {
 	return func(key string) PropertyUpdate {
@@ -961,6 +1605,15 @@ func DoubleProperty(key string, value float64) PropertyUpdate {
 	}
 }
 
+// DoublesProperty returns a PropertyUpdate adding the specified double slice
+// property.
+func DoublesProperty(key string, values ...float64) PropertyUpdate {
+	return func(db *datumBuilder) error {
+		db.withDbls(key, values...)
+		return nil
+	}
+}
+
 // DurationProperty returns a PropertyUpdate adding the specified duration property.
 func DurationProperty(key string, value time.Duration) PropertyUpdate {
 	return func(db *datumBuilder) error {
@@ -15,12 +15,16 @@ package util
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestStringTable(t *testing.T) {
@@ -315,6 +319,9 @@ func TestValueEncodingAndDecoding(t *testing.T) {
 	}, {
 		description: "dbl",
 		value:       DoubleValue(3.14159),
+	}, {
+		description: "dbls",
+		value:       DoublesValue(0.5, 1.25, -3),
 	}, {
 		description: "dur",
 		value:       DurationValue(time.Millisecond * 150),
@@ -435,6 +442,7 @@ func TestDataResponseBuilding(t *testing.T) {
 			if diff := cmp.Diff(
 				test.wantData,
 				gotData,
+				cmpopts.IgnoreFields(DataSeries{}, "Fingerprint"),
 			); diff != "" {
 				t.Errorf("Got Data %v, diff (-want +got):\n%s", gotData, diff)
 			}
@@ -442,6 +450,273 @@ func TestDataResponseBuilding(t *testing.T) {
 	}
 }
 
+func TestAddDataSeries(t *testing.T) {
+	// Build a series in one response, then splice it into another whose string
+	// table differs.
+	remoteDRB := NewDataResponseBuilder()
+	remoteDRB.DataSeries(&DataSeriesRequest{SeriesName: "remote"}).With(
+		StringProperty("name", "root"),
+	).Child().With(
+		StringsProperty("tags", "a", "b"),
+		IntegerProperty("weight", 3),
+	)
+	remoteData, err := remoteDRB.Data()
+	if err != nil {
+		t.Fatalf("Data yielded unexpected error %s", err)
+	}
+	drb := NewDataResponseBuilder()
+	drb.DataSeries(&DataSeriesRequest{SeriesName: "local"}).With(
+		StringProperty("weight", "b"),
+	)
+	if err := drb.AddDataSeries(remoteData.DataSeries[0], remoteData.StringTable); err != nil {
+		t.Fatalf("AddDataSeries yielded unexpected error %s", err)
+	}
+	gotData, err := drb.Data()
+	if err != nil {
+		t.Fatalf("Data yielded unexpected error %s", err)
+	}
+	wantData := &Data{
+		StringTable: []string{"weight", "b", "name", "root", "tags", "a"},
+		DataSeries: []*DataSeries{
+			&DataSeries{
+				SeriesName: "local",
+				Root: &Datum{
+					Properties: map[int64]*V{
+						0: StringIndexValue(1),
+					},
+					Children: []*Datum{},
+				},
+			},
+			&DataSeries{
+				SeriesName: "remote",
+				Root: &Datum{
+					Properties: map[int64]*V{
+						2: StringIndexValue(3),
+					},
+					Children: []*Datum{
+						&Datum{
+							Properties: map[int64]*V{
+								4: StringIndicesValue(5, 1),
+								0: IntValue(3),
+							},
+							Children: []*Datum{},
+						},
+					},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(wantData, gotData, cmpopts.IgnoreFields(DataSeries{}, "Fingerprint")); diff != "" {
+		t.Errorf("Got Data %v, diff (-want +got):\n%s", gotData, diff)
+	}
+	if err := NewDataResponseBuilder().AddDataSeries(remoteData.DataSeries[0], nil); err == nil {
+		t.Errorf("AddDataSeries with a short string table yielded no error")
+	}
+}
+
+func TestChildNamed(t *testing.T) {
+	// Two contributors add to the same named children, in different orders.
+	gotDRB := NewDataResponseBuilder()
+	got := gotDRB.DataSeries(&DataSeriesRequest{SeriesName: "series"})
+	got.ChildNamed("a").Child().With(IntegerProperty("span", 1))
+	got.Child().With(IntegerProperty("span", 2))
+	got.ChildNamed("b").With(StringProperty("label", "b"))
+	got.ChildNamed("a").With(StringProperty("label", "a")).Child().With(IntegerProperty("span", 3))
+	got.ChildNamed("b").With(StringProperty("label", "b"))
+	gotData, err := gotDRB.Data()
+	if err != nil {
+		t.Fatalf("Data yielded unexpected error %s", err)
+	}
+	wantDRB := NewDataResponseBuilder()
+	want := wantDRB.DataSeries(&DataSeriesRequest{SeriesName: "series"})
+	a := want.Child().With(StringProperty(ChildNameKey, "a"), StringProperty("label", "a"))
+	a.Child().With(IntegerProperty("span", 1))
+	a.Child().With(IntegerProperty("span", 3))
+	want.Child().With(IntegerProperty("span", 2))
+	want.Child().With(StringProperty(ChildNameKey, "b"), StringProperty("label", "b"))
+	wantData, err := wantDRB.Data()
+	if err != nil {
+		t.Fatalf("Data yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff(wantData.PrettyPrint(), gotData.PrettyPrint()); diff != "" {
+		t.Errorf("Got Data %s, diff (-want +got):\n%s", gotData.PrettyPrint(), diff)
+	}
+}
+
+func TestCloneAndMergeData(t *testing.T) {
+	build := func(globalFilters map[string]*V, series map[string]func(db DataBuilder)) *Data {
+		t.Helper()
+		drb := NewDataResponseBuilder().WithGlobalFilters(globalFilters)
+		names := make([]string, 0, len(series))
+		for name := range series {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			series[name](drb.DataSeries(&DataSeriesRequest{SeriesName: name}))
+		}
+		data, err := drb.Data()
+		if err != nil {
+			t.Fatalf("Data yielded unexpected error %s", err)
+		}
+		return data
+	}
+	dstSeries := map[string]func(db DataBuilder){
+		"a": func(db DataBuilder) {
+			db.With(StringProperty("name", "dst a")).Child().With(StringsProperty("tags", "x"))
+		},
+		"b": func(db DataBuilder) {
+			db.With(StringProperty("name", "dst b"))
+		},
+	}
+	srcSeries := map[string]func(db DataBuilder){
+		"b": func(db DataBuilder) {
+			db.With(StringProperty("label", "src b"))
+		},
+		"c": func(db DataBuilder) {
+			db.With(StringProperty("label", "src c")).Child().With(StringsProperty("tags", "y", "x"))
+		},
+	}
+	for _, test := range []struct {
+		description       string
+		policy            MergePolicy
+		wantSeries        map[string]func(db DataBuilder)
+		wantGlobalFilters map[string]*V
+		wantErr           bool
+	}{{
+		description: "keep destination",
+		policy:      KeepDestination,
+		wantSeries: map[string]func(db DataBuilder){
+			"a": dstSeries["a"],
+			"b": dstSeries["b"],
+			"c": srcSeries["c"],
+		},
+		wantGlobalFilters: map[string]*V{
+			"host": StringValue("dst"),
+			"user": StringValue("src"),
+		},
+	}, {
+		description: "replace destination",
+		policy:      ReplaceDestination,
+		wantSeries: map[string]func(db DataBuilder){
+			"a": dstSeries["a"],
+			"b": srcSeries["b"],
+			"c": srcSeries["c"],
+		},
+		wantGlobalFilters: map[string]*V{
+			"host": StringValue("src"),
+			"user": StringValue("src"),
+		},
+	}, {
+		description: "fail on conflict",
+		policy:      FailOnConflict,
+		wantErr:     true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			dst := build(map[string]*V{"host": StringValue("dst")}, dstSeries)
+			src := build(map[string]*V{"host": StringValue("src"), "user": StringValue("src")}, srcSeries)
+			origDst, origSrc := CloneData(dst), CloneData(src)
+			err := MergeData(dst, src, test.policy)
+			if diff := cmp.Diff(origSrc, src); diff != "" {
+				t.Errorf("MergeData modified its source, diff (-want +got):\n%s", diff)
+			}
+			if (err != nil) != test.wantErr {
+				t.Fatalf("MergeData yielded error %v, wanted error %t", err, test.wantErr)
+			}
+			if err != nil {
+				if diff := cmp.Diff(origDst, dst); diff != "" {
+					t.Errorf("Failed MergeData modified its destination, diff (-want +got):\n%s", diff)
+				}
+				return
+			}
+			want := build(test.wantGlobalFilters, test.wantSeries)
+			if diff := cmp.Diff(want.PrettyPrint(), dst.PrettyPrint()); diff != "" {
+				t.Errorf("Got merged Data %s, diff (-want +got):\n%s", dst.PrettyPrint(), diff)
+			}
+			if diff := cmp.Diff(want.GlobalFilters, dst.GlobalFilters); diff != "" {
+				t.Errorf("Got merged global filters %v, diff (-want +got):\n%s", dst.GlobalFilters, diff)
+			}
+			// Mutating the merged Data mustn't affect the source.
+			for _, ds := range dst.DataSeries {
+				for _, v := range ds.Root.Properties {
+					v.V = nil
+				}
+			}
+			if diff := cmp.Diff(origSrc, src); diff != "" {
+				t.Errorf("Merged Data shares values with its source, diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDatumIDsAndReferences(t *testing.T) {
+	drb := NewDataResponseBuilder()
+	trace := drb.DataSeries(&DataSeriesRequest{SeriesName: "trace"})
+	trace.Child().With(ID("span-1"), StringProperty("name", "a"))
+	trace.Child().With(ID("span-2"), StringProperty("name", "b")).
+		Child().With(Reference(DatumRef{ID: "span-1"})("parent"))
+	// Reassigning a Datum's own ID is harmless.
+	trace.ChildNamed("c").With(ID("span-3"))
+	trace.ChildNamed("c").With(ID("span-3"))
+	drb.DataSeries(&DataSeriesRequest{SeriesName: "table"}).Child().With(
+		ReferenceProperty("span", DatumRef{SeriesName: "trace", ID: "span-2"}),
+	)
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("Data yielded unexpected error %s", err)
+	}
+	// References survive JSON encoding.
+	encoded, err := json.Marshal(data.DataSeries[1].Root)
+	if err != nil {
+		t.Fatalf("Failed to encode Datum: %s", err)
+	}
+	row := &Datum{}
+	if err := json.Unmarshal(encoded, row); err != nil {
+		t.Fatalf("Failed to decode Datum: %s", err)
+	}
+	ref, ok := row.Children[0].PropertyReference(data.StringTable, "span")
+	if !ok {
+		t.Fatalf("Table row has no 'span' reference")
+	}
+	if got, want := ref.String(), "trace/span-2"; got != want {
+		t.Errorf("Table row references %s, want %s", got, want)
+	}
+	span, err := data.Resolve(ref, "table")
+	if err != nil {
+		t.Fatalf("Resolve(%s) yielded unexpected error %s", ref, err)
+	}
+	if name, _ := span.PropertyString(data.StringTable, "name"); name != "b" {
+		t.Errorf("Resolve(%s) yielded span named %q, want 'b'", ref, name)
+	}
+	// References without a series name are resolved in the referring series.
+	parentRef, ok := span.Children[0].PropertyReference(data.StringTable, "parent")
+	if !ok {
+		t.Fatalf("Span has no 'parent' reference")
+	}
+	parent, err := data.Resolve(parentRef, "trace")
+	if err != nil {
+		t.Fatalf("Resolve(%s) yielded unexpected error %s", parentRef, err)
+	}
+	if id, _ := parent.ID(data.StringTable); id != "span-1" {
+		t.Errorf("Resolve(%s) yielded datum with ID %q, want 'span-1'", parentRef, id)
+	}
+	if _, err := data.Resolve(DatumRef{SeriesName: "table", ID: "span-1"}, "trace"); err == nil {
+		t.Errorf("Resolve() of a datum in another series yielded no error")
+	}
+	// IDs must be unique within a series, but not across series.
+	drb = NewDataResponseBuilder()
+	drb.DataSeries(&DataSeriesRequest{SeriesName: "a"}).Child().With(ID("x"))
+	b := drb.DataSeries(&DataSeriesRequest{SeriesName: "b"})
+	b.Child().With(ID("x"))
+	if _, err := drb.Data(); err != nil {
+		t.Errorf("Data with an ID reused across series yielded unexpected error %s", err)
+	}
+	b.Child().With(ID("x"))
+	if _, err := drb.Data(); err == nil {
+		t.Errorf("Data with an ID reused within a series yielded no error")
+	}
+}
+
 func dataReqJSON(t *testing.T, req *DataRequest) []byte {
 	t.Helper()
 	ret, err := json.Marshal(req)
@@ -648,6 +923,46 @@ func TestPropertyUpdates(t *testing.T) {
 	}
 }
 
+func TestPropertyKeys(t *testing.T) {
+	got, err := PropertyKeys(
+		StringProperty("name", "Jane"),
+		If(false, IntegerProperty("age", 30)),
+		Chain(IntegerProperty("height", 170), StringsProperty("aliases", "J")),
+	)
+	if err != nil {
+		t.Fatalf("PropertyKeys() yielded unexpected error %s", err)
+	}
+	if diff := cmp.Diff([]string{"aliases", "height", "name"}, got); diff != "" {
+		t.Errorf("PropertyKeys() diff (-want +got):\n%s", diff)
+	}
+	if _, err := PropertyKeys(ErrorProperty(fmt.Errorf("oops"))); err == nil {
+		t.Errorf("PropertyKeys() yielded no error, wanted one")
+	}
+}
+
+func TestPropertyDatum(t *testing.T) {
+	d, st, err := PropertyDatum(
+		StringProperty("name", "Jane"),
+		If(false, IntegerProperty("age", 30)),
+		IntegerProperty("height", 170),
+	)
+	if err != nil {
+		t.Fatalf("PropertyDatum() yielded unexpected error %s", err)
+	}
+	if got, ok := d.PropertyString(st, "name"); !ok || got != "Jane" {
+		t.Errorf("PropertyDatum() name = %q, %t; want \"Jane\", true", got, ok)
+	}
+	if got, ok := d.PropertyNumber(st, "height"); !ok || got != 170 {
+		t.Errorf("PropertyDatum() height = %v, %t; want 170, true", got, ok)
+	}
+	if _, ok := d.Property(st, "age"); ok {
+		t.Errorf("PropertyDatum() unexpectedly set age")
+	}
+	if _, _, err := PropertyDatum(ErrorProperty(fmt.Errorf("oops"))); err == nil {
+		t.Errorf("PropertyDatum() yielded no error, wanted one")
+	}
+}
+
 func TestPrettyPrint(t *testing.T) {
 	for _, test := range []struct {
 		description string
@@ -696,6 +1011,21 @@ func TestPrettyPrint(t *testing.T) {
       Child:
         Prop 'items': [ 'apple', 'banana', 'coconut' ]
         Prop 'temp_f': 60.000000`,
+	}, {
+		description: "timestamps in UTC",
+		builder: func() *DataResponseBuilder {
+			drb := NewDataResponseBuilder()
+			drb.DataSeries(&DataSeriesRequest{SeriesName: "0"}).With(
+				TimestampProperty("when", time.Date(2023, time.May, 1, 12, 0, 0, 500, time.FixedZone("PDT", -7*60*60))),
+				DurationProperty("how_long", 1500*time.Millisecond),
+			)
+			return drb
+		},
+		want: `Data:
+  Series 0
+    Root:
+      Prop 'how_long': 1.5s
+      Prop 'when': 2023-05-01T19:00:00.0000005Z`,
 	}} {
 		drb := test.builder()
 		got, err := drb.Data()
@@ -707,3 +1037,625 @@ func TestPrettyPrint(t *testing.T) {
 		}
 	}
 }
+
+func TestPrettyPrintMalformed(t *testing.T) {
+	d := &Datum{
+		Properties: map[int64]*V{
+			0: StringIndexValue(5),
+			7: IntegerValue(3),
+		},
+	}
+	want := `Prop 'error: string index 7 out of range': 3
+Prop 'key': error: string index 5 out of range`
+	if diff := cmp.Diff(want, d.PrettyPrint("", []string{"key"})); diff != "" {
+		t.Errorf("PrettyPrint() diff (-want, +got) %s", diff)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	build := func(drb *DataResponseBuilder, req *DataSeriesRequest, name string) {
+		drb.DataSeries(req).
+			With(StringProperty("name", name)).
+			Child().With(StringsProperty("tags", "a", "b"), IntegerProperty("weight", 1))
+	}
+	req := &DataSeriesRequest{SeriesName: "1", WantFingerprint: true}
+	// Strings are added in a different order, so that the string tables differ.
+	drb1 := NewDataResponseBuilder()
+	drb1.DataSeries(&DataSeriesRequest{SeriesName: "0"}).With(StringProperty("other", "b"))
+	build(drb1, req, "x")
+	data1, err := drb1.Data()
+	if err != nil {
+		t.Fatalf("Data yielded unexpected error %s", err)
+	}
+	drb2 := NewDataResponseBuilder()
+	build(drb2, req, "x")
+	data2, err := drb2.Data()
+	if err != nil {
+		t.Fatalf("Data yielded unexpected error %s", err)
+	}
+	// Series whose requests don't want fingerprints aren't fingerprinted.
+	if got := data1.DataSeries[0].Fingerprint; got != "" {
+		t.Errorf("series not wanting a fingerprint has fingerprint '%s', want none", got)
+	}
+	fp := data1.DataSeries[1].Fingerprint
+	if fp == "" || fp != data2.DataSeries[0].Fingerprint {
+		t.Errorf("equal series yielded fingerprints '%s' and '%s'; want equal and nonempty", fp, data2.DataSeries[0].Fingerprint)
+	}
+	drb3 := NewDataResponseBuilder()
+	build(drb3, req, "y")
+	data3, err := drb3.Data()
+	if err != nil {
+		t.Fatalf("Data yielded unexpected error %s", err)
+	}
+	if data3.DataSeries[0].Fingerprint == fp {
+		t.Errorf("different series yielded equal fingerprints")
+	}
+	// A request with the current fingerprint yields a stub, and its strings
+	// are dropped from the string table.
+	drb4 := NewDataResponseBuilder()
+	build(drb4, &DataSeriesRequest{SeriesName: "1", Fingerprint: fp}, "x")
+	drb4.DataSeries(&DataSeriesRequest{SeriesName: "2"}).With(StringProperty("other", "b"))
+	data4, err := drb4.Data()
+	if err != nil {
+		t.Fatalf("Data yielded unexpected error %s", err)
+	}
+	wantData := &Data{
+		StringTable: []string{"other", "b"},
+		DataSeries: []*DataSeries{{
+			SeriesName:  "1",
+			Fingerprint: fp,
+			NotModified: true,
+		}, {
+			SeriesName: "2",
+			Root: &Datum{
+				Properties: map[int64]*V{
+					0: StringIndexValue(1),
+				},
+				Children: []*Datum{},
+			},
+		}},
+	}
+	if diff := cmp.Diff(wantData, data4, cmpopts.IgnoreFields(DataSeries{}, "Fingerprint")); diff != "" {
+		t.Errorf("Got Data %v, diff (-want +got):\n%s", data4, diff)
+	}
+	if data4.DataSeries[0].Fingerprint != fp {
+		t.Errorf("not-modified series has fingerprint '%s', want '%s'", data4.DataSeries[0].Fingerprint, fp)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	for _, test := range []struct {
+		description     string
+		req             *DataSeriesRequest
+		wantNotModified bool
+		wantData        *Data
+	}{{
+		description: "no fingerprint wanted",
+		req:         &DataSeriesRequest{SeriesName: "1"},
+		wantData: &Data{
+			StringTable: []string{"name", "x"},
+			DataSeries: []*DataSeries{{
+				SeriesName: "1",
+				Root: &Datum{
+					Properties: map[int64]*V{0: StringIndexValue(1)},
+					Children:   []*Datum{},
+				},
+			}},
+		},
+	}, {
+		description: "fingerprint wanted",
+		req:         &DataSeriesRequest{SeriesName: "1", WantFingerprint: true},
+		wantData: &Data{
+			StringTable: []string{"name", "x"},
+			DataSeries: []*DataSeries{{
+				SeriesName: "1",
+				Root: &Datum{
+					Properties: map[int64]*V{0: StringIndexValue(1)},
+					Children:   []*Datum{},
+				},
+				Fingerprint: "v2",
+			}},
+		},
+	}, {
+		description: "stale fingerprint",
+		req:         &DataSeriesRequest{SeriesName: "1", Fingerprint: "v1"},
+		wantData: &Data{
+			StringTable: []string{"name", "x"},
+			DataSeries: []*DataSeries{{
+				SeriesName: "1",
+				Root: &Datum{
+					Properties: map[int64]*V{0: StringIndexValue(1)},
+					Children:   []*Datum{},
+				},
+				Fingerprint: "v2",
+			}},
+		},
+	}, {
+		description:     "current fingerprint",
+		req:             &DataSeriesRequest{SeriesName: "1", Fingerprint: "v2"},
+		wantNotModified: true,
+		wantData: &Data{
+			DataSeries: []*DataSeries{{
+				SeriesName:  "1",
+				Fingerprint: "v2",
+				NotModified: true,
+			}},
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := NewDataResponseBuilder()
+			notModified := drb.NotModified(test.req, "v2")
+			if notModified != test.wantNotModified {
+				t.Errorf("NotModified() = %t, want %t", notModified, test.wantNotModified)
+			}
+			if !notModified {
+				drb.DataSeries(test.req).With(StringProperty("name", "x"))
+			}
+			data, err := drb.Data()
+			if err != nil {
+				t.Fatalf("Data yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(test.wantData, data); diff != "" {
+				t.Errorf("Got Data %v, diff (-want +got):\n%s", data, diff)
+			}
+		})
+	}
+}
+
+func TestViewport(t *testing.T) {
+	for _, test := range []struct {
+		description   string
+		globalFilters map[string]*V
+		wantOK        bool
+		want          *Viewport
+		wantErr       bool
+	}{{
+		description:   "no viewport",
+		globalFilters: map[string]*V{"other": StringValue("x")},
+	}, {
+		description: "timestamp viewport",
+		globalFilters: map[string]*V{
+			ViewportStartKey:            TimestampValue(time.Unix(100, 0)),
+			ViewportEndKey:              TimestampValue(time.Unix(110, 0)),
+			ViewportWidthPxKey:          IntegerValue(1000),
+			ViewportHeightPxKey:         IntegerValue(500),
+			ViewportDevicePixelRatioKey: DoubleValue(2),
+		},
+		wantOK: true,
+		want: &Viewport{
+			Start:            time.Unix(100, 0),
+			End:              time.Unix(110, 0),
+			WidthPx:          1000,
+			HeightPx:         500,
+			DevicePixelRatio: 2,
+		},
+	}, {
+		description: "duration viewport",
+		globalFilters: map[string]*V{
+			ViewportStartKey:   DurationValue(time.Second),
+			ViewportEndKey:     DurationValue(3 * time.Second),
+			ViewportWidthPxKey: IntegerValue(200),
+		},
+		wantOK: true,
+		want: &Viewport{
+			Start:            time.Time{}.Add(time.Second),
+			End:              time.Time{}.Add(3 * time.Second),
+			Durations:        true,
+			WidthPx:          200,
+			DevicePixelRatio: 1,
+		},
+	}, {
+		description: "incomplete viewport",
+		globalFilters: map[string]*V{
+			ViewportStartKey: DurationValue(time.Second),
+		},
+		wantErr: true,
+	}, {
+		description: "mixed range types",
+		globalFilters: map[string]*V{
+			ViewportStartKey:   DurationValue(time.Second),
+			ViewportEndKey:     TimestampValue(time.Unix(110, 0)),
+			ViewportWidthPxKey: IntegerValue(200),
+		},
+		wantErr: true,
+	}, {
+		description: "empty range",
+		globalFilters: map[string]*V{
+			ViewportStartKey:   DurationValue(time.Second),
+			ViewportEndKey:     DurationValue(time.Second),
+			ViewportWidthPxKey: IntegerValue(200),
+		},
+		wantErr: true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			req, err := DataRequestFromJSON(dataReqJSON(t, &DataRequest{
+				GlobalFilters: test.globalFilters,
+			}))
+			if err != nil {
+				t.Fatalf("DataRequestFromJSON() yielded unexpected error %s", err)
+			}
+			got, ok, err := req.Viewport()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Viewport() yielded error %v, wanted error %t", err, test.wantErr)
+			}
+			if ok != test.wantOK {
+				t.Fatalf("Viewport() = %t, want %t", ok, test.wantOK)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Fatalf("Viewport() = %v, diff (-want +got):\n%s", got, diff)
+			}
+			if !ok {
+				return
+			}
+			// The Viewport's own global filters should decode to it.
+			again, _, err := ExpectViewport(got.GlobalFilters())
+			if err != nil {
+				t.Fatalf("ExpectViewport(GlobalFilters()) yielded unexpected error %s", err)
+			}
+			if diff := cmp.Diff(got, again); diff != "" {
+				t.Errorf("ExpectViewport(GlobalFilters()) = %v, diff (-want +got):\n%s", again, diff)
+			}
+		})
+	}
+	vp := &Viewport{
+		Start:            time.Unix(0, 0),
+		End:              time.Unix(1, 0),
+		WidthPx:          1000,
+		DevicePixelRatio: 1.5,
+	}
+	if got, want := vp.PixelExtent(), time.Millisecond; got != want {
+		t.Errorf("PixelExtent() = %v, want %v", got, want)
+	}
+	if got, want := vp.DeviceWidthPx(), int64(1500); got != want {
+		t.Errorf("DeviceWidthPx() = %v, want %v", got, want)
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	schema := []*OptionSpec{{
+		Key:      "collection",
+		Source:   FromGlobal,
+		Required: true,
+		Type:     StringValueType,
+	}, {
+		Key:     "depth",
+		Default: IntegerValue(3),
+		Type:    IntegerValueType,
+	}, {
+		Key:    "filter",
+		Source: FromSeries,
+	}, {
+		Key: "host",
+	}}
+	for _, test := range []struct {
+		description   string
+		globalFilters map[string]*V
+		seriesOptions map[string]*V
+		wantErr       bool
+		wantValues    map[string]*V
+		wantConsumed  []string
+	}{{
+		description: "defaults",
+		globalFilters: map[string]*V{
+			"collection": StringValue("c"),
+			"filter":     StringValue("ignored"),
+			"unknown":    IntegerValue(1),
+		},
+		wantValues: map[string]*V{
+			"collection": StringValue("c"),
+			"depth":      IntegerValue(3),
+		},
+		wantConsumed: []string{"collection"},
+	}, {
+		description: "series options override global filters",
+		globalFilters: map[string]*V{
+			"collection": StringValue("c"),
+			"depth":      IntegerValue(4),
+			"host":       StringValue("global"),
+		},
+		seriesOptions: map[string]*V{
+			"depth":  IntegerValue(5),
+			"filter": StringValue("f"),
+		},
+		wantValues: map[string]*V{
+			"collection": StringValue("c"),
+			"depth":      IntegerValue(5),
+			"filter":     StringValue("f"),
+			"host":       StringValue("global"),
+		},
+		wantConsumed: []string{"collection", "depth", "filter", "host"},
+	}, {
+		description: "missing required",
+		seriesOptions: map[string]*V{
+			"depth": IntegerValue(5),
+		},
+		wantErr: true,
+	}, {
+		description: "global-only option",
+		globalFilters: map[string]*V{
+			"collection": StringValue("c"),
+		},
+		seriesOptions: map[string]*V{
+			"collection": StringValue("d"),
+		},
+		wantErr: true,
+	}, {
+		description: "unsupported option",
+		globalFilters: map[string]*V{
+			"collection": StringValue("c"),
+		},
+		seriesOptions: map[string]*V{
+			"unknown": StringValue("d"),
+		},
+		wantErr: true,
+	}, {
+		description: "wrong type",
+		globalFilters: map[string]*V{
+			"collection": StringValue("c"),
+			"depth":      StringValue("deep"),
+		},
+		wantErr: true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			ro, err := ResolveOptions(test.globalFilters, test.seriesOptions, schema)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ResolveOptions() yielded unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			for _, spec := range schema {
+				want, wantOk := test.wantValues[spec.Key]
+				got, gotOk := ro.Value(spec.Key)
+				if wantOk != gotOk || (wantOk && !cmp.Equal(want, got)) {
+					t.Errorf("Value(%s) = %v, %t; want %v, %t", spec.Key, got, gotOk, want, wantOk)
+				}
+			}
+			if diff := cmp.Diff(test.wantConsumed, ro.Consumed()); diff != "" {
+				t.Errorf("Consumed() diff (-want +got):\n%s", diff)
+			}
+			if collection, err := ro.String("collection"); err != nil || collection != "c" {
+				t.Errorf("String(collection) = %s, %v; want c", collection, err)
+			}
+			if _, err := ro.Integer("collection"); err == nil {
+				t.Errorf("Integer(collection) yielded no error")
+			}
+		})
+	}
+}
+
+func TestSetGlobalFilter(t *testing.T) {
+	requested := map[string]*V{
+		"collection": StringValue("c"),
+		"start":      TimestampValue(time.Unix(100, 0)),
+		"pan":        StringValue("left"),
+	}
+	drb := NewDataResponseBuilder().WithGlobalFilters(requested)
+	if drb.SetGlobalFilterIfChanged(requested, "collection", StringValue("c")) {
+		t.Errorf("SetGlobalFilterIfChanged() of an unchanged filter returned true")
+	}
+	if !drb.SetGlobalFilterIfChanged(requested, "start", TimestampValue(time.Unix(200, 0))) {
+		t.Errorf("SetGlobalFilterIfChanged() of a changed filter returned false")
+	}
+	if !drb.SetGlobalFilterIfChanged(requested, "end", TimestampValue(time.Unix(300, 0))) {
+		t.Errorf("SetGlobalFilterIfChanged() of a new filter returned false")
+	}
+	drb.SetGlobalFilter("pan", nil)
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("Data() yielded unexpected error %s", err)
+	}
+	want := map[string]*V{
+		"collection": StringValue("c"),
+		"start":      TimestampValue(time.Unix(200, 0)),
+		"end":        TimestampValue(time.Unix(300, 0)),
+	}
+	if diff := cmp.Diff(want, data.GlobalFilters); diff != "" {
+		t.Errorf("GlobalFilters diff (-want +got):\n%s", diff)
+	}
+	if len(requested) != 3 || requested["pan"] == nil {
+		t.Errorf("SetGlobalFilter() modified the requested global filters")
+	}
+}
+
+func TestCheckCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	visits := 0
+	visit := WithCtxChecks(ctx, func(int) error {
+		visits++
+		return nil
+	})
+	for i := 0; i < 2*CtxCheckInterval; i++ {
+		if err := visit(i); err != nil {
+			t.Fatalf("visit() yielded unexpected error %s", err)
+		}
+	}
+	cancel()
+	var err error
+	for i := 0; i < CtxCheckInterval && err == nil; i++ {
+		err = visit(i)
+	}
+	if err != context.Canceled {
+		t.Errorf("visit() after cancellation yielded %v, want %v", err, context.Canceled)
+	}
+	if visits != 2*CtxCheckInterval {
+		t.Errorf("visited %d times, want %d", visits, 2*CtxCheckInterval)
+	}
+	if err := CheckCtx(ctx, 1); err != nil {
+		t.Errorf("CheckCtx() off the check interval yielded unexpected error %s", err)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for _, test := range []struct {
+		secs, frac string
+		want       time.Duration
+		wantErr    bool
+	}{
+		{"1234", "567890", 1234*time.Second + 567890*time.Microsecond, false},
+		{"0", "5", 500 * time.Millisecond, false},
+		{"12", "1234567891", 12*time.Second + 123456789, false},
+		{"", "0", 0, true},
+		{"1", "x", 0, true},
+	} {
+		got, err := ParseTimestamp(test.secs, test.frac)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseTimestamp(%q, %q) yielded error %v, wanted error: %t", test.secs, test.frac, err, test.wantErr)
+		}
+		if err == nil && got != test.want {
+			t.Errorf("ParseTimestamp(%q, %q) = %s, want %s", test.secs, test.frac, got, test.want)
+		}
+	}
+}
+
+// randomV returns a randomly-generated V of a random type.
+func randomV(r *rand.Rand) *V {
+	str := func() string {
+		const letters = "abcdefghijklmnopqrstuvwxyz ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.'"
+		ret := make([]byte, r.Intn(10))
+		for idx := range ret {
+			ret[idx] = letters[r.Intn(len(letters))]
+		}
+		return string(ret)
+	}
+	ints := func() []int64 {
+		ret := make([]int64, r.Intn(5))
+		for idx := range ret {
+			ret[idx] = r.Int63() - r.Int63()
+		}
+		return ret
+	}
+	switch r.Intn(9) {
+	case 0:
+		return StringValue(str())
+	case 1:
+		return StringIndexValue(r.Int63n(100))
+	case 2:
+		strs := make([]string, r.Intn(5))
+		for idx := range strs {
+			strs[idx] = str()
+		}
+		return StringsValue(strs...)
+	case 3:
+		return StringIndicesValue(ints()...)
+	case 4:
+		return IntegerValue(r.Int63() - r.Int63())
+	case 5:
+		return IntegersValue(ints()...)
+	case 6:
+		return DoubleValue(r.NormFloat64() * 1e10)
+	case 7:
+		return DurationValue(time.Duration(r.Int63() - r.Int63()))
+	default:
+		return TimestampValue(time.Unix(r.Int63n(1<<40)-(1<<39), r.Int63n(int64(time.Second))))
+	}
+}
+
+func TestVRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		want := randomV(r)
+		j, err := json.Marshal(want)
+		if err != nil {
+			t.Fatalf("failed to marshal %v: %s", want, err)
+		}
+		got := &V{}
+		if err := json.Unmarshal(j, got); err != nil {
+			t.Fatalf("failed to unmarshal %s: %s", j, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("round-tripping %s yielded %v, diff (-want +got):\n%s", j, got, diff)
+		}
+	}
+}
+
+func TestVUnmarshalMalformed(t *testing.T) {
+	for _, j := range []string{
+		`{}`,
+		`[]`,
+		`[1]`,
+		`[1, "a", "b"]`,
+		`["1", "a"]`,
+		`[1, 2]`,
+		`[2, "a"]`,
+		`[3, "a"]`,
+		`[3, [1]]`,
+		`[5, 1.5]`,
+		`[5, 99999999999999999999]`,
+		`[5, "1"]`,
+		`[6, [1, "a"]]`,
+		`[6, {}]`,
+		`[7, "a"]`,
+		`[7, 1e400]`,
+		`[8, []]`,
+		`[9, 1]`,
+		`[9, [1]]`,
+		`[9, [1, 2, 3]]`,
+		`[9, ["a", 1]]`,
+		`[9, [1, 1.5]]`,
+		`[100, 1]`,
+	} {
+		v := &V{}
+		if err := json.Unmarshal([]byte(j), v); err == nil {
+			t.Errorf("unmarshaling %s yielded %v, but expected an error", j, v)
+		}
+	}
+	for _, j := range []string{
+		`{}`,
+		`[[], [], []]`,
+		`[1, []]`,
+		`[[1], []]`,
+		`[[[1, 2]], []]`,
+		`[[[1, [5, 1], 3]], []]`,
+		`[[], [1]]`,
+		`[[], [[[], 1]]]`,
+	} {
+		d := &Datum{}
+		if err := json.Unmarshal([]byte(j), d); err == nil {
+			t.Errorf("unmarshaling Datum %s yielded %v, but expected an error", j, d)
+		}
+	}
+}
+
+func FuzzVUnmarshalJSON(f *testing.F) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		j, err := json.Marshal(randomV(r))
+		if err != nil {
+			f.Fatalf("failed to marshal seed: %s", err)
+		}
+		f.Add(j)
+	}
+	for _, seed := range []string{`[0, null]`, `[9, [1, 2]]`, `[3, ["a%20b"]]`, `[5, "1"]`, `[1]`} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, j []byte) {
+		v := &V{}
+		if err := json.Unmarshal(j, v); err != nil {
+			return
+		}
+		// A successfully-decoded V must re-encode, and decode again.
+		again, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to re-marshal %s: %s", j, err)
+		}
+		if err := json.Unmarshal(again, &V{}); err != nil {
+			t.Fatalf("failed to re-unmarshal %s (from %s): %s", again, j, err)
+		}
+	})
+}
+
+func FuzzDatumUnmarshalJSON(f *testing.F) {
+	for _, seed := range []string{`[[], []]`, `[[[0, [5, 1]]], [[[], []]]]`, `[[[1, [9, [1, 2]]]], []]`} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, j []byte) {
+		d := &Datum{}
+		if err := json.Unmarshal(j, d); err != nil {
+			return
+		}
+		if _, err := json.Marshal(d); err != nil {
+			t.Fatalf("failed to re-marshal %s: %s", j, err)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Global filters describing the viewport through which a client displays the
+// requested data.  Frontends set these alongside their other global filters;
+// data sources decode them with ExpectViewport.
+const (
+	// The start and end of the visible range, as timestamps or, for views of
+	// duration axes, as durations.
+	ViewportStartKey = "viewport_start"
+	ViewportEndKey   = "viewport_end"
+	// The width and height of the view, in CSS pixels.
+	ViewportWidthPxKey  = "viewport_width_px"
+	ViewportHeightPxKey = "viewport_height_px"
+	// The ratio of device pixels to CSS pixels.  Optional; defaults to 1.
+	ViewportDevicePixelRatioKey = "viewport_device_pixel_ratio"
+)
+
+// Viewport describes the portion of a time axis a client displays, and the
+// size of the view displaying it.  Data sources can use a Viewport to omit or
+// aggregate data too small to see.
+type Viewport struct {
+	// The visible range.  If Durations is true, these are offsets from the
+	// zero time.Time.
+	Start, End time.Time
+	// If true, the visible range was specified as durations, as for views of
+	// a duration axis, rather than as timestamps.
+	Durations bool
+	// The size of the view in CSS pixels.  HeightPx is 0 if unspecified.
+	WidthPx, HeightPx int64
+	// The ratio of device pixels to CSS pixels.
+	DevicePixelRatio float64
+}
+
+// Extent returns the duration of the receiver's visible range.
+func (vp *Viewport) Extent() time.Duration {
+	return vp.End.Sub(vp.Start)
+}
+
+// StartOffset returns the start of the receiver's visible range as a
+// duration.  It is meaningful only if the receiver's Durations is true.
+func (vp *Viewport) StartOffset() time.Duration {
+	return vp.Start.Sub(time.Time{})
+}
+
+// EndOffset returns the end of the receiver's visible range as a duration.
+// It is meaningful only if the receiver's Durations is true.
+func (vp *Viewport) EndOffset() time.Duration {
+	return vp.End.Sub(time.Time{})
+}
+
+// PixelExtent returns the duration spanned by a single CSS pixel.
+func (vp *Viewport) PixelExtent() time.Duration {
+	return vp.Extent() / time.Duration(vp.WidthPx)
+}
+
+// DeviceWidthPx returns the width of the view in device pixels.
+func (vp *Viewport) DeviceWidthPx() int64 {
+	return int64(math.Round(float64(vp.WidthPx) * vp.DevicePixelRatio))
+}
+
+// GlobalFilters returns the global filters encoding the receiver, as a client
+// would send them.
+func (vp *Viewport) GlobalFilters() map[string]*V {
+	ret := map[string]*V{
+		ViewportWidthPxKey:          IntegerValue(vp.WidthPx),
+		ViewportDevicePixelRatioKey: DoubleValue(vp.DevicePixelRatio),
+	}
+	if vp.Durations {
+		ret[ViewportStartKey] = DurationValue(vp.StartOffset())
+		ret[ViewportEndKey] = DurationValue(vp.EndOffset())
+	} else {
+		ret[ViewportStartKey] = TimestampValue(vp.Start)
+		ret[ViewportEndKey] = TimestampValue(vp.End)
+	}
+	if vp.HeightPx > 0 {
+		ret[ViewportHeightPxKey] = IntegerValue(vp.HeightPx)
+	}
+	return ret
+}
+
+// expectViewportTime returns the provided viewport bound as a time.Time, and
+// whether it was specified as a duration.
+func expectViewportTime(key string, val *V) (time.Time, bool, error) {
+	switch val.T {
+	case TimestampValueType:
+		ts, err := ExpectTimestampValue(val)
+		return ts, false, err
+	case DurationValueType:
+		dur, err := ExpectDurationValue(val)
+		return time.Time{}.Add(dur), true, err
+	default:
+		return time.Time{}, false, fmt.Errorf("global filter '%s' must be a timestamp or duration", key)
+	}
+}
+
+// ExpectViewport returns the Viewport specified by the provided global
+// filters, and true, or false if they specify none.  Returns an error if the
+// viewport filters are incomplete or malformed.
+func ExpectViewport(globalFilters map[string]*V) (*Viewport, bool, error) {
+	startVal, startOK := globalFilters[ViewportStartKey]
+	endVal, endOK := globalFilters[ViewportEndKey]
+	widthVal, widthOK := globalFilters[ViewportWidthPxKey]
+	if !startOK && !endOK && !widthOK {
+		return nil, false, nil
+	}
+	if !startOK || !endOK || !widthOK {
+		return nil, false, fmt.Errorf("viewport requires global filters '%s', '%s', and '%s'", ViewportStartKey, ViewportEndKey, ViewportWidthPxKey)
+	}
+	ret := &Viewport{
+		DevicePixelRatio: 1,
+	}
+	var startDur, endDur bool
+	var err error
+	if ret.Start, startDur, err = expectViewportTime(ViewportStartKey, startVal); err != nil {
+		return nil, false, err
+	}
+	if ret.End, endDur, err = expectViewportTime(ViewportEndKey, endVal); err != nil {
+		return nil, false, err
+	}
+	if startDur != endDur {
+		return nil, false, fmt.Errorf("global filters '%s' and '%s' must have the same type", ViewportStartKey, ViewportEndKey)
+	}
+	ret.Durations = startDur
+	if !ret.End.After(ret.Start) {
+		return nil, false, fmt.Errorf("global filter '%s' must follow '%s'", ViewportEndKey, ViewportStartKey)
+	}
+	if ret.WidthPx, err = ExpectIntegerValue(widthVal); err != nil {
+		return nil, false, err
+	}
+	if ret.WidthPx <= 0 {
+		return nil, false, fmt.Errorf("global filter '%s' must be positive", ViewportWidthPxKey)
+	}
+	if heightVal, ok := globalFilters[ViewportHeightPxKey]; ok {
+		if ret.HeightPx, err = ExpectIntegerValue(heightVal); err != nil {
+			return nil, false, err
+		}
+	}
+	if dprVal, ok := globalFilters[ViewportDevicePixelRatioKey]; ok {
+		if ret.DevicePixelRatio, err = ExpectDoubleValue(dprVal); err != nil {
+			return nil, false, err
+		}
+		if ret.DevicePixelRatio <= 0 {
+			return nil, false, fmt.Errorf("global filter '%s' must be positive", ViewportDevicePixelRatioKey)
+		}
+	}
+	return ret, true, nil
+}
+
+// Viewport returns the Viewport specified by the receiver's global filters, as
+// ExpectViewport.
+func (dr *DataRequest) Viewport() (*Viewport, bool, error) {
+	return ExpectViewport(dr.GlobalFilters)
+}
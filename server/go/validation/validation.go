@@ -0,0 +1,110 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package validation checks TraceViz data responses against the documented
+// structure of the well-known data models -- traces, weighted trees, xy
+// charts, and tables -- so that malformed responses can be caught in tests,
+// or optionally at serve time, before they reach the frontend.
+//
+// Given a built response `data *util.Data`,
+//
+//	err := validation.Validate(data)
+//
+// checks each data series against the model it is detected to hold, while
+//
+//	err := validation.ValidateSeries(data, seriesName, validation.Trace)
+//
+// checks a specific series against a specific model.
+package validation
+
+import (
+	"fmt"
+
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+// Model is a well-known TraceViz data model.
+type Model struct {
+	Name string
+	// Detect reports whether the provided Datum appears to be the root of an
+	// instance of the model.
+	Detect func(root *util.Datum, st []string) bool
+	// Validate checks that the provided Datum is the root of a well-formed
+	// instance of the model.
+	Validate func(root *util.Datum, st []string) error
+}
+
+// The well-known data models.
+var (
+	Trace        = &Model{"trace", trace.Detect, trace.Validate}
+	WeightedTree = &Model{"weighted tree", weightedtree.Detect, weightedtree.Validate}
+	XYChart      = &Model{"xy chart", xychart.Detect, xychart.Validate}
+	Table        = &Model{"table", table.Detect, table.Validate}
+)
+
+// Models holds the well-known data models, in the order in which Validate
+// attempts to detect them.
+var Models = []*Model{Trace, WeightedTree, XYChart, Table}
+
+// Detect returns the first of Models detected in the provided Datum, whose
+// string indices refer to the provided string table, and true, or false if
+// none is detected.
+func Detect(root *util.Datum, st []string) (*Model, bool) {
+	for _, model := range Models {
+		if model.Detect(root, st) {
+			return model, true
+		}
+	}
+	return nil, false
+}
+
+// Validate checks each data series in the provided Data against the model it
+// is detected to hold.  Series holding no detected model, and series that are
+// not modified, are not checked.
+func Validate(data *util.Data) error {
+	for _, series := range data.DataSeries {
+		if series.NotModified || series.Root == nil {
+			continue
+		}
+		model, ok := Detect(series.Root, data.StringTable)
+		if !ok {
+			continue
+		}
+		if err := model.Validate(series.Root, data.StringTable); err != nil {
+			return fmt.Errorf("series '%s' is not a valid %s: %w", series.SeriesName, model.Name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateSeries checks the named data series in the provided Data against
+// the specified model.
+func ValidateSeries(data *util.Data, seriesName string, model *Model) error {
+	for _, series := range data.DataSeries {
+		if series.SeriesName != seriesName {
+			continue
+		}
+		if series.NotModified || series.Root == nil {
+			return fmt.Errorf("series '%s' has no data", seriesName)
+		}
+		if err := model.Validate(series.Root, data.StringTable); err != nil {
+			return fmt.Errorf("series '%s' is not a valid %s: %w", seriesName, model.Name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no series named '%s'", seriesName)
+}
@@ -0,0 +1,281 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	categoryaxis "github.com/google/traceviz/server/go/category_axis"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/payload"
+	"github.com/google/traceviz/server/go/table"
+	"github.com/google/traceviz/server/go/trace"
+	"github.com/google/traceviz/server/go/util"
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+	xychart "github.com/google/traceviz/server/go/xy_chart"
+)
+
+var (
+	xCat      = category.New("x_axis", "Time", "Time from start")
+	yCat      = category.New("y_axis", "Value", "Value")
+	threadCat = category.New("thread", "Thread", "Thread")
+	traceRS   = &trace.RenderSettings{
+		CategoryAxisRenderSettings: &categoryaxis.RenderSettings{},
+	}
+	nameCat = category.New("name", "Name", "Name")
+	nameCol = table.Column(nameCat)
+	sizeCol = table.Column(category.New("size", "Size", "Size"))
+)
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		build       func(db util.DataBuilder)
+		wantModel   *Model
+		wantErr     bool
+	}{{
+		description: "valid trace",
+		build: func(db util.DataBuilder) {
+			span := trace.New(db, continuousaxis.NewDurationAxis(xCat, 0, 100), traceRS).
+				Category(threadCat).
+				Span(10, 90)
+			span.Span(20, 30)
+			span.Subspan(40, 50)
+			payload.New(span, "thing")
+		},
+		wantModel: Trace,
+	}, {
+		description: "trace span outside axis",
+		build: func(db util.DataBuilder) {
+			trace.New(db, continuousaxis.NewDurationAxis(xCat, 0, 100), traceRS).
+				Category(threadCat).
+				Span(50, 150)
+		},
+		wantModel: Trace,
+		wantErr:   true,
+	}, {
+		description: "trace span ending before it starts",
+		build: func(db util.DataBuilder) {
+			trace.New(db, continuousaxis.NewDurationAxis(xCat, 0, 100), traceRS).
+				Category(threadCat).
+				Span(50, 40)
+		},
+		wantModel: Trace,
+		wantErr:   true,
+	}, {
+		description: "trace span directly under trace",
+		build: func(db util.DataBuilder) {
+			tr := trace.New(db, continuousaxis.NewDurationAxis(xCat, 0, 100), traceRS)
+			// Add a category whose span is then misplaced by hand.
+			tr.Category(threadCat)
+			db.Child().With(util.IntegerProperty("trace_node_type", 1))
+		},
+		wantModel: Trace,
+		wantErr:   true,
+	}, {
+		description: "valid weighted tree",
+		build: func(db util.DataBuilder) {
+			tree := weightedtree.New(db, &weightedtree.RenderSettings{FrameHeightPx: 20})
+			node := tree.Node(10)
+			node.Node(5)
+			payload.New(node, "thing")
+		},
+		wantModel: WeightedTree,
+	}, {
+		description: "weighted tree node with negative magnitude",
+		build: func(db util.DataBuilder) {
+			weightedtree.New(db, &weightedtree.RenderSettings{FrameHeightPx: 20}).Node(10).Node(-5)
+		},
+		wantModel: WeightedTree,
+		wantErr:   true,
+	}, {
+		description: "weighted tree node without magnitude",
+		build: func(db util.DataBuilder) {
+			weightedtree.New(db, &weightedtree.RenderSettings{FrameHeightPx: 20})
+			db.Child().With(util.StringProperty("name", "orphan"))
+		},
+		wantModel: WeightedTree,
+		wantErr:   true,
+	}, {
+		description: "valid xy chart",
+		build: func(db util.DataBuilder) {
+			xychart.New(db,
+				continuousaxis.NewTimestampAxis(xCat, time.Unix(0, 0), time.Unix(100, 0)),
+				continuousaxis.NewDoubleAxis(yCat, 0, 10)).
+				AddSeries(category.New("s", "S", "S")).
+				WithPoint(time.Unix(0, 0), 1).
+				WithPoint(time.Unix(100, 0), 10)
+		},
+		wantModel: XYChart,
+	}, {
+		description: "xy chart point outside axis",
+		build: func(db util.DataBuilder) {
+			xychart.New(db,
+				continuousaxis.NewTimestampAxis(xCat, time.Unix(0, 0), time.Unix(100, 0)),
+				continuousaxis.NewDoubleAxis(yCat, 0, 10)).
+				AddSeries(category.New("s", "S", "S")).
+				WithPoint(time.Unix(50, 0), 11)
+		},
+		wantModel: XYChart,
+		wantErr:   true,
+	}, {
+		description: "xy chart with gap",
+		build: func(db util.DataBuilder) {
+			xychart.New(db,
+				continuousaxis.NewTimestampAxis(xCat, time.Unix(0, 0), time.Unix(100, 0)),
+				continuousaxis.NewDoubleAxis(yCat, 0, 10)).
+				AddSeries(category.New("s", "S", "S")).
+				WithPoint(time.Unix(0, 0), 1).
+				WithGap(time.Unix(10, 0), time.Unix(90, 0)).
+				WithPoint(time.Unix(100, 0), 10)
+		},
+		wantModel: XYChart,
+	}, {
+		description: "xy chart reversed gap",
+		build: func(db util.DataBuilder) {
+			xychart.New(db,
+				continuousaxis.NewTimestampAxis(xCat, time.Unix(0, 0), time.Unix(100, 0)),
+				continuousaxis.NewDoubleAxis(yCat, 0, 10)).
+				AddSeries(category.New("s", "S", "S")).
+				WithGap(time.Unix(90, 0), time.Unix(10, 0))
+		},
+		wantModel: XYChart,
+		wantErr:   true,
+	}, {
+		description: "xy chart with point run",
+		build: func(db util.DataBuilder) {
+			xychart.New(db,
+				continuousaxis.NewTimestampAxis(xCat, time.Unix(0, 0), time.Unix(100, 0)),
+				continuousaxis.NewDoubleAxis(yCat, 0, 10)).
+				AddSeries(category.New("s", "S", "S")).
+				WithPoints([]time.Time{time.Unix(0, 0), time.Unix(50, 0), time.Unix(100, 0)}, []float64{1, 5, 10})
+		},
+		wantModel: XYChart,
+	}, {
+		description: "xy chart point run outside axis",
+		build: func(db util.DataBuilder) {
+			xychart.New(db,
+				continuousaxis.NewTimestampAxis(xCat, time.Unix(0, 0), time.Unix(100, 0)),
+				continuousaxis.NewDoubleAxis(yCat, 0, 10)).
+				AddSeries(category.New("s", "S", "S")).
+				WithPoints([]time.Time{time.Unix(50, 0), time.Unix(101, 0)}, []float64{1, 5})
+		},
+		wantModel: XYChart,
+		wantErr:   true,
+	}, {
+		description: "valid table",
+		build: func(db util.DataBuilder) {
+			tbl := table.New(db, nil, nameCol, sizeCol)
+			tbl.Row(
+				table.Cell(nameCol, util.String("a")),
+				table.FormattedCell(sizeCol, "$(bytes) bytes", util.IntegerProperty("bytes", 3)),
+			)
+			payload.New(tbl.Row(table.Cell(nameCol, util.String("b"))), "thing")
+		},
+		wantModel: Table,
+	}, {
+		description: "valid table with child rows",
+		build: func(db util.DataBuilder) {
+			row := table.New(db, nil, nameCol, sizeCol).Row(
+				table.Cell(nameCol, util.String("a")),
+			).Collapsed()
+			row.Row(table.Cell(sizeCol, util.Integer(3)))
+			row.With(table.MatchRanges(nameCol, table.MatchRange{Start: 0, End: 1}))
+		},
+		wantModel: Table,
+	}, {
+		description: "table child row cell in undefined column",
+		build: func(db util.DataBuilder) {
+			table.New(db, nil, nameCol).Row(
+				table.Cell(nameCol, util.String("a")),
+			).Row(
+				table.Cell(sizeCol, util.Integer(3)),
+			)
+		},
+		wantModel: Table,
+		wantErr:   true,
+	}, {
+		description: "table match ranges in undefined column",
+		build: func(db util.DataBuilder) {
+			table.New(db, nil, nameCol).Row(
+				table.Cell(nameCol, util.String("abc")),
+			).With(table.MatchRanges(sizeCol, table.MatchRange{Start: 0, End: 1}))
+		},
+		wantModel: Table,
+		wantErr:   true,
+	}, {
+		description: "table cell in undefined column",
+		build: func(db util.DataBuilder) {
+			table.New(db, nil, nameCol).Row(
+				table.Cell(sizeCol, util.Integer(3)),
+			)
+		},
+		wantModel: Table,
+		wantErr:   true,
+	}, {
+		description: "table cell with no value",
+		build: func(db util.DataBuilder) {
+			table.New(db, nil, nameCol)
+			db.Child().Child().With(nameCat.Tag())
+		},
+		wantModel: Table,
+		wantErr:   true,
+	}, {
+		description: "unrecognized series",
+		build: func(db util.DataBuilder) {
+			db.With(util.StringProperty("greeting", "hello"))
+		},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := util.NewDataResponseBuilder()
+			test.build(drb.DataSeries(&util.DataSeriesRequest{SeriesName: "series"}))
+			data, err := drb.Data()
+			if err != nil {
+				t.Fatalf("failed to build response: %s", err)
+			}
+			gotModel, ok := Detect(data.DataSeries[0].Root, data.StringTable)
+			if ok != (test.wantModel != nil) || gotModel != test.wantModel {
+				t.Fatalf("Detect() = %v, %t, want %v", gotModel, ok, test.wantModel)
+			}
+			err = Validate(data)
+			if (err != nil) != test.wantErr {
+				t.Errorf("Validate() yielded error %v, wanted error %t", err, test.wantErr)
+			}
+			if test.wantModel != nil {
+				err = ValidateSeries(data, "series", test.wantModel)
+				if (err != nil) != test.wantErr {
+					t.Errorf("ValidateSeries() yielded error %v, wanted error %t", err, test.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSeriesMismatch(t *testing.T) {
+	drb := util.NewDataResponseBuilder()
+	weightedtree.New(drb.DataSeries(&util.DataSeriesRequest{SeriesName: "tree"}), &weightedtree.RenderSettings{FrameHeightPx: 20}).
+		Node(1)
+	data, err := drb.Data()
+	if err != nil {
+		t.Fatalf("failed to build response: %s", err)
+	}
+	if err := ValidateSeries(data, "tree", Table); err == nil {
+		t.Errorf("ValidateSeries() of a tree as a table yielded no error, but expected one")
+	}
+	if err := ValidateSeries(data, "missing", WeightedTree); err == nil {
+		t.Errorf("ValidateSeries() of a missing series yielded no error, but expected one")
+	}
+}
@@ -0,0 +1,92 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package comparators provides ready-made weightedtree.CompareFns, and
+// wrappers composing them, so that data sources needn't each implement their
+// own deterministic tie-breaking.  For example,
+//
+//	comparators.Stable(comparators.ByTotalWeight(weighFn))
+//
+// visits heavier nodes first, breaking ties in favor of shallower nodes and
+// then of lower paths.
+package comparators
+
+import (
+	"slices"
+
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+)
+
+// ByTotalWeight returns a CompareFn ordering by the weight computed by the
+// provided WeightFn, so that heavier nodes are visited first.  Ties are not
+// broken.
+func ByTotalWeight(weightFn weightedtree.WeightFn) weightedtree.CompareFn {
+	return func(a, b weightedtree.Comparable) (int, error) {
+		aWeight, err := weightFn(a)
+		if err != nil {
+			return 0, err
+		}
+		bWeight, err := weightFn(b)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case aWeight < bWeight:
+			return -1, nil
+		case aWeight > bWeight:
+			return 1, nil
+		default:
+			return 0, nil
+		}
+	}
+}
+
+// ByPathLexicographic wraps the provided CompareFn, breaking its ties by
+// comparing paths lexicographically.  Lower paths compare greater, so are
+// visited first.
+func ByPathLexicographic(compare weightedtree.CompareFn) weightedtree.CompareFn {
+	return func(a, b weightedtree.Comparable) (int, error) {
+		ret, err := compare(a, b)
+		if err != nil || ret != 0 {
+			return ret, err
+		}
+		return slices.Compare(b.Path, a.Path), nil
+	}
+}
+
+// Stable wraps the provided CompareFn, breaking its ties first by path length
+// and then by comparing paths lexicographically.  Shallower, then lower, paths
+// compare greater, so are visited first.  Since distinct nodes have distinct
+// paths, the returned CompareFn totally orders a tree's nodes, as is required
+// by, e.g., WalkPage.
+func Stable(compare weightedtree.CompareFn) weightedtree.CompareFn {
+	return func(a, b weightedtree.Comparable) (int, error) {
+		ret, err := compare(a, b)
+		if err != nil || ret != 0 {
+			return ret, err
+		}
+		if len(a.Path) != len(b.Path) {
+			return len(b.Path) - len(a.Path), nil
+		}
+		return slices.Compare(b.Path, a.Path), nil
+	}
+}
+
+// Reversed returns a CompareFn ordering in reverse of the provided one, so
+// that, e.g., lighter nodes are visited first.
+func Reversed(compare weightedtree.CompareFn) weightedtree.CompareFn {
+	return func(a, b weightedtree.Comparable) (int, error) {
+		ret, err := compare(a, b)
+		return -ret, err
+	}
+}
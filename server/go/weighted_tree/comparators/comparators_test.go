@@ -0,0 +1,139 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package comparators
+
+import (
+	"fmt"
+	"testing"
+
+	weightedtree "github.com/google/traceviz/server/go/weighted_tree"
+)
+
+// weight returns the precomputed weight of the provided Comparable, or an error
+// if it is negative.
+func weight(c weightedtree.Comparable) (float64, error) {
+	if c.Weight < 0 {
+		return 0, fmt.Errorf("negative weight")
+	}
+	return c.Weight, nil
+}
+
+func comparable(weight float64, path ...weightedtree.ScopeID) weightedtree.Comparable {
+	return weightedtree.Comparable{
+		Path:   path,
+		Weight: weight,
+	}
+}
+
+func TestComparators(t *testing.T) {
+	byWeight := ByTotalWeight(weight)
+	for _, test := range []struct {
+		description string
+		compare     weightedtree.CompareFn
+		a, b        weightedtree.Comparable
+		want        int
+		wantErr     bool
+	}{{
+		description: "by weight, heavier",
+		compare:     byWeight,
+		a:           comparable(2, 1),
+		b:           comparable(1, 2),
+		want:        1,
+	}, {
+		description: "by weight, lighter",
+		compare:     byWeight,
+		a:           comparable(1, 1),
+		b:           comparable(2, 2),
+		want:        -1,
+	}, {
+		description: "by weight, tie",
+		compare:     byWeight,
+		a:           comparable(1, 1),
+		b:           comparable(1, 2),
+		want:        0,
+	}, {
+		description: "by weight, error",
+		compare:     byWeight,
+		a:           comparable(-1, 1),
+		b:           comparable(1, 2),
+		wantErr:     true,
+	}, {
+		description: "lexicographic, weights differ",
+		compare:     ByPathLexicographic(byWeight),
+		a:           comparable(1, 1),
+		b:           comparable(2, 2),
+		want:        -1,
+	}, {
+		description: "lexicographic, lower path",
+		compare:     ByPathLexicographic(byWeight),
+		a:           comparable(1, 1, 5),
+		b:           comparable(1, 2),
+		want:        1,
+	}, {
+		description: "lexicographic, higher path",
+		compare:     ByPathLexicographic(byWeight),
+		a:           comparable(1, 2),
+		b:           comparable(1, 1, 5),
+		want:        -1,
+	}, {
+		description: "lexicographic, error",
+		compare:     ByPathLexicographic(byWeight),
+		a:           comparable(1, 1),
+		b:           comparable(-1, 2),
+		wantErr:     true,
+	}, {
+		description: "stable, shallower path",
+		compare:     Stable(byWeight),
+		a:           comparable(1, 2),
+		b:           comparable(1, 1, 5),
+		want:        1,
+	}, {
+		description: "stable, lower path",
+		compare:     Stable(byWeight),
+		a:           comparable(1, 1, 5),
+		b:           comparable(1, 1, 6),
+		want:        1,
+	}, {
+		description: "stable, same path",
+		compare:     Stable(byWeight),
+		a:           comparable(1, 1, 5),
+		b:           comparable(1, 1, 5),
+		want:        0,
+	}, {
+		description: "reversed, heavier",
+		compare:     Reversed(byWeight),
+		a:           comparable(2, 1),
+		b:           comparable(1, 2),
+		want:        -1,
+	}, {
+		description: "reversed stable, shallower path",
+		compare:     Reversed(Stable(byWeight)),
+		a:           comparable(1, 2),
+		b:           comparable(1, 1, 5),
+		want:        -1,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			got, err := test.compare(test.a, test.b)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("compare yielded unexpected error %v", err)
+			}
+			if err != nil {
+				return
+			}
+			if (got < 0) != (test.want < 0) || (got > 0) != (test.want > 0) {
+				t.Errorf("compare(%v, %v) = %d, want sign of %d", test.a.Path, test.b.Path, got, test.want)
+			}
+		})
+	}
+}
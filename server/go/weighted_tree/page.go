@@ -0,0 +1,92 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package weightedtree
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const pageTokenSeparator = "/"
+
+// formatPath returns the provided path as a string, e.g. '/1/2'.
+func formatPath(path []ScopeID) string {
+	ret := make([]string, len(path))
+	for idx, scopeID := range path {
+		ret[idx] = strconv.FormatUint(uint64(scopeID), 10)
+	}
+	return pageTokenSeparator + strings.Join(ret, pageTokenSeparator)
+}
+
+// newPageToken returns a page token naming the node at the provided path.
+func newPageToken(path []ScopeID) string {
+	return formatPath(path)
+}
+
+// parsePageToken returns the path named by the provided page token, or nil if
+// the token is empty.
+func parsePageToken(pageToken string) ([]ScopeID, error) {
+	if pageToken == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pageToken, pageTokenSeparator) {
+		return nil, fmt.Errorf("malformed page token '%s'", pageToken)
+	}
+	ret := []ScopeID{}
+	if pageToken == pageTokenSeparator {
+		return ret, nil
+	}
+	for _, part := range strings.Split(pageToken[len(pageTokenSeparator):], pageTokenSeparator) {
+		scopeID, err := strconv.ParseUint(part, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("malformed page token '%s'", pageToken)
+		}
+		ret = append(ret, ScopeID(scopeID))
+	}
+	return ret, nil
+}
+
+// prunePrevious removes from beneath the provided SubtreeNode all Previous
+// descendants that have no non-Previous descendants, returning true if any
+// non-Previous SubtreeNodes remain at or beneath it.
+func prunePrevious(stn *SubtreeNode) bool {
+	if stn == nil {
+		return false
+	}
+	children := stn.Children[:0]
+	for _, child := range stn.Children {
+		if prunePrevious(child) {
+			children = append(children, child)
+		}
+	}
+	stn.Children = children
+	return !stn.Previous || len(stn.Children) > 0
+}
+
+// WalkPage performs a paginated Walk, for trees too wide to return all at
+// once.  The page size is set by the MaxNodes WalkOption; without it, the
+// first page holds the entire traversal.  Pass an empty pageToken for the first page, and the returned
+// nextPageToken for each subsequent one; nextPageToken is empty once the
+// traversal is exhausted.
+//
+// A page token names the last node returned in heaviest-first order.  To
+// resume after it, WalkPage retraverses the nodes of earlier pages, so the
+// tree, CompareFn, and WalkOptions must be unchanged across pages, and the
+// CompareFn must break ties deterministically.  Nodes returned in earlier
+// pages are only included in later ones as ancestors of that page's nodes,
+// with Previous=true.
+func WalkPage(root TreeNode, compare CompareFn, pageToken string, opts ...WalkOption) (subtree *SubtreeNode, nextPageToken string, err error) {
+	return walk(root, compare, pageToken, opts...)
+}
@@ -0,0 +1,123 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package weightedtree
+
+import (
+	"fmt"
+
+	"github.com/google/traceviz/server/go/category"
+	"github.com/google/traceviz/server/go/color"
+	"github.com/google/traceviz/server/go/magnitude"
+	"github.com/google/traceviz/server/go/render"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// The frame height used when rendering trees that don't specify their own.
+const defaultFrameHeightPx = 20
+
+// renderNode is a tree node being rendered.
+type renderNode struct {
+	d              *util.Datum
+	totalMagnitude float64
+	children       []*renderNode
+}
+
+func newRenderNode(d *util.Datum, st []string) *renderNode {
+	self, _ := magnitude.SelfMagnitudeOf(d, st)
+	ret := &renderNode{
+		d:              d,
+		totalMagnitude: self,
+	}
+	for _, child := range d.Children {
+		// Nodes have self-magnitudes; payloads don't.
+		if _, ok := magnitude.SelfMagnitudeOf(child, st); !ok {
+			continue
+		}
+		childNode := newRenderNode(child, st)
+		ret.children = append(ret.children, childNode)
+		ret.totalMagnitude += childNode.totalMagnitude
+	}
+	return ret
+}
+
+func (rn *renderNode) depth() int {
+	ret := 0
+	for _, child := range rn.children {
+		if d := child.depth(); d > ret {
+			ret = d
+		}
+	}
+	return ret + 1
+}
+
+// Render lays out the tree rooted at the provided Datum, whose string indices
+// refer to the provided string table, as a flame chart in a render.Scene of
+// the specified width.  Each node is a frame whose width is proportional to
+// its total magnitude, with its children beneath it, or above it if the tree
+// is bottom-up.  Frames are labeled with the value of the specified string
+// property, or, if that is empty or missing, with the display name of their
+// defined category, if any.  Frames are filled with their primary colors or,
+// lacking those, with colors chosen by label.
+func Render(root *util.Datum, st []string, widthPx float64, labelKey string) (*render.Scene, error) {
+	if _, ok := root.Property(st, frameHeightPxKey); !ok {
+		return nil, fmt.Errorf("weighted tree has no render settings")
+	}
+	frameHeightPx, _ := root.PropertyNumber(st, frameHeightPxKey)
+	if frameHeightPx <= 0 {
+		frameHeightPx = defaultFrameHeightPx
+	}
+	direction, _ := root.PropertyString(st, directionKey)
+	tree := newRenderNode(root, st)
+	depth := tree.depth() - 1
+	scene := render.NewScene(widthPx, float64(depth)*frameHeightPx)
+	if tree.totalMagnitude <= 0 {
+		return scene, nil
+	}
+	coloring := color.NewColoring(st, root)
+	label := func(d *util.Datum) string {
+		if labelKey != "" {
+			if str, ok := d.PropertyString(st, labelKey); ok && str != "" {
+				return str
+			}
+		}
+		if cat, ok := category.Defined(d, st); ok {
+			return cat.DisplayName()
+		}
+		return ""
+	}
+	pxPerMagnitude := widthPx / tree.totalMagnitude
+	var draw func(rn *renderNode, x float64, level int)
+	draw = func(rn *renderNode, x float64, level int) {
+		y := float64(level) * frameHeightPx
+		if direction == bottomUp {
+			y = float64(depth-level-1) * frameHeightPx
+		}
+		l := label(rn.d)
+		fill, ok := coloring.Primary(rn.d, st)
+		if !ok {
+			fill = render.DefaultFill(l)
+		}
+		scene.Rect(x, y, rn.totalMagnitude*pxPerMagnitude, frameHeightPx, fill, l)
+		for _, child := range rn.children {
+			draw(child, x, level+1)
+			x += child.totalMagnitude * pxPerMagnitude
+		}
+	}
+	x := 0.0
+	for _, child := range tree.children {
+		draw(child, x, 0)
+		x += child.totalMagnitude * pxPerMagnitude
+	}
+	return scene, nil
+}
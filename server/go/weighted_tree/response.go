@@ -0,0 +1,146 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package weightedtree
+
+import (
+	"fmt"
+
+	"github.com/google/traceviz/server/go/keys"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Standard node annotations.  Nodes always carry their self-magnitudes; these
+// additional annotations let flame charts and tables of hot frames present
+// nodes consistently across data sources.
+const (
+	// The node's total magnitude: its self-magnitude plus the total magnitudes
+	// of all its children.
+	totalMagnitudeKey = keys.WeightedTreeTotalMagnitude
+	// The number of samples aggregated into the node and its descendants.
+	sampleCountKey = keys.WeightedTreeSampleCount
+	// The node's total magnitude as a percentage, from 0 to 100, of that of
+	// the root of the walk producing it.
+	percentOfRootKey = keys.WeightedTreePercentOfRoot
+	// The paths, formatted like '/1/2', of the merge prefix leaves
+	// contributing to a merged node.
+	originsKey = keys.WeightedTreeOrigins
+	// Prefixes, for each merge prefix leaf contributing to a merged node, a
+	// key holding that leaf's contribution to the node's total magnitude.  For
+	// example, 'weighted_tree_origin_weight:/1/2'.
+	originWeightKeyPrefix = "weighted_tree_origin_weight:"
+	// The number of pruned nodes a summary node (see SummarizePruned) stands
+	// in for.
+	prunedNodesKey = keys.WeightedTreePrunedNodes
+)
+
+// TotalMagnitude returns a PropertyUpdate annotating a node with its total
+// magnitude.
+func TotalMagnitude(totalMagnitude float64) util.PropertyUpdate {
+	return util.DoubleProperty(totalMagnitudeKey, totalMagnitude)
+}
+
+// SampleCount returns a PropertyUpdate annotating a node with the number of
+// samples aggregated into it and its descendants.
+func SampleCount(samples int64) util.PropertyUpdate {
+	return util.IntegerProperty(sampleCountKey, samples)
+}
+
+// PercentOfRoot returns a PropertyUpdate annotating a node with its total
+// magnitude as a percentage of the root's.
+func PercentOfRoot(percent float64) util.PropertyUpdate {
+	return util.DoubleProperty(percentOfRootKey, percent)
+}
+
+// OriginWeights returns a PropertyUpdate annotating a merged node with the
+// contribution of each of the provided Origins to its total magnitude.
+func OriginWeights(origins ...*Origin) util.PropertyUpdate {
+	paths := make([]string, len(origins))
+	updates := make([]util.PropertyUpdate, len(origins)+1)
+	for idx, origin := range origins {
+		paths[idx] = formatPath(origin.Path)
+		updates[idx] = util.DoubleProperty(originWeightKeyPrefix+paths[idx], origin.Weight)
+	}
+	updates[len(origins)] = util.StringsProperty(originsKey, paths...)
+	return util.Chain(updates...)
+}
+
+// PrunedNodes returns a PropertyUpdate annotating a summary node as standing
+// in for the specified number of pruned nodes.
+func PrunedNodes(nodes int64) util.PropertyUpdate {
+	return util.IntegerProperty(prunedNodesKey, nodes)
+}
+
+// NodePropertiesFn returns the properties, beyond the standard annotations, of
+// the node built from the provided SubtreeNode.
+type NodePropertiesFn func(stn *SubtreeNode) ([]util.PropertyUpdate, error)
+
+// nodeParent is satisfied by both Tree and Node.
+type nodeParent interface {
+	Node(selfMagnitude float64, properties ...util.PropertyUpdate) *Node
+}
+
+// BuildResponse emits the receiver's descendants into the provided Tree, with
+// the receiver's children as the tree's roots.  The receiver must have been
+// returned from a walk with a WeightFn (see WithWeight), whose weights are
+// used as total magnitudes.  Each node is annotated with its total magnitude
+// and percentage of the receiver's total magnitude, and, if the walk merged
+// prefixes (see MergePrefix), with its OriginWeights; its self-magnitude is
+// its total magnitude less its children's, so it absorbs the weight of any
+// children not visited by the walk.  If propertiesFn is non-nil, it supplies
+// each node's further properties.  Summary SubtreeNodes (see SummarizePruned)
+// are instead annotated with their PrunedNodes, and are not passed to
+// propertiesFn.
+func (stn *SubtreeNode) BuildResponse(tree *Tree, propertiesFn NodePropertiesFn) error {
+	rootWeight := stn.Weight
+	var visit func(parent nodeParent, stn *SubtreeNode) error
+	visit = func(parent nodeParent, stn *SubtreeNode) error {
+		selfMagnitude := stn.Weight
+		for _, child := range stn.Children {
+			selfMagnitude -= child.Weight
+		}
+		if selfMagnitude < 0 {
+			return fmt.Errorf("weighted tree node %v weighs less than its children", stn.Path)
+		}
+		var percent float64
+		if rootWeight != 0 {
+			percent = stn.Weight * 100 / rootWeight
+		}
+		var properties []util.PropertyUpdate
+		if stn.Pruned != nil {
+			properties = []util.PropertyUpdate{PrunedNodes(int64(stn.Pruned.Nodes))}
+		} else if propertiesFn != nil {
+			var err error
+			if properties, err = propertiesFn(stn); err != nil {
+				return err
+			}
+		}
+		node := parent.Node(selfMagnitude,
+			TotalMagnitude(stn.Weight),
+			PercentOfRoot(percent),
+			util.If(len(stn.Origins) > 0, OriginWeights(stn.Origins...)),
+		).With(properties...)
+		for _, child := range stn.Children {
+			if err := visit(node, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, child := range stn.Children {
+		if err := visit(tree, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
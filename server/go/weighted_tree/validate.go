@@ -0,0 +1,74 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package weightedtree
+
+import (
+	"fmt"
+
+	"github.com/google/traceviz/server/go/magnitude"
+	"github.com/google/traceviz/server/go/payload"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Detect reports whether the provided Datum, whose string indices refer to the
+// provided string table, appears to be the root of a weighted tree: that is,
+// whether it defines tree render settings.
+func Detect(root *util.Datum, st []string) bool {
+	_, ok := root.Property(st, frameHeightPxKey)
+	return ok
+}
+
+// Validate checks that the weighted tree rooted at the provided Datum, whose
+// string indices refer to the provided string table, conforms to the weighted
+// tree data model: that its render settings are defined, that its nodes and
+// payloads are legally nested, and that each node has a nonnegative
+// self-magnitude.
+func Validate(root *util.Datum, st []string) error {
+	if _, ok := root.PropertyNumber(st, frameHeightPxKey); !ok {
+		return fmt.Errorf("weighted tree has no render settings")
+	}
+	if dir, ok := root.PropertyString(st, directionKey); ok && dir != topDown && dir != bottomUp {
+		return fmt.Errorf("weighted tree has unsupported direction '%s'", dir)
+	}
+	for idx, child := range root.Children {
+		if err := validateNode(child, st, "weighted tree", idx, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateNode checks the provided Datum, the idx'th child of the described
+// parent, which must be a node or, if the parent is itself a node, a payload.
+// path holds the child indices of the parent node from the tree root.
+func validateNode(d *util.Datum, st []string, parent string, idx int, path []int) error {
+	if _, ok := payload.TypeOf(d, st); ok && path != nil {
+		return nil
+	}
+	self, ok := magnitude.SelfMagnitudeOf(d, st)
+	if !ok {
+		return fmt.Errorf("%s child %d has no self-magnitude", parent, idx)
+	}
+	path = append(path[:len(path):len(path)], idx)
+	desc := fmt.Sprintf("weighted tree node %v", path)
+	if self < 0 {
+		return fmt.Errorf("%s has negative self-magnitude %f", desc, self)
+	}
+	for childIdx, child := range d.Children {
+		if err := validateNode(child, st, desc, childIdx, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -54,6 +54,19 @@
 //   - ElideTreeNodes(func(TreeNode) bool): Traverse normally, but only return
 //     SubtreeNodes for TreeNodes for which the specified filter function
 //     returns true.
+//   - WithContext(ctx): abandon the traversal, returning ctx's error, once ctx
+//     is done.
+//   - WithWeight(fn): compute each candidate node's aggregate weight once,
+//     storing it on the node's Comparable and SubtreeNode.
+//   - MinWeight(w): do not traverse nodes weighing less than w.
+//   - SummarizePruned(): append a synthetic summary SubtreeNode to any
+//     SubtreeNode some of whose children were pruned from the walk.
+//   - AllowForest(): treat the provided root as a synthetic forest root,
+//     walking its children as independent trees.
+//
+// WalkPage(TreeNode, Compare, pageToken, WalkOptions...) performs the same
+// traversal in pages of MaxNodes nodes, returning with each page a token from
+// which the next page resumes.
 //
 // Subtrees returned from Walk() may be rapidly constructed into the TraceViz
 // data format with SubtreeNode.BuildResponse().
@@ -61,8 +74,11 @@ package weightedtree
 
 import (
 	"container/heap"
+	"context"
 	"fmt"
 	"slices"
+
+	"github.com/google/traceviz/server/go/util"
 )
 
 // ScopeID is the unique ID of a scope.  The same scope may appear at multiple
@@ -94,6 +110,27 @@ type Comparable struct {
 	// The set of TreeNodes of the associated SubtreeNode, if one is generated in
 	// the traversal.
 	TreeNodes []TreeNode
+	// The aggregate weight of TreeNodes, as computed by the traversal's WeightFn.
+	// Zero if the traversal has no WeightFn.
+	Weight float64
+}
+
+// WeightFn computes the aggregate weight of a Comparable, whose Weight field is
+// not yet set.  It may return an error if the weight cannot be computed.
+type WeightFn func(Comparable) (float64, error)
+
+// CompareByWeight is a CompareFn ordering by Weight, as computed by the
+// traversal's WeightFn.  Ties are broken by path, so that traversals are
+// deterministic.
+func CompareByWeight(a, b Comparable) (int, error) {
+	switch {
+	case a.Weight < b.Weight:
+		return -1, nil
+	case a.Weight > b.Weight:
+		return 1, nil
+	}
+	// Lower paths are 'heavier', so are visited first.
+	return slices.Compare(b.Path, a.Path), nil
 }
 
 // CompareFn compares two items, returning <0, 0, or >0 if the first compares
@@ -113,6 +150,8 @@ func walkOpts(opts ...WalkOption) (*walkOptions, error) {
 		maxDepth:    unspecifiedOption,
 		maxNodes:    unspecifiedOption,
 		elidePrefix: false,
+		ctx:         context.Background(),
+		summaries:   map[*SubtreeNode]*SubtreeNode{},
 	}
 	for _, opt := range opts {
 		if err := opt(ret); err != nil {
@@ -184,6 +223,82 @@ func ElidePrefix() WalkOption {
 	}
 }
 
+// WithContext specifies a Context whose cancellation abandons the walk, which
+// then returns the Context's error.  This allows walks over large trees to
+// stop promptly when, e.g., their requesting client disconnects.  Defaults to
+// a Context that is never done.
+func WithContext(ctx context.Context) WalkOption {
+	return func(wo *walkOptions) error {
+		wo.ctx = ctx
+		return nil
+	}
+}
+
+// WithWeight specifies a WeightFn computing the aggregate weight of each node
+// considered by the walk.  Each weight is computed only once, and is available
+// to the walk's CompareFn, to MinWeight, and in the returned SubtreeNodes,
+// so that expensive aggregations need not be repeated.  Defaults to no
+// WeightFn.
+func WithWeight(weightFn WeightFn) WalkOption {
+	return func(wo *walkOptions) error {
+		wo.weightFn = weightFn
+		return nil
+	}
+}
+
+// MinWeight specifies the minimum weight, as computed by the WeightFn provided
+// via WithWeight, of traversed nodes: nodes weighing less, and their
+// descendants, are not traversed.  The root is always traversed.  Requires
+// WithWeight.  Defaults to no minimum.
+func MinWeight(minWeight float64) WalkOption {
+	return func(wo *walkOptions) error {
+		wo.minWeight = &minWeight
+		return nil
+	}
+}
+
+// SummarizePruned specifies that any returned SubtreeNode some of whose
+// children were pruned from the walk, by MaxNodes, MaxDepth, or MinWeight,
+// should have as its last child a synthetic summary SubtreeNode whose Pruned
+// field describes the pruned children.  This lets frontends show that more
+// data exists, and offer to expand it.  Defaults to no summary nodes.
+func SummarizePruned() WalkOption {
+	return func(wo *walkOptions) error {
+		wo.summarizePruned = true
+		return nil
+	}
+}
+
+// AllowForest specifies that the root TreeNode provided to the walk is a
+// synthetic root grouping a forest of independent trees (e.g., one per
+// top-level process), which are its children.  Each tree's root is then
+// traversed like a top-level node of a merged walk (see MergePrefix): the
+// forest is traversed heaviest-first as a whole; the provided root is not
+// counted against MaxNodes or MaxDepth; and the returned subtree's root is a
+// synthetic, empty-path SubtreeNode whose TreeNodes are the visited trees'
+// roots.  Cannot be combined with MergePrefix.  By default, the provided root
+// is itself the walk's single root.
+func AllowForest() WalkOption {
+	return func(wo *walkOptions) error {
+		wo.allowForest = true
+		return nil
+	}
+}
+
+// DeterministicOrder specifies that the walk's output should not depend on
+// map iteration order: nodes which the CompareFn deems equal are visited, and
+// so appear among their siblings, in increasing path order, and children are
+// requested from TreeNodes in increasing scope ID order.  This guarantees
+// reproducible responses, e.g. for golden tests and caching, even when the
+// CompareFn doesn't itself break ties.  By default, the visiting order of
+// equal nodes is unspecified.
+func DeterministicOrder() WalkOption {
+	return func(wo *walkOptions) error {
+		wo.deterministic = true
+		return nil
+	}
+}
+
 // TreeNodeFilterFunc defines a callback implementing a TreeNode filter, and
 // returning true for nodes that satisfy that filter and should be omitted
 // during traversal.
@@ -234,6 +349,38 @@ type SubtreeNode struct {
 	TreeNodes []TreeNode
 	// The children of this SubtreeNode.
 	Children []*SubtreeNode
+	// The aggregate weight of TreeNodes, as computed by the traversal's
+	// WeightFn.  Zero if the traversal has no WeightFn.
+	Weight float64
+	// Previous is true if this SubtreeNode was returned by an earlier page of
+	// a paginated walk (see WalkPage), and is included only as an ancestor of
+	// this page's SubtreeNodes.
+	Previous bool
+	// The contribution of each merge prefix leaf (see MergePrefix) to Weight,
+	// sorted by path.  Only set for traversals with both a merge prefix tree
+	// and a WeightFn.
+	Origins []*Origin
+	// Pruned is non-nil if this is a synthetic summary SubtreeNode (see
+	// SummarizePruned) standing in for children of its Parent that were pruned
+	// from the walk.  Summary SubtreeNodes have their Parent's Path, no
+	// TreeNodes, and the pruned children's total Weight.
+	Pruned *PrunedSummary
+}
+
+// PrunedSummary describes the children of a SubtreeNode pruned from a walk.
+type PrunedSummary struct {
+	// The number of pruned children.  Their descendants are not counted.
+	Nodes int
+}
+
+// Origin describes the contribution of a single merge prefix leaf to a merged
+// SubtreeNode.
+type Origin struct {
+	// The merge prefix leaf's path.
+	Path []ScopeID
+	// The aggregate weight, as computed by the traversal's WeightFn, of the
+	// SubtreeNode's TreeNodes descending from the merge prefix leaf.
+	Weight float64
 }
 
 // A node in the cumulative tree of prefixes defined for a given tree
@@ -281,18 +428,46 @@ func (ptn *prefixTreeNode) descend(path ...ScopeID) (*prefixTreeNode, int) {
 	return nil, 0
 }
 
+// leafPrefix returns the prefix of the provided path ending at a leaf of the
+// receiver, or nil if there is no such prefix.
+func (ptn *prefixTreeNode) leafPrefix(path []ScopeID) []ScopeID {
+	cursor := ptn
+	for depth, scopeID := range path {
+		child, ok := cursor.childrenByScopeID[scopeID]
+		if !ok {
+			return nil
+		}
+		if len(child.childrenByScopeID) == 0 {
+			return path[:depth+1]
+		}
+		cursor = child
+	}
+	return nil
+}
+
 func (ptn *prefixTreeNode) onPrefix() bool {
 	return ptn != nil && len(ptn.childrenByScopeID) > 0
 }
 
-func (ptn *prefixTreeNode) children() []ScopeID {
+// children returns the scope IDs of the receiver's children, in increasing
+// order if sorted is true.
+func (ptn *prefixTreeNode) children(sorted bool) []ScopeID {
 	if ptn == nil {
 		return nil
 	}
-	ret := make([]ScopeID, 0, len(ptn.childrenByScopeID))
-	for scopeID := range ptn.childrenByScopeID {
+	return scopeIDs(ptn.childrenByScopeID, sorted)
+}
+
+// scopeIDs returns the keys of the provided map, in increasing order if sorted
+// is true.
+func scopeIDs[V any](m map[ScopeID]V, sorted bool) []ScopeID {
+	ret := make([]ScopeID, 0, len(m))
+	for scopeID := range m {
 		ret = append(ret, scopeID)
 	}
+	if sorted {
+		slices.Sort(ret)
+	}
 	return ret
 }
 
@@ -308,6 +483,98 @@ type walkOptions struct {
 	elidePrefix        bool               // default false.
 	filterTreeNodeFunc TreeNodeFilterFunc // default nil.
 	elideTreeNodeFunc  TreeNodeFilterFunc // default nil.
+	ctx                context.Context    // default context.Background().
+	weightFn           WeightFn           // default nil.
+	minWeight          *float64           // If nil, no min weight.
+	summarizePruned    bool               // default false.
+	allowForest        bool               // default false.
+	deterministic      bool               // default false.
+	// Summary SubtreeNodes by parent, and the order in which their parents
+	// were first pruned from.  Only populated if summarizePruned is true.
+	summaries    map[*SubtreeNode]*SubtreeNode
+	summaryOrder []*SubtreeNode
+}
+
+// prune records a pruned child of the provided parent, of the provided weight,
+// in that parent's summary SubtreeNode, if the receiver summarizes pruned
+// nodes.
+func (wo *walkOptions) prune(parent *SubtreeNode, weight float64) {
+	if !wo.summarizePruned || parent == nil {
+		return
+	}
+	summary, ok := wo.summaries[parent]
+	if !ok {
+		summary = &SubtreeNode{
+			Parent: parent,
+			Path:   parent.Path,
+			Pruned: &PrunedSummary{},
+		}
+		wo.summaries[parent] = summary
+		wo.summaryOrder = append(wo.summaryOrder, parent)
+	}
+	summary.Pruned.Nodes++
+	summary.Weight += weight
+}
+
+// appendSummaries appends each summary SubtreeNode recorded by prune as the
+// last child of its parent.
+func (wo *walkOptions) appendSummaries() {
+	for _, parent := range wo.summaryOrder {
+		parent.Children = append(parent.Children, wo.summaries[parent])
+	}
+}
+
+// weigh computes and sets the Weight of the provided walkHeapEntry, if the
+// receiver has a WeightFn, returning false if the entry weighs less than the
+// receiver's minimum.
+func (wo *walkOptions) weigh(whe *walkHeapEntry) (bool, error) {
+	if wo.weightFn == nil {
+		return true, nil
+	}
+	weight, err := wo.weightFn(whe.Comparable)
+	if err != nil {
+		return false, err
+	}
+	whe.Weight = weight
+	return wo.minWeight == nil || weight >= *wo.minWeight, nil
+}
+
+// origins returns the contribution of each merge prefix leaf to the provided
+// Comparable, whose Weight must already be set, or nil if the receiver has no
+// merge prefix tree or no WeightFn.
+func (wo *walkOptions) origins(c Comparable) ([]*Origin, error) {
+	if wo.mergePrefixTree == nil || wo.weightFn == nil {
+		return nil, nil
+	}
+	var ret []*Origin
+	treeNodesByOrigin := map[string][]TreeNode{}
+	for _, tn := range c.TreeNodes {
+		originPath := wo.mergePrefixTree.leafPrefix(tn.Path())
+		key := formatPath(originPath)
+		if _, ok := treeNodesByOrigin[key]; !ok {
+			ret = append(ret, &Origin{Path: originPath})
+		}
+		treeNodesByOrigin[key] = append(treeNodesByOrigin[key], tn)
+	}
+	if len(ret) == 1 {
+		// A single origin contributes all the weight.
+		ret[0].Weight = c.Weight
+		return ret, nil
+	}
+	for _, origin := range ret {
+		weight, err := wo.weightFn(Comparable{
+			Path:      c.Path,
+			TreeNodes: treeNodesByOrigin[formatPath(origin.Path)],
+		})
+		if err != nil {
+			return nil, err
+		}
+		origin.Weight = weight
+	}
+	slices.SortFunc(ret, func(a, b *Origin) int {
+		return slices.Compare(a.Path, b.Path)
+	})
+	return ret, nil
 }
 
 // An entry in the heaviest-first heap used for tree traversal.
@@ -342,6 +609,10 @@ func (wh *walkHeap) Less(i, j int) bool {
 	if err != nil {
 		panic("failed to compare walkHeap entries: " + err.Error())
 	}
+	if cmp == 0 && wh.wo.deterministic {
+		// Lower paths are visited first.
+		return slices.Compare(ei.Path, ej.Path) < 0
+	}
 	return cmp > 0
 }
 
@@ -379,9 +650,21 @@ func (whe *walkHeapEntry) children(wo *walkOptions) (map[ScopeID][]TreeNode, err
 			whe.childrenByScopeID[scopeID] = append(whe.childrenByScopeID[scopeID], childTN)
 		}
 	}
+	if wo.deterministic {
+		for _, tns := range whe.childrenByScopeID {
+			sortByPath(tns)
+		}
+	}
 	return whe.childrenByScopeID, nil
 }
 
+// sortByPath sorts the provided TreeNodes in increasing path order.
+func sortByPath(tns []TreeNode) {
+	slices.SortFunc(tns, func(a, b TreeNode) int {
+		return slices.Compare(a.Path(), b.Path())
+	})
+}
+
 // treeNodeChildren creates and returns a set of child TreeNodes from the
 // provided TreeNode, with filtering and elision from the provided walkOptions
 // applied.  It is not idempotent; multiple calls will return new child
@@ -389,7 +672,7 @@ func (whe *walkHeapEntry) children(wo *walkOptions) (map[ScopeID][]TreeNode, err
 func treeNodeChildren(ptn *prefixTreeNode, tn TreeNode, wo *walkOptions) ([]TreeNode, error) {
 	var children []TreeNode
 	if ptn.onPrefix() {
-		for _, childScopeID := range ptn.children() {
+		for _, childScopeID := range ptn.children(wo.deterministic) {
 			child, err := tn.Children(childScopeID)
 			if err != nil {
 				return nil, err
@@ -465,6 +748,7 @@ func (whe *walkHeapEntry) visit(wo *walkOptions) (subtreeNode *SubtreeNode, chil
 		depthBelowPrefix++
 	}
 	if wo.maxDepth != unspecifiedOption && depthBelowPrefix > wo.maxDepth {
+		wo.prune(whe.parent, whe.Weight)
 		return nil, nil, nil
 	}
 	// If this node isn't a prefix, or prefix nodes aren't elided, include it in
@@ -475,6 +759,10 @@ func (whe *walkHeapEntry) visit(wo *walkOptions) (subtreeNode *SubtreeNode, chil
 			Path:      whe.Path,
 			TreeNodes: whe.TreeNodes,
 			Prefix:    whe.prefixTreeNode != nil && whe.prefixTreeNode.onPrefix(),
+			Weight:    whe.Weight,
+		}
+		if subtreeNode.Origins, err = wo.origins(whe.Comparable); err != nil {
+			return nil, nil, err
 		}
 		if whe.parent != nil {
 			whe.parent.Children = append(whe.parent.Children, subtreeNode)
@@ -492,8 +780,17 @@ func (whe *walkHeapEntry) visit(wo *walkOptions) (subtreeNode *SubtreeNode, chil
 		return nil, nil, err
 	}
 	childEntries = make([]*walkHeapEntry, 0, len(children))
-	for scopeID, child := range children {
-		childEntries = append(childEntries, newWalkHeapEntry(whe.prefixTreeNode, scopeID, child, subtreeNode))
+	for _, scopeID := range scopeIDs(children, wo.deterministic) {
+		childEntry := newWalkHeapEntry(whe.prefixTreeNode, scopeID, children[scopeID], subtreeNode)
+		keep, err := wo.weigh(childEntry)
+		if err != nil {
+			return nil, nil, err
+		}
+		if keep {
+			childEntries = append(childEntries, childEntry)
+		} else {
+			wo.prune(subtreeNode, childEntry.Weight)
+		}
 	}
 	return subtreeNode, childEntries, nil
 }
@@ -528,11 +825,46 @@ func (whe *walkHeapEntry) visit(wo *walkOptions) (subtreeNode *SubtreeNode, chil
 //     will be unioned into the returned subtree's root, and their descendants
 //     will be merged by common path suffix from the merge prefix tree.
 //     Specifying more than one MergePrefix may result in returned SubtreeNodes
-//     with more than one TreeNode.
+//     with more than one TreeNode.  If a WeightFn is also specified, each
+//     returned SubtreeNode's Origins attribute its weight to the merge prefix
+//     leaves its TreeNodes descend from.
+//   - WithWeight specifies a WeightFn computing each traversal candidate's
+//     weight exactly once.  Weights are available to the CompareFn (e.g.,
+//     CompareByWeight) and are stored on the returned SubtreeNodes.
+//   - MinWeight specifies the minimum weight of traversed nodes.
+//   - SummarizePruned specifies that SubtreeNodes with children pruned by
+//     MaxNodes, MaxDepth, or MinWeight get a synthetic summary child.
+//   - AllowForest specifies that the root's children are the roots of a
+//     forest of independent trees, traversed heaviest-first as a whole.
+//   - DeterministicOrder specifies that nodes the CompareFn deems equal are
+//     visited in increasing path order, so that the walk is reproducible.
+//
+// The provided root must have an empty path.
 func Walk(root TreeNode, compare CompareFn, opts ...WalkOption) (*SubtreeNode, error) {
+	ret, _, err := walk(root, compare, "", opts...)
+	return ret, err
+}
+
+// walk implements Walk and WalkPage.  If pageToken is nonempty, the traversal
+// resumes after the node it names.
+func walk(root TreeNode, compare CompareFn, pageToken string, opts ...WalkOption) (*SubtreeNode, string, error) {
 	wo, err := walkOpts(opts...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	if wo.minWeight != nil && wo.weightFn == nil {
+		return nil, "", fmt.Errorf("MinWeight requires WithWeight")
+	}
+	if wo.allowForest && wo.mergePrefixTree != nil {
+		return nil, "", fmt.Errorf("AllowForest cannot be combined with MergePrefix")
+	}
+	if len(root.Path()) != 0 {
+		return nil, "", fmt.Errorf("Walk() root has non-empty path %v", root.Path())
+	}
+	resuming := pageToken != ""
+	resumeAfter, err := parsePageToken(pageToken)
+	if err != nil {
+		return nil, "", err
 	}
 	mwh := &walkHeap{
 		wo:      wo,
@@ -541,10 +873,43 @@ func Walk(root TreeNode, compare CompareFn, opts ...WalkOption) (*SubtreeNode, e
 	heap.Init(mwh)
 	// The root of the returned subtree.
 	var subtreeRoot *SubtreeNode
-	if wo.mergePrefixTree == nil {
+	// Merged or forest root children pruned by MinWeight, which are
+	// summarized once the synthetic root exists.
+	var pruned []*walkHeapEntry
+	// Under a merged or forest root, root-level heap entries have no parents.
+	syntheticRoot := wo.mergePrefixTree != nil || wo.allowForest
+	if wo.allowForest {
+		// The provided root's children are the roots of the forest's trees.
+		forestRoots, err := treeNodeChildren(wo.pathPrefixTree, root, wo)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, tn := range forestRoots {
+			path := tn.Path()
+			entry := newWalkHeapEntry(wo.pathPrefixTree, path[len(path)-1], []TreeNode{tn}, nil)
+			keep, err := wo.weigh(entry)
+			if err != nil {
+				return nil, "", err
+			}
+			if keep {
+				heap.Push(mwh, entry)
+			} else {
+				pruned = append(pruned, entry)
+			}
+		}
+		subtreeRoot = &SubtreeNode{
+			Path:     []ScopeID{},
+			Prefix:   wo.pathPrefixTree.onPrefix(),
+			Previous: resuming,
+		}
+	} else if wo.mergePrefixTree == nil {
 		// If there is no merge prefix tree, the returned subtree root corresponds
 		// simply to the provided root TreeNode.
-		heap.Push(mwh, newWalkHeapRoot(wo.pathPrefixTree, []TreeNode{root}))
+		rootEntry := newWalkHeapRoot(wo.pathPrefixTree, []TreeNode{root})
+		if _, err := wo.weigh(rootEntry); err != nil {
+			return nil, "", err
+		}
+		heap.Push(mwh, rootEntry)
 	} else {
 		// If, however, there is a merge prefix tree, the returned subtree root
 		// corresponds to the union of all TreeNodes at the merge prefix tree's
@@ -582,8 +947,20 @@ func Walk(root TreeNode, compare CompareFn, opts ...WalkOption) (*SubtreeNode, e
 		// root.)
 		visit(wo.mergePrefixTree, root, 0)
 		// ... then push the merge prefix leaf TreeNodes onto the heap.
-		for scopeID, initialNodes := range rootTreeNodesByScope {
-			heap.Push(mwh, newWalkHeapEntry(wo.pathPrefixTree, scopeID, initialNodes, nil))
+		for _, scopeID := range scopeIDs(rootTreeNodesByScope, wo.deterministic) {
+			if wo.deterministic {
+				sortByPath(rootTreeNodesByScope[scopeID])
+			}
+			entry := newWalkHeapEntry(wo.pathPrefixTree, scopeID, rootTreeNodesByScope[scopeID], nil)
+			keep, err := wo.weigh(entry)
+			if err != nil {
+				return nil, "", err
+			}
+			if keep {
+				heap.Push(mwh, entry)
+			} else {
+				pruned = append(pruned, entry)
+			}
 		}
 		// Finally, we create an empty subtree root.  Any SubtreeRoots generated by
 		// the heaviest-first traversal that do not have a parent will be placed
@@ -592,43 +969,90 @@ func Walk(root TreeNode, compare CompareFn, opts ...WalkOption) (*SubtreeNode, e
 			Parent: nil,
 			Path:   []ScopeID{},
 			// If a path prefix was specified, the root is on it.
-			Prefix: wo.pathPrefixTree != nil,
+			Prefix:   wo.pathPrefixTree != nil,
+			Previous: resuming,
 		}
 	}
 	// Until we've added the maximum requested number of non-prefix subtree
 	// nodes, or exhausted all candidate nodes, pop the next entry from the stack
-	// and visit it.
+	// and visit it.  When resuming a paginated walk, nodes up to and including
+	// the one named by the page token are marked Previous, and aren't counted.
 	addedNodes := 0
-	for mwh.Len() > 0 && (wo.maxNodes == unspecifiedOption || addedNodes < wo.maxNodes) {
+	resumed := !resuming
+	var lastPath []ScopeID
+	for iteration := 0; mwh.Len() > 0 && (wo.maxNodes == unspecifiedOption || addedNodes < wo.maxNodes); iteration++ {
+		if err := util.CheckCtx(wo.ctx, iteration); err != nil {
+			return nil, "", err
+		}
 		entry := heap.Pop(mwh).(*walkHeapEntry)
 		// Visit the entry, getting its SubtreeNode and all its child heap entries.
 		stn, childEntries, err := entry.visit(wo)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		if stn != nil {
 			if entry.parent == nil {
-				if wo.mergePrefixTree != nil {
-					// If the merge prefix tree exists, and this entry has no parent, it
-					// should be placed under subtreeRoot.
+				if syntheticRoot {
+					// If the merge prefix tree exists, or this is a forest, and this
+					// entry has no parent, it should be placed under subtreeRoot.
 					subtreeRoot.Children = append(subtreeRoot.Children, stn)
 					subtreeRoot.TreeNodes = append(subtreeRoot.TreeNodes, stn.TreeNodes...)
 				} else {
 					// Otherwise, we've found multiple roots: an error.
 					if subtreeRoot != nil {
-						return nil, fmt.Errorf("Walk() found multiple root nodes (%v and %v)", subtreeRoot, stn)
+						return nil, "", fmt.Errorf("Walk() found multiple root nodes (%v and %v)", subtreeRoot, stn)
 					}
 					subtreeRoot = stn
 				}
 			}
-			if !stn.Prefix {
+			if !resumed {
+				stn.Previous = true
+			} else if !stn.Prefix {
 				addedNodes++
+				lastPath = entry.Path
 			}
 		}
+		if !resumed && slices.Equal(entry.Path, resumeAfter) {
+			resumed = true
+		}
 		// Push each child heap entry onto the heap.
 		for _, childEntry := range childEntries {
 			heap.Push(mwh, childEntry)
 		}
 	}
-	return subtreeRoot, nil
+	if !resumed {
+		return nil, "", fmt.Errorf("page token '%s' names no node in the traversal", pageToken)
+	}
+	if resuming {
+		prunePrevious(subtreeRoot)
+	}
+	if wo.summarizePruned {
+		// Any entries left on the heap were pruned by MaxNodes.
+		for _, entry := range append(pruned, mwh.entries...) {
+			parent := entry.parent
+			if parent == nil {
+				// Only merged or forest roots' children lack parents.
+				parent = subtreeRoot
+			}
+			wo.prune(parent, entry.Weight)
+		}
+	}
+	if syntheticRoot && wo.weightFn != nil {
+		// The merged or forest root was never a heap entry, so must be weighed
+		// here.
+		rootEntry := newWalkHeapRoot(nil, subtreeRoot.TreeNodes)
+		if _, err := wo.weigh(rootEntry); err != nil {
+			return nil, "", err
+		}
+		subtreeRoot.Weight = rootEntry.Weight
+		if subtreeRoot.Origins, err = wo.origins(rootEntry.Comparable); err != nil {
+			return nil, "", err
+		}
+	}
+	wo.appendSummaries()
+	nextPageToken := ""
+	if mwh.Len() > 0 && wo.maxNodes != unspecifiedOption && addedNodes >= wo.maxNodes {
+		nextPageToken = newPageToken(lastPath)
+	}
+	return subtreeRoot, nextPageToken, nil
 }
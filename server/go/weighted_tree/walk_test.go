@@ -14,6 +14,7 @@
 package weightedtree
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"sort"
@@ -214,6 +215,9 @@ func prettyPrintSubtreeNode(t *testing.T, stn *SubtreeNode, indent string) strin
 	if stn.Prefix {
 		prefix = " (prefix)"
 	}
+	if stn.Previous {
+		prefix += " (previous)"
+	}
 	ret := []string{
 		indent + pathAsString(stn.Path) +
 			fmt.Sprintf(" (%s)%s:", strings.Join(weights, ", "), prefix),
@@ -300,6 +304,8 @@ var tree3 = tree(
 )
 
 func TestWalk(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
 	for _, test := range []struct {
 		description     string
 		tree            TreeNode
@@ -330,6 +336,44 @@ func TestWalk(t *testing.T) {
         [/1/2/3]
     /1/3 (1e, 1s):
       [/1/3]`,
+	}, {
+		description: "tree1 as a forest, max nodes 3",
+		tree:        tree1,
+		compare:     compareBy(eventsKey, decreasing),
+		opts:        []WalkOption{AllowForest(), MaxNodes(3)},
+		// The forest's roots are not merged, and /2/2 outweighs /1.
+		wantPrettyPrint: `
+/ (210ns, 17e, 8s):
+  [/ < /1, / < /2]
+  /2 (100ns, 11e, 3s):
+    [/2]
+    /2/2 (100ns, 6e, 3s):
+      [/2/2]
+  /1 (110ns, 6e, 5s):
+    [/1]`,
+	}, {
+		description: "tree1 as a forest, max depth 1",
+		tree:        tree1,
+		compare:     compareBy(eventsKey, decreasing),
+		opts:        []WalkOption{AllowForest(), MaxDepth(1)},
+		wantPrettyPrint: `
+/ (210ns, 17e, 8s):
+  [/ < /1, / < /2]
+  /2 (100ns, 11e, 3s):
+    [/2]
+  /1 (110ns, 6e, 5s):
+    [/1]`,
+	}, {
+		description: "forest with merge prefix",
+		tree:        tree1,
+		compare:     compareBy(eventsKey, decreasing),
+		opts:        []WalkOption{AllowForest(), MergePrefix(1)},
+		wantErr:     true,
+	}, {
+		description: "non-root tree node",
+		tree:        tree1.(*testTreeNode).children[1],
+		compare:     compareBy(eventsKey, decreasing),
+		wantErr:     true,
 	}, {
 		description: "whole tree, ordered by spans increasing",
 		tree:        tree1,
@@ -623,6 +667,12 @@ func TestWalk(t *testing.T) {
             [/1/1/1/2/3]
             /1/1/1/2/3/4 (40ns):
               [/1/1/1/2/3/4]`,
+	}, {
+		description: "canceled context",
+		tree:        tree1,
+		compare:     compareBy(eventsKey, decreasing),
+		opts:        []WalkOption{WithContext(canceledCtx)},
+		wantErr:     true,
 	}} {
 		t.Run(test.description, func(t *testing.T) {
 			gotSubtree, err := Walk(test.tree, test.compare, test.opts...)
@@ -639,3 +689,322 @@ func TestWalk(t *testing.T) {
 		})
 	}
 }
+
+func TestWalkPage(t *testing.T) {
+	var gotPages []string
+	pageToken := ""
+	for {
+		gotSubtree, nextPageToken, err := WalkPage(tree1, compareBy(eventsKey, decreasing), pageToken, MaxNodes(3))
+		if err != nil {
+			t.Fatalf("WalkPage yielded unexpected error %s", err)
+		}
+		gotPages = append(gotPages, "\n"+prettyPrintSubtreeNode(t, gotSubtree, ""))
+		if nextPageToken == "" {
+			break
+		}
+		if len(gotPages) > 10 {
+			t.Fatalf("WalkPage didn't terminate")
+		}
+		pageToken = nextPageToken
+	}
+	wantPages := []string{`
+/ (210ns, 17e, 8s):
+  [/]
+  /2 (100ns, 11e, 3s):
+    [/2]
+    /2/2 (100ns, 6e, 3s):
+      [/2/2]`, `
+/ (210ns, 17e, 8s) (previous):
+  [/]
+  /2 (100ns, 11e, 3s) (previous):
+    [/2]
+    /2/2 (100ns, 6e, 3s) (previous):
+      [/2/2]
+      /2/2/3 (4e):
+        [/2/2/3]
+      /2/2/1 (50ns, 2e):
+        [/2/2/1]
+  /1 (110ns, 6e, 5s):
+    [/1]`, `
+/ (210ns, 17e, 8s) (previous):
+  [/]
+  /1 (110ns, 6e, 5s) (previous):
+    [/1]
+    /1/2 (10ns, 2e, 4s):
+      [/1/2]
+      /1/2/3 (2e):
+        [/1/2/3]
+    /1/3 (1e, 1s):
+      [/1/3]`}
+	if diff := cmp.Diff(wantPages, gotPages); diff != "" {
+		t.Errorf("got pages\n%s\ndiff (-want +got) %s", strings.Join(gotPages, "\n"), diff)
+	}
+	for _, pageToken := range []string{"1/2", "/1/x", "/9"} {
+		if _, _, err := WalkPage(tree1, compareBy(eventsKey, decreasing), pageToken, MaxNodes(3)); err == nil {
+			t.Errorf("WalkPage with page token '%s' yielded no error, but expected one", pageToken)
+		}
+	}
+}
+
+func TestWalkWeights(t *testing.T) {
+	var weighed []string
+	weighEvents := func(c Comparable) (float64, error) {
+		weighed = append(weighed, pathAsString(c.Path))
+		var ret int64
+		for _, tn := range c.TreeNodes {
+			ret += tn.(*testTreeNode).totalVals[eventsKey]
+		}
+		return float64(ret), nil
+	}
+	for _, test := range []struct {
+		description string
+		opts        []WalkOption
+		wantWeights []string
+		wantWeighed []string
+		wantErr     bool
+	}{{
+		description: "weights",
+		opts:        []WalkOption{WithWeight(weighEvents)},
+		wantWeights: []string{"/=17", "/2=11", "/2/2=6", "/2/2/3=4", "/2/2/1=2", "/1=6", "/1/2=2", "/1/2/3=2", "/1/3=1"},
+		wantWeighed: []string{"/", "/1", "/1/2", "/1/2/3", "/1/3", "/2", "/2/2", "/2/2/1", "/2/2/3"},
+	}, {
+		description: "min weight",
+		opts:        []WalkOption{WithWeight(weighEvents), MinWeight(4)},
+		wantWeights: []string{"/=17", "/2=11", "/2/2=6", "/2/2/3=4", "/1=6"},
+		wantWeighed: []string{"/", "/1", "/1/2", "/1/3", "/2", "/2/2", "/2/2/1", "/2/2/3"},
+	}, {
+		description: "min weight without weight",
+		opts:        []WalkOption{MinWeight(4)},
+		wantErr:     true,
+	}, {
+		description: "weight error",
+		opts: []WalkOption{WithWeight(func(c Comparable) (float64, error) {
+			return 0, fmt.Errorf("oops")
+		})},
+		wantErr: true,
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			weighed = nil
+			gotSubtree, err := Walk(tree1, CompareByWeight, test.opts...)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Walk yielded unexpected error %v", err)
+			}
+			if test.wantErr {
+				return
+			}
+			var gotWeights []string
+			var visit func(stn *SubtreeNode)
+			visit = func(stn *SubtreeNode) {
+				gotWeights = append(gotWeights, fmt.Sprintf("%s=%g", pathAsString(stn.Path), stn.Weight))
+				for _, child := range stn.Children {
+					visit(child)
+				}
+			}
+			visit(gotSubtree)
+			sort.Strings(weighed)
+			if diff := cmp.Diff(test.wantWeighed, weighed); diff != "" {
+				t.Errorf("weighed unexpected nodes: diff (-want +got) %s", diff)
+			}
+			if diff := cmp.Diff(test.wantWeights, gotWeights); diff != "" {
+				t.Errorf("got unexpected weights: diff (-want +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestWalkOrigins(t *testing.T) {
+	weighEvents := func(c Comparable) (float64, error) {
+		var ret int64
+		for _, tn := range c.TreeNodes {
+			ret += tn.(*testTreeNode).totalVals[eventsKey]
+		}
+		return float64(ret), nil
+	}
+	for _, test := range []struct {
+		description string
+		opts        []WalkOption
+		wantOrigins []string
+	}{{
+		description: "no merge prefix",
+		opts:        []WalkOption{WithWeight(weighEvents), MaxNodes(2)},
+		wantOrigins: []string{"/=", "/2="},
+	}, {
+		description: "merged at /1/2 and /2",
+		opts:        []WalkOption{WithWeight(weighEvents), MergePrefix(1, 2), MergePrefix(2)},
+		wantOrigins: []string{
+			"/=/1/2:2,/2:11",
+			"/2=/1/2:2,/2:11",
+			"/2/2=/2:6",
+			"/2/2/3=/2:4",
+			"/2/2/1=/2:2",
+			"/2/3=/1/2:2",
+		},
+	}, {
+		description: "merged without weight",
+		opts:        []WalkOption{MergePrefix(1, 2), MergePrefix(2), MaxNodes(1)},
+		wantOrigins: []string{"/=", "/2="},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			gotSubtree, err := Walk(tree1, CompareByWeight, test.opts...)
+			if err != nil {
+				t.Fatalf("Walk yielded unexpected error %s", err)
+			}
+			var gotOrigins []string
+			var visit func(stn *SubtreeNode)
+			visit = func(stn *SubtreeNode) {
+				origins := make([]string, len(stn.Origins))
+				for idx, origin := range stn.Origins {
+					origins[idx] = fmt.Sprintf("%s:%g", pathAsString(origin.Path), origin.Weight)
+				}
+				gotOrigins = append(gotOrigins, pathAsString(stn.Path)+"="+strings.Join(origins, ","))
+				for _, child := range stn.Children {
+					visit(child)
+				}
+			}
+			visit(gotSubtree)
+			if diff := cmp.Diff(test.wantOrigins, gotOrigins); diff != "" {
+				t.Errorf("got unexpected origins: diff (-want +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestSummarizePruned(t *testing.T) {
+	weighEvents := func(c Comparable) (float64, error) {
+		var ret int64
+		for _, tn := range c.TreeNodes {
+			ret += tn.(*testTreeNode).totalVals[eventsKey]
+		}
+		return float64(ret), nil
+	}
+	for _, test := range []struct {
+		description string
+		opts        []WalkOption
+		wantNodes   []string
+	}{{
+		description: "not summarized",
+		opts:        []WalkOption{WithWeight(weighEvents), MaxNodes(4)},
+		wantNodes:   []string{"/=17", "/2=11", "/2/2=6", "/1=6"},
+	}, {
+		description: "max nodes",
+		opts:        []WalkOption{WithWeight(weighEvents), MaxNodes(4), SummarizePruned()},
+		wantNodes:   []string{"/=17", "/2=11", "/2/2=6", "/2/2 (pruned 2)=6", "/1=6", "/1 (pruned 2)=3"},
+	}, {
+		description: "max depth",
+		opts:        []WalkOption{WithWeight(weighEvents), MaxDepth(2), SummarizePruned()},
+		wantNodes:   []string{"/=17", "/2=11", "/2 (pruned 1)=6", "/1=6", "/1 (pruned 2)=3"},
+	}, {
+		description: "min weight",
+		opts:        []WalkOption{WithWeight(weighEvents), MinWeight(4), SummarizePruned()},
+		wantNodes:   []string{"/=17", "/2=11", "/2/2=6", "/2/2/3=4", "/2/2 (pruned 1)=2", "/1=6", "/1 (pruned 2)=3"},
+	}, {
+		description: "merged, max nodes",
+		opts:        []WalkOption{WithWeight(weighEvents), MergePrefix(1), MergePrefix(2), MaxNodes(1), SummarizePruned()},
+		wantNodes:   []string{"/=11", "/2=11", "/2 (pruned 1)=6", "/ (pruned 1)=6"},
+	}, {
+		description: "no nodes pruned",
+		opts:        []WalkOption{WithWeight(weighEvents), SummarizePruned()},
+		wantNodes:   []string{"/=17", "/2=11", "/2/2=6", "/2/2/3=4", "/2/2/1=2", "/1=6", "/1/2=2", "/1/2/3=2", "/1/3=1"},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			gotSubtree, err := Walk(tree1, CompareByWeight, test.opts...)
+			if err != nil {
+				t.Fatalf("Walk yielded unexpected error %s", err)
+			}
+			var gotNodes []string
+			var visit func(stn *SubtreeNode)
+			visit = func(stn *SubtreeNode) {
+				node := pathAsString(stn.Path)
+				if stn.Pruned != nil {
+					node += fmt.Sprintf(" (pruned %d)", stn.Pruned.Nodes)
+				}
+				gotNodes = append(gotNodes, fmt.Sprintf("%s=%g", node, stn.Weight))
+				for _, child := range stn.Children {
+					visit(child)
+				}
+			}
+			visit(gotSubtree)
+			if diff := cmp.Diff(test.wantNodes, gotNodes); diff != "" {
+				t.Errorf("got unexpected nodes: diff (-want +got) %s", diff)
+			}
+		})
+	}
+}
+
+func TestDeterministicOrder(t *testing.T) {
+	// Every node has the same self weight, so siblings often tie.
+	var ops []func(ttn *testTreeNode)
+	for scopeID := ScopeID(8); scopeID > 0; scopeID-- {
+		ops = append(ops, node(scopeID, val(eventsKey, 1),
+			node(3, val(eventsKey, 1)),
+			node(2, val(eventsKey, 1)),
+			node(1, val(eventsKey, 1)),
+		))
+	}
+	root := tree(ops...)
+	weighEvents := func(c Comparable) (float64, error) {
+		var ret int64
+		for _, tn := range c.TreeNodes {
+			ret += tn.(*testTreeNode).totalVals[eventsKey]
+		}
+		return float64(ret), nil
+	}
+	// compareWeights doesn't break ties.
+	compareWeights := func(a, b Comparable) (int, error) {
+		switch {
+		case a.Weight < b.Weight:
+			return -1, nil
+		case a.Weight > b.Weight:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	for _, test := range []struct {
+		description string
+		opts        []WalkOption
+		want        []string
+	}{{
+		description: "ties broken by path",
+		opts:        []WalkOption{WithWeight(weighEvents), MaxNodes(7)},
+		want:        []string{"/", "/1", "/2", "/3", "/4", "/5", "/6"},
+	}, {
+		description: "merged TreeNodes ordered by path",
+		opts:        []WalkOption{WithWeight(weighEvents), MergePrefix(5, 3), MergePrefix(2, 3)},
+		want:        []string{"/[/2/3,/5/3]", "/3[/2/3,/5/3]"},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			var first []string
+			for i := 0; i < 20; i++ {
+				gotSubtree, err := Walk(root, compareWeights, append(test.opts, DeterministicOrder())...)
+				if err != nil {
+					t.Fatalf("Walk yielded unexpected error %v", err)
+				}
+				var got []string
+				var visit func(stn *SubtreeNode)
+				visit = func(stn *SubtreeNode) {
+					entry := pathAsString(stn.Path)
+					if len(stn.TreeNodes) > 1 {
+						var tnPaths []string
+						for _, tn := range stn.TreeNodes {
+							tnPaths = append(tnPaths, pathAsString(tn.Path()))
+						}
+						entry += "[" + strings.Join(tnPaths, ",") + "]"
+					}
+					got = append(got, entry)
+					for _, child := range stn.Children {
+						visit(child)
+					}
+				}
+				visit(gotSubtree)
+				if i == 0 {
+					first = got
+					if diff := cmp.Diff(test.want, got); diff != "" {
+						t.Fatalf("Walk yielded unexpected nodes: diff (-want +got) %s", diff)
+					}
+				} else if diff := cmp.Diff(first, got); diff != "" {
+					t.Fatalf("Walk %d differed from the first: diff (-first +got) %s", i, diff)
+				}
+			}
+		})
+	}
+}
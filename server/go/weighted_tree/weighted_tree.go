@@ -60,15 +60,19 @@
 package weightedtree
 
 import (
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/magnitude"
 	"github.com/google/traceviz/server/go/util"
 )
 
 const (
-	frameHeightPxKey = "weighted_tree_frame_height_px"
+	frameHeightPxKey = keys.WeightedTreeFrameHeightPx
 	// The tree's direction, top-down or bottom-up.  If unspecified, it is
 	// top-down.
-	directionKey = "weighted_tree_direction"
+	directionKey = keys.WeightedTreeDirection
+	// The token from which a paginated tree's next page may be requested.  If
+	// unspecified, there are no further pages.
+	nextPageTokenKey = keys.WeightedTreeNextPageToken
 )
 
 const (
@@ -119,6 +123,15 @@ func (t *Tree) BottomUp() *Tree {
 	)
 }
 
+// NextPage marks the receiver as one page of a paginated tree, with the next
+// page requested via the provided token (e.g., as returned from WalkPage).  If
+// the token is empty, the receiver is the last page.
+func (t *Tree) NextPage(pageToken string) *Tree {
+	return t.With(
+		util.If(pageToken != "", util.StringProperty(nextPageTokenKey, pageToken)),
+	)
+}
+
 // Node creates and returns a new root node with the specified magnitude in the
 // tree.
 func (t *Tree) Node(selfMagnitude float64, properties ...util.PropertyUpdate) *Node {
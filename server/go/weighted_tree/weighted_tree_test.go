@@ -84,6 +84,31 @@ func TestTreeConstruction(t *testing.T) {
 				name("y"),
 			)
 		},
+	}, {
+		description: "paginated tree",
+		buildTree: func(db util.DataBuilder) {
+			tree := New(db, defaultRenderSettings).NextPage("/1/2")
+			tree.Node(1, name("root"))
+		},
+		buildExplicit: func(db testutil.TestDataBuilder) {
+			db.With(
+				util.IntegerProperty(frameHeightPxKey, 20),
+				util.StringProperty(nextPageTokenKey, "/1/2"),
+			).Child().With(
+				magnitude.SelfMagnitude(1),
+				name("root"),
+			)
+		},
+	}, {
+		description: "last page",
+		buildTree: func(db util.DataBuilder) {
+			New(db, defaultRenderSettings).NextPage("")
+		},
+		buildExplicit: func(db testutil.TestDataBuilder) {
+			db.With(
+				util.IntegerProperty(frameHeightPxKey, 20),
+			)
+		},
 	}} {
 		t.Run(test.description, func(t *testing.T) {
 			err := testutil.CompareResponses(t, test.buildTree, test.buildExplicit)
@@ -92,4 +117,204 @@ func TestTreeConstruction(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestBuildResponse(t *testing.T) {
+	// total events=10
+	tr := tree(
+		node(1, events(2), // total events=7
+			node(1, events(4)), // total events=4
+			node(2, events(1)), // total events=1
+		),
+		node(2, events(3)), // total events=3
+	)
+	weighEvents := func(c Comparable) (float64, error) {
+		var ret int64
+		for _, tn := range c.TreeNodes {
+			ret += tn.(*testTreeNode).totalVals[eventsKey]
+		}
+		return float64(ret), nil
+	}
+	stats := func(total, percent float64) util.PropertyUpdate {
+		return util.Chain(TotalMagnitude(total), PercentOfRoot(percent))
+	}
+	err := testutil.CompareResponses(t,
+		func(db util.DataBuilder) {
+			// Node /1/2 is not visited, so /1 absorbs its weight.
+			subtree, err := Walk(tr, CompareByWeight, WithWeight(weighEvents), MaxNodes(4))
+			if err != nil {
+				t.Fatalf("Walk yielded unexpected error %s", err)
+			}
+			if err := subtree.BuildResponse(New(db, defaultRenderSettings), func(stn *SubtreeNode) ([]util.PropertyUpdate, error) {
+				return []util.PropertyUpdate{name(pathAsString(stn.Path))}, nil
+			}); err != nil {
+				t.Fatalf("BuildResponse yielded unexpected error %s", err)
+			}
+		},
+		func(db testutil.TestDataBuilder) {
+			db.With(
+				util.IntegerProperty(frameHeightPxKey, 20),
+			).Child().With(
+				magnitude.SelfMagnitude(3),
+				stats(7, 70),
+				name("/1"),
+			).Child().With(
+				magnitude.SelfMagnitude(4),
+				stats(4, 40),
+				name("/1/1"),
+			).Parent().AndChild().With(
+				magnitude.SelfMagnitude(3),
+				stats(3, 30),
+				name("/2"),
+			)
+		})
+	if err != nil {
+		t.Fatalf("encountered unexpected error building the tree: %s", err)
+	}
+}
+
+func TestBuildPrunedResponse(t *testing.T) {
+	tr := tree(
+		node(1, events(2),
+			node(1, events(4)),
+			node(2, events(1)),
+		),
+		node(2, events(3)),
+	)
+	weighEvents := func(c Comparable) (float64, error) {
+		var ret int64
+		for _, tn := range c.TreeNodes {
+			ret += tn.(*testTreeNode).totalVals[eventsKey]
+		}
+		return float64(ret), nil
+	}
+	stats := func(total, percent float64) util.PropertyUpdate {
+		return util.Chain(TotalMagnitude(total), PercentOfRoot(percent))
+	}
+	err := testutil.CompareResponses(t,
+		func(db util.DataBuilder) {
+			subtree, err := Walk(tr, CompareByWeight, WithWeight(weighEvents), MaxNodes(2), SummarizePruned())
+			if err != nil {
+				t.Fatalf("Walk yielded unexpected error %s", err)
+			}
+			if err := subtree.BuildResponse(New(db, defaultRenderSettings), func(stn *SubtreeNode) ([]util.PropertyUpdate, error) {
+				return []util.PropertyUpdate{name(pathAsString(stn.Path))}, nil
+			}); err != nil {
+				t.Fatalf("BuildResponse yielded unexpected error %s", err)
+			}
+		},
+		func(db testutil.TestDataBuilder) {
+			db.With(
+				util.IntegerProperty(frameHeightPxKey, 20),
+			).Child().With(
+				magnitude.SelfMagnitude(2),
+				stats(7, 70),
+				name("/1"),
+			).Child().With(
+				// /1/1 and /1/2 are pruned.
+				magnitude.SelfMagnitude(5),
+				stats(5, 50),
+				PrunedNodes(2),
+			).Parent().AndChild().With(
+				// /2 is pruned.
+				magnitude.SelfMagnitude(3),
+				stats(3, 30),
+				PrunedNodes(1),
+			)
+		})
+	if err != nil {
+		t.Fatalf("encountered unexpected error building the tree: %s", err)
+	}
+}
+
+func TestBuildMergedResponse(t *testing.T) {
+	tr := tree(
+		node(1,
+			node(3, events(2)),
+		),
+		node(2,
+			node(3, events(5)),
+		),
+	)
+	weighEvents := func(c Comparable) (float64, error) {
+		var ret int64
+		for _, tn := range c.TreeNodes {
+			ret += tn.(*testTreeNode).totalVals[eventsKey]
+		}
+		return float64(ret), nil
+	}
+	err := testutil.CompareResponses(t,
+		func(db util.DataBuilder) {
+			subtree, err := Walk(tr, CompareByWeight, WithWeight(weighEvents), MergePrefix(1, 3), MergePrefix(2, 3))
+			if err != nil {
+				t.Fatalf("Walk yielded unexpected error %s", err)
+			}
+			if err := subtree.BuildResponse(New(db, defaultRenderSettings), nil); err != nil {
+				t.Fatalf("BuildResponse yielded unexpected error %s", err)
+			}
+		},
+		func(db testutil.TestDataBuilder) {
+			db.With(
+				util.IntegerProperty(frameHeightPxKey, 20),
+			).Child().With(
+				magnitude.SelfMagnitude(7),
+				TotalMagnitude(7),
+				PercentOfRoot(100),
+				util.DoubleProperty("weighted_tree_origin_weight:/1/3", 2),
+				util.DoubleProperty("weighted_tree_origin_weight:/2/3", 5),
+				util.StringsProperty("weighted_tree_origins", "/1/3", "/2/3"),
+			)
+		})
+	if err != nil {
+		t.Fatalf("encountered unexpected error building the tree: %s", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		bottomUp    bool
+		wantYs      []float64
+	}{{
+		description: "top-down",
+		wantYs:      []float64{0, 20, 20, 0},
+	}, {
+		description: "bottom-up",
+		bottomUp:    true,
+		wantYs:      []float64{20, 0, 0, 20},
+	}} {
+		t.Run(test.description, func(t *testing.T) {
+			drb := util.NewDataResponseBuilder()
+			tree := New(drb.DataSeries(&util.DataSeriesRequest{SeriesName: "tree"}), defaultRenderSettings)
+			if test.bottomUp {
+				tree.BottomUp()
+			}
+			a := tree.Node(1, name("a"))
+			a.Node(2, name("b"))
+			a.Node(1, name("c")).Payload().With(name("payload"))
+			tree.Node(4, name("d"))
+			data, err := drb.Data()
+			if err != nil {
+				t.Fatalf("failed to build tree: %s", err)
+			}
+			scene, err := Render(data.DataSeries[0].Root, data.StringTable, 80, "name")
+			if err != nil {
+				t.Fatalf("Render() yielded unexpected error %s", err)
+			}
+			wantLabels := []string{"a", "b", "c", "d"}
+			wantXs := []float64{0, 0, 20, 40}
+			wantWidths := []float64{40, 20, 10, 40}
+			if len(scene.Rects) != len(wantLabels) {
+				t.Fatalf("Render() yielded %d rects, want %d", len(scene.Rects), len(wantLabels))
+			}
+			for idx, r := range scene.Rects {
+				if r.Label != wantLabels[idx] || r.X != wantXs[idx] || r.Y != test.wantYs[idx] || r.Width != wantWidths[idx] || r.Height != 20 {
+					t.Errorf("Render() rect %d = %v, want '%s' at (%g, %g), width %g", idx, *r, wantLabels[idx], wantXs[idx], test.wantYs[idx], wantWidths[idx])
+				}
+			}
+			if scene.Height != 40 {
+				t.Errorf("Render() scene height = %g, want 40", scene.Height)
+			}
+		})
+	}
+}
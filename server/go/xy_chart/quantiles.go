@@ -0,0 +1,123 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xychart
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/quantile"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// Quantiles aggregates a stream of (x, y) samples into equal-width bins
+// across an x axis, and emits selected quantiles of y within each bin as
+// series, such as p50 and p99 latency over time:
+//
+//	q := NewQuantiles(xAxis, binCount, .5, .99)
+//	for _, sample := range samples {
+//		q.Add(sample.time, sample.latency)
+//	}
+//	min, max := q.Extent()
+//	chart := New(db, xAxis, continuousaxis.NewDoubleAxis(yCat, min, max))
+//	q.Emit(chart)
+//
+// Each bin's samples are summarized in a quantile.Sketch, so memory use is
+// bounded regardless of the number of samples.
+type Quantiles[X int64 | float64 | time.Duration | time.Time] struct {
+	xAxis     *continuousaxis.Axis[X]
+	quantiles []float64
+	// Nil for bins with no samples.
+	bins []*quantile.Sketch
+}
+
+// NewQuantiles returns a new Quantiles dividing the provided x axis into the
+// specified number of bins, and computing the specified quantiles, each
+// between 0 and 1.
+func NewQuantiles[X int64 | float64 | time.Duration | time.Time](xAxis *continuousaxis.Axis[X], binCount int, quantiles ...float64) *Quantiles[X] {
+	if binCount < 1 {
+		binCount = 1
+	}
+	return &Quantiles[X]{
+		xAxis:     xAxis,
+		quantiles: quantiles,
+		bins:      make([]*quantile.Sketch, binCount),
+	}
+}
+
+// Add adds a sample to the receiver.  Samples outside the x axis are dropped.
+func (q *Quantiles[X]) Add(x X, y float64) {
+	f := q.xAxis.Fraction(x)
+	if f < 0 || f > 1 {
+		return
+	}
+	bin := int(f * float64(len(q.bins)))
+	if bin == len(q.bins) {
+		bin--
+	}
+	if q.bins[bin] == nil {
+		q.bins[bin] = quantile.New(quantile.DefaultCompression)
+	}
+	q.bins[bin].Add(y)
+}
+
+// Extent returns the minimum and maximum of all quantile values the receiver
+// will emit, for use in defining a y axis.  Returns zeros if there are no
+// samples.
+func (q *Quantiles[X]) Extent() (min, max float64) {
+	min, max = math.Inf(1), math.Inf(-1)
+	for _, sketch := range q.bins {
+		if sketch == nil {
+			continue
+		}
+		for _, qu := range q.quantiles {
+			v := sketch.Quantile(qu)
+			min = math.Min(min, v)
+			max = math.Max(max, v)
+		}
+	}
+	if min > max {
+		return 0, 0
+	}
+	return min, max
+}
+
+// QuantileCategory returns the Category of the series of the specified
+// quantile, e.g. 'p99' for .99.
+func QuantileCategory(qu float64) *category.Category {
+	pct := strconv.FormatFloat(qu*100, 'f', -1, 64)
+	return category.New("p"+pct, "p"+pct, pct+"th percentile")
+}
+
+// Emit adds a series for each of the receiver's quantiles, tagged with its
+// QuantileCategory and the provided properties, into the provided XYChart,
+// and returns those series.  Each series has a point at the start of each bin
+// holding any samples.
+func (q *Quantiles[X]) Emit(chart *XYChart[X, float64], properties ...util.PropertyUpdate) []*Series[X, float64] {
+	ret := make([]*Series[X, float64], len(q.quantiles))
+	for idx, qu := range q.quantiles {
+		series := chart.AddSeries(QuantileCategory(qu), properties...)
+		for bin, sketch := range q.bins {
+			if sketch == nil {
+				continue
+			}
+			series.WithPoint(q.xAxis.At(float64(bin)/float64(len(q.bins))), sketch.Quantile(qu))
+		}
+		ret[idx] = series
+	}
+	return ret
+}
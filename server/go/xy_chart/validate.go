@@ -0,0 +1,159 @@
+/*
+	Copyright 2023 Google Inc.
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+		https://www.apache.org/licenses/LICENSE-2.0
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xychart
+
+import (
+	"fmt"
+
+	"github.com/google/traceviz/server/go/category"
+	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/util"
+)
+
+// definedAxis is an axis defined in an xy chart response.
+type definedAxis struct {
+	name string
+	id   string
+	pos  *continuousaxis.Positioner
+}
+
+// definedAxes returns the x and y axes defined in the provided xy chart axes
+// Datum, or an error if they are not both defined.
+func definedAxes(axes *util.Datum, st []string) ([]*definedAxis, error) {
+	if len(axes.Children) != 2 {
+		return nil, fmt.Errorf("xy chart has %d axes, but expected 2", len(axes.Children))
+	}
+	ret := make([]*definedAxis, 2)
+	for idx, name := range []string{"x", "y"} {
+		axis := axes.Children[idx]
+		pos, ok := continuousaxis.DefinedPositioner(axis, st)
+		if !ok {
+			return nil, fmt.Errorf("xy chart %s axis has no axis definition", name)
+		}
+		cat, ok := category.Defined(axis, st)
+		if !ok {
+			return nil, fmt.Errorf("xy chart %s axis has no category definition", name)
+		}
+		ret[idx] = &definedAxis{
+			name: name,
+			id:   cat.ID(),
+			pos:  pos,
+		}
+	}
+	return ret, nil
+}
+
+// Detect reports whether the provided Datum, whose string indices refer to the
+// provided string table, appears to be the root of an xy chart: that is,
+// whether its first child defines x and y axes.
+func Detect(root *util.Datum, st []string) bool {
+	if len(root.Children) == 0 {
+		return false
+	}
+	_, err := definedAxes(root.Children[0], st)
+	return err == nil
+}
+
+// Validate checks that the xy chart rooted at the provided Datum, whose string
+// indices refer to the provided string table, conforms to the xy chart data
+// model: that its x and y axes are defined, that each series is defined, that
+// each point, and each point in each point run, has x and y values lying
+// within the axes, and that each gap has start and end values lying within
+// the x axis.
+func Validate(root *util.Datum, st []string) error {
+	if len(root.Children) == 0 {
+		return fmt.Errorf("xy chart has no axis definitions")
+	}
+	axes, err := definedAxes(root.Children[0], st)
+	if err != nil {
+		return err
+	}
+	for seriesIdx, series := range root.Children[1:] {
+		cat, ok := category.Defined(series, st)
+		if !ok {
+			return fmt.Errorf("xy chart series %d has no category definition", seriesIdx)
+		}
+		for pointIdx, point := range series.Children {
+			if _, ok := point.Property(st, gapStartKey); ok {
+				if err := validateGap(point, st, axes[0], cat.ID(), pointIdx); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, ok := point.Property(st, pointsXKey); ok {
+				if err := validatePointRun(point, st, axes, cat.ID(), pointIdx); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, axis := range axes {
+				f, ok := axis.pos.Value(point, st, axis.id)
+				if !ok {
+					return fmt.Errorf("xy chart series '%s' point %d has missing or malformed %s value", cat.ID(), pointIdx, axis.name)
+				}
+				if f < 0 || f > 1 {
+					return fmt.Errorf("xy chart series '%s' point %d has %s value outside its axis", cat.ID(), pointIdx, axis.name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validateGap checks that the provided gap Datum has start and end values
+// lying, in order, within the provided x axis.
+func validateGap(gap *util.Datum, st []string, xAxis *definedAxis, seriesID string, idx int) error {
+	start, ok := xAxis.pos.Value(gap, st, gapStartKey)
+	if !ok {
+		return fmt.Errorf("xy chart series '%s' gap %d has malformed start value", seriesID, idx)
+	}
+	end, ok := xAxis.pos.Value(gap, st, gapEndKey)
+	if !ok {
+		return fmt.Errorf("xy chart series '%s' gap %d has missing or malformed end value", seriesID, idx)
+	}
+	if start < 0 || end > 1 || start > end {
+		return fmt.Errorf("xy chart series '%s' gap %d has extent outside its x axis or reversed", seriesID, idx)
+	}
+	return nil
+}
+
+// validatePointRun checks that the provided point run Datum has x and y
+// columns of equal length, with values lying within the provided axes.
+func validatePointRun(run *util.Datum, st []string, axes []*definedAxis, seriesID string, idx int) error {
+	var lens []int
+	for _, axis := range axes {
+		key := pointsXKey
+		if axis.name == "y" {
+			key = pointsYKey
+		}
+		col, ok := run.Property(st, key)
+		if !ok {
+			return fmt.Errorf("xy chart series '%s' point run %d has no %s values", seriesID, idx, axis.name)
+		}
+		fs, ok := axis.pos.ColumnFractions(col)
+		if !ok {
+			return fmt.Errorf("xy chart series '%s' point run %d has malformed %s values", seriesID, idx, axis.name)
+		}
+		for _, f := range fs {
+			if f < 0 || f > 1 {
+				return fmt.Errorf("xy chart series '%s' point run %d has %s value outside its axis", seriesID, idx, axis.name)
+			}
+		}
+		lens = append(lens, len(fs))
+	}
+	if lens[0] != lens[1] {
+		return fmt.Errorf("xy chart series '%s' point run %d has %d x values but %d y values", seriesID, idx, lens[0], lens[1])
+	}
+	return nil
+}
@@ -35,6 +35,17 @@
 //
 //	series.WithPoint(x, y, properties...)
 //
+// Dense runs of points may instead be added from parallel slices of x and y
+// values, which are encoded compactly as a single columnar datum rather than
+// as a datum per point, via
+//
+//	series.WithPoints(xs, ys, sharedProperties...)
+//
+// Long runs over which a series is zero may be emitted as a single gap,
+// rather than as a point per x value, via
+//
+//	series.WithGap(fromX, toX, properties...)
+//
 // Note that providing x and y values incompatible with the corresponding axis
 // type will yield an error when the response is built.
 //
@@ -62,25 +73,58 @@
 //	    * category definition
 //	    * <decorators>
 //	  children:
-//	    repeated points
+//	    repeated points, point runs, and gaps, in increasing x order
 //
 //	point
 //	  properties:
 //	    * xAxisName: Value (depending on x-axis type)
 //	    * yAxisName: Value (depending on y-axis type)
 //	    * <decorators>
+//
+//	point run
+//	  properties:
+//	    * pointsXKey: column of x values (depending on x-axis type)
+//	    * pointsYKey: column of y values (depending on y-axis type)
+//	    * <decorators, shared by all points in the run>
+//
+//	gap
+//	  properties:
+//	    * gapStartKey: Value (depending on x-axis type)
+//	    * gapEndKey: Value (depending on x-axis type)
+//	    * <decorators>
+//
+// A point run holds the points (x[i], y[i]) for each i, in increasing x
+// order; its columns have the same length.  Columns on integer and double
+// axes are Integers and Doubles respectively; on duration axes, Integers of
+// nanoseconds; and on timestamp axes, Integers of nanoseconds since the axis
+// minimum.  See continuousaxis.Axis.Column.
+//
+// A gap denotes that its series is zero at every x value within its inclusive
+// extent, and so has no explicit points there.
 package xychart
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/traceviz/server/go/category"
 	continuousaxis "github.com/google/traceviz/server/go/continuous_axis"
+	"github.com/google/traceviz/server/go/keys"
 	"github.com/google/traceviz/server/go/util"
 )
 
+const (
+	// The inclusive x extent of a gap, in x-axis units.
+	gapStartKey = keys.XYChartGapStart
+	gapEndKey   = keys.XYChartGapEnd
+
+	// The columns of a point run.
+	pointsXKey = keys.XYChartPointsX
+	pointsYKey = keys.XYChartPointsY
+)
+
 // XYChart represents an xy-chart embedded in a TraceViz response.
-type XYChart[X float64 | time.Duration | time.Time, Y float64 | time.Duration | time.Time] struct {
+type XYChart[X int64 | float64 | time.Duration | time.Time, Y int64 | float64 | time.Duration | time.Time] struct {
 	xAxis *continuousaxis.Axis[X]
 	yAxis *continuousaxis.Axis[Y]
 	db    util.DataBuilder
@@ -88,7 +132,7 @@ type XYChart[X float64 | time.Duration | time.Time, Y float64 | time.Duration |
 
 // New constructs a new xy chart.  The returned close function should be
 // invoked when no more data may be added to the chart.
-func New[X float64 | time.Duration | time.Time, Y float64 | time.Duration | time.Time](db util.DataBuilder, xAxis *continuousaxis.Axis[X], yAxis *continuousaxis.Axis[Y], properties ...util.PropertyUpdate) *XYChart[X, Y] {
+func New[X int64 | float64 | time.Duration | time.Time, Y int64 | float64 | time.Duration | time.Time](db util.DataBuilder, xAxis *continuousaxis.Axis[X], yAxis *continuousaxis.Axis[Y], properties ...util.PropertyUpdate) *XYChart[X, Y] {
 	ret := &XYChart[X, Y]{
 		xAxis: xAxis,
 		yAxis: yAxis,
@@ -120,7 +164,7 @@ func (xyc *XYChart[X, Y]) AddSeries(category *category.Category, properties ...u
 }
 
 // Series helps define a series within a XYChart.
-type Series[X float64 | time.Duration | time.Time, Y float64 | time.Duration | time.Time] struct {
+type Series[X int64 | float64 | time.Duration | time.Time, Y int64 | float64 | time.Duration | time.Time] struct {
 	xyc *XYChart[X, Y]
 	db  util.DataBuilder
 }
@@ -140,3 +184,33 @@ func (s *Series[X, Y]) WithPoint(x X, y Y, properties ...util.PropertyUpdate) *S
 	).With(properties...)
 	return s
 }
+
+// WithPoints adds a run of data points to the receiving Series, with the x
+// and y values at corresponding indices of the provided slices, which must
+// have the same length, and with the provided properties shared by all
+// points in the run.  The run is encoded as a single datum with a column of x
+// values and a column of y values, which is far smaller, and far faster to
+// build, than a datum per point.  Points should be added in increasing x
+// order.
+func (s *Series[X, Y]) WithPoints(xs []X, ys []Y, sharedProperties ...util.PropertyUpdate) *Series[X, Y] {
+	if len(xs) != len(ys) {
+		s.db.With(util.ErrorProperty(fmt.Errorf("xy chart point run has %d x values but %d y values", len(xs), len(ys))))
+		return s
+	}
+	s.db.Child().With(
+		s.xyc.xAxis.Column(pointsXKey, xs),
+		s.xyc.yAxis.Column(pointsYKey, ys),
+	).With(sharedProperties...)
+	return s
+}
+
+// WithGap adds a gap to the receiving Series, denoting that the series is
+// zero at every x value from fromX to toX inclusive, so has no explicit
+// points there.  Gaps and points should be added in increasing x order.
+func (s *Series[X, Y]) WithGap(fromX, toX X, properties ...util.PropertyUpdate) *Series[X, Y] {
+	s.db.Child().With(
+		s.xyc.xAxis.Value(gapStartKey, fromX),
+		s.xyc.xAxis.Value(gapEndKey, toX),
+	).With(properties...)
+	return s
+}
@@ -123,6 +123,79 @@ func TestXYChart(t *testing.T) {
 			)
 
 		},
+	}, {
+		description: "series with gap",
+		buildChart: func(db util.DataBuilder) {
+			New(db,
+				continuousaxis.NewTimestampAxis(xAxisCat, ts(0), ts(100*time.Second)),
+				continuousaxis.NewDoubleAxis(yAxisCat, 0, 3),
+			).AddSeries(thingsCat).WithPoint(
+				ts(0), 3,
+			).WithGap(
+				ts(10*time.Second), ts(90*time.Second), util.StringProperty("story", "Nothing happened"),
+			).WithPoint(
+				ts(100*time.Second), 1,
+			)
+		},
+		buildExplicit: func(db testutil.TestDataBuilder) {
+			x := continuousaxis.NewTimestampAxis(xAxisCat, ts(0), ts(100*time.Second))
+			y := continuousaxis.NewDoubleAxis(yAxisCat, 0, 3)
+			db.Child().
+				Child().With(x.Define()).
+				AndChild().With(y.Define())
+			db.Child().With(
+				thingsCat.Define(),
+			).Child().With(
+				util.TimestampProperty(xAxisName, ts(0)),
+				util.DoubleProperty(yAxisName, 3),
+			).AndChild().With(
+				util.TimestampProperty(gapStartKey, ts(10*time.Second)),
+				util.TimestampProperty(gapEndKey, ts(90*time.Second)),
+				util.StringProperty("story", "Nothing happened"),
+			).AndChild().With(
+				util.TimestampProperty(xAxisName, ts(100*time.Second)),
+				util.DoubleProperty(yAxisName, 1),
+			)
+		},
+	}, {
+		description: "series with point run",
+		buildChart: func(db util.DataBuilder) {
+			New(db,
+				continuousaxis.NewTimestampAxis(xAxisCat, ts(0), ts(100*time.Second)),
+				continuousaxis.NewDoubleAxis(yAxisCat, 0, 3),
+			).AddSeries(thingsCat).WithPoints(
+				[]time.Time{ts(0), ts(10 * time.Second), ts(20 * time.Second)},
+				[]float64{3, 1.5, 2},
+				util.StringProperty("story", "Sampled"),
+			)
+		},
+		buildExplicit: func(db testutil.TestDataBuilder) {
+			x := continuousaxis.NewTimestampAxis(xAxisCat, ts(0), ts(100*time.Second))
+			y := continuousaxis.NewDoubleAxis(yAxisCat, 0, 3)
+			db.Child().
+				Child().With(x.Define()).
+				AndChild().With(y.Define())
+			db.Child().With(
+				thingsCat.Define(),
+			).Child().With(
+				util.IntegersProperty(pointsXKey, 0, int64(10*time.Second), int64(20*time.Second)),
+				util.DoublesProperty(pointsYKey, 3, 1.5, 2),
+				util.StringProperty("story", "Sampled"),
+			)
+		},
+	}, {
+		description: "point run with mismatched columns",
+		buildChart: func(db util.DataBuilder) {
+			New(db,
+				continuousaxis.NewDurationAxis(xAxisCat, 0, 100*time.Second),
+				continuousaxis.NewIntegerAxis(yAxisCat, 0, 3),
+			).AddSeries(thingsCat).WithPoints(
+				[]time.Duration{0, 10 * time.Second},
+				[]int64{3},
+			)
+		},
+		buildExplicit: func(db testutil.TestDataBuilder) {},
+		wantErr:       true,
 	}} {
 		t.Run(test.description, func(t *testing.T) {
 			err := testutil.CompareResponses(t, test.buildChart, test.buildExplicit)
@@ -132,3 +205,50 @@ func TestXYChart(t *testing.T) {
 		})
 	}
 }
+
+func TestQuantiles(t *testing.T) {
+	xAxis := continuousaxis.NewDurationAxis(category.New("x_axis", "Time", "Time"), 0, 100*time.Second)
+	q := NewQuantiles(xAxis, 4, .5, .99)
+	// The first bin holds latencies 1..100; the second is empty; the third
+	// holds a single latency; samples outside the axis are dropped.
+	for latency := 1; latency <= 100; latency++ {
+		q.Add(time.Duration(latency)*100*time.Millisecond, float64(latency))
+	}
+	q.Add(60*time.Second, 1000)
+	q.Add(200*time.Second, 5000)
+	min, max := q.Extent()
+	if min != 50.5 || max != 1000 {
+		t.Fatalf("Extent() = %g, %g, want 50.5, 1000", min, max)
+	}
+	yAxis := continuousaxis.NewDoubleAxis(category.New("y_axis", "Latency", "Latency"), min, max)
+	err := testutil.CompareResponses(t,
+		func(db util.DataBuilder) {
+			q.Emit(New(db, xAxis, yAxis))
+		},
+		func(db testutil.TestDataBuilder) {
+			db.Child().
+				Child().With(xAxis.Define()).
+				AndChild().With(yAxis.Define())
+			db.Child().With(
+				category.New("p50", "p50", "50th percentile").Define(),
+			).Child().With(
+				util.DurationProperty("x_axis", 0),
+				util.DoubleProperty("y_axis", 50.5),
+			).AndChild().With(
+				util.DurationProperty("x_axis", 50*time.Second),
+				util.DoubleProperty("y_axis", 1000),
+			)
+			db.Child().With(
+				category.New("p99", "p99", "99th percentile").Define(),
+			).Child().With(
+				util.DurationProperty("x_axis", 0),
+				util.DoubleProperty("y_axis", 99.5),
+			).AndChild().With(
+				util.DurationProperty("x_axis", 50*time.Second),
+				util.DoubleProperty("y_axis", 1000),
+			)
+		})
+	if err != nil {
+		t.Fatalf("encountered unexpected error building the chart: %s", err)
+	}
+}